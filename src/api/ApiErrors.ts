@@ -1,20 +1,153 @@
 import { HttpApiSchema } from "@effect/platform"
 import * as Schema from "effect/Schema"
+import { type AccessCode, ConflictCode, type ErrorCode, NotFoundCode, ServiceCode } from "../schemas/ErrorSchema"
 
 export class ApiNotFoundError extends Schema.TaggedError<ApiNotFoundError>()(
   "ApiNotFoundError",
-  { message: Schema.String, resourceType: Schema.String, resourceId: Schema.String },
+  {
+    code: NotFoundCode,
+    message: Schema.String,
+    resourceType: Schema.String,
+    resourceId: Schema.String,
+    hint: Schema.String
+  },
   HttpApiSchema.annotations({ status: 404 })
 ) {}
 
 export class ApiConflictError extends Schema.TaggedError<ApiConflictError>()(
   "ApiConflictError",
-  { message: Schema.String },
+  { code: ConflictCode, message: Schema.String, hint: Schema.String },
   HttpApiSchema.annotations({ status: 409 })
 ) {}
 
 export class ApiServiceError extends Schema.TaggedError<ApiServiceError>()(
   "ApiServiceError",
-  { message: Schema.String },
+  { code: ServiceCode, message: Schema.String, hint: Schema.String },
   HttpApiSchema.annotations({ status: 503 })
 ) {}
+
+interface CatalogueEntry {
+  readonly status: number
+  readonly description: string
+  readonly hint: string
+}
+
+// Every code the admin API fails with, in the order GET /errors lists them
+export const ERROR_CATALOGUE: { readonly [C in ErrorCode]: CatalogueEntry } = {
+  imposter_not_found: {
+    status: 404,
+    description: "Imposter not found",
+    hint: "List imposters with GET /imposters; an imposter gets a new ID when it is recreated"
+  },
+  stub_not_found: {
+    status: 404,
+    description: "Stub not found",
+    hint: "List the imposter's stubs with GET /imposters/:id/stubs"
+  },
+  request_not_found: {
+    status: 404,
+    description: "Request not found",
+    hint: "The journal is bounded and cleared by resets; list what it holds with GET /imposters/:id/requests"
+  },
+  callback_not_found: {
+    status: 404,
+    description: "Failed callback not found",
+    hint: "List failed callbacks with GET /callbacks/failed; retried and cleared ones are gone"
+  },
+  profile_not_found: {
+    status: 404,
+    description: "Profile not found",
+    hint: "List profiles with GET /profiles, or PUT the missing one first"
+  },
+  scenario_not_found: {
+    status: 404,
+    description: "No stub of the imposter names the scenario",
+    hint: "List the imposter's scenarios with GET /imposters/:id/scenarios"
+  },
+  port_unavailable: {
+    status: 409,
+    description: "The port is taken or outside the port range",
+    hint: "Pick another port, or leave it out to get a free one from PORT_RANGE_MIN-PORT_RANGE_MAX"
+  },
+  imposter_running: {
+    status: 409,
+    description: "The imposter is still running",
+    hint: "Stop it first with PATCH {\"status\": \"stopped\"}, or pass force=true"
+  },
+  ids_exhausted: {
+    status: 409,
+    description: "No unused imposter or stub ID could be drawn",
+    hint: "Raise ID_LENGTH or widen ID_ALPHABET"
+  },
+  request_matched: {
+    status: 409,
+    description: "The request was matched by a stub",
+    hint: "Only unmatched requests can be promoted; edit the stub that matched instead"
+  },
+  profile_cycle: {
+    status: 409,
+    description: "Profiles extend each other in a loop",
+    hint: "Point `extends` at a profile that doesn't lead back to this one"
+  },
+  profile_in_use: {
+    status: 409,
+    description: "Other profiles extend this one",
+    hint: "Remove the profiles extending it, or point them elsewhere, first"
+  },
+  admin_unprotected: {
+    status: 409,
+    description: "Share links need the admin API to be protected",
+    hint: "Start the admin server with ADMIN_TOKEN set; without it anyone can already read and change everything"
+  },
+  imposter_limit: {
+    status: 503,
+    description: "The maximum number of imposters is reached",
+    hint: "Delete imposters you no longer need, or raise MAX_IMPOSTERS"
+  },
+  ports_exhausted: {
+    status: 503,
+    description: "Every port in the range is taken",
+    hint: "Delete imposters to free ports, or widen PORT_RANGE_MIN-PORT_RANGE_MAX"
+  },
+  server_start_failed: {
+    status: 503,
+    description: "The imposter's server could not start",
+    hint: "Usually another process holds the port; free it or move the imposter with PATCH {\"port\": ...}"
+  },
+  unauthorized: {
+    status: 401,
+    description: "The request carries no valid admin token or share",
+    hint: "Send ADMIN_TOKEN as a bearer token; shares expire, so ask for a new link"
+  },
+  read_only: {
+    status: 403,
+    description: "A share only reads",
+    hint: "Shares allow GET requests alone; use the admin token to make changes"
+  }
+}
+
+// `resourceType` is the code's first word: "imposter" for imposter_not_found
+export const notFoundError = (code: NotFoundCode, resourceId: string) =>
+  new ApiNotFoundError({
+    code,
+    message: ERROR_CATALOGUE[code].description,
+    resourceType: code.slice(0, code.indexOf("_")),
+    resourceId,
+    hint: ERROR_CATALOGUE[code].hint
+  })
+
+export const conflictError = (code: ConflictCode, message: string) =>
+  new ApiConflictError({ code, message, hint: ERROR_CATALOGUE[code].hint })
+
+export const serviceError = (code: ServiceCode, message: string) =>
+  new ApiServiceError({ code, message, hint: ERROR_CATALOGUE[code].hint })
+
+// Access errors are answered before a request reaches the API, so they are built as its errors are encoded
+export const accessError = (code: AccessCode, message: string): Response =>
+  new Response(JSON.stringify({ _tag: "ApiAccessError", code, message, hint: ERROR_CATALOGUE[code].hint }), {
+    status: ERROR_CATALOGUE[code].status,
+    headers: {
+      "content-type": "application/json",
+      ...(code === "unauthorized" ? { "www-authenticate": "Bearer" } : {})
+    }
+  })
@@ -1,5 +1,7 @@
 import * as Schema from "effect/Schema"
 import { ImposterStatus, Protocol } from "../schemas/common"
+import { ImposterEventType } from "../schemas/EventSchema"
+import { ImportMode, RESET_SCOPES, ResetScope } from "../schemas/ImposterSchema"
 
 export const PaginationUrlParams = Schema.Struct({
   limit: Schema.optionalWith(
@@ -24,13 +26,117 @@ export const DeleteImposterUrlParams = Schema.Struct({
 })
 export type DeleteImposterUrlParams = Schema.Schema.Type<typeof DeleteImposterUrlParams>
 
+// Without `limit` every stub is returned, so existing callers keep getting the full list
+export const ListStubsUrlParams = Schema.Struct({
+  limit: Schema.optional(Schema.NumberFromString.pipe(Schema.int(), Schema.positive())),
+  offset: Schema.optional(Schema.NumberFromString.pipe(Schema.int(), Schema.nonNegative()))
+})
+export type ListStubsUrlParams = Schema.Schema.Type<typeof ListStubsUrlParams>
+
+const DURATION_UNITS_MS = { ms: 1, s: 1000, m: 60_000, h: 3_600_000, d: 86_400_000 } as const
+const DURATION_PATTERN = /^(\d+)(ms|s|m|h|d)$/
+
+// "500ms", "30s", "15m", "24h", "7d" -> milliseconds
+export const DurationFromString = Schema.transform(
+  Schema.String.pipe(
+    Schema.pattern(DURATION_PATTERN, { message: () => "Expected a duration like 30s, 15m, 24h or 7d" })
+  ),
+  Schema.Number,
+  {
+    strict: true,
+    decode: (s) => {
+      const [, amount, unit] = DURATION_PATTERN.exec(s)!
+      return Number(amount) * DURATION_UNITS_MS[unit as keyof typeof DURATION_UNITS_MS]
+    },
+    encode: (ms) => `${ms}ms`
+  }
+)
+
+// "scenarios,counters" resets only those; without `scope` everything is reset
+export const ResetUrlParams = Schema.Struct({
+  scope: Schema.optionalWith(
+    Schema.compose(Schema.split(","), Schema.NonEmptyArray(ResetScope)),
+    { default: () => RESET_SCOPES }
+  )
+})
+export type ResetUrlParams = Schema.Schema.Type<typeof ResetUrlParams>
+
+export const ImportUrlParams = Schema.Struct({
+  mode: Schema.optionalWith(ImportMode, { default: () => "replace" as const })
+})
+export type ImportUrlParams = Schema.Schema.Type<typeof ImportUrlParams>
+
+export const PruneStubsUrlParams = Schema.Struct({
+  unusedFor: DurationFromString,
+  dryRun: Schema.optionalWith(Schema.BooleanFromString, { default: () => false })
+})
+export type PruneStubsUrlParams = Schema.Schema.Type<typeof PruneStubsUrlParams>
+
 export const ListRequestsUrlParams = Schema.Struct({
   limit: Schema.optionalWith(
     Schema.NumberFromString.pipe(Schema.int(), Schema.positive()),
     { default: () => 50 }
   ),
+  // Skips the most recent `offset` entries, to page backwards through the journal
+  offset: Schema.optionalWith(
+    Schema.NumberFromString.pipe(Schema.int(), Schema.nonNegative()),
+    { default: () => 0 }
+  ),
   method: Schema.optional(Schema.String),
   path: Schema.optional(Schema.String),
-  status: Schema.optional(Schema.NumberFromString)
+  status: Schema.optional(Schema.NumberFromString),
+  // `false` keeps only the requests no stub matched and no proxy answered, `true` only the others
+  matched: Schema.optional(Schema.BooleanFromString)
 })
 export type ListRequestsUrlParams = Schema.Schema.Type<typeof ListRequestsUrlParams>
+
+export const ListOutboundUrlParams = Schema.Struct({
+  limit: Schema.optionalWith(
+    Schema.NumberFromString.pipe(Schema.int(), Schema.positive()),
+    { default: () => 50 }
+  ),
+  // Only what was sent while serving this journal entry
+  triggeredBy: Schema.optional(Schema.String)
+})
+export type ListOutboundUrlParams = Schema.Schema.Type<typeof ListOutboundUrlParams>
+
+export const StreamEventsUrlParams = Schema.Struct({
+  imposterId: Schema.optional(Schema.String),
+  type: Schema.optional(ImposterEventType)
+})
+export type StreamEventsUrlParams = Schema.Schema.Type<typeof StreamEventsUrlParams>
+
+export const ListEventsUrlParams = Schema.Struct({
+  ...StreamEventsUrlParams.fields,
+  limit: Schema.optionalWith(
+    Schema.NumberFromString.pipe(Schema.int(), Schema.positive()),
+    { default: () => 50 }
+  )
+})
+export type ListEventsUrlParams = Schema.Schema.Type<typeof ListEventsUrlParams>
+
+export const FailedCallbacksUrlParams = Schema.Struct({
+  imposterId: Schema.optional(Schema.String)
+})
+export type FailedCallbacksUrlParams = Schema.Schema.Type<typeof FailedCallbacksUrlParams>
+
+export const ExportUrlParams = Schema.Struct({
+  // "json" is a config file; "go" is test code that loads the imposters onto a running server;
+  // "curl" is a shell script and "http" an HTTP file for IDE REST clients, creating them route by route
+  format: Schema.optionalWith(Schema.Literal("json", "go", "curl", "http"), { default: () => "json" as const }),
+  // The package of the Go code
+  package: Schema.optionalWith(Schema.String.pipe(Schema.pattern(/^[a-z_][a-z0-9_]*$/)), {
+    default: () => "imposters"
+  })
+})
+export type ExportUrlParams = Schema.Schema.Type<typeof ExportUrlParams>
+
+export const ReadyUrlParams = Schema.Struct({
+  // Set to false to skip probing upstream targets and only report imposter health
+  probe: Schema.optionalWith(Schema.BooleanFromString, { default: () => true }),
+  timeout: Schema.optionalWith(
+    Schema.NumberFromString.pipe(Schema.int(), Schema.between(100, 30000)),
+    { default: () => 2000 }
+  )
+})
+export type ReadyUrlParams = Schema.Schema.Type<typeof ReadyUrlParams>
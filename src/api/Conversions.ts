@@ -3,8 +3,14 @@ import * as DateTime from "effect/DateTime"
 import * as Duration from "effect/Duration"
 import * as Effect from "effect/Effect"
 import type { ImposterRecord } from "../repositories/ImposterRepository"
-import { NonEmptyString, type PaginationMeta, PortNumber, PositiveInteger } from "../schemas/common"
+import type { ImposterConfig } from "../domain/imposter"
+import { NonEmptyString, type PaginationMeta, PortNumber, PositiveInteger, type Protocol } from "../schemas/common"
+import type { ImposterConfig as ImposterConfigEntry } from "../schemas/ConfigFileSchema"
 import type { ImposterResponse } from "../schemas/ImposterSchema"
+import type { ProfileCycleError, ProfileNotFoundError } from "../services/Profiles"
+import { conflictError, notFoundError } from "./ApiErrors"
+
+export const protocolOf = (config: ImposterConfig): Protocol => config.tls !== undefined ? "HTTPS" : "HTTP"
 
 export const toImposterResponse = (record: ImposterRecord): Effect.Effect<ImposterResponse> =>
   Effect.gen(function*() {
@@ -15,20 +21,50 @@ export const toImposterResponse = (record: ImposterRecord): Effect.Effect<Impost
       id: NonEmptyString.make(config.id),
       name: NonEmptyString.make(config.name),
       port: PortNumber.make(config.port),
-      protocol: "HTTP" as const,
+      protocol: protocolOf(config),
       status: config.status,
       endpointCount: record.stubs.length,
       createdAt: config.createdAt,
-      adminUrl: NonEmptyString.make(`http://localhost:${config.port}`),
+      adminUrl: NonEmptyString.make(`${protocolOf(config).toLowerCase()}://localhost:${config.port}`),
       adminPath: NonEmptyString.make("/_admin"),
       uptime: Duration.format(uptime),
-      ...(config.proxy !== undefined ? { proxy: config.proxy } : {})
+      ...(config.proxy !== undefined ? { proxy: config.proxy } : {}),
+      ...(config.stubIds !== undefined ? { stubIds: config.stubIds } : {}),
+      ...(config.httpParser !== undefined ? { httpParser: config.httpParser } : {}),
+      ...(config.stubOrder !== undefined ? { stubOrder: config.stubOrder } : {}),
+      ...(config.cors !== undefined ? { cors: config.cors } : {}),
+      ...(config.example !== undefined ? { example: NonEmptyString.make(config.example) } : {}),
+      ...(config.profile !== undefined ? { profile: NonEmptyString.make(config.profile) } : {})
     }
   })
 
+// The record as a config file entry: its settings, and its stubs without their IDs. Not its
+// `profile`, whose settings and stubs it already holds
+export const toImposterConfigEntry = (record: ImposterRecord): ImposterConfigEntry => {
+  const config = record.config
+  return {
+    name: NonEmptyString.make(config.name),
+    port: PortNumber.make(config.port),
+    stubs: record.stubs.map(({ id: _, ...stub }) => stub),
+    presets: [],
+    ...(config.proxy !== undefined ? { proxy: config.proxy } : {}),
+    ...(config.tls !== undefined ? { tls: config.tls } : {}),
+    ...(config.stubIds !== undefined ? { stubIds: config.stubIds } : {}),
+    ...(config.httpParser !== undefined ? { httpParser: config.httpParser } : {}),
+    ...(config.stubOrder !== undefined ? { stubOrder: config.stubOrder } : {}),
+    ...(config.cors !== undefined ? { cors: config.cors } : {})
+  }
+}
+
 export const buildPaginationMeta = (total: number, limit: number, offset: number): PaginationMeta => ({
   total,
   limit: PositiveInteger.make(limit),
   offset,
   hasMore: offset + limit < total
 })
+
+export const profileNotFound = (e: ProfileNotFoundError) =>
+  Effect.fail(notFoundError("profile_not_found", e.name))
+
+export const profileCycle = (e: ProfileCycleError) =>
+  Effect.fail(conflictError("profile_cycle", `Profiles extend each other in a loop: ${e.chain.join(" -> ")}`))
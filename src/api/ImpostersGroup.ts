@@ -1,21 +1,54 @@
 import { HttpApiEndpoint, HttpApiGroup, HttpApiSchema } from "@effect/platform"
 import * as Schema from "effect/Schema"
+import { AdvanceClockRequest, ClockState, SetClockRequest } from "../schemas/ClockSchema"
+import { EnvironmentManifest, ImportDocument } from "../schemas/ConfigFileSchema"
 import {
+  BulkResetResponse,
   CreateImposterRequest,
   DeleteImposterResponse,
+  ImportResponse,
   ImposterResponse,
   ListImpostersResponse,
+  LoadManifestResponse,
+  OverviewResponse,
+  PruneStubsResponse,
+  ResetImposterResponse,
   Statistics,
   UpdateImposterRequest
 } from "../schemas/ImposterSchema"
-import { RequestLogEntry } from "../schemas/RequestLogSchema"
+import { PresetConfig } from "../schemas/PresetSchema"
+import {
+  OutboundLogEntry,
+  PromoteRequest,
+  RequestLogEntry,
+  VerifyRequest,
+  VerifyResponse
+} from "../schemas/RequestLogSchema"
+import {
+  ScenarioStates,
+  ScenarioTransition,
+  SetScenarioRequest,
+  VerifyScenarioRequest,
+  VerifyScenarioResponse
+} from "../schemas/ScenarioSchema"
+import { StoreValues } from "../schemas/StoreSchema"
 import { CreateStubRequest, Stub, UpdateStubRequest } from "../schemas/StubSchema"
 import { ApiConflictError, ApiNotFoundError, ApiServiceError } from "./ApiErrors"
-import { DeleteImposterUrlParams, ListImpostersUrlParams, ListRequestsUrlParams } from "./ApiSchemas"
+import {
+  DeleteImposterUrlParams,
+  ImportUrlParams,
+  ListImpostersUrlParams,
+  ListOutboundUrlParams,
+  ListRequestsUrlParams,
+  ListStubsUrlParams,
+  PruneStubsUrlParams,
+  ResetUrlParams
+} from "./ApiSchemas"
 
 const createImposter = HttpApiEndpoint.post("createImposter", "/imposters")
   .setPayload(CreateImposterRequest)
   .addSuccess(ImposterResponse, { status: 201 })
+  .addError(ApiNotFoundError)
   .addError(ApiConflictError)
   .addError(ApiServiceError)
 
@@ -23,6 +56,37 @@ const listImposters = HttpApiEndpoint.get("listImposters", "/imposters")
   .setUrlParams(ListImpostersUrlParams)
   .addSuccess(ListImpostersResponse)
 
+const getOverview = HttpApiEndpoint.get("getOverview", "/imposters/overview")
+  .addSuccess(OverviewResponse)
+
+const resetAll = HttpApiEndpoint.post("resetAll", "/imposters/reset")
+  .setUrlParams(ResetUrlParams)
+  .addSuccess(BulkResetResponse)
+
+// Stubs stay; `scope` picks what is cleared, everything when not given
+const resetImposter = HttpApiEndpoint.post("resetImposter")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/reset`
+  .setUrlParams(ResetUrlParams)
+  .addSuccess(ResetImposterResponse)
+  .addError(ApiNotFoundError)
+
+const loadManifest = HttpApiEndpoint.post("loadManifest", "/imposters/load")
+  .setPayload(EnvironmentManifest)
+  .addSuccess(LoadManifestResponse, { status: 201 })
+  .addError(ApiNotFoundError)
+  .addError(ApiConflictError)
+  .addError(ApiServiceError)
+
+// Restores a GET /export document: its variables, profiles and imposters, each imposter started.
+const importDocument = HttpApiEndpoint.post("importDocument", "/imposters/import")
+  .setPayload(ImportDocument)
+  .setUrlParams(ImportUrlParams)
+  .addSuccess(ImportResponse)
+  .addError(ApiNotFoundError)
+  .addError(ApiConflictError)
+  .addError(ApiServiceError)
+
 const getImposter = HttpApiEndpoint.get("getImposter")`/imposters/${HttpApiSchema.param("id", Schema.String)}`
   .addSuccess(ImposterResponse)
   .addError(ApiNotFoundError)
@@ -44,11 +108,36 @@ const addStub = HttpApiEndpoint.post("addStub")`/imposters/${HttpApiSchema.param
   .setPayload(CreateStubRequest)
   .addSuccess(Stub, { status: 201 })
   .addError(ApiNotFoundError)
+  .addError(ApiConflictError)
+
+const applyPreset = HttpApiEndpoint.post("applyPreset")`/imposters/${
+  HttpApiSchema.param("imposterId", Schema.String)
+}/presets`
+  .setPayload(PresetConfig)
+  .addSuccess(Schema.Array(Stub), { status: 201 })
+  .addError(ApiNotFoundError)
+  .addError(ApiConflictError)
 
 const listStubs = HttpApiEndpoint.get("listStubs")`/imposters/${HttpApiSchema.param("imposterId", Schema.String)}/stubs`
+  .setUrlParams(ListStubsUrlParams)
   .addSuccess(Schema.Array(Stub))
   .addError(ApiNotFoundError)
 
+const replaceStubs = HttpApiEndpoint.put("replaceStubs")`/imposters/${
+  HttpApiSchema.param("imposterId", Schema.String)
+}/stubs`
+  .setPayload(Schema.Array(CreateStubRequest))
+  .addSuccess(Schema.Array(Stub))
+  .addError(ApiNotFoundError)
+  .addError(ApiConflictError)
+
+const pruneStubs = HttpApiEndpoint.post("pruneStubs")`/imposters/${
+  HttpApiSchema.param("imposterId", Schema.String)
+}/stubs/prune`
+  .setUrlParams(PruneStubsUrlParams)
+  .addSuccess(PruneStubsResponse)
+  .addError(ApiNotFoundError)
+
 const updateStub = HttpApiEndpoint.put("updateStub")`/imposters/${
   HttpApiSchema.param("imposterId", Schema.String)
 }/stubs/${HttpApiSchema.param("stubId", Schema.String)}`
@@ -69,12 +158,35 @@ const listRequests = HttpApiEndpoint.get("listRequests")`/imposters/${
   .addSuccess(Schema.Array(RequestLogEntry))
   .addError(ApiNotFoundError)
 
+const listOutbound = HttpApiEndpoint.get("listOutbound")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/requests/outbound`
+  .setUrlParams(ListOutboundUrlParams)
+  .addSuccess(Schema.Array(OutboundLogEntry))
+  .addError(ApiNotFoundError)
+
 const clearRequests = HttpApiEndpoint.del("clearRequests")`/imposters/${
   HttpApiSchema.param("id", Schema.String)
 }/requests`
   .addSuccess(Schema.Struct({ message: Schema.String }))
   .addError(ApiNotFoundError)
 
+// Turns a request no stub matched into a stub for its method, path and query
+const promoteRequest = HttpApiEndpoint.post("promoteRequest")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/requests/${HttpApiSchema.param("requestId", Schema.String)}/promote`
+  .setPayload(PromoteRequest)
+  .addSuccess(Stub, { status: 201 })
+  .addError(ApiNotFoundError)
+  .addError(ApiConflictError)
+
+const verifyRequests = HttpApiEndpoint.post("verifyRequests")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/verify`
+  .setPayload(VerifyRequest)
+  .addSuccess(VerifyResponse)
+  .addError(ApiNotFoundError)
+
 const getImposterStats = HttpApiEndpoint.get("getImposterStats")`/imposters/${
   HttpApiSchema.param("id", Schema.String)
 }/stats`
@@ -87,17 +199,115 @@ const resetImposterStats = HttpApiEndpoint.del("resetImposterStats")`/imposters/
   .addSuccess(Schema.Struct({ message: Schema.String }))
   .addError(ApiNotFoundError)
 
+const listScenarios = HttpApiEndpoint.get("listScenarios")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/scenarios`
+  .addSuccess(ScenarioStates)
+  .addError(ApiNotFoundError)
+
+const setScenario = HttpApiEndpoint.put("setScenario")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/scenarios/${HttpApiSchema.param("name", Schema.String)}`
+  .setPayload(SetScenarioRequest)
+  .addSuccess(ScenarioStates)
+  .addError(ApiNotFoundError)
+
+const resetScenarios = HttpApiEndpoint.del("resetScenarios")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/scenarios`
+  .addSuccess(ScenarioStates)
+  .addError(ApiNotFoundError)
+
+const scenarioHistory = HttpApiEndpoint.get("scenarioHistory")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/scenarios/history`
+  .addSuccess(Schema.Array(ScenarioTransition))
+  .addError(ApiNotFoundError)
+
+const verifyScenario = HttpApiEndpoint.post("verifyScenario")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/scenarios/verify`
+  .setPayload(VerifyScenarioRequest)
+  .addSuccess(VerifyScenarioResponse)
+  .addError(ApiNotFoundError)
+
+const getState = HttpApiEndpoint.get("getState")`/imposters/${HttpApiSchema.param("id", Schema.String)}/state`
+  .addSuccess(StoreValues)
+  .addError(ApiNotFoundError)
+
+const replaceState = HttpApiEndpoint.put("replaceState")`/imposters/${HttpApiSchema.param("id", Schema.String)}/state`
+  .setPayload(StoreValues)
+  .addSuccess(StoreValues)
+  .addError(ApiNotFoundError)
+
+// Sets the given keys and keeps the rest; null removes one
+const mergeState = HttpApiEndpoint.patch("mergeState")`/imposters/${HttpApiSchema.param("id", Schema.String)}/state`
+  .setPayload(StoreValues)
+  .addSuccess(StoreValues)
+  .addError(ApiNotFoundError)
+
+const clearState = HttpApiEndpoint.del("clearState")`/imposters/${HttpApiSchema.param("id", Schema.String)}/state`
+  .addSuccess(StoreValues)
+  .addError(ApiNotFoundError)
+
+const getClock = HttpApiEndpoint.get("getClock")`/imposters/${HttpApiSchema.param("id", Schema.String)}/clock`
+  .addSuccess(ClockState)
+  .addError(ApiNotFoundError)
+
+// Stops the clock at the given time, until it is advanced, set again or cleared
+const setClock = HttpApiEndpoint.put("setClock")`/imposters/${HttpApiSchema.param("id", Schema.String)}/clock`
+  .setPayload(SetClockRequest)
+  .addSuccess(ClockState)
+  .addError(ApiNotFoundError)
+
+// A clock following the wall clock stops where it is moved to
+const advanceClock = HttpApiEndpoint.post("advanceClock")`/imposters/${
+  HttpApiSchema.param("id", Schema.String)
+}/clock/advance`
+  .setPayload(AdvanceClockRequest)
+  .addSuccess(ClockState)
+  .addError(ApiNotFoundError)
+
+// Back to the wall clock
+const clearClock = HttpApiEndpoint.del("clearClock")`/imposters/${HttpApiSchema.param("id", Schema.String)}/clock`
+  .addSuccess(ClockState)
+  .addError(ApiNotFoundError)
+
 export const ImpostersGroup = HttpApiGroup.make("imposters")
   .add(createImposter)
   .add(listImposters)
+  .add(getOverview)
+  .add(resetAll)
+  .add(resetImposter)
+  .add(loadManifest)
+  .add(importDocument)
   .add(getImposter)
   .add(updateImposter)
   .add(deleteImposter)
   .add(addStub)
+  .add(applyPreset)
   .add(listStubs)
+  .add(replaceStubs)
+  .add(pruneStubs)
   .add(updateStub)
   .add(deleteStub)
   .add(listRequests)
+  .add(listOutbound)
   .add(clearRequests)
+  .add(promoteRequest)
+  .add(verifyRequests)
   .add(getImposterStats)
   .add(resetImposterStats)
+  .add(listScenarios)
+  .add(setScenario)
+  .add(resetScenarios)
+  .add(scenarioHistory)
+  .add(verifyScenario)
+  .add(getState)
+  .add(replaceState)
+  .add(mergeState)
+  .add(clearState)
+  .add(getClock)
+  .add(setClock)
+  .add(advanceClock)
+  .add(clearClock)
@@ -2,56 +2,297 @@ import { HttpApiBuilder } from "@effect/platform"
 import * as Clock from "effect/Clock"
 import * as DateTime from "effect/DateTime"
 import * as Effect from "effect/Effect"
-import { ImposterConfig, type ProxyConfigDomain } from "../domain/imposter"
-import { ImposterRepository } from "../repositories/ImposterRepository"
-import { NonEmptyString } from "../schemas/common"
+import {
+  environmentPrefix,
+  ImposterConfig,
+  type ImposterNotFoundError,
+  type ProxyConfigDomain
+} from "../domain/imposter"
+import { inlineBodies } from "../domain/bodies"
+import { canonicalJson, contentStubId, type StubIdMode, uniqueStubId } from "../domain/stubIds"
+import type { StubOrder } from "../matching/RequestMatcher"
+import { verifyEntries, verifyScenario } from "../matching/Verification"
+import { expandPreset, seedPreset } from "../presets/Presets"
+import { type ImposterRecord, ImposterRepository, type ImposterTransaction } from "../repositories/ImposterRepository"
+import { NonEmptyString, PortNumber } from "../schemas/common"
+import type { CorsRule } from "../schemas/CorsSchema"
+import type { ImposterConfig as ImposterConfigEntry, SharedBodies } from "../schemas/ConfigFileSchema"
+import type {
+  ImportMode,
+  ImportSkip,
+  ImposterOverview,
+  ImposterResponse,
+  ResetScope,
+  UnmatchedRequestSummary
+} from "../schemas/ImposterSchema"
+import type { PromoteRequest, RequestLogEntry } from "../schemas/RequestLogSchema"
+import type { PredicateExpression, Stub } from "../schemas/StubSchema"
 import { ImposterServer } from "../server/ImposterServer"
+import { scenarioStates, stateOf } from "../server/Scenarios"
+import type { HttpParserMode } from "../server/ServerFactory"
+import type { TlsSettings } from "../server/Tls"
 import { AppConfig } from "../services/AppConfig"
+import { diffFields, EventBus } from "../services/EventBus"
 import { MetricsService } from "../services/MetricsService"
 import { PortAllocator } from "../services/PortAllocator"
+import { Profiles } from "../services/Profiles"
 import { RequestLogger } from "../services/RequestLogger"
 import { Uuid } from "../services/Uuid"
+import { Variables } from "../services/Variables"
 import { AdminApi } from "./AdminApi"
-import { ApiConflictError, ApiNotFoundError, ApiServiceError } from "./ApiErrors"
-import { buildPaginationMeta, toImposterResponse } from "./Conversions"
+import { conflictError, notFoundError, serviceError } from "./ApiErrors"
+import { buildPaginationMeta, profileCycle, profileNotFound, protocolOf, toImposterResponse } from "./Conversions"
 
-export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters", (handlers) =>
-  handlers
-    .handle("createImposter", ({ payload }) =>
-      Effect.gen(function*() {
-        const repo = yield* ImposterRepository
-        const uuid = yield* Uuid
-        const allocator = yield* PortAllocator
-        const config = yield* AppConfig
+const MAX_ID_ATTEMPTS = 10
 
-        const all = yield* repo.getAll
+// Draws IDs until one isn't taken; only a short ID_LENGTH or narrow ID_ALPHABET makes that take long
+const unusedId = (taken: ReadonlySet<string>) =>
+  Effect.gen(function*() {
+    const uuid = yield* Uuid
+    for (let attempt = 0; attempt < MAX_ID_ATTEMPTS; attempt++) {
+      const id = yield* uuid.generateShort
+      if (!taken.has(id)) return NonEmptyString.make(id)
+    }
+    return yield* Effect.fail(
+      conflictError("ids_exhausted", `No unused ID after ${MAX_ID_ATTEMPTS} attempts; raise ID_LENGTH`)
+    )
+  })
+
+const resolvedProfile = (name: string) =>
+  Effect.flatMap(Profiles, (profiles) => profiles.resolve(name)).pipe(
+    Effect.catchTags({ ProfileNotFoundError: profileNotFound, ProfileCycleError: profileCycle })
+  )
+
+const createImposterRecord = (
+  input: {
+    readonly name?: string | undefined
+    readonly port?: number | undefined
+    readonly proxy?: ProxyConfigDomain | undefined
+    readonly tls?: TlsSettings | undefined
+    readonly stubIds?: StubIdMode | undefined
+    readonly httpParser?: HttpParserMode | undefined
+    readonly stubOrder?: StubOrder | undefined
+    readonly cors?: ReadonlyArray<CorsRule> | undefined
+    readonly profile?: string | undefined
+  },
+  ownStubs: ReadonlyArray<Omit<Stub, "id">> = []
+) =>
+  Effect.gen(function*() {
+    const repo = yield* ImposterRepository
+    const allocator = yield* PortAllocator
+    const config = yield* AppConfig
+    const eventBus = yield* EventBus
+
+    // The profile's settings apply where the imposter sets none, and its stubs go ahead of the imposter's
+    const profile = input.profile !== undefined ? yield* resolvedProfile(input.profile) : undefined
+    const proxy = input.proxy ?? profile?.proxy
+    const stubIds = input.stubIds ?? profile?.stubIds
+    const httpParser = input.httpParser ?? profile?.httpParser
+    const cors = input.cors ?? profile?.cors
+    const stubs = profile !== undefined
+      ? [...profile.stubs, ...profile.presets.flatMap(expandPreset), ...ownStubs]
+      : ownStubs
+
+    // The imposter appears with all its stubs at once, under an ID nothing else can take meanwhile
+    const record = yield* repo.transaction((tx) =>
+      Effect.gen(function*() {
+        const all = yield* tx.getAll
         if (all.length >= config.maxImposters) {
           return yield* Effect.fail(
-            new ApiServiceError({ message: `Maximum number of imposters (${config.maxImposters}) reached` })
+            serviceError("imposter_limit", `Maximum number of imposters (${config.maxImposters}) reached`)
           )
         }
 
-        const id = yield* uuid.generateShort
-        const name = payload.name ?? NonEmptyString.make(id)
+        const id = yield* unusedId(new Set(all.map((r) => r.config.id)))
 
-        const port = yield* allocator.allocate(payload.port).pipe(
+        const port = yield* allocator.allocate(input.port).pipe(
           Effect.catchTags({
-            PortAllocatorError: (e) => Effect.fail(new ApiConflictError({ message: e.reason })),
+            PortAllocatorError: (e) => Effect.fail(conflictError("port_unavailable", e.reason)),
             PortExhaustedError: (e) =>
-              Effect.fail(new ApiServiceError({ message: `No available ports in range ${e.rangeMin}-${e.rangeMax}` }))
+              Effect.fail(serviceError("ports_exhausted", `No available ports in range ${e.rangeMin}-${e.rangeMax}`))
           })
         )
 
         const imposterConfig = ImposterConfig({
           id,
-          name,
+          name: input.name ?? id,
           port,
           status: "stopped",
           createdAt: DateTime.unsafeNow(),
-          ...(payload.proxy !== undefined ? { proxy: payload.proxy } : {})
+          ...(proxy !== undefined ? { proxy } : {}),
+          ...(input.tls !== undefined ? { tls: input.tls } : {}),
+          ...(stubIds !== undefined ? { stubIds } : {}),
+          ...(httpParser !== undefined ? { httpParser } : {}),
+          ...(input.stubOrder !== undefined ? { stubOrder: input.stubOrder } : {}),
+          ...(cors !== undefined ? { cors } : {}),
+          ...(profile?.example !== undefined ? { example: profile.example } : {}),
+          ...(input.profile !== undefined ? { profile: input.profile } : {})
         })
 
-        const record = yield* repo.create(imposterConfig)
+        return yield* Effect.gen(function*() {
+          yield* tx.create(imposterConfig)
+          for (const stub of stubs) {
+            yield* addNewStub(tx, id, stub)
+          }
+          return yield* tx.get(id)
+        }).pipe(
+          Effect.catchTags({ ImposterExistsError: Effect.die, ImposterNotFoundError: Effect.die }),
+          Effect.tapError(() => allocator.release(port))
+        )
+      })
+    )
+    if (profile !== undefined) yield* Effect.forEach(profile.presets, seedPreset, { discard: true })
+    yield* eventBus.publish("imposter.created", record.config.id, {
+      name: record.config.name,
+      port: record.config.port
+    })
+    return record
+  })
+
+// The ID of a stub added without one: random, or derived from its predicates when the imposter asks
+const newStubId = (
+  config: ImposterConfig,
+  predicates: ReadonlyArray<PredicateExpression>,
+  taken: ReadonlySet<string>
+) =>
+  Effect.gen(function*() {
+    if (config.stubIds === "content") return NonEmptyString.make(uniqueStubId(contentStubId(predicates), taken))
+    return yield* unusedId(taken)
+  })
+
+// A stub answering requests like a journaled one: same method and path, and query if asked
+const promotedStub = (entry: RequestLogEntry, options: PromoteRequest): Omit<Stub, "id"> => {
+  const { method, path, query } = entry.request
+  return {
+    predicates: [
+      { field: "method", operator: "equals", value: method, caseSensitive: true },
+      { field: "path", operator: "equals", value: path, caseSensitive: true },
+      ...(options.matchQuery && Object.keys(query).length > 0
+        ? [{ field: "query" as const, operator: "equals" as const, value: query, caseSensitive: true }]
+        : [])
+    ],
+    responses: [options.response ?? { status: 200 }],
+    responseMode: "sequential",
+    ...(options.priority !== undefined ? { priority: options.priority } : {})
+  }
+}
+
+// Clears what `scopes` name for the imposter, leaving its stubs as they are
+const resetImposterState = (id: string, scopes: ReadonlyArray<ResetScope>) =>
+  Effect.gen(function*() {
+    const imposterServer = yield* ImposterServer
+    if (scopes.includes("requests")) {
+      const requestLogger = yield* RequestLogger
+      const metricsService = yield* MetricsService
+      yield* requestLogger.clear(id)
+      yield* metricsService.resetStats(id)
+    }
+    yield* imposterServer.resetState(id, scopes)
+  })
+
+// Adds a stub under a new ID. Within a transaction no other write can take the ID first.
+const addNewStub = (tx: ImposterTransaction, imposterId: string, input: Omit<Stub, "id">) =>
+  Effect.gen(function*() {
+    const record = yield* tx.get(imposterId)
+    const id = yield* newStubId(record.config, input.predicates, new Set(record.stubs.map((s) => s.id)))
+    return yield* tx.addStub(imposterId, { id, ...input }).pipe(Effect.catchTag("StubExistsError", Effect.die))
+  })
+
+const imposterNotFound = (e: ImposterNotFoundError) =>
+  Effect.fail(notFoundError("imposter_not_found", e.id))
+
+// Stops (if needed) and removes an imposter, releasing its port and metrics
+const removeImposterRecord = (id: string) =>
+  Effect.gen(function*() {
+    const repo = yield* ImposterRepository
+    const allocator = yield* PortAllocator
+    const imposterServer = yield* ImposterServer
+    const metricsService = yield* MetricsService
+    const eventBus = yield* EventBus
+
+    const running = yield* imposterServer.isRunning(id)
+    if (running) {
+      yield* imposterServer.stop(id)
+    }
+    yield* imposterServer.clearClock(id)
+
+    const removed = yield* repo.remove(id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+    yield* allocator.release(removed.config.port)
+    yield* metricsService.removeStats(id)
+    yield* eventBus.publish("imposter.deleted", id, { name: removed.config.name, port: removed.config.port })
+  })
+
+// Creates an imposter from a config file entry, with shared bodies put back in place
+const createFromEntry = (imp: ImposterConfigEntry, bodies: SharedBodies, name: string | undefined) =>
+  Effect.gen(function*() {
+    const record = yield* createImposterRecord(
+      {
+        name,
+        port: imp.port,
+        proxy: imp.proxy,
+        tls: imp.tls,
+        stubIds: imp.stubIds,
+        httpParser: imp.httpParser,
+        stubOrder: imp.stubOrder,
+        cors: imp.cors,
+        profile: imp.profile
+      },
+      [...inlineBodies(imp.stubs, bodies), ...imp.presets.flatMap(expandPreset)]
+    )
+    yield* Effect.forEach(imp.presets, seedPreset, { discard: true })
+    return record
+  })
+
+// Starts an imposter just created, answering with it as it then is
+const startCreated = (id: string) =>
+  Effect.gen(function*() {
+    const repo = yield* ImposterRepository
+    const imposterServer = yield* ImposterServer
+    yield* imposterServer.start(id).pipe(
+      Effect.catchTag("ImposterServerError", (e) => Effect.fail(serviceError("server_start_failed", e.reason))),
+      Effect.catchTag("ImposterNotFoundError", Effect.die)
+    )
+    const final = yield* repo.get(id).pipe(Effect.orDie)
+    return yield* toImposterResponse(final)
+  })
+
+// Puts back an imposter an import removed, under its own ID and with its own stubs
+const restoreRecord = (record: ImposterRecord, running: boolean) =>
+  Effect.gen(function*() {
+    const repo = yield* ImposterRepository
+    const allocator = yield* PortAllocator
+    const imposterServer = yield* ImposterServer
+    const eventBus = yield* EventBus
+    const port = yield* allocator.allocate(record.config.port)
+    yield* repo.transaction((tx) =>
+      Effect.gen(function*() {
+        yield* tx.create(ImposterConfig({ ...record.config, status: "stopped" }))
+        for (const stub of record.stubs) {
+          yield* tx.addStub(record.config.id, stub)
+        }
+      })
+    ).pipe(Effect.tapError(() => allocator.release(port)))
+    yield* eventBus.publish("imposter.created", record.config.id, { name: record.config.name, port })
+    if (running) yield* imposterServer.start(record.config.id)
+  })
+
+// The settings a document gives an imposter that a merge leaves as the imposter has them
+const settingsKept = (imp: ImposterConfigEntry, config: ImposterConfig) =>
+  (["proxy", "tls", "stubIds", "httpParser", "stubOrder", "cors", "profile"] as const).filter((setting) =>
+    imp[setting] !== undefined && canonicalJson(imp[setting]) !== canonicalJson(config[setting])
+  )
+
+// What an import leaves of `current` given the document's `given`: skip-conflicts keeps names already set
+const laidOver = <R extends object>(mode: ImportMode, current: R, given: R): R =>
+  mode === "replace" ? given : mode === "merge" ? { ...current, ...given } : { ...given, ...current }
+
+const RECENT_UNMATCHED_LIMIT = 20
+
+export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters", (handlers) =>
+  handlers
+    .handle("createImposter", ({ payload }) =>
+      Effect.gen(function*() {
+        const record = yield* createImposterRecord(payload)
         return yield* toImposterResponse(record)
       }))
     .handle("listImposters", ({ urlParams }) =>
@@ -59,10 +300,9 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
         const repo = yield* ImposterRepository
         const all = yield* repo.getAll
 
-        // All imposters are HTTP in Phase 2; protocol filter is for forward compatibility
         const filtered = all
           .filter((r) => urlParams.status === undefined || r.config.status === urlParams.status)
-          .filter(() => urlParams.protocol === undefined || urlParams.protocol === "HTTP")
+          .filter((r) => urlParams.protocol === undefined || protocolOf(r.config) === urlParams.protocol)
 
         filtered.sort((a, b) => DateTime.toEpochMillis(a.config.createdAt) - DateTime.toEpochMillis(b.config.createdAt))
 
@@ -75,15 +315,232 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
           pagination: buildPaginationMeta(total, urlParams.limit, urlParams.offset)
         }
       }))
-    .handle("getImposter", ({ path }) =>
+    .handle("getOverview", () =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const requestLogger = yield* RequestLogger
+        const metricsService = yield* MetricsService
+
+        const all = [...(yield* repo.getAll)].sort((a, b) =>
+          DateTime.toEpochMillis(a.config.createdAt) - DateTime.toEpochMillis(b.config.createdAt)
+        )
+
+        const imposters: Array<ImposterOverview> = []
+        const unmatched: Array<UnmatchedRequestSummary> = []
+        for (const record of all) {
+          const { config } = record
+          const running = yield* imposterServer.isRunning(config.id)
+          const stats = yield* metricsService.getStats(config.id)
+          const entries = yield* requestLogger.getEntries(config.id, { limit: Number.MAX_SAFE_INTEGER })
+          const misses = entries.filter((e) => e.response.matchedStubId === undefined && !e.response.proxied)
+          for (const miss of misses) {
+            unmatched.push({
+              imposterId: NonEmptyString.make(config.id),
+              imposterName: NonEmptyString.make(config.name),
+              method: miss.request.method,
+              path: miss.request.path,
+              timestamp: miss.timestamp
+            })
+          }
+          imposters.push({
+            id: NonEmptyString.make(config.id),
+            name: NonEmptyString.make(config.name),
+            port: PortNumber.make(config.port),
+            status: config.status,
+            health: config.status !== "running" ? "stopped" : running ? "up" : "down",
+            stubCount: record.stubs.length,
+            requestCount: stats.totalRequests,
+            unmatchedCount: misses.length
+          })
+        }
+
+        const recentUnmatched = unmatched
+          .sort((a, b) => DateTime.toEpochMillis(b.timestamp) - DateTime.toEpochMillis(a.timestamp))
+          .slice(0, RECENT_UNMATCHED_LIMIT)
+
+        return {
+          totals: {
+            imposters: imposters.length,
+            running: imposters.filter((i) => i.health === "up").length,
+            stubs: imposters.reduce((sum, i) => sum + i.stubCount, 0),
+            requests: imposters.reduce((sum, i) => sum + i.requestCount, 0),
+            unmatched: unmatched.length
+          },
+          imposters,
+          recentUnmatched
+        }
+      }))
+    .handle("resetAll", ({ urlParams }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const all = yield* repo.getAll
+        for (const record of all) {
+          yield* resetImposterState(record.config.id, urlParams.scope)
+        }
+        return { message: `Reset ${all.length} imposters`, count: all.length, scopes: urlParams.scope }
+      }))
+    .handle("resetImposter", ({ path, urlParams }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        yield* resetImposterState(path.id, urlParams.scope)
+        return {
+          message: `Reset ${urlParams.scope.join(", ")} of imposter ${path.id}`,
+          id: NonEmptyString.make(path.id),
+          scopes: urlParams.scope
+        }
+      }))
+    .handle("loadManifest", ({ payload }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
-        const record = yield* repo.get(path.id).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
+
+        // Replace whatever a previous load of this environment left behind
+        const prefix = environmentPrefix(payload.name)
+        const previous = (yield* repo.getAll).filter((r) => r.config.name.startsWith(prefix))
+        for (const record of previous) {
+          yield* removeImposterRecord(record.config.id).pipe(Effect.catchTag("ApiNotFoundError", () => Effect.void))
+        }
+        if (previous.length > 0) {
+          // Give released ports a moment to close before they are bound again
+          yield* Effect.sleep("100 millis")
+        }
+
+        // All or nothing: if one imposter fails to load, the ones loaded before it are removed again
+        const created: Array<string> = []
+        const imposters: Array<ImposterResponse> = []
+        yield* Effect.gen(function*() {
+          for (const imp of payload.imposters) {
+            const record = yield* createFromEntry(imp, payload.bodies, `${prefix}${imp.name ?? imp.port}`)
+            created.push(record.config.id)
+            imposters.push(yield* startCreated(record.config.id))
+          }
+        }).pipe(
+          Effect.onError(() =>
+            Effect.forEach(created, (id) => Effect.ignore(removeImposterRecord(id)), { discard: true })
+          )
+        )
+
+        return { environment: payload.name, removed: previous.length, imposters }
+      }))
+    .handle("importDocument", ({ payload, urlParams }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const eventBus = yield* EventBus
+        const variables = yield* Variables
+        const profiles = yield* Profiles
+        const { mode } = urlParams
+        const skipped: Array<ImportSkip> = []
+
+        // What the server held, to put back should the import fail part way
+        const previousVariables = yield* variables.get
+        const previousProfiles = yield* profiles.getAll
+        if (mode === "skip-conflicts") {
+          for (const name of Object.keys(payload.variables).filter((name) => Object.hasOwn(previousVariables, name))) {
+            skipped.push({ kind: "variable", name, reason: "Already set" })
+          }
+          for (const name of Object.keys(payload.profiles).filter((name) => Object.hasOwn(previousProfiles, name))) {
+            skipped.push({ kind: "profile", name, reason: "Already set" })
+          }
+        }
+
+        const removed: Array<{ readonly record: ImposterRecord; readonly running: boolean }> = []
+        const createdIds: Array<string> = []
+        const mergedStubs: Array<{ readonly imposterId: string; readonly stubId: string }> = []
+        const created: Array<ImposterResponse> = []
+        const updated: Array<ImposterResponse> = []
+
+        yield* Effect.gen(function*() {
+          // Profiles first, so a document naming one that doesn't resolve changes nothing
+          yield* profiles.update((current) => laidOver(mode, current, payload.profiles)).pipe(
+            Effect.catchTags({ ProfileNotFoundError: profileNotFound, ProfileCycleError: profileCycle })
+          )
+          yield* variables.update((current) => laidOver(mode, current, payload.variables))
+
+          if (mode === "replace") {
+            for (const record of yield* repo.getAll) {
+              const running = yield* imposterServer.isRunning(record.config.id)
+              yield* removeImposterRecord(record.config.id).pipe(Effect.catchTag("ApiNotFoundError", () => Effect.void))
+              removed.push({ record, running })
+            }
+            if (removed.length > 0) {
+              // Give released ports a moment to close before they are bound again
+              yield* Effect.sleep("100 millis")
+            }
+          }
+
+          // Imposters already on the server are matched by port
+          const existing = new Map((yield* repo.getAll).map((record) => [record.config.port, record]))
+          for (const imp of payload.imposters) {
+            const name = imp.name ?? String(imp.port)
+            const found = existing.get(imp.port)
+            if (found === undefined) {
+              const record = yield* createFromEntry(imp, payload.bodies, imp.name)
+              createdIds.push(record.config.id)
+              created.push(yield* startCreated(record.config.id))
+              continue
+            }
+            const { config } = found
+            if (mode === "skip-conflicts") {
+              skipped.push({ kind: "imposter", name, reason: `Port ${imp.port} is taken by imposter ${config.name}` })
+              continue
+            }
+            // Merged into, the imposter keeps its settings and gains the document's stubs it lacks
+            for (const setting of settingsKept(imp, config)) {
+              skipped.push({ kind: "setting", name, reason: `Merging keeps the imposter's own ${setting}` })
+            }
+            const known = new Set(found.stubs.map((stub) => canonicalJson(stub.predicates)))
+            const incoming = [...inlineBodies(imp.stubs, payload.bodies), ...imp.presets.flatMap(expandPreset)]
+            const fresh = incoming.filter((stub) => {
+              const key = canonicalJson(stub.predicates)
+              if (known.has(key)) return false
+              known.add(key)
+              return true
+            })
+            for (let i = fresh.length; i < incoming.length; i++) {
+              skipped.push({ kind: "stub", name, reason: "A stub with the same predicates is already there" })
+            }
+            const added = yield* repo.transaction((tx) =>
+              Effect.forEach(fresh, (input) => addNewStub(tx, config.id, input))
+            ).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+            mergedStubs.push(...added.map((stub) => ({ imposterId: config.id, stubId: stub.id })))
+            yield* Effect.forEach(imp.presets, seedPreset, { discard: true })
+            if (yield* imposterServer.isRunning(config.id)) {
+              yield* imposterServer.updateStubs(config.id)
+            }
+            for (const stub of added) {
+              yield* eventBus.publish("stub.added", config.id, { stubId: stub.id, stub })
+            }
+            updated.push(yield* toImposterResponse(yield* repo.get(config.id).pipe(Effect.orDie)))
+          }
+        }).pipe(
+          // All or nothing: what the import changed goes back to how it was
+          Effect.onError(() =>
+            Effect.gen(function*() {
+              yield* Effect.forEach(createdIds, (id) => Effect.ignore(removeImposterRecord(id)), { discard: true })
+              for (const { imposterId, stubId } of mergedStubs) {
+                yield* Effect.ignore(repo.removeStub(imposterId, stubId))
+              }
+              for (const imposterId of new Set(mergedStubs.map((stub) => stub.imposterId))) {
+                if (yield* imposterServer.isRunning(imposterId)) yield* imposterServer.updateStubs(imposterId)
+              }
+              if (removed.length > 0) yield* Effect.sleep("100 millis")
+              yield* Effect.forEach(removed, ({ record, running }) => Effect.ignore(restoreRecord(record, running)), {
+                discard: true
+              })
+              yield* variables.replace(previousVariables)
+              yield* Effect.ignore(profiles.update(() => previousProfiles))
+            })
+          )
         )
+
+        return { mode, removed: removed.length, created, updated, skipped }
+      }))
+    .handle("getImposter", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const record = yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
         return yield* toImposterResponse(record)
       }))
     .handle("updateImposter", ({ path, payload }) =>
@@ -91,13 +548,9 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
         const repo = yield* ImposterRepository
         const allocator = yield* PortAllocator
         const imposterServer = yield* ImposterServer
+        const eventBus = yield* EventBus
 
-        const existing = yield* repo.get(path.id).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
-        )
+        const existing = yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
 
         const wasRunning = yield* imposterServer.isRunning(path.id)
         const wantsRunning = payload.status === "running"
@@ -113,9 +566,9 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
         if (portChanging) {
           newPort = yield* allocator.allocate(payload.port).pipe(
             Effect.catchTags({
-              PortAllocatorError: (e) => Effect.fail(new ApiConflictError({ message: e.reason })),
+              PortAllocatorError: (e) => Effect.fail(conflictError("port_unavailable", e.reason)),
               PortExhaustedError: (e) =>
-                Effect.fail(new ApiServiceError({ message: `No available ports in range ${e.rangeMin}-${e.rangeMax}` }))
+                Effect.fail(serviceError("ports_exhausted", `No available ports in range ${e.rangeMin}-${e.rangeMax}`))
             })
           )
         }
@@ -134,13 +587,11 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
             ...(payload.name !== undefined ? { name: payload.name as string } : {}),
             ...(payload.status !== undefined ? { status: payload.status } : {}),
             ...(newPort !== undefined ? { port: newPort } : {}),
-            ...proxyUpdate
+            ...proxyUpdate,
+            ...(payload.example !== undefined ? { example: payload.example ?? undefined } : {})
           })
         })).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            )),
+          Effect.catchTag("ImposterNotFoundError", imposterNotFound),
           Effect.tapError(() => newPort !== undefined ? allocator.release(newPort) : Effect.void)
         )
 
@@ -152,74 +603,47 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
         if (payload.proxy !== undefined) {
           yield* imposterServer.updateProxyConfig(path.id)
         }
+        if (payload.example !== undefined) {
+          yield* imposterServer.updateExample(path.id)
+        }
 
         // Handle start/stop transitions
         if (wantsRunning && !wasRunning) {
           yield* imposterServer.start(path.id).pipe(
-            Effect.catchTag("ImposterServerError", (e) => Effect.fail(new ApiServiceError({ message: e.reason }))),
-            Effect.catchTag("ImposterNotFoundError", (e) =>
-              Effect.fail(
-                new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-              ))
+            Effect.catchTag("ImposterServerError", (e) => Effect.fail(serviceError("server_start_failed", e.reason))),
+            Effect.catchTag("ImposterNotFoundError", imposterNotFound)
           )
         } else if (wantsStopped && wasRunning && !portChanging) {
           yield* imposterServer.stop(path.id)
         } else if (portChanging && wasRunning) {
           // Port changed while running — restart
           yield* imposterServer.start(path.id).pipe(
-            Effect.catchTag("ImposterServerError", (e) => Effect.fail(new ApiServiceError({ message: e.reason }))),
-            Effect.catchTag("ImposterNotFoundError", (e) =>
-              Effect.fail(
-                new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-              ))
+            Effect.catchTag("ImposterServerError", (e) => Effect.fail(serviceError("server_start_failed", e.reason))),
+            Effect.catchTag("ImposterNotFoundError", imposterNotFound)
           )
         }
 
         // Re-read to get final status
-        const final = yield* repo.get(path.id).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
-        )
+        const final = yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        const changes = diffFields(existing.config, final.config, ["name", "port", "status", "proxy", "example"])
+        if (Object.keys(changes).length > 0) {
+          yield* eventBus.publish("imposter.updated", path.id, { changes })
+        }
         return yield* toImposterResponse(final)
       }))
     .handle("deleteImposter", ({ path, urlParams }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
-        const allocator = yield* PortAllocator
-        const imposterServer = yield* ImposterServer
-        const metricsService = yield* MetricsService
 
-        const existing = yield* repo.get(path.id).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
-        )
+        const existing = yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
 
         if (!urlParams.force && existing.config.status !== "stopped") {
           return yield* Effect.fail(
-            new ApiConflictError({
-              message: `Imposter is ${existing.config.status}, use force=true to delete`
-            })
+            conflictError("imposter_running", `Imposter is ${existing.config.status}, use force=true to delete`)
           )
         }
 
-        // Stop if running
-        const running = yield* imposterServer.isRunning(path.id)
-        if (running) {
-          yield* imposterServer.stop(path.id)
-        }
-
-        yield* repo.remove(path.id).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
-        )
-        yield* allocator.release(existing.config.port)
-        yield* metricsService.resetStats(path.id)
+        yield* removeImposterRecord(path.id)
 
         const now = yield* Effect.map(Clock.currentTimeMillis, (ms) => DateTime.unsafeMake(ms))
 
@@ -232,23 +656,40 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
     .handle("addStub", ({ path, payload }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
-        const uuid = yield* Uuid
         const imposterServer = yield* ImposterServer
+        const eventBus = yield* EventBus
 
-        const id = yield* uuid.generateShort
-        const stub = {
-          id: NonEmptyString.make(id),
-          predicates: payload.predicates,
-          responses: payload.responses,
-          responseMode: payload.responseMode
+        const result = yield* repo.transaction((tx) =>
+          addNewStub(tx, path.imposterId, {
+            predicates: payload.predicates,
+            responses: payload.responses,
+            responseMode: payload.responseMode,
+            ...(payload.priority !== undefined ? { priority: payload.priority } : {}),
+            ...(payload.scenario !== undefined ? { scenario: payload.scenario } : {}),
+            ...(payload.capture !== undefined ? { capture: payload.capture } : {})
+          })
+        ).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+
+        // Hot-reload if running
+        const running = yield* imposterServer.isRunning(path.imposterId)
+        if (running) {
+          yield* imposterServer.updateStubs(path.imposterId)
         }
 
-        const result = yield* repo.addStub(path.imposterId, stub).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
-        )
+        yield* eventBus.publish("stub.added", path.imposterId, { stubId: result.id, stub: result })
+        return result
+      }))
+    .handle("applyPreset", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const eventBus = yield* EventBus
+
+        // A preset's stubs are added together or not at all
+        const added = yield* repo.transaction((tx) =>
+          Effect.forEach(expandPreset(payload), (input) => addNewStub(tx, path.imposterId, input))
+        ).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        yield* seedPreset(payload)
 
         // Hot-reload if running
         const running = yield* imposterServer.isRunning(path.imposterId)
@@ -256,37 +697,120 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
           yield* imposterServer.updateStubs(path.imposterId)
         }
 
-        return result
+        for (const stub of added) {
+          yield* eventBus.publish("stub.added", path.imposterId, { stubId: stub.id, stub })
+        }
+        return added
       }))
-    .handle("listStubs", ({ path }) =>
+    .handle("listStubs", ({ path, urlParams }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
-        return yield* repo.getStubs(path.imposterId).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
+        const stubs = yield* repo.getStubs(path.imposterId).pipe(
+          Effect.catchTag("ImposterNotFoundError", imposterNotFound)
         )
+        const offset = urlParams.offset ?? 0
+        return stubs.slice(offset, urlParams.limit !== undefined ? offset + urlParams.limit : undefined)
+      }))
+    .handle("replaceStubs", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+
+        const record = yield* repo.get(path.imposterId).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        // The new set replaces every stub, so only its own IDs can clash
+        const taken = new Set<string>()
+        const stubs: Array<Stub> = []
+        for (const input of payload) {
+          const id = yield* newStubId(record.config, input.predicates, taken)
+          taken.add(id)
+          stubs.push({
+            id,
+            predicates: input.predicates,
+            responses: input.responses,
+            responseMode: input.responseMode,
+            ...(input.priority !== undefined ? { priority: input.priority } : {}),
+            ...(input.scenario !== undefined ? { scenario: input.scenario } : {}),
+            ...(input.capture !== undefined ? { capture: input.capture } : {})
+          })
+        }
+
+        yield* repo.update(path.imposterId, (r) => ({ ...r, stubs })).pipe(
+          Effect.catchTag("ImposterNotFoundError", imposterNotFound)
+        )
+
+        // Swap atomically; requests already in flight finish against the previous set
+        yield* imposterServer.replaceStubs(path.imposterId)
+
+        return stubs
+      }))
+    .handle("pruneStubs", ({ path, urlParams }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const metricsService = yield* MetricsService
+        const eventBus = yield* EventBus
+
+        const record = yield* repo.get(path.imposterId).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        const usage = yield* metricsService.getStubUsage(path.imposterId)
+        const cutoff = DateTime.toEpochMillis(yield* DateTime.now) - urlParams.unusedFor
+
+        // Stubs never hit are judged from when counting started for them: when they were added,
+        // or the last stats reset if that came later
+        const resetAt = usage.resetAt !== undefined ? DateTime.toEpochMillis(usage.resetAt) : 0
+        const countingSince = (stub: Stub) => {
+          const addedAt = record.stubsAddedAt.get(stub.id) ?? record.config.createdAt
+          return Math.max(DateTime.toEpochMillis(addedAt), resetAt)
+        }
+        const stale = record.stubs.filter((stub) => {
+          const lastHitAt = usage.stubs[stub.id]?.lastHitAt
+          return (lastHitAt !== undefined ? DateTime.toEpochMillis(lastHitAt) : countingSince(stub)) < cutoff
+        })
+        const pruned = stale.map((stub) => {
+          const hits = usage.stubs[stub.id]
+          return { id: stub.id, hits: hits?.hits ?? 0, ...(hits !== undefined ? { lastHitAt: hits.lastHitAt } : {}) }
+        })
+
+        if (urlParams.dryRun || stale.length === 0) {
+          return { dryRun: urlParams.dryRun, pruned, remaining: record.stubs.length - stale.length }
+        }
+
+        const staleIds = new Set(stale.map((stub) => stub.id))
+        const updated = yield* repo.update(path.imposterId, (r) => ({
+          ...r,
+          stubs: r.stubs.filter((stub) => !staleIds.has(stub.id))
+        })).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+
+        const running = yield* imposterServer.isRunning(path.imposterId)
+        if (running) {
+          yield* imposterServer.updateStubs(path.imposterId)
+        }
+
+        for (const stub of stale) {
+          yield* eventBus.publish("stub.removed", path.imposterId, { stubId: stub.id, stub })
+        }
+        return { dryRun: false, pruned, remaining: updated.stubs.length }
       }))
     .handle("updateStub", ({ path, payload }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
         const imposterServer = yield* ImposterServer
+        const eventBus = yield* EventBus
 
+        const before = yield* repo.getStubs(path.imposterId).pipe(
+          Effect.map((stubs) => stubs.find((s) => s.id === path.stubId)),
+          Effect.orElseSucceed(() => undefined)
+        )
         const result = yield* repo.updateStub(path.imposterId, path.stubId, (s) => ({
           ...s,
           ...(payload.predicates !== undefined ? { predicates: payload.predicates } : {}),
           ...(payload.responses !== undefined ? { responses: payload.responses } : {}),
-          ...(payload.responseMode !== undefined ? { responseMode: payload.responseMode } : {})
+          ...(payload.responseMode !== undefined ? { responseMode: payload.responseMode } : {}),
+          ...(payload.priority !== undefined ? { priority: payload.priority } : {}),
+          ...(payload.scenario !== undefined ? { scenario: payload.scenario } : {}),
+          ...(payload.capture !== undefined ? { capture: payload.capture } : {})
         })).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            )),
-          Effect.catchTag("StubNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Stub not found", resourceType: "stub", resourceId: e.stubId })
-            ))
+          Effect.catchTag("ImposterNotFoundError", imposterNotFound),
+          Effect.catchTag("StubNotFoundError", (e) => Effect.fail(notFoundError("stub_not_found", e.stubId)))
         )
 
         // Hot-reload if running
@@ -295,25 +819,28 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
           yield* imposterServer.updateStubs(path.imposterId)
         }
 
+        if (before !== undefined) {
+          const changes = diffFields(before, result, [
+            "predicates",
+            "responses",
+            "responseMode",
+            "priority",
+            "scenario",
+            "capture"
+          ])
+          yield* eventBus.publish("stub.updated", path.imposterId, { stubId: result.id, changes })
+        }
         return result
       }))
     .handle("deleteStub", ({ path }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
         const imposterServer = yield* ImposterServer
+        const eventBus = yield* EventBus
 
         const result = yield* repo.removeStub(path.imposterId, path.stubId).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            )),
-          Effect.catchTag(
-            "StubNotFoundError",
-            (e) =>
-              Effect.fail(
-                new ApiNotFoundError({ message: "Stub not found", resourceType: "stub", resourceId: e.stubId })
-              )
-          )
+          Effect.catchTag("ImposterNotFoundError", imposterNotFound),
+          Effect.catchTag("StubNotFoundError", (e) => Effect.fail(notFoundError("stub_not_found", e.stubId)))
         )
 
         // Hot-reload if running
@@ -322,66 +849,193 @@ export const ImpostersHandlersLive = HttpApiBuilder.group(AdminApi, "imposters",
           yield* imposterServer.updateStubs(path.imposterId)
         }
 
+        yield* eventBus.publish("stub.removed", path.imposterId, { stubId: result.id, stub: result })
         return result
       }))
     .handle("listRequests", ({ path, urlParams }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
         const requestLogger = yield* RequestLogger
-        yield* repo.get(path.id).pipe(
-          Effect.catchTag(
-            "ImposterNotFoundError",
-            (e) =>
-              Effect.fail(
-                new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-              )
-          )
-        )
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
         return yield* requestLogger.getEntries(path.id, {
           limit: urlParams.limit,
+          offset: urlParams.offset,
           ...(urlParams.method !== undefined ? { method: urlParams.method } : {}),
           ...(urlParams.path !== undefined ? { path: urlParams.path } : {}),
-          ...(urlParams.status !== undefined ? { status: urlParams.status } : {})
+          ...(urlParams.status !== undefined ? { status: urlParams.status } : {}),
+          ...(urlParams.matched !== undefined ? { matched: urlParams.matched } : {})
         })
       }))
-    .handle("clearRequests", ({ path }) =>
+    .handle("listOutbound", ({ path, urlParams }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const requestLogger = yield* RequestLogger
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* requestLogger.getOutbound(path.id, {
+          limit: urlParams.limit,
+          ...(urlParams.triggeredBy !== undefined ? { triggeredBy: urlParams.triggeredBy } : {})
+        })
+      }))
+    .handle("promoteRequest", ({ path, payload }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
         const requestLogger = yield* RequestLogger
-        yield* repo.get(path.id).pipe(
-          Effect.catchTag(
-            "ImposterNotFoundError",
-            (e) =>
-              Effect.fail(
-                new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-              )
+        const imposterServer = yield* ImposterServer
+        const eventBus = yield* EventBus
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+
+        const entry = yield* requestLogger.getEntryById(path.id, path.requestId)
+        if (entry === null) {
+          return yield* Effect.fail(notFoundError("request_not_found", path.requestId))
+        }
+        if (entry.response.matchedStubId !== undefined) {
+          return yield* Effect.fail(
+            conflictError("request_matched", `Request was matched by stub ${entry.response.matchedStubId}`)
           )
+        }
+
+        const stub = yield* repo.transaction((tx) => addNewStub(tx, path.id, promotedStub(entry, payload))).pipe(
+          Effect.catchTag("ImposterNotFoundError", imposterNotFound)
         )
+        if (yield* imposterServer.isRunning(path.id)) {
+          yield* imposterServer.updateStubs(path.id)
+        }
+        yield* eventBus.publish("stub.added", path.id, { stubId: stub.id, stub })
+        return stub
+      }))
+    .handle("clearRequests", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const requestLogger = yield* RequestLogger
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
         yield* requestLogger.clear(path.id)
         return { message: `Request log cleared for imposter ${path.id}` }
       }))
+    .handle("verifyRequests", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const requestLogger = yield* RequestLogger
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        const entries = yield* requestLogger.getEntries(path.id, { limit: Number.MAX_SAFE_INTEGER })
+        return verifyEntries(entries, payload)
+      }))
     .handle("getImposterStats", ({ path }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
         const metricsService = yield* MetricsService
-        yield* repo.get(path.id).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
-        )
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
         return yield* metricsService.getStats(path.id)
       }))
     .handle("resetImposterStats", ({ path }) =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
         const metricsService = yield* MetricsService
-        yield* repo.get(path.id).pipe(
-          Effect.catchTag("ImposterNotFoundError", (e) =>
-            Effect.fail(
-              new ApiNotFoundError({ message: "Imposter not found", resourceType: "imposter", resourceId: e.id })
-            ))
-        )
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
         yield* metricsService.resetStats(path.id)
         return { message: `Statistics reset for imposter ${path.id}` }
+      }))
+    .handle("listScenarios", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const stubs = yield* repo.getStubs(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return scenarioStates(stubs, yield* imposterServer.scenarios(path.id))
+      }))
+    .handle("setScenario", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const stubs = yield* repo.getStubs(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        if (!stubs.some((stub) => stub.scenario?.name === path.name)) {
+          return yield* Effect.fail(notFoundError("scenario_not_found", path.name))
+        }
+        yield* imposterServer.setScenario(path.id, path.name, payload.state)
+        return scenarioStates(stubs, yield* imposterServer.scenarios(path.id))
+      }))
+    .handle("resetScenarios", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const stubs = yield* repo.getStubs(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        yield* imposterServer.resetScenarios(path.id)
+        return scenarioStates(stubs, {})
+      }))
+    .handle("scenarioHistory", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.scenarioHistory(path.id)
+      }))
+    .handle("verifyScenario", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const stubs = yield* repo.getStubs(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        const history = yield* imposterServer.scenarioHistory(path.id)
+        if (
+          !stubs.some((stub) => stub.scenario?.name === payload.name) &&
+          !history.some((transition) => transition.scenario === payload.name)
+        ) {
+          return yield* Effect.fail(notFoundError("scenario_not_found", payload.name))
+        }
+        const states = scenarioStates(stubs, yield* imposterServer.scenarios(path.id))
+        return verifyScenario(history, stateOf(states, payload.name), payload)
+      }))
+    .handle("getState", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.store(path.id)
+      }))
+    .handle("replaceState", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.replaceStore(path.id, payload)
+      }))
+    .handle("mergeState", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.mergeStore(path.id, payload)
+      }))
+    .handle("clearState", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        yield* imposterServer.clearStore(path.id)
+        return {}
+      }))
+    .handle("getClock", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.clock(path.id)
+      }))
+    .handle("setClock", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.setClock(path.id, DateTime.toEpochMillis(payload.now))
+      }))
+    .handle("advanceClock", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.advanceClock(path.id, payload.ms)
+      }))
+    .handle("clearClock", ({ path }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        yield* repo.get(path.id).pipe(Effect.catchTag("ImposterNotFoundError", imposterNotFound))
+        return yield* imposterServer.clearClock(path.id)
       })))
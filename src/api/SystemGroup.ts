@@ -1,12 +1,178 @@
-import { HttpApiEndpoint, HttpApiGroup } from "@effect/platform"
-import { HealthResponse, ServerInfoResponse } from "../schemas/ImposterSchema"
+import { HttpApiEndpoint, HttpApiGroup, HttpApiSchema } from "@effect/platform"
+import * as Schema from "effect/Schema"
+import { CallbackDelivery, FailedCallback } from "../schemas/CallbackSchema"
+import { ChaosConfig, ChaosResponse } from "../schemas/ChaosSchema"
+import { ExportDocument } from "../schemas/ConfigFileSchema"
+import { ErrorCatalogueEntry } from "../schemas/ErrorSchema"
+import { ImposterEvent } from "../schemas/EventSchema"
+import { FeatureFlags } from "../schemas/PresetSchema"
+import {
+  BulkResetResponse,
+  HealthResponse,
+  ProfileName,
+  ReadinessResponse,
+  ServerInfoResponse
+} from "../schemas/ImposterSchema"
+import { Profile, Profiles } from "../schemas/ProfileSchema"
+import { HeldRequests, ReleaseRequest, ReleaseResponse } from "../schemas/ReleaseSchema"
+import { CreateShareRequest, ShareResponse } from "../schemas/ShareSchema"
+import { TemplateVariables } from "../schemas/VariablesSchema"
+import { ApiConflictError, ApiNotFoundError } from "./ApiErrors"
+import {
+  ExportUrlParams,
+  FailedCallbacksUrlParams,
+  ListEventsUrlParams,
+  ReadyUrlParams,
+  StreamEventsUrlParams
+} from "./ApiSchemas"
 
 export const SystemGroup = HttpApiGroup.make("system", { topLevel: true })
   .add(
     HttpApiEndpoint.get("healthCheck", "/health")
       .addSuccess(HealthResponse)
   )
+  .add(
+    HttpApiEndpoint.get("ready", "/ready")
+      .setUrlParams(ReadyUrlParams)
+      .addSuccess(ReadinessResponse)
+  )
   .add(
     HttpApiEndpoint.get("serverInfo", "/info")
       .addSuccess(ServerInfoResponse)
   )
+  .add(
+    HttpApiEndpoint.get("listEvents", "/events")
+      .setUrlParams(ListEventsUrlParams)
+      .addSuccess(Schema.Array(ImposterEvent))
+  )
+  .add(
+    // Server-sent events: one `event: <type>` message per change, as it happens
+    HttpApiEndpoint.get("streamEvents", "/events/stream")
+      .setUrlParams(StreamEventsUrlParams)
+      .addSuccess(HttpApiSchema.Text({ contentType: "text/event-stream" }))
+  )
+  .add(
+    // Dead letters: webhook callbacks that failed every attempt
+    HttpApiEndpoint.get("listFailedCallbacks", "/callbacks/failed")
+      .setUrlParams(FailedCallbacksUrlParams)
+      .addSuccess(Schema.Array(FailedCallback))
+  )
+  .add(
+    HttpApiEndpoint.post("retryFailedCallback")`/callbacks/failed/${HttpApiSchema.param("id", Schema.String)}/retry`
+      .addSuccess(CallbackDelivery)
+      .addError(ApiNotFoundError)
+  )
+  .add(
+    HttpApiEndpoint.del("clearFailedCallbacks", "/callbacks/failed")
+      .setUrlParams(FailedCallbacksUrlParams)
+      .addSuccess(BulkResetResponse)
+  )
+  .add(
+    // Variables shared by every imposter's templates
+    HttpApiEndpoint.get("getVariables", "/variables")
+      .addSuccess(TemplateVariables)
+  )
+  .add(
+    HttpApiEndpoint.put("replaceVariables", "/variables")
+      .setPayload(TemplateVariables)
+      .addSuccess(TemplateVariables)
+  )
+  .add(
+    // Sets the given variables and keeps the others; null removes one
+    HttpApiEndpoint.patch("mergeVariables", "/variables")
+      .setPayload(TemplateVariables)
+      .addSuccess(TemplateVariables)
+  )
+  .add(
+    // The feature flags served by the flags preset
+    HttpApiEndpoint.get("getFlags", "/flags")
+      .addSuccess(FeatureFlags)
+  )
+  .add(
+    HttpApiEndpoint.put("replaceFlags", "/flags")
+      .setPayload(FeatureFlags)
+      .addSuccess(FeatureFlags)
+  )
+  .add(
+    // Sets the given flags and keeps the others; null removes one
+    HttpApiEndpoint.patch("mergeFlags", "/flags")
+      .setPayload(FeatureFlags)
+      .addSuccess(FeatureFlags)
+  )
+  .add(
+    // Settings and stubs imposters can be created from
+    HttpApiEndpoint.get("listProfiles", "/profiles")
+      .addSuccess(Profiles)
+  )
+  .add(
+    // A profile extending one that doesn't exist, or itself, is refused
+    HttpApiEndpoint.put("putProfile")`/profiles/${HttpApiSchema.param("name", ProfileName)}`
+      .setPayload(Profile)
+      .addSuccess(Profile)
+      .addError(ApiNotFoundError)
+      .addError(ApiConflictError)
+  )
+  .add(
+    // Imposters created from it keep what they got; a profile others extend can't be removed
+    HttpApiEndpoint.del("deleteProfile")`/profiles/${HttpApiSchema.param("name", ProfileName)}`
+      .addSuccess(Profile)
+      .addError(ApiNotFoundError)
+      .addError(ApiConflictError)
+  )
+  .add(
+    // Failures and latency layered over every imposter's matched requests
+    HttpApiEndpoint.get("getChaos", "/chaos")
+      .addSuccess(ChaosResponse)
+  )
+  .add(
+    HttpApiEndpoint.put("setChaos", "/chaos")
+      .setPayload(ChaosConfig)
+      .addSuccess(ChaosResponse)
+  )
+  .add(
+    // Back to the stubs' own behaviour
+    HttpApiEndpoint.del("clearChaos", "/chaos")
+      .addSuccess(ChaosResponse)
+  )
+  .add(
+    // Keys long-polling requests are held on, with how many wait on each
+    HttpApiEndpoint.get("listHeld", "/releases")
+      .addSuccess(HeldRequests)
+  )
+  .add(
+    // Lets go of every request held on the key; none waiting is not an error
+    HttpApiEndpoint.post("release")`/releases/${HttpApiSchema.param("key", Schema.String)}`
+      .setPayload(ReleaseRequest)
+      .addSuccess(ReleaseResponse)
+  )
+  .add(
+    // Every imposter, variable and profile, as a config file that recreates them, as Go test code, or as a
+    // curl script or HTTP file that recreates them route by route
+    HttpApiEndpoint.get("exportConfig", "/export")
+      .setUrlParams(ExportUrlParams)
+      .addSuccess(ExportDocument)
+      .addSuccess(HttpApiSchema.Text({ contentType: "text/x-go" }))
+      .addSuccess(HttpApiSchema.Text({ contentType: "text/x-shellscript" }))
+      .addSuccess(HttpApiSchema.Text({ contentType: "text/plain" }))
+  )
+  .add(
+    // A read-only link to the admin UI and API, for sharing the mocks' state with someone debugging them
+    HttpApiEndpoint.post("createShare", "/shares")
+      .setPayload(CreateShareRequest)
+      .addSuccess(ShareResponse, { status: 201 })
+      .addError(ApiConflictError)
+  )
+  .add(
+    // Every error code the admin API fails with, its status and what to do about it
+    HttpApiEndpoint.get("listErrors", "/errors")
+      .addSuccess(Schema.Array(ErrorCatalogueEntry))
+  )
+  .add(
+    // JSON Schemas for authoring config files and stubs in an editor
+    HttpApiEndpoint.get("configSchema", "/schema/config.json")
+      .addSuccess(Schema.Record({ key: Schema.String, value: Schema.Unknown }))
+  )
+  .add(
+    HttpApiEndpoint.get("stubSchema", "/schema/stub.json")
+      .addSuccess(Schema.Record({ key: Schema.String, value: Schema.Unknown }))
+  )
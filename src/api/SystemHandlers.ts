@@ -1,12 +1,61 @@
-import { HttpApiBuilder } from "@effect/platform"
+import { Headers, HttpApiBuilder, HttpServerRequest, HttpServerResponse } from "@effect/platform"
 import * as Clock from "effect/Clock"
 import * as DateTime from "effect/DateTime"
 import * as Duration from "effect/Duration"
 import * as Effect from "effect/Effect"
+import * as Option from "effect/Option"
+import * as Redacted from "effect/Redacted"
+import * as Schema from "effect/Schema"
+import * as Stream from "effect/Stream"
+import { hoistBodies } from "../domain/bodies"
+import { curlScript } from "../exporters/Curl"
+import { goSource } from "../exporters/Go"
+import { httpFile } from "../exporters/Http"
+import { flagsOf, updateFlags } from "../presets/Flags"
 import { ImposterRepository } from "../repositories/ImposterRepository"
+import type { ChaosConfig, ChaosResponse } from "../schemas/ChaosSchema"
 import { NonEmptyString, PortNumber } from "../schemas/common"
+import { ConfigFile, editorJsonSchema, ExportDocument } from "../schemas/ConfigFileSchema"
+import type { ErrorCode } from "../schemas/ErrorSchema"
+import { ImposterEvent } from "../schemas/EventSchema"
+import type { ImposterReadiness } from "../schemas/ImposterSchema"
+import { CreateStubRequest } from "../schemas/StubSchema"
+import { ImposterServer } from "../server/ImposterServer"
+import { dependencyTargets, probeTarget } from "../server/Readiness"
+import { issueShare } from "../server/Shares"
 import { AppConfig } from "../services/AppConfig"
+import { CallbackService } from "../services/CallbackService"
+import { EventBus } from "../services/EventBus"
+import { Profiles } from "../services/Profiles"
+import { Variables } from "../services/Variables"
 import { AdminApi } from "./AdminApi"
+import { conflictError, ERROR_CATALOGUE, notFoundError } from "./ApiErrors"
+import { profileCycle, profileNotFound, toImposterConfigEntry } from "./Conversions"
+
+// Sent first so clients know the subscription is live, then periodically to keep proxies from timing out
+const SSE_CONNECTED = ": connected\n\n"
+const SSE_KEEPALIVE = ": keep-alive\n\n"
+
+const encodeEvent = Schema.encodeSync(ImposterEvent)
+const encodeExport = Schema.encodeSync(ExportDocument)
+
+// Everything the server holds, in port order, with large bodies served more than once written once
+const exportDocument = Effect.gen(function*() {
+  const records = yield* Effect.flatMap(ImposterRepository, (repo) => repo.getAll)
+  const imposters = [...records].sort((a, b) => a.config.port - b.config.port).map(toImposterConfigEntry)
+  // Shared across imposters too, then dealt back out in order
+  const { bodies, stubs } = hoistBodies(imposters.flatMap((imp) => imp.stubs))
+  let next = 0
+  return {
+    variables: yield* Effect.flatMap(Variables, (variables) => variables.get),
+    profiles: yield* Effect.flatMap(Profiles, (profiles) => profiles.getAll),
+    imposters: imposters.map((imp) => ({ ...imp, stubs: stubs.slice(next, next += imp.stubs.length) })),
+    bodies
+  }
+})
+
+const chaosStatus = (chaos: ChaosConfig | undefined): ChaosResponse =>
+  chaos !== undefined ? { enabled: true, chaos } : { enabled: false }
 
 export const SystemHandlersLive = HttpApiBuilder.group(AdminApi, "system", (handlers) =>
   handlers
@@ -44,6 +93,37 @@ export const SystemHandlersLive = HttpApiBuilder.group(AdminApi, "system", (hand
           }
         }
       }))
+    .handle("ready", ({ urlParams }) =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const imposterServer = yield* ImposterServer
+        const all = yield* repo.getAll
+
+        const imposters: Array<ImposterReadiness> = []
+        for (const record of all) {
+          const { config } = record
+          const running = yield* imposterServer.isRunning(config.id)
+          const dependencies = urlParams.probe && config.status === "running"
+            ? yield* Effect.forEach(dependencyTargets(record), (t) => probeTarget(t, urlParams.timeout), {
+              concurrency: "unbounded"
+            })
+            : []
+          imposters.push({
+            id: NonEmptyString.make(config.id),
+            name: NonEmptyString.make(config.name),
+            health: config.status !== "running" ? "stopped" : running ? "up" : "down",
+            dependencies
+          })
+        }
+
+        const now = yield* Effect.map(Clock.currentTimeMillis, (ms) => DateTime.unsafeMake(ms))
+        return {
+          // Stopped imposters were stopped on purpose and don't block readiness
+          ready: imposters.every((i) => i.health !== "down" && i.dependencies.every((d) => d.reachable)),
+          checkedAt: now,
+          imposters
+        }
+      }))
     .handle("serverInfo", () =>
       Effect.gen(function*() {
         const config = yield* AppConfig
@@ -55,7 +135,7 @@ export const SystemHandlersLive = HttpApiBuilder.group(AdminApi, "system", (hand
             version: NonEmptyString.make("0.0.0"),
             buildTime: now,
             platform: NonEmptyString.make(process.platform),
-            protocols: ["HTTP" as const]
+            protocols: ["HTTP" as const, "HTTPS" as const]
           },
           configuration: {
             maxImposters: config.maxImposters,
@@ -73,4 +153,124 @@ export const SystemHandlersLive = HttpApiBuilder.group(AdminApi, "system", (hand
             clustering: false
           }
         }
-      })))
+      }))
+    .handle("listEvents", ({ urlParams }) =>
+      Effect.gen(function*() {
+        const eventBus = yield* EventBus
+        return yield* eventBus.recent({
+          limit: urlParams.limit,
+          ...(urlParams.imposterId !== undefined ? { imposterId: urlParams.imposterId } : {}),
+          ...(urlParams.type !== undefined ? { type: urlParams.type } : {})
+        })
+      }))
+    .handleRaw("streamEvents", ({ urlParams }) =>
+      Effect.gen(function*() {
+        const eventBus = yield* EventBus
+        const events = Stream.unwrapScoped(
+          Effect.map(eventBus.subscribe, (queue) =>
+            Stream.concat(
+              Stream.make(SSE_CONNECTED),
+              Stream.fromQueue(queue).pipe(
+                Stream.filter((e) =>
+                  (urlParams.imposterId === undefined || e.imposterId === urlParams.imposterId) &&
+                  (urlParams.type === undefined || e.type === urlParams.type)
+                ),
+                Stream.map((e) => `event: ${e.type}\ndata: ${JSON.stringify(encodeEvent(e))}\n\n`),
+                Stream.merge(Stream.tick("15 seconds").pipe(Stream.drop(1), Stream.as(SSE_KEEPALIVE)))
+              )
+            ))
+        )
+        return HttpServerResponse.stream(Stream.encodeText(events), {
+          contentType: "text/event-stream",
+          headers: Headers.fromInput({ "cache-control": "no-cache" })
+        })
+      })
+    .handle("listFailedCallbacks", ({ urlParams }) =>
+      Effect.gen(function*() {
+        const callbacks = yield* CallbackService
+        return yield* callbacks.failed(urlParams.imposterId !== undefined ? { imposterId: urlParams.imposterId } : {})
+      }))
+    .handle("retryFailedCallback", ({ path }) =>
+      Effect.gen(function*() {
+        const callbacks = yield* CallbackService
+        return yield* callbacks.redrive(path.id).pipe(
+          Effect.mapError((e) => notFoundError("callback_not_found", e.id))
+        )
+      }))
+    .handle("clearFailedCallbacks", ({ urlParams }) =>
+      Effect.gen(function*() {
+        const callbacks = yield* CallbackService
+        const count = yield* callbacks.clearFailed(urlParams.imposterId)
+        return { message: `Cleared ${count} failed callbacks`, count }
+      }))
+    .handle("getVariables", () => Effect.flatMap(Variables, (variables) => variables.get))
+    .handle("replaceVariables", ({ payload }) => Effect.flatMap(Variables, (variables) => variables.replace(payload)))
+    .handle("mergeVariables", ({ payload }) => Effect.flatMap(Variables, (variables) => variables.merge(payload)))
+    .handle("getFlags", () => Effect.map(Effect.flatMap(Variables, (variables) => variables.get), flagsOf))
+    .handle("replaceFlags", ({ payload }) => updateFlags(() => payload))
+    .handle("mergeFlags", ({ payload }) => updateFlags((current) => ({ ...current, ...payload })))
+    .handle("listProfiles", () => Effect.flatMap(Profiles, (profiles) => profiles.getAll))
+    .handle("putProfile", ({ path, payload }) =>
+      Effect.flatMap(Profiles, (profiles) => profiles.set(path.name, payload)).pipe(
+        Effect.catchTags({ ProfileNotFoundError: profileNotFound, ProfileCycleError: profileCycle })
+      ))
+    .handle("deleteProfile", ({ path }) =>
+      Effect.flatMap(Profiles, (profiles) => profiles.remove(path.name)).pipe(
+        Effect.catchTags({
+          ProfileNotFoundError: profileNotFound,
+          ProfileInUseError: (e) =>
+            Effect.fail(conflictError("profile_in_use", `Profile is extended by ${e.extendedBy.join(", ")}`))
+        })
+      ))
+    .handle("getChaos", () => Effect.map(Effect.flatMap(ImposterServer, (server) => server.chaos), chaosStatus))
+    .handle("setChaos", ({ payload }) =>
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.setChaos(payload)
+        return chaosStatus(payload)
+      }))
+    .handle("clearChaos", () =>
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.setChaos(undefined)
+        return chaosStatus(undefined)
+      }))
+    .handle("listHeld", () => Effect.flatMap(ImposterServer, (server) => server.held))
+    .handle("release", ({ path, payload }) =>
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        const released = yield* server.release(path.key, payload)
+        return { key: path.key, released }
+      }))
+    .handleRaw("exportConfig", ({ urlParams }) =>
+      Effect.map(exportDocument, (document) => {
+        switch (urlParams.format) {
+          case "go":
+            return HttpServerResponse.text(goSource(encodeExport(document), urlParams.package), {
+              contentType: "text/x-go; charset=utf-8"
+            })
+          case "curl":
+            return HttpServerResponse.text(curlScript(document), { contentType: "text/x-shellscript; charset=utf-8" })
+          case "http":
+            return HttpServerResponse.text(httpFile(document), { contentType: "text/plain; charset=utf-8" })
+          case "json":
+            return HttpServerResponse.unsafeJson(encodeExport(document))
+        }
+      }))
+    .handle("createShare", ({ payload }) =>
+      Effect.gen(function*() {
+        const config = yield* AppConfig
+        const secret = Option.getOrElse(Option.map(config.adminToken, Redacted.value), () => "")
+        if (secret === "") return yield* Effect.fail(conflictError("admin_unprotected", "ADMIN_TOKEN is not set"))
+        const request = yield* HttpServerRequest.HttpServerRequest
+        const expiresAt = (yield* Clock.currentTimeMillis) + payload.ttl * 1000
+        const token = issueShare(secret, expiresAt)
+        const host = request.headers["host"] ?? `localhost:${config.adminPort}`
+        return { token, expiresAt: DateTime.unsafeMake(expiresAt), url: `http://${host}/_ui?token=${token}` }
+      }))
+    .handle("listErrors", () =>
+      Effect.succeed(
+        Object.entries(ERROR_CATALOGUE).map(([code, entry]) => ({ code: code as ErrorCode, ...entry }))
+      ))
+    .handle("configSchema", () => Effect.sync(() => editorJsonSchema(ConfigFile)))
+    .handle("stubSchema", () => Effect.sync(() => editorJsonSchema(CreateStubRequest))))
@@ -0,0 +1,191 @@
+import { Data, Effect } from "effect"
+import { spawn } from "node:child_process"
+import * as fs from "node:fs"
+import * as os from "node:os"
+import * as path from "node:path"
+import { createCertificateAuthority, issueCertificate } from "../server/Certificates"
+import type { TlsCertificate } from "../server/Tls"
+
+export const CA_NAME = "Imposters local CA"
+
+export class CaError extends Data.TaggedError("CaError")<{
+  readonly message: string
+  readonly cause?: unknown
+}> {}
+
+export const caDirPath = (): string => process.env.IMPOSTERS_CA_DIR ?? path.join(os.homedir(), ".imposters", "ca")
+
+export const caFiles = (dir: string) => ({ cert: path.join(dir, "ca.crt"), key: path.join(dir, "ca.key") })
+
+/**
+ * Create the local CA in `dir`. An existing CA is kept unless `force` is set, since
+ * replacing it untrusts every certificate it issued.
+ */
+export const initCa = (dir: string, options: { readonly force: boolean }): Effect.Effect<string, CaError> =>
+  Effect.gen(function*() {
+    const files = caFiles(dir)
+    if (!options.force && fs.existsSync(files.cert)) {
+      return yield* Effect.fail(
+        new CaError({ message: `A local CA already exists in ${dir} (use --force to replace it)` })
+      )
+    }
+    const ca = createCertificateAuthority(CA_NAME)
+    yield* Effect.try({
+      try: () => {
+        fs.mkdirSync(dir, { recursive: true })
+        fs.writeFileSync(files.key, ca.key, { mode: 0o600 })
+        fs.writeFileSync(files.cert, ca.cert)
+      },
+      catch: (error) => new CaError({ message: `Failed to write the local CA to ${dir}`, cause: error })
+    })
+    return files.cert
+  })
+
+export const loadCa = (dir: string): Effect.Effect<TlsCertificate, CaError> => {
+  const files = caFiles(dir)
+  if (!fs.existsSync(files.cert) || !fs.existsSync(files.key)) {
+    return Effect.fail(new CaError({ message: `No local CA in ${dir} (run \`imposters ca init\` first)` }))
+  }
+  return Effect.try({
+    try: () => ({ cert: fs.readFileSync(files.cert, "utf-8"), key: fs.readFileSync(files.key, "utf-8") }),
+    catch: (error) => new CaError({ message: `Failed to read the local CA from ${dir}`, cause: error })
+  })
+}
+
+/**
+ * A certificate for `hosts` signed by the local CA, in the shape of an imposter's `tls`.
+ */
+export const mintCertificate = (
+  dir: string,
+  hosts: ReadonlyArray<string>
+): Effect.Effect<TlsCertificate, CaError> =>
+  loadCa(dir).pipe(
+    Effect.flatMap((ca) =>
+      Effect.try({
+        try: () => issueCertificate(ca, hosts),
+        catch: (error) => new CaError({ message: "Failed to issue a certificate", cause: error })
+      })
+    )
+  )
+
+// One way to trust the CA; `install` steps are what `ca trust --install` runs
+export interface TrustStep {
+  readonly description: string
+  readonly commands: ReadonlyArray<ReadonlyArray<string>>
+  readonly install: boolean
+}
+
+/**
+ * How to trust `certPath` on `platform`: the OS store first, then clients that keep
+ * their own. `exists` probes for the Linux distribution's anchor directory.
+ */
+export const trustSteps = (
+  certPath: string,
+  platform: NodeJS.Platform,
+  exists: (p: string) => boolean = fs.existsSync
+): Array<TrustStep> => {
+  const steps: Array<TrustStep> = []
+  if (platform === "darwin") {
+    steps.push({
+      description: "macOS keychain (Safari, Chrome, curl, and Firefox with enterprise roots enabled)",
+      commands: [[
+        "sudo",
+        "security",
+        "add-trusted-cert",
+        "-d",
+        "-r",
+        "trustRoot",
+        "-k",
+        "/Library/Keychains/System.keychain",
+        certPath
+      ]],
+      install: true
+    })
+  } else if (platform === "win32") {
+    steps.push({
+      description: "Windows certificate store for the current user (Edge, Chrome)",
+      commands: [["certutil", "-user", "-addstore", "Root", certPath]],
+      install: true
+    })
+  } else if (exists("/usr/local/share/ca-certificates")) {
+    steps.push({
+      description: "System trust store (Debian, Ubuntu, Alpine)",
+      commands: [
+        ["sudo", "cp", certPath, "/usr/local/share/ca-certificates/imposters-local-ca.crt"],
+        ["sudo", "update-ca-certificates"]
+      ],
+      install: true
+    })
+  } else if (exists("/etc/pki/ca-trust/source/anchors")) {
+    steps.push({
+      description: "System trust store (Fedora, RHEL)",
+      commands: [
+        ["sudo", "cp", certPath, "/etc/pki/ca-trust/source/anchors/imposters-local-ca.crt"],
+        ["sudo", "update-ca-trust"]
+      ],
+      install: true
+    })
+  } else {
+    steps.push({
+      description: "System trust store (p11-kit, e.g. Arch)",
+      commands: [["sudo", "trust", "anchor", "--store", certPath]],
+      install: true
+    })
+  }
+  if (platform === "linux") {
+    steps.push({
+      description: "Chrome and Firefox on Linux read their own NSS database (certutil is in libnss3-tools / nss-tools)",
+      commands: [[
+        "certutil",
+        "-d",
+        `sql:${path.join(os.homedir(), ".pki", "nssdb")}`,
+        "-A",
+        "-t",
+        "C,,",
+        "-n",
+        CA_NAME,
+        "-i",
+        certPath
+      ]],
+      install: false
+    })
+  }
+  steps.push(
+    {
+      description: "Node.js ignores the OS store; point it at the CA",
+      commands: [["export", `NODE_EXTRA_CA_CERTS=${certPath}`]],
+      install: false
+    },
+    {
+      description: "Or pass it per request, e.g. with curl",
+      commands: [["curl", "--cacert", certPath, "https://localhost:8443/"]],
+      install: false
+    }
+  )
+  return steps
+}
+
+// POSIX shell quoting, for printing commands people can paste
+export const shellQuote = (arg: string): string =>
+  /^[\w@%+=:,./-]+$/.test(arg) ? arg : `'${arg.replace(/'/g, "'\\''")}'`
+
+const runCommand = (argv: ReadonlyArray<string>): Effect.Effect<void, CaError> =>
+  Effect.async<void, CaError>((resume) => {
+    const [command = "", ...args] = argv
+    const child = spawn(command, args, { stdio: "inherit" })
+    child.once("error", (err) => resume(Effect.fail(new CaError({ message: `Failed to run ${command}`, cause: err }))))
+    child.once("exit", (code) =>
+      resume(
+        code === 0
+          ? Effect.void
+          : Effect.fail(new CaError({ message: `${argv.map(shellQuote).join(" ")} exited with code ${code}` }))
+      ))
+  })
+
+/**
+ * Run the `install` steps' commands in order, stopping at the first failure.
+ */
+export const installTrust = (steps: ReadonlyArray<TrustStep>): Effect.Effect<void, CaError> =>
+  Effect.forEach(steps.filter((step) => step.install).flatMap((step) => step.commands), runCommand, {
+    discard: true
+  })
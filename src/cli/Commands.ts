@@ -1,12 +1,43 @@
-import { Command, Options } from "@effect/cli"
+import { Args, Command, Options } from "@effect/cli"
 import { NodeContext, NodeRuntime } from "@effect/platform-node"
-import { Effect, Layer, Option } from "effect"
+import { DateTime, Effect, Layer, Option, Schema } from "effect"
+import * as fs from "node:fs"
+import { DurationFromString } from "../api/ApiSchemas"
 import { HandlerHttpClientLive } from "../client/HandlerHttpClient"
-import { ImpostersClient, ImpostersClientLive } from "../client/ImpostersClient"
+import { ImpostersClient, ImpostersClientFetchLive, ImpostersClientLive } from "../client/ImpostersClient"
+import { ImposterResponse } from "../schemas/ImposterSchema"
+import { PRESET_NAMES, PresetConfig } from "../schemas/PresetSchema"
+import { OutboundLogEntry, RequestLogEntry } from "../schemas/RequestLogSchema"
+import { CreateStubRequest, Stub } from "../schemas/StubSchema"
 import { makeCompositeHandler } from "../server/AdminServer"
 import { BunServerFactoryLive, NodeServerFactoryLive, ServerFactory } from "../server/ServerFactory"
-import { loadConfigFile } from "./ConfigLoader"
+import {
+  CaError,
+  caDirPath,
+  caFiles,
+  initCa,
+  installTrust,
+  loadCa,
+  mintCertificate,
+  shellQuote,
+  trustSteps
+} from "./CertificateAuthority"
+import { COMPLETION_SHELLS, type CompletionShell, completionScript } from "./Completion"
+import { ConfigLoadError, loadConfigFile, loadJsonFile, loadManifestFile } from "./ConfigLoader"
+import {
+  addContext,
+  contextsFilePath,
+  loadContexts,
+  removeContext,
+  resolveAdminUrl,
+  useContext
+} from "./Contexts"
+import { createImposters, environmentDown, environmentUp, putProfiles } from "./Environment"
+import { formatRows, imposterColumns, OUTPUT_FORMATS, outboundColumns, requestColumns, stubColumns } from "./Output"
+import { parsePortMapping, relay, sshReverseTunnel, TunnelError } from "./Tunnel"
+import { describeEvent, type ServerSentEvent, watchEvents } from "./Watch"
 import { version } from "./version"
+import { stubWizard } from "./Wizard"
 
 const configOption = Options.file("config").pipe(
   Options.withAlias("c"),
@@ -25,14 +56,34 @@ const runtimeOption = Options.choice("runtime", ["node", "bun"]).pipe(
   Options.withDefault("node" as const)
 )
 
+// Warm-up check: print each upstream target and whether it answered
+const reportReadiness = Effect.gen(function*() {
+  const client = yield* ImpostersClient
+  const report = yield* client.ready({ urlParams: { probe: true, timeout: 2000 } })
+  for (const imp of report.imposters) {
+    for (const dep of imp.dependencies) {
+      const detail = dep.reachable ? `HTTP ${dep.status}, ${dep.latencyMs}ms` : dep.error ?? "unreachable"
+      console.log(`  ${dep.reachable ? "ok  " : "FAIL"} ${imp.name} -> ${dep.url} (${detail})`)
+    }
+  }
+  console.log(report.ready ? "All dependencies reachable" : "Warning: some dependencies are not reachable")
+}).pipe(Effect.catchAll((e) => Effect.sync(() => console.error(`Readiness probe failed: ${e}`))))
+
+const probeOption = Options.boolean("probe").pipe(
+  Options.withDescription("After loading the config, probe proxy targets and report their reachability")
+)
+
+// The admin server requires this of every request when set, and the CLI sends it
+const adminToken = () => process.env.ADMIN_TOKEN || undefined
+
 const startCommand = Command.make(
   "start",
-  { config: configOption, port: portOption, runtime: runtimeOption },
-  ({ config, port, runtime }) =>
+  { config: configOption, port: portOption, runtime: runtimeOption, probe: probeOption },
+  ({ config, port, probe, runtime }) =>
     Effect.gen(function*() {
       const adminPort = Option.isSome(port) ? port.value : Number(process.env.ADMIN_PORT ?? 2525)
 
-      const { dispose, handler } = makeCompositeHandler(adminPort)
+      const { dispose, handler } = makeCompositeHandler(adminPort, adminToken())
 
       const serverFactory = yield* ServerFactory
       const server = serverFactory.create({ port: adminPort, fetch: handler })
@@ -50,52 +101,32 @@ const startCommand = Command.make(
             }))
         )
 
-        if (configData !== null && configData.imposters.length > 0) {
-          const clientLayer = ImpostersClientLive(`http://localhost:${server.port}`).pipe(
-            Layer.provide(HandlerHttpClientLive(handler))
-          )
+        const clientLayer = ImpostersClientLive(`http://localhost:${server.port}`, adminToken()).pipe(
+          Layer.provide(HandlerHttpClientLive(handler))
+        )
 
-          yield* Effect.provide(
-            Effect.gen(function*() {
-              const client = yield* ImpostersClient
-              for (const imp of configData.imposters) {
-                const created = yield* client.imposters.createImposter({
-                  payload: {
-                    port: imp.port,
-                    ...(imp.name !== undefined ? { name: imp.name } : {}),
-                    protocol: "HTTP" as const,
-                    adminPath: "/_admin"
-                  }
-                }).pipe(Effect.catchAll((e) => {
-                  console.error(`Failed to create imposter on port ${imp.port}: ${e}`)
-                  return Effect.succeed(null)
-                }))
-
-                if (created === null) continue
-
-                for (const stub of imp.stubs) {
-                  yield* client.imposters.addStub({
-                    path: { imposterId: created.id },
-                    payload: stub
-                  }).pipe(Effect.catchAll((e) => {
-                    console.error(`Failed to add stub: ${e}`)
-                    return Effect.void
-                  }))
-                }
-
-                yield* client.imposters.updateImposter({
-                  path: { id: created.id },
-                  payload: { status: "running" as const }
-                }).pipe(Effect.catchAll((e) => {
-                  console.error(`Failed to start imposter ${created.id}: ${e}`)
-                  return Effect.void
-                }))
-
-                console.log(`Created imposter "${imp.name ?? created.id}" on port ${imp.port}`)
-              }
-            }),
+        if (configData !== null && Object.keys(configData.variables).length > 0) {
+          const variables = configData.variables
+          const merge = Effect.flatMap(ImpostersClient, (client) => client.mergeVariables({ payload: variables }))
+          yield* Effect.provide(merge, clientLayer)
+        }
+
+        if (configData !== null && Object.keys(configData.profiles).length > 0) {
+          yield* Effect.provide(putProfiles(configData.profiles), clientLayer)
+        }
+
+        if (configData !== null && configData.imposters.length > 0) {
+          const created = yield* Effect.provide(
+            createImposters(configData.imposters, { bodies: configData.bodies }),
             clientLayer
           )
+          for (const imp of created) {
+            console.log(`Created imposter "${imp.name}" on port ${imp.port}`)
+          }
+
+          if (probe) {
+            yield* Effect.provide(reportReadiness, clientLayer)
+          }
         }
       }
 
@@ -115,8 +146,525 @@ const startCommand = Command.make(
     )
 )
 
+const adminUrlOption = Options.text("admin-url").pipe(
+  Options.withDescription("Admin server URL (overrides --ctx)"),
+  Options.optional
+)
+
+const ctxOption = Options.text("ctx").pipe(
+  Options.withDescription("Named context to target (default: the current context, else http://localhost:2525)"),
+  Options.optional
+)
+
+const withAdminClient = <A, E>(
+  options: { readonly adminUrl: Option.Option<string>; readonly ctx: Option.Option<string> },
+  effect: Effect.Effect<A, E, ImpostersClient>
+) =>
+  resolveAdminUrl(contextsFilePath(), options).pipe(
+    Effect.flatMap((url) => Effect.provide(effect, ImpostersClientFetchLive(url, adminToken())))
+  )
+
+const upCommand = Command.make(
+  "up",
+  {
+    manifest: Args.file({ name: "manifest", exists: "yes" }).pipe(
+      Args.withDescription("Environment manifest, a JSON or YAML file")
+    ),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption
+  },
+  ({ manifest, ...target }) =>
+    withAdminClient(
+      target,
+      Effect.gen(function*() {
+        const env = yield* loadManifestFile(manifest)
+        const created = yield* environmentUp(env)
+        for (const imp of created) {
+          console.log(`Started "${imp.name}" on port ${imp.port}`)
+        }
+        console.log(`Environment "${env.name}" is up (${created.length}/${env.imposters.length} imposters)`)
+      })
+    )
+)
+
+const downCommand = Command.make(
+  "down",
+  {
+    env: Args.text({ name: "manifest-or-name" }).pipe(
+      Args.withDescription("Environment manifest (a JSON or YAML file), or the environment name")
+    ),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption
+  },
+  ({ env, ...target }) =>
+    withAdminClient(
+      target,
+      Effect.gen(function*() {
+        const envName = fs.existsSync(env) ? (yield* loadManifestFile(env)).name : env
+        const removed = yield* environmentDown(envName)
+        for (const imp of removed) {
+          console.log(`Removed "${imp.name}" (port ${imp.port})`)
+        }
+        console.log(`Environment "${envName}" is down (${removed.length} imposters removed)`)
+      })
+    )
+)
+
+const watchOption = Options.boolean("watch").pipe(
+  Options.withAlias("w"),
+  Options.withDescription("Keep running and print again whenever the data changes")
+)
+
+// Print once, then (with --watch) reprint after every matching change event
+const printAndWatch = <E>(
+  target: { readonly adminUrl: Option.Option<string>; readonly ctx: Option.Option<string> },
+  watch: boolean,
+  filter: { readonly imposterId?: string; readonly prefix: string },
+  print: Effect.Effect<void, E, ImpostersClient>
+) =>
+  Effect.gen(function*() {
+    yield* print
+    if (!watch) return
+    const url = yield* resolveAdminUrl(contextsFilePath(), target)
+    const onEvent = (event: ServerSentEvent) =>
+      event.event.startsWith(filter.prefix)
+        ? Effect.sync(() => console.log(`\n# ${describeEvent(event)}`)).pipe(Effect.andThen(print))
+        : Effect.void
+    yield* watchEvents(url, filter, onEvent, adminToken())
+  })
+
+const outputOption = Options.choice("output", OUTPUT_FORMATS).pipe(
+  Options.withAlias("o"),
+  Options.withDescription("Output format: table (default), wide for extra columns, or json"),
+  Options.withDefault("table")
+)
+
+const listCommand = Command.make(
+  "list",
+  { adminUrl: adminUrlOption, ctx: ctxOption, watch: watchOption, output: outputOption },
+  ({ output, watch, ...target }) =>
+    withAdminClient(
+      target,
+      printAndWatch(
+        target,
+        watch,
+        { prefix: "imposter." },
+        Effect.gen(function*() {
+          const client = yield* ImpostersClient
+          const page = yield* client.imposters.listImposters({ urlParams: { limit: 1000, offset: 0 } })
+          console.log(formatRows(output, ImposterResponse, imposterColumns, page.imposters))
+        })
+      )
+    )
+)
+
+const stubsCommand = Command.make(
+  "stubs",
+  {
+    imposterId: Args.text({ name: "imposter-id" }),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption,
+    watch: watchOption,
+    output: outputOption
+  },
+  ({ imposterId, output, watch, ...target }) =>
+    withAdminClient(
+      target,
+      printAndWatch(
+        target,
+        watch,
+        { imposterId, prefix: "stub" },
+        Effect.gen(function*() {
+          const client = yield* ImpostersClient
+          const stubs = yield* client.imposters.listStubs({ path: { imposterId }, urlParams: {} })
+          console.log(formatRows(output, Stub, stubColumns, stubs))
+        })
+      )
+    )
+)
+
+const requestsCommand = Command.make(
+  "requests",
+  {
+    imposterId: Args.text({ name: "imposter-id" }),
+    unmatched: Options.boolean("unmatched").pipe(
+      Options.withDescription("Only requests no stub matched and no proxy answered")
+    ),
+    outbound: Options.boolean("outbound").pipe(
+      Options.withDescription("Requests the imposter sent itself: proxy forwards and callbacks")
+    ),
+    limit: Options.integer("limit").pipe(
+      Options.withDescription("Most recent requests to show (default: 50)"),
+      Options.withDefault(50)
+    ),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption,
+    output: outputOption
+  },
+  ({ imposterId, limit, outbound, output, unmatched, ...target }) =>
+    withAdminClient(
+      target,
+      Effect.gen(function*() {
+        const client = yield* ImpostersClient
+        if (outbound) {
+          const sent = yield* client.imposters.listOutbound({ path: { id: imposterId }, urlParams: { limit } })
+          console.log(formatRows(output, OutboundLogEntry, outboundColumns, sent))
+          return
+        }
+        const entries = yield* client.imposters.listRequests({
+          path: { id: imposterId },
+          urlParams: { limit, offset: 0, ...(unmatched ? { matched: false } : {}) }
+        })
+        console.log(formatRows(output, RequestLogEntry, requestColumns, entries))
+      })
+    )
+)
+
+const createStubCommand = Command.make(
+  "create-stub",
+  {
+    imposterId: Args.text({ name: "imposter-id" }),
+    interactive: Options.boolean("interactive").pipe(
+      Options.withAlias("i"),
+      Options.withDescription("Build the stub step by step, with a preview of the rendered response")
+    ),
+    file: Options.file("file").pipe(
+      Options.withAlias("f"),
+      Options.withDescription("JSON file holding the stub"),
+      Options.optional
+    ),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption
+  },
+  ({ file, imposterId, interactive, ...target }) =>
+    withAdminClient(
+      target,
+      Effect.gen(function*() {
+        if (Option.isSome(file) === interactive) {
+          return yield* Effect.fail(new ConfigLoadError({ message: "Specify exactly one of --interactive or --file" }))
+        }
+        const stub = Option.isSome(file)
+          ? yield* loadJsonFile(CreateStubRequest, file.value, "stub")
+          : yield* stubWizard
+        if (stub === undefined) return
+        const client = yield* ImpostersClient
+        const created = yield* client.imposters.addStub({ path: { imposterId }, payload: stub })
+        console.log(`Created stub ${created.id}`)
+      })
+    )
+)
+
+const deleteStubCommand = Command.make(
+  "delete-stub",
+  {
+    imposterId: Args.text({ name: "imposter-id" }),
+    stubId: Args.text({ name: "stub-id" }),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption
+  },
+  ({ imposterId, stubId, ...target }) =>
+    withAdminClient(
+      target,
+      Effect.gen(function*() {
+        const client = yield* ImpostersClient
+        const deleted = yield* client.imposters.deleteStub({ path: { imposterId, stubId } })
+        console.log(`Deleted stub ${deleted.id}`)
+      })
+    )
+)
+
+const applyPresetCommand = Command.make(
+  "apply-preset",
+  {
+    imposterId: Args.text({ name: "imposter-id" }),
+    preset: Args.choice(PRESET_NAMES.map((name): [string, string] => [name, name]), { name: "preset" }),
+    file: Options.file("file").pipe(
+      Options.withAlias("f"),
+      Options.withDescription("JSON file holding the preset's settings"),
+      Options.optional
+    ),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption
+  },
+  ({ file, imposterId, preset, ...target }) =>
+    withAdminClient(
+      target,
+      Effect.gen(function*() {
+        const settings = Option.isSome(file)
+          ? yield* loadJsonFile(Schema.Record({ key: Schema.String, value: Schema.Unknown }), file.value, "preset")
+          : {}
+        const payload = yield* Schema.decodeUnknown(PresetConfig)({ ...settings, preset }).pipe(
+          Effect.mapError((error) =>
+            new ConfigLoadError({ message: `Preset validation failed: ${String(error)}`, cause: error })
+          )
+        )
+        const client = yield* ImpostersClient
+        const stubs = yield* client.imposters.applyPreset({ path: { imposterId }, payload })
+        console.log(`Added ${stubs.length} stub(s) from the ${preset} preset`)
+      })
+    )
+)
+
+const pruneCommand = Command.make(
+  "prune",
+  {
+    imposterId: Args.text({ name: "imposter-id" }),
+    unusedFor: Options.text("unused-for").pipe(
+      Options.withDescription("Remove stubs not hit within this window, e.g. 30m, 24h or 7d")
+    ),
+    dryRun: Options.boolean("dry-run").pipe(Options.withDescription("List the stubs that would be removed")),
+    adminUrl: adminUrlOption,
+    ctx: ctxOption
+  },
+  ({ dryRun, imposterId, unusedFor, ...target }) =>
+    withAdminClient(
+      target,
+      Effect.gen(function*() {
+        const client = yield* ImpostersClient
+        const window = yield* Schema.decode(DurationFromString)(unusedFor)
+        const result = yield* client.imposters.pruneStubs({
+          path: { imposterId },
+          urlParams: { unusedFor: window, dryRun }
+        })
+        for (const stub of result.pruned) {
+          const lastHit = stub.lastHitAt !== undefined ? DateTime.formatIso(stub.lastHitAt) : "never"
+          console.log(`${stub.id}\t${stub.hits} hits\tlast hit ${lastHit}`)
+        }
+        const verb = result.dryRun ? "Would prune" : "Pruned"
+        console.log(`${verb} ${result.pruned.length} stub(s), ${result.remaining} remaining`)
+      })
+    )
+)
+
+const ctxAddCommand = Command.make(
+  "add",
+  { name: Args.text({ name: "name" }), url: Args.text({ name: "url" }) },
+  ({ name, url }) =>
+    addContext(contextsFilePath(), name, url).pipe(
+      Effect.andThen(Effect.sync(() => console.log(`Context "${name}" -> ${url}`)))
+    )
+)
+
+const ctxRemoveCommand = Command.make(
+  "remove",
+  { name: Args.text({ name: "name" }) },
+  ({ name }) =>
+    removeContext(contextsFilePath(), name).pipe(
+      Effect.andThen(Effect.sync(() => console.log(`Removed context "${name}"`)))
+    )
+)
+
+const ctxUseCommand = Command.make(
+  "use",
+  { name: Args.text({ name: "name" }) },
+  ({ name }) =>
+    useContext(contextsFilePath(), name).pipe(
+      Effect.andThen(Effect.sync(() => console.log(`Switched to context "${name}"`)))
+    )
+)
+
+const ctxListCommand = Command.make("list", {}, () =>
+  loadContexts(contextsFilePath()).pipe(
+    Effect.andThen((data) =>
+      Effect.sync(() => {
+        for (const [name, context] of Object.entries(data.contexts)) {
+          console.log(`${name === data.current ? "*" : " "} ${name}\t${context.url}`)
+        }
+      })
+    )
+  ))
+
+const ctxCommand = Command.make("ctx").pipe(
+  Command.withSubcommands([ctxAddCommand, ctxRemoveCommand, ctxUseCommand, ctxListCommand])
+)
+
+const tunnelCommand = Command.make(
+  "tunnel",
+  {
+    ports: Options.text("port").pipe(
+      Options.repeated,
+      Options.withDescription("Port to forward, as PORT or LOCAL:REMOTE (repeatable)")
+    ),
+    to: Options.text("to").pipe(
+      Options.withDescription("Relay local ports to imposters on this remote host"),
+      Options.optional
+    ),
+    ssh: Options.text("ssh").pipe(
+      Options.withDescription("SSH destination (user@host or ssh config alias) to expose local imposters on"),
+      Options.optional
+    ),
+    bind: Options.text("bind").pipe(
+      Options.withDescription("Local address to listen on with --to (default: 127.0.0.1)"),
+      Options.withDefault("127.0.0.1")
+    )
+  },
+  ({ bind, ports, ssh, to }) =>
+    Effect.gen(function*() {
+      if (ports.length === 0) {
+        return yield* Effect.fail(new TunnelError({ message: "At least one --port is required" }))
+      }
+      const mappings = yield* Effect.forEach(ports, parsePortMapping)
+
+      if (Option.isSome(ssh) === Option.isSome(to)) {
+        return yield* Effect.fail(new TunnelError({ message: "Specify exactly one of --to or --ssh" }))
+      }
+
+      if (Option.isSome(ssh)) {
+        for (const m of mappings) {
+          console.log(`Forwarding ${ssh.value}:${m.remote} -> localhost:${m.local}`)
+        }
+        return yield* sshReverseTunnel(ssh.value, mappings)
+      }
+
+      const targetHost = Option.getOrThrow(to)
+      yield* Effect.scoped(
+        Effect.gen(function*() {
+          for (const m of mappings) {
+            yield* relay(m, targetHost, bind)
+            console.log(`Forwarding ${bind}:${m.local} -> ${targetHost}:${m.remote}`)
+          }
+          return yield* Effect.never
+        })
+      )
+    })
+)
+
+const caInitCommand = Command.make(
+  "init",
+  { force: Options.boolean("force").pipe(Options.withDescription("Replace an existing local CA")) },
+  ({ force }) =>
+    initCa(caDirPath(), { force }).pipe(
+      Effect.andThen((certPath) =>
+        Effect.sync(() => {
+          console.log(`Created local CA: ${certPath}`)
+          console.log("Trust it with `imposters ca trust`, then mint certificates with `imposters ca export --host`")
+        })
+      )
+    )
+)
+
+const caExportCommand = Command.make(
+  "export",
+  {
+    hosts: Options.text("host").pipe(
+      Options.repeated,
+      Options.withDescription("Mint a certificate for this host name, *.wildcard or IP (repeatable)")
+    ),
+    out: Options.text("out").pipe(
+      Options.withAlias("o"),
+      Options.withDescription("Write to this file instead of stdout"),
+      Options.optional
+    )
+  },
+  ({ hosts, out }) =>
+    Effect.gen(function*() {
+      const dir = caDirPath()
+      // The CA certificate as PEM, or a minted certificate as an imposter's `tls` JSON
+      const content = hosts.length === 0
+        ? (yield* loadCa(dir)).cert
+        : JSON.stringify(yield* mintCertificate(dir, hosts), null, 2) + "\n"
+      if (Option.isNone(out)) return yield* Effect.sync(() => process.stdout.write(content))
+      yield* Effect.try({
+        try: () => fs.writeFileSync(out.value, content, hosts.length > 0 ? { mode: 0o600 } : {}),
+        catch: (error) => new CaError({ message: `Failed to write ${out.value}`, cause: error })
+      })
+      console.log(`Wrote ${out.value}`)
+    })
+)
+
+const caTrustCommand = Command.make(
+  "trust",
+  {
+    install: Options.boolean("install").pipe(
+      Options.withDescription("Add the CA to the system trust store (may prompt for sudo)")
+    )
+  },
+  ({ install }) =>
+    Effect.gen(function*() {
+      const dir = caDirPath()
+      yield* loadCa(dir)
+      const steps = trustSteps(caFiles(dir).cert, process.platform)
+      if (install) {
+        yield* installTrust(steps)
+        console.log("Added the local CA to the system trust store")
+      }
+      for (const step of steps.filter((s) => !(install && s.install))) {
+        console.log(`# ${step.description}`)
+        for (const argv of step.commands) console.log(argv.map(shellQuote).join(" "))
+        console.log("")
+      }
+    })
+)
+
+const caCommand = Command.make("ca").pipe(
+  Command.withSubcommands([caInitCommand, caExportCommand, caTrustCommand])
+)
+
+const completionCommand = Command.make(
+  "completion",
+  {
+    shell: Args.choice(COMPLETION_SHELLS.map((shell): [string, CompletionShell] => [shell, shell]), { name: "shell" })
+  },
+  ({ shell }) => Effect.sync(() => process.stdout.write(completionScript(shell)))
+)
+
+type CompletionKind = "imposters" | "stubs" | "presets" | "contexts"
+
+const COMPLETION_KINDS: ReadonlyArray<CompletionKind> = ["imposters", "stubs", "presets", "contexts"]
+
+const completionCandidates = (kind: CompletionKind, imposterId: Option.Option<string>) => {
+  if (kind === "contexts") {
+    return loadContexts(contextsFilePath()).pipe(Effect.map((data) => Object.keys(data.contexts)))
+  }
+  if (kind === "presets") return Effect.succeed(PRESET_NAMES)
+  return withAdminClient(
+    { adminUrl: Option.none(), ctx: Option.none() },
+    Effect.gen(function*() {
+      const client = yield* ImpostersClient
+      if (kind === "imposters") {
+        const page = yield* client.imposters.listImposters({ urlParams: { limit: 1000, offset: 0 } })
+        return page.imposters.map((imp) => imp.id)
+      }
+      // Stubs are those of the imposter given, and there are none to offer without one
+      if (Option.isNone(imposterId)) return []
+      const stubs = yield* client.imposters.listStubs({ path: { imposterId: imposterId.value }, urlParams: {} })
+      return stubs.map((stub) => stub.id)
+    })
+  ).pipe(Effect.timeout("2 seconds"))
+}
+
+// Called by the completion scripts: prints one candidate per line and stays silent on errors
+const completeCommand = Command.make(
+  "complete",
+  {
+    kind: Args.choice(COMPLETION_KINDS.map((kind): [string, CompletionKind] => [kind, kind]), { name: "kind" }),
+    imposterId: Args.text({ name: "imposter-id" }).pipe(Args.optional)
+  },
+  ({ imposterId, kind }) =>
+    completionCandidates(kind, imposterId).pipe(
+      Effect.flatMap((candidates) => Effect.sync(() => candidates.forEach((c) => console.log(c)))),
+      Effect.catchAll(() => Effect.void)
+    )
+)
+
 const command = Command.make("imposters").pipe(
-  Command.withSubcommands([startCommand])
+  Command.withSubcommands([
+    startCommand,
+    upCommand,
+    downCommand,
+    listCommand,
+    stubsCommand,
+    requestsCommand,
+    createStubCommand,
+    deleteStubCommand,
+    applyPresetCommand,
+    pruneCommand,
+    ctxCommand,
+    tunnelCommand,
+    caCommand,
+    completionCommand,
+    completeCommand
+  ])
 )
 
 export const run = Command.run(command, {
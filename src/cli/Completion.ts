@@ -0,0 +1,279 @@
+// Shell completion scripts for the CLI. Static parts (subcommands, flags, choices) are baked into
+// the script; imposter IDs, stub IDs, preset names and context names are fetched at completion time
+// via `imposters complete`.
+
+export type CompletionShell = "bash" | "zsh" | "fish"
+
+export const COMPLETION_SHELLS: ReadonlyArray<CompletionShell> = ["bash", "zsh", "fish"]
+
+// What a flag or argument completes to: fixed choices, file paths, values looked up on the fly, or free text.
+// "stubs" are those of the imposter named by the command's first argument.
+export type Completions = ReadonlyArray<string> | "files" | "imposters" | "stubs" | "presets" | "contexts" | "text"
+
+export interface FlagSpec {
+  readonly name: string
+  readonly alias?: string
+  // Absent for boolean flags
+  readonly values?: Completions
+}
+
+export interface CommandSpec {
+  readonly name: string
+  readonly flags: ReadonlyArray<FlagSpec>
+  // One per positional argument, in order; a single one completes every position
+  readonly args?: ReadonlyArray<Completions>
+  readonly subcommands?: ReadonlyArray<CommandSpec>
+}
+
+const target: ReadonlyArray<FlagSpec> = [
+  { name: "admin-url", values: "text" },
+  { name: "ctx", values: "contexts" }
+]
+
+const output: FlagSpec = { name: "output", alias: "o", values: ["table", "wide", "json"] }
+
+const watch: FlagSpec = { name: "watch", alias: "w" }
+
+// Keep in step with the commands in Commands.ts
+export const COMMANDS: ReadonlyArray<CommandSpec> = [
+  {
+    name: "start",
+    flags: [
+      { name: "config", alias: "c", values: "files" },
+      { name: "port", alias: "p", values: "text" },
+      { name: "runtime", values: ["node", "bun"] },
+      { name: "probe" }
+    ]
+  },
+  { name: "up", flags: target, args: ["files"] },
+  { name: "down", flags: target, args: ["files"] },
+  { name: "list", flags: [...target, watch, output] },
+  { name: "stubs", flags: [...target, watch, output], args: ["imposters"] },
+  {
+    name: "requests",
+    flags: [...target, output, { name: "unmatched" }, { name: "outbound" }, { name: "limit", values: "text" }],
+    args: ["imposters"]
+  },
+  {
+    name: "create-stub",
+    flags: [...target, { name: "interactive", alias: "i" }, { name: "file", alias: "f", values: "files" }],
+    args: ["imposters"]
+  },
+  {
+    name: "delete-stub",
+    flags: target,
+    args: ["imposters", "stubs"]
+  },
+  {
+    name: "apply-preset",
+    flags: [...target, { name: "file", alias: "f", values: "files" }],
+    args: ["imposters", "presets"]
+  },
+  {
+    name: "prune",
+    flags: [...target, { name: "unused-for", values: "text" }, { name: "dry-run" }],
+    args: ["imposters"]
+  },
+  {
+    name: "ctx",
+    flags: [],
+    subcommands: [
+      { name: "add", flags: [] },
+      { name: "remove", flags: [], args: ["contexts"] },
+      { name: "use", flags: [], args: ["contexts"] },
+      { name: "list", flags: [] }
+    ]
+  },
+  {
+    name: "tunnel",
+    flags: [
+      { name: "port", values: "text" },
+      { name: "to", values: "text" },
+      { name: "ssh", values: "text" },
+      { name: "bind", values: "text" }
+    ]
+  },
+  {
+    name: "ca",
+    flags: [],
+    subcommands: [
+      { name: "init", flags: [{ name: "force" }] },
+      { name: "export", flags: [{ name: "host", values: "text" }, { name: "out", alias: "o", values: "files" }] },
+      { name: "trust", flags: [{ name: "install" }] }
+    ]
+  },
+  { name: "completion", flags: [], args: [COMPLETION_SHELLS] }
+]
+
+// Flags that take a value, wherever they appear, so positional arguments can be told from flag values
+const valuedFlags = (commands: ReadonlyArray<CommandSpec>): Array<string> => [
+  ...new Set(
+    commands.flatMap((command) => [
+      ...command.flags
+        .filter((f) => f.values !== undefined)
+        .flatMap((f) => [`--${f.name}`, ...(f.alias !== undefined ? [`-${f.alias}`] : [])]),
+      ...valuedFlags(command.subcommands ?? [])
+    ])
+  )
+]
+
+const lookup = (values: "imposters" | "stubs" | "presets" | "contexts", imposter: string) =>
+  `imposters complete ${values}${values === "stubs" ? ` ${imposter}` : ""} 2>/dev/null`
+
+const flagWords = (flags: ReadonlyArray<FlagSpec>): string =>
+  flags.flatMap((f) => [`--${f.name}`, ...(f.alias !== undefined ? [`-${f.alias}`] : [])]).join(" ")
+
+const bashValues = (values: Completions): string => {
+  if (values === "files") return `COMPREPLY=($(compgen -f -- "$cur"))`
+  if (values === "text") return "COMPREPLY=()"
+  const words = typeof values === "string" ? `$(${lookup(values, "\"${args[0]}\"")})` : values.join(" ")
+  return `COMPREPLY=($(compgen -W "${words}" -- "$cur"))`
+}
+
+// Completions for one command, whose arguments start at word `start`: the value of the previous
+// flag, then flags, then arguments
+const bashCommand = (command: CommandSpec, indent: string, start: number): Array<string> => {
+  const lines: Array<string> = []
+  const valued = command.flags.filter((f) => f.values !== undefined)
+  if (valued.length > 0) {
+    lines.push(`${indent}case "$prev" in`)
+    for (const flag of valued) {
+      const names = [`--${flag.name}`, ...(flag.alias !== undefined ? [`-${flag.alias}`] : [])].join("|")
+      lines.push(`${indent}  ${names}) ${bashValues(flag.values ?? "text")}; return ;;`)
+    }
+    lines.push(`${indent}esac`)
+  }
+  if (command.flags.length > 0) {
+    lines.push(`${indent}if [[ "$cur" == -* ]]; then ${bashValues(flagWords(command.flags).split(" "))}; return; fi`)
+  }
+  const args = command.args ?? []
+  if (args.length === 1) lines.push(`${indent}${bashValues(args[0]!)}`)
+  if (args.length > 1) {
+    lines.push(`${indent}local -a args=($(_imposters_args ${start}))`, `${indent}case \${#args[@]} in`)
+    args.forEach((values, i) => lines.push(`${indent}  ${i}) ${bashValues(values)} ;;`))
+    lines.push(`${indent}esac`)
+  }
+  return lines
+}
+
+const bash = (): string => {
+  const lines = [
+    "# imposters completion for bash; add to ~/.bashrc:  source <(imposters completion bash)",
+    "# The arguments typed so far from word $1 on, skipping flags and their values",
+    "_imposters_args() {",
+    "  local i",
+    "  for ((i = $1; i < COMP_CWORD; i++)); do",
+    "    case \"${COMP_WORDS[i]}\" in",
+    `      ${valuedFlags(COMMANDS).join("|")}) ((i++)) ;;`,
+    "      -*) ;;",
+    "      *) echo \"${COMP_WORDS[i]}\" ;;",
+    "    esac",
+    "  done",
+    "}",
+    "_imposters() {",
+    "  local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\" cmd=\"${COMP_WORDS[1]}\"",
+    "  COMPREPLY=()",
+    "  if [[ $COMP_CWORD -eq 1 ]]; then",
+    `    ${bashValues(COMMANDS.map((c) => c.name))}`,
+    "    return",
+    "  fi",
+    "  case \"$cmd\" in"
+  ]
+  for (const command of COMMANDS) {
+    lines.push(`    ${command.name})`)
+    if (command.subcommands !== undefined) {
+      lines.push(
+        "      if [[ $COMP_CWORD -eq 2 ]]; then",
+        `        ${bashValues(command.subcommands.map((c) => c.name))}`,
+        "        return",
+        "      fi",
+        "      case \"${COMP_WORDS[2]}\" in"
+      )
+      for (const sub of command.subcommands) {
+        lines.push(`        ${sub.name})`, ...bashCommand(sub, "          ", 3), "          ;;")
+      }
+      lines.push("      esac")
+    } else {
+      lines.push(...bashCommand(command, "      ", 2))
+    }
+    lines.push("      ;;")
+  }
+  lines.push("  esac", "}", "complete -F _imposters imposters", "")
+  return lines.join("\n")
+}
+
+// zsh runs the bash function through its bash compatibility layer
+const zsh = (): string =>
+  [
+    "# imposters completion for zsh; add to ~/.zshrc:  source <(imposters completion zsh)",
+    "autoload -U +X compinit && compinit",
+    "autoload -U +X bashcompinit && bashcompinit",
+    bash().split("\n").slice(1).join("\n")
+  ].join("\n")
+
+// `start` is the token the command's arguments start at
+const fishValues = (values: Completions, start = 3): string => {
+  if (values === "files") return "-F"
+  if (values === "text") return "-x"
+  const words = typeof values === "string" ? `(${lookup(values, `(__imposters_args ${start})[1]`)})` : values.join(" ")
+  return `-x -a "${words}"`
+}
+
+const fish = (): string => {
+  const lines = [
+    "# imposters completion for fish; save as ~/.config/fish/completions/imposters.fish",
+    "# The arguments typed so far from token $argv[1] on, skipping flags and their values",
+    "function __imposters_args",
+    "  set -l skip 0",
+    "  for token in (commandline -opc)[$argv[1]..-1]",
+    "    if test $skip -eq 1",
+    "      set skip 0",
+    "    else if string match -q -- '-*' $token",
+    `      contains -- $token ${valuedFlags(COMMANDS).join(" ")}; and set skip 1`,
+    "    else",
+    "      echo $token",
+    "    end",
+    "  end",
+    "end",
+    "complete -c imposters -f",
+    `complete -c imposters -n __fish_use_subcommand -a "${COMMANDS.map((c) => c.name).join(" ")}"`
+  ]
+  const describe = (command: CommandSpec, condition: string, start: number) => {
+    for (const flag of command.flags) {
+      const short = flag.alias !== undefined ? ` -s ${flag.alias}` : ""
+      const values = flag.values !== undefined ? ` ${fishValues(flag.values)}` : ""
+      lines.push(`complete -c imposters -n "${condition}" -l ${flag.name}${short}${values}`)
+    }
+    const args = command.args ?? []
+    if (args.length === 1) lines.push(`complete -c imposters -n "${condition}" ${fishValues(args[0]!)}`)
+    if (args.length > 1) {
+      args.forEach((values, i) => {
+        const position = `${condition}; and test (count (__imposters_args ${start})) -eq ${i}`
+        lines.push(`complete -c imposters -n "${position}" ${fishValues(values, start)}`)
+      })
+    }
+  }
+  for (const command of COMMANDS) {
+    const seen = `__fish_seen_subcommand_from ${command.name}`
+    if (command.subcommands === undefined) {
+      describe(command, seen, 3)
+      continue
+    }
+    const names = command.subcommands.map((c) => c.name).join(" ")
+    lines.push(`complete -c imposters -n "${seen}; and not __fish_seen_subcommand_from ${names}" -a "${names}"`)
+    for (const sub of command.subcommands) describe(sub, `${seen}; and __fish_seen_subcommand_from ${sub.name}`, 4)
+  }
+  lines.push("")
+  return lines.join("\n")
+}
+
+export const completionScript = (shell: CompletionShell): string => {
+  switch (shell) {
+    case "bash":
+      return bash()
+    case "zsh":
+      return zsh()
+    case "fish":
+      return fish()
+  }
+}
@@ -1,41 +1,69 @@
 import { Data, Effect, Schema } from "effect"
 import * as fs from "node:fs"
-import { ConfigFile } from "../schemas/ConfigFileSchema"
+import * as path from "node:path"
+import { parseYaml } from "../domain/yaml"
+import { ConfigFile, EnvironmentManifest } from "../schemas/ConfigFileSchema"
 
 export class ConfigLoadError extends Data.TaggedError("ConfigLoadError")<{
   readonly message: string
   readonly cause?: unknown
 }> {}
 
-export const loadConfigFile = (
-  filePath: string
-): Effect.Effect<Schema.Schema.Type<typeof ConfigFile>, ConfigLoadError> =>
+const loadFile = <A, I>(
+  schema: Schema.Schema<A, I>,
+  filePath: string,
+  kind: string,
+  format: "JSON" | "YAML"
+): Effect.Effect<A, ConfigLoadError> =>
   Effect.gen(function*() {
     const content = yield* Effect.try({
       try: () => fs.readFileSync(filePath, "utf-8"),
       catch: (error) =>
         new ConfigLoadError({
-          message: `Failed to read config file: ${filePath}`,
+          message: `Failed to read ${kind} file: ${filePath}`,
           cause: error
         })
     })
 
-    const json = yield* Effect.try({
-      try: () => JSON.parse(content) as unknown,
+    const parsed = yield* Effect.try({
+      try: () => (format === "YAML" ? parseYaml(content) : JSON.parse(content) as unknown),
       catch: (error) =>
         new ConfigLoadError({
-          message: `Invalid JSON in config file: ${filePath}`,
+          message: format === "YAML"
+            ? `Invalid YAML in ${kind} file: ${filePath}: ${(error as Error).message}`
+            : `Invalid JSON in ${kind} file: ${filePath}`,
           cause: error
         })
     })
 
-    return yield* Schema.decodeUnknown(ConfigFile)(json).pipe(
+    return yield* Schema.decodeUnknown(schema)(parsed).pipe(
       Effect.mapError(
         (error) =>
           new ConfigLoadError({
-            message: `Config validation failed: ${String(error)}`,
+            message: `${kind.charAt(0).toUpperCase()}${kind.slice(1)} validation failed: ${String(error)}`,
             cause: error
           })
       )
     )
   })
+
+export const loadJsonFile = <A, I>(
+  schema: Schema.Schema<A, I>,
+  filePath: string,
+  kind: string
+): Effect.Effect<A, ConfigLoadError> => loadFile(schema, filePath, kind, "JSON")
+
+export const loadConfigFile = (
+  filePath: string
+): Effect.Effect<Schema.Schema.Type<typeof ConfigFile>, ConfigLoadError> => loadJsonFile(ConfigFile, filePath, "config")
+
+// Manifests are JSON, or YAML when the file is named .yaml or .yml
+export const loadManifestFile = (
+  filePath: string
+): Effect.Effect<EnvironmentManifest, ConfigLoadError> =>
+  loadFile(
+    EnvironmentManifest,
+    filePath,
+    "manifest",
+    [".yaml", ".yml"].includes(path.extname(filePath).toLowerCase()) ? "YAML" : "JSON"
+  )
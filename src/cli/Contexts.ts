@@ -0,0 +1,93 @@
+import { Data, Effect, Option, Schema } from "effect"
+import * as fs from "node:fs"
+import * as os from "node:os"
+import * as path from "node:path"
+import { CliContext, CliContextsFile } from "../schemas/ConfigFileSchema"
+import { type ConfigLoadError, loadJsonFile } from "./ConfigLoader"
+
+export const DEFAULT_ADMIN_URL = "http://localhost:2525"
+
+export class ContextError extends Data.TaggedError("ContextError")<{
+  readonly message: string
+  readonly cause?: unknown
+}> {}
+
+export const contextsFilePath = (): string =>
+  process.env.IMPOSTERS_CONTEXTS ?? path.join(os.homedir(), ".imposters", "contexts.json")
+
+export const loadContexts = (filePath: string): Effect.Effect<CliContextsFile, ConfigLoadError> =>
+  fs.existsSync(filePath)
+    ? loadJsonFile(CliContextsFile, filePath, "contexts")
+    : Effect.succeed(Schema.decodeSync(CliContextsFile)({}))
+
+const saveContexts = (filePath: string, data: CliContextsFile): Effect.Effect<void, ContextError> =>
+  Effect.try({
+    try: () => {
+      fs.mkdirSync(path.dirname(filePath), { recursive: true })
+      fs.writeFileSync(filePath, JSON.stringify(Schema.encodeSync(CliContextsFile)(data), null, 2) + "\n", "utf-8")
+    },
+    catch: (error) => new ContextError({ message: `Failed to write contexts file: ${filePath}`, cause: error })
+  })
+
+/**
+ * Add or replace a context. The first context added becomes the current one.
+ */
+export const addContext = (
+  filePath: string,
+  name: string,
+  url: string
+): Effect.Effect<void, ConfigLoadError | ContextError> =>
+  Effect.gen(function*() {
+    const context = yield* Schema.decodeUnknown(CliContext)({ url }).pipe(
+      Effect.mapError((error) =>
+        new ContextError({ message: `Invalid URL for context "${name}": ${url}`, cause: error })
+      )
+    )
+    const data = yield* loadContexts(filePath)
+    yield* saveContexts(filePath, {
+      current: data.current ?? name,
+      contexts: { ...data.contexts, [name]: context }
+    })
+  })
+
+export const removeContext = (filePath: string, name: string): Effect.Effect<void, ConfigLoadError | ContextError> =>
+  Effect.gen(function*() {
+    const data = yield* loadContexts(filePath)
+    if (!Object.hasOwn(data.contexts, name)) {
+      return yield* Effect.fail(new ContextError({ message: `Unknown context "${name}"` }))
+    }
+    const contexts = Object.fromEntries(Object.entries(data.contexts).filter(([key]) => key !== name))
+    yield* saveContexts(filePath, {
+      ...(data.current !== undefined && data.current !== name ? { current: data.current } : {}),
+      contexts
+    })
+  })
+
+export const useContext = (filePath: string, name: string): Effect.Effect<void, ConfigLoadError | ContextError> =>
+  Effect.gen(function*() {
+    const data = yield* loadContexts(filePath)
+    if (!Object.hasOwn(data.contexts, name)) {
+      return yield* Effect.fail(new ContextError({ message: `Unknown context "${name}"` }))
+    }
+    yield* saveContexts(filePath, { ...data, current: name })
+  })
+
+/**
+ * Pick the admin URL for a command: an explicit --admin-url wins, then --ctx,
+ * then the current context, then the local default.
+ */
+export const resolveAdminUrl = (
+  filePath: string,
+  options: { readonly adminUrl: Option.Option<string>; readonly ctx: Option.Option<string> }
+): Effect.Effect<string, ConfigLoadError | ContextError> =>
+  Effect.gen(function*() {
+    if (Option.isSome(options.adminUrl)) return options.adminUrl.value
+    const data = yield* loadContexts(filePath)
+    const name = Option.isSome(options.ctx) ? options.ctx.value : data.current
+    if (name === undefined) return DEFAULT_ADMIN_URL
+    const context = Object.hasOwn(data.contexts, name) ? data.contexts[name] : undefined
+    if (context === undefined) {
+      return yield* Effect.fail(new ContextError({ message: `Unknown context "${name}"` }))
+    }
+    return context.url
+  })
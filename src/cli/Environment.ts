@@ -0,0 +1,154 @@
+import { Effect } from "effect"
+import { ImpostersClient } from "../client/ImpostersClient"
+import { inlineBodies } from "../domain/bodies"
+import { environmentPrefix } from "../domain/imposter"
+import { NonEmptyString } from "../schemas/common"
+import type { EnvironmentManifest, ImposterConfig, SharedBodies } from "../schemas/ConfigFileSchema"
+import type { Profiles } from "../schemas/ProfileSchema"
+
+const LIST_PAGE_SIZE = 100
+
+export interface CreatedImposter {
+  readonly id: string
+  readonly name: string
+  readonly port: number
+}
+
+/**
+ * Put each profile after the ones it extends. Failures are reported and skipped, like
+ * imposters, and leave the profiles extending them to fail in turn.
+ */
+export const putProfiles = (profiles: Profiles): Effect.Effect<void, never, ImpostersClient> =>
+  Effect.gen(function*() {
+    const client = yield* ImpostersClient
+    const names = Object.keys(profiles)
+    // How many profiles up its `extends` chain goes; bounded, since the server refuses cycles anyway
+    const depth = (name: string) => {
+      let count = 0
+      let next = profiles[name]!.extends
+      while (next !== undefined && count < names.length) {
+        next = Object.hasOwn(profiles, next) ? profiles[next]?.extends : undefined
+        count++
+      }
+      return count
+    }
+
+    for (const name of [...names].sort((a, b) => depth(a) - depth(b))) {
+      yield* client.putProfile({ path: { name: NonEmptyString.make(name) }, payload: profiles[name]! }).pipe(
+        Effect.catchAll((e) => Effect.sync(() => console.error(`Failed to put profile ${name}: ${e}`)))
+      )
+    }
+  })
+
+/**
+ * Create, populate and start each configured imposter. Failures are reported
+ * and skipped so one bad entry doesn't block the rest.
+ */
+export const createImposters = (
+  imposters: ReadonlyArray<ImposterConfig>,
+  options: { readonly namePrefix?: string; readonly bodies?: SharedBodies } = {}
+): Effect.Effect<ReadonlyArray<CreatedImposter>, never, ImpostersClient> =>
+  Effect.gen(function*() {
+    const client = yield* ImpostersClient
+    const created: Array<CreatedImposter> = []
+
+    for (const imp of imposters) {
+      const name = options.namePrefix !== undefined
+        ? NonEmptyString.make(`${options.namePrefix}${imp.name ?? imp.port}`)
+        : imp.name
+      const imposter = yield* client.imposters.createImposter({
+        payload: {
+          port: imp.port,
+          ...(name !== undefined ? { name } : {}),
+          protocol: imp.tls !== undefined ? "HTTPS" as const : "HTTP" as const,
+          adminPath: "/_admin",
+          ...(imp.proxy !== undefined ? { proxy: imp.proxy } : {}),
+          ...(imp.tls !== undefined ? { tls: imp.tls } : {}),
+          ...(imp.stubIds !== undefined ? { stubIds: imp.stubIds } : {}),
+          ...(imp.httpParser !== undefined ? { httpParser: imp.httpParser } : {}),
+          ...(imp.stubOrder !== undefined ? { stubOrder: imp.stubOrder } : {}),
+          ...(imp.cors !== undefined ? { cors: imp.cors } : {}),
+          ...(imp.profile !== undefined ? { profile: imp.profile } : {})
+        }
+      }).pipe(Effect.catchAll((e) => {
+        console.error(`Failed to create imposter on port ${imp.port}: ${e}`)
+        return Effect.succeed(null)
+      }))
+
+      if (imposter === null) continue
+
+      for (const stub of inlineBodies(imp.stubs, options.bodies ?? {})) {
+        yield* client.imposters.addStub({
+          path: { imposterId: imposter.id },
+          payload: stub
+        }).pipe(Effect.catchAll((e) => {
+          console.error(`Failed to add stub: ${e}`)
+          return Effect.void
+        }))
+      }
+
+      for (const preset of imp.presets) {
+        yield* client.imposters.applyPreset({
+          path: { imposterId: imposter.id },
+          payload: preset
+        }).pipe(Effect.catchAll((e) => {
+          console.error(`Failed to apply preset ${preset.preset}: ${e}`)
+          return Effect.void
+        }))
+      }
+
+      yield* client.imposters.updateImposter({
+        path: { id: imposter.id },
+        payload: { status: "running" as const }
+      }).pipe(Effect.catchAll((e) => {
+        console.error(`Failed to start imposter ${imposter.id}: ${e}`)
+        return Effect.void
+      }))
+
+      created.push({ id: imposter.id, name: imposter.name, port: imposter.port })
+    }
+
+    return created
+  })
+
+/**
+ * Delete every imposter that belongs to the environment. Returns the removed imposters.
+ */
+export const environmentDown = (
+  envName: string
+): Effect.Effect<ReadonlyArray<CreatedImposter>, unknown, ImpostersClient> =>
+  Effect.gen(function*() {
+    const client = yield* ImpostersClient
+    const prefix = environmentPrefix(envName)
+    const members: Array<CreatedImposter> = []
+
+    let offset = 0
+    while (true) {
+      const page = yield* client.imposters.listImposters({ urlParams: { limit: LIST_PAGE_SIZE, offset } })
+      for (const imp of page.imposters) {
+        if (imp.name.startsWith(prefix)) members.push({ id: imp.id, name: imp.name, port: imp.port })
+      }
+      if (!page.pagination.hasMore) break
+      offset += LIST_PAGE_SIZE
+    }
+
+    for (const imp of members) {
+      yield* client.imposters.deleteImposter({ path: { id: imp.id }, urlParams: { force: true } })
+    }
+    return members
+  })
+
+/**
+ * Bring an environment up with `POST /imposters/load`, replacing any imposters left from a
+ * previous `up`. All or nothing: when one imposter fails, the server removes the ones created
+ * before it and the error is returned, so the command fails rather than reporting a partial
+ * environment as up.
+ */
+export const environmentUp = (
+  manifest: EnvironmentManifest
+): Effect.Effect<ReadonlyArray<CreatedImposter>, unknown, ImpostersClient> =>
+  Effect.gen(function*() {
+    const client = yield* ImpostersClient
+    const loaded = yield* client.imposters.loadManifest({ payload: manifest })
+    return loaded.imposters.map((imp) => ({ id: imp.id, name: imp.name, port: imp.port }))
+  })
@@ -0,0 +1,149 @@
+import { DateTime, Schema } from "effect"
+import type { ImposterResponse } from "../schemas/ImposterSchema"
+import type { OutboundLogEntry, RequestLogEntry } from "../schemas/RequestLogSchema"
+import type { PredicateExpression, Stub } from "../schemas/StubSchema"
+
+// `table` is for reading, `wide` adds columns, `json` is for scripts
+export type OutputFormat = "table" | "wide" | "json"
+
+export const OUTPUT_FORMATS: ReadonlyArray<OutputFormat> = ["table", "wide", "json"]
+
+type Color = "red" | "green" | "yellow" | "cyan" | "dim" | "bold"
+
+const ANSI: Record<Color, string> = { red: "31", green: "32", yellow: "33", cyan: "36", dim: "2", bold: "1" }
+
+// Colors only when writing to a terminal, and never with NO_COLOR set (https://no-color.org)
+export const colorEnabled = (): boolean => process.stdout.isTTY === true && process.env.NO_COLOR === undefined
+
+const paint = (text: string, color: Color | undefined, enabled: boolean): string =>
+  enabled && color !== undefined ? `\u001b[${ANSI[color]}m${text}\u001b[0m` : text
+
+export interface Column<A> {
+  readonly header: string
+  readonly value: (row: A) => string
+  readonly color?: (row: A) => Color | undefined
+  // Only shown with `--output wide`
+  readonly wide?: boolean
+}
+
+/**
+ * Align rows under upper-case headers. Widths are measured before coloring, so
+ * escape codes never break the alignment; the last column is not padded.
+ */
+export const renderTable = <A>(
+  columns: ReadonlyArray<Column<A>>,
+  rows: ReadonlyArray<A>,
+  options: { readonly wide: boolean; readonly color: boolean }
+): string => {
+  const shown = columns.filter((c) => options.wide || c.wide !== true)
+  const cells = rows.map((row) => shown.map((c) => c.value(row)))
+  const widths = shown.map((c, i) => Math.max(c.header.length, ...cells.map((r) => r[i]?.length ?? 0)))
+  const line = (values: ReadonlyArray<string>, colorOf: (i: number) => Color | undefined) =>
+    values.map((v, i) => paint(i === values.length - 1 ? v : v.padEnd(widths[i] ?? 0), colorOf(i), options.color))
+      .join("  ")
+  return [
+    line(shown.map((c) => c.header), () => "bold"),
+    ...rows.map((row, r) => line(cells[r] ?? [], (i) => shown[i]?.color?.(row)))
+  ].join("\n")
+}
+
+const statusColor = (status: number): Color =>
+  status >= 500 ? "red" : status >= 400 ? "yellow" : status >= 300 ? "cyan" : "green"
+
+export const imposterColumns: ReadonlyArray<Column<ImposterResponse>> = [
+  { header: "ID", value: (i) => i.id, color: () => "dim" },
+  { header: "NAME", value: (i) => i.name },
+  { header: "PORT", value: (i) => String(i.port) },
+  {
+    header: "STATUS",
+    value: (i) => i.status,
+    color: (i) => i.status === "running" ? "green" : i.status === "stopped" ? "dim" : "yellow"
+  },
+  { header: "PROTOCOL", value: (i) => i.protocol, wide: true },
+  { header: "STUBS", value: (i) => String(i.endpointCount), wide: true },
+  { header: "UPTIME", value: (i) => i.uptime ?? "-", wide: true },
+  { header: "CREATED", value: (i) => DateTime.formatIso(i.createdAt), wide: true }
+]
+
+// The method, path and remaining matchers of a stub's top-level predicates
+const summarizePredicates = (predicates: ReadonlyArray<PredicateExpression>) => {
+  let method = "*"
+  let path = "*"
+  const others: Array<string> = []
+  for (const p of predicates) {
+    if (!("field" in p)) others.push("and" in p ? "and(...)" : "or" in p ? "or(...)" : "not(...)")
+    else if (p.negate !== true && typeof p.value === "string" && p.field === "method" && p.operator === "equals") {
+      method = p.value
+    } else if (p.negate !== true && typeof p.value === "string" && p.field === "path" && p.operator !== "exists") {
+      path = p.operator === "equals" || p.operator === "template" ? p.value : `${p.operator} ${p.value}`
+    } else others.push(`${p.negate === true ? "!" : ""}${p.field} ${p.operator}`)
+  }
+  return { method, path, others }
+}
+
+export const stubColumns: ReadonlyArray<Column<Stub>> = [
+  { header: "ID", value: (s) => s.id, color: () => "dim" },
+  { header: "METHOD", value: (s) => summarizePredicates(s.predicates).method, color: () => "bold" },
+  { header: "PATH", value: (s) => summarizePredicates(s.predicates).path },
+  {
+    header: "RESPONSE",
+    value: (s) => s.responses[0].proxy !== undefined ? "proxy" : String(s.responses[0].status),
+    color: (s) => {
+      const { proxy, status } = s.responses[0]
+      return proxy !== undefined ? "cyan" : typeof status === "number" ? statusColor(status) : "bold"
+    }
+  },
+  { header: "RESPONSES", value: (s) => String(s.responses.length), wide: true },
+  { header: "MODE", value: (s) => s.responseMode, wide: true },
+  { header: "MATCHERS", value: (s) => summarizePredicates(s.predicates).others.join(", ") || "-", wide: true }
+]
+
+export const requestColumns: ReadonlyArray<Column<RequestLogEntry>> = [
+  { header: "TIME", value: (e) => DateTime.formatIso(e.timestamp).slice(11, 23), color: () => "dim" },
+  { header: "METHOD", value: (e) => e.request.method, color: () => "bold" },
+  { header: "PATH", value: (e) => e.request.path },
+  { header: "STATUS", value: (e) => String(e.response.status), color: (e) => statusColor(e.response.status) },
+  {
+    header: "STUB",
+    value: (e) => e.response.matchedStubId ?? (e.response.proxied ? "proxied" : "unmatched"),
+    color: (e) => e.response.matchedStubId === undefined && !e.response.proxied ? "red" : undefined
+  },
+  { header: "DURATION", value: (e) => `${Math.round(e.duration)}ms` },
+  { header: "ID", value: (e) => e.id, wide: true },
+  {
+    header: "MATCH",
+    value: (e) => e.timings !== undefined ? `${e.timings.candidates} stubs, ${e.timings.matchMs}ms` : "-",
+    wide: true
+  },
+  { header: "QUERY", value: (e) => new URLSearchParams(e.request.query).toString() || "-", wide: true }
+]
+
+export const outboundColumns: ReadonlyArray<Column<OutboundLogEntry>> = [
+  { header: "TIME", value: (e) => DateTime.formatIso(e.timestamp).slice(11, 23), color: () => "dim" },
+  { header: "KIND", value: (e) => e.attempt > 1 ? `${e.kind} #${e.attempt}` : e.kind },
+  { header: "METHOD", value: (e) => e.request.method, color: () => "bold" },
+  { header: "URL", value: (e) => e.request.url },
+  {
+    header: "STATUS",
+    value: (e) => e.status !== undefined ? String(e.status) : e.error ?? "-",
+    color: (e) => e.status !== undefined ? statusColor(e.status) : "red"
+  },
+  { header: "DURATION", value: (e) => `${Math.round(e.duration)}ms` },
+  { header: "TRIGGERED BY", value: (e) => e.triggeredBy, wide: true },
+  { header: "STUB", value: (e) => e.stubId ?? "-", wide: true }
+]
+
+/**
+ * Print rows as a table, or as JSON encoded with the API's own schema so the
+ * output can be fed back to the admin API.
+ */
+export const formatRows = <A, I>(
+  format: OutputFormat,
+  schema: Schema.Schema<A, I>,
+  columns: ReadonlyArray<Column<A>>,
+  rows: ReadonlyArray<A>,
+  color: boolean = colorEnabled()
+): string =>
+  format === "json"
+    ? JSON.stringify(Schema.encodeSync(Schema.Array(schema))(rows), null, 2)
+    : renderTable(columns, rows, { wide: format === "wide", color })
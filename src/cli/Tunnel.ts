@@ -0,0 +1,84 @@
+import { Data, Effect, type Scope } from "effect"
+import { spawn } from "node:child_process"
+import * as net from "node:net"
+
+export class TunnelError extends Data.TaggedError("TunnelError")<{
+  readonly message: string
+  readonly cause?: unknown
+}> {}
+
+// "4000" forwards 4000 <-> 4000, "8080:4000" forwards local 8080 <-> remote 4000
+export interface PortMapping {
+  readonly local: number
+  readonly remote: number
+}
+
+const parsePort = (value: string): number | undefined => {
+  const n = Number(value)
+  return Number.isInteger(n) && n >= 1 && n <= 65535 ? n : undefined
+}
+
+export const parsePortMapping = (spec: string): Effect.Effect<PortMapping, TunnelError> => {
+  const parts = spec.split(":")
+  const local = parsePort(parts[0] ?? "")
+  const remote = parts.length === 2 ? parsePort(parts[1]!) : local
+  if (parts.length > 2 || local === undefined || remote === undefined) {
+    return Effect.fail(new TunnelError({ message: `Invalid port mapping "${spec}" (expected PORT or LOCAL:REMOTE)` }))
+  }
+  return Effect.succeed({ local, remote })
+}
+
+/**
+ * Arguments for `ssh -N -R ...`: the remote host's ports are forwarded back to the
+ * local imposters, so services running there can call the laptop's mocks.
+ */
+export const sshReverseArgs = (destination: string, mappings: ReadonlyArray<PortMapping>): Array<string> => [
+  "-N",
+  "-o",
+  "ExitOnForwardFailure=yes",
+  ...mappings.flatMap((m) => ["-R", `${m.remote}:localhost:${m.local}`]),
+  destination
+]
+
+/**
+ * Listen on `bindHost:mapping.local` and pipe every connection to `targetHost:mapping.remote`.
+ * The listener is closed when the scope closes.
+ */
+export const relay = (
+  mapping: PortMapping,
+  targetHost: string,
+  bindHost: string
+): Effect.Effect<net.Server, TunnelError, Scope.Scope> =>
+  Effect.acquireRelease(
+    Effect.async<net.Server, TunnelError>((resume) => {
+      const server = net.createServer((client) => {
+        const upstream = net.connect(mapping.remote, targetHost)
+        client.pipe(upstream).pipe(client)
+        client.on("error", () => upstream.destroy())
+        upstream.on("error", () => client.destroy())
+      })
+      server.once("error", (err) =>
+        resume(Effect.fail(new TunnelError({ message: `Cannot listen on ${bindHost}:${mapping.local}`, cause: err }))))
+      server.listen(mapping.local, bindHost, () => resume(Effect.succeed(server)))
+    }),
+    (server) => Effect.sync(() => server.close())
+  )
+
+/**
+ * Run `ssh` with reverse forwards until it exits or the fiber is interrupted.
+ */
+export const sshReverseTunnel = (
+  destination: string,
+  mappings: ReadonlyArray<PortMapping>
+): Effect.Effect<void, TunnelError> =>
+  Effect.async<void, TunnelError>((resume) => {
+    const child = spawn("ssh", sshReverseArgs(destination, mappings), { stdio: "inherit" })
+    child.once("error", (err) => resume(Effect.fail(new TunnelError({ message: "Failed to run ssh", cause: err }))))
+    child.once("exit", (code) =>
+      resume(
+        code === 0 ? Effect.void : Effect.fail(new TunnelError({ message: `ssh exited with code ${code}` }))
+      ))
+    return Effect.sync(() => {
+      child.kill()
+    })
+  })
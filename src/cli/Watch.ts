@@ -0,0 +1,95 @@
+import { Data, Effect } from "effect"
+
+export class WatchError extends Data.TaggedError("WatchError")<{
+  readonly message: string
+  readonly cause?: unknown
+}> {}
+
+export interface ServerSentEvent {
+  readonly event: string
+  readonly data: string
+}
+
+/**
+ * Split buffered SSE text into complete messages and the unfinished remainder.
+ * Comment-only messages (keep-alives) are dropped.
+ */
+export const parseServerSentEvents = (
+  buffer: string
+): { readonly events: ReadonlyArray<ServerSentEvent>; readonly rest: string } => {
+  const blocks = buffer.replace(/\r\n/g, "\n").split("\n\n")
+  const rest = blocks.pop() ?? ""
+  const events: Array<ServerSentEvent> = []
+  for (const block of blocks) {
+    let event = "message"
+    const data: Array<string> = []
+    for (const line of block.split("\n")) {
+      if (line.startsWith("event:")) event = line.slice(6).trim()
+      else if (line.startsWith("data:")) data.push(line.slice(5).trimStart())
+    }
+    if (data.length > 0) events.push({ event, data: data.join("\n") })
+  }
+  return { events, rest }
+}
+
+/**
+ * One-line summary of a change event, e.g. `stub.updated a1b2c3 stub=x9 (responses)`.
+ */
+export const describeEvent = (sse: ServerSentEvent): string => {
+  try {
+    const event = JSON.parse(sse.data) as {
+      imposterId?: string
+      data?: { stubId?: string; changes?: Record<string, unknown> }
+    }
+    const stub = event.data?.stubId !== undefined ? ` stub=${event.data.stubId}` : ""
+    const changes = event.data?.changes !== undefined ? ` (${Object.keys(event.data.changes).join(", ")})` : ""
+    return `${sse.event} ${event.imposterId ?? ""}${stub}${changes}`
+  } catch {
+    return sse.event
+  }
+}
+
+/**
+ * Follow the admin server's change stream and run `onEvent` for each event until the
+ * fiber is interrupted or the server closes the connection.
+ */
+export const watchEvents = <E, R>(
+  adminUrl: string,
+  filter: { readonly imposterId?: string },
+  onEvent: (event: ServerSentEvent) => Effect.Effect<void, E, R>,
+  // Sent as a bearer token, for admin servers started with ADMIN_TOKEN
+  token?: string
+): Effect.Effect<void, WatchError | E, R> =>
+  Effect.gen(function*() {
+    const url = new URL("/events/stream", adminUrl)
+    if (filter.imposterId !== undefined) url.searchParams.set("imposterId", filter.imposterId)
+
+    const response = yield* Effect.tryPromise({
+      try: (signal) =>
+        fetch(url, {
+          signal,
+          headers: { accept: "text/event-stream", ...(token !== undefined ? { authorization: `Bearer ${token}` } : {}) }
+        }),
+      catch: (cause) => new WatchError({ message: `Cannot connect to ${url.origin}`, cause })
+    })
+    if (!response.ok || response.body === null) {
+      return yield* Effect.fail(new WatchError({ message: `Event stream returned HTTP ${response.status}` }))
+    }
+
+    const reader = response.body.getReader()
+    const decoder = new TextDecoder()
+    let buffer = ""
+    yield* Effect.addFinalizer(() => Effect.promise(() => reader.cancel()))
+    while (true) {
+      const chunk = yield* Effect.tryPromise({
+        try: () => reader.read(),
+        catch: (cause) => new WatchError({ message: "Event stream interrupted", cause })
+      })
+      if (chunk.done) return
+      const parsed = parseServerSentEvents(buffer + decoder.decode(chunk.value, { stream: true }))
+      buffer = parsed.rest
+      for (const event of parsed.events) {
+        yield* onEvent(event)
+      }
+    }
+  }).pipe(Effect.scoped)
@@ -0,0 +1,194 @@
+import { Prompt } from "@effect/cli"
+import { Effect, Schema } from "effect"
+import type { RequestContext } from "../matching/RequestMatcher"
+import { buildResponse } from "../matching/ResponseGenerator"
+import { CreateStubRequest, type PredicateOperator } from "../schemas/StubSchema"
+
+// Request parts a matcher can look at besides the method and path
+export type MatcherField = "host" | "headers" | "query" | "form" | "body"
+
+export interface MatcherDraft {
+  readonly field: MatcherField
+  // Header, query or form field name; unused for the host and body
+  readonly name: string
+  readonly operator: PredicateOperator
+  readonly value: string
+}
+
+// A stub as answered in the wizard, before it becomes the API's JSON shape
+export interface StubDraft {
+  // "ANY" leaves the method unmatched
+  readonly method: string
+  readonly path: string
+  readonly matchers: ReadonlyArray<MatcherDraft>
+  readonly status: number
+  readonly contentType: "application/json" | "text/plain"
+  readonly body: string
+}
+
+const METHODS = ["ANY", "GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"]
+
+const MATCHER_FIELDS: ReadonlyArray<MatcherField> = ["host", "headers", "query", "form", "body"]
+
+const NAMED_OPERATORS: ReadonlyArray<PredicateOperator> = ["equals", "contains", "startsWith", "matches", "exists"]
+
+const BODY_OPERATORS: ReadonlyArray<PredicateOperator> = ["equals", "contains", "startsWith", "matches", "matchesJson"]
+
+const HOST_OPERATORS: ReadonlyArray<PredicateOperator> = ["equals", "contains", "startsWith", "matches"]
+
+// Fields matched as a whole rather than by name
+const isUnnamed = (field: MatcherField): boolean => field === "host" || field === "body"
+
+// `{id}`, `{rest...}` and `*` make the path a template rather than an exact match
+const isTemplate = (path: string): boolean => /[{*]/.test(path)
+
+const predicateFor = (matcher: MatcherDraft): Record<string, unknown> => {
+  if (isUnnamed(matcher.field)) return { field: matcher.field, operator: matcher.operator, value: matcher.value }
+  const value = matcher.operator === "exists" ? true : matcher.value
+  return { field: matcher.field, operator: matcher.operator, value: { [matcher.name]: value } }
+}
+
+/**
+ * Turn wizard answers into a stub, validated exactly as the admin API would
+ * validate it. A JSON body that does not parse is sent as text.
+ */
+export const draftToStub = (draft: StubDraft) => {
+  const predicates = [
+    ...(draft.method !== "ANY" ? [{ field: "method", operator: "equals", value: draft.method }] : []),
+    { field: "path", operator: isTemplate(draft.path) ? "template" : "equals", value: draft.path },
+    ...draft.matchers.map(predicateFor)
+  ]
+  let body: unknown = draft.body
+  if (draft.contentType === "application/json") {
+    try {
+      body = JSON.parse(draft.body)
+    } catch {
+      body = draft.body
+    }
+  }
+  const response = {
+    status: draft.status,
+    headers: { "content-type": draft.contentType },
+    ...(draft.body !== "" ? { body } : {})
+  }
+  return Schema.decodeUnknown(CreateStubRequest)({ predicates, responses: [response] })
+}
+
+/**
+ * A request the draft would match, for previewing templates: path parameters are
+ * filled in as `<name>` and matcher values are sent as given.
+ */
+export const sampleRequest = (draft: StubDraft): RequestContext => {
+  const params: Record<string, string> = {}
+  const path = draft.path
+    .replace(/\{([^}.]+)(\.\.\.)?\}/g, (_, name: string) => {
+      const value = `<${name}>`
+      params[name] = value
+      return value
+    })
+    .replace(/\*/g, "x")
+  const headers: Record<string, string> = {}
+  const query: Record<string, string> = {}
+  const form: Record<string, string> = {}
+  let body: unknown = undefined
+  for (const matcher of draft.matchers) {
+    const sample = matcher.operator === "equals" || matcher.operator === "startsWith" ? matcher.value : "sample"
+    if (matcher.field === "host") headers["host"] = sample
+    else if (matcher.field === "headers") headers[matcher.name.toLowerCase()] = sample
+    else if (matcher.field === "query") query[matcher.name] = sample
+    else if (matcher.field === "form") form[matcher.name] = sample
+    else if (matcher.operator === "equals") body = matcher.value
+  }
+  return {
+    method: draft.method === "ANY" ? "GET" : draft.method,
+    path,
+    headers,
+    query,
+    body,
+    ...(Object.keys(form).length > 0 ? { form } : {}),
+    ...(Object.keys(params).length > 0 ? { params } : {})
+  }
+}
+
+// Render the stub's response to the sample request, as a client would see it
+export const previewResponse = (stub: CreateStubRequest, ctx: RequestContext): Effect.Effect<string> =>
+  Effect.promise(async () => {
+    const response = await buildResponse(stub.responses[0], ctx)
+    const headers = [...response.headers].map(([key, value]) => `${key}: ${value}`)
+    const text = await response.text()
+    return [`HTTP ${response.status}`, ...headers, "", text].join("\n")
+  })
+
+const required = (label: string) => (value: string) =>
+  value.trim() === "" ? Effect.fail(`${label} is required`) : Effect.succeed(value.trim())
+
+const promptMatcher = Effect.gen(function*() {
+  const field = yield* Prompt.select({
+    message: "Match on",
+    choices: MATCHER_FIELDS.map((f) => ({ title: f, value: f }))
+  })
+  const name = isUnnamed(field) ? "" : yield* Prompt.text({
+    message: field === "headers" ? "Header name" : "Field name",
+    validate: required("Name")
+  })
+  const operator = yield* Prompt.select({
+    message: "Operator",
+    choices: (field === "body" ? BODY_OPERATORS : field === "host" ? HOST_OPERATORS : NAMED_OPERATORS)
+      .map((o) => ({ title: o, value: o }))
+  })
+  const value = operator === "exists" ? "" : yield* Prompt.text({ message: "Value" })
+  return { field, name, operator, value }
+})
+
+/**
+ * Ask for a stub's method, path, matchers and response, show the response rendered
+ * against a sample request, and return the stub once confirmed. Returns undefined
+ * when the user declines, after printing the JSON so it can be saved instead.
+ */
+export const stubWizard = Effect.gen(function*() {
+  const method = yield* Prompt.select({ message: "Method", choices: METHODS.map((m) => ({ title: m, value: m })) })
+  const path = yield* Prompt.text({
+    message: "Path (use {name} to capture a segment, * for any)",
+    default: "/",
+    validate: (value) => value.startsWith("/") ? Effect.succeed(value) : Effect.fail("Path must start with /")
+  })
+  const matchers: Array<MatcherDraft> = []
+  while (
+    yield* Prompt.confirm({
+      message: matchers.length === 0 ? "Match on host, headers, query, form or body too?" : "Add another matcher?",
+      initial: false
+    })
+  ) {
+    matchers.push(yield* promptMatcher)
+  }
+  const status = yield* Prompt.integer({ message: "Response status", min: 100, max: 599 })
+  const contentType = yield* Prompt.select({
+    message: "Response body",
+    choices: [
+      { title: "JSON", value: "application/json" as const },
+      { title: "Text", value: "text/plain" as const }
+    ]
+  })
+  const body = yield* Prompt.text({
+    message: "Body (templates like {{request.params.id}} are filled in per request)",
+    validate: (value) => {
+      if (contentType !== "application/json" || value === "") return Effect.succeed(value)
+      try {
+        JSON.parse(value)
+        return Effect.succeed(value)
+      } catch {
+        return Effect.fail("Body is not valid JSON")
+      }
+    }
+  })
+
+  const draft: StubDraft = { method, path, matchers, status, contentType, body }
+  const stub = yield* draftToStub(draft)
+  const sample = sampleRequest(draft)
+  console.log(`\nPreview for ${sample.method} ${sample.path}:\n`)
+  console.log(yield* previewResponse(stub, sample))
+  console.log("")
+  if (yield* Prompt.confirm({ message: "Create this stub?", initial: true })) return stub
+  console.log(JSON.stringify(Schema.encodeSync(CreateStubRequest)(stub), null, 2))
+  return undefined
+})
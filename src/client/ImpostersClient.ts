@@ -1,11 +1,16 @@
-import type { HttpClient } from "@effect/platform"
-import { FetchHttpClient, HttpApiClient } from "@effect/platform"
+import { FetchHttpClient, HttpApiClient, HttpClient, HttpClientRequest } from "@effect/platform"
 import type { Effect } from "effect"
 import { Context, Layer } from "effect"
 import { AdminApi } from "../api/AdminApi"
 
-export const makeImpostersClient = (baseUrl?: string) =>
-  HttpApiClient.make(AdminApi, { baseUrl: baseUrl ?? "http://localhost:2525" })
+// `token` is sent as a bearer token, for admin servers started with ADMIN_TOKEN
+export const makeImpostersClient = (baseUrl?: string, token?: string) =>
+  HttpApiClient.make(AdminApi, {
+    baseUrl: baseUrl ?? "http://localhost:2525",
+    ...(token !== undefined
+      ? { transformClient: HttpClient.mapRequest(HttpClientRequest.bearerToken(token)) }
+      : {})
+  })
 
 export type ImpostersClientShape = Effect.Effect.Success<ReturnType<typeof makeImpostersClient>>
 
@@ -14,8 +19,11 @@ export class ImpostersClient extends Context.Tag("ImpostersClient")<
   ImpostersClientShape
 >() {}
 
-export const ImpostersClientLive = (baseUrl?: string): Layer.Layer<ImpostersClient, never, HttpClient.HttpClient> =>
-  Layer.effect(ImpostersClient, makeImpostersClient(baseUrl))
+export const ImpostersClientLive = (
+  baseUrl?: string,
+  token?: string
+): Layer.Layer<ImpostersClient, never, HttpClient.HttpClient> =>
+  Layer.effect(ImpostersClient, makeImpostersClient(baseUrl, token))
 
-export const ImpostersClientFetchLive = (baseUrl?: string): Layer.Layer<ImpostersClient> =>
-  ImpostersClientLive(baseUrl).pipe(Layer.provide(FetchHttpClient.layer))
+export const ImpostersClientFetchLive = (baseUrl?: string, token?: string): Layer.Layer<ImpostersClient> =>
+  ImpostersClientLive(baseUrl, token).pipe(Layer.provide(FetchHttpClient.layer))
@@ -6,4 +6,10 @@ export type { ImpostersClientShape } from "./ImpostersClient"
 
 export { makeTestServer, withImposter } from "./testing"
 
-export type { ImposterTestContext, StubConfig, WithImposterConfig } from "./testing"
+export type {
+  FieldPredicateConfig,
+  ImposterTestContext,
+  PredicateConfig,
+  StubConfig,
+  WithImposterConfig
+} from "./testing"
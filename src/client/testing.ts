@@ -1,26 +1,61 @@
 import { HttpApiBuilder } from "@effect/platform"
 import { Effect, Layer } from "effect"
 import type { NonEmptyString, PortNumber } from "../schemas/common"
-import type { CreateStubRequest } from "../schemas/StubSchema"
+import type { CreateStubRequest, PredicateExpression, ResponseDelay } from "../schemas/StubSchema"
 import { HandlerHttpClientLive } from "./HandlerHttpClient"
 import { ImpostersClient, ImpostersClientLive } from "./ImpostersClient"
 
+export interface FieldPredicateConfig {
+  readonly field:
+    | "method"
+    | "path"
+    | "headers"
+    | "query"
+    | "queryString"
+    | "body"
+    | "form"
+    | "host"
+    | "bodySize"
+    | "encoding"
+    | "contentType"
+    | "authorization"
+  readonly operator:
+    | "equals"
+    | "contains"
+    | "startsWith"
+    | "matches"
+    | "exists"
+    | "template"
+    | "equalToJson"
+    | "matchesJson"
+    | "between"
+    | "verifies"
+  readonly value: unknown
+  readonly caseSensitive?: boolean
+  readonly negate?: boolean
+  readonly xpath?: string
+}
+
+export type PredicateConfig =
+  | FieldPredicateConfig
+  | { readonly and: ReadonlyArray<PredicateConfig> }
+  | { readonly or: ReadonlyArray<PredicateConfig> }
+  | { readonly not: PredicateConfig }
+
 export interface StubConfig {
-  readonly predicates?: ReadonlyArray<{
-    readonly field: "method" | "path" | "headers" | "query" | "body"
-    readonly operator: "equals" | "contains" | "startsWith" | "matches" | "exists"
-    readonly value: unknown
-    readonly caseSensitive?: boolean
-  }>
+  readonly predicates?: ReadonlyArray<PredicateConfig>
   readonly responses: readonly [ResponseConfigInput, ...ReadonlyArray<ResponseConfigInput>]
   readonly responseMode?: "sequential" | "random" | "repeat"
+  readonly priority?: number
+  readonly scenario?: { readonly name: string; readonly requiredState?: string; readonly newState?: string }
+  readonly capture?: Readonly<Record<string, string>>
 }
 
 interface ResponseConfigInput {
   readonly status?: number
   readonly headers?: Record<string, string>
   readonly body?: unknown
-  readonly delay?: number
+  readonly delay?: ResponseDelay
 }
 
 export interface WithImposterConfig {
@@ -37,20 +72,32 @@ export interface ImposterTestContext {
 const asPort = (n: number) => n as PortNumber
 const asNes = (s: string) => s as NonEmptyString
 
-const toStubPayload = (stub: StubConfig): CreateStubRequest => ({
-  predicates: (stub.predicates ?? []).map((p) => ({
+const toPredicate = (p: PredicateConfig): PredicateExpression => {
+  if ("and" in p) return { and: p.and.map(toPredicate) }
+  if ("or" in p) return { or: p.or.map(toPredicate) }
+  if ("not" in p) return { not: toPredicate(p.not) }
+  return {
     field: p.field,
     operator: p.operator,
     value: p.value,
-    caseSensitive: p.caseSensitive ?? true
-  })),
+    caseSensitive: p.caseSensitive ?? true,
+    ...(p.negate !== undefined ? { negate: p.negate } : {}),
+    ...(p.xpath !== undefined ? { xpath: p.xpath } : {})
+  }
+}
+
+const toStubPayload = (stub: StubConfig): CreateStubRequest => ({
+  predicates: (stub.predicates ?? []).map(toPredicate),
   responses: stub.responses.map((r) => ({
     status: r.status ?? 200,
     ...(r.headers !== undefined ? { headers: r.headers } : {}),
     ...(r.body !== undefined ? { body: r.body } : {}),
     ...(r.delay !== undefined ? { delay: r.delay } : {})
   })) as unknown as CreateStubRequest["responses"],
-  responseMode: stub.responseMode ?? "sequential"
+  responseMode: stub.responseMode ?? "sequential",
+  ...(stub.priority !== undefined ? { priority: stub.priority } : {}),
+  ...(stub.scenario !== undefined ? { scenario: stub.scenario as CreateStubRequest["scenario"] & {} } : {}),
+  ...(stub.capture !== undefined ? { capture: stub.capture } : {})
 })
 
 export const withImposter = <A, E>(
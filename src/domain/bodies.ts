@@ -0,0 +1,118 @@
+import { createHash } from "node:crypto"
+import type { ResponseConfig } from "../schemas/StubSchema"
+import { canonicalJson } from "./stubIds"
+
+// Bodies shorter than this, as canonical JSON, aren't worth sharing
+export const MIN_SHARED_BODY_BYTES = 128
+
+// Names a body by its content: equal bodies, whatever their key order, get the same hash
+export const bodyHash = (body: unknown): string =>
+  `sha256:${createHash("sha256").update(canonicalJson(body)).digest("hex")}`
+
+interface HasResponses {
+  readonly responses: readonly [ResponseConfig, ...Array<ResponseConfig>]
+}
+
+const mapResponses = <S extends HasResponses>(stub: S, f: (response: ResponseConfig) => ResponseConfig): S => {
+  const [first, ...rest] = stub.responses
+  return { ...stub, responses: [f(first), ...rest.map(f)] }
+}
+
+/**
+ * Keeps one copy of each large object body however many stubs serve it, such as the
+ * identical pages of an imported recording. Only bodies that serialize the same, key order
+ * included, are shared, so every stub is served its body as it was given. Copies are held
+ * weakly, so a body goes once no stub serves it any more. Strings can't be held weakly and
+ * are left as they are.
+ */
+export const makeBodyPool = () => {
+  const pool = new Map<string, WeakRef<object>>()
+  const seen = new WeakSet<object>()
+  const collected = new FinalizationRegistry<string>((hash) => {
+    if (pool.get(hash)?.deref() === undefined) pool.delete(hash)
+  })
+
+  const intern = (body: unknown): unknown => {
+    if (body === null || typeof body !== "object" || seen.has(body)) return body
+    const json = JSON.stringify(body)
+    if (json.length < MIN_SHARED_BODY_BYTES) return body
+    const hash = createHash("sha256").update(json).digest("hex")
+    const shared = pool.get(hash)?.deref()
+    if (shared !== undefined) return shared
+    pool.set(hash, new WeakRef(body))
+    seen.add(body)
+    collected.register(body, hash)
+    return body
+  }
+
+  // `stub` with each of its bodies swapped for the pool's copy
+  const internStub = <S extends HasResponses>(stub: S): S =>
+    mapResponses(
+      stub,
+      (response) => response.body !== undefined ? { ...response, body: intern(response.body) } : response
+    )
+
+  return { internStub, size: () => pool.size }
+}
+
+export type BodyPool = ReturnType<typeof makeBodyPool>
+
+/**
+ * Moves each large body more than one response serves into `bodies`, keyed by its hash, and
+ * has those responses name it with `bodyRef` instead. Bodies served once stay where they are.
+ */
+export const hoistBodies = <S extends HasResponses>(
+  stubs: ReadonlyArray<S>
+): { readonly stubs: ReadonlyArray<S>; readonly bodies: Record<string, unknown> } => {
+  const counts = new Map<string, number>()
+  const hashOf = new Map<unknown, string | undefined>()
+  const hash = (body: unknown) => {
+    if (!hashOf.has(body)) {
+      hashOf.set(body, canonicalJson(body).length >= MIN_SHARED_BODY_BYTES ? bodyHash(body) : undefined)
+    }
+    return hashOf.get(body)
+  }
+  for (const stub of stubs) {
+    for (const response of stub.responses) {
+      const key = response.body !== undefined ? hash(response.body) : undefined
+      if (key !== undefined) counts.set(key, (counts.get(key) ?? 0) + 1)
+    }
+  }
+
+  const bodies: Record<string, unknown> = {}
+  const hoisted = stubs.map((stub) =>
+    mapResponses(stub, (response) => {
+      const key = response.body !== undefined ? hash(response.body) : undefined
+      if (key === undefined || (counts.get(key) ?? 0) < 2) return response
+      bodies[key] = response.body
+      const { body: _, ...rest } = response
+      return { ...rest, bodyRef: key }
+    })
+  )
+  return { stubs: hoisted, bodies }
+}
+
+// `bodyRef`s not found in `bodies`
+export const missingBodies = (
+  stubs: ReadonlyArray<HasResponses>,
+  bodies: Readonly<Record<string, unknown>>
+): ReadonlyArray<string> => [
+  ...new Set(
+    stubs.flatMap((stub) =>
+      stub.responses.flatMap((r) => r.bodyRef !== undefined && !(r.bodyRef in bodies) ? [r.bodyRef] : [])
+    )
+  )
+]
+
+// Puts each body named by a `bodyRef` back in place
+export const inlineBodies = <S extends HasResponses>(
+  stubs: ReadonlyArray<S>,
+  bodies: Readonly<Record<string, unknown>>
+): ReadonlyArray<S> =>
+  stubs.map((stub) =>
+    mapResponses(stub, (response) => {
+      if (response.bodyRef === undefined) return response
+      const { bodyRef, ...rest } = response
+      return bodyRef in bodies ? { ...rest, body: bodies[bodyRef] } : response
+    })
+  )
@@ -5,7 +5,13 @@ import * as Duration from "effect/Duration"
 import * as Effect from "effect/Effect"
 import type * as ParseResult from "effect/ParseResult"
 import * as Schema from "effect/Schema"
+import type { CorsRule } from "../schemas/CorsSchema"
+import type { OutboundOptions, ScrubRule } from "../schemas/StubSchema"
+import type { StubOrder } from "../matching/RequestMatcher"
+import type { HttpParserMode } from "../server/ServerFactory"
+import type { TlsSettings } from "../server/Tls"
 import { Uuid } from "../services/Uuid"
+import type { StubIdMode } from "./stubIds"
 
 // Schemas for validation
 const ImposterNameSchema = Schema.String.pipe(
@@ -33,6 +39,12 @@ export interface ProxyConfigDomain {
   readonly removeHeaders: ReadonlyArray<string>
   readonly followRedirects: boolean
   readonly timeout: number
+  readonly pathRewrite?: { readonly pattern: string; readonly replacement: string } | undefined
+  readonly body?: unknown
+  readonly scrub?: ReadonlyArray<ScrubRule> | undefined
+  readonly normalize?: boolean | undefined
+  readonly outbound?: OutboundOptions | undefined
+  readonly cache?: boolean | undefined
 }
 
 // Domain types using tagged interfaces
@@ -44,6 +56,16 @@ export interface ImposterConfig {
   readonly status: ImposterStatus
   readonly createdAt: DateTime.Utc
   readonly proxy?: ProxyConfigDomain | undefined
+  // Served over HTTPS when set
+  readonly tls?: TlsSettings | undefined
+  readonly stubIds?: StubIdMode | undefined
+  readonly httpParser?: HttpParserMode | undefined
+  readonly stubOrder?: StubOrder | undefined
+  readonly cors?: ReadonlyArray<CorsRule> | undefined
+  // Responses of this name answer in place of the next in turn
+  readonly example?: string | undefined
+  // The profile it was created from
+  readonly profile?: string | undefined
 }
 
 export const ImposterConfig = Data.tagged<ImposterConfig>("ImposterConfig")
@@ -79,6 +101,10 @@ export class ImposterNotFoundError extends Data.TaggedError("ImposterNotFoundErr
   readonly id: string
 }> {}
 
+export class ImposterExistsError extends Data.TaggedError("ImposterExistsError")<{
+  readonly id: string
+}> {}
+
 /**
  * Parses and validates imposter creation request
  */
@@ -184,3 +210,8 @@ export const canStart = (config: ImposterConfig): boolean => config.status === "
  * Checks if imposter can be stopped
  */
 export const canStop = (config: ImposterConfig): boolean => config.status === "running" || config.status === "stopping"
+
+/**
+ * Name prefix shared by imposters that belong to an environment ("<env>/<imposter>")
+ */
+export const environmentPrefix = (envName: string): string => `${envName}/`
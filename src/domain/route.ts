@@ -182,6 +182,53 @@ export const substituteParams = (params: Record<string, string>) => (body: unkno
   return body
 }
 
+const decodeSegment = (segment: string): string => {
+  try {
+    return decodeURIComponent(segment)
+  } catch {
+    return segment
+  }
+}
+
+/**
+ * Matches a request path against a route template. `{name}` captures a segment as a
+ * parameter and `*` matches any single segment without capturing it. A trailing
+ * `{name...}` matches the remaining segments (possibly none) and captures them joined by "/".
+ */
+export const matchPath = (
+  template: string,
+  path: string,
+  caseSensitive = true
+): Option.Option<Record<string, string>> => {
+  const templateSegments = template.split("/")
+  let pathSegments = path.split("/")
+  const params: Record<string, string> = {}
+
+  const restName = /^\{(\w+)\.\.\.\}$/.exec(templateSegments[templateSegments.length - 1] ?? "")?.[1]
+  if (restName !== undefined) {
+    templateSegments.pop()
+    // "/files/{rest...}" also matches "/files" itself
+    if (pathSegments.length < templateSegments.length) return Option.none()
+    params[restName] = pathSegments.slice(templateSegments.length).map(decodeSegment).join("/")
+    pathSegments = pathSegments.slice(0, templateSegments.length)
+  }
+  if (templateSegments.length !== pathSegments.length) return Option.none()
+
+  for (let i = 0; i < templateSegments.length; i++) {
+    const expected = templateSegments[i]!
+    const actual = pathSegments[i]!
+    const name = /^\{(\w+)\}$/.exec(expected)?.[1]
+    if (expected === "*" || name !== undefined) {
+      if (actual === "") return Option.none()
+      if (name !== undefined) params[name] = decodeSegment(actual)
+      continue
+    }
+    const equal = caseSensitive ? expected === actual : expected.toLowerCase() === actual.toLowerCase()
+    if (!equal) return Option.none()
+  }
+  return Option.some(params)
+}
+
 /**
  * Creates a response with substituted parameters
  */
@@ -0,0 +1,35 @@
+import { createHash } from "node:crypto"
+import type { PredicateExpression } from "../schemas/StubSchema"
+
+// How an imposter names stubs it is given without an ID: random short IDs, or IDs derived
+// from what the stub matches, which stay the same each time a config is loaded
+export type StubIdMode = "random" | "content"
+
+// JSON with object keys sorted, so equal values always serialize the same way
+export const canonicalJson = (value: unknown): string => {
+  if (Array.isArray(value)) return `[${value.map(canonicalJson).join(",")}]`
+  if (value !== null && typeof value === "object") {
+    const entries = Object.entries(value)
+      .filter(([, v]) => v !== undefined)
+      .sort(([a], [b]) => a < b ? -1 : a > b ? 1 : 0)
+    return `{${entries.map(([k, v]) => `${JSON.stringify(k)}:${canonicalJson(v)}`).join(",")}}`
+  }
+  return JSON.stringify(value) ?? "null"
+}
+
+/**
+ * An 8-character ID hashed from the stub's predicates (method, path and every other
+ * matcher), the same length as random IDs. Key order and omitted defaults don't change it.
+ */
+export const contentStubId = (predicates: ReadonlyArray<PredicateExpression>): string =>
+  createHash("sha256").update(canonicalJson(predicates)).digest("hex").slice(0, 8)
+
+/**
+ * `id`, or `id-2`, `id-3`, ... for stubs that match the same requests as one already
+ * named, so they keep their load order.
+ */
+export const uniqueStubId = (id: string, taken: ReadonlySet<string>): string => {
+  let candidate = id
+  for (let n = 2; taken.has(candidate); n++) candidate = `${id}-${n}`
+  return candidate
+}
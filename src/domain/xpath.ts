@@ -0,0 +1,344 @@
+import * as Data from "effect/Data"
+
+// A small XML reader and XPath subset for matching request bodies. Element and attribute
+// names are compared by local name, so namespace prefixes never have to line up with the client's.
+
+export interface XmlElement {
+  readonly name: string
+  readonly attributes: Record<string, string>
+  readonly children: ReadonlyArray<XmlElement | string>
+}
+
+export class XmlSyntaxError extends Data.TaggedError("XmlSyntaxError")<{
+  readonly message: string
+}> {}
+
+const ENTITIES: Record<string, string> = { lt: "<", gt: ">", amp: "&", quot: "\"", apos: "'" }
+
+const decodeEntities = (text: string): string =>
+  text.replace(/&(#x[0-9a-fA-F]+|#\d+|\w+);/g, (raw, ref: string) => {
+    if (ref.startsWith("#x")) return String.fromCodePoint(parseInt(ref.slice(2), 16))
+    if (ref.startsWith("#")) return String.fromCodePoint(parseInt(ref.slice(1), 10))
+    return ENTITIES[ref] ?? raw
+  })
+
+const localName = (name: string): string => name.slice(name.indexOf(":") + 1)
+
+const NAME = /[^\s/>=<"']+/y
+const ATTRIBUTE = /\s*([^\s/>=<"']+)\s*=\s*("([^"]*)"|'([^']*)')/y
+
+/**
+ * Parse an XML document into its root element. The prolog, comments, processing
+ * instructions and DOCTYPE are skipped; CDATA sections become text.
+ */
+export const parseXml = (source: string): XmlElement => {
+  let pos = 0
+
+  const fail = (reason: string): never => {
+    throw new XmlSyntaxError({ message: `${reason} at offset ${pos}` })
+  }
+
+  const skipUntil = (terminator: string) => {
+    const end = source.indexOf(terminator, pos)
+    if (end === -1) fail(`Missing ${terminator}`)
+    pos = end + terminator.length
+  }
+
+  // Skips markup that carries no content; returns false at anything else
+  const skipMisc = (): boolean => {
+    if (source.startsWith("<?", pos)) skipUntil("?>")
+    else if (source.startsWith("<!--", pos)) skipUntil("-->")
+    else if (source.startsWith("<!DOCTYPE", pos)) {
+      const subset = source.indexOf("[", pos)
+      const close = source.indexOf(">", pos)
+      if (subset !== -1 && subset < close) {
+        pos = subset
+        skipUntil("]")
+      }
+      skipUntil(">")
+    } else return false
+    return true
+  }
+
+  // Sticky regexes match exactly at the current position
+  const readAt = (pattern: RegExp): RegExpExecArray | null => {
+    pattern.lastIndex = pos
+    return pattern.exec(source)
+  }
+
+  const skipWhitespace = () => {
+    while (pos < source.length && /\s/.test(source[pos]!)) pos++
+  }
+
+  const parseElement = (): XmlElement => {
+    pos++ // "<"
+    const name = readAt(NAME)?.[0] ?? fail("Expected an element name")
+    pos += name.length
+    const attributes: Record<string, string> = {}
+    for (;;) {
+      const attribute = readAt(ATTRIBUTE)
+      if (attribute === null) break
+      attributes[attribute[1]!] = decodeEntities(attribute[3] ?? attribute[4] ?? "")
+      pos += attribute[0].length
+    }
+    skipWhitespace()
+    if (source.startsWith("/>", pos)) {
+      pos += 2
+      return { name, attributes, children: [] }
+    }
+    if (source[pos] !== ">") fail(`Malformed start tag <${name}>`)
+    pos++
+
+    const children: Array<XmlElement | string> = []
+    for (;;) {
+      if (pos >= source.length) fail(`Unclosed element <${name}>`)
+      if (source.startsWith("</", pos)) {
+        pos += 2
+        const closing = readAt(NAME)?.[0]
+        if (closing !== name) fail(`Expected </${name}>`)
+        pos += name.length
+        skipWhitespace()
+        if (source[pos] !== ">") fail(`Malformed end tag </${name}>`)
+        pos++
+        return { name, attributes, children }
+      }
+      if (source.startsWith("<![CDATA[", pos)) {
+        const start = pos + 9
+        skipUntil("]]>")
+        children.push(source.slice(start, pos - 3))
+      } else if (skipMisc()) {
+        continue
+      } else if (source[pos] === "<") {
+        children.push(parseElement())
+      } else {
+        const end = source.indexOf("<", pos)
+        const text = source.slice(pos, end === -1 ? source.length : end)
+        children.push(decodeEntities(text))
+        pos += text.length
+      }
+    }
+  }
+
+  for (;;) {
+    skipWhitespace()
+    if (!skipMisc()) break
+  }
+  if (source[pos] !== "<") fail("Expected a root element")
+  const root = parseElement()
+  for (;;) {
+    skipWhitespace()
+    if (!skipMisc()) break
+  }
+  if (pos < source.length) fail("Unexpected content after the root element")
+  return root
+}
+
+// XPath subset: `/` and `//` steps over element names, `*`, `@attr`, `@*`, `text()` and `.`,
+// with predicates `[n]`, `[last()]`, `[@attr]`, `[child]` and `[@attr='v']`, `[child='v']`,
+// `[text()='v']`, `[.='v']` (also with `!=`).
+type NodeTest =
+  | { readonly kind: "element"; readonly name: string }
+  | { readonly kind: "attribute"; readonly name: string }
+  | { readonly kind: "text" }
+  | { readonly kind: "self" }
+
+type StepPredicate =
+  | { readonly kind: "position"; readonly index: number }
+  | { readonly kind: "last" }
+  | {
+    readonly kind: "compare"
+    readonly target: NodeTest
+    readonly comparison?: { readonly op: "=" | "!="; readonly value: string }
+  }
+
+export interface XPathStep {
+  readonly descendant: boolean
+  readonly test: NodeTest
+  readonly predicates: ReadonlyArray<StepPredicate>
+}
+
+export class XPathSyntaxError extends Data.TaggedError("XPathSyntaxError")<{
+  readonly message: string
+}> {}
+
+const parseNodeTest = (source: string, expression: string): NodeTest => {
+  if (source === "text()") return { kind: "text" }
+  if (source === ".") return { kind: "self" }
+  if (source.startsWith("@")) {
+    const name = source.slice(1)
+    if (!/^(\*|[\w.-]+(:[\w.-]+)?)$/.test(name)) {
+      throw new XPathSyntaxError({ message: `Invalid attribute in ${expression}` })
+    }
+    return { kind: "attribute", name: localName(name) }
+  }
+  if (!/^(\*|[\w.-]+(:[\w.-]+)?)$/.test(source)) {
+    throw new XPathSyntaxError({ message: `Unsupported step ${JSON.stringify(source)} in ${expression}` })
+  }
+  return { kind: "element", name: localName(source) }
+}
+
+const parseStepPredicate = (source: string, expression: string): StepPredicate => {
+  const body = source.trim()
+  if (/^\d+$/.test(body)) {
+    const index = Number(body)
+    if (index < 1) throw new XPathSyntaxError({ message: `Positions start at 1 in ${expression}` })
+    return { kind: "position", index }
+  }
+  if (body === "last()") return { kind: "last" }
+  const comparison = /^(.+?)\s*(!=|=)\s*(?:'([^']*)'|"([^"]*)")$/.exec(body)
+  if (comparison === null) return { kind: "compare", target: parseNodeTest(body, expression) }
+  return {
+    kind: "compare",
+    target: parseNodeTest(comparison[1]!.trim(), expression),
+    comparison: { op: comparison[2] as "=" | "!=", value: comparison[3] ?? comparison[4] ?? "" }
+  }
+}
+
+// Split on `/` outside brackets and quotes
+const splitSteps = (expression: string): Array<string> => {
+  const parts: Array<string> = []
+  let current = ""
+  let depth = 0
+  let quote: string | undefined
+  for (const ch of expression) {
+    if (quote !== undefined) {
+      if (ch === quote) quote = undefined
+    } else if (ch === "'" || ch === "\"") quote = ch
+    else if (ch === "[") depth++
+    else if (ch === "]") depth--
+    else if (ch === "/" && depth === 0) {
+      parts.push(current)
+      current = ""
+      continue
+    }
+    current += ch
+  }
+  if (quote !== undefined || depth !== 0) {
+    throw new XPathSyntaxError({ message: `Unbalanced brackets or quotes in ${expression}` })
+  }
+  parts.push(current)
+  return parts
+}
+
+const parseStep = (source: string, descendant: boolean, expression: string): XPathStep => {
+  const open = source.indexOf("[")
+  const test = parseNodeTest((open === -1 ? source : source.slice(0, open)).trim(), expression)
+  const predicates: Array<StepPredicate> = []
+  let rest = open === -1 ? "" : source.slice(open)
+  while (rest.length > 0) {
+    const match = /^\[((?:[^\]'"]|'[^']*'|"[^"]*")*)\]\s*/.exec(rest)
+    if (match === null) throw new XPathSyntaxError({ message: `Malformed predicate in ${expression}` })
+    predicates.push(parseStepPredicate(match[1]!, expression))
+    rest = rest.slice(match[0].length)
+  }
+  return { descendant, test, predicates }
+}
+
+const compiled = new Map<string, ReadonlyArray<XPathStep>>()
+
+/**
+ * Compile an XPath expression. Paths are always taken from the document, so `a/b`
+ * and `/a/b` are the same. Throws `XPathSyntaxError` for anything outside the subset.
+ */
+export const compileXPath = (expression: string): ReadonlyArray<XPathStep> => {
+  const cached = compiled.get(expression)
+  if (cached !== undefined) return cached
+  const parts = splitSteps(expression.trim())
+  if (parts[0] === "") parts.shift()
+  const steps: Array<XPathStep> = []
+  let descendant = false
+  for (const part of parts) {
+    if (part.trim() === "") {
+      if (descendant) throw new XPathSyntaxError({ message: `Empty step in ${expression}` })
+      descendant = true
+      continue
+    }
+    steps.push(parseStep(part, descendant, expression))
+    descendant = false
+  }
+  if (steps.length === 0 || descendant) throw new XPathSyntaxError({ message: `Missing step in ${expression}` })
+  steps.forEach((step, i) => {
+    if (i < steps.length - 1 && (step.test.kind === "attribute" || step.test.kind === "text")) {
+      throw new XPathSyntaxError({ message: `Only the last step can select attributes or text in ${expression}` })
+    }
+  })
+  compiled.set(expression, steps)
+  return steps
+}
+
+// Selected nodes: elements, or the string value of an attribute or text node
+export type XPathNode = XmlElement | { readonly value: string }
+
+const isElement = (node: XmlElement | string): node is XmlElement => typeof node !== "string"
+
+const textOf = (element: XmlElement): string =>
+  element.children.map((child) => isElement(child) ? textOf(child) : child).join("")
+
+// String value of a node; surrounding whitespace is dropped so pretty-printed documents compare as expected
+export const stringValue = (node: XPathNode): string => ("value" in node ? node.value : textOf(node)).trim()
+
+const descendantsOrSelf = (element: XmlElement): Array<XmlElement> => [
+  element,
+  ...element.children.filter(isElement).flatMap(descendantsOrSelf)
+]
+
+const selectFrom = (element: XmlElement, test: NodeTest): Array<XPathNode> => {
+  switch (test.kind) {
+    case "element":
+      return element.children.filter(
+        (child): child is XmlElement => isElement(child) && (test.name === "*" || localName(child.name) === test.name)
+      )
+    case "attribute":
+      return Object.entries(element.attributes)
+        .filter(([name]) => !name.startsWith("xmlns") && (test.name === "*" || localName(name) === test.name))
+        .map(([, value]) => ({ value }))
+    case "text":
+      return element.children.filter((child): child is string => !isElement(child)).map((value) => ({ value }))
+    case "self":
+      return [element]
+  }
+}
+
+const satisfies = (node: XPathNode, predicate: StepPredicate, index: number, size: number): boolean => {
+  switch (predicate.kind) {
+    case "position":
+      return index + 1 === predicate.index
+    case "last":
+      return index + 1 === size
+    case "compare": {
+      if ("value" in node) return false
+      const targets = selectFrom(node, predicate.target)
+      const comparison = predicate.comparison
+      if (comparison === undefined) return targets.length > 0
+      const equal = targets.some((target) => stringValue(target) === comparison.value)
+      return comparison.op === "=" ? equal : targets.length > 0 && !equal
+    }
+  }
+}
+
+/**
+ * Evaluate a compiled XPath against a document, returning the selected nodes in document order.
+ */
+export const selectNodes = (root: XmlElement, steps: ReadonlyArray<XPathStep>): Array<XPathNode> => {
+  // The document node sits above the root element
+  let context: Array<XmlElement> = [{ name: "", attributes: {}, children: [root] }]
+  let selected: Array<XPathNode> = []
+  for (const step of steps) {
+    const origins = step.descendant ? [...new Set(context.flatMap(descendantsOrSelf))] : context
+    selected = []
+    for (const origin of origins) {
+      let candidates = selectFrom(origin, step.test)
+      for (const predicate of step.predicates) {
+        candidates = candidates.filter((node, i, all) => satisfies(node, predicate, i, all.length))
+      }
+      selected.push(...candidates)
+    }
+    selected = [...new Set(selected)]
+    context = selected.filter((node): node is XmlElement => !("value" in node))
+  }
+  return selected
+}
+
+// The string values an XPath selects from a document
+export const selectValues = (root: XmlElement, expression: string): Array<string> =>
+  selectNodes(root, compileXPath(expression)).map(stringValue)
@@ -0,0 +1,295 @@
+import * as Data from "effect/Data"
+
+// A small YAML reader for environment manifests: block mappings and sequences, flow collections
+// (JSON included), plain, quoted and block scalars, and comments. Anchors, aliases, tags,
+// multi-line plain or quoted scalars and further documents are refused rather than guessed at.
+
+export class YamlSyntaxError extends Data.TaggedError("YamlSyntaxError")<{
+  readonly message: string
+}> {}
+
+const INT = /^[-+]?[0-9]+$/
+const FLOAT = /^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$/
+const BLOCK_HEADER = /^([|>])([-+]?)([1-9]?)([-+]?)$/
+
+// Sets `key` as an own property, so `__proto__` and the like stay data
+const setKey = (target: Record<string, unknown>, key: string, value: unknown) =>
+  Object.defineProperty(target, key, { value, enumerable: true, writable: true, configurable: true })
+
+// The text before a `#` that starts a comment (at the start, or after whitespace) outside quotes
+const stripComment = (text: string): string => {
+  let quote: string | undefined
+  for (let i = 0; i < text.length; i++) {
+    const c = text[i]!
+    if (quote !== undefined) {
+      if (c === "\\" && quote === "\"") i++
+      else if (c === quote) quote = undefined
+    } else if (c === "\"" || c === "'") {
+      quote = c
+    } else if (c === "#" && (i === 0 || /\s/.test(text[i - 1]!))) {
+      return text.slice(0, i).trimEnd()
+    }
+  }
+  return text.trimEnd()
+}
+
+// How many brackets `text` leaves open, outside quotes
+const openBrackets = (text: string): number => {
+  let depth = 0
+  let quote: string | undefined
+  for (let i = 0; i < text.length; i++) {
+    const c = text[i]!
+    if (quote !== undefined) {
+      if (c === "\\" && quote === "\"") i++
+      else if (c === quote) quote = undefined
+    } else if (c === "\"" || c === "'") quote = c
+    else if (c === "[" || c === "{") depth++
+    else if (c === "]" || c === "}") depth--
+  }
+  return depth
+}
+
+const isSequenceItem = (text: string) => text === "-" || text.startsWith("- ")
+
+/**
+ * Parse a YAML document into the value it holds. Scalars follow the YAML 1.2 core schema, so
+ * `true`, `null`, `42` and `4.2` are typed and anything else is a string.
+ */
+export const parseYaml = (source: string): unknown => {
+  const raw = source.replace(/^\uFEFF/, "").split(/\r?\n/)
+  let row = 0
+
+  const fail = (line: number, reason: string): never => {
+    throw new YamlSyntaxError({ message: `${reason} on line ${line}` })
+  }
+
+  // The next line with content, without consuming it
+  const peek = (): { readonly number: number; readonly indent: number; readonly text: string } | undefined => {
+    for (; row < raw.length; row++) {
+      const line = raw[row]!
+      const text = stripComment(line.trim())
+      if (text === "") continue
+      const indentation = /^[ \t]*/.exec(line)![0]
+      if (indentation.includes("\t")) fail(row + 1, "Tabs can't indent YAML")
+      return { number: row + 1, indent: indentation.length, text }
+    }
+    return undefined
+  }
+
+  const plainScalar = (text: string, line: number): unknown => {
+    if (/^[&*!]/.test(text)) fail(line, `Anchors, aliases and tags aren't supported: ${text}`)
+    if (/^[|>]/.test(text)) fail(line, `A block scalar header can't be followed by text: ${text}`)
+    if (text === "" || text === "~" || /^(null|Null|NULL)$/.test(text)) return null
+    if (/^(true|True|TRUE)$/.test(text)) return true
+    if (/^(false|False|FALSE)$/.test(text)) return false
+    if (/^0x[0-9a-fA-F]+$/.test(text)) return parseInt(text.slice(2), 16)
+    if (/^0o[0-7]+$/.test(text)) return parseInt(text.slice(2), 8)
+    if (INT.test(text) || FLOAT.test(text)) return Number(text)
+    return text
+  }
+
+  // The quoted scalar `text` starts with, and the length of its source
+  const quotedScalar = (text: string, line: number): { readonly value: string; readonly length: number } => {
+    const quote = text[0]!
+    for (let i = 1; i < text.length; i++) {
+      if (quote === "\"" && text[i] === "\\") {
+        i++
+      } else if (quote === "'" && text[i] === "'" && text[i + 1] === "'") {
+        i++
+      } else if (text[i] === quote) {
+        const body = text.slice(1, i)
+        if (quote === "'") return { value: body.replaceAll("''", "'"), length: i + 1 }
+        try {
+          return { value: JSON.parse(`"${body.replaceAll("\t", "\\t")}"`) as string, length: i + 1 }
+        } catch {
+          return fail(line, `Unsupported escape in ${text.slice(0, i + 1)}`)
+        }
+      }
+    }
+    return fail(line, `Unterminated string ${text}`)
+  }
+
+  // `key: rest` with the key unquoted, or undefined when `text` isn't a mapping entry
+  const mappingEntry = (text: string, line: number): { readonly key: string; readonly rest: string } | undefined => {
+    if (text.startsWith("\"") || text.startsWith("'")) {
+      const { length, value } = quotedScalar(text, line)
+      const colon = /^\s*:(\s|$)/.exec(text.slice(length))
+      return colon !== null ? { key: value, rest: text.slice(length + colon[0].length).trim() } : undefined
+    }
+    if (/^[[{]/.test(text)) return undefined
+    const match = /^(.+?)\s*:(\s|$)/.exec(text)
+    return match !== null ? { key: match[1]!, rest: text.slice(match[0].length).trim() } : undefined
+  }
+
+  // `[...]` or `{...}`, which may run over several lines
+  const flowCollection = (first: string, line: number): unknown => {
+    let text = first
+    while (openBrackets(text) > 0 && row < raw.length) text += " " + stripComment(raw[row++]!.trim())
+    let pos = 0
+    const broken = (reason: string): never => fail(line, `${reason} in ${text}`)
+    const skipWhitespace = () => {
+      while (pos < text.length && /\s/.test(text[pos]!)) pos++
+    }
+    const scalar = (stop: RegExp): unknown => {
+      if (text[pos] === "\"" || text[pos] === "'") {
+        const { length, value } = quotedScalar(text.slice(pos), line)
+        pos += length
+        return value
+      }
+      const start = pos
+      while (pos < text.length && !stop.test(text.slice(pos, pos + 2))) pos++
+      return plainScalar(text.slice(start, pos).trim(), line)
+    }
+    // Skips the comma between items; false at the closing bracket
+    const separator = (close: string): boolean => {
+      skipWhitespace()
+      if (text[pos] === ",") {
+        pos++
+        skipWhitespace()
+        return text[pos] !== close
+      }
+      if (text[pos] !== close) broken(`Expected , or ${close}`)
+      return false
+    }
+    const value = (): unknown => {
+      skipWhitespace()
+      if (pos >= text.length) broken("Unexpected end")
+      if (text[pos] === "[") {
+        pos++
+        skipWhitespace()
+        const items: Array<unknown> = []
+        if (text[pos] !== "]") {
+          do items.push(value()); while (separator("]"))
+        }
+        pos++
+        return items
+      }
+      if (text[pos] === "{") {
+        pos++
+        skipWhitespace()
+        const entries: Record<string, unknown> = {}
+        if (text[pos] !== "}") {
+          do {
+            const key = String(scalar(/^(:|,|\})/))
+            skipWhitespace()
+            if (text[pos] !== ":") broken("Expected :")
+            pos++
+            if (Object.hasOwn(entries, key)) broken(`Duplicate key "${key}"`)
+            setKey(entries, key, value())
+          } while (separator("}"))
+        }
+        pos++
+        return entries
+      }
+      return scalar(/^(,|\]|\}|: )/)
+    }
+    const result = value()
+    skipWhitespace()
+    if (pos < text.length) broken("Unexpected text after the collection")
+    return result
+  }
+
+  // `|` keeps line breaks and `>` folds them into spaces; `-` strips the final break and `+` keeps them all
+  const blockScalar = (header: RegExpExecArray, ownerIndent: number): string => {
+    const folded = header[1] === ">"
+    const chomp = header[2] || header[4]
+    let indent = header[3] ? Math.max(ownerIndent, 0) + Number(header[3]) : undefined
+    const lines: Array<string> = []
+    for (; row < raw.length; row++) {
+      const line = raw[row]!
+      if (line.trim() === "") {
+        lines.push("")
+        continue
+      }
+      const lineIndent = line.length - line.trimStart().length
+      indent ??= lineIndent
+      if (lineIndent <= ownerIndent || lineIndent < indent) break
+      lines.push(line.slice(indent))
+    }
+    let trailing = 0
+    while (trailing < lines.length && lines[lines.length - 1 - trailing] === "") trailing++
+    const content = lines.slice(0, lines.length - trailing)
+    if (content.length === 0) return chomp === "+" ? "\n".repeat(trailing) : ""
+    let text = content[0]!
+    for (let i = 1; i < content.length; i++) {
+      const [previous, current] = [content[i - 1]!, content[i]!]
+      const moreIndented = /^\s/.test(previous) || /^\s/.test(current)
+      if (!folded) text += "\n" + current
+      else if (current === "") text += "\n"
+      else if (previous === "") text += moreIndented ? "\n" + current : current
+      else text += (moreIndented ? "\n" : " ") + current
+    }
+    if (chomp === "-") return text
+    return text + "\n".repeat(chomp === "+" ? trailing + 1 : 1)
+  }
+
+  // A value starting on the line already read, in a construct indented at `ownerIndent`
+  const inlineValue = (text: string, line: number, ownerIndent: number): unknown => {
+    const header = BLOCK_HEADER.exec(text)
+    if (header !== null) return blockScalar(header, ownerIndent)
+    if (text.startsWith("[") || text.startsWith("{")) return flowCollection(text, line)
+    const next = peek()
+    if (next !== undefined && next.indent > ownerIndent) fail(next.number, "Unexpected indentation")
+    if (text.startsWith("\"") || text.startsWith("'")) {
+      const { length, value } = quotedScalar(text, line)
+      if (length < text.length) fail(line, `Unexpected text after ${text.slice(0, length)}`)
+      return value
+    }
+    return plainScalar(text, line)
+  }
+
+  // The node whose first line is indented at least `min`, or null when there is none
+  const node = (min: number): unknown => {
+    const line = peek()
+    if (line === undefined || line.indent < min) return null
+    if (isSequenceItem(line.text)) return sequence(line.indent)
+    if (mappingEntry(line.text, line.number) !== undefined) return mapping(line.indent)
+    row++
+    return inlineValue(line.text, line.number, min - 1)
+  }
+
+  const sequence = (indent: number): Array<unknown> => {
+    const items: Array<unknown> = []
+    for (let line = peek(); line?.indent === indent && isSequenceItem(line.text); line = peek()) {
+      const rest = line.text.slice(1).trimStart()
+      if (rest === "") row++
+      // Otherwise the item reads on as if its dash were indentation, so `- key: value` opens a mapping
+      else raw[row] = " ".repeat(indent + line.text.length - rest.length) + rest
+      items.push(node(indent + 1))
+    }
+    return items
+  }
+
+  const mapping = (indent: number): Record<string, unknown> => {
+    const entries: Record<string, unknown> = {}
+    for (let line = peek(); line?.indent === indent; line = peek()) {
+      const entry = mappingEntry(line.text, line.number) ?? fail(line.number, `Expected "key: value": ${line.text}`)
+      if (Object.hasOwn(entries, entry.key)) fail(line.number, `Duplicate key "${entry.key}"`)
+      row++
+      let value: unknown
+      if (entry.rest !== "") {
+        value = inlineValue(entry.rest, line.number, indent)
+      } else {
+        // A sequence may sit at its key's indentation
+        const next = peek()
+        value = next?.indent === indent && isSequenceItem(next.text) ? sequence(indent) : node(indent + 1)
+      }
+      setKey(entries, entry.key, value)
+    }
+    return entries
+  }
+
+  // A `---` may open the document and `...` or another `---` close it, with nothing after
+  const first = raw.findIndex((line) => stripComment(line.trim()) !== "")
+  if (first !== -1 && /^---(\s|$)/.test(raw[first]!)) raw[first] = "   " + raw[first]!.slice(3)
+  const end = raw.findIndex((line, i) => i > first && /^(---|\.\.\.)(\s|$)/.test(line))
+  if (end !== -1) {
+    if (raw.slice(end + 1).some((line) => stripComment(line.trim()) !== "")) fail(end + 1, "Only one document is read")
+    raw.length = end
+  }
+
+  const value = node(0)
+  const left = peek()
+  if (left !== undefined) fail(left.number, `Unexpected ${left.text}`)
+  return value
+}
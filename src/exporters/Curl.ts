@@ -0,0 +1,47 @@
+// An export as a shell script of curl commands, one admin API call per imposter, stub and
+// preset, for teams that keep their mock setup as plain scripts next to the code.
+
+import type { ExportDocument } from "../schemas/ConfigFileSchema"
+import { setupSteps } from "./Setup"
+
+const PRELUDE = `#!/bin/sh
+# Generated by GET /export?format=curl from the imposters an admin server held.
+# Usage: sh imposters.sh [admin URL], http://localhost:2525 by default, with ADMIN_TOKEN set
+# when the admin server has one
+set -eu
+
+ADMIN_URL="\${1:-http://localhost:2525}"
+ADMIN_TOKEN="\${ADMIN_TOKEN:-}"
+
+# admin METHOD PATH sends stdin as the JSON body, and ADMIN_TOKEN as a bearer token when set,
+# failing unless answered with a 2xx
+admin() {
+  method=$1 path=$2
+  shift 2
+  [ -z "$ADMIN_TOKEN" ] || set -- -H "Authorization: Bearer $ADMIN_TOKEN"
+  curl -sSf -X "$method" "$ADMIN_URL$path" -H "Content-Type: application/json" --data-binary @- "$@"
+}
+
+# create sends stdin to POST /imposters and prints the new imposter's id, the first "id" key
+# wherever it is in the response
+create() {
+  admin POST /imposters | grep -o '"id" *: *"[^"]*"' | head -n 1 | sed 's/.*"\\([^"]*\\)"$/\\1/' | grep .
+}`
+
+// A quoted heredoc, so the shell leaves `$` and backquotes in the JSON alone. No line of
+// indented JSON is just `JSON`, which ends it.
+const heredoc = (body: unknown) => `<<'JSON'\n${JSON.stringify(body, null, 2)}\nJSON`
+
+/**
+ * A POSIX shell script recreating the export: each imposter is created, given its stubs and
+ * presets, and started, with its id kept in `$id` for the calls that follow.
+ */
+export const curlScript = (document: ExportDocument): string =>
+  [
+    PRELUDE,
+    ...setupSteps(document).map((step) =>
+      step.creates
+        ? `# ${step.title}\nid=$(create ${heredoc(step.body)}\n)`
+        : `# ${step.title}\nadmin ${step.method} "${step.path("$id")}" >/dev/null ${heredoc(step.body)}`
+    )
+  ].join("\n\n") + "\n"
@@ -0,0 +1,97 @@
+// An export as Go test code: a helper that loads the exported imposters onto a running admin
+// server over its REST API, with nothing but the standard library, and removes them when the
+// test ends. Paste it into the package of the integration tests that need the mocks.
+
+import type { ExportDocument } from "../schemas/ConfigFileSchema"
+
+// The environment the imposters are loaded as, so their names come out as `exported/<name>`
+export const GO_ENVIRONMENT = "exported"
+
+// A Go raw string literal holding `text`; backquotes can't appear in one, so they are spliced in
+const rawString = (text: string) => `\`${text.replaceAll("`", "` + \"`\" + `")}\``
+
+const ADMIN_REQUEST = `// adminRequest sends body to the admin API, with $ADMIN_TOKEN as a bearer token when set, and
+// fails the test unless it answers with a 2xx.
+func adminRequest(t *testing.T, method, url, body string) []byte {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading response: %v", method, url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		t.Fatalf("%s %s: %s: %s", method, url, resp.Status, out)
+	}
+	return out
+}`
+
+/**
+ * Go source declaring `SetupImposters(t, adminURL)` in package `pkg`. It merges the exported
+ * template variables, loads the imposters in one `POST /imposters/load`, and deletes them again
+ * in `t.Cleanup`. Profiles are left out: the exported imposters already hold what they got
+ * from theirs.
+ */
+export const goSource = (document: typeof ExportDocument.Encoded, pkg: string): string => {
+  const manifest = { name: GO_ENVIRONMENT, imposters: document.imposters, bodies: document.bodies }
+  const hasVariables = Object.keys(document.variables).length > 0
+  return [
+    `// Code generated by GET /export?format=go from the imposters an admin server held.`,
+    ``,
+    `package ${pkg}`,
+    ``,
+    `import (`,
+    `\t"encoding/json"`,
+    `\t"io"`,
+    `\t"net/http"`,
+    `\t"os"`,
+    `\t"strings"`,
+    `\t"testing"`,
+    `)`,
+    ``,
+    `// SetupImposters recreates the exported imposters on the admin server at adminURL, such as`,
+    `// "http://localhost:2525", and removes them when the test ends.`,
+    `func SetupImposters(t *testing.T, adminURL string) {`,
+    `\tt.Helper()`,
+    ...(hasVariables ? [`\tadminRequest(t, http.MethodPatch, adminURL+"/variables", variables)`] : []),
+    `\tloaded := adminRequest(t, http.MethodPost, adminURL+"/imposters/load", manifest)`,
+    `\tvar result struct {`,
+    `\t\tImposters []struct {`,
+    `\t\t\tID string \`json:"id"\``,
+    `\t\t} \`json:"imposters"\``,
+    `\t}`,
+    `\tif err := json.Unmarshal(loaded, &result); err != nil {`,
+    `\t\tt.Fatalf("decoding loaded imposters: %v", err)`,
+    `\t}`,
+    `\tt.Cleanup(func() {`,
+    `\t\tfor _, imp := range result.Imposters {`,
+    `\t\t\tadminRequest(t, http.MethodDelete, adminURL+"/imposters/"+imp.ID+"?force=true", "")`,
+    `\t\t}`,
+    `\t})`,
+    `}`,
+    ``,
+    ADMIN_REQUEST,
+    ``,
+    ...(hasVariables
+      ? [
+        `// Template variables the imposters' responses use`,
+        `const variables = ${rawString(JSON.stringify(document.variables, null, 2))}`,
+        ``
+      ]
+      : []),
+    `// The imposters, as an environment manifest`,
+    `const manifest = ${rawString(JSON.stringify(manifest, null, 2))}`,
+    ``
+  ].join("\n")
+}
@@ -0,0 +1,40 @@
+// An export as an HTTP file for IDE REST clients: one request per imposter, stub and preset,
+// to step through or run in order from the editor.
+
+import type { ExportDocument } from "../schemas/ConfigFileSchema"
+import { setupSteps } from "./Setup"
+
+const PRELUDE = `# Generated by GET /export?format=http from the imposters an admin server held.
+# Run the requests in order. Those after an imposter is created read its id from that
+# response, named imposterN with "# @name" as VS Code's REST Client does. @token is read
+# from ADMIN_TOKEN in the editor's environment, for admin servers started with one.
+
+@admin = http://localhost:2525
+@token = {{$processEnv ADMIN_TOKEN}}`
+
+// The body as JSON. Clients substitute `{{...}}` anywhere in a request, response templates
+// included, so their braces are written as escapes JSON reads back the same.
+const jsonBody = (body: unknown) => JSON.stringify(body, null, 2).replaceAll("{{", "\\u007b\\u007b")
+
+/**
+ * The requests recreating the export: each imposter is created, given its stubs and presets,
+ * and started, with later requests reading its id from the response that created it.
+ */
+export const httpFile = (document: ExportDocument): string => {
+  let created = 0
+  return [
+    PRELUDE,
+    ...setupSteps(document).map((step) => {
+      const name = step.creates ? `imposter${++created}` : undefined
+      return [
+        `### ${step.title}`,
+        ...(name !== undefined ? [`# @name ${name}`] : []),
+        `${step.method} {{admin}}${step.path(`{{imposter${created}.response.body.$.id}}`)}`,
+        "Content-Type: application/json",
+        "Authorization: Bearer {{token}}",
+        "",
+        jsonBody(step.body)
+      ].join("\n")
+    })
+  ].join("\n\n") + "\n"
+}
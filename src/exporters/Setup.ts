@@ -0,0 +1,70 @@
+// The admin API calls that recreate an export route by route, in the order `imposters env up`
+// makes them: create each imposter, add its stubs and presets, then start it.
+
+import * as Schema from "effect/Schema"
+import { inlineBodies } from "../domain/bodies"
+import { type ExportDocument, ImposterConfig } from "../schemas/ConfigFileSchema"
+import type { CreateStubRequest } from "../schemas/StubSchema"
+
+export interface SetupStep {
+  // What the call does, for a comment above it: one line, without control characters
+  readonly title: string
+  readonly method: "POST" | "PATCH"
+  // The path, given how the script refers to the id of the imposter created last
+  readonly path: (id: string) => string
+  readonly body: unknown
+  // Whether the call creates the imposter later steps refer to
+  readonly creates: boolean
+}
+
+// Names and paths come from the export, so a line break in one could end the comment it goes in
+const oneLine = (title: string) => title.replace(/[\u0000-\u001f\u007f-\u009f\u2028\u2029]+/g, " ")
+
+const step = (
+  title: string,
+  method: SetupStep["method"],
+  path: SetupStep["path"],
+  body: unknown,
+  creates = false
+): SetupStep => ({ title: oneLine(title), method, path, body, creates })
+
+const encodeImposter = Schema.encodeSync(ImposterConfig)
+
+// "Stub GET /orders", from the method and path the stub matches when it names them
+const stubTitle = (stub: CreateStubRequest) => {
+  const valueOf = (field: string) => {
+    const predicate = stub.predicates.find((p) => "field" in p && p.field === field)
+    return predicate !== undefined && "value" in predicate ? predicate.value : undefined
+  }
+  return ["Stub", ...[valueOf("method"), valueOf("path")].filter((v) => typeof v === "string")].join(" ")
+}
+
+/**
+ * Every call, with shared bodies put back in place. Template variables are merged first;
+ * profiles are left out, as the exported imposters already hold what they got from theirs.
+ */
+export const setupSteps = (document: ExportDocument): ReadonlyArray<SetupStep> => [
+  ...(Object.keys(document.variables).length > 0
+    ? [step("Template variables the responses use", "PATCH", () => "/variables", document.variables)]
+    : []),
+  ...document.imposters.flatMap((imp) => {
+    const stubs = inlineBodies(imp.stubs, document.bodies)
+    const { presets, profile: _, stubs: encodedStubs, ...settings } = encodeImposter({ ...imp, stubs })
+    return [
+      step(
+        imp.name !== undefined ? `Imposter ${imp.name} on port ${imp.port}` : `Imposter on port ${imp.port}`,
+        "POST",
+        () => "/imposters",
+        { ...settings, protocol: imp.tls !== undefined ? "HTTPS" : "HTTP" },
+        true
+      ),
+      ...(encodedStubs ?? []).map((stub, i) =>
+        step(stubTitle(stubs[i]!), "POST", (id) => `/imposters/${id}/stubs`, stub)
+      ),
+      ...(presets ?? []).map((preset) =>
+        step(`Preset ${preset.preset}`, "POST", (id) => `/imposters/${id}/presets`, preset)
+      ),
+      step("Start it", "PATCH", (id) => `/imposters/${id}`, { status: "running" })
+    ]
+  })
+]
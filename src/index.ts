@@ -16,10 +16,20 @@ export * as SystemGroup from "./api/SystemGroup.js"
 
 export * as SystemHandlers from "./api/SystemHandlers.js"
 
+export * as CertificateAuthority from "./cli/CertificateAuthority.js"
+
 export * as Commands from "./cli/Commands.js"
 
 export * as ConfigLoader from "./cli/ConfigLoader.js"
 
+export * as Contexts from "./cli/Contexts.js"
+
+export * as Environment from "./cli/Environment.js"
+
+export * as Tunnel from "./cli/Tunnel.js"
+
+export * as Watch from "./cli/Watch.js"
+
 export * as version from "./cli/version.js"
 
 export * as HandlerHttpClient from "./client/HandlerHttpClient.js"
@@ -38,46 +48,109 @@ export * as imposter from "./domain/imposter.js"
  */
 export * as route from "./domain/route.js"
 
+/**
+ * XML parsing and the XPath subset used by body predicates
+ */
+export * as xpath from "./domain/xpath.js"
+
 export * as ApiLayer from "./layers/ApiLayer.js"
 
 export * as MainLayer from "./layers/MainLayer.js"
 
+export * as EventStream from "./matching/EventStream.js"
+
+export * as ExampleGenerator from "./matching/ExampleGenerator.js"
+
 /**
  * Extract expression content from a ${...} pattern using brace-depth counting.
  * Returns [expressionContent, endIndex] or null if no valid expression found.
  */
 export * as ExpressionEvaluator from "./matching/ExpressionEvaluator.js"
 
+export * as JsonSchema from "./matching/JsonSchema.js"
+
+export * as Media from "./matching/Media.js"
+
+export * as RecordNormalizer from "./matching/RecordNormalizer.js"
+
 export * as RequestMatcher from "./matching/RequestMatcher.js"
 
 export * as ResponseGenerator from "./matching/ResponseGenerator.js"
 
+export * as ResponseTransforms from "./matching/ResponseTransforms.js"
+
+export * as Scrubber from "./matching/Scrubber.js"
+
+export * as Signatures from "./matching/Signatures.js"
+
 export * as TemplateEngine from "./matching/TemplateEngine.js"
 
+export * as Verification from "./matching/Verification.js"
+
+export * as Digest from "./presets/Digest.js"
+
+export * as Flags from "./presets/Flags.js"
+
+export * as Llm from "./presets/Llm.js"
+
+export * as Metadata from "./presets/Metadata.js"
+
+export * as Ntlm from "./presets/Ntlm.js"
+
+export * as Payments from "./presets/Payments.js"
+
+export * as Presets from "./presets/Presets.js"
+
+export * as SigV4 from "./presets/SigV4.js"
+
 export * as ImposterRepository from "./repositories/ImposterRepository.js"
 
+export * as CallbackSchema from "./schemas/CallbackSchema.js"
+
 export * as ConfigFileSchema from "./schemas/ConfigFileSchema.js"
 
+export * as EventSchema from "./schemas/EventSchema.js"
+
 export * as ImposterSchema from "./schemas/ImposterSchema.js"
 
+export * as PresetSchema from "./schemas/PresetSchema.js"
+
 export * as RequestLogSchema from "./schemas/RequestLogSchema.js"
 
 export * as StubSchema from "./schemas/StubSchema.js"
 
+export * as VariablesSchema from "./schemas/VariablesSchema.js"
+
 export * as common from "./schemas/common.js"
 
 export * as AdminServer from "./server/AdminServer.js"
 
+export * as Certificates from "./server/Certificates.js"
+
+export * as Compression from "./server/Compression.js"
+
+export * as Faults from "./server/Faults.js"
+
 export * as FiberManager from "./server/FiberManager.js"
 
 export * as ImposterServer from "./server/ImposterServer.js"
 
+export * as Readiness from "./server/Readiness.js"
+
 export * as ServerFactory from "./server/ServerFactory.js"
 
+export * as Tls from "./server/Tls.js"
+
 export * as AppConfig from "./services/AppConfig.js"
 
+export * as CallbackService from "./services/CallbackService.js"
+
+export * as EventBus from "./services/EventBus.js"
+
 export * as MetricsService from "./services/MetricsService.js"
 
+export * as Outbound from "./services/Outbound.js"
+
 export * as PortAllocator from "./services/PortAllocator.js"
 
 export * as ProxyService from "./services/ProxyService.js"
@@ -88,6 +161,8 @@ export * as Uuid from "./services/Uuid.js"
 
 export * as UuidLive from "./services/UuidLive.js"
 
+export * as Variables from "./services/Variables.js"
+
 export * as UiRouter from "./ui/UiRouter.js"
 
 export * as AdminLayout from "./ui/admin/AdminLayout.js"
@@ -4,11 +4,15 @@ import { FiberManagerLive } from "../server/FiberManager"
 import { ImposterServerLive } from "../server/ImposterServer"
 import { NodeServerFactoryLive } from "../server/ServerFactory"
 import { AppConfigLive } from "../services/AppConfig"
+import { CallbackServiceLive } from "../services/CallbackService"
+import { EventBusLive } from "../services/EventBus"
 import { MetricsServiceLive } from "../services/MetricsService"
 import { PortAllocatorLive } from "../services/PortAllocator"
+import { ProfilesLive } from "../services/Profiles"
 import { ProxyServiceLive } from "../services/ProxyService"
 import { RequestLoggerLive } from "../services/RequestLogger"
 import { UuidLive } from "../services/UuidLive"
+import { VariablesLive } from "../services/Variables"
 
 // PortAllocatorLive depends on AppConfig
 const PortAllocatorWithDeps = PortAllocatorLive.pipe(Layer.provide(AppConfigLive))
@@ -16,7 +20,14 @@ const PortAllocatorWithDeps = PortAllocatorLive.pipe(Layer.provide(AppConfigLive
 // ProxyServiceLive depends on Uuid
 const ProxyServiceWithDeps = ProxyServiceLive.pipe(Layer.provide(UuidLive))
 
+// EventBusLive depends on Uuid
+const EventBusWithDeps = EventBusLive.pipe(Layer.provide(UuidLive))
+
+// CallbackServiceLive depends on Uuid
+const CallbackServiceWithDeps = CallbackServiceLive.pipe(Layer.provide(UuidLive))
+
 // ImposterServerLive depends on FiberManager + ImposterRepository + ServerFactory + RequestLogger + Metrics + Proxy
+// + EventBus + CallbackService + Variables
 const ImposterServerWithDeps = ImposterServerLive.pipe(
   Layer.provide(
     Layer.mergeAll(
@@ -25,7 +36,10 @@ const ImposterServerWithDeps = ImposterServerLive.pipe(
       NodeServerFactoryLive,
       RequestLoggerLive,
       MetricsServiceLive,
-      ProxyServiceWithDeps
+      ProxyServiceWithDeps,
+      EventBusWithDeps,
+      CallbackServiceWithDeps,
+      VariablesLive
     )
   )
 )
@@ -39,5 +53,9 @@ export const MainLayer = Layer.mergeAll(
   FiberManagerLive,
   RequestLoggerLive,
   MetricsServiceLive,
+  EventBusWithDeps,
+  CallbackServiceWithDeps,
+  VariablesLive,
+  ProfilesLive,
   ImposterServerWithDeps
 )
@@ -0,0 +1,63 @@
+// Bulk APIs: a request carrying N operations answered with one result per operation, each
+// picked by the first rule covering it, so the common bulk pattern needs no custom script.
+
+import type { BatchResponse, BatchRule } from "../schemas/StubSchema"
+import type { TemplateHelpers } from "./ExpressionEvaluator"
+import { evaluatePredicates, type RequestContext } from "./RequestMatcher"
+import { visitParents } from "./ResponseTransforms"
+import { applyTemplates, substituteIndex } from "./TemplateEngine"
+
+export interface BatchAnswer {
+  readonly results: ReadonlyArray<unknown>
+  // Set when an operation failed and the batch names a status for that
+  readonly status?: number
+}
+
+const segments = (path: string) => path.split(".").filter((s) => s.length > 0)
+
+// The value at a dot path, or `value` itself for no path
+const valueAt = (value: unknown, path: string | undefined): unknown => {
+  if (path === undefined) return value
+  let found: unknown
+  visitParents(value, segments(path), false, (parent, key) => {
+    found = parent[key]
+  })
+  return found
+}
+
+const covers = (rule: BatchRule, operation: RequestContext, index: number, id: unknown): boolean =>
+  (rule.indexes === undefined || rule.indexes.includes(index)) &&
+  (rule.ids === undefined || (id !== undefined && id !== null && rule.ids.includes(String(id)))) &&
+  evaluatePredicates(operation, rule.predicates)
+
+/**
+ * A result for each operation in the request, rendered against the operation. A body without
+ * an operations array is a batch of none.
+ */
+export const answerBatch = async (
+  batch: BatchResponse,
+  ctx: RequestContext,
+  helpers: TemplateHelpers = {}
+): Promise<BatchAnswer> => {
+  const found = valueAt(ctx.body, batch.operations)
+  const operations: ReadonlyArray<unknown> = Array.isArray(found) ? found : []
+  let failed = false
+  const results: Array<unknown> = []
+  for (const [index, body] of operations.entries()) {
+    const operation = { ...ctx, body }
+    const rule = batch.rules.find((r) => covers(r, operation, index, valueAt(body, batch.id)))
+    failed ||= rule?.failed === true
+    results.push(await applyTemplates(operation, substituteIndex(rule?.result ?? batch.result, index), helpers))
+  }
+  return { results, ...(failed && batch.failureStatus !== undefined ? { status: batch.failureStatus } : {}) }
+}
+
+// `body` with the results at `into`, or the results alone when there is no `into`
+export const withResults = (body: unknown, results: ReadonlyArray<unknown>, into: string | undefined): unknown => {
+  if (into === undefined) return results
+  const container = typeof body === "object" && body !== null ? body : {}
+  visitParents(container, segments(into), true, (parent, key) => {
+    parent[key] = results
+  })
+  return container
+}
@@ -0,0 +1,90 @@
+// A rendered event: its wire text and how long to wait before sending it
+export interface StreamedEvent {
+  readonly text: string
+  readonly delay: number
+}
+
+/**
+ * An event in the `text/event-stream` format: `event:` and `id:` lines, then `data` as one
+ * `data:` line per line (JSON unless it is a string), ended by a blank line.
+ */
+export const formatEvent = (event: { readonly data: unknown; readonly event?: unknown; readonly id?: unknown }) => {
+  const data = typeof event.data === "string" ? event.data : JSON.stringify(event.data) ?? ""
+  const lines = [
+    ...(event.event !== undefined ? [`event: ${String(event.event)}`] : []),
+    ...(event.id !== undefined ? [`id: ${String(event.id)}`] : []),
+    ...data.split(/\r\n|\r|\n/).map((line) => `data: ${line}`)
+  ]
+  return `${lines.join("\n")}\n\n`
+}
+
+/**
+ * Sends each event once its delay has passed since the one before, then closes. Cancelling
+ * the stream (the client went away) stops it sending any more.
+ */
+export const eventStream = (events: ReadonlyArray<StreamedEvent>): ReadableStream<Uint8Array> => {
+  const encoder = new TextEncoder()
+  let next = 0
+  let timer: ReturnType<typeof setTimeout> | undefined
+  return new ReadableStream<Uint8Array>({
+    pull: (controller) =>
+      new Promise<void>((resolve) => {
+        const event = events[next++]
+        if (event === undefined) {
+          controller.close()
+          return resolve()
+        }
+        timer = setTimeout(() => {
+          controller.enqueue(encoder.encode(event.text))
+          resolve()
+        }, event.delay)
+      }),
+    cancel: () => clearTimeout(timer)
+  })
+}
+
+/**
+ * Sends `initial` through, then stays open: each time `onChange` reports a value, the events
+ * `render` makes of it are sent, in order and after their delays. Cancelling the stream stops
+ * the reports; `onChange` returns what does that.
+ */
+export const liveEventStream = <A>(
+  initial: ReadableStream<Uint8Array>,
+  onChange: (listener: (value: A) => void) => () => void,
+  render: (value: A) => Promise<ReadonlyArray<StreamedEvent>>
+): ReadableStream<Uint8Array> => {
+  const encoder = new TextEncoder()
+  const reader = initial.getReader()
+  let open = true
+  let stop = () => {}
+  const wait = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms))
+  return new ReadableStream<Uint8Array>({
+    start: (controller) => {
+      const fail = (e: unknown) => {
+        if (open) controller.error(e)
+        open = false
+      }
+      const send = async (value: A) => {
+        for (const event of await render(value)) {
+          if (event.delay > 0) await wait(event.delay)
+          if (!open) return
+          controller.enqueue(encoder.encode(event.text))
+        }
+      }
+      // Changes made while the first events are still going out follow them
+      let sending = (async () => {
+        for (let chunk = await reader.read(); !chunk.done && open; chunk = await reader.read()) {
+          controller.enqueue(chunk.value)
+        }
+      })().catch(fail)
+      stop = onChange((value) => {
+        sending = sending.then(() => open ? send(value) : undefined).catch(fail)
+      })
+    },
+    cancel: () => {
+      open = false
+      stop()
+      return reader.cancel()
+    }
+  })
+}
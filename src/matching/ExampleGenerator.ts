@@ -0,0 +1,197 @@
+// Fake values that validate against a JSON Schema (draft 2020-12 / OpenAPI 3 subset)
+
+import { isSchema, type JsonSchema, resolveRef } from "./JsonSchema"
+
+export interface ExampleOptions {
+  // Length range for arrays, narrowed by each schema's minItems/maxItems
+  readonly arraySize: { readonly min: number; readonly max: number }
+  // Custom string formats: `#` becomes a digit, `?` a lowercase letter, anything else is kept
+  readonly formats?: Record<string, string>
+  // Same seed, same body; random otherwise
+  readonly seed?: number
+}
+
+// Recursive $refs stop expanding here: arrays come out with their minItems and objects without
+// optional fields. One level further nothing is expanded at all, even where a schema requires itself
+const MAX_DEPTH = 6
+
+const WORDS = ["alpha", "bravo", "delta", "echo", "lima", "nova", "oscar", "sierra", "tango", "zulu"]
+const NAMES = ["Alice", "Bob", "Carol", "Dave", "Erin", "Frank", "Grace", "Heidi"]
+
+// mulberry32: small, fast and good enough for test data
+const seededRandom = (seed: number): () => number => {
+  let state = seed >>> 0
+  return () => {
+    state = (state + 0x6d2b79f5) >>> 0
+    let t = state
+    t = Math.imul(t ^ (t >>> 15), t | 1)
+    t ^= t + Math.imul(t ^ (t >>> 7), t | 61)
+    return ((t ^ (t >>> 14)) >>> 0) / 4294967296
+  }
+}
+
+const numberOr = (value: unknown, fallback: number): number => typeof value === "number" ? value : fallback
+
+const propertiesOf = (schema: JsonSchema): JsonSchema => isSchema(schema.properties) ? schema.properties : {}
+
+const requiredOf = (schema: JsonSchema): ReadonlyArray<unknown> => Array.isArray(schema.required) ? schema.required : []
+
+// allOf: one schema with every part's properties and required fields
+const merge = (schemas: ReadonlyArray<JsonSchema>): JsonSchema =>
+  schemas.reduce<JsonSchema>((acc, s) => ({
+    ...acc,
+    ...s,
+    properties: { ...propertiesOf(acc), ...propertiesOf(s) },
+    required: [...requiredOf(acc), ...requiredOf(s)]
+  }), {})
+
+const typeOf = (schema: JsonSchema): string | undefined => {
+  const type = schema.type
+  if (typeof type === "string") return type
+  if (Array.isArray(type)) return type.find((t) => t !== "null") ?? type[0]
+  if (isSchema(schema.properties)) return "object"
+  if (schema.items !== undefined) return "array"
+  return undefined
+}
+
+// Whether generating `schema` goes on to generate other schemas
+const expands = (schema: JsonSchema): boolean =>
+  typeof schema.$ref === "string" || Array.isArray(schema.allOf) || Array.isArray(schema.oneOf) ||
+  Array.isArray(schema.anyOf) || typeOf(schema) === "object" || typeOf(schema) === "array"
+
+/**
+ * Generate a value that validates against `schema`. `example`, `examples`, `const`,
+ * `enum` and `default` are used as given; everything else is made up within the
+ * schema's bounds. `$ref`s are resolved against `schema` itself, so an OpenAPI
+ * spec's `components` can sit next to a `$ref` into them. `pattern` is not generated
+ * from; give such fields an `example`.
+ */
+export const generateExample = (schema: unknown, options: ExampleOptions): unknown => {
+  const root = isSchema(schema) ? schema : {}
+  const random = options.seed !== undefined ? seededRandom(options.seed) : Math.random
+  const int = (min: number, max: number) => min + Math.floor(random() * (max - min + 1))
+  const pick = <A>(items: ReadonlyArray<A>): A => items[int(0, items.length - 1)]!
+  const word = () => pick(WORDS)
+
+  const fromPattern = (pattern: string) =>
+    pattern.replace(/[#?]/g, (ch) => ch === "#" ? String(int(0, 9)) : String.fromCharCode(int(97, 122)))
+
+  const formatted = (format: string): string | undefined => {
+    const custom = options.formats?.[format]
+    if (custom !== undefined) return fromPattern(custom)
+    const hex = (n: number) => Array.from({ length: n }, () => int(0, 15).toString(16)).join("")
+    const date = new Date(Date.UTC(int(2020, 2030), int(0, 11), int(1, 28), int(0, 23), int(0, 59), int(0, 59)))
+    switch (format) {
+      case "uuid":
+        return `${hex(8)}-${hex(4)}-4${hex(3)}-${pick(["8", "9", "a", "b"])}${hex(3)}-${hex(12)}`
+      case "email":
+        return `${pick(NAMES).toLowerCase()}.${word()}@example.com`
+      case "date-time":
+        return date.toISOString()
+      case "date":
+        return date.toISOString().slice(0, 10)
+      case "time":
+        return date.toISOString().slice(11, 19) + "Z"
+      case "uri":
+      case "url":
+        return `https://example.com/${word()}/${int(1, 999)}`
+      case "hostname":
+        return `${word()}.example.com`
+      case "ipv4":
+        return `10.${int(0, 255)}.${int(0, 255)}.${int(1, 254)}`
+      case "ipv6":
+        return `fd00::${hex(4)}:${hex(4)}`
+      default:
+        return undefined
+    }
+  }
+
+  const string = (schema: JsonSchema): string => {
+    const min = numberOr(schema.minLength, 0)
+    const format = typeof schema.format === "string" ? formatted(schema.format) : undefined
+    let value = format ?? `${word()} ${word()}`
+    while (value.length < min) value += ` ${word()}`
+    return typeof schema.maxLength === "number" ? value.slice(0, schema.maxLength) : value
+  }
+
+  const number = (schema: JsonSchema, integer: boolean): number => {
+    const exclusiveMin = schema.exclusiveMinimum
+    const exclusiveMax = schema.exclusiveMaximum
+    let min = typeof exclusiveMin === "number" ? exclusiveMin : numberOr(schema.minimum, 0)
+    let max = typeof exclusiveMax === "number" ? exclusiveMax : numberOr(schema.maximum, Math.max(min, 0) + 1000)
+    const step = numberOr(schema.multipleOf, integer ? 1 : 0)
+    if (step > 0) {
+      // Pick a multiple of the step that lies within the bounds
+      const lo = Math.ceil((min + (typeof exclusiveMin === "number" ? step / 2 : 0)) / step)
+      const hi = Math.floor((max - (typeof exclusiveMax === "number" ? step / 2 : 0)) / step)
+      return hi < lo ? lo * step : int(lo, hi) * step
+    }
+    if (typeof exclusiveMin === "number") min += 0.01
+    if (typeof exclusiveMax === "number") max -= 0.01
+    return Math.round((min + random() * (max - min)) * 100) / 100
+  }
+
+  const generate = (node: JsonSchema, depth: number): unknown => {
+    if (depth > MAX_DEPTH && expands(node)) {
+      if (node.default !== undefined) return node.default
+      return typeOf(node) === "array" ? [] : null
+    }
+    if (typeof node.$ref === "string") {
+      const target = resolveRef(root, node.$ref)
+      return target === undefined ? null : generate(target, depth + 1)
+    }
+    if ("const" in node) return node.const
+    if (node.example !== undefined) return node.example
+    if (Array.isArray(node.examples) && node.examples.length > 0) return pick(node.examples)
+    if (Array.isArray(node.enum) && node.enum.length > 0) return pick(node.enum)
+    if (node.default !== undefined) return node.default
+    if (Array.isArray(node.allOf)) {
+      const parts = node.allOf.filter(isSchema).map((s) =>
+        typeof s.$ref === "string" ? resolveRef(root, s.$ref) ?? {} : s
+      )
+      const { allOf: _, ...rest } = node
+      return generate(merge([...parts, rest]), depth + 1)
+    }
+    const choices = Array.isArray(node.oneOf) ? node.oneOf : Array.isArray(node.anyOf) ? node.anyOf : undefined
+    if (choices !== undefined && choices.length > 0) {
+      const choice = pick(choices.filter(isSchema))
+      return choice === undefined ? null : generate(choice, depth + 1)
+    }
+
+    switch (typeOf(node)) {
+      case "object": {
+        const properties = propertiesOf(node)
+        const required = requiredOf(node)
+        const result: Record<string, unknown> = {}
+        for (const [key, value] of Object.entries(properties)) {
+          if (depth >= MAX_DEPTH && !required.includes(key)) continue
+          if (isSchema(value)) result[key] = generate(value, depth + 1)
+        }
+        return result
+      }
+      case "array": {
+        const minItems = numberOr(node.minItems, 0)
+        const maxItems = numberOr(node.maxItems, Number.MAX_SAFE_INTEGER)
+        const min = Math.min(Math.max(minItems, options.arraySize.min), maxItems)
+        const max = Math.max(Math.min(maxItems, options.arraySize.max), min)
+        const length = depth >= MAX_DEPTH ? minItems : int(min, max)
+        const items = isSchema(node.items) ? node.items : {}
+        return Array.from({ length }, () => generate(items, depth + 1))
+      }
+      case "string":
+        return string(node)
+      case "integer":
+        return number(node, true)
+      case "number":
+        return number(node, false)
+      case "boolean":
+        return random() < 0.5
+      case "null":
+        return null
+      default:
+        return word()
+    }
+  }
+
+  return generate(root, 0)
+}
@@ -3,6 +3,25 @@ import type { RequestContext } from "./RequestMatcher"
 
 const MAX_OUTPUT_SIZE = 1_048_576 // 1MB
 
+// The imposter's key-value store, as templates read and write it: `{{store.get "key"}}`
+export interface TemplateStore {
+  readonly get: (key: string) => unknown
+  readonly set: (key: string, value: unknown) => void
+  readonly values: () => Readonly<Record<string, unknown>>
+}
+
+// Functions and values available to templates beyond the request
+export interface TemplateHelpers {
+  // Backs `$route(path, method?)`: the body another stub answers with
+  readonly route?: (path: string, method: string) => Promise<unknown>
+  // Shared variables: `{{vars.<name>}}`, and `$vars` in expressions
+  readonly variables?: Readonly<Record<string, unknown>>
+  // `{{store.get}}` and `{{store.set}}`, and `$store` in expressions
+  readonly store?: TemplateStore
+  // The imposter's clock, in epoch ms, for `{{now}}`; the wall clock when not given
+  readonly now?: () => number
+}
+
 /**
  * Extract expression content from a ${...} pattern using brace-depth counting.
  * Returns [expressionContent, endIndex] or null if no valid expression found.
@@ -26,11 +45,19 @@ const extractExpression = (str: string, startIndex: number): [string, number] |
  * Evaluate a single JSONata expression against the request context.
  * Returns the result or undefined on error.
  */
-export const evaluateExpression = async (expr: string, ctx: RequestContext): Promise<unknown> => {
+export const evaluateExpression = async (
+  expr: string,
+  ctx: RequestContext,
+  helpers: TemplateHelpers = {}
+): Promise<unknown> => {
   try {
     const expression = jsonata(expr)
+    const route = helpers.route
+    if (route !== undefined) {
+      expression.registerFunction("route", (path: string, method?: string) => route(path, method ?? "GET"))
+    }
     const context = { request: ctx }
-    return await expression.evaluate(context)
+    return await expression.evaluate(context, { vars: helpers.variables ?? {}, store: helpers.store?.values() ?? {} })
   } catch {
     return undefined
   }
@@ -39,14 +66,14 @@ export const evaluateExpression = async (expr: string, ctx: RequestContext): Pro
 /**
  * Process a string, replacing all ${...} patterns with evaluated JSONata results.
  */
-const processString = async (str: string, ctx: RequestContext): Promise<unknown> => {
+const processString = async (str: string, ctx: RequestContext, helpers: TemplateHelpers): Promise<unknown> => {
   // Quick check: if no ${, return as-is
   if (!str.includes("${")) return str
 
   // If the entire string is a single expression, return the raw result (preserving type)
   const singleMatch = extractExpression(str, 0)
   if (singleMatch && singleMatch[1] === str.length) {
-    const result = await evaluateExpression(singleMatch[0], ctx)
+    const result = await evaluateExpression(singleMatch[0], ctx, helpers)
     if (result === undefined) return str // Preserve raw on failure
     return result
   }
@@ -59,7 +86,7 @@ const processString = async (str: string, ctx: RequestContext): Promise<unknown>
       const extracted = extractExpression(str, i)
       if (extracted) {
         const [exprContent, endIndex] = extracted
-        const evalResult = await evaluateExpression(exprContent, ctx)
+        const evalResult = await evaluateExpression(exprContent, ctx, helpers)
         if (evalResult === undefined) {
           // Preserve raw expression on failure
           result += str.slice(i, endIndex)
@@ -85,16 +112,20 @@ const processString = async (str: string, ctx: RequestContext): Promise<unknown>
 /**
  * Recursively walk data structures, processing ${...} expressions in strings.
  */
-export const processExpressions = async (ctx: RequestContext, data: unknown): Promise<unknown> => {
-  if (typeof data === "string") return processString(data, ctx)
+export const processExpressions = async (
+  ctx: RequestContext,
+  data: unknown,
+  helpers: TemplateHelpers = {}
+): Promise<unknown> => {
+  if (typeof data === "string") return processString(data, ctx, helpers)
   if (Array.isArray(data)) {
-    const results = await Promise.all(data.map((item) => processExpressions(ctx, item)))
+    const results = await Promise.all(data.map((item) => processExpressions(ctx, item, helpers)))
     return results
   }
   if (data !== null && typeof data === "object") {
     const entries = Object.entries(data as Record<string, unknown>)
     const resolved = await Promise.all(
-      entries.map(async ([k, v]) => [k, await processExpressions(ctx, v)] as const)
+      entries.map(async ([k, v]) => [k, await processExpressions(ctx, v, helpers)] as const)
     )
     return Object.fromEntries(resolved)
   }
@@ -0,0 +1,165 @@
+// JSON Schema helpers shared by body generation and response validation (draft 2020-12 / OpenAPI 3 subset)
+
+export type JsonSchema = Record<string, unknown>
+
+export const isSchema = (value: unknown): value is JsonSchema =>
+  typeof value === "object" && value !== null && !Array.isArray(value)
+
+// Resolve a local JSON pointer such as "#/components/schemas/User"
+export const resolveRef = (root: JsonSchema, ref: string): JsonSchema | undefined => {
+  if (!ref.startsWith("#")) return undefined
+  let node: unknown = root
+  for (const raw of ref.slice(1).split("/").filter((s) => s !== "")) {
+    const key = decodeURIComponent(raw).replace(/~1/g, "/").replace(/~0/g, "~")
+    node = isSchema(node) ? node[key] : undefined
+  }
+  return isSchema(node) ? node : undefined
+}
+
+const FORMATS: Record<string, RegExp> = {
+  "date-time": /^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$/i,
+  date: /^\d{4}-\d{2}-\d{2}$/,
+  time: /^\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})?$/i,
+  email: /^[^\s@]+@[^\s@]+\.[^\s@]+$/,
+  uuid: /^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$/i,
+  uri: /^[a-z][a-z0-9+.-]*:\S+$/i,
+  ipv4: /^((25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(25[0-5]|2[0-4]\d|1?\d?\d)$/
+}
+
+const typeName = (value: unknown): string =>
+  value === null ? "null" : Array.isArray(value) ? "array" : typeof value
+
+const hasType = (value: unknown, type: string): boolean => {
+  switch (type) {
+    case "integer":
+      return Number.isInteger(value)
+    case "number":
+      return typeof value === "number" && Number.isFinite(value)
+    default:
+      return typeName(value) === type
+  }
+}
+
+const childPath = (path: string, key: string | number): string =>
+  typeof key === "number" ? `${path}[${key}]` : /^[A-Za-z_$][\w$]*$/.test(key) ? `${path}.${key}` : `${path}["${key}"]`
+
+// An invalid pattern fails validation rather than the request
+const matchesPattern = (pattern: string, value: string): boolean => {
+  try {
+    return new RegExp(pattern).test(value)
+  } catch {
+    return false
+  }
+}
+
+const deepEqual = (a: unknown, b: unknown): boolean => JSON.stringify(a) === JSON.stringify(b)
+
+/**
+ * Validate a value against a JSON Schema and describe every violation as
+ * `<path>: <problem>`, with paths like `$.lines[0].qty`. An empty result means the
+ * value conforms. Unknown keywords and formats are ignored.
+ */
+export const validateJsonSchema = (schema: unknown, value: unknown): Array<string> => {
+  const root = isSchema(schema) ? schema : {}
+
+  const check = (node: JsonSchema, actual: unknown, path: string, depth: number): Array<string> => {
+    if (depth > 64) return []
+    const errors: Array<string> = []
+    const fail = (problem: string) => errors.push(`${path}: ${problem}`)
+
+    if (typeof node.$ref === "string") {
+      const target = resolveRef(root, node.$ref)
+      if (target === undefined) fail(`unresolved $ref ${node.$ref}`)
+      else errors.push(...check(target, actual, path, depth + 1))
+    }
+
+    if (node.type !== undefined) {
+      const types = Array.isArray(node.type) ? node.type : [node.type]
+      const nullable = node.nullable === true && actual === null
+      if (!nullable && !types.some((t) => typeof t === "string" && hasType(actual, t))) {
+        fail(`expected ${types.join(" or ")}, got ${typeName(actual)}`)
+        return errors
+      }
+    }
+    if ("const" in node && !deepEqual(actual, node.const)) fail(`expected ${JSON.stringify(node.const)}`)
+    if (Array.isArray(node.enum) && !node.enum.some((e) => deepEqual(e, actual))) {
+      fail(`expected one of ${node.enum.map((e) => JSON.stringify(e)).join(", ")}`)
+    }
+
+    if (typeof actual === "string") {
+      if (typeof node.minLength === "number" && actual.length < node.minLength) {
+        fail(`shorter than ${node.minLength} characters`)
+      }
+      if (typeof node.maxLength === "number" && actual.length > node.maxLength) {
+        fail(`longer than ${node.maxLength} characters`)
+      }
+      if (typeof node.pattern === "string" && !matchesPattern(node.pattern, actual)) {
+        fail(`does not match ${node.pattern}`)
+      }
+      const format = typeof node.format === "string" ? FORMATS[node.format] : undefined
+      if (format !== undefined && !format.test(actual)) fail(`not a valid ${node.format}`)
+    }
+
+    if (typeof actual === "number") {
+      if (typeof node.minimum === "number" && actual < node.minimum) fail(`less than ${node.minimum}`)
+      if (typeof node.maximum === "number" && actual > node.maximum) fail(`greater than ${node.maximum}`)
+      if (typeof node.exclusiveMinimum === "number" && actual <= node.exclusiveMinimum) {
+        fail(`not greater than ${node.exclusiveMinimum}`)
+      }
+      if (typeof node.exclusiveMaximum === "number" && actual >= node.exclusiveMaximum) {
+        fail(`not less than ${node.exclusiveMaximum}`)
+      }
+      if (typeof node.multipleOf === "number" && Math.abs(actual / node.multipleOf % 1) > 1e-9) {
+        fail(`not a multiple of ${node.multipleOf}`)
+      }
+    }
+
+    if (Array.isArray(actual)) {
+      if (typeof node.minItems === "number" && actual.length < node.minItems) {
+        fail(`fewer than ${node.minItems} items`)
+      }
+      if (typeof node.maxItems === "number" && actual.length > node.maxItems) fail(`more than ${node.maxItems} items`)
+      if (node.uniqueItems === true && new Set(actual.map((a) => JSON.stringify(a))).size !== actual.length) {
+        fail("items are not unique")
+      }
+      const items = node.items
+      if (isSchema(items)) {
+        actual.forEach((item, i) => errors.push(...check(items, item, childPath(path, i), depth + 1)))
+      }
+    }
+
+    if (isSchema(actual)) {
+      const properties = isSchema(node.properties) ? node.properties : {}
+      for (const key of Array.isArray(node.required) ? node.required : []) {
+        if (typeof key === "string" && !(key in actual)) fail(`missing required property ${JSON.stringify(key)}`)
+      }
+      for (const [key, val] of Object.entries(actual)) {
+        const property = properties[key]
+        if (isSchema(property)) errors.push(...check(property, val, childPath(path, key), depth + 1))
+        else if (node.additionalProperties === false) fail(`unexpected property ${JSON.stringify(key)}`)
+        else if (isSchema(node.additionalProperties)) {
+          errors.push(...check(node.additionalProperties, val, childPath(path, key), depth + 1))
+        }
+      }
+    }
+
+    const subschemas = (key: string): Array<JsonSchema> => {
+      const list = node[key]
+      return Array.isArray(list) ? list.filter(isSchema) : []
+    }
+    for (const part of subschemas("allOf")) errors.push(...check(part, actual, path, depth + 1))
+    const anyOf = subschemas("anyOf")
+    if (anyOf.length > 0 && !anyOf.some((s) => check(s, actual, path, depth + 1).length === 0)) {
+      fail("does not match any schema in anyOf")
+    }
+    const oneOf = subschemas("oneOf")
+    if (oneOf.length > 0) {
+      const matches = oneOf.filter((s) => check(s, actual, path, depth + 1).length === 0).length
+      if (matches !== 1) fail(`matches ${matches} schemas in oneOf, expected exactly 1`)
+    }
+    if (isSchema(node.not) && check(node.not, actual, path, depth + 1).length === 0) fail("matches a schema in not")
+    return errors
+  }
+
+  return check(root, value, "$", 0)
+}
@@ -0,0 +1,170 @@
+// Placeholder media: solid-colour PNG/SVG images and plain-text PDFs that clients can actually render
+
+import { deflateSync } from "node:zlib"
+import type { MediaConfig } from "../schemas/StubSchema"
+
+export interface RenderedMedia {
+  readonly contentType: string
+  readonly bytes: Uint8Array<ArrayBuffer>
+}
+
+// Content types whose bodies are safe to log and inspect as text
+export const isTextual = (contentType: string | null): boolean => {
+  const type = (contentType ?? "").split(";")[0]!.trim().toLowerCase()
+  return type === "" || type.startsWith("text/") || /json|xml|javascript|x-www-form-urlencoded/.test(type)
+}
+
+const concat = (parts: ReadonlyArray<Uint8Array>): Uint8Array<ArrayBuffer> => {
+  const out = new Uint8Array(parts.reduce((n, p) => n + p.byteLength, 0))
+  let offset = 0
+  for (const part of parts) {
+    out.set(part, offset)
+    offset += part.byteLength
+  }
+  return out
+}
+
+const hexColor = (hex: string): readonly [number, number, number] => [
+  parseInt(hex.slice(1, 3), 16),
+  parseInt(hex.slice(3, 5), 16),
+  parseInt(hex.slice(5, 7), 16)
+]
+
+// Dark text on light backgrounds and vice versa
+const isLight = ([r, g, b]: readonly [number, number, number]): boolean => 0.299 * r + 0.587 * g + 0.114 * b > 150
+
+const CRC_TABLE = Array.from({ length: 256 }, (_, n) => {
+  let c = n
+  for (let k = 0; k < 8; k++) c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1
+  return c >>> 0
+})
+
+const crc32 = (bytes: Uint8Array): number => {
+  let crc = 0xffffffff
+  for (const byte of bytes) crc = CRC_TABLE[(crc ^ byte) & 0xff]! ^ (crc >>> 8)
+  return (crc ^ 0xffffffff) >>> 0
+}
+
+const pngChunk = (type: string, data: Uint8Array): Uint8Array => {
+  const chunk = new Uint8Array(12 + data.byteLength)
+  const view = new DataView(chunk.buffer)
+  view.setUint32(0, data.byteLength)
+  chunk.set(new TextEncoder().encode(type), 4)
+  chunk.set(data, 8)
+  view.setUint32(8 + data.byteLength, crc32(chunk.subarray(4, 8 + data.byteLength)))
+  return chunk
+}
+
+/**
+ * An RGB PNG filled with `color`, crossed by two darker diagonals in the usual
+ * placeholder style.
+ */
+export const renderPng = (width: number, height: number, color: string): Uint8Array<ArrayBuffer> => {
+  const fill = hexColor(color)
+  const line = fill.map((c) => isLight(fill) ? Math.round(c * 0.8) : Math.min(255, Math.round(c * 1.4 + 20)))
+  const stride = width * 3 + 1
+  const raw = new Uint8Array(stride * height)
+  const thickness = Math.max(1, Math.round(Math.min(width, height) / 100))
+  for (let y = 0; y < height; y++) {
+    // Each scanline starts with filter type 0 (none)
+    for (let x = 0; x < width; x++) {
+      const onDiagonal = Math.abs(x * height - y * width) <= thickness * width ||
+        Math.abs((width - 1 - x) * height - y * width) <= thickness * width
+      raw.set(onDiagonal ? line : fill, y * stride + 1 + x * 3)
+    }
+  }
+  const header = new Uint8Array(13)
+  const view = new DataView(header.buffer)
+  view.setUint32(0, width)
+  view.setUint32(4, height)
+  header.set([8, 2, 0, 0, 0], 8) // 8-bit truecolour, default compression/filter, no interlace
+  return concat([
+    new Uint8Array([0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a]),
+    pngChunk("IHDR", header),
+    pngChunk("IDAT", deflateSync(raw)),
+    pngChunk("IEND", new Uint8Array(0))
+  ])
+}
+
+const escapeXml = (s: string): string =>
+  s.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;")
+
+export const renderSvg = (width: number, height: number, color: string, text: string): string => {
+  const ink = isLight(hexColor(color)) ? "#333333" : "#ffffff"
+  const fontSize = Math.max(8, Math.round(Math.min(width, height) / 8))
+  return [
+    `<svg xmlns="http://www.w3.org/2000/svg" width="${width}" height="${height}" viewBox="0 0 ${width} ${height}">`,
+    `<rect width="100%" height="100%" fill="${color}"/>`,
+    `<text x="50%" y="50%" dominant-baseline="middle" text-anchor="middle" font-family="sans-serif" ` +
+    `font-size="${fontSize}" fill="${ink}">${escapeXml(text)}</text>`,
+    "</svg>"
+  ].join("")
+}
+
+const PAGE_SIZES = { a4: [595, 842], letter: [612, 792] } as const
+
+// PDF string literals are bytes: keep printable ASCII and escape the delimiters
+const pdfString = (s: string): string => `(${s.replace(/[^\x20-\x7e]/g, "?").replace(/[\\()]/g, "\\$&")})`
+
+/**
+ * A PDF with `text` (one line per `\n`) at the top of every page and a
+ * "Page n of m" footer, set in the built-in Helvetica font.
+ */
+export const renderPdf = (text: string, pages: number, size: keyof typeof PAGE_SIZES): Uint8Array<ArrayBuffer> => {
+  const [width, height] = PAGE_SIZES[size]
+  const lines = text.split("\n")
+  // 1: catalog, 2: page tree, 3: font, then a page and its content stream per page
+  const pageIds = Array.from({ length: pages }, (_, i) => 4 + i * 2)
+  const objects: Array<string> = [
+    "<< /Type /Catalog /Pages 2 0 R >>",
+    `<< /Type /Pages /Kids [${pageIds.map((id) => `${id} 0 R`).join(" ")}] /Count ${pages} >>`,
+    "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+  ]
+  for (let i = 0; i < pages; i++) {
+    const content = [
+      "BT",
+      "/F1 16 Tf",
+      "20 TL",
+      `72 ${height - 90} Td`,
+      ...lines.map((l) => `${pdfString(l)} '`),
+      "ET",
+      "BT",
+      "/F1 9 Tf",
+      `${width / 2 - 30} 40 Td`,
+      `${pdfString(`Page ${i + 1} of ${pages}`)} Tj`,
+      "ET"
+    ].join("\n")
+    objects.push(
+      `<< /Type /Page /Parent 2 0 R /MediaBox [0 0 ${width} ${height}] ` +
+        `/Resources << /Font << /F1 3 0 R >> >> /Contents ${pageIds[i]! + 1} 0 R >>`,
+      `<< /Length ${content.length} >>\nstream\n${content}\nendstream`
+    )
+  }
+  // Everything is ASCII, so string offsets are byte offsets
+  let pdf = "%PDF-1.4\n"
+  const offsets = objects.map((body, i) => {
+    const offset = pdf.length
+    pdf += `${i + 1} 0 obj\n${body}\nendobj\n`
+    return offset
+  })
+  const xref = pdf.length
+  pdf += `xref\n0 ${objects.length + 1}\n0000000000 65535 f \n`
+  pdf += offsets.map((o) => `${String(o).padStart(10, "0")} 00000 n \n`).join("")
+  pdf += `trailer\n<< /Size ${objects.length + 1} /Root 1 0 R >>\nstartxref\n${xref}\n%%EOF\n`
+  return new TextEncoder().encode(pdf)
+}
+
+/**
+ * Render a response's `media`. `text` has already been through templates; without
+ * it images show their dimensions and PDFs a short placeholder line.
+ */
+export const renderMedia = (media: MediaConfig, text: string | undefined): RenderedMedia => {
+  if (media.type === "pdf") {
+    return { contentType: "application/pdf", bytes: renderPdf(text ?? "Sample document", media.pages, media.size) }
+  }
+  if (media.format === "svg") {
+    const svg = renderSvg(media.width, media.height, media.color, text ?? `${media.width}×${media.height}`)
+    return { contentType: "image/svg+xml", bytes: new TextEncoder().encode(svg) }
+  }
+  return { contentType: "image/png", bytes: renderPng(media.width, media.height, media.color) }
+}
@@ -0,0 +1,96 @@
+import type { ResponseConfig, Stub } from "../schemas/StubSchema"
+
+const VOLATILE_SEGMENT_PATTERNS = [
+  /^\d+$/,
+  /^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$/i,
+  /^[0-9a-f]{16,}$/i,
+  /^[0-9A-HJKMNP-TV-Z]{26}$/
+]
+
+const ISO_TIMESTAMP = /^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?$/
+
+// Headers that describe one specific upstream response and would be wrong when replayed
+const VOLATILE_HEADERS = new Set(["date", "content-length"])
+
+export const isVolatileSegment = (segment: string): boolean =>
+  VOLATILE_SEGMENT_PATTERNS.some((pattern) => pattern.test(segment))
+
+const escapeRegex = (s: string): string => s.replace(/[.*+?^${}()|[\]\\]/g, "\\$&")
+
+/**
+ * Turn a concrete path into an anchored regex where ID-like segments match any value.
+ * Returns undefined when the path has no volatile segments.
+ */
+export const pathPattern = (path: string): string | undefined => {
+  const segments = path.split("/")
+  if (!segments.some(isVolatileSegment)) return undefined
+  return `^${segments.map((s) => isVolatileSegment(s) ? "[^/]+" : escapeRegex(s)).join("/")}$`
+}
+
+// Keys that name identifiers: `id`, `_id`, or ending in `Id`/`ID`/`_id` such as `userId`, and their plurals
+const ID_KEY = /^(_?id|ID)s?$|[a-z0-9](Id|ID|_id)s?$/
+
+// Replace timestamps with the current time, and path IDs echoed in identifying fields with a
+// reference to the request; other fields keep their value even when it equals a path segment
+const templatizeBody = (value: unknown, segmentIndex: Map<string, number>, identifying = false): unknown => {
+  if (typeof value === "string") {
+    if (ISO_TIMESTAMP.test(value)) return "${$now()}"
+    const index = identifying ? segmentIndex.get(value) : undefined
+    return index !== undefined ? `\${$split(request.path, "/")[${index}]}` : value
+  }
+  if (typeof value === "number") {
+    const index = identifying ? segmentIndex.get(String(value)) : undefined
+    return index !== undefined ? `\${$number($split(request.path, "/")[${index}])}` : value
+  }
+  // Items of an identifying field, such as `userIds`, identify too
+  if (Array.isArray(value)) return value.map((item) => templatizeBody(item, segmentIndex, identifying))
+  if (typeof value === "object" && value !== null) {
+    return Object.fromEntries(
+      Object.entries(value).map(([key, val]) => [key, templatizeBody(val, segmentIndex, ID_KEY.test(key))])
+    )
+  }
+  return value
+}
+
+/**
+ * Generalize a freshly recorded stub so it can serve similar requests: ID-like path
+ * segments become a `matches` predicate, and volatile response fields become templates.
+ */
+export const normalizeRecordedStub = (stub: Stub, path: string): Stub => {
+  const pattern = pathPattern(path)
+  const segmentIndex = new Map<string, number>()
+  path.split("/").forEach((segment, i) => {
+    if (isVolatileSegment(segment) && !segmentIndex.has(segment)) segmentIndex.set(segment, i)
+  })
+
+  const predicates = stub.predicates.map((p) =>
+    pattern !== undefined && "field" in p && p.field === "path" && p.operator === "equals"
+      ? { ...p, operator: "matches" as const, value: pattern }
+      : p
+  )
+
+  const [first, ...rest] = stub.responses
+  const normalize = (response: ResponseConfig): ResponseConfig => {
+    const headers = response.headers !== undefined
+      ? Object.fromEntries(
+        Object.entries(response.headers).filter(([key]) => !VOLATILE_HEADERS.has(key.toLowerCase()))
+      )
+      : undefined
+    return {
+      ...response,
+      ...(headers !== undefined ? { headers } : {}),
+      ...(response.body !== undefined ? { body: templatizeBody(response.body, segmentIndex) } : {})
+    }
+  }
+
+  return { ...stub, predicates, responses: [normalize(first), ...rest.map(normalize)] }
+}
+
+/**
+ * True when `stubs` already contains a stub with the same predicates, i.e. recording
+ * `candidate` would only add a shadowed duplicate.
+ */
+export const isDuplicateRecording = (stubs: ReadonlyArray<Stub>, candidate: Stub): boolean => {
+  const key = JSON.stringify(candidate.predicates)
+  return stubs.some((stub) => JSON.stringify(stub.predicates) === key)
+}
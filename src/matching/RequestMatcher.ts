@@ -1,13 +1,108 @@
-import type { Predicate, Stub } from "../schemas/StubSchema"
+import * as Option from "effect/Option"
+import * as Schema from "effect/Schema"
+import { brotliDecompressSync, gunzipSync, inflateSync } from "node:zlib"
+import { matchPath } from "../domain/route"
+import { parseXml, selectValues, type XmlElement } from "../domain/xpath"
+import { AuthCredentials, type Predicate, type PredicateExpression, type Stub } from "../schemas/StubSchema"
+import { verifyCredentials } from "./Signatures"
 
 export interface RequestContext {
   readonly method: string
   readonly path: string
   readonly headers: Record<string, string>
   readonly query: Record<string, string>
+  // Every value of query parameters given more than once (`query` keeps the last one)
+  readonly queryValues?: Record<string, ReadonlyArray<string>>
+  // The query string as sent, without the leading "?"
+  readonly queryString?: string
   readonly body: unknown
+  // Bytes of body received, before decompression
+  readonly bodySize?: number
+  // Fields of an application/x-www-form-urlencoded body, shaped like `query`/`queryValues`
+  readonly form?: Record<string, string>
+  readonly formValues?: Record<string, ReadonlyArray<string>>
+  // Parameters captured by the matched stub's path template
+  readonly params?: Record<string, string>
 }
 
+// Last value of each parameter, plus every value of the ones given more than once
+const collectParams = (
+  params: URLSearchParams
+): { readonly last: Record<string, string>; readonly repeated?: Record<string, ReadonlyArray<string>> } => {
+  const last: Record<string, string> = {}
+  const all: Record<string, Array<string>> = {}
+  params.forEach((value, key) => {
+    last[key] = value
+    all[key] = [...(all[key] ?? []), value]
+  })
+  const repeated = Object.entries(all).filter(([, values]) => values.length > 1)
+  return repeated.length > 0 ? { last, repeated: Object.fromEntries(repeated) } : { last }
+}
+
+export const isFormContentType = (contentType: string): boolean =>
+  contentType.toLowerCase().includes("application/x-www-form-urlencoded")
+
+// The `form`/`formValues` context fields for a form-encoded body
+export const formFields = (text: string): Pick<RequestContext, "form" | "formValues"> => {
+  const { last, repeated } = collectParams(new URLSearchParams(text))
+  return { form: last, ...(repeated !== undefined ? { formValues: repeated } : {}) }
+}
+
+// Parameters of a query string, shaped like `query`/`queryValues`
+export const queryFields = (queryString: string): Pick<RequestContext, "query" | "queryValues"> => {
+  const { last, repeated } = collectParams(new URLSearchParams(queryString))
+  return { query: last, ...(repeated !== undefined ? { queryValues: repeated } : {}) }
+}
+
+// Most bytes a compressed request body may decode to, so a small upload can't inflate without bound
+export const MAX_DECODED_BODY_BYTES = 16 * 1024 * 1024
+
+const DECODERS: Record<string, (bytes: Uint8Array, options: { maxOutputLength: number }) => Uint8Array> = {
+  gzip: gunzipSync,
+  "x-gzip": gunzipSync,
+  deflate: inflateSync,
+  br: brotliDecompressSync
+}
+
+/**
+ * Undo a Content-Encoding such as "gzip" or "deflate, br" (applied in that order), so
+ * compressed uploads can be matched and templated like plain ones. A body in an unknown
+ * or corrupt encoding is returned as received, and undefined when it would decode to more
+ * than `maxBytes`.
+ */
+export const decodeBody = (
+  bytes: Uint8Array,
+  contentEncoding: string | undefined,
+  maxBytes = MAX_DECODED_BODY_BYTES
+): Uint8Array | undefined => {
+  const codings = (contentEncoding ?? "").split(",").map((c) => c.trim().toLowerCase())
+    .filter((c) => c !== "" && c !== "identity")
+  try {
+    return codings.reduceRight((decoded, coding) => {
+      const decode = DECODERS[coding]
+      if (decode === undefined) throw new Error(`Unsupported content-encoding ${coding}`)
+      return decode(decoded, { maxOutputLength: maxBytes })
+    }, bytes)
+  } catch (error) {
+    return error instanceof RangeError ? undefined : bytes
+  }
+}
+
+// Requests whose body would decode to more than MAX_DECODED_BODY_BYTES; they are answered 413 unmatched
+const oversizedBodies = new WeakSet<RequestContext>()
+
+export const isBodyTooLarge = (ctx: RequestContext): boolean => oversizedBodies.has(ctx)
+
+// Bodies exactly as received, for signature checks; kept out of the context so it stays plain data
+const receivedBodies = new WeakMap<RequestContext, Uint8Array>()
+
+// The body as received, or re-encoded from the context for requests that didn't come over the wire
+export const receivedBody = (ctx: RequestContext): Uint8Array =>
+  receivedBodies.get(ctx) ??
+    new TextEncoder().encode(
+      ctx.body === undefined ? "" : typeof ctx.body === "string" ? ctx.body : JSON.stringify(ctx.body)
+    )
+
 export const extractRequestContext = async (request: Request): Promise<RequestContext> => {
   const url = new URL(request.url)
   const method = request.method.toUpperCase()
@@ -18,15 +113,23 @@ export const extractRequestContext = async (request: Request): Promise<RequestCo
     headers[key.toLowerCase()] = value
   })
 
-  const query: Record<string, string> = {}
-  url.searchParams.forEach((value, key) => {
-    query[key] = value
-  })
+  const { last: query, repeated } = collectParams(url.searchParams)
 
   let body: unknown
+  let bodySize = 0
+  let received: Uint8Array | undefined
+  let form: Pick<RequestContext, "form" | "formValues"> = {}
+  let oversized = false
   if (request.body) {
     const contentType = request.headers.get("content-type") ?? ""
-    const text = await request.text()
+    const bytes = new Uint8Array(await request.arrayBuffer())
+    bodySize = bytes.byteLength
+    received = bytes
+    const decoded = decodeBody(bytes, headers["content-encoding"])
+    oversized = decoded === undefined
+    const text = decoded !== undefined ? new TextDecoder().decode(decoded) : ""
+    // The raw text stays the body, so existing body predicates keep working on forms
+    if (isFormContentType(contentType)) form = formFields(text)
     if (contentType.includes("application/json")) {
       try {
         body = JSON.parse(text)
@@ -38,7 +141,20 @@ export const extractRequestContext = async (request: Request): Promise<RequestCo
     }
   }
 
-  return { method, path, headers, query, body }
+  const ctx: RequestContext = {
+    method,
+    path,
+    headers,
+    query,
+    ...(repeated !== undefined ? { queryValues: repeated } : {}),
+    queryString: url.search.slice(1),
+    body,
+    bodySize,
+    ...form
+  }
+  if (received !== undefined) receivedBodies.set(ctx, received)
+  if (oversized) oversizedBodies.add(ctx)
+  return ctx
 }
 
 const normalize = (s: string, caseSensitive: boolean): string => caseSensitive ? s : s.toLowerCase()
@@ -64,15 +180,50 @@ const matchString = (
       const flags = caseSensitive ? "" : "i"
       return new RegExp(expected, flags).test(actual)
     }
+    case "template":
+      return Option.isSome(matchPath(expected, actual, caseSensitive))
+    case "equalToJson":
+    case "matchesJson":
+    case "between":
+    case "verifies":
+      return false
   }
 }
 
+// "application/json; charset=utf-8" -> "application/json"
+export const mediaType = (contentType: string | undefined): string | undefined => {
+  const type = contentType?.split(";")[0]?.trim().toLowerCase()
+  return type === undefined || type === "" ? undefined : type
+}
+
+/**
+ * Compare a media type with a pattern in which `*` stands for any run of characters
+ * within the type or subtype, e.g. `text/*` or `application/*+json`.
+ */
+export const matchMediaType = (actual: string, pattern: string): boolean => {
+  const source = pattern.trim().toLowerCase().split("*").map((part) => part.replace(/[.+?^${}()|[\]\\]/g, "\\$&"))
+  return new RegExp(`^${source.join("[^/]*")}$`).test(actual)
+}
+
+const matchSize = (actual: number, expected: unknown, operator: Predicate["operator"]): boolean => {
+  if (operator === "exists") return actual > 0
+  if (operator === "equals") return actual === expected
+  if (operator !== "between" || typeof expected !== "object" || expected === null) return false
+  const min = "min" in expected && typeof expected.min === "number" ? expected.min : 0
+  const max = "max" in expected && typeof expected.max === "number" ? expected.max : Infinity
+  return actual >= min && actual <= max
+}
+
+// A repeated query parameter matches when any of its values does. With `caseInsensitiveKeys`
+// (headers) names always compare case-insensitively; `caseSensitive` then only applies to values.
 const matchObject = (
-  actual: Record<string, string>,
+  actual: Record<string, string | ReadonlyArray<string>>,
   expected: unknown,
   operator: Predicate["operator"],
-  caseSensitive: boolean
+  caseSensitive: boolean,
+  caseInsensitiveKeys = false
 ): boolean => {
+  const keyCaseSensitive = caseSensitive && !caseInsensitiveKeys
   if (operator === "exists") {
     if (typeof expected !== "object" || expected === null) return true
     return Object.keys(expected as Record<string, unknown>).every((key) => key.toLowerCase() in actual || key in actual)
@@ -81,12 +232,14 @@ const matchObject = (
   const entries = Object.entries(expected as Record<string, unknown>)
   return entries.every(([key, val]) => {
     const actualKey = Object.keys(actual).find(
-      (k) => normalize(k, caseSensitive) === normalize(key, caseSensitive)
+      (k) => normalize(k, keyCaseSensitive) === normalize(key, keyCaseSensitive)
     )
     if (actualKey === undefined) return false
     const actualVal = actual[actualKey]!
     if (typeof val !== "string") return false
-    return matchString(actualVal, val, operator, caseSensitive)
+    return typeof actualVal === "string"
+      ? matchString(actualVal, val, operator, caseSensitive)
+      : actualVal.some((v) => matchString(v, val, operator, caseSensitive))
   })
 }
 
@@ -109,15 +262,79 @@ const deepSubsetMatch = (actual: unknown, expected: unknown, caseSensitive: bool
   return false
 }
 
+// Parse a JSON document given as text; anything else is already a document
+const asJsonDocument = (value: unknown): unknown => {
+  if (typeof value !== "string") return value
+  try {
+    return JSON.parse(value)
+  } catch {
+    return undefined
+  }
+}
+
+const isJsonObject = (value: unknown): value is Record<string, unknown> =>
+  typeof value === "object" && value !== null && !Array.isArray(value)
+
+const jsonPrimitiveEquals = (actual: unknown, expected: unknown, caseSensitive: boolean): boolean =>
+  typeof actual === "string" && typeof expected === "string"
+    ? normalize(actual, caseSensitive) === normalize(expected, caseSensitive)
+    : actual === expected
+
+// Structural equality: same keys and values, object key order ignored, array order kept
+const jsonEquals = (actual: unknown, expected: unknown, caseSensitive: boolean): boolean => {
+  if (Array.isArray(expected)) {
+    return Array.isArray(actual) && actual.length === expected.length &&
+      expected.every((e, i) => jsonEquals(actual[i], e, caseSensitive))
+  }
+  if (isJsonObject(expected)) {
+    if (!isJsonObject(actual)) return false
+    const keys = Object.keys(expected)
+    return keys.length === Object.keys(actual).length &&
+      keys.every((key) => key in actual && jsonEquals(actual[key], expected[key], caseSensitive))
+  }
+  return jsonPrimitiveEquals(actual, expected, caseSensitive)
+}
+
+// Partial match: extra fields are allowed and each expected array item may appear anywhere
+const jsonContains = (actual: unknown, expected: unknown, caseSensitive: boolean): boolean => {
+  if (Array.isArray(expected)) {
+    return Array.isArray(actual) && expected.every((e) => actual.some((a) => jsonContains(a, e, caseSensitive)))
+  }
+  if (isJsonObject(expected)) {
+    return isJsonObject(actual) &&
+      Object.entries(expected).every(([key, val]) => key in actual && jsonContains(actual[key], val, caseSensitive))
+  }
+  return jsonPrimitiveEquals(actual, expected, caseSensitive)
+}
+
+const hasKeyPaths = (actual: unknown, expected: Record<string, unknown>): boolean => {
+  if (typeof actual !== "object" || actual === null) return false
+  return Object.entries(expected).every(([key, val]) => {
+    if (!(key in actual)) return false
+    const next = (actual as Record<string, unknown>)[key]
+    if (typeof val === "object" && val !== null && !Array.isArray(val)) {
+      return hasKeyPaths(next, val as Record<string, unknown>)
+    }
+    return next !== undefined
+  })
+}
+
 const matchBody = (
   actual: unknown,
   expected: unknown,
   operator: Predicate["operator"],
   caseSensitive: boolean
 ): boolean => {
+  // No body matches no body predicate, so a negated one ("body does not contain") always holds
+  if (actual === undefined) return false
   switch (operator) {
     case "exists":
-      return actual !== null && actual !== undefined
+      if (actual === null) return false
+      // An object value lists the (nested) fields that must be present
+      if (typeof expected === "object" && expected !== null && !Array.isArray(expected)) {
+        return hasKeyPaths(actual, expected as Record<string, unknown>)
+      }
+      return true
     case "equals":
       return deepSubsetMatch(actual, expected, caseSensitive)
     case "contains": {
@@ -136,27 +353,284 @@ const matchBody = (
       const flags = caseSensitive ? "" : "i"
       return new RegExp(pattern, flags).test(a)
     }
+    case "template":
+      return typeof actual === "string" && typeof expected === "string" &&
+        Option.isSome(matchPath(expected, actual, caseSensitive))
+    case "equalToJson": {
+      const document = asJsonDocument(actual)
+      return document !== undefined && jsonEquals(document, asJsonDocument(expected), caseSensitive)
+    }
+    case "matchesJson": {
+      const document = asJsonDocument(actual)
+      return document !== undefined && jsonContains(document, asJsonDocument(expected), caseSensitive)
+    }
+    case "between":
+    case "verifies":
+      return false
   }
 }
 
-export const evaluatePredicate = (ctx: RequestContext, predicate: Predicate): boolean => {
+const isXmlContentType = (contentType: string): boolean => /[/+]xml\b/i.test(contentType)
+
+// Parsed once per request however many xpath predicates look at it; null when the body isn't XML
+const xmlBodies = new WeakMap<RequestContext, XmlElement | null>()
+
+const xmlBody = (ctx: RequestContext): XmlElement | null => {
+  const cached = xmlBodies.get(ctx)
+  if (cached !== undefined) return cached
+  let document: XmlElement | null = null
+  // Legacy clients often send XML without an XML content type, so markup-looking bodies qualify too
+  const body = ctx.body
+  if (
+    typeof body === "string" &&
+    (isXmlContentType(ctx.headers["content-type"] ?? "") || body.trimStart().startsWith("<"))
+  ) {
+    try {
+      document = parseXml(body)
+    } catch {
+      document = null
+    }
+  }
+  xmlBodies.set(ctx, document)
+  return document
+}
+
+const matchXPath = (
+  ctx: RequestContext,
+  xpath: string,
+  expected: unknown,
+  operator: Predicate["operator"],
+  caseSensitive: boolean
+): boolean => {
+  const document = xmlBody(ctx)
+  if (document === null) return false
+  const values = selectValues(document, xpath)
+  return operator === "exists"
+    ? values.length > 0
+    : values.some((actual) => matchString(actual, expected, operator, caseSensitive))
+}
+
+// "api.example.com:8080" -> "api.example.com", "[::1]:8080" -> "[::1]"
+export const hostOf = (headers: Record<string, string>): string | undefined => {
+  const host = headers["host"]
+  return host === undefined ? undefined : host.replace(/:\d+$/, "").toLowerCase()
+}
+
+const isAuthCredentials = Schema.is(AuthCredentials)
+
+const evaluateField = (ctx: RequestContext, predicate: Predicate): boolean => {
   const { caseSensitive, field, operator, value } = predicate
+  if (predicate.xpath !== undefined) return matchXPath(ctx, predicate.xpath, value, operator, caseSensitive)
   switch (field) {
     case "method":
       return matchString(ctx.method, value, operator, caseSensitive)
     case "path":
       return matchString(ctx.path, value, operator, caseSensitive)
     case "headers":
-      return matchObject(ctx.headers, value, operator, caseSensitive)
+      return matchObject(ctx.headers, value, operator, caseSensitive, true)
     case "query":
-      return matchObject(ctx.query, value, operator, caseSensitive)
+      return matchObject({ ...ctx.query, ...ctx.queryValues }, value, operator, caseSensitive)
+    case "queryString": {
+      const queryString = ctx.queryString ?? ""
+      return operator === "exists" ? queryString !== "" : matchString(queryString, value, operator, caseSensitive)
+    }
+    case "form":
+      return matchObject({ ...ctx.form, ...ctx.formValues }, value, operator, caseSensitive)
     case "body":
       return matchBody(ctx.body, value, operator, caseSensitive)
+    case "host": {
+      // Host names are case-insensitive whatever `caseSensitive` says
+      const host = hostOf(ctx.headers)
+      return host !== undefined && matchString(host, value, operator, false)
+    }
+    case "bodySize":
+      return matchSize(ctx.bodySize ?? 0, value, operator)
+    case "encoding":
+      return matchString((ctx.headers["content-encoding"] ?? "identity").toLowerCase(), value, operator, false)
+    case "contentType": {
+      const type = mediaType(ctx.headers["content-type"])
+      if (type === undefined) return false
+      return operator === "equals" && typeof value === "string"
+        ? matchMediaType(type, value)
+        : matchString(type, value, operator, false)
+    }
+    case "authorization":
+      if (operator === "exists") return ctx.headers["authorization"] !== undefined || "X-Amz-Signature" in ctx.query
+      return isAuthCredentials(value) && verifyCredentials(ctx, receivedBody(ctx), value)
+  }
+}
+
+export const evaluatePredicate = (ctx: RequestContext, predicate: Predicate): boolean => {
+  const result = evaluateField(ctx, predicate)
+  return predicate.negate === true ? !result : result
+}
+
+export const evaluatePredicateExpression = (ctx: RequestContext, expr: PredicateExpression): boolean => {
+  if ("and" in expr) return expr.and.every((e) => evaluatePredicateExpression(ctx, e))
+  if ("or" in expr) return expr.or.some((e) => evaluatePredicateExpression(ctx, e))
+  if ("not" in expr) return !evaluatePredicateExpression(ctx, expr.not)
+  return evaluatePredicate(ctx, expr)
+}
+
+export const evaluatePredicates = (ctx: RequestContext, predicates: ReadonlyArray<PredicateExpression>): boolean =>
+  predicates.length === 0 || predicates.every((p) => evaluatePredicateExpression(ctx, p))
+
+// Path predicates that must all hold for the stub to match (top level and inside `and`)
+const requiredPathPredicates = (exprs: ReadonlyArray<PredicateExpression>): Array<Predicate> =>
+  exprs.flatMap((expr) => {
+    if ("and" in expr) return requiredPathPredicates(expr.and)
+    if ("or" in expr || "not" in expr) return []
+    return expr.field === "path" && expr.negate !== true ? [expr] : []
+  })
+
+// Per-segment weights: a literal beats `{name}`, which beats `*`. A trailing `{name...}` ranks
+// below everything, even below a path that ends before it: `/files` beats `/files/{rest...}`.
+const segmentWeights = (predicate: Predicate): ReadonlyArray<number> => {
+  if (typeof predicate.value !== "string") return []
+  if (predicate.operator === "equals") return predicate.value.split("/").map(() => 4)
+  if (predicate.operator !== "template") return []
+  return predicate.value.split("/").map((segment) =>
+    /^\{\w+\.\.\.\}$/.test(segment) ? 0 : /^\{\w+\}$/.test(segment) ? 3 : segment === "*" ? 2 : 4
+  )
+}
+
+const ENDED = 1
+
+// Segment by segment, the first difference decides
+const compareWeights = (a: ReadonlyArray<number>, b: ReadonlyArray<number>): number => {
+  for (let i = 0; i < Math.max(a.length, b.length); i++) {
+    const diff = (a[i] ?? ENDED) - (b[i] ?? ENDED)
+    if (diff !== 0) return diff
   }
+  return 0
+}
+
+/**
+ * How specific a stub's path is, from its `equals` and `template` path predicates.
+ * Stubs matching paths only by pattern (or not at all) rank below any of them.
+ */
+export const pathSpecificity = (stub: Stub): ReadonlyArray<number> =>
+  requiredPathPredicates(stub.predicates)
+    .map(segmentWeights)
+    .reduce<ReadonlyArray<number>>((best, weights) => compareWeights(weights, best) > 0 ? weights : best, [])
+
+// "definition" tries stubs in the order they were defined in; "specificity" tries the most specific path first
+export type StubOrder = "definition" | "specificity"
+
+const rankings: Record<StubOrder, WeakMap<ReadonlyArray<Stub>, ReadonlyArray<Stub>>> = {
+  definition: new WeakMap(),
+  specificity: new WeakMap()
+}
+
+/**
+ * The order in which stubs are tried: highest `priority` first, then, with "specificity",
+ * the most specific path (`/users/me` before `/users/{id}`), then the order they were
+ * defined in. Cached per stub list, which is replaced rather than mutated on every change.
+ */
+export const rankStubs = (stubs: ReadonlyArray<Stub>, order: StubOrder = "definition"): ReadonlyArray<Stub> => {
+  const cached = rankings[order].get(stubs)
+  if (cached !== undefined) return cached
+  const specificity = new Map(order === "specificity" ? stubs.map((stub) => [stub, pathSpecificity(stub)]) : [])
+  const ranked = [...stubs].sort((a, b) =>
+    (b.priority ?? 0) - (a.priority ?? 0) || compareWeights(specificity.get(b) ?? [], specificity.get(a) ?? [])
+  )
+  rankings[order].set(stubs, ranked)
+  return ranked
 }
 
-export const evaluatePredicates = (ctx: RequestContext, predicates: ReadonlyArray<Predicate>): boolean =>
-  predicates.length === 0 || predicates.every((p) => evaluatePredicate(ctx, p))
+// Whether a stub may match at all, such as only in its scenario's required state. Kept apart from the
+// stub list so the list, and its cached ranking, stays the same from one request to the next.
+export type StubFilter = (stub: Stub) => boolean
+
+const everyStub: StubFilter = () => true
 
-export const findMatchingStub = (ctx: RequestContext, stubs: ReadonlyArray<Stub>): Stub | undefined =>
-  stubs.find((stub) => evaluatePredicates(ctx, stub.predicates))
+export const findMatchingStub = (
+  ctx: RequestContext,
+  stubs: ReadonlyArray<Stub>,
+  order: StubOrder = "definition",
+  eligible: StubFilter = everyStub
+): Stub | undefined =>
+  rankStubs(stubs, order).find((stub) => eligible(stub) && evaluatePredicates(ctx, stub.predicates))
+
+export interface MatchResult {
+  readonly stub: Stub | undefined
+  // Stubs whose predicates were evaluated: up to the match, or all of them when none matched
+  readonly candidates: number
+  readonly matchMs: number
+}
+
+// `findMatchingStub`, plus what finding the stub cost
+export const matchStub = (
+  ctx: RequestContext,
+  stubs: ReadonlyArray<Stub>,
+  order: StubOrder = "definition",
+  eligible: StubFilter = everyStub
+): MatchResult => {
+  const started = performance.now()
+  let candidates = 0
+  for (const stub of rankStubs(stubs, order)) {
+    if (!eligible(stub)) continue
+    candidates++
+    if (evaluatePredicates(ctx, stub.predicates)) return { stub, candidates, matchMs: performance.now() - started }
+  }
+  return { stub: undefined, candidates, matchMs: performance.now() - started }
+}
+
+// `method equals` predicates that must all hold for the stub to match (top level and inside `and`)
+const requiredMethods = (exprs: ReadonlyArray<PredicateExpression>): Array<string> =>
+  exprs.flatMap((expr) => {
+    if ("and" in expr) return requiredMethods(expr.and)
+    if ("or" in expr || "not" in expr) return []
+    return expr.field === "method" && expr.operator === "equals" && expr.negate !== true &&
+        typeof expr.value === "string"
+      ? [expr.value.toUpperCase()]
+      : []
+  })
+
+/**
+ * Methods that some stub would accept for this request, had it used that method instead.
+ * Empty when no stub matches the rest of the request, i.e. the path is unknown, so the
+ * caller can tell a 405 from a 404. Only `method equals` predicates are considered.
+ */
+export const allowedMethods = (
+  ctx: RequestContext,
+  stubs: ReadonlyArray<Stub>,
+  eligible: StubFilter = everyStub
+): ReadonlyArray<string> => {
+  const allowed = new Set<string>()
+  for (const stub of stubs) {
+    if (!eligible(stub)) continue
+    for (const method of requiredMethods(stub.predicates)) {
+      if (!allowed.has(method) && evaluatePredicates({ ...ctx, method }, stub.predicates)) allowed.add(method)
+    }
+  }
+  return [...allowed].sort()
+}
+
+// Capturing path predicates that must all hold for the stub to match
+const requiredPathCaptures = (exprs: ReadonlyArray<PredicateExpression>): Array<Predicate> =>
+  requiredPathPredicates(exprs).filter((p) => p.operator === "template" || p.operator === "matches")
+
+const capturePathParams = (predicate: Predicate, path: string): Record<string, string> => {
+  if (typeof predicate.value !== "string") return {}
+  if (predicate.operator === "template") {
+    return Option.getOrElse(matchPath(predicate.value, path, predicate.caseSensitive), () => ({}))
+  }
+  // Named groups of a regex, e.g. "^/orders/(?<orderId>\d+)$"
+  const groups = new RegExp(predicate.value, predicate.caseSensitive ? "" : "i").exec(path)?.groups ?? {}
+  return Object.fromEntries(
+    Object.entries(groups).filter((entry): entry is [string, string] => entry[1] !== undefined)
+  )
+}
+
+/**
+ * Attach the parameters captured by a matched stub's path templates and named regex
+ * groups to the context, so responses can use them as `{{request.params.id}}` or `${request.params.id}`.
+ */
+export const withPathParams = (ctx: RequestContext, stub: Stub): RequestContext => {
+  const params: Record<string, string> = {}
+  for (const predicate of requiredPathCaptures(stub.predicates)) {
+    Object.assign(params, capturePathParams(predicate, ctx.path))
+  }
+  return Object.keys(params).length > 0 ? { ...ctx, params } : ctx
+}
@@ -1,16 +1,89 @@
 import * as Effect from "effect/Effect"
 import * as HashMap from "effect/HashMap"
+import * as Option from "effect/Option"
 import * as Ref from "effect/Ref"
-import type { ResponseConfig, ResponseMode } from "../schemas/StubSchema"
-import type { RequestContext } from "./RequestMatcher"
+import { readFile } from "node:fs/promises"
+import * as path from "node:path"
+import type {
+  BodyType,
+  LatencyDistribution,
+  ResponseConfig,
+  ResponseDelay,
+  ResponseMode,
+  ResponseSchedule,
+  SseEvent,
+  Stub,
+  TimeWindow,
+  Weekday
+} from "../schemas/StubSchema"
+import { answerBatch, withResults } from "./Batch"
+import { eventStream, formatEvent, type StreamedEvent } from "./EventStream"
+import { generateExample } from "./ExampleGenerator"
+import type { TemplateHelpers, TemplateStore } from "./ExpressionEvaluator"
+import { type JsonSchema, validateJsonSchema } from "./JsonSchema"
+import { renderMedia } from "./Media"
+import {
+  findMatchingStub,
+  type RequestContext,
+  type StubFilter,
+  type StubOrder,
+  withPathParams
+} from "./RequestMatcher"
+import { applyTransforms } from "./ResponseTransforms"
 import { applyTemplates } from "./TemplateEngine"
 
 type CounterMap = HashMap.HashMap<string, number>
 type CounterResult = readonly [Effect.Effect<number, never>, CounterMap]
 
+// Whether a response's `calls` ("3", "2+" or "2-4") covers the stub's nth call
+export const coversCall = (calls: string, call: number): boolean => {
+  const [from, to] = calls.endsWith("+")
+    ? [Number(calls.slice(0, -1)), Infinity]
+    : calls.includes("-")
+    ? calls.split("-").map(Number) as [number, number]
+    : [Number(calls), Number(calls)]
+  return call >= from && call <= to
+}
+
+const WEEKDAYS: ReadonlyArray<Weekday> = ["sun", "mon", "tue", "wed", "thu", "fri", "sat"]
+
+const minutesOf = (time: string) => Number(time.slice(0, 2)) * 60 + Number(time.slice(3))
+
+// The weekday (0 for Sunday) and minute of the day `time` falls on in `timeZone`
+const localTime = (time: number, timeZone: string) => {
+  const parts = new Intl.DateTimeFormat("en-US", {
+    timeZone,
+    weekday: "short",
+    hour: "2-digit",
+    minute: "2-digit",
+    hourCycle: "h23"
+  }).formatToParts(time)
+  const part = (type: Intl.DateTimeFormatPartTypes) => parts.find((p) => p.type === type)?.value ?? ""
+  return {
+    day: WEEKDAYS.indexOf(part("weekday").toLowerCase() as Weekday),
+    minutes: Number(part("hour")) * 60 + Number(part("minute"))
+  }
+}
+
+// Whether `time` (epoch ms) falls in a response's `during` window
+export const withinWindow = (window: TimeWindow, time: number): boolean => {
+  const { day, minutes } = localTime(time, window.timezone ?? "UTC")
+  const [from, to] = [minutesOf(window.from), minutesOf(window.to)]
+  const overnight = to <= from
+  const inside = overnight ? minutes >= from || minutes < to : minutes >= from && minutes < to
+  if (!inside || window.days === undefined) return inside
+  // Past midnight, the window still belongs to the day it started on
+  const started = overnight && minutes < to ? (day + 6) % 7 : day
+  return window.days.includes(WEEKDAYS[started]!)
+}
+
 export const makeResponseState = () =>
   Effect.gen(function*() {
     const countersRef = yield* Ref.make<CounterMap>(HashMap.empty())
+    // How many times each stub has answered, for responses picked by `calls`
+    const callsRef = yield* Ref.make<CounterMap>(HashMap.empty())
+    // When the imposter's scenario began, for responses scheduled relative to it
+    const scenarioStartRef = yield* Ref.make(Date.now())
 
     const getNextIndex = (
       imposterId: string,
@@ -39,48 +112,446 @@ export const makeResponseState = () =>
       }).pipe(Effect.flatten)
     }
 
-    const reset = (imposterId: string): Effect.Effect<void> =>
-      Ref.update(countersRef, (counters) => {
-        let updated = counters
-        for (const key of HashMap.keys(counters)) {
-          if (key.startsWith(`${imposterId}:`)) {
-            updated = HashMap.remove(updated, key)
-          }
-        }
-        return updated
+    // The response for the stub's next call among `responses`, none of which has `during`
+    const untimedIndex = (
+      imposterId: string,
+      stubId: string,
+      responses: ReadonlyArray<ResponseConfig>,
+      mode: ResponseMode
+    ): Effect.Effect<number> => {
+      if (responses.every((r) => r.calls === undefined)) return getNextIndex(imposterId, stubId, responses.length, mode)
+      const key = `${imposterId}:${stubId}`
+      return Effect.gen(function*() {
+        const call = yield* Ref.modify(callsRef, (calls): readonly [number, CounterMap] => {
+          const next = Option.getOrElse(HashMap.get(calls, key), () => 0) + 1
+          return [next, HashMap.set(calls, key, next)]
+        })
+        const covering = responses.findIndex((r) => r.calls !== undefined && coversCall(r.calls, call))
+        if (covering !== -1) return covering
+        const open = responses.flatMap((r, i) => r.calls === undefined ? [i] : [])
+        if (open.length === 0) return responses.length - 1
+        return open[yield* getNextIndex(imposterId, stubId, open.length, mode)]!
       })
+    }
+
+    /**
+     * The response to answer the stub's next call with. The first response whose `during`
+     * window holds `now` (the imposter's clock) answers it, else the last response when all of
+     * them have windows. Otherwise the first response whose `calls` covers the call does; other
+     * calls cycle through the remaining responses as `mode` says, or get the last of them when
+     * every one has `calls`.
+     */
+    const nextIndex = (
+      imposterId: string,
+      stubId: string,
+      responses: ReadonlyArray<ResponseConfig>,
+      mode: ResponseMode,
+      now: number = Date.now()
+    ): Effect.Effect<number> => {
+      if (responses.every((r) => r.during === undefined)) return untimedIndex(imposterId, stubId, responses, mode)
+      const timed = responses.findIndex((r) => r.during !== undefined && withinWindow(r.during, now))
+      if (timed !== -1) return Effect.succeed(timed)
+      const untimed = responses.flatMap((r, i) => r.during === undefined ? [i] : [])
+      if (untimed.length === 0) return Effect.succeed(responses.length - 1)
+      return Effect.map(
+        untimedIndex(imposterId, stubId, untimed.map((i) => responses[i]!), mode),
+        (i) => untimed[i]!
+      )
+    }
+
+    const withoutImposter = (imposterId: string) => (counters: CounterMap) => {
+      let updated = counters
+      for (const key of HashMap.keys(counters)) {
+        if (key.startsWith(`${imposterId}:`)) {
+          updated = HashMap.remove(updated, key)
+        }
+      }
+      return updated
+    }
+
+    // Starts response cycles and `calls` counts over
+    const resetCounters = (imposterId: string): Effect.Effect<void> =>
+      Ref.update(countersRef, withoutImposter(imposterId)).pipe(
+        Effect.zipRight(Ref.update(callsRef, withoutImposter(imposterId)))
+      )
 
-    return { getNextIndex, reset }
+    // Starts the clock scheduled responses count from over
+    const restartScenario: Effect.Effect<void> = Ref.set(scenarioStartRef, Date.now())
+
+    const reset = (imposterId: string): Effect.Effect<void> =>
+      Effect.zipRight(resetCounters(imposterId), restartScenario)
+
+    return { getNextIndex, nextIndex, reset, resetCounters, restartScenario, scenarioStart: Ref.get(scenarioStartRef) }
   })
 
-export const buildResponse = async (config: ResponseConfig, ctx: RequestContext): Promise<Response> => {
+// Names the response of the matched stub to answer with, e.g. `X-Mock-Example: rate-limited`
+export const EXAMPLE_HEADER = "x-mock-example"
+
+// The index of the first response named `example`, or undefined when none is
+export const exampleIndex = (
+  responses: ReadonlyArray<ResponseConfig>,
+  example: string | undefined
+): number | undefined => {
+  const index = example === undefined ? -1 : responses.findIndex((r) => r.example === example)
+  return index === -1 ? undefined : index
+}
+
+const MAX_DELAY = 60000
+
+const uniformMs = (min: number, max: number, random: () => number) => min + Math.floor(random() * (max - min + 1))
+
+// A standard normal draw (Box-Muller)
+const gaussian = (random: () => number) => Math.sqrt(-2 * Math.log(1 - random())) * Math.cos(2 * Math.PI * random())
+
+const sampleMs = (distribution: LatencyDistribution, random: () => number): number => {
+  if (distribution.distribution === "uniform") return uniformMs(distribution.min, distribution.max, random)
+  const { mean, stddev } = distribution
+  if (distribution.distribution === "normal") return mean + stddev * gaussian(random)
+  // The log-space parameters giving a wait of this mean and standard deviation
+  const sigma2 = Math.log(1 + (stddev * stddev) / (mean * mean))
+  return Math.exp(Math.log(mean) - sigma2 / 2 + Math.sqrt(sigma2) * gaussian(random))
+}
+
+/**
+ * Milliseconds to wait for `delay`: itself, a whole number from `min` to `max` with every one
+ * as likely, or a draw from its distribution, unless the draw lands in the distribution's tail.
+ */
+export const delayMs = (delay: ResponseDelay, random: () => number = Math.random): number => {
+  if (typeof delay === "number") return delay
+  if (!("distribution" in delay)) return uniformMs(delay.min, delay.max, random)
+  if (delay.tail !== undefined && random() * 100 >= delay.tail.percentile) return delay.tail.delay
+  return Math.min(MAX_DELAY, Math.max(0, Math.round(sampleMs(delay, random))))
+}
+
+/**
+ * Milliseconds to hold a response so it goes out at `schedule.at` on the imposter's clock (`now`),
+ * or `schedule.after` ms (plus or minus up to `jitter`) into the scenario, `elapsed` ms of which
+ * have gone by. Zero once that time has passed.
+ */
+export const scheduledWait = (
+  schedule: ResponseSchedule,
+  elapsed: number,
+  now: number,
+  random: () => number = Math.random
+): number => {
+  const wait = schedule.at !== undefined
+    ? Date.parse(schedule.at) - now
+    : (schedule.after ?? 0) + (schedule.jitter ?? 0) * (random() * 2 - 1) - elapsed
+  return Math.max(0, wait)
+}
+
+const asText = (value: unknown): string => typeof value === "string" ? value : JSON.stringify(value)
+
+const csvCell = (value: unknown): string => {
+  const text = value === null || value === undefined ? "" : asText(value)
+  return /[",\r\n]/.test(text) ? `"${text.replace(/"/g, '""')}"` : text
+}
+
+/**
+ * Rows as RFC 4180 CSV: arrays are rows of cells, and objects are rows under a header
+ * line of every key they use, in order of appearance.
+ */
+export const toCsv = (rows: ReadonlyArray<unknown>): string => {
+  if (rows.length === 0) return ""
+  const isRecord = (row: unknown): row is Record<string, unknown> =>
+    row !== null && typeof row === "object" && !Array.isArray(row)
+  const lines: Array<ReadonlyArray<unknown>> = []
+  if (rows.every(isRecord)) {
+    const columns = [...new Set(rows.flatMap((row) => Object.keys(row)))]
+    lines.push(columns, ...rows.map((row) => columns.map((column) => row[column])))
+  } else {
+    lines.push(...rows.map((row) => Array.isArray(row) ? row : [row]))
+  }
+  return lines.map((line) => line.map(csvCell).join(",")).join("\r\n") + "\r\n"
+}
+
+const BODY_CONTENT_TYPES: Record<Exclude<BodyType, "raw">, string> = {
+  json: "application/json",
+  text: "text/plain; charset=utf-8",
+  html: "text/html; charset=utf-8",
+  csv: "text/csv; charset=utf-8",
+  xml: "application/xml"
+}
+
+// The body's text and the Content-Type it implies, if any
+const serializeBody = (body: unknown, bodyType: BodyType | undefined): readonly [string, string | undefined] => {
+  switch (bodyType) {
+    case undefined:
+      return typeof body === "string" ? [body, "text/plain"] : [JSON.stringify(body), "application/json"]
+    case "json":
+      return [JSON.stringify(body), BODY_CONTENT_TYPES.json]
+    case "csv":
+      return [Array.isArray(body) ? toCsv(body) : asText(body), BODY_CONTENT_TYPES.csv]
+    case "xml":
+      return [typeof body === "string" ? body : toXml(body), BODY_CONTENT_TYPES.xml]
+    case "raw":
+      return [asText(body), undefined]
+    default:
+      return [asText(body), BODY_CONTENT_TYPES[bodyType]]
+  }
+}
+
+const escapeXml = (text: string): string =>
+  text.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;")
+
+const xmlElement = (name: string, value: unknown): string => {
+  if (Array.isArray(value)) return value.map((item) => xmlElement(name, item)).join("")
+  if (value === null || value === undefined) return `<${name}/>`
+  if (typeof value !== "object") return `<${name}>${escapeXml(String(value))}</${name}>`
+  let attributes = ""
+  let content = ""
+  for (const [key, child] of Object.entries(value)) {
+    if (key.startsWith("@")) attributes += ` ${key.slice(1)}="${escapeXml(String(child))}"`
+    else if (key === "#text") content += escapeXml(String(child))
+    else content += xmlElement(key, child)
+  }
+  return content === "" ? `<${name}${attributes}/>` : `<${name}${attributes}>${content}</${name}>`
+}
+
+/**
+ * A structured body as an XML document. An object with a single key is the root element;
+ * anything else is wrapped in `<response>`, a top-level array as `<item>`s. Keys starting with
+ * `@` become attributes, `#text` the element's text, and arrays repeat their element.
+ */
+export const toXml = (body: unknown): string => {
+  const entries = body !== null && typeof body === "object" && !Array.isArray(body) ? Object.entries(body) : []
+  const [root, value] = entries.length === 1 && !entries[0]![0].startsWith("@") && entries[0]![0] !== "#text"
+    ? entries[0]!
+    : ["response", Array.isArray(body) ? { item: body } : body]
+  return `<?xml version="1.0" encoding="UTF-8"?>${xmlElement(root, value)}`
+}
+
+// Content-Types of fixture files by extension; other files are served as application/octet-stream
+const FILE_CONTENT_TYPES: Record<string, string> = {
+  ".json": BODY_CONTENT_TYPES.json,
+  ".txt": BODY_CONTENT_TYPES.text,
+  ".html": BODY_CONTENT_TYPES.html,
+  ".htm": BODY_CONTENT_TYPES.html,
+  ".csv": BODY_CONTENT_TYPES.csv,
+  ".xml": BODY_CONTENT_TYPES.xml,
+  ".pdf": "application/pdf",
+  ".png": "image/png",
+  ".jpg": "image/jpeg",
+  ".jpeg": "image/jpeg",
+  ".gif": "image/gif",
+  ".svg": "image/svg+xml",
+  ".zip": "application/zip"
+}
+
+// The Content-Type for a fixture: `bodyType`'s if set, else one guessed from the extension
+const fileContentType = (file: string, bodyType: BodyType | undefined): string | undefined => {
+  if (bodyType === "raw") return undefined
+  if (bodyType !== undefined) return BODY_CONTENT_TYPES[bodyType]
+  return FILE_CONTENT_TYPES[path.extname(file).toLowerCase()] ?? "application/octet-stream"
+}
+
+/**
+ * `file` resolved against `fixturesDir`, or undefined when it would escape the directory
+ * (an absolute path, or `..` segments leading out of it).
+ */
+export const resolveFixture = (fixturesDir: string, file: string): string | undefined => {
+  const root = path.resolve(fixturesDir)
+  const resolved = path.resolve(root, file)
+  return resolved.startsWith(root + path.sep) ? resolved : undefined
+}
+
+// Statuses a Response can't be built with, or can't carry a body with
+const MIN_STATUS = 200
+export const NULL_BODY_STATUSES = new Set([204, 205, 304])
+
+// A rendered `status` as a status code, or undefined unless it is a whole number from 200 to 599
+export const toStatus = (rendered: unknown): number | undefined => {
+  const status = typeof rendered === "number" ? rendered : Number(String(rendered))
+  return Number.isInteger(status) && status >= MIN_STATUS && status <= 599 ? status : undefined
+}
+
+// The helpers with the clock read once, so every `{{now}}` of one response agrees
+const atOneTime = (helpers: TemplateHelpers): TemplateHelpers => {
+  const at = (helpers.now ?? Date.now)()
+  return { ...helpers, now: () => at }
+}
+
+// Server-Sent Events rendered for the request, ready to send
+export const renderEvents = (
+  events: ReadonlyArray<SseEvent>,
+  ctx: RequestContext,
+  helpers: TemplateHelpers = {}
+): Promise<ReadonlyArray<StreamedEvent>> => {
+  const once = atOneTime(helpers)
+  return Promise.all(events.map(async ({ delay, ...event }) => ({
+    text: formatEvent(await applyTemplates(ctx, event, once) as typeof event),
+    delay: delay ?? 0
+  })))
+}
+
+export const buildResponse = async (
+  config: ResponseConfig,
+  ctx: RequestContext,
+  templateHelpers: TemplateHelpers = {},
+  // Where `bodyFile` paths are resolved
+  fixturesDir: string = "."
+): Promise<Response> => {
+  const helpers = atOneTime(templateHelpers)
+  // Answered before the status, which a failed operation can set
+  const batch = config.batch !== undefined ? await answerBatch(config.batch, ctx, helpers) : undefined
+  const rendered = batch?.status ??
+    (typeof config.status === "number" ? config.status : await applyTemplates(ctx, config.status, helpers))
+  const status = toStatus(rendered)
+  if (status === undefined) {
+    return new Response(JSON.stringify({ error: "Status is not a valid status code", status: rendered }), {
+      status: 500,
+      headers: { "content-type": "application/json" }
+    })
+  }
+
   const headers = new Headers()
   const responseHeaders = config.headers
   if (responseHeaders !== undefined) {
     for (const [key, val] of Object.entries(responseHeaders)) {
-      const templated = await applyTemplates(ctx, val)
+      const templated = await applyTemplates(ctx, val, helpers)
       headers.set(key, typeof templated === "string" ? templated : String(templated))
     }
   }
 
+  if (config.events !== undefined) {
+    const events = await renderEvents(config.events, ctx, helpers)
+    if (!headers.has("content-type")) headers.set("content-type", "text/event-stream")
+    if (!headers.has("cache-control")) headers.set("cache-control", "no-cache")
+    return new Response(eventStream(events), { status, headers })
+  }
+
+  if (config.body === undefined && config.bodyFile !== undefined) {
+    const file = resolveFixture(fixturesDir, config.bodyFile)
+    const bytes = file === undefined ? undefined : await readFile(file).catch(() => undefined)
+    if (bytes === undefined) {
+      return new Response(JSON.stringify({ error: "Body file not found", bodyFile: config.bodyFile }), {
+        status: 500,
+        headers: { "content-type": "application/json" }
+      })
+    }
+    const contentType = fileContentType(config.bodyFile, config.bodyType)
+    if (contentType !== undefined && !headers.has("content-type")) {
+      headers.set("content-type", contentType)
+    }
+    return new Response(new Uint8Array(bytes), { status, headers })
+  }
+
+  if (config.body === undefined && config.media !== undefined) {
+    const text = config.media.text !== undefined ? await applyTemplates(ctx, config.media.text, helpers) : undefined
+    const media = renderMedia(config.media, text === undefined ? undefined : String(text))
+    if (!headers.has("content-type")) {
+      headers.set("content-type", media.contentType)
+    }
+    return new Response(media.bytes, { status, headers })
+  }
+
   let bodyStr: string | null = null
-  if (config.body !== undefined) {
-    const templated = await applyTemplates(ctx, config.body)
-    if (typeof templated === "string") {
-      bodyStr = templated
-      if (!headers.has("content-type")) {
-        headers.set("content-type", "text/plain")
-      }
-    } else {
-      bodyStr = JSON.stringify(templated)
-      if (!headers.has("content-type")) {
-        headers.set("content-type", "application/json")
-      }
+  const body = config.body ?? (config.bodySchema !== undefined
+    ? generateExample(config.bodySchema.schema, config.bodySchema)
+    : undefined)
+  if (body !== undefined || batch !== undefined) {
+    // Generated bodies are templated too, so schema examples can echo the request
+    const rendered = await applyTemplates(ctx, body, helpers)
+    const answered = batch !== undefined ? withResults(rendered, batch.results, config.batch?.into) : rendered
+    const templated = config.transforms !== undefined ? applyTransforms(answered, config.transforms) : answered
+    const [text, contentType] = serializeBody(templated, config.bodyType)
+    bodyStr = text
+    if (contentType !== undefined && !headers.has("content-type")) {
+      headers.set("content-type", contentType)
     }
   }
 
-  return new Response(bodyStr, {
-    status: config.status,
+  if (NULL_BODY_STATUSES.has(status)) return new Response(null, { status, headers })
+  // Bytes, so a raw body without a Content-Type isn't given text/plain
+  return new Response(bodyStr !== null && config.bodyType === "raw" ? new TextEncoder().encode(bodyStr) : bodyStr, {
+    status,
     headers
   })
 }
+
+/**
+ * Check a response's body against a JSON Schema. A non-conformant response is replaced
+ * by a 500 listing the violations, so a broken mock fails loudly instead of misleading clients.
+ */
+export const validateResponse = async (
+  response: Response,
+  schema: JsonSchema
+): Promise<{ readonly response: Response; readonly violations: ReadonlyArray<string> }> => {
+  const bytes = new Uint8Array(await response.arrayBuffer())
+  const text = new TextDecoder().decode(bytes)
+  let violations: ReadonlyArray<string>
+  if ((response.headers.get("content-type") ?? "").includes("json")) {
+    try {
+      violations = validateJsonSchema(schema, JSON.parse(text))
+    } catch {
+      violations = ["$: body is not valid JSON"]
+    }
+  } else {
+    violations = validateJsonSchema(schema, text)
+  }
+  if (violations.length === 0) {
+    const { headers, status } = response
+    return { response: new Response(bytes.byteLength === 0 ? null : bytes, { status, headers }), violations }
+  }
+  const body = JSON.stringify({ error: "Response does not match its schema", violations })
+  return { response: new Response(body, { status: 500, headers: { "content-type": "application/json" } }), violations }
+}
+
+// Guards against routes that include themselves
+const MAX_ROUTE_DEPTH = 5
+
+const readBody = async (response: Response): Promise<unknown> => {
+  const text = await response.text()
+  if (!(response.headers.get("content-type") ?? "").includes("json")) return text
+  try {
+    return JSON.parse(text)
+  } catch {
+    return text
+  }
+}
+
+// The store as a routed response sees it: its reads see the caller's store, and its writes are dropped
+const readOnlyStore = (store: TemplateStore): TemplateStore => ({ get: store.get, set: () => {}, values: store.values })
+
+/**
+ * Template helpers for a request served from `stubs`. `$route(path, method?)` answers an
+ * internal request with the caller's headers and returns the body of the matching stub's
+ * first response, parsed when it is JSON. Delays, faults, callbacks and proxies are skipped,
+ * response cycling is not advanced and the routed response can't write the store, so
+ * composing responses has no side effects.
+ */
+export const makeTemplateHelpers = (
+  stubs: ReadonlyArray<Stub>,
+  headers: Record<string, string>,
+  options: {
+    readonly fixturesDir?: string
+    readonly variables?: Readonly<Record<string, unknown>>
+    readonly store?: TemplateStore
+    readonly now?: () => number
+    readonly stubOrder?: StubOrder
+    readonly eligible?: StubFilter
+  } = {},
+  depth = 0
+): TemplateHelpers => ({
+  ...(options.variables !== undefined ? { variables: options.variables } : {}),
+  ...(options.store !== undefined ? { store: options.store } : {}),
+  ...(options.now !== undefined ? { now: options.now } : {}),
+  route: async (path, method) => {
+    if (depth >= MAX_ROUTE_DEPTH) throw new Error(`$route nested more than ${MAX_ROUTE_DEPTH} levels deep`)
+    const url = new URL(path, "http://localhost")
+    const ctx: RequestContext = {
+      method: method.toUpperCase(),
+      path: url.pathname,
+      headers,
+      query: Object.fromEntries(url.searchParams),
+      queryString: url.search.slice(1),
+      body: undefined
+    }
+    const stub = findMatchingStub(ctx, stubs, options.stubOrder, options.eligible)
+    const config = stub?.responses[0]
+    if (stub === undefined || config === undefined || config.proxy !== undefined) return undefined
+    const store = options.store !== undefined ? { store: readOnlyStore(options.store) } : {}
+    const nested = makeTemplateHelpers(stubs, headers, { ...options, ...store }, depth + 1)
+    return readBody(await buildResponse(config, withPathParams(ctx, stub), nested, options.fixturesDir))
+  }
+})
@@ -0,0 +1,108 @@
+import type { ResponseTransform } from "../schemas/StubSchema"
+
+type Container = Record<string, unknown>
+
+const isContainer = (value: unknown): value is Container => typeof value === "object" && value !== null
+
+// Keys that would reach into, or replace, an object's prototype rather than the object itself
+const PROTOTYPE_KEYS: ReadonlySet<string> = new Set(["__proto__", "constructor", "prototype"])
+
+/**
+ * Walk `segments` from `node` and call `visit` with the parent container and key of
+ * every matched location. With `create`, missing intermediate objects are added.
+ * `__proto__`, `constructor` and `prototype` match nothing.
+ */
+export const visitParents = (
+  node: unknown,
+  segments: ReadonlyArray<string>,
+  create: boolean,
+  visit: (parent: Container, key: string) => void
+): void => {
+  if (!isContainer(node) || segments.length === 0) return
+  const [head, ...rest] = segments as [string, ...Array<string>]
+  const keys = (head === "*" ? Object.keys(node) : [head]).filter((key) => !PROTOTYPE_KEYS.has(key))
+  // Iterate arrays backwards so removals don't shift pending indices
+  if (Array.isArray(node)) keys.reverse()
+  for (const key of keys) {
+    if (rest.length === 0) {
+      visit(node, key)
+      continue
+    }
+    if (create && head !== "*" && !isContainer(node[key])) {
+      node[key] = {}
+    }
+    visitParents(node[key], rest, create, visit)
+  }
+}
+
+const applyOne = (body: unknown, transform: ResponseTransform): void => {
+  const segments = transform.path.split(".").filter((s) => s.length > 0)
+  switch (transform.op) {
+    case "remove":
+      visitParents(body, segments, false, (parent, key) => {
+        if (Array.isArray(parent)) parent.splice(Number(key), 1)
+        else delete parent[key]
+      })
+      break
+    case "mask": {
+      const replacement = transform.with
+      visitParents(body, segments, false, (parent, key) => {
+        if (key in parent && parent[key] !== null && parent[key] !== undefined) parent[key] = replacement
+      })
+      break
+    }
+    case "rename": {
+      const to = transform.to
+      // Moving a key to `__proto__` would replace the object's prototype instead
+      if (PROTOTYPE_KEYS.has(to)) break
+      visitParents(body, segments, false, (parent, key) => {
+        if (Array.isArray(parent) || !(key in parent)) return
+        parent[to] = parent[key]
+        delete parent[key]
+      })
+      break
+    }
+    case "set": {
+      const value = transform.value
+      visitParents(body, segments, true, (parent, key) => {
+        parent[key] = value
+      })
+      break
+    }
+  }
+}
+
+/**
+ * Apply transforms in order to a JSON body. The input is left untouched;
+ * non-object bodies (strings, numbers) are returned as-is.
+ */
+export const applyTransforms = (body: unknown, transforms: ReadonlyArray<ResponseTransform>): unknown => {
+  if (!isContainer(body) || transforms.length === 0) return body
+  const copy = structuredClone(body)
+  for (const transform of transforms) {
+    applyOne(copy, transform)
+  }
+  return copy
+}
+
+/**
+ * Apply transforms to a JSON response (e.g. one returned by a proxy).
+ * Non-JSON responses pass through unchanged.
+ */
+export const transformResponse = async (
+  response: Response,
+  transforms: ReadonlyArray<ResponseTransform>
+): Promise<Response> => {
+  const contentType = response.headers.get("content-type") ?? ""
+  if (transforms.length === 0 || !contentType.includes("json")) return response
+  const text = await response.text()
+  let parsed: unknown
+  try {
+    parsed = JSON.parse(text)
+  } catch {
+    return new Response(text, { status: response.status, headers: response.headers })
+  }
+  const headers = new Headers(response.headers)
+  headers.delete("content-length")
+  return new Response(JSON.stringify(applyTransforms(parsed, transforms)), { status: response.status, headers })
+}
@@ -0,0 +1,71 @@
+import type { ScrubPreset, ScrubRule } from "../schemas/StubSchema"
+import { visitParents } from "./ResponseTransforms"
+
+const PRESET_PATTERNS: Record<ScrubPreset, string> = {
+  email: "[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\\.[A-Za-z]{2,}",
+  creditCard: "\\b(?:\\d[ -]?){12,18}\\d\\b",
+  bearerToken: "(?<=Bearer )[A-Za-z0-9\\-._~+/]+=*",
+  jwt: "eyJ[A-Za-z0-9_-]+\\.[A-Za-z0-9_-]+\\.[A-Za-z0-9_-]+"
+}
+
+const ruleRegex = (rule: ScrubRule): RegExp | undefined => {
+  const source = rule.pattern ?? (rule.preset !== undefined ? PRESET_PATTERNS[rule.preset] : undefined)
+  return source !== undefined ? new RegExp(source, "g") : undefined
+}
+
+// Replace regex matches in every string nested inside `value`
+const redactStrings = (value: unknown, regex: RegExp, replacement: string): unknown => {
+  if (typeof value === "string") return value.replace(regex, replacement)
+  if (Array.isArray(value)) return value.map((item) => redactStrings(item, regex, replacement))
+  if (typeof value === "object" && value !== null) {
+    return Object.fromEntries(
+      Object.entries(value).map(([key, val]) => [key, redactStrings(val, regex, replacement)])
+    )
+  }
+  return value
+}
+
+/**
+ * Redact a recorded body. Returns a new value; the input is not modified.
+ */
+export const scrubBody = (body: unknown, rules: ReadonlyArray<ScrubRule>): unknown => {
+  let result = typeof body === "object" && body !== null ? structuredClone(body) : body
+  for (const rule of rules) {
+    if (rule.header !== undefined) continue
+    const regex = ruleRegex(rule)
+    if (rule.path !== undefined) {
+      const segments = rule.path.replace(/^\$\.?/, "").split(".").filter((s) => s.length > 0)
+      visitParents(result, segments, false, (parent, key) => {
+        if (!(key in parent)) return
+        parent[key] = regex !== undefined ? redactStrings(parent[key], regex, rule.replacement) : rule.replacement
+      })
+    } else if (regex !== undefined) {
+      result = redactStrings(result, regex, rule.replacement)
+    }
+  }
+  return result
+}
+
+/**
+ * Redact recorded response headers. `header` rules replace the whole value (or the
+ * regex matches within it); body-less regex rules apply to every header value.
+ */
+export const scrubHeaders = (
+  headers: Record<string, string>,
+  rules: ReadonlyArray<ScrubRule>
+): Record<string, string> => {
+  const result: Record<string, string> = { ...headers }
+  for (const rule of rules) {
+    if (rule.path !== undefined) continue
+    const regex = ruleRegex(rule)
+    for (const [key, val] of Object.entries(result)) {
+      if (rule.header !== undefined) {
+        if (key.toLowerCase() !== rule.header.toLowerCase()) continue
+        result[key] = regex !== undefined ? val.replace(regex, rule.replacement) : rule.replacement
+      } else if (regex !== undefined) {
+        result[key] = val.replace(regex, rule.replacement)
+      }
+    }
+  }
+  return result
+}
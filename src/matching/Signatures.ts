@@ -0,0 +1,154 @@
+// Checks the credentials SDKs compute per request: HTTP Digest responses (RFC 7616) and
+// AWS Signature Version 4, sent in the Authorization header or a presigned URL's query.
+
+import { createHash, createHmac } from "node:crypto"
+import type { AuthCredentials, DigestCredentials, SigV4Credentials } from "../schemas/StubSchema"
+import type { RequestContext } from "./RequestMatcher"
+
+// `name=value` and `name="quoted, value"` pairs of an auth header, names lowercased
+export const authParams = (header: string): Record<string, string> => {
+  const params: Record<string, string> = {}
+  for (const [, name = "", quoted, token] of header.matchAll(/([\w-]+)\s*=\s*(?:"((?:[^"\\]|\\.)*)"|([^\s,]+))/g)) {
+    params[name.toLowerCase()] = quoted !== undefined ? quoted.replace(/\\(.)/g, "$1") : token ?? ""
+  }
+  return params
+}
+
+const DIGEST_HASHES: Record<string, string> = { "md5": "md5", "sha-256": "sha256", "sha-512-256": "sha512-256" }
+
+const hex = (algorithm: string, data: string | Uint8Array): string =>
+  createHash(algorithm).update(data).digest("hex")
+
+// The request target a Digest `uri` names must be the one requested
+const sameTarget = (uri: string, ctx: RequestContext): boolean => {
+  try {
+    return new URL(uri, "http://imposter").pathname === ctx.path
+  } catch {
+    return false
+  }
+}
+
+const verifyDigest = (ctx: RequestContext, body: Uint8Array, credentials: DigestCredentials): boolean => {
+  const header = ctx.headers["authorization"] ?? ""
+  if (!/^digest\s/i.test(header)) return false
+  const params = authParams(header.slice(6))
+  const { cnonce = "", nc = "", nonce, qop, realm, response, uri, username = "" } = params
+  const algorithm = (params["algorithm"] ?? "MD5").toLowerCase()
+  const hash = DIGEST_HASHES[algorithm.replace(/-sess$/, "")]
+  const password = Object.hasOwn(credentials.users, username) ? credentials.users[username] : undefined
+  if (hash === undefined || password === undefined || nonce === undefined || realm === undefined) return false
+  if (uri === undefined || response === undefined || !sameTarget(uri, ctx)) return false
+  if (credentials.realm !== undefined && realm !== credentials.realm) return false
+  const H = (data: string | Uint8Array) => hex(hash, data)
+  const secret = H(`${username}:${realm}:${password}`)
+  const ha1 = algorithm.endsWith("-sess") ? H(`${secret}:${nonce}:${cnonce}`) : secret
+  const ha2 = qop === "auth-int" ? H(`${ctx.method}:${uri}:${H(body)}`) : H(`${ctx.method}:${uri}`)
+  const expected = qop === "auth" || qop === "auth-int"
+    ? H(`${ha1}:${nonce}:${nc}:${cnonce}:${qop}:${ha2}`)
+    : H(`${ha1}:${nonce}:${ha2}`)
+  return response.toLowerCase() === expected
+}
+
+// RFC 3986 unreserved characters stay, everything else is percent-encoded
+const uriEncode = (s: string): string =>
+  encodeURIComponent(s).replace(/[!'()*]/g, (c) => `%${c.charCodeAt(0).toString(16).toUpperCase()}`)
+
+const hmac = (key: string | Uint8Array, data: string): Buffer => createHmac("sha256", key).update(data).digest()
+
+interface SigV4Parts {
+  readonly credential: string
+  readonly signedHeaders: string
+  readonly signature: string
+  readonly date: string
+  readonly payloadHash: string | undefined
+  // Signed in the query string rather than the Authorization header
+  readonly presigned: boolean
+}
+
+const sigV4Parts = (ctx: RequestContext): SigV4Parts | undefined => {
+  const header = ctx.headers["authorization"] ?? ""
+  if (header.startsWith("AWS4-HMAC-SHA256 ")) {
+    const date = ctx.headers["x-amz-date"] ?? ""
+    const { credential, signature, signedheaders } = authParams(header.slice(17))
+    if (credential === undefined || signedheaders === undefined || signature === undefined) return undefined
+    return {
+      credential,
+      signedHeaders: signedheaders,
+      signature,
+      date,
+      payloadHash: ctx.headers["x-amz-content-sha256"],
+      presigned: false
+    }
+  }
+  const q = ctx.query
+  if (q["X-Amz-Algorithm"] !== "AWS4-HMAC-SHA256") return undefined
+  const { "X-Amz-Credential": credential, "X-Amz-Date": date, "X-Amz-Signature": signature } = q
+  const signedHeaders = q["X-Amz-SignedHeaders"]
+  if (credential === undefined || date === undefined || signature === undefined || signedHeaders === undefined) {
+    return undefined
+  }
+  return { credential, signedHeaders, signature, date, payloadHash: q["X-Amz-Content-Sha256"], presigned: true }
+}
+
+const canonicalQuery = (ctx: RequestContext, presigned: boolean): string =>
+  Object.entries({ ...ctx.query, ...ctx.queryValues })
+    .filter(([key]) => !(presigned && key === "X-Amz-Signature"))
+    .flatMap(([key, values]) =>
+      (typeof values === "string" ? [values] : values).map((value) => [uriEncode(key), uriEncode(value)] as const)
+    )
+    .sort(([ka, va], [kb, vb]) => ka < kb ? -1 : ka > kb ? 1 : va < vb ? -1 : va > vb ? 1 : 0)
+    .map(([key, value]) => `${key}=${value}`)
+    .join("&")
+
+const verifySigV4 = (ctx: RequestContext, body: Uint8Array, credentials: SigV4Credentials): boolean => {
+  const parts = sigV4Parts(ctx)
+  if (parts === undefined) return false
+  const [keyId = "", day = "", region = "", service = "", terminator] = parts.credential.split("/")
+  const secret = Object.hasOwn(credentials.keys, keyId) ? credentials.keys[keyId] : undefined
+  if (secret === undefined || terminator !== "aws4_request" || !parts.date.startsWith(day)) return false
+  if (credentials.region !== undefined && region !== credentials.region) return false
+  if (credentials.service !== undefined && service !== credentials.service) return false
+  const names = parts.signedHeaders.split(";")
+  if (names.some((name) => ctx.headers[name] === undefined)) return false
+  const scope = `${day}/${region}/${service}/aws4_request`
+  const key = [day, region, service, "aws4_request"].reduce<string | Uint8Array>(
+    (k, part) => hmac(k, part),
+    `AWS4${secret}`
+  )
+  const signature = (path: string): string => {
+    const canonicalRequest = [
+      ctx.method,
+      path,
+      canonicalQuery(ctx, parts.presigned),
+      ...names.map((name) => `${name}:${ctx.headers[name]!.trim().replace(/\s+/g, " ")}`),
+      "",
+      parts.signedHeaders,
+      // Presigned S3 URLs can't know the payload in advance
+      parts.payloadHash ?? (parts.presigned && service === "s3" ? "UNSIGNED-PAYLOAD" : hex("sha256", body))
+    ].join("\n")
+    const stringToSign = ["AWS4-HMAC-SHA256", parts.date, scope, hex("sha256", canonicalRequest)].join("\n")
+    return hmac(key, stringToSign).toString("hex")
+  }
+  // S3 signs the path as sent. Other services encode it once more, though some clients
+  // (curl among them) don't, so both forms are accepted.
+  const paths = service === "s3" ? [ctx.path] : [ctx.path.split("/").map(uriEncode).join("/"), ctx.path]
+  return paths.some((path) => signature(path) === parts.signature.toLowerCase())
+}
+
+/**
+ * Whether the request carries a valid Digest response or SigV4 signature for one of
+ * the users or keys. `body` is the body as received, which auth-int and SigV4 hash.
+ * Nonces and timestamps are not checked, so replayed requests are accepted.
+ */
+export const verifyCredentials = (ctx: RequestContext, body: Uint8Array, credentials: AuthCredentials): boolean =>
+  credentials.scheme === "digest" ? verifyDigest(ctx, body, credentials) : verifySigV4(ctx, body, credentials)
+
+/**
+ * A Digest challenge for `WWW-Authenticate`. The nonce is derived from the realm, so it
+ * stays the same across restarts and clients can reuse it.
+ */
+export const digestChallenge = (realm: string, algorithm: string): string => {
+  const nonce = hex("sha256", `imposters:${realm}`).slice(0, 32)
+  const quote = (s: string) => `"${s.replace(/["\\]/g, "\\$&")}"`
+  return `Digest realm=${quote(realm)}, qop="auth, auth-int", algorithm=${algorithm}, nonce="${nonce}"`
+}
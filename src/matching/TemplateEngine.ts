@@ -1,5 +1,6 @@
+import { randomInt, randomUUID } from "node:crypto"
 import { substituteParams } from "../domain/route"
-import { processExpressions } from "./ExpressionEvaluator"
+import { processExpressions, type TemplateHelpers, type TemplateStore } from "./ExpressionEvaluator"
 import type { RequestContext } from "./RequestMatcher"
 
 const flattenObject = (obj: unknown, prefix: string, result: Record<string, string>): void => {
@@ -39,6 +40,14 @@ export const flattenRequestContext = (ctx: RequestContext): Record<string, strin
     result[`request.query.${key}`] = val
   }
 
+  for (const [key, val] of Object.entries(ctx.form ?? {})) {
+    result[`request.form.${key}`] = val
+  }
+
+  for (const [key, val] of Object.entries(ctx.params ?? {})) {
+    result[`request.params.${key}`] = val
+  }
+
   if (ctx.body !== undefined && ctx.body !== null) {
     flattenObject(ctx.body, "request.body", result)
   }
@@ -46,9 +55,259 @@ export const flattenRequestContext = (ctx: RequestContext): Record<string, strin
   return result
 }
 
-export const applyTemplates = async (ctx: RequestContext, data: unknown): Promise<unknown> => {
-  // Step 1: Apply {{key}} substitution
-  const substituted = substituteParams(flattenRequestContext(ctx))(data)
+// While a template renders, the `{{` and `${` in values taken from the request are held as
+// private-use characters, so request data can't add tokens or expressions of its own
+const HELD_TOKEN = "\uE000"
+const HELD_EXPRESSION = "\uE001"
+
+const mapStrings = (data: unknown, f: (text: string) => string): unknown => {
+  if (typeof data === "string") return f(data)
+  if (Array.isArray(data)) return data.map((item) => mapStrings(item, f))
+  if (data !== null && typeof data === "object") {
+    return Object.fromEntries(Object.entries(data as Record<string, unknown>).map(([k, v]) => [k, mapStrings(v, f)]))
+  }
+  return data
+}
+
+const inert = (value: unknown): unknown =>
+  mapStrings(value, (text) => text.replaceAll("{{", HELD_TOKEN).replaceAll("${", HELD_EXPRESSION))
+
+const released = (value: unknown): unknown =>
+  mapStrings(value, (text) => text.replaceAll(HELD_TOKEN, "{{").replaceAll(HELD_EXPRESSION, "${"))
+
+/**
+ * Replaces `token` (a global regex) wherever it occurs in the strings of `data`. `lookup` gets
+ * the token's capture groups and returns `undefined` to leave it as written. A token that is
+ * the whole string is replaced by the value itself, keeping its type.
+ */
+const substituteTokens = (token: RegExp, lookup: (...groups: Array<string>) => unknown) => {
+  const whole = new RegExp(`^(?:${token.source})$`)
+  const groupCount = new RegExp(`${token.source}|`).exec("")!.length - 1
+  const walk = (data: unknown): unknown => {
+    if (typeof data === "string") {
+      const match = whole.exec(data)
+      if (match) {
+        const value = lookup(...match.slice(1))
+        return value === undefined ? data : value
+      }
+      return data.replace(token, (text: string, ...args: Array<string>) => {
+        const value = lookup(...args.slice(0, groupCount))
+        return value === undefined ? text : typeof value === "string" ? value : JSON.stringify(value)
+      })
+    }
+    if (Array.isArray(data)) return data.map(walk)
+    if (data !== null && typeof data === "object") {
+      return Object.fromEntries(Object.entries(data as Record<string, unknown>).map(([k, v]) => [k, walk(v)]))
+    }
+    return data
+  }
+  return walk
+}
+
+// `{{header.X-Request-Id}}` (or `{{request.headers.X-Request-Id}}`) names a header in any case
+const HEADER_TOKEN = /\{\{(?:header|request\.headers)\.([^{}]+)\}\}/g
+
+const substituteHeaders = (headers: Record<string, string>) =>
+  substituteTokens(HEADER_TOKEN, (name) => inert(headers[name!.toLowerCase()]))
+
+// `{{body.user.name}}` reads a field of the parsed request body, or of a form body. A token that
+// is the whole string keeps the field's type, so `"{{body.items}}"` echoes an array as an array.
+const BODY_TOKEN = /\{\{body((?:\.[^{}.]+)*)\}\}/g
+
+const bodyField = (ctx: RequestContext, path: string): unknown => {
+  let value = path === "" || (ctx.body !== null && typeof ctx.body === "object") ? ctx.body : ctx.form
+  for (const key of path.split(".").slice(1)) {
+    if (value === null || typeof value !== "object" || !Object.hasOwn(value, key)) return undefined
+    value = (value as Record<string, unknown>)[key]
+  }
+  return value
+}
+
+const substituteBody = (ctx: RequestContext) =>
+  substituteTokens(BODY_TOKEN, (path) => inert(bodyField(ctx, path!)))
+
+const pad = (n: number, width = 2) => String(n).padStart(width, "0")
+
+/**
+ * `date` in a named layout (RFC3339, the default, RFC3339Millis, RFC1123, unix or
+ * unixMillis) or a pattern of YYYY, MM, DD, HH, mm, ss and SSS, all in UTC.
+ */
+export const formatTime = (date: Date, layout = "RFC3339"): string | number => {
+  switch (layout) {
+    case "RFC3339":
+      return date.toISOString().replace(/\.\d{3}Z$/, "Z")
+    case "RFC3339Millis":
+      return date.toISOString()
+    case "RFC1123":
+      return date.toUTCString()
+    case "unix":
+      return Math.floor(date.getTime() / 1000)
+    case "unixMillis":
+      return date.getTime()
+  }
+  const fields: Record<string, string> = {
+    YYYY: String(date.getUTCFullYear()),
+    MM: pad(date.getUTCMonth() + 1),
+    DD: pad(date.getUTCDate()),
+    HH: pad(date.getUTCHours()),
+    mm: pad(date.getUTCMinutes()),
+    ss: pad(date.getUTCSeconds()),
+    SSS: pad(date.getUTCMilliseconds(), 3)
+  }
+  return layout.replace(/YYYY|MM|DD|HH|mm|ss|SSS/g, (field) => fields[field]!)
+}
+
+// `{{uuid}}`, `{{now "RFC1123"}}` and `{{randomInt 1 100}}`, rendered afresh for every response
+const HELPER_TOKEN = /\{\{\s*(uuid|now|randomInt)((?:\s+(?:"[^"]*"|[^\s"{}]+))*)\s*\}\}/g
+
+const renderHelper = (name: string, rawArgs: string, now: () => number): unknown => {
+  const args = Array.from(rawArgs.matchAll(/"([^"]*)"|([^\s"]+)/g), (m) => m[1] ?? m[2]!)
+  switch (name) {
+    case "uuid":
+      return randomUUID()
+    case "now":
+      return formatTime(new Date(now()), args[0])
+    case "randomInt": {
+      // Both bounds included; bounds that aren't integers, or that crypto's randomInt can't take
+      // (a range over 2^48 - 1, an exclusive end past the safe integers), leave the token as written
+      const [min = NaN, max = NaN] = args.map(Number)
+      const usable = Number.isSafeInteger(min) && Number.isSafeInteger(max) && Number.isSafeInteger(max + 1)
+      if (!usable || min > max || max - min + 1 > 2 ** 48 - 1) {
+        return undefined
+      }
+      return randomInt(min, max + 1)
+    }
+  }
+  return undefined
+}
+
+const substituteHelpers = (now: () => number) =>
+  substituteTokens(HELPER_TOKEN, (name, args) => renderHelper(name!, args!, now))
+
+// `{{vars.tenant}}` reads a shared variable; a token that is the whole string keeps its type
+const VARS_TOKEN = /\{\{vars\.([^{}]+)\}\}/g
+
+const substituteVariables = (variables: Readonly<Record<string, unknown>>) =>
+  substituteTokens(VARS_TOKEN, (name) => Object.hasOwn(variables, name!) ? variables[name!] : undefined)
+
+// `{{store.get "key"}}` reads the imposter's store; `{{store.set "key" value}}` writes it and renders as nothing
+const STORE_TOKEN = /\{\{\s*store\.(get|set)\s+("[^"]*"|[^\s"{}]+)((?:\s+(?:"[^"]*"|[^\s"{}]+))?)\s*\}\}/g
+
+// A quoted argument is a string; others are JSON when they parse as it, like `42` or `true`
+const storeArgument = (arg: string): unknown => {
+  if (arg.startsWith("\"")) return arg.slice(1, -1)
+  try {
+    return JSON.parse(arg)
+  } catch {
+    return arg
+  }
+}
+
+const substituteStore = (store: TemplateStore) =>
+  substituteTokens(STORE_TOKEN, (op, key, value) => {
+    const name = String(storeArgument(key!))
+    // What was stored may have come from a request, so it goes back in held like one
+    if (op === "get") return inert(store.get(name))
+    store.set(name, value!.trim() === "" ? null : released(storeArgument(value!.trim())))
+    return ""
+  })
+
+// Longest array a `$repeat` or `$range` may generate
+export const MAX_REPEAT = 10_000
+
+// Most items all the `$repeat`s and `$range`s of one render may generate together, nested ones included
+export const MAX_REPEAT_ITEMS = 100_000
+
+// `{{index}}` in a repeated item: its position, or its value in a `$range`
+const INDEX_TOKEN = /\{\{index\}\}/g
+
+// Replaces `{{index}}` throughout `data`, as in each copy of a `$repeat`
+export const substituteIndex = (data: unknown, index: number): unknown =>
+  substituteTokens(INDEX_TOKEN, () => index)(data)
+
+const isRepeat = (data: Record<string, unknown>): boolean =>
+  Object.hasOwn(data, "$item") && (Object.hasOwn(data, "$repeat") || Object.hasOwn(data, "$range"))
+
+// The indices a rendered `$repeat` count or `$range` of bounds stands for, or undefined when it isn't one
+const indicesOf = (spec: Record<string, unknown>, rendered: unknown): ReadonlyArray<number> | undefined => {
+  const [from = NaN, to = NaN] = Object.hasOwn(spec, "$repeat")
+    ? [0, Number(rendered) - 1]
+    : Array.isArray(rendered) && rendered.length === 2
+    ? rendered.map(Number)
+    : []
+  if (!Number.isSafeInteger(from) || !Number.isSafeInteger(to) || to - from + 1 > MAX_REPEAT) return undefined
+  return Array.from({ length: Math.max(0, to - from + 1) }, (_, i) => from + i)
+}
+
+const containsRepeat = (data: unknown): boolean => {
+  if (Array.isArray(data)) return data.some(containsRepeat)
+  if (data === null || typeof data !== "object") return false
+  const record = data as Record<string, unknown>
+  return isRepeat(record) || Object.values(record).some(containsRepeat)
+}
+
+/**
+ * Expands `{"$repeat": n, "$item": ...}` into an array of `n` items, and
+ * `{"$range": [from, to], "$item": ...}` into one item per whole number from `from` to `to`.
+ * Counts and bounds are rendered first, so they can come from the request. Each item has
+ * `{{index}}` replaced with its own index (the innermost, when repeats are nested) and is
+ * rendered separately, so helpers like `{{uuid}}` differ between items. Nested counts multiply,
+ * so `budget` counts the items left for the whole render, and running out fails it.
+ */
+const expandRepeats = async (
+  data: unknown,
+  render: (data: unknown) => Promise<unknown>,
+  budget: { left: number },
+  index?: number
+): Promise<unknown> => {
+  if (typeof data === "string") {
+    return index === undefined ? data : substituteIndex(data, index)
+  }
+  if (Array.isArray(data)) return Promise.all(data.map((item) => expandRepeats(item, render, budget, index)))
+  if (data === null || typeof data !== "object") return data
+  const record = data as Record<string, unknown>
+  if (isRepeat(record)) {
+    const spec = await expandRepeats(record.$repeat ?? record.$range, render, budget, index)
+    const indices = indicesOf(record, await render(spec))
+    // Left as written when the count or bounds don't render to whole numbers
+    if (indices !== undefined) {
+      budget.left -= indices.length
+      if (budget.left < 0) throw new Error(`$repeat and $range make more than ${MAX_REPEAT_ITEMS} items in all`)
+      return Promise.all(indices.map((i) => expandRepeats(record.$item, render, budget, i)))
+    }
+  }
+  const entries = await Promise.all(
+    Object.entries(record).map(async ([key, value]) =>
+      [key, await expandRepeats(value, render, budget, index)] as const
+    )
+  )
+  return Object.fromEntries(entries)
+}
+
+const render = async (ctx: RequestContext, data: unknown, helpers: TemplateHelpers): Promise<unknown> => {
+  // Step 1: Apply {{key}} substitution, then header names in any case, helpers, body fields and variables
+  const request = Object.fromEntries(
+    Object.entries(flattenRequestContext(ctx)).map(([key, value]) => [key, inert(value) as string])
+  )
+  const params = substituteParams(request)(data)
+  const substituted = substituteVariables(helpers.variables ?? {})(substituteBody(ctx)(
+    substituteHelpers(helpers.now ?? Date.now)(substituteHeaders(ctx.headers)(params))
+  ))
+  // Last, so the value a `{{store.set}}` writes can come from the request
+  const stored = helpers.store !== undefined ? substituteStore(helpers.store)(substituted) : substituted
   // Step 2: Apply ${expr} JSONata evaluation
-  return processExpressions(ctx, substituted)
+  return released(await processExpressions(ctx, stored, helpers))
 }
+
+export const applyTemplates = async (
+  ctx: RequestContext,
+  data: unknown,
+  helpers: TemplateHelpers = {}
+): Promise<unknown> =>
+  render(
+    ctx,
+    containsRepeat(data)
+      ? await expandRepeats(data, (spec) => render(ctx, spec, helpers), { left: MAX_REPEAT_ITEMS })
+      : data,
+    helpers
+  )
@@ -0,0 +1,140 @@
+import type { RequestLogEntry, TimesExpectation, VerifyRequest, VerifyResponse } from "../schemas/RequestLogSchema"
+import type {
+  ScenarioStep,
+  ScenarioTransition,
+  VerifyScenarioRequest,
+  VerifyScenarioResponse
+} from "../schemas/ScenarioSchema"
+import { evaluatePredicates, formFields, isFormContentType, queryFields, type RequestContext } from "./RequestMatcher"
+
+const requestContext = (entry: RequestLogEntry): RequestContext => {
+  const { body, headers } = entry.request
+  const isForm = typeof body === "string" && isFormContentType(headers["content-type"] ?? "")
+  return {
+    method: entry.request.method,
+    path: entry.request.path,
+    headers,
+    query: entry.request.query,
+    // Every value of a repeated parameter, which `query` keeps only the last of
+    ...(entry.request.queryString !== undefined
+      ? { queryString: entry.request.queryString, ...queryFields(entry.request.queryString) }
+      : {}),
+    body,
+    ...(entry.request.bodySize !== undefined ? { bodySize: entry.request.bodySize } : {}),
+    ...(isForm ? formFields(body) : {})
+  }
+}
+
+const parseBody = (body: string | undefined): unknown => {
+  if (body === undefined) return undefined
+  try {
+    return JSON.parse(body)
+  } catch {
+    return body
+  }
+}
+
+// The served response viewed as a request context, so response predicates reuse the request matchers
+const responseContext = (entry: RequestLogEntry): RequestContext => ({
+  method: entry.request.method,
+  path: entry.request.path,
+  headers: Object.fromEntries(Object.entries(entry.response.headers).map(([k, v]) => [k.toLowerCase(), v])),
+  query: entry.request.query,
+  body: parseBody(entry.response.body)
+})
+
+const matchesResponse = (entry: RequestLogEntry, expected: VerifyRequest["response"]): boolean =>
+  expected === undefined || (
+    (expected.status === undefined || entry.response.status === expected.status) &&
+    (expected.stubId === undefined || entry.response.matchedStubId === expected.stubId) &&
+    (expected.proxied === undefined || entry.response.proxied === expected.proxied) &&
+    evaluatePredicates(responseContext(entry), expected.predicates)
+  )
+
+const describeTimes = (times: TimesExpectation | undefined): string => {
+  if (times?.exactly !== undefined) return `exactly ${times.exactly}`
+  const bounds = [
+    times?.atLeast !== undefined ? `at least ${times.atLeast}` : undefined,
+    times?.atMost !== undefined ? `at most ${times.atMost}` : undefined
+  ].filter((b) => b !== undefined)
+  return bounds.length > 0 ? bounds.join(" and ") : "at least 1"
+}
+
+const satisfiesTimes = (count: number, times: TimesExpectation | undefined): boolean => {
+  if (times?.exactly !== undefined) return count === times.exactly
+  if (times?.atLeast === undefined && times?.atMost === undefined) return count >= 1
+  return (times?.atLeast === undefined || count >= times.atLeast) &&
+    (times?.atMost === undefined || count <= times.atMost)
+}
+
+const tally = (values: ReadonlyArray<string>): Record<string, number> => {
+  const counts: Record<string, number> = {}
+  for (const value of values) counts[value] = (counts[value] ?? 0) + 1
+  return counts
+}
+
+/**
+ * Check the journal against an expectation: how many exchanges matched the request
+ * predicates and were answered as expected. The status and stub tallies cover every
+ * request that matched, so a failure shows what was served instead.
+ */
+export const verifyEntries = (entries: ReadonlyArray<RequestLogEntry>, spec: VerifyRequest): VerifyResponse => {
+  const requested = entries.filter((entry) => evaluatePredicates(requestContext(entry), spec.request))
+  const matched = requested.filter((entry) => matchesResponse(entry, spec.response))
+  const passed = satisfiesTimes(matched.length, spec.times)
+  const expected = describeTimes(spec.times)
+  const subject = spec.response !== undefined ? "exchanges with the expected response" : "matching requests"
+
+  return {
+    passed,
+    requestMatches: requested.length,
+    count: matched.length,
+    message: passed
+      ? `Verified ${matched.length} ${subject}`
+      : `Expected ${expected} ${subject}, found ${matched.length} (${requested.length} matching requests)`,
+    statuses: tally(requested.map((entry) => String(entry.response.status))),
+    stubs: tally(requested.map((entry) => entry.response.matchedStubId ?? "none")),
+    entryIds: matched.map((entry) => entry.id)
+  }
+}
+
+const describeStep = (step: ScenarioStep): string =>
+  step.from !== undefined ? `${step.from} -> ${step.to}` : `-> ${step.to}`
+
+const takesStep = (transition: ScenarioTransition, step: ScenarioStep): boolean =>
+  transition.to === step.to && (step.from === undefined || transition.from === step.from)
+
+/**
+ * Check a scenario's history against an expectation: the state it is in now, and the
+ * transitions it should have taken in order. Other transitions may come in between.
+ */
+export const verifyScenario = (
+  history: ReadonlyArray<ScenarioTransition>,
+  state: string,
+  spec: VerifyScenarioRequest
+): VerifyScenarioResponse => {
+  const transitions = history.filter((transition) => transition.scenario === spec.name)
+  const result = (passed: boolean, message: string): VerifyScenarioResponse => ({
+    passed,
+    message,
+    state,
+    transitions
+  })
+
+  if (spec.state !== undefined && spec.state !== state) {
+    return result(false, `Expected scenario ${spec.name} in ${spec.state}, found ${state}`)
+  }
+  let next = 0
+  for (const step of spec.sequence) {
+    const at = transitions.findIndex((transition, i) => i >= next && takesStep(transition, step))
+    if (at === -1) {
+      const taken = transitions.map((transition) => `${transition.from} -> ${transition.to}`).join(", ")
+      return result(
+        false,
+        `Expected scenario ${spec.name} to move ${describeStep(step)}, found ${taken === "" ? "no transitions" : taken}`
+      )
+    }
+    next = at + 1
+  }
+  return result(true, `Scenario ${spec.name} verified`)
+}
@@ -0,0 +1,22 @@
+import { digestChallenge } from "../matching/Signatures"
+import type { DigestPreset } from "../schemas/PresetSchema"
+import type { CreateStubRequest } from "../schemas/StubSchema"
+import { pathPredicates } from "./common"
+
+/**
+ * One stub in front of the protected routes: any request without a valid Digest
+ * response for one of the users gets a 401 challenge. Valid ones reach the
+ * imposter's other stubs.
+ */
+export const digestStubs = (config: DigestPreset): ReadonlyArray<CreateStubRequest> => [{
+  predicates: [...pathPredicates(config.path), {
+    field: "authorization",
+    operator: "verifies",
+    value: { scheme: "digest", users: config.users, realm: config.realm },
+    caseSensitive: true,
+    negate: true
+  }],
+  responses: [{ status: 401, headers: { "www-authenticate": digestChallenge(config.realm, config.algorithm) } }],
+  responseMode: "sequential",
+  priority: config.priority
+}]
@@ -0,0 +1,97 @@
+// Feature flags live in the shared `flags` variable, so they can be changed at /flags while
+// imposters run. The stubs render the SDK payloads from it with JSONata on every request.
+
+import * as Effect from "effect/Effect"
+import type { FeatureFlags, FlagsPreset } from "../schemas/PresetSchema"
+import type { CreateStubRequest, Predicate, ResponseConfig } from "../schemas/StubSchema"
+import type { TemplateVariables } from "../schemas/VariablesSchema"
+import { Variables } from "../services/Variables"
+
+export const FLAGS_VARIABLE = "flags"
+
+// The flags held in the shared variables
+export const flagsOf = (variables: TemplateVariables): FeatureFlags => {
+  const flags = variables[FLAGS_VARIABLE]
+  return flags !== null && typeof flags === "object" && !Array.isArray(flags) ? flags as FeatureFlags : {}
+}
+
+/**
+ * Replaces the flags with what `f` makes of the current ones; a null value removes a flag.
+ * Responds with the new flags.
+ */
+export const updateFlags = (f: (current: FeatureFlags) => FeatureFlags) =>
+  Effect.gen(function*() {
+    const variables = yield* Variables
+    const updated = yield* variables.update((current) => {
+      const flags = Object.entries(f(flagsOf(current))).filter(([, value]) => value !== null)
+      return { ...current, [FLAGS_VARIABLE]: Object.fromEntries(flags) }
+    })
+    return flagsOf(updated)
+  })
+
+// JSONata calling `fn` with each flag's value and name
+const eachFlag = (fn: string) => `$each($vars.${FLAGS_VARIABLE}, function($v, $k) { ${fn} })`
+
+// JSONata merging `entry`, an object constructor over `$k` and `$v`, for every flag
+const flagMap = (entry: string) => `$merge($append([{}], ${eachFlag(entry)}))`
+
+// A LaunchDarkly flag that is on and serves its one variation to everyone
+const LAUNCHDARKLY_FLAGS = flagMap(
+  `{ $k: { "key": $k, "version": 1, "on": true, "variations": [$v], "fallthrough": { "variation": 0 }, ` +
+    `"offVariation": 0, "targets": [], "contextTargets": [], "rules": [], "prerequisites": [], "salt": $k, ` +
+    `"trackEvents": false, "deleted": false } }`
+)
+
+// Client-side SDKs get values already evaluated
+const LAUNCHDARKLY_EVALUATED = flagMap(
+  `{ $k: { "value": $v, "variation": 0, "version": 1, "flagVersion": 1, "trackEvents": false } }`
+)
+
+const UNLEASH_PAYLOAD = `{ "type": $type($v) = "string" ? "string" : $type($v) = "number" ? "number" : "json", ` +
+  `"value": $type($v) = "string" ? $v : $string($v) }`
+
+// Any value but false enables an Unleash toggle; values that aren't booleans become its variant's payload
+const UNLEASH_FEATURE = `{ "name": $k, "type": "release", "enabled": $type($v) != "boolean" or $v, "stale": false, ` +
+  `"impressionData": false, "strategies": [{ "name": "default", "parameters": {} }], ` +
+  `"variants": $type($v) = "boolean" ? [] : [{ "name": $k, "weight": 1000, "weightType": "variable", ` +
+  `"stickiness": "default", "payload": ${UNLEASH_PAYLOAD} }] }`
+
+// The frontend API lists enabled toggles only
+const UNLEASH_TOGGLE = `$type($v) != "boolean" or $v ? { "name": $k, "enabled": true, "impressionData": false, ` +
+  `"variant": $type($v) = "boolean" ? { "name": "disabled", "enabled": false } ` +
+  `: { "name": $k, "enabled": true, "payload": ${UNLEASH_PAYLOAD} } }`
+
+const route = (method: string, path: string): ReadonlyArray<Predicate> => [
+  { field: "method", operator: "matches", value: `^(${method})$`, caseSensitive: true },
+  { field: "path", operator: "template", value: path, caseSensitive: true }
+]
+
+const json = (expression: string): ResponseConfig => ({ status: 200, body: `\${${expression}}` })
+
+// The current flags as a `put` event, sent again on the open stream every time they change
+const stream = (expression: string): ResponseConfig => ({
+  status: 200,
+  type: "sse",
+  events: [{ event: "put", data: `\${${expression}}` }],
+  live: true
+})
+
+const stub = (method: string, path: string, response: ResponseConfig): CreateStubRequest => ({
+  predicates: route(method, path),
+  responses: [response],
+  responseMode: "sequential"
+})
+
+/**
+ * Stubs for the endpoints SDKs call at startup: LaunchDarkly's server-side polling and
+ * streaming, its client-side evaluation and streaming, and Unleash's client and frontend APIs.
+ */
+export const flagStubs = (_config: FlagsPreset): ReadonlyArray<CreateStubRequest> => [
+  stub("GET", "/sdk/latest-all", json(`{ "flags": ${LAUNCHDARKLY_FLAGS}, "segments": {} }`)),
+  stub("GET", "/sdk/latest-flags", json(LAUNCHDARKLY_FLAGS)),
+  stub("GET", "/all", stream(`{ "path": "/", "data": { "flags": ${LAUNCHDARKLY_FLAGS}, "segments": {} } }`)),
+  stub("GET|REPORT", "/sdk/evalx/{rest...}", json(LAUNCHDARKLY_EVALUATED)),
+  stub("GET|REPORT", "/eval/{rest...}", stream(LAUNCHDARKLY_EVALUATED)),
+  stub("GET", "/api/client/features", json(`{ "version": 2, "features": [${eachFlag(UNLEASH_FEATURE)}] }`)),
+  stub("GET|POST", "/api/frontend", json(`{ "toggles": [${eachFlag(UNLEASH_TOGGLE)}] }`))
+]
@@ -0,0 +1,222 @@
+// An OpenAI-style chat completions API, so apps built on an LLM can run in CI without paying for
+// tokens. Replies are canned: the first completion whose pattern finds something in the request
+// body answers, else the preset's `reply`. Requests with `"stream": true` get the reply as
+// chat.completion.chunk events, a word at a time.
+
+import type { LlmError, LlmPreset } from "../schemas/PresetSchema"
+import type {
+  CreateStubRequest,
+  PredicateExpression,
+  ResponseConfig,
+  ResponseRateLimit,
+  SseEvent
+} from "../schemas/StubSchema"
+
+interface ApiError {
+  readonly status: number
+  readonly message: string
+  readonly type: string
+  readonly param?: string
+  readonly code: string | null
+}
+
+const API_ERRORS: Readonly<Record<LlmError, ApiError>> = {
+  rate_limit: {
+    status: 429,
+    message: "Rate limit reached for requests. Please try again in 1s.",
+    type: "requests",
+    code: "rate_limit_exceeded"
+  },
+  insufficient_quota: {
+    status: 429,
+    message: "You exceeded your current quota, please check your plan and billing details.",
+    type: "insufficient_quota",
+    code: "insufficient_quota"
+  },
+  invalid_api_key: {
+    status: 401,
+    message: "Incorrect API key provided.",
+    type: "invalid_request_error",
+    code: "invalid_api_key"
+  },
+  context_length: {
+    status: 400,
+    message: "This model's maximum context length is 128000 tokens. Please reduce the length of the messages.",
+    type: "invalid_request_error",
+    param: "messages",
+    code: "context_length_exceeded"
+  },
+  server_error: {
+    status: 500,
+    message: "The server had an error while processing your request. Sorry about that!",
+    type: "server_error",
+    code: null
+  },
+  overloaded: {
+    status: 503,
+    message: "The engine is currently overloaded, please try again later.",
+    type: "server_error",
+    code: null
+  }
+}
+
+export const ERROR_HEADER = "x-imposters-error"
+
+const COMPLETIONS_PATH = "/v1/chat/completions"
+
+// A response reads the clock once, so every event of a stream has the same id and time
+const COMPLETION_ID = `chatcmpl-{{now "unixMillis"}}`
+const CREATED = `{{now "unix"}}`
+
+// Roughly four characters to a token
+const PROMPT_TOKENS = `$ceil($length($string(request.body.messages)) / 4)`
+const tokens = (text: string) => Math.ceil(text.length / 4)
+
+const route = (method: string, path: string): Array<PredicateExpression> => [
+  { field: "method", operator: "equals", value: method, caseSensitive: true },
+  { field: "path", operator: "template", value: path, caseSensitive: true }
+]
+
+const STREAMING: PredicateExpression = {
+  field: "body",
+  operator: "matchesJson",
+  value: { stream: true },
+  caseSensitive: true
+}
+
+const stub = (
+  predicates: ReadonlyArray<PredicateExpression>,
+  response: ResponseConfig,
+  priority?: number
+): CreateStubRequest => ({
+  predicates,
+  responses: [response],
+  responseMode: "sequential",
+  ...(priority !== undefined ? { priority } : {})
+})
+
+// The model the request asked for, else the preset's
+const modelOf = (config: LlmPreset) => `\${request.body.model ? request.body.model : ${JSON.stringify(config.model)}}`
+
+const rateLimitHeaders = (config: LlmPreset, remaining = config.requestsPerMinute - 1) => ({
+  "x-ratelimit-limit-requests": String(config.requestsPerMinute),
+  "x-ratelimit-remaining-requests": String(remaining)
+})
+
+const errorAnswer = (error: LlmError, config: LlmPreset) => {
+  const { code, message, param, status, type } = API_ERRORS[error]
+  return {
+    status,
+    ...(error === "rate_limit" ? { headers: { "retry-after": "1", ...rateLimitHeaders(config, 0) } } : {}),
+    body: { error: { message, type, param: param ?? null, code } }
+  }
+}
+
+const errorResponse = (error: LlmError, config: LlmPreset): ResponseConfig => ({
+  ...errorAnswer(error, config),
+  bodyType: "json"
+})
+
+// Completions of the imposter share a window a minute long, and past `requestsPerMinute` fail as `rate_limit`
+const requestLimit = (config: LlmPreset): ResponseRateLimit => ({
+  name: "llm",
+  requests: config.requestsPerMinute,
+  windowMs: 60000,
+  ...errorAnswer("rate_limit", config)
+})
+
+// The reply in pieces of one word and the whitespace after it
+const chunks = (reply: string): ReadonlyArray<string> => reply.match(/\s*\S+\s*/g) ?? [reply]
+
+const completion = (config: LlmPreset, reply: string): ResponseConfig => {
+  const model = modelOf(config)
+  return {
+    status: 200,
+    headers: rateLimitHeaders(config),
+    rateLimit: requestLimit(config),
+    body: {
+      id: COMPLETION_ID,
+      object: "chat.completion",
+      created: CREATED,
+      model,
+      choices: [{
+        index: 0,
+        message: { role: "assistant", content: reply, refusal: null },
+        logprobs: null,
+        finish_reason: "stop"
+      }],
+      usage: {
+        prompt_tokens: `\${${PROMPT_TOKENS}}`,
+        completion_tokens: tokens(reply),
+        total_tokens: `\${${PROMPT_TOKENS} + ${tokens(reply)}}`
+      }
+    },
+    bodyType: "json"
+  }
+}
+
+const streamedCompletion = (config: LlmPreset, reply: string): ResponseConfig => {
+  const model = modelOf(config)
+  const chunk = (delta: Record<string, unknown>, finishReason: string | null, delay: number): SseEvent => ({
+    data: {
+      id: COMPLETION_ID,
+      object: "chat.completion.chunk",
+      created: CREATED,
+      model,
+      choices: [{ index: 0, delta, logprobs: null, finish_reason: finishReason }]
+    },
+    delay
+  })
+  return {
+    status: 200,
+    headers: rateLimitHeaders(config),
+    rateLimit: requestLimit(config),
+    type: "sse",
+    events: [
+      chunk({ role: "assistant", content: "" }, null, 0),
+      ...chunks(reply).map((content) => chunk({ content }, null, config.chunkDelay)),
+      chunk({}, "stop", config.chunkDelay),
+      { data: "[DONE]" }
+    ]
+  }
+}
+
+// Streaming and plain stubs answering with `reply`, for requests `predicates` also match
+const replying = (
+  config: LlmPreset,
+  predicates: ReadonlyArray<PredicateExpression>,
+  reply: string
+): ReadonlyArray<CreateStubRequest> => [
+  stub([...route("POST", COMPLETIONS_PATH), ...predicates, STREAMING], streamedCompletion(config, reply)),
+  stub([...route("POST", COMPLETIONS_PATH), ...predicates], completion(config, reply))
+]
+
+const MODEL = (id: string) => ({ id, object: "model", created: 1700000000, owned_by: "imposters" })
+
+/**
+ * Stubs for `POST /v1/chat/completions` and `/v1/models`. Failures come first, at a higher
+ * priority, then the completions in the order given, then the default reply.
+ */
+export const llmStubs = (config: LlmPreset): ReadonlyArray<CreateStubRequest> => [
+  ...Object.keys(API_ERRORS).map((error) =>
+    stub(
+      [...route("POST", COMPLETIONS_PATH), {
+        field: "headers",
+        operator: "equals",
+        value: { [ERROR_HEADER]: error },
+        caseSensitive: true
+      }],
+      errorResponse(error as LlmError, config),
+      100
+    )
+  ),
+  ...(config.error !== undefined
+    ? [stub(route("POST", COMPLETIONS_PATH), errorResponse(config.error, config), 100)]
+    : []),
+  ...config.completions.flatMap(({ match, reply }) =>
+    replying(config, [{ field: "body", operator: "matches", value: match, caseSensitive: false }], reply)
+  ),
+  ...replying(config, [], config.reply),
+  stub(route("GET", "/v1/models"), { status: 200, body: { object: "list", data: [MODEL(config.model)] } }),
+  stub(route("GET", "/v1/models/{id}"), { status: 200, body: MODEL("{{request.params.id}}") })
+]
@@ -0,0 +1,163 @@
+// The instance metadata services cloud SDKs' credential chains ask at 169.254.169.254: EC2's
+// IMDS (with IMDSv2 session tokens) and GCE's metadata server. Credentials are derived from the
+// clock, so they stay the same for `credentialTtl` seconds and rotate when they expire.
+
+import type { MetadataPreset } from "../schemas/PresetSchema"
+import type { CreateStubRequest, PredicateExpression, ResponseConfig } from "../schemas/StubSchema"
+
+const path = (value: string): PredicateExpression => ({
+  field: "path",
+  operator: value.includes("{") ? "template" : "equals",
+  value,
+  caseSensitive: true
+})
+
+const method = (value: string): PredicateExpression => ({
+  field: "method",
+  operator: "equals",
+  value,
+  caseSensitive: true
+})
+
+const stub = (
+  predicates: ReadonlyArray<PredicateExpression>,
+  response: ResponseConfig,
+  priority?: number
+): CreateStubRequest => ({
+  predicates,
+  responses: [response],
+  responseMode: "sequential",
+  ...(priority !== undefined ? { priority } : {})
+})
+
+// JSONata binding `$start` and `$end`, the bounds in ms of the credential period we are in, and `$id`, naming it
+const inWindow = (ttl: number, body: string) =>
+  `\${($ttl := ${ttl * 1000}; $window := $floor($millis() / $ttl); $start := $window * $ttl; ` +
+  `$end := $start + $ttl; $id := $uppercase($formatBase($window, 36)); ${body})}`
+
+const AWS_TIME = `"[Y0001]-[M01]-[D01]T[H01]:[m01]:[s01]Z"`
+
+// Requests needing a session token get a 401 without one; the token itself isn't checked
+const awsStubs = (config: MetadataPreset): ReadonlyArray<CreateStubRequest> => {
+  const account = config.account ?? "123456789012"
+  const region = config.region ?? "us-east-1"
+  const role = config.identity ?? "imposters"
+  const instanceId = config.instanceId ?? "i-0123456789abcdef0"
+  const text = (p: string, body: string) =>
+    stub([method("GET"), path(p)], { status: 200, headers: { "content-type": "text/plain" }, body })
+  const json = (p: string, body: unknown) => stub([method("GET"), path(p)], { status: 200, body, bodyType: "json" })
+
+  return [
+    stub([method("PUT"), path("/latest/api/token"), {
+      field: "headers",
+      operator: "exists",
+      value: { "x-aws-ec2-metadata-token-ttl-seconds": true },
+      caseSensitive: true
+    }], {
+      status: 200,
+      headers: {
+        "content-type": "text/plain",
+        "x-aws-ec2-metadata-token-ttl-seconds": "{{header.x-aws-ec2-metadata-token-ttl-seconds}}"
+      },
+      body: "AQAEAimposters-{{uuid}}"
+    }),
+    stub([method("PUT"), path("/latest/api/token")], { status: 400 }),
+    ...(config.tokenRequired
+      ? [stub([method("GET"), path("/latest/{rest...}"), {
+        field: "headers",
+        operator: "exists",
+        value: { "x-aws-ec2-metadata-token": true },
+        caseSensitive: true,
+        negate: true
+      }], { status: 401 }, 100)]
+      : []),
+    text("/latest/meta-data/instance-id", instanceId),
+    text("/latest/meta-data/instance-type", "t3.micro"),
+    text("/latest/meta-data/ami-id", "ami-0123456789abcdef0"),
+    text("/latest/meta-data/local-ipv4", "10.0.0.10"),
+    text("/latest/meta-data/hostname", `ip-10-0-0-10.${region}.compute.internal`),
+    text("/latest/meta-data/placement/region", region),
+    text("/latest/meta-data/placement/availability-zone", `${region}a`),
+    text("/latest/meta-data/iam/security-credentials/", role),
+    text("/latest/meta-data/iam/security-credentials", role),
+    json("/latest/meta-data/iam/info", {
+      Code: "Success",
+      LastUpdated: "2025-01-01T00:00:00Z",
+      InstanceProfileArn: `arn:aws:iam::${account}:instance-profile/${role}`,
+      InstanceProfileId: "AIPAIMPOSTERSEXAMPLE"
+    }),
+    json(
+      `/latest/meta-data/iam/security-credentials/${role}`,
+      inWindow(
+        config.credentialTtl,
+        `{ "Code": "Success", "LastUpdated": $fromMillis($start, ${AWS_TIME}), "Type": "AWS-HMAC", ` +
+          `"AccessKeyId": "ASIAIMPOSTERS" & $id, "SecretAccessKey": "imposters-secret-" & $id, ` +
+          `"Token": "imposters-session-" & $id, "Expiration": $fromMillis($end, ${AWS_TIME}) }`
+      )
+    ),
+    json("/latest/dynamic/instance-identity/document", {
+      accountId: account,
+      architecture: "x86_64",
+      availabilityZone: `${region}a`,
+      imageId: "ami-0123456789abcdef0",
+      instanceId,
+      instanceType: "t3.micro",
+      pendingTime: "2025-01-01T00:00:00Z",
+      privateIp: "10.0.0.10",
+      region,
+      version: "2017-09-30"
+    })
+  ]
+}
+
+const GCP_SCOPE = "https://www.googleapis.com/auth/cloud-platform"
+
+// Requests without `Metadata-Flavor: Google` get a 403; every answer carries the header
+const gcpStubs = (config: MetadataPreset): ReadonlyArray<CreateStubRequest> => {
+  const project = config.account ?? "imposters"
+  const zone = config.region ?? "us-central1-a"
+  const email = config.identity ?? `default@${project}.iam.gserviceaccount.com`
+  const instanceId = config.instanceId ?? "1234567890123456789"
+  const flavor = { "metadata-flavor": "Google" }
+  const get = (p: string, contentType: string, body: unknown, bodyType?: "text" | "json") =>
+    stub([method("GET"), path(p)], {
+      status: 200,
+      headers: { ...flavor, "content-type": contentType },
+      body,
+      ...(bodyType !== undefined ? { bodyType } : {})
+    })
+  const text = (p: string, body: string) => get(p, "application/text", body, "text")
+  const json = (p: string, body: unknown) => get(p, "application/json", body, "json")
+  const accounts = "/computeMetadata/v1/instance/service-accounts"
+
+  return [
+    stub([{ field: "headers", operator: "equals", value: flavor, caseSensitive: true, negate: true }], {
+      status: 403,
+      headers: { ...flavor, "content-type": "text/html" },
+      body: "Missing required header: Metadata-Flavor"
+    }, 100),
+    text("/", "computeMetadata/\n"),
+    text("/computeMetadata/v1/instance", "hostname\nid\nservice-accounts/\nzone\n"),
+    text("/computeMetadata/v1/instance/", "hostname\nid\nservice-accounts/\nzone\n"),
+    text("/computeMetadata/v1/project/project-id", project),
+    text("/computeMetadata/v1/project/numeric-project-id", "123456789012"),
+    text("/computeMetadata/v1/instance/id", instanceId),
+    text("/computeMetadata/v1/instance/zone", `projects/123456789012/zones/${zone}`),
+    text("/computeMetadata/v1/instance/hostname", `imposters.${zone}.c.${project}.internal`),
+    text(`${accounts}/`, `default/\n${email}/\n`),
+    json(`${accounts}/{account}/`, { aliases: ["default"], email, scopes: [GCP_SCOPE] }),
+    text(`${accounts}/{account}/email`, email),
+    text(`${accounts}/{account}/scopes`, `${GCP_SCOPE}\n`),
+    json(
+      `${accounts}/{account}/token`,
+      inWindow(
+        config.credentialTtl,
+        `{ "access_token": "ya29.imposters-" & $id, "expires_in": $round(($end - $millis()) / 1000), ` +
+          `"token_type": "Bearer" }`
+      )
+    )
+  ]
+}
+
+export const metadataStubs = (config: MetadataPreset): ReadonlyArray<CreateStubRequest> =>
+  config.provider === "aws" ? awsStubs(config) : gcpStubs(config)
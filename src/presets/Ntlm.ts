@@ -0,0 +1,106 @@
+// NTLM over HTTP (MS-NTHT): the client sends a NEGOTIATE message, the server answers 401 with a
+// CHALLENGE, and the client retries with an AUTHENTICATE message. Messages are recognised by the
+// base64 of their "NTLMSSP\0" signature and type, so the handshake needs no server-side state.
+
+import type { NtlmPreset } from "../schemas/PresetSchema"
+import type { CreateStubRequest } from "../schemas/StubSchema"
+import { pathPredicates } from "./common"
+
+// base64("NTLMSSP\0" + type 1)
+export const NTLM_NEGOTIATE_PREFIX = "TlRMTVNTUAABAAAA"
+
+// Unicode, request target, NTLM, always sign, domain target, extended session security,
+// target info, 128- and 56-bit keys
+const CHALLENGE_FLAGS = 0xa0898205
+
+const SCHEME_NAMES = { negotiate: "Negotiate", ntlm: "NTLM" } as const
+
+const utf16 = (s: string): Uint8Array => {
+  const bytes = new Uint8Array(s.length * 2)
+  for (let i = 0; i < s.length; i++) new DataView(bytes.buffer).setUint16(i * 2, s.charCodeAt(i), true)
+  return bytes
+}
+
+// MsvAvNbDomainName, MsvAvNbComputerName, MsvAvDnsDomainName, MsvAvDnsComputerName, MsvAvEOL
+const targetInfo = (domain: string): Uint8Array => {
+  const pairs: ReadonlyArray<readonly [number, Uint8Array]> = [
+    [2, utf16(domain.toUpperCase())],
+    [1, utf16("IMPOSTERS")],
+    [4, utf16(`${domain.toLowerCase()}.local`)],
+    [3, utf16(`imposters.${domain.toLowerCase()}.local`)],
+    [0, new Uint8Array(0)]
+  ]
+  const out = new Uint8Array(pairs.reduce((n, [, value]) => n + 4 + value.byteLength, 0))
+  const view = new DataView(out.buffer)
+  let offset = 0
+  for (const [id, value] of pairs) {
+    view.setUint16(offset, id, true)
+    view.setUint16(offset + 2, value.byteLength, true)
+    out.set(value, offset + 4)
+    offset += 4 + value.byteLength
+  }
+  return out
+}
+
+/**
+ * A base64 CHALLENGE message (type 2) naming `domain`, with a fixed server challenge.
+ * AUTHENTICATE responses are accepted without checking them against any password.
+ */
+export const ntlmChallenge = (domain: string): string => {
+  const target = utf16(domain.toUpperCase())
+  const info = targetInfo(domain)
+  const header = 48
+  const message = new Uint8Array(header + target.byteLength + info.byteLength)
+  const view = new DataView(message.buffer)
+  message.set(new TextEncoder().encode("NTLMSSP\0"), 0)
+  view.setUint32(8, 2, true)
+  view.setUint16(12, target.byteLength, true)
+  view.setUint16(14, target.byteLength, true)
+  view.setUint32(16, header, true)
+  view.setUint32(20, CHALLENGE_FLAGS, true)
+  message.set([0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef], 24)
+  view.setUint16(40, info.byteLength, true)
+  view.setUint16(42, info.byteLength, true)
+  view.setUint32(44, header + target.byteLength, true)
+  message.set(target, header)
+  message.set(info, header + target.byteLength)
+  return Buffer.from(message).toString("base64")
+}
+
+/**
+ * Two stubs in front of the protected routes: requests without credentials for one of
+ * the schemes get a 401 offering them, and NEGOTIATE messages get a 401 with a challenge.
+ * AUTHENTICATE messages (and Kerberos tokens under Negotiate) match neither, so they
+ * reach the imposter's other stubs.
+ */
+export const ntlmStubs = (config: NtlmPreset): ReadonlyArray<CreateStubRequest> => {
+  const path = pathPredicates(config.path)
+  const names = config.schemes.map((scheme) => SCHEME_NAMES[scheme])
+  const unauthorized = (headers: Record<string, string>) => ({ status: 401, headers })
+  const challenge = ntlmChallenge(config.domain)
+  return [
+    {
+      predicates: [...path, {
+        field: "headers",
+        operator: "matches",
+        value: { authorization: `^(${names.join("|")}) ` },
+        caseSensitive: false,
+        negate: true
+      }],
+      responses: [unauthorized({ "www-authenticate": names.join(", ") })],
+      responseMode: "sequential",
+      priority: config.priority
+    },
+    ...names.map((name): CreateStubRequest => ({
+      predicates: [...path, {
+        field: "headers",
+        operator: "startsWith",
+        value: { authorization: `${name} ${NTLM_NEGOTIATE_PREFIX}` },
+        caseSensitive: true
+      }],
+      responses: [unauthorized({ "www-authenticate": `${name} ${challenge}` })],
+      responseMode: "sequential",
+      priority: config.priority
+    }))
+  ]
+}
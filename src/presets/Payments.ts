@@ -0,0 +1,170 @@
+// A card payment gateway in the style of Stripe. Test card numbers decide the outcome, 3-D Secure
+// cards need a challenge completed first, and payments made with `"capture": false` are captured
+// later, asynchronously. The gateway keeps no state: a payment's ID comes from the request, so
+// the steps of a flow and the webhooks they send can be tied together.
+
+import type { PaymentsPreset } from "../schemas/PresetSchema"
+import type { CallbackConfig, CreateStubRequest, PredicateExpression, ResponseConfig } from "../schemas/StubSchema"
+
+interface Decline {
+  readonly code: string
+  readonly declineCode?: string
+  readonly message: string
+}
+
+// Test cards answered with a 402 card error
+export const DECLINED_CARDS: Readonly<Record<string, Decline>> = {
+  "4000000000000002": { code: "card_declined", declineCode: "generic_decline", message: "Your card was declined." },
+  "4000000000009995": {
+    code: "card_declined",
+    declineCode: "insufficient_funds",
+    message: "Your card has insufficient funds."
+  },
+  "4000000000000069": { code: "expired_card", message: "Your card has expired." },
+  "4000000000000127": { code: "incorrect_cvc", message: "Your card's security code is incorrect." },
+  "4000000000000119": {
+    code: "processing_error",
+    message: "An error occurred while processing your card. Try again in a little bit."
+  }
+}
+
+// The test card that needs a 3-D Secure challenge
+export const THREE_D_SECURE_CARD = "4000000000003220"
+
+// JSONata: `pay_` and the request's reference, else its Idempotency-Key, else something random
+const PAYMENT_ID = `"pay_" & (request.body.reference ? request.body.reference : ` +
+  `request.headers."idempotency-key" ? request.headers."idempotency-key" : $formatBase($floor($random() * 1e12), 36))`
+
+// JSONata for a payment being created from the request's body; `fields` can refer to its `$id`
+const newPayment = (status: string, fields = "") =>
+  `($id := ${PAYMENT_ID}; { "id": $id, "object": "payment", "status": "${status}", "amount": request.body.amount, ` +
+  `"currency": request.body.currency, "reference": request.body.reference, ` +
+  `"last4": $substring(request.body.card.number, -4)${fields} })`
+
+// JSONata for the payment a path's `{id}` names
+const existingPayment = (status: string, fields = "") =>
+  `{ "id": request.params.id, "object": "payment", "status": "${status}"${fields} }`
+
+const cardError = (decline: Decline) =>
+  `{ "error": { "type": "card_error", "code": "${decline.code}", ` +
+  (decline.declineCode !== undefined ? `"decline_code": "${decline.declineCode}", ` : "") +
+  `"message": "${decline.message}", "payment": ${newPayment("failed")} } }`
+
+const expression = (jsonata: string) => `\${${jsonata}}`
+
+const route = (method: string, path: string): Array<PredicateExpression> => [
+  { field: "method", operator: "equals", value: method, caseSensitive: true },
+  { field: "path", operator: "template", value: path, caseSensitive: true }
+]
+
+const withBody = (body: unknown): PredicateExpression => ({
+  field: "body",
+  operator: "matchesJson",
+  value: body,
+  caseSensitive: true
+})
+
+const withCard = (number: string) => withBody({ card: { number } })
+
+// A webhook event carrying `payment`, sent `delay` ms after the response
+const webhook = (config: PaymentsPreset, type: string, payment: string, delay?: number): Array<CallbackConfig> =>
+  config.webhookUrl === undefined ? [] : [{
+    url: config.webhookUrl,
+    method: "POST",
+    headers: { "content-type": "application/json" },
+    body: expression(
+      `{ "id": "evt_" & $formatBase($floor($random() * 1e12), 36), "type": "${type}", ` +
+        `"created": $floor($millis() / 1000), "data": ${payment} }`
+    ),
+    ...(delay !== undefined ? { delay } : {}),
+    timeout: 5000
+  }]
+
+const respond = (status: number, body: string, callbacks: Array<CallbackConfig>): ResponseConfig => ({
+  status,
+  body: expression(body),
+  ...(callbacks.length > 0 ? { callbacks } : {})
+})
+
+const stub = (predicates: Array<PredicateExpression>, response: ResponseConfig): CreateStubRequest => ({
+  predicates,
+  responses: [response],
+  responseMode: "sequential"
+})
+
+// The challenge page's "fail" button, or the same as JSON
+const AUTHENTICATION_FAILED: PredicateExpression = {
+  or: [
+    { field: "form", operator: "equals", value: { result: "failed" }, caseSensitive: true },
+    withBody({ result: "failed" })
+  ]
+}
+
+const CHALLENGE_PAGE = `<!doctype html>
+<html>
+<head><title>3-D Secure</title></head>
+<body>
+<h1>Authenticate payment {{request.params.id}}</h1>
+<form method="post" action="/3ds/{{request.params.id}}">
+<button name="result" value="authenticated">Complete authentication</button>
+<button name="result" value="failed">Fail authentication</button>
+</form>
+</body>
+</html>
+`
+
+/**
+ * Stubs for `POST /payments`, its 3-D Secure challenge at `/3ds/{id}` and
+ * `POST /payments/{id}/capture`. Declined and challenged cards are listed before the
+ * catch-all payment stubs, so they are tried first.
+ */
+export const paymentStubs = (config: PaymentsPreset): ReadonlyArray<CreateStubRequest> => [
+  ...Object.entries(DECLINED_CARDS).map(([number, decline]) =>
+    stub(
+      [...route("POST", "/payments"), withCard(number)],
+      respond(402, cardError(decline), webhook(config, "payment.failed", newPayment("failed")))
+    )
+  ),
+  stub(
+    [...route("POST", "/payments"), withCard(THREE_D_SECURE_CARD)],
+    respond(
+      200,
+      newPayment("requires_action", `, "next_action": { "type": "three_d_secure", "url": "/3ds/" & $id }`),
+      []
+    )
+  ),
+  stub(
+    [...route("POST", "/payments"), withBody({ capture: false })],
+    respond(
+      201,
+      newPayment("requires_capture"),
+      webhook(config, "payment.authorized", newPayment("requires_capture"))
+    )
+  ),
+  stub(
+    route("POST", "/payments"),
+    respond(201, newPayment("succeeded"), webhook(config, "payment.succeeded", newPayment("succeeded")))
+  ),
+  stub(route("GET", "/3ds/{id}"), { status: 200, body: CHALLENGE_PAGE, bodyType: "html" }),
+  stub(
+    [...route("POST", "/3ds/{id}"), AUTHENTICATION_FAILED],
+    respond(
+      200,
+      existingPayment("failed", `, "error": { "code": "authentication_failed" }`),
+      webhook(config, "payment.failed", existingPayment("failed"))
+    )
+  ),
+  stub(
+    route("POST", "/3ds/{id}"),
+    respond(200, existingPayment("succeeded"), webhook(config, "payment.succeeded", existingPayment("succeeded")))
+  ),
+  // Capture completes later: the response says it is under way, the webhook that it is done
+  stub(
+    route("POST", "/payments/{id}/capture"),
+    respond(
+      202,
+      existingPayment("processing"),
+      webhook(config, "payment.captured", existingPayment("succeeded"), config.captureDelay)
+    )
+  )
+]
@@ -0,0 +1,41 @@
+import * as Effect from "effect/Effect"
+import type { PresetConfig } from "../schemas/PresetSchema"
+import type { CreateStubRequest } from "../schemas/StubSchema"
+import type { Variables } from "../services/Variables"
+import { digestStubs } from "./Digest"
+import { flagStubs, updateFlags } from "./Flags"
+import { llmStubs } from "./Llm"
+import { metadataStubs } from "./Metadata"
+import { ntlmStubs } from "./Ntlm"
+import { paymentStubs } from "./Payments"
+import { resourceStubs } from "./Resource"
+import { sigV4Stubs } from "./SigV4"
+
+/**
+ * The stubs a preset stands for. Options were validated when the preset was decoded,
+ * so expansion cannot fail.
+ */
+export const expandPreset = (config: PresetConfig): ReadonlyArray<CreateStubRequest> => {
+  switch (config.preset) {
+    case "ntlm":
+      return ntlmStubs(config)
+    case "digest":
+      return digestStubs(config)
+    case "sigv4":
+      return sigV4Stubs(config)
+    case "flags":
+      return flagStubs(config)
+    case "payments":
+      return paymentStubs(config)
+    case "metadata":
+      return metadataStubs(config)
+    case "llm":
+      return llmStubs(config)
+    case "resource":
+      return resourceStubs(config)
+  }
+}
+
+// State a preset's stubs read, set up when it is applied next to the stubs
+export const seedPreset = (config: PresetConfig): Effect.Effect<void, never, Variables> =>
+  config.preset === "flags" ? Effect.asVoid(updateFlags((current) => ({ ...current, ...config.flags }))) : Effect.void
@@ -0,0 +1,19 @@
+// A REST backend in one stub: its response answers every request under the resource's path
+// from an in-memory collection, so there's no stub to write per method and id.
+
+import type { ResourcePreset } from "../schemas/PresetSchema"
+import type { CreateStubRequest } from "../schemas/StubSchema"
+
+const escapeRegex = (s: string): string => s.replace(/[.*+?^${}()|[\]\\]/g, "\\$&")
+
+// The collection and its items: `/todos` and `/todos/{id}`, each with or without a trailing slash
+export const resourceStubs = ({ preset: _, ...resource }: ResourcePreset): ReadonlyArray<CreateStubRequest> => [{
+  predicates: [{
+    field: "path",
+    operator: "matches",
+    value: `^${escapeRegex(resource.path.replace(/\/+$/, ""))}(/[^/]+)?/?$`,
+    caseSensitive: true
+  }],
+  responses: [{ status: 200, resource }],
+  responseMode: "sequential"
+}]
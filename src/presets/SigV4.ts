@@ -0,0 +1,62 @@
+import type { SigV4Preset } from "../schemas/PresetSchema"
+import type { CreateStubRequest, ResponseConfig } from "../schemas/StubSchema"
+import { pathPredicates } from "./common"
+
+// The 403 an AWS service answers with, as JSON services and S3 phrase it
+const awsError = (s3: boolean, code: string, s3Code: string, message: string): ResponseConfig =>
+  s3
+    ? {
+      status: 403,
+      headers: { "content-type": "application/xml" },
+      body: `<?xml version="1.0" encoding="UTF-8"?>\n<Error><Code>${s3Code}</Code><Message>${message}</Message></Error>`
+    }
+    : {
+      status: 403,
+      headers: { "content-type": "application/json", "x-amzn-errortype": code },
+      body: { __type: code, message }
+    }
+
+/**
+ * Two stubs in front of the protected routes: unsigned requests get AWS's "missing
+ * authentication token" error and requests signed wrongly (or with an unknown key) its
+ * signature mismatch. Correctly signed requests reach the imposter's other stubs.
+ */
+export const sigV4Stubs = (config: SigV4Preset): ReadonlyArray<CreateStubRequest> => {
+  const path = pathPredicates(config.path)
+  const s3 = config.service === "s3"
+  const value = {
+    scheme: "sigv4",
+    keys: config.keys,
+    ...(config.region !== undefined ? { region: config.region } : {}),
+    ...(config.service !== undefined ? { service: config.service } : {})
+  }
+  return [
+    {
+      predicates: [...path, {
+        field: "authorization",
+        operator: "exists",
+        value: undefined,
+        caseSensitive: true,
+        negate: true
+      }],
+      responses: [
+        awsError(s3, "MissingAuthenticationTokenException", "AccessDenied", "Missing Authentication Token")
+      ],
+      responseMode: "sequential",
+      priority: config.priority
+    },
+    {
+      predicates: [...path, { field: "authorization", operator: "verifies", value, caseSensitive: true, negate: true }],
+      responses: [
+        awsError(
+          s3,
+          "InvalidSignatureException",
+          "SignatureDoesNotMatch",
+          "The request signature we calculated does not match the signature you provided."
+        )
+      ],
+      responseMode: "sequential",
+      priority: config.priority
+    }
+  ]
+}
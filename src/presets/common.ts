@@ -0,0 +1,5 @@
+import type { Predicate } from "../schemas/StubSchema"
+
+// Predicates limiting a preset's stubs to its `path` template, if it has one
+export const pathPredicates = (path: string | undefined): ReadonlyArray<Predicate> =>
+  path !== undefined ? [{ field: "path", operator: "template", value: path, caseSensitive: true }] : []
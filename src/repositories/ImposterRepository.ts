@@ -1,6 +1,7 @@
-import { Context, Data, Effect, HashMap, Layer, Ref } from "effect"
+import { Context, Data, DateTime, Effect, HashMap, Layer, Ref, SynchronizedRef } from "effect"
+import { type BodyPool, makeBodyPool } from "../domain/bodies"
 import type { ImposterConfig } from "../domain/imposter"
-import { ImposterNotFoundError } from "../domain/imposter"
+import { ImposterExistsError, ImposterNotFoundError } from "../domain/imposter"
 import type { Stub } from "../schemas/StubSchema"
 
 export class StubNotFoundError extends Data.TaggedError("StubNotFoundError")<{
@@ -8,13 +9,21 @@ export class StubNotFoundError extends Data.TaggedError("StubNotFoundError")<{
   readonly stubId: string
 }> {}
 
+export class StubExistsError extends Data.TaggedError("StubExistsError")<{
+  readonly imposterId: string
+  readonly stubId: string
+}> {}
+
 export interface ImposterRecord {
   readonly config: ImposterConfig
   readonly stubs: ReadonlyArray<Stub>
+  // When each stub ID was added; editing a stub keeps it
+  readonly stubsAddedAt: ReadonlyMap<string, DateTime.Utc>
 }
 
 export interface ImposterRepositoryShape {
-  readonly create: (config: ImposterConfig) => Effect.Effect<ImposterRecord>
+  // IDs are unique: adding an imposter or stub under a taken ID fails
+  readonly create: (config: ImposterConfig) => Effect.Effect<ImposterRecord, ImposterExistsError>
   readonly get: (id: string) => Effect.Effect<ImposterRecord, ImposterNotFoundError>
   readonly getAll: Effect.Effect<ReadonlyArray<ImposterRecord>>
   readonly update: (
@@ -22,7 +31,7 @@ export interface ImposterRepositoryShape {
     fn: (r: ImposterRecord) => ImposterRecord
   ) => Effect.Effect<ImposterRecord, ImposterNotFoundError>
   readonly remove: (id: string) => Effect.Effect<ImposterRecord, ImposterNotFoundError>
-  readonly addStub: (imposterId: string, stub: Stub) => Effect.Effect<Stub, ImposterNotFoundError>
+  readonly addStub: (imposterId: string, stub: Stub) => Effect.Effect<Stub, ImposterNotFoundError | StubExistsError>
   readonly getStubs: (imposterId: string) => Effect.Effect<ReadonlyArray<Stub>, ImposterNotFoundError>
   readonly updateStub: (
     imposterId: string,
@@ -33,6 +42,148 @@ export interface ImposterRepositoryShape {
     imposterId: string,
     stubId: string
   ) => Effect.Effect<Stub, ImposterNotFoundError | StubNotFoundError>
+  /**
+   * Runs `body` against a draft of the store that replaces it in one step when `body`
+   * succeeds, and is dropped when it fails. Other readers see the store as it was until
+   * then, and other writes wait for it; `body` must not call the repository itself.
+   */
+  readonly transaction: <A, E, R>(
+    body: (tx: ImposterTransaction) => Effect.Effect<A, E, R>
+  ) => Effect.Effect<A, E, R>
+}
+
+// The repository's operations, applied to the draft of a transaction
+export type ImposterTransaction = Omit<ImposterRepositoryShape, "transaction">
+
+type Store = HashMap.HashMap<string, ImposterRecord>
+type ModifyRecord<A, E> = readonly [Effect.Effect<A, E>, Store]
+type RecordResult = ModifyRecord<ImposterRecord, ImposterNotFoundError>
+type StubResult = ModifyRecord<Stub, ImposterNotFoundError | StubExistsError>
+type StubOrNotFound = ModifyRecord<Stub, ImposterNotFoundError | StubNotFoundError>
+
+// Stubs are stored with their large bodies swapped for `pool`'s copies, so equal bodies are held once
+const operations = (storeRef: Ref.Ref<Store>, pool: BodyPool): ImposterTransaction => {
+  // Keeps the times of IDs still there and stamps new ones `now`
+  const addedAt = (
+    previous: ReadonlyMap<string, DateTime.Utc>,
+    stubs: ReadonlyArray<Stub>,
+    now: DateTime.Utc
+  ): ReadonlyMap<string, DateTime.Utc> => new Map(stubs.map((stub) => [stub.id, previous.get(stub.id) ?? now]))
+
+  // Ref.modify, given the time from the Clock to stamp stubs the change adds
+  const modifyAt = <A>(f: (store: Store, now: DateTime.Utc) => readonly [A, Store]): Effect.Effect<A> =>
+    Effect.flatMap(DateTime.now, (now) => Ref.modify(storeRef, (store) => f(store, now)))
+
+  const getRecord = (id: string): Effect.Effect<ImposterRecord, ImposterNotFoundError> =>
+    Ref.get(storeRef).pipe(
+      Effect.flatMap((store) => {
+        const record = HashMap.get(store, id)
+        return record._tag === "Some"
+          ? Effect.succeed(record.value)
+          : Effect.fail(new ImposterNotFoundError({ id }))
+      })
+    )
+
+  const create = (config: ImposterConfig): Effect.Effect<ImposterRecord, ImposterExistsError> => {
+    const record: ImposterRecord = { config, stubs: [], stubsAddedAt: new Map() }
+    return Ref.modify(
+      storeRef,
+      (store): ModifyRecord<ImposterRecord, ImposterExistsError> =>
+        HashMap.has(store, config.id)
+          ? [Effect.fail(new ImposterExistsError({ id: config.id })), store]
+          : [Effect.succeed(record), HashMap.set(store, config.id, record)]
+    ).pipe(Effect.flatten)
+  }
+
+  const get = (id: string) => getRecord(id)
+
+  const getAll: Effect.Effect<ReadonlyArray<ImposterRecord>> = Ref.get(storeRef).pipe(
+    Effect.map((store) => Array.from(HashMap.values(store)))
+  )
+
+  const update = (id: string, fn: (r: ImposterRecord) => ImposterRecord) =>
+    modifyAt((store, now): RecordResult => {
+      const existing = HashMap.get(store, id)
+      if (existing._tag === "None") {
+        return [Effect.fail(new ImposterNotFoundError({ id })), store]
+      }
+      const result = fn(existing.value)
+      const unchanged = new Set(existing.value.stubs)
+      const updated: ImposterRecord = {
+        ...result,
+        stubs: result.stubs.map((stub) => unchanged.has(stub) ? stub : pool.internStub(stub)),
+        stubsAddedAt: addedAt(existing.value.stubsAddedAt, result.stubs, now)
+      }
+      return [Effect.succeed(updated), HashMap.set(store, id, updated)]
+    }).pipe(Effect.flatten)
+
+  const remove = (id: string) =>
+    Ref.modify(storeRef, (store): RecordResult => {
+      const existing = HashMap.get(store, id)
+      if (existing._tag === "None") {
+        return [Effect.fail(new ImposterNotFoundError({ id })), store]
+      }
+      return [Effect.succeed(existing.value), HashMap.remove(store, id)]
+    }).pipe(Effect.flatten)
+
+  const addStub = (imposterId: string, added: Stub) =>
+    modifyAt((store, now): StubResult => {
+      const stub = pool.internStub(added)
+      const existing = HashMap.get(store, imposterId)
+      if (existing._tag === "None") {
+        return [Effect.fail(new ImposterNotFoundError({ id: imposterId })), store]
+      }
+      if (existing.value.stubs.some((s) => s.id === stub.id)) {
+        return [Effect.fail(new StubExistsError({ imposterId, stubId: stub.id })), store]
+      }
+      const stubs = [...existing.value.stubs, stub]
+      const updated: ImposterRecord = {
+        ...existing.value,
+        stubs,
+        stubsAddedAt: addedAt(existing.value.stubsAddedAt, stubs, now)
+      }
+      return [Effect.succeed(stub), HashMap.set(store, imposterId, updated)]
+    }).pipe(Effect.flatten)
+
+  const getStubs = (imposterId: string) => getRecord(imposterId).pipe(Effect.map((r) => r.stubs))
+
+  const updateStub = (imposterId: string, stubId: string, fn: (s: Stub) => Stub) =>
+    Ref.modify(storeRef, (store): StubOrNotFound => {
+      const existing = HashMap.get(store, imposterId)
+      if (existing._tag === "None") {
+        return [Effect.fail(new ImposterNotFoundError({ id: imposterId })), store]
+      }
+      const stubIndex = existing.value.stubs.findIndex((s) => s.id === stubId)
+      if (stubIndex === -1) {
+        return [Effect.fail(new StubNotFoundError({ imposterId, stubId })), store]
+      }
+      const updatedStub = pool.internStub(fn(existing.value.stubs[stubIndex]!))
+      const newStubs = [...existing.value.stubs]
+      newStubs[stubIndex] = updatedStub
+      const updated: ImposterRecord = { ...existing.value, stubs: newStubs }
+      return [Effect.succeed(updatedStub), HashMap.set(store, imposterId, updated)]
+    }).pipe(Effect.flatten)
+
+  const removeStub = (imposterId: string, stubId: string) =>
+    modifyAt((store, now): StubOrNotFound => {
+      const existing = HashMap.get(store, imposterId)
+      if (existing._tag === "None") {
+        return [Effect.fail(new ImposterNotFoundError({ id: imposterId })), store]
+      }
+      const stub = existing.value.stubs.find((s) => s.id === stubId)
+      if (!stub) {
+        return [Effect.fail(new StubNotFoundError({ imposterId, stubId })), store]
+      }
+      const stubs = existing.value.stubs.filter((s) => s.id !== stubId)
+      const updated: ImposterRecord = {
+        ...existing.value,
+        stubs,
+        stubsAddedAt: addedAt(existing.value.stubsAddedAt, stubs, now)
+      }
+      return [Effect.succeed(stub), HashMap.set(store, imposterId, updated)]
+    }).pipe(Effect.flatten)
+
+  return { create, get, getAll, update, remove, addStub, getStubs, updateStub, removeStub }
 }
 
 export class ImposterRepository extends Context.Tag("ImposterRepository")<
@@ -43,103 +194,18 @@ export class ImposterRepository extends Context.Tag("ImposterRepository")<
 export const ImposterRepositoryLive = Layer.effect(
   ImposterRepository,
   Effect.gen(function*() {
-    const storeRef = yield* Ref.make(HashMap.empty<string, ImposterRecord>())
-
-    const getRecord = (id: string): Effect.Effect<ImposterRecord, ImposterNotFoundError> =>
-      Ref.get(storeRef).pipe(
-        Effect.flatMap((store) => {
-          const record = HashMap.get(store, id)
-          return record._tag === "Some"
-            ? Effect.succeed(record.value)
-            : Effect.fail(new ImposterNotFoundError({ id }))
-        })
-      )
-
-    type Store = HashMap.HashMap<string, ImposterRecord>
-    type ModifyRecord<A, E> = readonly [Effect.Effect<A, E>, Store]
-    type RecordResult = ModifyRecord<ImposterRecord, ImposterNotFoundError>
-    type StubResult = ModifyRecord<Stub, ImposterNotFoundError>
-    type StubOrNotFound = ModifyRecord<Stub, ImposterNotFoundError | StubNotFoundError>
-
-    const create = (config: ImposterConfig): Effect.Effect<ImposterRecord> => {
-      const record: ImposterRecord = { config, stubs: [] }
-      return Ref.modify(
-        storeRef,
-        (store): ModifyRecord<ImposterRecord, never> => [Effect.succeed(record), HashMap.set(store, config.id, record)]
-      ).pipe(Effect.flatten)
-    }
-
-    const get = (id: string) => getRecord(id)
-
-    const getAll: Effect.Effect<ReadonlyArray<ImposterRecord>> = Ref.get(storeRef).pipe(
-      Effect.map((store) => Array.from(HashMap.values(store)))
-    )
-
-    const update = (id: string, fn: (r: ImposterRecord) => ImposterRecord) =>
-      Ref.modify(storeRef, (store): RecordResult => {
-        const existing = HashMap.get(store, id)
-        if (existing._tag === "None") {
-          return [Effect.fail(new ImposterNotFoundError({ id })), store]
-        }
-        const updated = fn(existing.value)
-        return [Effect.succeed(updated), HashMap.set(store, id, updated)]
-      }).pipe(Effect.flatten)
-
-    const remove = (id: string) =>
-      Ref.modify(storeRef, (store): RecordResult => {
-        const existing = HashMap.get(store, id)
-        if (existing._tag === "None") {
-          return [Effect.fail(new ImposterNotFoundError({ id })), store]
-        }
-        return [Effect.succeed(existing.value), HashMap.remove(store, id)]
-      }).pipe(Effect.flatten)
-
-    const addStub = (imposterId: string, stub: Stub) =>
-      Ref.modify(storeRef, (store): StubResult => {
-        const existing = HashMap.get(store, imposterId)
-        if (existing._tag === "None") {
-          return [Effect.fail(new ImposterNotFoundError({ id: imposterId })), store]
-        }
-        const updated: ImposterRecord = { ...existing.value, stubs: [...existing.value.stubs, stub] }
-        return [Effect.succeed(stub), HashMap.set(store, imposterId, updated)]
-      }).pipe(Effect.flatten)
-
-    const getStubs = (imposterId: string) => getRecord(imposterId).pipe(Effect.map((r) => r.stubs))
-
-    const updateStub = (imposterId: string, stubId: string, fn: (s: Stub) => Stub) =>
-      Ref.modify(storeRef, (store): StubOrNotFound => {
-        const existing = HashMap.get(store, imposterId)
-        if (existing._tag === "None") {
-          return [Effect.fail(new ImposterNotFoundError({ id: imposterId })), store]
-        }
-        const stubIndex = existing.value.stubs.findIndex((s) => s.id === stubId)
-        if (stubIndex === -1) {
-          return [Effect.fail(new StubNotFoundError({ imposterId, stubId })), store]
-        }
-        const updatedStub = fn(existing.value.stubs[stubIndex]!)
-        const newStubs = [...existing.value.stubs]
-        newStubs[stubIndex] = updatedStub
-        const updated: ImposterRecord = { ...existing.value, stubs: newStubs }
-        return [Effect.succeed(updatedStub), HashMap.set(store, imposterId, updated)]
-      }).pipe(Effect.flatten)
-
-    const removeStub = (imposterId: string, stubId: string) =>
-      Ref.modify(storeRef, (store): StubOrNotFound => {
-        const existing = HashMap.get(store, imposterId)
-        if (existing._tag === "None") {
-          return [Effect.fail(new ImposterNotFoundError({ id: imposterId })), store]
-        }
-        const stub = existing.value.stubs.find((s) => s.id === stubId)
-        if (!stub) {
-          return [Effect.fail(new StubNotFoundError({ imposterId, stubId })), store]
-        }
-        const updated: ImposterRecord = {
-          ...existing.value,
-          stubs: existing.value.stubs.filter((s) => s.id !== stubId)
-        }
-        return [Effect.succeed(stub), HashMap.set(store, imposterId, updated)]
-      }).pipe(Effect.flatten)
-
-    return { create, get, getAll, update, remove, addStub, getStubs, updateStub, removeStub }
+    // Writes, and whole transactions, take the ref's lock one at a time
+    const storeRef = yield* SynchronizedRef.make(HashMap.empty<string, ImposterRecord>())
+    const pool = makeBodyPool()
+
+    const transaction = <A, E, R>(body: (tx: ImposterTransaction) => Effect.Effect<A, E, R>) =>
+      SynchronizedRef.modifyEffect(storeRef, (store) =>
+        Effect.gen(function*() {
+          const draft = yield* Ref.make(store)
+          const result = yield* body(operations(draft, pool))
+          return [result, yield* Ref.get(draft)] as const
+        }))
+
+    return { ...operations(storeRef, pool), transaction }
   })
 )
@@ -0,0 +1,33 @@
+import * as Schema from "effect/Schema"
+import { NonEmptyString } from "./common"
+
+// The request as it was sent, after templates were rendered
+export const CallbackRequest = Schema.Struct({
+  url: Schema.String,
+  method: Schema.String,
+  headers: Schema.Record({ key: Schema.String, value: Schema.String }),
+  body: Schema.optional(Schema.String)
+})
+export type CallbackRequest = Schema.Schema.Type<typeof CallbackRequest>
+
+// A callback that failed every attempt - GET /callbacks/failed
+export const FailedCallback = Schema.Struct({
+  id: NonEmptyString,
+  imposterId: Schema.String,
+  stubId: Schema.optional(Schema.String),
+  request: CallbackRequest,
+  attempts: Schema.Number.pipe(Schema.int(), Schema.positive()),
+  lastStatus: Schema.optional(Schema.Number),
+  lastError: Schema.String,
+  failedAt: Schema.DateTimeUtc
+})
+export type FailedCallback = Schema.Schema.Type<typeof FailedCallback>
+
+// Result of re-driving a failed callback - POST /callbacks/failed/:id/retry
+export const CallbackDelivery = Schema.Struct({
+  delivered: Schema.Boolean,
+  attempts: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  status: Schema.optional(Schema.Number),
+  error: Schema.optional(Schema.String)
+})
+export type CallbackDelivery = Schema.Schema.Type<typeof CallbackDelivery>
@@ -0,0 +1,26 @@
+import * as Schema from "effect/Schema"
+import { LatencyDistribution } from "./StubSchema"
+
+// Failures and latency layered over matched requests without editing stubs - GET/PUT/DELETE /chaos
+export const ChaosConfig = Schema.Struct({
+  // Share of matched requests answered with `errorStatus` instead of their stub's response
+  errorRate: Schema.optionalWith(Schema.Number.pipe(Schema.between(0, 1)), { default: () => 0 }),
+  errorStatus: Schema.optionalWith(Schema.Number.pipe(Schema.int(), Schema.between(400, 599)), {
+    default: () => 503
+  }),
+  // Added to every matched request, before its stub's own delay
+  extraLatencyMs: Schema.optionalWith(Schema.Number.pipe(Schema.int(), Schema.between(0, 60000)), {
+    default: () => 0
+  }),
+  // Drawn afresh for every matched request and added after `extraLatencyMs`
+  latency: Schema.optional(LatencyDistribution),
+  // Imposters it applies to; all of them when absent
+  imposters: Schema.optional(Schema.Array(Schema.String))
+})
+export type ChaosConfig = Schema.Schema.Type<typeof ChaosConfig>
+
+export const ChaosResponse = Schema.Struct({
+  enabled: Schema.Boolean,
+  chaos: Schema.optional(ChaosConfig)
+})
+export type ChaosResponse = Schema.Schema.Type<typeof ChaosResponse>
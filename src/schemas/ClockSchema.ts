@@ -0,0 +1,18 @@
+import * as Schema from "effect/Schema"
+
+// An imposter's clock, and whether it was set or follows the wall clock - GET /imposters/{id}/clock
+export const ClockState = Schema.Struct({
+  now: Schema.DateTimeUtc,
+  set: Schema.Boolean
+})
+export type ClockState = Schema.Schema.Type<typeof ClockState>
+
+// Stops the imposter's clock at `now` - PUT /imposters/{id}/clock
+export const SetClockRequest = Schema.Struct({
+  now: Schema.DateTimeUtc
+})
+
+// Moves the imposter's clock on by `ms` - POST /imposters/{id}/clock/advance
+export const AdvanceClockRequest = Schema.Struct({
+  ms: Schema.Number.pipe(Schema.int(), Schema.between(0, 366 * 24 * 3_600_000))
+})
@@ -1,15 +1,42 @@
+import * as JSONSchema from "effect/JSONSchema"
 import * as Schema from "effect/Schema"
+import { missingBodies } from "../domain/bodies"
 import { NonEmptyString, PortNumber } from "./common"
-import { CreateStubRequest, ProxyConfig } from "./StubSchema"
+import { CorsRule } from "./CorsSchema"
+import { HttpParserMode, ProfileName, StubIdMode, StubOrder, TlsConfig } from "./ImposterSchema"
+import { PresetConfig } from "./PresetSchema"
+import { Profiles } from "./ProfileSchema"
+import { BodyHash, DocumentStubRequest, ProxyConfig } from "./StubSchema"
+import { TemplateVariables } from "./VariablesSchema"
 
 export const ImposterConfig = Schema.Struct({
   name: Schema.optional(NonEmptyString),
   port: PortNumber,
-  stubs: Schema.optionalWith(Schema.Array(CreateStubRequest), { default: () => [] }),
-  proxy: Schema.optional(ProxyConfig)
+  stubs: Schema.optionalWith(Schema.Array(DocumentStubRequest), { default: () => [] }),
+  // Applied after `stubs`
+  presets: Schema.optionalWith(Schema.Array(PresetConfig), { default: () => [] }),
+  proxy: Schema.optional(ProxyConfig),
+  tls: Schema.optional(TlsConfig),
+  stubIds: Schema.optional(StubIdMode),
+  httpParser: Schema.optional(HttpParserMode),
+  stubOrder: Schema.optional(StubOrder),
+  cors: Schema.optional(Schema.Array(CorsRule)),
+  profile: Schema.optional(ProfileName)
 })
 export type ImposterConfig = Schema.Schema.Type<typeof ImposterConfig>
 
+// Bodies responses name with `bodyRef`, keyed by their hash
+export const SharedBodies = Schema.Record({ key: BodyHash, value: Schema.Unknown })
+export type SharedBodies = Schema.Schema.Type<typeof SharedBodies>
+
+const bodiesFound = (document: {
+  readonly imposters: ReadonlyArray<ImposterConfig>
+  readonly bodies: SharedBodies
+}) => {
+  const missing = missingBodies(document.imposters.flatMap((imp) => imp.stubs), document.bodies)
+  return missing.length === 0 || `bodyRef names bodies not in bodies: ${missing.join(", ")}`
+}
+
 export const AdminConfig = Schema.Struct({
   port: Schema.optionalWith(PortNumber, { default: () => 2525 as Schema.Schema.Type<typeof PortNumber> }),
   portRangeMin: Schema.optionalWith(PortNumber, { default: () => 3000 as Schema.Schema.Type<typeof PortNumber> }),
@@ -27,6 +54,65 @@ export type AdminConfig = Schema.Schema.Type<typeof AdminConfig>
 
 export const ConfigFile = Schema.Struct({
   admin: Schema.optionalWith(AdminConfig, { default: () => Schema.decodeSync(AdminConfig)({}) }),
-  imposters: Schema.optionalWith(Schema.Array(ImposterConfig), { default: () => [] })
-})
+  // Merged over the VARIABLES environment variable before imposters are created
+  variables: Schema.optionalWith(TemplateVariables, { default: () => ({}) }),
+  // Put before imposters are created, so they can name one
+  profiles: Schema.optionalWith(Profiles, { default: () => ({}) }),
+  imposters: Schema.optionalWith(Schema.Array(ImposterConfig), { default: () => [] }),
+  bodies: Schema.optionalWith(SharedBodies, { default: () => ({}) })
+}).pipe(Schema.filter(bodiesFound))
 export type ConfigFile = Schema.Schema.Type<typeof ConfigFile>
+
+/**
+ * JSON Schema for a config document, for editor autocomplete and validation. The
+ * top-level `$schema` key is allowed so files can point at the published schema.
+ */
+export const editorJsonSchema = <A, I>(schema: Schema.Schema<A, I>): Record<string, unknown> => {
+  const root: Record<string, unknown> = { ...JSONSchema.make(schema) }
+  const properties = root.properties
+  if (typeof properties === "object" && properties !== null) {
+    root.properties = { $schema: { type: "string", description: "URL of this JSON Schema" }, ...properties }
+  }
+  return root
+}
+
+// Environment manifest: a named group of imposters brought up and torn down together
+export const EnvironmentManifest = Schema.Struct({
+  name: NonEmptyString.pipe(Schema.pattern(/^[A-Za-z0-9_.-]+$/)),
+  imposters: Schema.Array(ImposterConfig),
+  bodies: Schema.optionalWith(SharedBodies, { default: () => ({}) })
+}).pipe(Schema.filter(bodiesFound))
+export type EnvironmentManifest = Schema.Schema.Type<typeof EnvironmentManifest>
+
+/**
+ * Everything the admin server holds, as a config file that recreates it - GET /export.
+ * Large bodies served more than once are written once, under `bodies`.
+ */
+export const ExportDocument = Schema.Struct({
+  variables: TemplateVariables,
+  profiles: Profiles,
+  imposters: Schema.Array(ImposterConfig),
+  bodies: SharedBodies
+})
+export type ExportDocument = Schema.Schema.Type<typeof ExportDocument>
+
+// An export document to restore with POST /imposters/import; sections it leaves out are empty
+export const ImportDocument = Schema.Struct({
+  variables: Schema.optionalWith(TemplateVariables, { default: () => ({}) }),
+  profiles: Schema.optionalWith(Profiles, { default: () => ({}) }),
+  imposters: Schema.optionalWith(Schema.Array(ImposterConfig), { default: () => [] }),
+  bodies: Schema.optionalWith(SharedBodies, { default: () => ({}) })
+}).pipe(Schema.filter(bodiesFound))
+export type ImportDocument = Schema.Schema.Type<typeof ImportDocument>
+
+// Named admin server endpoints used by the CLI (~/.imposters/contexts.json)
+export const CliContext = Schema.Struct({
+  url: Schema.String.pipe(Schema.pattern(/^https?:\/\//))
+})
+export type CliContext = Schema.Schema.Type<typeof CliContext>
+
+export const CliContextsFile = Schema.Struct({
+  current: Schema.optional(Schema.String),
+  contexts: Schema.optionalWith(Schema.Record({ key: Schema.String, value: CliContext }), { default: () => ({}) })
+})
+export type CliContextsFile = Schema.Schema.Type<typeof CliContextsFile>
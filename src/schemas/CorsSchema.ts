@@ -0,0 +1,20 @@
+import * as Schema from "effect/Schema"
+
+// CORS for some of an imposter's paths, so browser apps can call it directly. Preflights on those
+// paths are answered from the rule; other requests get its headers added to whatever they are served
+export const CorsRule = Schema.Struct({
+  // An exact path, or a prefix when it ends in `*`; every path by default
+  path: Schema.optionalWith(Schema.String, { default: () => "*" }),
+  // "*" allows any origin
+  origins: Schema.optionalWith(Schema.Array(Schema.String), { default: () => ["*"] }),
+  // Offered to preflights; by default the methods the imposter's stubs answer at the path
+  methods: Schema.optional(Schema.Array(Schema.String)),
+  // Request headers allowed; by default whichever the preflight asks for
+  headers: Schema.optional(Schema.Array(Schema.String)),
+  // Response headers scripts may read besides the safelisted ones
+  exposeHeaders: Schema.optional(Schema.Array(Schema.String)),
+  credentials: Schema.optionalWith(Schema.Boolean, { default: () => false }),
+  // Seconds browsers may cache a preflight's answer
+  maxAge: Schema.optional(Schema.Number.pipe(Schema.int(), Schema.nonNegative()))
+})
+export type CorsRule = Schema.Schema.Type<typeof CorsRule>
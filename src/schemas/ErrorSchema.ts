@@ -0,0 +1,43 @@
+import * as Schema from "effect/Schema"
+
+// Why an admin request failed. Codes are stable: once published, a code keeps its meaning and
+// is never renamed or reused, so clients can branch on it - GET /errors
+export const NotFoundCode = Schema.Literal(
+  "imposter_not_found",
+  "stub_not_found",
+  "request_not_found",
+  "callback_not_found",
+  "profile_not_found",
+  "scenario_not_found"
+)
+export type NotFoundCode = Schema.Schema.Type<typeof NotFoundCode>
+
+export const ConflictCode = Schema.Literal(
+  "port_unavailable",
+  "imposter_running",
+  "ids_exhausted",
+  "request_matched",
+  "profile_cycle",
+  "profile_in_use",
+  "admin_unprotected"
+)
+export type ConflictCode = Schema.Schema.Type<typeof ConflictCode>
+
+export const ServiceCode = Schema.Literal("imposter_limit", "ports_exhausted", "server_start_failed")
+export type ServiceCode = Schema.Schema.Type<typeof ServiceCode>
+
+// Refused before reaching the API, when ADMIN_TOKEN protects it
+export const AccessCode = Schema.Literal("unauthorized", "read_only")
+export type AccessCode = Schema.Schema.Type<typeof AccessCode>
+
+export const ErrorCode = Schema.Union(NotFoundCode, ConflictCode, ServiceCode, AccessCode)
+export type ErrorCode = Schema.Schema.Type<typeof ErrorCode>
+
+export const ErrorCatalogueEntry = Schema.Struct({
+  code: ErrorCode,
+  status: Schema.Number,
+  description: Schema.String,
+  // What to do about it; also sent as the error's `hint`
+  hint: Schema.String
+})
+export type ErrorCatalogueEntry = Schema.Schema.Type<typeof ErrorCatalogueEntry>
@@ -0,0 +1,27 @@
+import * as Schema from "effect/Schema"
+import { NonEmptyString } from "./common"
+
+// Kinds of change events published on the event bus
+export const ImposterEventType = Schema.Literal(
+  "imposter.created",
+  "imposter.updated",
+  "imposter.deleted",
+  "stub.added",
+  "stub.updated",
+  "stub.removed",
+  "stubs.swapped",
+  // A connection sent something other than HTTP and was dropped
+  "connection.rejected",
+  // A client went away before a streamed response was all sent
+  "client.disconnected"
+)
+export type ImposterEventType = Schema.Schema.Type<typeof ImposterEventType>
+
+export const ImposterEvent = Schema.Struct({
+  id: NonEmptyString,
+  type: ImposterEventType,
+  imposterId: NonEmptyString,
+  timestamp: Schema.DateTimeUtc,
+  data: Schema.optional(Schema.Record({ key: Schema.String, value: Schema.Unknown }))
+})
+export type ImposterEvent = Schema.Schema.Type<typeof ImposterEvent>
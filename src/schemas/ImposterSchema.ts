@@ -9,8 +9,45 @@ import {
   ProtocolFilter,
   StatusFilter
 } from "./common"
+import { invalidCertificate } from "../server/Tls"
+import { CorsRule } from "./CorsSchema"
 import { ProxyConfig } from "./StubSchema"
 
+const certificateError = (c: { readonly cert: string; readonly key: string }) => {
+  const error = invalidCertificate(c)
+  return error === undefined || `Invalid certificate or key: ${error}`
+}
+
+// A PEM certificate chain and its private key, rejected up front if TLS can't use them
+export const TlsCertificate = Schema.Struct({
+  cert: Schema.String,
+  key: Schema.String
+}).pipe(Schema.filter(certificateError))
+export type TlsCertificate = Schema.Schema.Type<typeof TlsCertificate>
+
+// Serve HTTPS: the default certificate, plus per-host certificates chosen by the client's SNI
+export const TlsConfig = Schema.Struct({
+  cert: Schema.String,
+  key: Schema.String,
+  // Host name or "*.example.com" -> certificate; route each host with `host` predicates
+  hosts: Schema.optional(Schema.Record({ key: Schema.String, value: TlsCertificate }))
+}).pipe(Schema.filter(certificateError))
+export type TlsConfig = Schema.Schema.Type<typeof TlsConfig>
+
+// "content" derives IDs of stubs added without one from their predicates, so they are stable across loads
+export const StubIdMode = Schema.Literal("random", "content")
+
+// "lenient" accepts malformed requests (bad header characters, conflicting framing) so they can be
+// journaled with what is wrong with them; "strict" drops them as rejected connections
+export const HttpParserMode = Schema.Literal("strict", "lenient")
+
+// "definition" tries stubs in the order they were defined in, "specificity" tries the most specific path first;
+// a stub's `priority` goes before either
+export const StubOrder = Schema.Literal("definition", "specificity")
+
+// Names a profile of shared imposter settings - GET/PUT/DELETE /profiles/{name}
+export const ProfileName = NonEmptyString.pipe(Schema.pattern(/^[A-Za-z0-9_.-]+$/))
+
 // Create Imposter Request Schema - POST /imposters
 export const CreateImposterRequest = Schema.Struct({
   name: Schema.optional(NonEmptyString),
@@ -20,8 +57,18 @@ export const CreateImposterRequest = Schema.Struct({
     Schema.String.pipe(Schema.startsWith("/")),
     { default: () => "/_admin" }
   ),
-  proxy: Schema.optional(ProxyConfig)
-})
+  proxy: Schema.optional(ProxyConfig),
+  tls: Schema.optional(TlsConfig),
+  stubIds: Schema.optional(StubIdMode),
+  httpParser: Schema.optional(HttpParserMode),
+  stubOrder: Schema.optional(StubOrder),
+  // Checked in order; the first covering a path applies
+  cors: Schema.optional(Schema.Array(CorsRule)),
+  // Settings and stubs to start from; the request's own settings win
+  profile: Schema.optional(ProfileName)
+}).pipe(
+  Schema.filter((r) => r.protocol !== "HTTPS" || r.tls !== undefined || "HTTPS imposters need a tls certificate")
+)
 export type CreateImposterRequest = Schema.Schema.Type<typeof CreateImposterRequest>
 
 // Update Imposter Request Schema - PATCH /imposters/{id}
@@ -30,7 +77,9 @@ export const UpdateImposterRequest = Schema.Struct({
   status: Schema.optional(ImposterStatus),
   port: Schema.optional(PortNumber),
   adminPath: Schema.optional(Schema.String.pipe(Schema.startsWith("/"))),
-  proxy: Schema.optional(Schema.NullOr(ProxyConfig))
+  proxy: Schema.optional(Schema.NullOr(ProxyConfig)),
+  // The named response every stub that has one answers with; null goes back to cycling
+  example: Schema.optional(Schema.NullOr(NonEmptyString))
 })
 export type UpdateImposterRequest = Schema.Schema.Type<typeof UpdateImposterRequest>
 
@@ -109,7 +158,29 @@ export const Statistics = Schema.Struct({
   lastRequestAt: Schema.optional(Schema.DateTimeUtc),
   p50ResponseTime: Schema.optional(Schema.Number),
   p95ResponseTime: Schema.optional(Schema.Number),
-  p99ResponseTime: Schema.optional(Schema.Number)
+  p99ResponseTime: Schema.optional(Schema.Number),
+  // From the request journal's timings; absent until a request is served
+  matching: Schema.optional(Schema.Struct({
+    averageCandidates: Schema.Number.pipe(Schema.nonNegative()),
+    maxCandidates: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+    averageMatchMs: Schema.Number.pipe(Schema.nonNegative()),
+    p95MatchMs: Schema.Number.pipe(Schema.nonNegative()),
+    maxMatchMs: Schema.Number.pipe(Schema.nonNegative()),
+    averageRenderMs: Schema.optional(Schema.Number.pipe(Schema.nonNegative()))
+  })),
+  // Connections dropped because they didn't send HTTP, e.g. TLS to a plain port; absent until one is
+  rejectedConnections: Schema.optional(Schema.Struct({
+    total: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+    byKind: Schema.Record({ key: Schema.String, value: Schema.Number }),
+    // The latest few, first bytes in hex
+    samples: Schema.Array(Schema.Struct({
+      at: Schema.DateTimeUtc,
+      kind: Schema.Literal("tls", "http2", "malformed"),
+      reason: Schema.String,
+      sample: Schema.String,
+      remoteAddress: Schema.optional(Schema.String)
+    }))
+  }))
 })
 export type Statistics = Schema.Schema.Type<typeof Statistics>
 
@@ -127,7 +198,13 @@ export const ImposterResponse = Schema.Struct({
   uptime: Schema.optional(Schema.String), // Formatted duration string
   endpoints: Schema.optional(Schema.Array(EndpointSummary)),
   statistics: Schema.optional(Statistics),
-  proxy: Schema.optional(ProxyConfig)
+  proxy: Schema.optional(ProxyConfig),
+  stubIds: Schema.optional(StubIdMode),
+  httpParser: Schema.optional(HttpParserMode),
+  stubOrder: Schema.optional(StubOrder),
+  cors: Schema.optional(Schema.Array(CorsRule)),
+  example: Schema.optional(NonEmptyString),
+  profile: Schema.optional(ProfileName)
 })
 export type ImposterResponse = Schema.Schema.Type<typeof ImposterResponse>
 
@@ -138,6 +215,143 @@ export const ListImpostersResponse = Schema.Struct({
 })
 export type ListImpostersResponse = Schema.Schema.Type<typeof ListImpostersResponse>
 
+// Overview Schemas - GET /imposters/overview
+export const ImposterHealth = Schema.Literal("up", "down", "stopped")
+export type ImposterHealth = Schema.Schema.Type<typeof ImposterHealth>
+
+export const ImposterOverview = Schema.Struct({
+  id: NonEmptyString,
+  name: NonEmptyString,
+  port: PortNumber,
+  status: ImposterStatus,
+  health: ImposterHealth,
+  stubCount: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  requestCount: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  unmatchedCount: Schema.Number.pipe(Schema.int(), Schema.nonNegative())
+})
+export type ImposterOverview = Schema.Schema.Type<typeof ImposterOverview>
+
+export const UnmatchedRequestSummary = Schema.Struct({
+  imposterId: NonEmptyString,
+  imposterName: NonEmptyString,
+  method: Schema.String,
+  path: Schema.String,
+  timestamp: Schema.DateTimeUtc
+})
+export type UnmatchedRequestSummary = Schema.Schema.Type<typeof UnmatchedRequestSummary>
+
+export const OverviewResponse = Schema.Struct({
+  totals: Schema.Struct({
+    imposters: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+    running: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+    stubs: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+    requests: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+    unmatched: Schema.Number.pipe(Schema.int(), Schema.nonNegative())
+  }),
+  imposters: Schema.Array(ImposterOverview),
+  recentUnmatched: Schema.Array(UnmatchedRequestSummary)
+})
+export type OverviewResponse = Schema.Schema.Type<typeof OverviewResponse>
+
+// What a reset clears: scenario states (and the clock scheduled responses count from), response
+// cycles and `calls` counts, what responses stored (the key-value store, resources and paginated
+// datasets), or the request journal and stats. Stubs are never touched
+export const ResetScope = Schema.Literal("scenarios", "counters", "state", "requests")
+export type ResetScope = Schema.Schema.Type<typeof ResetScope>
+
+export const RESET_SCOPES = ResetScope.literals
+
+// Bulk Reset Response Schema - POST /imposters/reset
+export const BulkResetResponse = Schema.Struct({
+  message: Schema.String,
+  count: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  scopes: Schema.Array(ResetScope)
+})
+export type BulkResetResponse = Schema.Schema.Type<typeof BulkResetResponse>
+
+// POST /imposters/:id/reset
+export const ResetImposterResponse = Schema.Struct({
+  message: Schema.String,
+  id: NonEmptyString,
+  scopes: Schema.Array(ResetScope)
+})
+export type ResetImposterResponse = Schema.Schema.Type<typeof ResetImposterResponse>
+
+// Prune Stubs Response Schema - POST /imposters/:id/stubs/prune
+export const PrunedStub = Schema.Struct({
+  id: Schema.String,
+  hits: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  lastHitAt: Schema.optional(Schema.DateTimeUtc)
+})
+export type PrunedStub = Schema.Schema.Type<typeof PrunedStub>
+
+export const PruneStubsResponse = Schema.Struct({
+  dryRun: Schema.Boolean,
+  pruned: Schema.Array(PrunedStub),
+  remaining: Schema.Number.pipe(Schema.int(), Schema.nonNegative())
+})
+export type PruneStubsResponse = Schema.Schema.Type<typeof PruneStubsResponse>
+
+// Load Manifest Response Schema - POST /imposters/load
+export const LoadManifestResponse = Schema.Struct({
+  environment: NonEmptyString,
+  removed: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  imposters: Schema.Array(ImposterResponse)
+})
+export type LoadManifestResponse = Schema.Schema.Type<typeof LoadManifestResponse>
+
+// How POST /imposters/import treats what the server already holds
+export const ImportMode = Schema.Literal("replace", "merge", "skip-conflicts")
+export type ImportMode = Schema.Schema.Type<typeof ImportMode>
+
+// Something in the document an import left out, as the server already had it or its own
+export const ImportSkip = Schema.Struct({
+  kind: Schema.Literal("imposter", "profile", "variable", "stub", "setting"),
+  // The variable or profile name, or the imposter's name (its port when it has none)
+  name: Schema.String,
+  reason: Schema.String
+})
+export type ImportSkip = Schema.Schema.Type<typeof ImportSkip>
+
+// Import Response Schema - POST /imposters/import
+export const ImportResponse = Schema.Struct({
+  mode: ImportMode,
+  // Imposters the replace mode removed first
+  removed: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  created: Schema.Array(ImposterResponse),
+  // Existing imposters the merge mode added stubs to
+  updated: Schema.Array(ImposterResponse),
+  skipped: Schema.Array(ImportSkip)
+})
+export type ImportResponse = Schema.Schema.Type<typeof ImportResponse>
+
+// Readiness Response Schema - GET /ready
+export const DependencyProbe = Schema.Struct({
+  url: Schema.String,
+  // Where the target is configured: "imposter" or "stub:<id>"
+  sources: Schema.Array(Schema.String),
+  reachable: Schema.Boolean,
+  status: Schema.optional(Schema.Number),
+  latencyMs: Schema.Number.pipe(Schema.nonNegative()),
+  error: Schema.optional(Schema.String)
+})
+export type DependencyProbe = Schema.Schema.Type<typeof DependencyProbe>
+
+export const ImposterReadiness = Schema.Struct({
+  id: NonEmptyString,
+  name: NonEmptyString,
+  health: ImposterHealth,
+  dependencies: Schema.Array(DependencyProbe)
+})
+export type ImposterReadiness = Schema.Schema.Type<typeof ImposterReadiness>
+
+export const ReadinessResponse = Schema.Struct({
+  ready: Schema.Boolean,
+  checkedAt: Schema.DateTimeUtc,
+  imposters: Schema.Array(ImposterReadiness)
+})
+export type ReadinessResponse = Schema.Schema.Type<typeof ReadinessResponse>
+
 // Delete Imposter Query Schema - DELETE /imposters/{id}
 export const DeleteImposterQuery = Schema.Struct({
   force: Schema.optionalWith(Schema.Boolean, { default: () => false })
@@ -0,0 +1,166 @@
+import * as Schema from "effect/Schema"
+import { ResourceConfig, StubPriority } from "./StubSchema"
+
+// Path template a preset protects; every path when absent
+const PresetPath = Schema.String.pipe(Schema.startsWith("/"))
+
+// Multi-round-trip Windows authentication: 401 challenges until the client completes an NTLM handshake
+export const NtlmPreset = Schema.Struct({
+  preset: Schema.Literal("ntlm"),
+  // Offered in this order in `WWW-Authenticate`
+  schemes: Schema.optionalWith(Schema.NonEmptyArray(Schema.Literal("negotiate", "ntlm")), {
+    default: () => ["negotiate", "ntlm"] as const
+  }),
+  path: Schema.optional(PresetPath),
+  // NetBIOS domain announced in the challenge
+  domain: Schema.optionalWith(Schema.String.pipe(Schema.pattern(/^[A-Za-z0-9_.-]{1,15}$/)), {
+    default: () => "IMPOSTERS"
+  }),
+  // The handshake stubs must outrank the protected routes' own stubs
+  priority: Schema.optionalWith(StubPriority, { default: () => 100 })
+})
+export type NtlmPreset = Schema.Schema.Type<typeof NtlmPreset>
+
+const Secrets = (what: string) =>
+  Schema.Record({ key: Schema.String, value: Schema.String }).pipe(
+    Schema.filter((r) => Object.keys(r).length > 0 || `At least one ${what} is required`)
+  )
+
+// HTTP Digest authentication: 401 challenges until the client answers for one of the users
+export const DigestPreset = Schema.Struct({
+  preset: Schema.Literal("digest"),
+  // username -> password
+  users: Secrets("user"),
+  realm: Schema.optionalWith(Schema.String, { default: () => "imposters" }),
+  algorithm: Schema.optionalWith(Schema.Literal("MD5", "SHA-256", "SHA-512-256"), {
+    default: () => "MD5" as const
+  }),
+  path: Schema.optional(PresetPath),
+  priority: Schema.optionalWith(StubPriority, { default: () => 100 })
+})
+export type DigestPreset = Schema.Schema.Type<typeof DigestPreset>
+
+// AWS Signature Version 4: requests not signed with one of the keys get AWS's 403 errors
+export const SigV4Preset = Schema.Struct({
+  preset: Schema.Literal("sigv4"),
+  // access key ID -> secret access key
+  keys: Secrets("key"),
+  // Reject signatures scoped to another region or service; "s3" also switches errors to S3's XML
+  region: Schema.optional(Schema.String),
+  service: Schema.optional(Schema.String),
+  path: Schema.optional(PresetPath),
+  priority: Schema.optionalWith(StubPriority, { default: () => 100 })
+})
+export type SigV4Preset = Schema.Schema.Type<typeof SigV4Preset>
+
+// Flag name -> value: a boolean for on/off flags, anything else for multivariate ones
+export const FeatureFlags = Schema.Record({ key: Schema.String, value: Schema.Unknown })
+export type FeatureFlags = Schema.Schema.Type<typeof FeatureFlags>
+
+// A feature-flag service: LaunchDarkly and Unleash SDK endpoints serving the flags set at /flags
+export const FlagsPreset = Schema.Struct({
+  preset: Schema.Literal("flags"),
+  // Merged into the current flags when the preset is applied
+  flags: Schema.optionalWith(FeatureFlags, { default: () => ({}) })
+})
+export type FlagsPreset = Schema.Schema.Type<typeof FlagsPreset>
+
+// A card payment gateway: test card numbers decide outcomes, with 3-D Secure challenges and async capture
+export const PaymentsPreset = Schema.Struct({
+  preset: Schema.Literal("payments"),
+  // Receives payment.* events; no webhooks are sent without it
+  webhookUrl: Schema.optional(Schema.String),
+  // How long after `POST /payments/{id}/capture` the payment.captured event is sent
+  captureDelay: Schema.optionalWith(Schema.Number.pipe(Schema.int(), Schema.between(0, 60000)), {
+    default: () => 2000
+  })
+})
+export type PaymentsPreset = Schema.Schema.Type<typeof PaymentsPreset>
+
+// A cloud instance metadata service, as cloud SDKs' credential chains find it at 169.254.169.254
+export const MetadataPreset = Schema.Struct({
+  preset: Schema.Literal("metadata"),
+  provider: Schema.Literal("aws", "gcp"),
+  // AWS account ID or Google Cloud project ID
+  account: Schema.optional(Schema.String.pipe(Schema.pattern(/^[a-z0-9-]{1,30}$/))),
+  // AWS region or Google Cloud zone
+  region: Schema.optional(Schema.String.pipe(Schema.pattern(/^[a-z0-9-]{1,30}$/))),
+  // IAM role or service account email the instance runs as
+  identity: Schema.optional(Schema.String.pipe(Schema.pattern(/^[\w.@+=,-]{1,128}$/))),
+  instanceId: Schema.optional(Schema.String.pipe(Schema.pattern(/^[\w-]{1,64}$/))),
+  // Seconds credentials stay valid before they rotate
+  credentialTtl: Schema.optionalWith(Schema.Number.pipe(Schema.int(), Schema.between(60, 43200)), {
+    default: () => 3600
+  }),
+  // AWS only: answer requests without an IMDSv2 session token with 401, as instances that require IMDSv2 do
+  tokenRequired: Schema.optionalWith(Schema.Boolean, { default: () => false })
+})
+export type MetadataPreset = Schema.Schema.Type<typeof MetadataPreset>
+
+// How an LLM API call can fail, each answered the way OpenAI's API answers it
+export const LlmError = Schema.Literal(
+  "rate_limit",
+  "insufficient_quota",
+  "invalid_api_key",
+  "context_length",
+  "server_error",
+  "overloaded"
+)
+export type LlmError = Schema.Schema.Type<typeof LlmError>
+
+const Pattern = Schema.String.pipe(Schema.filter((source) => {
+  try {
+    new RegExp(source)
+    return true
+  } catch {
+    return "must be a valid regular expression"
+  }
+}))
+
+// A canned completion, given when `match` (case-insensitive) finds something in the request body
+export const LlmCompletion = Schema.Struct({
+  match: Pattern,
+  reply: Schema.String
+})
+export type LlmCompletion = Schema.Schema.Type<typeof LlmCompletion>
+
+// An OpenAI-style chat completions API, streaming replies token by token when asked to
+export const LlmPreset = Schema.Struct({
+  preset: Schema.Literal("llm"),
+  // Reported when the request doesn't name a model, and listed at /v1/models
+  model: Schema.optionalWith(Schema.NonEmptyString, { default: () => "gpt-4o-mini" }),
+  // Given when no completion matches
+  reply: Schema.optionalWith(Schema.String, { default: () => "This is a mock completion from Imposters." }),
+  completions: Schema.optionalWith(Schema.Array(LlmCompletion), { default: () => [] }),
+  // Milliseconds between streamed chunks
+  chunkDelay: Schema.optionalWith(Schema.Number.pipe(Schema.int(), Schema.between(0, 5000)), { default: () => 20 }),
+  // Completions allowed a minute, advertised in the x-ratelimit-* headers; the next ones fail as `rate_limit`
+  requestsPerMinute: Schema.optionalWith(Schema.Number.pipe(Schema.int(), Schema.between(1, 1000000)), {
+    default: () => 500
+  }),
+  // Fail every completion this way; a request's X-Imposters-Error header picks one for that request alone
+  error: Schema.optional(LlmError)
+})
+export type LlmPreset = Schema.Schema.Type<typeof LlmPreset>
+
+// A REST collection at `path` with working create, read, update and delete, kept in memory
+export const ResourcePreset = Schema.Struct({
+  preset: Schema.Literal("resource"),
+  ...ResourceConfig.fields
+})
+export type ResourcePreset = Schema.Schema.Type<typeof ResourcePreset>
+
+// A ready-made scenario, expanded into stubs when applied; `preset` names it
+export const PresetConfig = Schema.Union(
+  NtlmPreset,
+  DigestPreset,
+  SigV4Preset,
+  FlagsPreset,
+  PaymentsPreset,
+  MetadataPreset,
+  LlmPreset,
+  ResourcePreset
+)
+export type PresetConfig = Schema.Schema.Type<typeof PresetConfig>
+
+export const PRESET_NAMES = PresetConfig.members.map((member) => member.fields.preset.literals[0])
@@ -0,0 +1,24 @@
+import * as Schema from "effect/Schema"
+import { NonEmptyString } from "./common"
+import { CorsRule } from "./CorsSchema"
+import { HttpParserMode, ProfileName, StubIdMode } from "./ImposterSchema"
+import { PresetConfig } from "./PresetSchema"
+import { CreateStubRequest, ProxyConfig } from "./StubSchema"
+
+// Settings shared by the imposters created with it - GET/PUT/DELETE /profiles/{name}
+export const Profile = Schema.Struct({
+  // A profile to start from: its settings apply unless this one sets them, and its stubs come first
+  extends: Schema.optional(ProfileName),
+  proxy: Schema.optional(ProxyConfig),
+  stubIds: Schema.optional(StubIdMode),
+  httpParser: Schema.optional(HttpParserMode),
+  cors: Schema.optional(Schema.Array(CorsRule)),
+  example: Schema.optional(NonEmptyString),
+  // Added to each imposter ahead of its own
+  stubs: Schema.optionalWith(Schema.Array(CreateStubRequest), { default: () => [] }),
+  presets: Schema.optionalWith(Schema.Array(PresetConfig), { default: () => [] })
+})
+export type Profile = Schema.Schema.Type<typeof Profile>
+
+export const Profiles = Schema.Record({ key: ProfileName, value: Profile })
+export type Profiles = Schema.Schema.Type<typeof Profiles>
@@ -0,0 +1,19 @@
+import * as Schema from "effect/Schema"
+
+// Lets go of the requests held on a key - POST /releases/{key}
+export const ReleaseRequest = Schema.Struct({
+  // Served in place of the held response's own body
+  body: Schema.optional(Schema.Unknown)
+})
+export type ReleaseRequest = Schema.Schema.Type<typeof ReleaseRequest>
+
+export const ReleaseResponse = Schema.Struct({
+  key: Schema.String,
+  // How many held requests were let go; 0 when none were waiting
+  released: Schema.Number
+})
+export type ReleaseResponse = Schema.Schema.Type<typeof ReleaseResponse>
+
+// The keys requests are held on, with how many wait on each - GET /releases
+export const HeldRequests = Schema.Record({ key: Schema.String, value: Schema.Number })
+export type HeldRequests = Schema.Schema.Type<typeof HeldRequests>
@@ -1,5 +1,19 @@
 import * as Schema from "effect/Schema"
 import { NonEmptyString } from "./common"
+import { InlineResponseConfig, PredicateExpression, StubPriority } from "./StubSchema"
+
+// What finding the stub and rendering its response cost, to tell what makes a mock slow
+export const RequestTimings = Schema.Struct({
+  // Stubs whose predicates were evaluated before one matched (all of them when none did)
+  candidates: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  matchMs: Schema.Number.pipe(Schema.nonNegative()),
+  // Building the response, templates included; absent for proxied and unmatched requests
+  renderMs: Schema.optional(Schema.Number.pipe(Schema.nonNegative())),
+  // The wait injected before answering, as sampled: `delay`, chaos latency and any `respondAt` wait.
+  // Absent when there was none
+  delayMs: Schema.optional(Schema.Number.pipe(Schema.nonNegative()))
+})
+export type RequestTimings = Schema.Schema.Type<typeof RequestTimings>
 
 export const RequestLogEntry = Schema.Struct({
   id: NonEmptyString,
@@ -10,7 +24,13 @@ export const RequestLogEntry = Schema.Struct({
     path: Schema.String,
     headers: Schema.Record({ key: Schema.String, value: Schema.String }),
     query: Schema.Record({ key: Schema.String, value: Schema.String }),
-    body: Schema.optional(Schema.Unknown)
+    // The query string as sent, without the `?`; for `queryString` predicates in /verify
+    queryString: Schema.optional(Schema.String),
+    body: Schema.optional(Schema.Unknown),
+    // Body bytes received, before decompression; for `bodySize` predicates in /verify
+    bodySize: Schema.optional(Schema.Number),
+    // What is malformed about the request; see diagnoseRequest
+    diagnostics: Schema.optional(Schema.Array(Schema.String))
   }),
   response: Schema.Struct({
     status: Schema.Number,
@@ -20,12 +40,48 @@ export const RequestLogEntry = Schema.Struct({
     ),
     body: Schema.optional(Schema.String),
     matchedStubId: Schema.optional(NonEmptyString),
-    proxied: Schema.optionalWith(Schema.Boolean, { default: () => false })
+    proxied: Schema.optionalWith(Schema.Boolean, { default: () => false }),
+    // Why the rendered body failed its response schema; the client got a 500 instead
+    violations: Schema.optional(Schema.Array(Schema.String)),
+    // Body bytes that went out, after any fault cut them short; a stream counts all its events
+    bytes: Schema.optional(Schema.Number.pipe(Schema.int(), Schema.nonNegative())),
+    // The client went away before a streamed body was all sent; `bytes` counts what it got
+    disconnected: Schema.optional(Schema.Boolean)
   }),
-  duration: Schema.Number
+  duration: Schema.Number,
+  timings: Schema.optional(RequestTimings)
 })
 export type RequestLogEntry = Schema.Schema.Type<typeof RequestLogEntry>
 
+// A request the imposter made itself while serving one: a proxy forward or a callback attempt
+export const OutboundExchange = Schema.Struct({
+  kind: Schema.Literal("proxy", "callback"),
+  timestamp: Schema.DateTimeUtc,
+  request: Schema.Struct({
+    method: Schema.String,
+    url: Schema.String,
+    headers: Schema.Record({ key: Schema.String, value: Schema.String }),
+    body: Schema.optional(Schema.String)
+  }),
+  // The upstream status, or why no response arrived
+  status: Schema.optional(Schema.Number),
+  error: Schema.optional(Schema.String),
+  // Callbacks are retried; proxy forwards are always attempt 1
+  attempt: Schema.Number.pipe(Schema.int(), Schema.positive()),
+  duration: Schema.Number
+})
+export type OutboundExchange = Schema.Schema.Type<typeof OutboundExchange>
+
+export const OutboundLogEntry = Schema.Struct({
+  id: NonEmptyString,
+  imposterId: NonEmptyString,
+  // The journal entry of the inbound request that caused it
+  triggeredBy: NonEmptyString,
+  stubId: Schema.optional(NonEmptyString),
+  ...OutboundExchange.fields
+})
+export type OutboundLogEntry = Schema.Schema.Type<typeof OutboundLogEntry>
+
 export const ListRequestsUrlParams = Schema.Struct({
   limit: Schema.optionalWith(
     Schema.NumberFromString.pipe(Schema.int(), Schema.positive()),
@@ -36,3 +92,52 @@ export const ListRequestsUrlParams = Schema.Struct({
   status: Schema.optional(Schema.NumberFromString)
 })
 export type ListRequestsUrlParams = Schema.Schema.Type<typeof ListRequestsUrlParams>
+
+// Promote Request Schema - POST /imposters/:id/requests/:requestId/promote
+export const PromoteRequest = Schema.Struct({
+  // What the new stub answers with; an empty 200, to be edited later, by default
+  response: Schema.optional(InlineResponseConfig),
+  // Match the request's query parameters as well as its method and path
+  matchQuery: Schema.optionalWith(Schema.Boolean, { default: () => true }),
+  priority: Schema.optional(StubPriority)
+})
+export type PromoteRequest = Schema.Schema.Type<typeof PromoteRequest>
+
+// What the imposter must have served. `body` predicates are evaluated against the
+// response: `body` is the served body (parsed as JSON when possible), `headers` the response headers.
+export const ResponseExpectation = Schema.Struct({
+  status: Schema.optional(Schema.Number.pipe(Schema.int(), Schema.between(100, 599))),
+  stubId: Schema.optional(Schema.String),
+  proxied: Schema.optional(Schema.Boolean),
+  predicates: Schema.optionalWith(Schema.Array(PredicateExpression), { default: () => [] as const })
+})
+export type ResponseExpectation = Schema.Schema.Type<typeof ResponseExpectation>
+
+export const TimesExpectation = Schema.Struct({
+  exactly: Schema.optional(Schema.Number.pipe(Schema.int(), Schema.nonNegative())),
+  atLeast: Schema.optional(Schema.Number.pipe(Schema.int(), Schema.nonNegative())),
+  atMost: Schema.optional(Schema.Number.pipe(Schema.int(), Schema.nonNegative()))
+})
+export type TimesExpectation = Schema.Schema.Type<typeof TimesExpectation>
+
+// Verification Request Schema - POST /imposters/:id/verify
+export const VerifyRequest = Schema.Struct({
+  request: Schema.optionalWith(Schema.Array(PredicateExpression), { default: () => [] as const }),
+  response: Schema.optional(ResponseExpectation),
+  // Defaults to at least one matching exchange
+  times: Schema.optional(TimesExpectation)
+})
+export type VerifyRequest = Schema.Schema.Type<typeof VerifyRequest>
+
+export const VerifyResponse = Schema.Struct({
+  passed: Schema.Boolean,
+  // Journal entries matching the request predicates, and of those, the ones whose response matched too
+  requestMatches: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  count: Schema.Number.pipe(Schema.int(), Schema.nonNegative()),
+  message: Schema.String,
+  // What was served for the requests that matched, to see which scenario variant ran
+  statuses: Schema.Record({ key: Schema.String, value: Schema.Number }),
+  stubs: Schema.Record({ key: Schema.String, value: Schema.Number }),
+  entryIds: Schema.Array(NonEmptyString)
+})
+export type VerifyResponse = Schema.Schema.Type<typeof VerifyResponse>
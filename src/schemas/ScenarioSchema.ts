@@ -0,0 +1,49 @@
+import * as Schema from "effect/Schema"
+import { NonEmptyString } from "./common"
+
+// Each scenario an imposter's stubs name, with the state it is in - GET /imposters/{id}/scenarios
+export const ScenarioStates = Schema.Record({ key: Schema.String, value: Schema.String })
+export type ScenarioStates = Schema.Schema.Type<typeof ScenarioStates>
+
+// Moves a scenario to `state` - PUT /imposters/{id}/scenarios/{name}
+export const SetScenarioRequest = Schema.Struct({
+  state: NonEmptyString
+})
+export type SetScenarioRequest = Schema.Schema.Type<typeof SetScenarioRequest>
+
+// A scenario moving from one state to another - GET /imposters/{id}/scenarios/history
+export const ScenarioTransition = Schema.Struct({
+  scenario: Schema.String,
+  from: Schema.String,
+  to: Schema.String,
+  // The stub whose response moved it; none when it was moved by hand
+  stubId: Schema.optional(Schema.String),
+  at: Schema.DateTimeUtc
+})
+export type ScenarioTransition = Schema.Schema.Type<typeof ScenarioTransition>
+
+// A transition to look for: into `to`, and out of `from` when given
+export const ScenarioStep = Schema.Struct({
+  from: Schema.optional(NonEmptyString),
+  to: NonEmptyString
+})
+export type ScenarioStep = Schema.Schema.Type<typeof ScenarioStep>
+
+// POST /imposters/{id}/scenarios/verify
+export const VerifyScenarioRequest = Schema.Struct({
+  name: NonEmptyString,
+  // The state it should be in now
+  state: Schema.optional(NonEmptyString),
+  // Transitions that should have happened in this order, with any others in between
+  sequence: Schema.optionalWith(Schema.Array(ScenarioStep), { default: () => [] })
+})
+export type VerifyScenarioRequest = Schema.Schema.Type<typeof VerifyScenarioRequest>
+
+export const VerifyScenarioResponse = Schema.Struct({
+  passed: Schema.Boolean,
+  message: Schema.String,
+  state: Schema.String,
+  // The scenario's transitions, oldest first
+  transitions: Schema.Array(ScenarioTransition)
+})
+export type VerifyScenarioResponse = Schema.Schema.Type<typeof VerifyScenarioResponse>
@@ -0,0 +1,22 @@
+import * as Schema from "effect/Schema"
+
+// Longest a share link stays valid: a week
+export const MAX_SHARE_SECONDS = 7 * 24 * 3600
+
+// A read-only link to the admin UI and API - POST /shares
+export const CreateShareRequest = Schema.Struct({
+  // Seconds until the link stops working
+  ttl: Schema.optionalWith(Schema.Number.pipe(Schema.int(), Schema.between(60, MAX_SHARE_SECONDS)), {
+    default: () => 3600
+  })
+})
+export type CreateShareRequest = Schema.Schema.Type<typeof CreateShareRequest>
+
+export const ShareResponse = Schema.Struct({
+  // Sent as a bearer token, or as `?token=` in a link
+  token: Schema.String,
+  expiresAt: Schema.DateTimeUtc,
+  // The admin UI, opened with the token
+  url: Schema.String
+})
+export type ShareResponse = Schema.Schema.Type<typeof ShareResponse>
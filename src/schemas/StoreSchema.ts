@@ -0,0 +1,14 @@
+import * as Schema from "effect/Schema"
+
+// An imposter's key-value store, read and written by its templates - GET/PUT/PATCH/DELETE /imposters/{id}/state
+export const StoreValues = Schema.Record({ key: Schema.String, value: Schema.Unknown })
+export type StoreValues = Schema.Schema.Type<typeof StoreValues>
+
+// An upstream answer a caching proxy keeps in the store; binary bodies are base64
+export const CachedResponse = Schema.Struct({
+  status: Schema.Number,
+  headers: Schema.Record({ key: Schema.String, value: Schema.String }),
+  body: Schema.String,
+  encoding: Schema.Literal("utf8", "base64")
+})
+export type CachedResponse = Schema.Schema.Type<typeof CachedResponse>
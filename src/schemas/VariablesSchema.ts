@@ -0,0 +1,5 @@
+import * as Schema from "effect/Schema"
+
+// Values shared by every imposter's templates as `{{vars.<name>}}` and `$vars` - GET/PUT/PATCH /variables
+export const TemplateVariables = Schema.Record({ key: Schema.String, value: Schema.Unknown })
+export type TemplateVariables = Schema.Schema.Type<typeof TemplateVariables>
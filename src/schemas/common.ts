@@ -6,7 +6,7 @@ import * as Schema from "effect/Schema"
 export const ImposterStatus = Schema.Literal("running", "stopped", "starting", "stopping")
 export type ImposterStatus = Schema.Schema.Type<typeof ImposterStatus>
 
-export const Protocol = Schema.Literal("HTTP")
+export const Protocol = Schema.Literal("HTTP", "HTTPS")
 export type Protocol = Schema.Schema.Type<typeof Protocol>
 
 // Utility schemas for validation
@@ -3,20 +3,23 @@ import * as Layer from "effect/Layer"
 import { ApiLayer } from "../layers/ApiLayer"
 import { MainLayer } from "../layers/MainLayer"
 import { makeAdminUiRouter } from "../ui/admin/AdminUiRouter"
+import { compressResponse } from "./Compression"
+import { guardAdmin } from "./Shares"
 
 export const FullLayer = ApiLayer.pipe(Layer.provide(MainLayer))
 
 export const makeWebHandler = () => HttpApiBuilder.toWebHandler(FullLayer)
 
-export const makeCompositeHandler = (adminPort: number) => {
+// With `adminToken`, the UI and API answer only requests carrying it, or a share to read with
+export const makeCompositeHandler = (adminPort: number, adminToken?: string) => {
   const { dispose, handler: apiHandler } = HttpApiBuilder.toWebHandler(FullLayer)
   const adminUiRouter = makeAdminUiRouter({ apiHandler, adminPort })
 
-  const handler = async (request: Request): Promise<Response> => {
+  const handler = guardAdmin(adminToken, async (request: Request): Promise<Response> => {
     const uiResponse = await adminUiRouter(request)
     if (uiResponse !== null) return uiResponse
-    return apiHandler(request)
-  }
+    return compressResponse(request, await apiHandler(request))
+  })
 
   return { handler, dispose }
 }
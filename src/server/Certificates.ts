@@ -0,0 +1,244 @@
+// Mints X.509 certificates for HTTPS imposters: a local CA, and per-host certificates it
+// signs. node:crypto signs but can't build certificates, so they are DER-encoded here.
+
+import {
+  createHash,
+  createPrivateKey,
+  generateKeyPairSync,
+  type KeyObject,
+  randomBytes,
+  sign,
+  X509Certificate
+} from "node:crypto"
+import * as net from "node:net"
+import type { TlsCertificate } from "./Tls"
+
+const DAY_MS = 24 * 60 * 60 * 1000
+
+// -- DER --
+
+const tlv = (tag: number, content: Uint8Array): Buffer => {
+  const n = content.length
+  const length = n < 0x80
+    ? [n]
+    : (() => {
+      const bytes: Array<number> = []
+      for (let rest = n; rest > 0; rest = Math.floor(rest / 256)) bytes.unshift(rest % 256)
+      return [0x80 | bytes.length, ...bytes]
+    })()
+  return Buffer.concat([Buffer.from([tag, ...length]), content])
+}
+
+const sequence = (...items: ReadonlyArray<Uint8Array>) => tlv(0x30, Buffer.concat(items))
+const set = (...items: ReadonlyArray<Uint8Array>) => tlv(0x31, Buffer.concat(items))
+const explicit = (n: number, item: Uint8Array) => tlv(0xa0 + n, item)
+const octetString = (content: Uint8Array) => tlv(0x04, content)
+const bitString = (content: Uint8Array) => tlv(0x03, Buffer.concat([Buffer.from([0]), content]))
+const utf8String = (s: string) => tlv(0x0c, Buffer.from(s, "utf8"))
+const boolean = (value: boolean) => tlv(0x01, Buffer.from([value ? 0xff : 0]))
+
+// Unsigned big-endian integer; a leading zero keeps it positive
+const integer = (bytes: Uint8Array) => tlv(0x02, bytes[0]! & 0x80 ? Buffer.concat([Buffer.from([0]), bytes]) : bytes)
+
+const oid = (dotted: string): Buffer => {
+  const [first = 0, second = 0, ...rest] = dotted.split(".").map(Number)
+  const bytes = [first * 40 + second]
+  for (const arc of rest) {
+    const base128 = [arc & 0x7f]
+    for (let v = Math.floor(arc / 128); v > 0; v = Math.floor(v / 128)) base128.unshift(0x80 | (v & 0x7f))
+    bytes.push(...base128)
+  }
+  return tlv(0x06, Buffer.from(bytes))
+}
+
+// UTCTime through 2049, GeneralizedTime after, as RFC 5280 requires
+const time = (date: Date): Buffer => {
+  const digits = date.toISOString().replace(/[-:T]/g, "").slice(0, 14) + "Z"
+  return date.getUTCFullYear() < 2050 ? tlv(0x17, Buffer.from(digits.slice(2))) : tlv(0x18, Buffer.from(digits))
+}
+
+// Header length of the TLV at the start of `der`
+const headerLength = (der: Buffer): number => (der[1]! & 0x80 ? 2 + (der[1]! & 0x7f) : 2)
+
+// The TLVs one level inside a constructed value
+const children = (der: Buffer): Array<Buffer> => {
+  const items: Array<Buffer> = []
+  const content = der.subarray(headerLength(der))
+  for (let offset = 0; offset < content.length;) {
+    const item = content.subarray(offset)
+    const header = headerLength(item)
+    let length = item[1]!
+    if (length & 0x80) {
+      length = 0
+      for (let i = 2; i < header; i++) length = length * 256 + item[i]!
+    }
+    items.push(item.subarray(0, header + length))
+    offset += header + length
+  }
+  return items
+}
+
+// -- X.509 --
+
+// ecdsa-with-SHA256, or sha256WithRSAEncryption for a CA brought along with an RSA key
+const signatureAlgorithm = (key: KeyObject): Buffer =>
+  key.asymmetricKeyType === "rsa"
+    ? sequence(oid("1.2.840.113549.1.1.11"), tlv(0x05, Buffer.alloc(0)))
+    : sequence(oid("1.2.840.10045.4.3.2"))
+
+const name = (commonName: string) =>
+  sequence(
+    set(sequence(oid("2.5.4.10"), utf8String("Imposters"))),
+    set(sequence(oid("2.5.4.3"), utf8String(commonName)))
+  )
+
+const extension = (id: string, critical: boolean, value: Uint8Array) =>
+  sequence(oid(id), ...(critical ? [boolean(true)] : []), octetString(value))
+
+// The key identifier of RFC 5280 4.2.1.2, method 1: SHA-1 of the public key bits
+const keyIdentifier = (publicKey: KeyObject): Buffer => {
+  const [, bits] = children(publicKey.export({ type: "spki", format: "der" }))
+  // Skip the bit string's header and its unused-bits byte
+  return createHash("sha1").update(bits!.subarray(headerLength(bits!) + 1)).digest()
+}
+
+// The subject Name of a DER certificate, which certificates it signs name as their issuer
+const subjectOf = (der: Buffer): Buffer => {
+  const fields = children(children(der)[0]!)
+  // version [0], serialNumber, signature, issuer, validity, subject
+  return fields[fields[0]![0] === 0xa0 ? 5 : 4]!
+}
+
+// dNSName [2] for names, iPAddress [7] for IPv4/IPv6 literals
+const generalName = (host: string): Buffer => {
+  const bare = host.replace(/^\[|\]$/g, "")
+  if (net.isIPv4(bare)) return tlv(0x87, Buffer.from(bare.split(".").map(Number)))
+  if (net.isIPv6(bare)) {
+    const [head = "", tail = ""] = bare.split("::")
+    const groups = (s: string) => s === "" ? [] : s.split(":")
+    const fill = bare.includes("::") ? 8 - groups(head).length - groups(tail).length : 0
+    const all = [...groups(head), ...Array<string>(fill).fill("0"), ...groups(tail)]
+    return tlv(0x87, Buffer.concat(all.map((g) => Buffer.from(g.padStart(4, "0"), "hex"))))
+  }
+  return tlv(0x82, Buffer.from(host.toLowerCase(), "ascii"))
+}
+
+interface CertificateFields {
+  readonly subject: Buffer
+  readonly issuer: Buffer
+  readonly publicKey: KeyObject
+  readonly signingKey: KeyObject
+  readonly authorityKeyId: Buffer
+  readonly notBefore: Date
+  readonly notAfter: Date
+  readonly extensions: ReadonlyArray<Buffer>
+  readonly serial: Uint8Array | undefined
+}
+
+// Positive and minimal: a clear top bit needs no 0x00 pad and a set low bit rules out a leading zero byte
+const serialNumber = (bytes: Uint8Array): Buffer => {
+  const serial = Buffer.from(bytes)
+  serial[0] = (serial[0]! & 0x7f) | 0x01
+  return integer(serial)
+}
+
+const certificate = (fields: CertificateFields): string => {
+  const tbs = sequence(
+    explicit(0, integer(Buffer.from([2]))),
+    serialNumber(fields.serial ?? randomBytes(16)),
+    signatureAlgorithm(fields.signingKey),
+    fields.issuer,
+    sequence(time(fields.notBefore), time(fields.notAfter)),
+    fields.subject,
+    fields.publicKey.export({ type: "spki", format: "der" }),
+    explicit(
+      3,
+      sequence(
+        ...fields.extensions,
+        extension("2.5.29.14", false, octetString(keyIdentifier(fields.publicKey))),
+        extension("2.5.29.35", false, sequence(tlv(0x80, fields.authorityKeyId)))
+      )
+    )
+  )
+  const signature = sign("sha256", tbs, { key: fields.signingKey, dsaEncoding: "der" })
+  const der = sequence(tbs, signatureAlgorithm(fields.signingKey), bitString(signature)).toString("base64")
+  return `-----BEGIN CERTIFICATE-----\n${der.match(/.{1,64}/g)!.join("\n")}\n-----END CERTIFICATE-----\n`
+}
+
+const newKeyPair = () => generateKeyPairSync("ec", { namedCurve: "prime256v1" })
+
+const pem = (key: KeyObject): string => key.export({ type: "pkcs8", format: "pem" }).toString()
+
+export interface CertificateOptions {
+  // Defaults to now; backdated an hour to absorb clock skew
+  readonly now?: Date
+  readonly validityDays?: number
+  // 16 random bytes unless given, adjusted to a valid DER serial
+  readonly serial?: Uint8Array
+}
+
+/**
+ * A self-signed CA certificate for signing imposter certificates. Valid for ten years
+ * unless `validityDays` says otherwise.
+ */
+export const createCertificateAuthority = (
+  commonName: string,
+  options: CertificateOptions = {}
+): TlsCertificate => {
+  const now = options.now ?? new Date()
+  const { privateKey, publicKey } = newKeyPair()
+  return {
+    cert: certificate({
+      subject: name(commonName),
+      issuer: name(commonName),
+      publicKey,
+      signingKey: privateKey,
+      authorityKeyId: keyIdentifier(publicKey),
+      notBefore: new Date(now.getTime() - 60 * 60 * 1000),
+      notAfter: new Date(now.getTime() + (options.validityDays ?? 3650) * DAY_MS),
+      serial: options.serial,
+      extensions: [
+        // CA, may only sign end-entity certificates
+        extension("2.5.29.19", true, sequence(boolean(true), integer(Buffer.from([0])))),
+        // keyCertSign and cRLSign
+        extension("2.5.29.15", true, tlv(0x03, Buffer.from([1, 0x06])))
+      ]
+    }),
+    key: pem(privateKey)
+  }
+}
+
+/**
+ * A server certificate for `hosts` (names, `*.` wildcards or IP addresses) signed by
+ * `ca`. Valid for 397 days by default, the most browsers accept.
+ */
+export const issueCertificate = (
+  ca: TlsCertificate,
+  hosts: ReadonlyArray<string>,
+  options: CertificateOptions = {}
+): TlsCertificate => {
+  const now = options.now ?? new Date()
+  const caCert = new X509Certificate(ca.cert)
+  const { privateKey, publicKey } = newKeyPair()
+  return {
+    cert: certificate({
+      subject: name(hosts[0] ?? "localhost"),
+      issuer: subjectOf(caCert.raw),
+      publicKey,
+      signingKey: createPrivateKey(ca.key),
+      authorityKeyId: keyIdentifier(caCert.publicKey),
+      notBefore: new Date(now.getTime() - 60 * 60 * 1000),
+      notAfter: new Date(now.getTime() + (options.validityDays ?? 397) * DAY_MS),
+      serial: options.serial,
+      extensions: [
+        extension("2.5.29.19", true, sequence()),
+        // digitalSignature
+        extension("2.5.29.15", true, tlv(0x03, Buffer.from([7, 0x80]))),
+        // serverAuth
+        extension("2.5.29.37", false, sequence(oid("1.3.6.1.5.5.7.3.1"))),
+        extension("2.5.29.17", false, sequence(...hosts.map(generalName)))
+      ]
+    }),
+    key: pem(privateKey)
+  }
+}
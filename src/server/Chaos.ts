@@ -0,0 +1,17 @@
+import type { ChaosConfig } from "../schemas/ChaosSchema"
+
+export const CHAOS_HEADER = "x-imposters-chaos"
+
+// The chaos settings that apply to an imposter's requests, if any
+export const chaosFor = (chaos: ChaosConfig | undefined, imposterId: string): ChaosConfig | undefined =>
+  chaos !== undefined && (chaos.imposters === undefined || chaos.imposters.includes(imposterId)) ? chaos : undefined
+
+// Whether this request fails; `random` is in [0, 1)
+export const injectsError = (chaos: ChaosConfig, random: number = Math.random()): boolean => random < chaos.errorRate
+
+// The answer a request chaos fails gets, marked so it can't be mistaken for the stub's
+export const chaosResponse = (chaos: ChaosConfig): Response =>
+  new Response(JSON.stringify({ error: "Injected failure", status: chaos.errorStatus }), {
+    status: chaos.errorStatus,
+    headers: { "content-type": "application/json", [CHAOS_HEADER]: "error" }
+  })
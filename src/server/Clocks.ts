@@ -0,0 +1,35 @@
+import { Effect, HashMap, Option, Ref } from "effect"
+
+/**
+ * Each imposter's clock, which `during` windows and `{{now}}` read. It is the wall clock until
+ * set; a set clock stands still at its time until it is advanced, set again or cleared.
+ */
+export const makeClocks = Effect.gen(function*() {
+  const ref = yield* Ref.make(HashMap.empty<string, number>())
+
+  const now = (imposterId: string): Effect.Effect<number> =>
+    Effect.map(Ref.get(ref), (all) => Option.getOrElse(HashMap.get(all, imposterId), () => Date.now()))
+
+  // Reads the clock as it is now: the set time, or the wall clock as it goes on
+  const reader = (imposterId: string): Effect.Effect<() => number> =>
+    Effect.map(Ref.get(ref), (all) =>
+      Option.match(HashMap.get(all, imposterId), { onNone: () => Date.now, onSome: (time) => () => time }))
+
+  // Whether the imposter's clock was set, rather than following the wall clock
+  const isSet = (imposterId: string): Effect.Effect<boolean> =>
+    Effect.map(Ref.get(ref), HashMap.has(imposterId))
+
+  const set = (imposterId: string, time: number): Effect.Effect<void> =>
+    Ref.update(ref, HashMap.set(imposterId, time))
+
+  // Moves the clock on by `ms`; a clock following the wall clock stops there
+  const advance = (imposterId: string, ms: number): Effect.Effect<number> =>
+    Ref.modify(ref, (all) => {
+      const next = Option.getOrElse(HashMap.get(all, imposterId), () => Date.now()) + ms
+      return [next, HashMap.set(all, imposterId, next)]
+    })
+
+  const clear = (imposterId: string): Effect.Effect<void> => Ref.update(ref, HashMap.remove(imposterId))
+
+  return { now, reader, isSet, set, advance, clear }
+})
@@ -0,0 +1,45 @@
+import { gzipSync } from "node:zlib"
+
+// Bodies smaller than this aren't worth the CPU or the extra headers
+const MIN_COMPRESS_BYTES = 1024
+
+const COMPRESSIBLE = /json|text\/|javascript|xml/
+
+/**
+ * True when the client accepts gzip, honouring `q=0` as a refusal.
+ */
+export const acceptsGzip = (acceptEncoding: string | null): boolean =>
+  (acceptEncoding ?? "").split(",").some((part) => {
+    const [coding, ...params] = part.trim().split(";").map((s) => s.trim())
+    if (coding !== "gzip" && coding !== "*") return false
+    const q = params.find((p) => p.startsWith("q="))
+    return q === undefined || Number(q.slice(2)) > 0
+  })
+
+/**
+ * Gzip a response when the request negotiates it. Streams (SSE), already encoded and
+ * small or binary bodies pass through unchanged.
+ */
+export const compressResponse = async (request: Request, response: Response): Promise<Response> => {
+  const contentType = response.headers.get("content-type") ?? ""
+  if (
+    response.body === null ||
+    !acceptsGzip(request.headers.get("accept-encoding")) ||
+    response.headers.has("content-encoding") ||
+    contentType.includes("text/event-stream") ||
+    !COMPRESSIBLE.test(contentType)
+  ) {
+    return response
+  }
+
+  const body = new Uint8Array(await response.arrayBuffer())
+  const headers = new Headers(response.headers)
+  headers.append("vary", "accept-encoding")
+  if (body.byteLength < MIN_COMPRESS_BYTES) {
+    return new Response(body, { status: response.status, statusText: response.statusText, headers })
+  }
+
+  headers.set("content-encoding", "gzip")
+  headers.delete("content-length")
+  return new Response(gzipSync(body), { status: response.status, statusText: response.statusText, headers })
+}
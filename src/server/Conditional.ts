@@ -0,0 +1,96 @@
+import { createHash } from "node:crypto"
+
+// A strong entity tag for a representation: a digest of its bytes
+export const entityTag = (body: Uint8Array): string =>
+  `"${createHash("sha256").update(body).digest("base64url").slice(0, 22)}"`
+
+const opaqueTag = (tag: string): string => tag.replace(/^W\//, "")
+
+// Entity tags listed in an If-Match/If-None-Match header, or "*"
+const listedTags = (header: string): ReadonlyArray<string> | "*" =>
+  header.trim() === "*" ? "*" : header.match(/(?:W\/)?"[^"]*"/g) ?? []
+
+/**
+ * Whether `header` names `etag`. If-Match compares strongly (weak tags never match),
+ * If-None-Match weakly (`W/"x"` matches `"x"`), as RFC 9110 section 13.1 requires.
+ */
+export const tagsMatch = (header: string, etag: string, weak: boolean): boolean => {
+  const tags = listedTags(header)
+  if (tags === "*") return true
+  if (!weak && etag.startsWith("W/")) return false
+  return tags.some((tag) => weak ? opaqueTag(tag) === opaqueTag(etag) : !tag.startsWith("W/") && tag === etag)
+}
+
+// An HTTP date in whole seconds, or undefined when it doesn't parse
+const httpDate = (value: string | null | undefined): number | undefined => {
+  if (value === null || value === undefined) return undefined
+  const ms = Date.parse(value)
+  return Number.isNaN(ms) ? undefined : Math.floor(ms / 1000)
+}
+
+/**
+ * The outcome of a request's preconditions (RFC 9110 section 13.2.2) for a representation
+ * with `etag`, last modified at `lastModified` (an HTTP date): 200 to go ahead, 412 when
+ * If-Match or If-Unmodified-Since fails, and 304 to GET and HEAD (412 to other methods)
+ * when If-None-Match matches or If-Modified-Since is no older than `lastModified`.
+ */
+export const preconditions = (
+  method: string,
+  header: (name: string) => string | null | undefined,
+  etag: string,
+  lastModified: string | null | undefined
+): 200 | 304 | 412 => {
+  const modified = httpDate(lastModified)
+  const safe = method === "GET" || method === "HEAD"
+
+  const ifMatch = header("if-match")
+  if (ifMatch !== null && ifMatch !== undefined) {
+    if (!tagsMatch(ifMatch, etag, false)) return 412
+  } else {
+    const since = httpDate(header("if-unmodified-since"))
+    if (since !== undefined && modified !== undefined && modified > since) return 412
+  }
+
+  const ifNoneMatch = header("if-none-match")
+  if (ifNoneMatch !== null && ifNoneMatch !== undefined) {
+    if (tagsMatch(ifNoneMatch, etag, true)) return safe ? 304 : 412
+  } else if (safe) {
+    const since = httpDate(header("if-modified-since"))
+    if (since !== undefined && modified !== undefined && modified <= since) return 304
+  }
+  return 200
+}
+
+export interface ConditionalBody {
+  readonly status: number
+  readonly headers: Headers
+  readonly body: Uint8Array<ArrayBuffer>
+}
+
+/**
+ * Evaluate a request's preconditions against a 2xx response, which gets an ETag from its
+ * body unless it has one, answering 412 or 304 in its place when they say so.
+ */
+export const serveConditional = (
+  method: string,
+  request: Headers,
+  status: number,
+  body: Uint8Array<ArrayBuffer>,
+  headers: Headers
+): ConditionalBody => {
+  if (status < 200 || status > 299) return { status, headers, body }
+  const out = new Headers(headers)
+  if (!out.has("etag")) out.set("etag", entityTag(body))
+
+  switch (preconditions(method, (name) => request.get(name), out.get("etag")!, out.get("last-modified"))) {
+    case 412:
+      out.delete("content-type")
+      return { status: 412, headers: out, body: new Uint8Array(0) }
+    case 304:
+      out.delete("content-type")
+      out.delete("content-length")
+      return { status: 304, headers: out, body: new Uint8Array(0) }
+    case 200:
+      return { status, headers: out, body }
+  }
+}
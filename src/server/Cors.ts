@@ -0,0 +1,56 @@
+import type { CorsRule } from "../schemas/CorsSchema"
+
+// The first rule covering `path`
+export const corsRuleFor = (rules: ReadonlyArray<CorsRule>, path: string): CorsRule | undefined =>
+  rules.find((rule) => rule.path.endsWith("*") ? path.startsWith(rule.path.slice(0, -1)) : path === rule.path)
+
+// A browser asking whether it may send the request it names
+export const isPreflight = (method: string, headers: Readonly<Record<string, string>>): boolean =>
+  method === "OPTIONS" && headers["origin"] !== undefined && headers["access-control-request-method"] !== undefined
+
+// What Access-Control-Allow-Origin carries for `origin`; "*" can't be used with credentials
+const allowedOrigin = (rule: CorsRule, origin: string): string | undefined =>
+  rule.origins.includes("*")
+    ? (rule.credentials ? origin : "*")
+    : rule.origins.includes(origin)
+    ? origin
+    : undefined
+
+const allowOrigin = (rule: CorsRule, origin: string, headers: Headers): boolean => {
+  headers.append("vary", "Origin")
+  const allowed = allowedOrigin(rule, origin)
+  if (allowed === undefined) return false
+  headers.set("access-control-allow-origin", allowed)
+  if (rule.credentials) headers.set("access-control-allow-credentials", "true")
+  return true
+}
+
+/**
+ * A 204 answering a preflight. An origin the rule doesn't allow gets no Access-Control-*
+ * headers, which browsers take as a refusal. `stubMethods` are offered when the rule names none.
+ */
+export const preflightResponse = (
+  rule: CorsRule,
+  headers: Readonly<Record<string, string>>,
+  stubMethods: ReadonlyArray<string>
+): Response => {
+  const response = new Headers()
+  if (allowOrigin(rule, headers["origin"] ?? "", response)) {
+    const requested = headers["access-control-request-method"] ?? ""
+    const methods = rule.methods ?? (stubMethods.length > 0 ? stubMethods : [requested])
+    response.set("access-control-allow-methods", methods.join(", "))
+    const allowHeaders = rule.headers?.join(", ") ?? headers["access-control-request-headers"]
+    if (allowHeaders !== undefined && allowHeaders !== "") response.set("access-control-allow-headers", allowHeaders)
+    if (rule.maxAge !== undefined) response.set("access-control-max-age", String(rule.maxAge))
+  }
+  return new Response(null, { status: 204, headers: response })
+}
+
+// `served` with the rule's headers for a cross-origin request from `origin`
+export const withCors = (rule: CorsRule, origin: string, served: Response): Response => {
+  const headers = new Headers(served.headers)
+  if (allowOrigin(rule, origin, headers) && rule.exposeHeaders !== undefined) {
+    headers.set("access-control-expose-headers", rule.exposeHeaders.join(", "))
+  }
+  return new Response(served.body, { status: served.status, statusText: served.statusText, headers })
+}
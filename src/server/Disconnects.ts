@@ -0,0 +1,52 @@
+import { progressiveResponses } from "./Drip"
+
+const concat = (chunks: ReadonlyArray<Uint8Array>): Uint8Array => {
+  const bytes = new Uint8Array(chunks.reduce((n, chunk) => n + chunk.byteLength, 0))
+  let offset = 0
+  for (const chunk of chunks) {
+    bytes.set(chunk, offset)
+    offset += chunk.byteLength
+  }
+  return bytes
+}
+
+/**
+ * `served` with a body that passes through what it sends and calls `onDone` once with the bytes
+ * that went out: when the body ends, or with `disconnected` when the client goes away before then.
+ */
+export const watchBody = (
+  served: Response,
+  onDone: (sent: Uint8Array, disconnected: boolean) => void
+): Response => {
+  if (served.body === null) {
+    onDone(new Uint8Array(), false)
+    return served
+  }
+  const reader = served.body.getReader()
+  const chunks: Array<Uint8Array> = []
+  let finished = false
+  const finish = (disconnected: boolean) => {
+    if (finished) return
+    finished = true
+    onDone(concat(chunks), disconnected)
+  }
+  // Nothing is read ahead, so what was read is what the server sent
+  const body = new ReadableStream<Uint8Array>({
+    pull: async (controller) => {
+      const { done, value } = await reader.read()
+      if (done) {
+        controller.close()
+        return finish(false)
+      }
+      chunks.push(value)
+      controller.enqueue(value)
+    },
+    cancel: (reason) => {
+      finish(true)
+      return reader.cancel(reason)
+    }
+  }, { highWaterMark: 0 })
+  const watched = new Response(body, { status: served.status, headers: served.headers })
+  if (progressiveResponses.has(served)) progressiveResponses.add(watched)
+  return watched
+}
@@ -0,0 +1,39 @@
+import type { ResponseDrip } from "../schemas/StubSchema"
+
+// Responses whose body the server factories write as it arrives, rather than collecting it first
+export const progressiveResponses = new WeakSet<Response>()
+
+/**
+ * `body` in pieces of `chunkBytes`, each sent once its share of `duration` has passed since
+ * the one before. Cancelling the stream (the client went away) stops it sending any more.
+ */
+export const dripStream = (body: Uint8Array, drip: ResponseDrip): ReadableStream<Uint8Array> => {
+  const chunks = Math.ceil(body.byteLength / drip.chunkBytes)
+  const interval = drip.duration / chunks
+  let next = 0
+  let timer: ReturnType<typeof setTimeout> | undefined
+  return new ReadableStream<Uint8Array>({
+    pull: (controller) =>
+      new Promise<void>((resolve) => {
+        if (next >= chunks) {
+          controller.close()
+          return resolve()
+        }
+        const offset = next++ * drip.chunkBytes
+        timer = setTimeout(() => {
+          controller.enqueue(body.slice(offset, offset + drip.chunkBytes))
+          resolve()
+        }, interval)
+      }),
+    cancel: () => clearTimeout(timer)
+  })
+}
+
+// Keeps the full content-length, so clients can show progress while the body trickles in
+export const dripResponse = (body: Uint8Array, drip: ResponseDrip, init: ResponseInit): Response => {
+  const headers = new Headers(init.headers)
+  headers.set("content-length", String(body.byteLength))
+  const response = new Response(dripStream(body, drip), { ...init, headers })
+  progressiveResponses.add(response)
+  return response
+}
@@ -0,0 +1,155 @@
+import { Data } from "effect"
+import { STATUS_CODES } from "node:http"
+import type { ResponseFault, TruncateFault } from "../schemas/StubSchema"
+
+export class TruncatedBodyError extends Data.TaggedError("TruncatedBodyError")<{
+  readonly sent: number
+  readonly length: number
+}> {
+  override get message() {
+    return `Response body truncated after ${this.sent} of ${this.length} bytes`
+  }
+}
+
+export class ConnectionResetError extends Data.TaggedError("ConnectionResetError")<{}> {
+  override get message() {
+    return "Connection reset before the response was sent"
+  }
+}
+
+// Byte offset at which a truncate fault cuts a body of `length` bytes
+export const truncationOffset = (fault: TruncateFault, length: number): number =>
+  fault.bytes !== undefined
+    ? Math.min(fault.bytes, length)
+    : Math.floor(length * (fault.percent ?? 0) / 100)
+
+/**
+ * A response with the status and headers of the complete body, including its full
+ * content-length, whose body stream fails after `offset` bytes. The server factories
+ * drop the connection when a body fails, so clients see a cut-off upstream response.
+ */
+export const truncatedResponse = (
+  body: Uint8Array,
+  offset: number,
+  init: { readonly status: number; readonly headers: Headers }
+): Response => {
+  const headers = new Headers(init.headers)
+  headers.set("content-length", String(body.byteLength))
+  let sent = false
+  const stream = new ReadableStream<Uint8Array>({
+    pull(controller) {
+      if (!sent) {
+        sent = true
+        if (offset > 0) {
+          controller.enqueue(body.subarray(0, offset))
+          return
+        }
+      }
+      controller.error(new TruncatedBodyError({ sent: offset, length: body.byteLength }))
+    }
+  })
+  return new Response(stream, { status: init.status, headers })
+}
+
+/**
+ * A response whose body fails before a byte of it is read. The Node server factory resets
+ * the connection when it sees that, without sending the status or headers either.
+ */
+export const resetResponse = (init: { readonly status: number; readonly headers: Headers }): Response =>
+  new Response(
+    new ReadableStream<Uint8Array>({
+      pull(controller) {
+        controller.error(new ConnectionResetError())
+      }
+    }),
+    init
+  )
+
+// Bytes the Node server factory writes to the socket in place of a response, then closes it
+export const rawResponses = new WeakMap<Response, Uint8Array>()
+
+const encoder = new TextEncoder()
+
+const concat = (...parts: ReadonlyArray<Uint8Array>): Uint8Array => {
+  const out = new Uint8Array(parts.reduce((sum, p) => sum + p.byteLength, 0))
+  let at = 0
+  for (const part of parts) {
+    out.set(part, at)
+    at += part.byteLength
+  }
+  return out
+}
+
+// A status line and headers, with the body framing headers replaced by `framing`
+const rawHead = (init: { readonly status: number; readonly headers: Headers }, framing: Record<string, string>) => {
+  const lines = [`HTTP/1.1 ${init.status} ${STATUS_CODES[init.status] ?? ""}`]
+  init.headers.forEach((value, key) => {
+    if (key !== "content-length" && key !== "transfer-encoding" && key !== "connection") lines.push(`${key}: ${value}`)
+  })
+  for (const [key, value] of Object.entries(framing)) lines.push(`${key}: ${value}`)
+  lines.push("connection: close")
+  return encoder.encode(`${lines.join("\r\n")}\r\n\r\n`)
+}
+
+// The same bytes every time, none of them a plausible start of an HTTP response
+export const garbageBytes = (length: number): Uint8Array =>
+  Uint8Array.from({ length }, (_, i) => (i * 151 + 0x80) & 0xff)
+
+/**
+ * Raw bytes `fault` sends in place of a response, and the body bytes among them. Under Bun,
+ * which can't write to the socket, the response carries those body bytes instead.
+ */
+const malformed = (
+  fault: Exclude<ResponseFault, { readonly type: "truncate" | "connection_reset" }>,
+  bytes: Uint8Array,
+  init: { readonly status: number; readonly headers: Headers }
+): { readonly raw: Uint8Array; readonly sentBytes: Uint8Array } => {
+  switch (fault.type) {
+    case "garbage": {
+      const garbage = garbageBytes(fault.bytes)
+      return { raw: garbage, sentBytes: garbage }
+    }
+    case "truncated_json": {
+      const cut = bytes.subarray(0, Math.floor(bytes.byteLength * fault.percent / 100))
+      return { raw: concat(rawHead(init, { "content-length": String(cut.byteLength) }), cut), sentBytes: cut }
+    }
+    case "wrong_content_length": {
+      const length = String(Math.max(0, bytes.byteLength + fault.offset))
+      return { raw: concat(rawHead(init, { "content-length": length }), bytes), sentBytes: bytes }
+    }
+    case "invalid_chunked": {
+      const chunk = concat(encoder.encode("zz\r\n"), bytes, encoder.encode("\r\n0\r\n\r\n"))
+      return { raw: concat(rawHead(init, { "transfer-encoding": "chunked" }), chunk), sentBytes: bytes }
+    }
+  }
+}
+
+/**
+ * Apply a fault to an already-rendered response. Returns the response to send and
+ * the part of the body that will actually reach the client.
+ */
+export const applyFault = (
+  fault: ResponseFault,
+  body: string | Uint8Array,
+  init: { readonly status: number; readonly headers: Headers }
+): { readonly response: Response; readonly sentBody: string; readonly sentBytes: Uint8Array } => {
+  if (fault.type === "connection_reset") {
+    return { response: resetResponse(init), sentBody: "", sentBytes: new Uint8Array() }
+  }
+  const bytes = typeof body === "string" ? encoder.encode(body) : body
+  if (fault.type === "truncate") {
+    const offset = truncationOffset(fault, bytes.byteLength)
+    const sentBytes = bytes.subarray(0, offset)
+    return {
+      response: truncatedResponse(bytes, offset, init),
+      sentBody: new TextDecoder().decode(sentBytes),
+      sentBytes
+    }
+  }
+  const { raw, sentBytes } = malformed(fault, bytes, init)
+  const headers = new Headers(init.headers)
+  headers.delete("content-length")
+  const response = new Response(sentBytes.byteLength > 0 ? sentBytes : null, { status: init.status, headers })
+  rawResponses.set(response, raw)
+  return { response, sentBody: new TextDecoder().decode(sentBytes), sentBytes }
+}
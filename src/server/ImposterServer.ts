@@ -1,18 +1,77 @@
-import { Context, Data, Effect, HashMap, Layer, Ref, Runtime } from "effect"
+import { Config, Context, Data, Effect, Fiber, HashMap, Layer, Option, Redacted, Ref, Runtime, Stream } from "effect"
 import * as DateTime from "effect/DateTime"
 import { ImposterConfig, type ImposterNotFoundError, type ProxyConfigDomain } from "../domain/imposter"
-import { extractRequestContext, findMatchingStub } from "../matching/RequestMatcher"
-import { buildResponse, makeResponseState } from "../matching/ResponseGenerator"
+import {
+  allowedMethods,
+  extractRequestContext,
+  isBodyTooLarge,
+  matchStub,
+  MAX_DECODED_BODY_BYTES,
+  type RequestContext,
+  withPathParams
+} from "../matching/RequestMatcher"
+import { liveEventStream } from "../matching/EventStream"
+import { isTextual } from "../matching/Media"
+import { isDuplicateRecording } from "../matching/RecordNormalizer"
+import {
+  buildResponse,
+  delayMs,
+  EXAMPLE_HEADER,
+  exampleIndex,
+  makeResponseState,
+  makeTemplateHelpers,
+  renderEvents,
+  scheduledWait,
+  validateResponse
+} from "../matching/ResponseGenerator"
+import { applyTemplates } from "../matching/TemplateEngine"
+import { transformResponse } from "../matching/ResponseTransforms"
 import { ImposterRepository } from "../repositories/ImposterRepository"
+import type { ChaosConfig } from "../schemas/ChaosSchema"
+import type { ClockState } from "../schemas/ClockSchema"
 import { NonEmptyString } from "../schemas/common"
+import { RESET_SCOPES, type ResetScope } from "../schemas/ImposterSchema"
+import type { HeldRequests, ReleaseRequest } from "../schemas/ReleaseSchema"
 import type { RequestLogEntry } from "../schemas/RequestLogSchema"
-import type { Stub } from "../schemas/StubSchema"
+import type { ScenarioTransition } from "../schemas/ScenarioSchema"
+import type { StoreValues } from "../schemas/StoreSchema"
+import type { TemplateVariables } from "../schemas/VariablesSchema"
+import {
+  type DisconnectHook,
+  MAX_SCHEDULED_WAIT_MS,
+  type RangeMode,
+  type ResponseConfig,
+  type ResponseDrip,
+  type ResponseFault,
+  type ResponseSchedule,
+  type Stub
+} from "../schemas/StubSchema"
+import { CallbackService } from "../services/CallbackService"
+import { EventBus } from "../services/EventBus"
 import { MetricsService } from "../services/MetricsService"
 import { ProxyService } from "../services/ProxyService"
-import { RequestLogger } from "../services/RequestLogger"
+import { type JournalOutbound, RequestLogger } from "../services/RequestLogger"
+import { Variables } from "../services/Variables"
 import { makeUiRouter } from "../ui/UiRouter"
+import { chaosFor, chaosResponse, injectsError } from "./Chaos"
+import { makeClocks } from "./Clocks"
+import { serveConditional } from "./Conditional"
+import { corsRuleFor, isPreflight, preflightResponse, withCors } from "./Cors"
+import { watchBody } from "./Disconnects"
+import { dripResponse } from "./Drip"
+import { applyFault } from "./Faults"
+import { makePages } from "./Pagination"
+import { cacheKey, fromCached, toCached } from "./ProxyCache"
+import { serveRange } from "./Ranges"
 import { FiberManager } from "./FiberManager"
-import { ServerFactory } from "./ServerFactory"
+import { makeReleases } from "./Releases"
+import { makeRateLimits, overLimit } from "./RateLimits"
+import { makeResources, resourceResponse } from "./Resources"
+import { inState, makeScenarios } from "./Scenarios"
+import { captureValues, makeStores, storeView } from "./Stores"
+import type { ScenarioStates } from "./Scenarios"
+import { requestDiagnostics, ServerFactory } from "./ServerFactory"
+import { guardAdmin } from "./Shares"
 
 export class ImposterServerError extends Data.TaggedError("ImposterServerError")<{
   readonly imposterId: string
@@ -24,14 +83,70 @@ export interface ImposterServerShape {
   readonly stop: (id: string) => Effect.Effect<void>
   readonly updateStubs: (id: string) => Effect.Effect<void>
   readonly updateProxyConfig: (id: string) => Effect.Effect<void>
+  readonly updateExample: (id: string) => Effect.Effect<void>
+  readonly resetResponses: (id: string) => Effect.Effect<void>
+  // Clears only what `scopes` name; "requests" is the journal's, which the server doesn't keep
+  readonly resetState: (id: string, scopes: ReadonlyArray<ResetScope>) => Effect.Effect<void>
+  readonly replaceStubs: (id: string) => Effect.Effect<void>
   readonly isRunning: (id: string) => Effect.Effect<boolean>
+  // Failures and latency layered over every imposter's matched requests; undefined when off
+  readonly chaos: Effect.Effect<ChaosConfig | undefined>
+  readonly setChaos: (chaos: ChaosConfig | undefined) => Effect.Effect<void>
+  // Lets go of the requests a response's `hold` keeps waiting on `key`, returning how many there were
+  readonly release: (key: string, release: ReleaseRequest) => Effect.Effect<number>
+  readonly held: Effect.Effect<HeldRequests>
+  // The states the imposter's scenarios have been moved to; those never moved are in "Started"
+  readonly scenarios: (id: string) => Effect.Effect<ScenarioStates>
+  // Oldest first, up to MAX_TRANSITIONS
+  readonly scenarioHistory: (id: string) => Effect.Effect<ReadonlyArray<ScenarioTransition>>
+  readonly setScenario: (id: string, name: string, state: string) => Effect.Effect<void>
+  readonly resetScenarios: (id: string) => Effect.Effect<void>
+  // The imposter's key-value store, which its templates read and write
+  readonly store: (id: string) => Effect.Effect<StoreValues>
+  readonly replaceStore: (id: string, values: StoreValues) => Effect.Effect<StoreValues>
+  // Sets the given keys and keeps the rest; a null value removes one
+  readonly mergeStore: (id: string, values: StoreValues) => Effect.Effect<StoreValues>
+  readonly clearStore: (id: string) => Effect.Effect<void>
+  // The imposter's clock, which `during` windows and `{{now}}` read: the wall clock unless set
+  readonly clock: (id: string) => Effect.Effect<ClockState>
+  readonly setClock: (id: string, time: number) => Effect.Effect<ClockState>
+  readonly advanceClock: (id: string, ms: number) => Effect.Effect<ClockState>
+  readonly clearClock: (id: string) => Effect.Effect<ClockState>
 }
 
 export class ImposterServer extends Context.Tag("ImposterServer")<ImposterServer, ImposterServerShape>() {}
 
+// Requests in flight per stub-set generation; a full replacement bumps the generation
+interface DrainState {
+  generation: number
+  readonly inFlight: Map<number, number>
+}
+
+const releaseInFlight = (drain: DrainState, generation: number): void => {
+  const remaining = (drain.inFlight.get(generation) ?? 1) - 1
+  if (remaining > 0) drain.inFlight.set(generation, remaining)
+  else drain.inFlight.delete(generation)
+}
+
+const pendingBefore = (drain: DrainState, generation: number): number => {
+  let pending = 0
+  for (const [gen, count] of drain.inFlight) {
+    if (gen < generation) pending += count
+  }
+  return pending
+}
+
+const DRAIN_TIMEOUT_MS = 30_000
+
+// Journal timings to the microsecond
+const roundMs = (ms: number): number => Math.round(ms * 1000) / 1000
+
 interface ImposterState {
   readonly stubsRef: Ref.Ref<ReadonlyArray<Stub>>
   readonly proxyConfigRef: Ref.Ref<ProxyConfigDomain | undefined>
+  readonly exampleRef: Ref.Ref<string | undefined>
+  readonly responseState: Effect.Effect.Success<ReturnType<typeof makeResponseState>>
+  readonly drain: DrainState
 }
 
 export const ImposterServerLive = Layer.effect(
@@ -43,7 +158,70 @@ export const ImposterServerLive = Layer.effect(
     const requestLogger = yield* RequestLogger
     const metricsService = yield* MetricsService
     const proxyService = yield* ProxyService
+    const eventBus = yield* EventBus
+    const callbackService = yield* CallbackService
+    const variables = yield* Variables
     const stateMapRef = yield* Ref.make<HashMap.HashMap<string, ImposterState>>(HashMap.empty())
+    const chaosRef = yield* Ref.make<ChaosConfig | undefined>(undefined)
+    const releases = yield* makeReleases
+    const scenarios = yield* makeScenarios
+    const stores = yield* makeStores
+    const resources = yield* makeResources
+    const pages = yield* makePages
+    const clocks = yield* makeClocks
+    const rateLimits = yield* makeRateLimits
+    // Where responses' `bodyFile` fixtures are read from
+    const fixturesDir = yield* Config.string("FIXTURES_DIR").pipe(Config.withDefault("."))
+    // Guards each imposter's /_admin pages as it does the admin port, since they can change stubs too
+    const adminToken = yield* Config.option(Config.redacted("ADMIN_TOKEN")).pipe(
+      Config.map((token) => Option.getOrUndefined(Option.filter(Option.map(token, Redacted.value), (t) => t !== "")))
+    )
+
+    // With `cache`, an identical request answered before is served from the imposter's store
+    const forwardToProxy = (
+      id: string,
+      ctx: RequestContext,
+      proxyConfig: ProxyConfigDomain,
+      url: URL,
+      journal: JournalOutbound
+    ): Effect.Effect<Response> =>
+      Effect.gen(function*() {
+        const key = proxyConfig.cache === true ? cacheKey(ctx) : undefined
+        const cached = key !== undefined ? fromCached((yield* stores.get(id))[key]) : undefined
+        if (cached !== undefined) return cached
+        const response = yield* proxyService.forward(ctx, proxyConfig, url, journal).pipe(
+          Effect.catchTag("ProxyError", (err) =>
+            Effect.succeed(
+              new Response(
+                JSON.stringify({ error: "Proxy failed", target: err.targetUrl, reason: err.reason }),
+                { status: 502, headers: { "content-type": "application/json" } }
+              )
+            ))
+        )
+        if (key !== undefined && response.status < 500) {
+          const kept = response.clone()
+          yield* stores.merge(id, { [key]: yield* Effect.promise(() => toCached(kept)) })
+        }
+        return response
+      })
+
+    // A held response waits for its key to be released, then serves the release's body if it carries
+    // one; still waiting at its timeout, it gives way to an empty `timeoutStatus`
+    const awaitRelease = (config: ResponseConfig, ctx: RequestContext): Effect.Effect<ResponseConfig> => {
+      const hold = config.hold
+      if (hold === undefined) return Effect.succeed(config)
+      return Effect.promise(() => applyTemplates(ctx, hold.key)).pipe(
+        Effect.flatMap((key) => releases.wait(String(key), hold.timeout)),
+        Effect.map(Option.match({
+          onNone: (): ResponseConfig => ({ status: hold.timeoutStatus }),
+          onSome: ({ body }): ResponseConfig => {
+            if (body === undefined) return config
+            const { bodyFile: _, ...rest } = config
+            return { ...rest, body }
+          }
+        }))
+      )
+    }
 
     const start = (id: string): Effect.Effect<void, ImposterServerError | ImposterNotFoundError> =>
       Effect.gen(function*() {
@@ -53,108 +231,385 @@ export const ImposterServerLive = Layer.effect(
         // Create per-imposter state
         const stubsRef = yield* Ref.make<ReadonlyArray<Stub>>(record.stubs)
         const proxyConfigRef = yield* Ref.make<ProxyConfigDomain | undefined>(config.proxy)
+        const exampleRef = yield* Ref.make<string | undefined>(config.example)
         const responseState = yield* makeResponseState()
+        const drain: DrainState = { generation: 0, inFlight: new Map() }
 
         // Store state for hot-reload
-        yield* Ref.update(stateMapRef, HashMap.set(id, { stubsRef, proxyConfigRef } as ImposterState))
+        yield* Ref.update(
+          stateMapRef,
+          HashMap.set(id, { stubsRef, proxyConfigRef, exampleRef, responseState, drain } as ImposterState)
+        )
 
         // Capture runtime for running effects inside fetch handler
         const rt = yield* Effect.runtime<never>()
         const runPromise = Runtime.runPromise(rt)
 
+        // Calls `listener` with the shared variables after every change, until the returned function is called
+        const onVariablesChange = (listener: (current: TemplateVariables) => void): () => void => {
+          const fiber = Runtime.runFork(rt)(Effect.scoped(Effect.flatMap(variables.subscribe, (changes) =>
+            Stream.runForEach(Stream.fromQueue(changes), (current) => Effect.sync(() => listener(current))))))
+          return () => {
+            Runtime.runFork(rt)(Fiber.interrupt(fiber))
+          }
+        }
+
         // UI router for /_admin pages
-        const uiRouter = makeUiRouter({ id, config, stubsRef, repo, requestLogger, runPromise })
+        const uiRouter = makeUiRouter({ id, config, stubsRef, repo, requestLogger, eventBus, runPromise })
+        const guardedUi = guardAdmin(
+          adminToken,
+          async (request) => (await uiRouter(request)) ?? new Response(null, { status: 404 })
+        )
 
         const handler = async (request: Request): Promise<Response> => {
-          // Try UI router first (returns null if not a /_admin path)
-          const uiResponse = await uiRouter(request)
-          if (uiResponse !== null) return uiResponse
+          // /_admin paths go to the UI, behind the same token or read-only share as the admin port
+          if (new URL(request.url).pathname.startsWith("/_admin")) return guardedUi(request)
+
+          // Count the request against the current stub set so a swap can wait for it
+          const generation = drain.generation
+          drain.inFlight.set(generation, (drain.inFlight.get(generation) ?? 0) + 1)
 
           return runPromise(
             Effect.gen(function*() {
               const startTime = Date.now()
+              // Known up front so outbound requests made while serving this one can point at it
+              const entryId = NonEmptyString.make(crypto.randomUUID())
               const stubs = yield* Ref.get(stubsRef)
+              // Stubs whose scenario is in another state sit this request out
+              const eligible = inState(yield* scenarios.states(id))
               const ctx = yield* Effect.promise(() => extractRequestContext(request))
-              const stub = findMatchingStub(ctx, stubs)
+              const diagnostics = requestDiagnostics.get(request)
+              const cors = corsRuleFor(config.cors ?? [], ctx.path)
+              const origin = ctx.headers["origin"]
+              // Preflights on a path with a CORS rule are answered from it, before any stub
+              const preflight = cors !== undefined && isPreflight(ctx.method, ctx.headers)
+              // A body that decodes to more than MAX_DECODED_BODY_BYTES is refused before matching
+              const tooLarge = isBodyTooLarge(ctx)
+              const { candidates, matchMs, stub } = preflight || tooLarge
+                ? { candidates: 0, matchMs: 0, stub: undefined }
+                : matchStub(ctx, stubs, config.stubOrder, eligible)
+              const journal: JournalOutbound = (exchange) =>
+                requestLogger.logOutbound({
+                  id: NonEmptyString.make(crypto.randomUUID()),
+                  imposterId: NonEmptyString.make(id),
+                  triggeredBy: entryId,
+                  ...(stub ? { stubId: NonEmptyString.make(stub.id) } : {}),
+                  ...exchange
+                })
 
               let response: Response
               let proxied = false
-              if (!stub) {
+              let fault: ResponseFault | undefined
+              let drip: ResponseDrip | undefined
+              let onDisconnect: DisconnectHook | undefined
+              let range: RangeMode | undefined
+              let conditional = false
+              let schedule: ResponseSchedule | undefined
+              let streamed = false
+              let violations: ReadonlyArray<string> | undefined
+              let renderMs: number | undefined
+              let injectedMs = 0
+              // Chaos only touches requests a stub matched, on top of whatever the stub does
+              const chaos = stub !== undefined ? chaosFor(yield* Ref.get(chaosRef), id) : undefined
+              const chaosLatency = chaos !== undefined
+                ? chaos.extraLatencyMs + (chaos.latency !== undefined ? delayMs(chaos.latency) : 0)
+                : 0
+              if (chaosLatency > 0) {
+                yield* Effect.sleep(`${chaosLatency} millis`)
+                injectedMs += chaosLatency
+              }
+              if (preflight) {
+                response = preflightResponse(cors, ctx.headers, allowedMethods(ctx, stubs, eligible))
+              } else if (tooLarge) {
+                response = new Response(
+                  JSON.stringify({ error: "Request body too large once decoded", limit: MAX_DECODED_BODY_BYTES }),
+                  { status: 413, headers: { "content-type": "application/json" } }
+                )
+              } else if (!stub) {
                 const proxyConfig = yield* Ref.get(proxyConfigRef)
                 if (proxyConfig) {
-                  const url = new URL(request.url)
-                  response = yield* proxyService.forward(ctx, proxyConfig, url).pipe(
-                    Effect.catchTag("ProxyError", (err) =>
-                      Effect.succeed(
-                        new Response(
-                          JSON.stringify({ error: "Proxy failed", target: err.targetUrl, reason: err.reason }),
-                          { status: 502, headers: { "content-type": "application/json" } }
-                        )
-                      ))
-                  )
+                  response = yield* forwardToProxy(id, ctx, proxyConfig, new URL(request.url), journal)
                   proxied = true
                   // Record mode: save as stub + update stubsRef
                   if (proxyConfig.mode === "record" && response.status < 500) {
                     const responseClone = response.clone()
-                    const newStub = yield* proxyService.recordAsStub(ctx, responseClone)
-                    yield* repo.addStub(id, newStub).pipe(Effect.catchAll(() => Effect.void))
+                    const newStub = yield* proxyService.recordAsStub(ctx, responseClone, proxyConfig)
+                    // Concurrent misses for the same route may race; keep only the first recording
+                    const currentStubs = yield* repo.getStubs(id).pipe(
+                      Effect.catchAll(() => Effect.succeed([] as ReadonlyArray<Stub>))
+                    )
+                    if (!isDuplicateRecording(currentStubs, newStub)) {
+                      yield* repo.addStub(id, newStub).pipe(Effect.catchAll(() => Effect.void))
+                    }
                     const freshStubs = yield* repo.getStubs(id).pipe(
                       Effect.catchAll(() => Effect.succeed([] as ReadonlyArray<Stub>))
                     )
                     yield* Ref.set(stubsRef, freshStubs)
                   }
                 } else {
-                  response = new Response(
-                    JSON.stringify({ error: "No matching stub found", method: ctx.method, path: ctx.path }),
-                    { status: 404, headers: { "content-type": "application/json" } }
-                  )
+                  // A known path requested with the wrong method gets a 405 listing the right ones
+                  const allow = allowedMethods(ctx, stubs, eligible)
+                  response = allow.length > 0
+                    ? new Response(
+                      JSON.stringify({ error: "Method not allowed", method: ctx.method, path: ctx.path, allow }),
+                      { status: 405, headers: { "content-type": "application/json", allow: allow.join(", ") } }
+                    )
+                    : new Response(
+                      JSON.stringify({ error: "No matching stub found", method: ctx.method, path: ctx.path }),
+                      { status: 404, headers: { "content-type": "application/json" } }
+                    )
                 }
+              } else if (chaos !== undefined && injectsError(chaos)) {
+                response = chaosResponse(chaos)
               } else {
                 const responses = stub.responses
-                const index = yield* responseState.getNextIndex(id, stub.id, responses.length, stub.responseMode)
-                const responseConfig = responses[index]!
-                const delay = responseConfig.delay
-                if (delay !== undefined && delay > 0) {
+                // A response named by the request, else by the imposter, answers without advancing the cycle
+                const named = exampleIndex(responses, ctx.headers[EXAMPLE_HEADER]) ??
+                  exampleIndex(responses, yield* Ref.get(exampleRef))
+                const clock = yield* clocks.reader(id)
+                const index = named ??
+                  (yield* responseState.nextIndex(id, stub.id, responses, stub.responseMode, clock()))
+                const chosen = responses[index]!
+                // Past its rate limit, a response gives way to the limit's own answer
+                const limit = chosen.rateLimit
+                const picked = limit !== undefined && !(yield* rateLimits.take(id, limit, clock()))
+                  ? overLimit(limit)
+                  : chosen
+                const delay = picked.delay !== undefined ? delayMs(picked.delay) : 0
+                if (delay > 0) {
                   yield* Effect.sleep(`${delay} millis`)
+                  injectedMs += delay
+                }
+                const responseConfig = yield* awaitRelease(picked, withPathParams(ctx, stub))
+                fault = responseConfig.fault
+                drip = responseConfig.drip
+                onDisconnect = responseConfig.onDisconnect
+                range = responseConfig.range ?? (responseConfig.media !== undefined ? "honor" : undefined)
+                conditional = responseConfig.conditional === true
+                schedule = responseConfig.respondAt
+                streamed = responseConfig.events !== undefined
+                const matchedCtx = withPathParams(ctx, stub)
+                // Captured before rendering, so this response's templates can already read them
+                if (stub.capture !== undefined) {
+                  const captured = captureValues(stub.capture, matchedCtx)
+                  if (Object.keys(captured).length > 0) yield* stores.merge(id, captured)
                 }
-                response = yield* Effect.promise(() => buildResponse(responseConfig, ctx))
+                if (responseConfig.proxy !== undefined) {
+                  response = yield* forwardToProxy(id, matchedCtx, responseConfig.proxy, new URL(request.url), journal)
+                  if (responseConfig.transforms !== undefined) {
+                    const transforms = responseConfig.transforms
+                    const upstream = response
+                    response = yield* Effect.promise(() => transformResponse(upstream, transforms))
+                  }
+                  proxied = true
+                } else if (responseConfig.resource !== undefined) {
+                  // Resources check preconditions themselves, before a write is made
+                  conditional = false
+                  const answer = yield* resources.serve(id, stub.id, responseConfig.resource, matchedCtx, clock())
+                  response = resourceResponse(answer)
+                } else if (responseConfig.paginate !== undefined) {
+                  const helpers = makeTemplateHelpers(stubs, ctx.headers, {
+                    fixturesDir,
+                    variables: yield* variables.get,
+                    now: clock,
+                    eligible,
+                    ...(config.stubOrder !== undefined ? { stubOrder: config.stubOrder } : {})
+                  })
+                  const answer = yield* pages.serve(
+                    id,
+                    stub.id,
+                    responseConfig.paginate,
+                    matchedCtx,
+                    (items) => applyTemplates(matchedCtx, items, helpers)
+                  )
+                  response = resourceResponse(answer)
+                } else {
+                  const store = storeView(yield* stores.get(id))
+                  const helpers = makeTemplateHelpers(stubs, ctx.headers, {
+                    fixturesDir,
+                    variables: yield* variables.get,
+                    store,
+                    now: clock,
+                    eligible,
+                    ...(config.stubOrder !== undefined ? { stubOrder: config.stubOrder } : {})
+                  })
+                  const renderStarted = performance.now()
+                  response = yield* Effect.promise(() =>
+                    buildResponse(responseConfig, matchedCtx, helpers, fixturesDir)
+                  )
+                  renderMs = performance.now() - renderStarted
+                  const liveEvents = responseConfig.live === true ? responseConfig.events : undefined
+                  if (liveEvents !== undefined && response.body !== null) {
+                    const live = liveEventStream(response.body, onVariablesChange, (current: TemplateVariables) =>
+                      renderEvents(liveEvents, matchedCtx, { ...helpers, variables: current, now: clock }))
+                    response = new Response(live, { status: response.status, headers: response.headers })
+                  }
+                  if (Object.keys(store.writes()).length > 0) yield* stores.merge(id, store.writes())
+                }
+                const validation = responseConfig.validate
+                if (validation !== undefined) {
+                  const checked = yield* Effect.promise(() => validateResponse(response, validation.schema))
+                  response = checked.response
+                  if (checked.violations.length > 0) {
+                    violations = checked.violations
+                    fault = undefined
+                  }
+                }
+                // Webhooks are delivered in the background and never delay the response
+                for (const callback of responseConfig.callbacks ?? []) {
+                  yield* callbackService.dispatch(id, stub.id, callback, matchedCtx, journal)
+                }
+                if (stub.scenario?.newState !== undefined) {
+                  yield* scenarios.set(id, stub.scenario.name, stub.scenario.newState, clock(), stub.id)
+                }
+              }
+
+              // Browsers only hand a cross-origin response to the page when it carries CORS headers
+              if (cors !== undefined && !preflight && origin !== undefined) {
+                response = withCors(cors, origin, response)
               }
 
-              // Capture response for logging
-              const respText = yield* Effect.promise(() => response.text())
+              // Journal the exchange once what reached the client is known
+              const record = (
+                status: number,
+                headers: Record<string, string>,
+                sentBytes: Uint8Array,
+                disconnected = false
+              ) =>
+                Effect.gen(function*() {
+                  const contentType = headers["content-type"] ?? null
+                  const sentText = sentBytes.byteLength === 0
+                    ? ""
+                    : isTextual(contentType)
+                    ? new TextDecoder().decode(sentBytes)
+                    : `[${sentBytes.byteLength} bytes of ${contentType}]`
+                  const logBody = sentText.length > 10240 ? sentText.slice(0, 10240) : (sentText || undefined)
+
+                  const duration = Date.now() - startTime
+                  const logEntry: RequestLogEntry = {
+                    id: entryId,
+                    imposterId: NonEmptyString.make(id),
+                    timestamp: DateTime.unsafeMake(startTime),
+                    request: {
+                      method: ctx.method,
+                      path: ctx.path,
+                      headers: ctx.headers,
+                      query: ctx.query,
+                      ...(ctx.queryString !== undefined ? { queryString: ctx.queryString } : {}),
+                      body: ctx.body,
+                      ...(ctx.bodySize !== undefined ? { bodySize: ctx.bodySize } : {}),
+                      ...(diagnostics !== undefined ? { diagnostics } : {})
+                    },
+                    response: {
+                      status,
+                      headers,
+                      ...(logBody !== undefined ? { body: logBody } : {}),
+                      ...(stub ? { matchedStubId: NonEmptyString.make(stub.id) } : {}),
+                      proxied,
+                      ...(violations !== undefined ? { violations } : {}),
+                      bytes: sentBytes.byteLength,
+                      ...(disconnected ? { disconnected } : {})
+                    },
+                    duration,
+                    timings: {
+                      candidates,
+                      matchMs: roundMs(matchMs),
+                      ...(renderMs !== undefined ? { renderMs: roundMs(renderMs) } : {}),
+                      ...(injectedMs > 0 ? { delayMs: roundMs(injectedMs) } : {})
+                    }
+                  }
+                  yield* requestLogger.log(logEntry).pipe(Effect.catchAll(() => Effect.void))
+                  yield* metricsService.recordRequest(logEntry).pipe(Effect.catchAll(() => Effect.void))
+                })
+
+              const hook = onDisconnect
+              const clientDisconnected = (sent: Uint8Array) =>
+                Effect.gen(function*() {
+                  yield* eventBus.publish("client.disconnected", id, {
+                    requestId: entryId,
+                    method: ctx.method,
+                    path: ctx.path,
+                    ...(stub ? { stubId: stub.id } : {}),
+                    bytes: sent.byteLength,
+                    afterMs: Date.now() - startTime
+                  })
+                  if (hook?.reset === true) yield* responseState.reset(id)
+                  if (hook?.variables !== undefined) yield* variables.merge(hook.variables)
+                  if (hook?.newState !== undefined && stub?.scenario !== undefined) {
+                    yield* scenarios.set(id, stub.scenario.name, hook.newState, yield* clocks.now(id), stub.id)
+                  }
+                })
+
+              // A streamed body is journaled once it ends, or once the client goes away before then,
+              // which is announced and sets off the response's `onDisconnect`
+              const journalWhenDone = (served: Response, headers: Record<string, string>) =>
+                watchBody(served, (sent, disconnected) =>
+                  Runtime.runFork(rt)(
+                    Effect.zipRight(
+                      record(served.status, headers, sent, disconnected),
+                      disconnected ? clientDisconnected(sent) : Effect.void
+                    )
+                  ))
+
+              // Events go out as they fall due, so the body is passed through rather than collected
+              if (streamed && response.body !== null) {
+                return journalWhenDone(response, Object.fromEntries(response.headers))
+              }
+
+              // Capture response for logging; bytes, not text, so binary bodies survive the round trip
+              let respBytes = new Uint8Array(yield* Effect.promise(() => response.arrayBuffer()))
+              let finalStatus = response.status
+              let finalHeaders = response.headers
+              if (conditional) {
+                const checked = serveConditional(ctx.method, request.headers, finalStatus, respBytes, finalHeaders)
+                finalStatus = checked.status
+                finalHeaders = checked.headers
+                respBytes = checked.body
+              }
+              if (range !== undefined && finalStatus === 200) {
+                const ranged = serveRange(range, request.headers, respBytes, finalHeaders)
+                finalStatus = ranged.status
+                finalHeaders = ranged.headers
+                respBytes = ranged.body
+              }
+              // Scheduled responses wait once rendered, so render time doesn't push them late. `at` is read
+              // on the imposter's clock; `after` counts real time, which is what the wait sleeps through.
+              if (schedule !== undefined) {
+                const elapsed = Date.now() - (yield* responseState.scenarioStart)
+                const wait = scheduledWait(schedule, elapsed, yield* clocks.now(id))
+                if (wait > MAX_SCHEDULED_WAIT_MS) {
+                  // Held this long, every matching connection would stay open for as long
+                  finalStatus = 500
+                  finalHeaders = new Headers({ "content-type": "application/json" })
+                  respBytes = new TextEncoder().encode(
+                    JSON.stringify({ error: "respondAt is too far ahead", limitMs: MAX_SCHEDULED_WAIT_MS })
+                  )
+                } else if (wait > 0) {
+                  yield* Effect.sleep(`${Math.ceil(wait)} millis`)
+                  injectedMs += Math.ceil(wait)
+                }
+              }
               const respHeaders: Record<string, string> = {}
-              response.headers.forEach((val, key) => {
+              finalHeaders.forEach((val, key) => {
                 respHeaders[key] = val
               })
-              // Reconstruct since .text() consumed body
-              response = new Response(respText, { status: response.status, headers: response.headers })
-
-              const logBody = respText.length > 10240 ? respText.slice(0, 10240) : (respText || undefined)
-
-              const duration = Date.now() - startTime
-              const logEntry: RequestLogEntry = {
-                id: NonEmptyString.make(crypto.randomUUID()),
-                imposterId: NonEmptyString.make(id),
-                timestamp: DateTime.unsafeMake(startTime),
-                request: {
-                  method: ctx.method,
-                  path: ctx.path,
-                  headers: ctx.headers,
-                  query: ctx.query,
-                  body: ctx.body
-                },
-                response: {
-                  status: response.status,
-                  headers: respHeaders,
-                  ...(logBody !== undefined ? { body: logBody } : {}),
-                  ...(stub ? { matchedStubId: NonEmptyString.make(stub.id) } : {}),
-                  proxied
-                },
-                duration
+              // Reconstruct since the body was consumed; a fault decides how much of it is sent
+              let sentBytes: Uint8Array = respBytes
+              if (fault !== undefined) {
+                const faulted = applyFault(fault, respBytes, { status: finalStatus, headers: finalHeaders })
+                response = faulted.response
+                sentBytes = faulted.sentBytes
+              } else if (drip !== undefined && respBytes.byteLength > 0) {
+                const dripped = dripResponse(respBytes, drip, { status: finalStatus, headers: finalHeaders })
+                return journalWhenDone(dripped, respHeaders)
+              } else {
+                response = new Response(respBytes.byteLength > 0 ? respBytes : null, {
+                  status: finalStatus,
+                  headers: finalHeaders
+                })
               }
-              yield* requestLogger.log(logEntry).pipe(Effect.catchAll(() => Effect.void))
-              yield* metricsService.recordRequest(logEntry).pipe(Effect.catchAll(() => Effect.void))
 
+              yield* record(response.status, respHeaders, sentBytes)
               return response
             }).pipe(
               Effect.catchAllCause((cause) =>
@@ -164,7 +619,8 @@ export const ImposterServerLive = Layer.effect(
                     { status: 500, headers: { "content-type": "application/json" } }
                   )
                 )
-              )
+              ),
+              Effect.ensuring(Effect.sync(() => releaseInFlight(drain, generation)))
             )
           )
         }
@@ -172,7 +628,20 @@ export const ImposterServerLive = Layer.effect(
         // Build the long-running fiber effect with acquireRelease
         const fiberEffect = Effect.acquireRelease(
           Effect.try({
-            try: () => serverFactory.create({ port: config.port, fetch: handler }),
+            try: () =>
+              serverFactory.create({
+                port: config.port,
+                fetch: handler,
+                onRejectedConnection: (rejected) =>
+                  Runtime.runFork(rt)(
+                    Effect.zipRight(
+                      metricsService.recordRejectedConnection(id, rejected),
+                      eventBus.publish("connection.rejected", id, { ...rejected })
+                    )
+                  ),
+                ...(config.tls !== undefined ? { tls: config.tls } : {}),
+                ...(config.httpParser !== undefined ? { httpParser: config.httpParser } : {})
+              }),
             catch: (err) =>
               new ImposterServerError({ imposterId: id, reason: `Failed to bind port ${config.port}: ${err}` })
           }),
@@ -209,6 +678,11 @@ export const ImposterServerLive = Layer.effect(
       Effect.gen(function*() {
         yield* fiberManager.stop(id)
         yield* Ref.update(stateMapRef, HashMap.remove(id))
+        yield* scenarios.reset(id)
+        yield* stores.clear(id)
+        yield* resources.reset(id)
+        yield* pages.reset(id)
+        yield* rateLimits.reset(id)
         yield* repo.update(id, (r) => ({
           ...r,
           config: ImposterConfig({ ...r.config, status: "stopped" })
@@ -237,8 +711,104 @@ export const ImposterServerLive = Layer.effect(
         }
       })
 
+    const updateExample = (id: string): Effect.Effect<void> =>
+      Effect.gen(function*() {
+        const record = yield* repo.get(id).pipe(Effect.catchAll(() => Effect.succeed(null)))
+        if (record === null) return
+        const state = HashMap.get(yield* Ref.get(stateMapRef), id)
+        if (state._tag === "Some") {
+          yield* Ref.set(state.value.exampleRef, record.config.example)
+        }
+      })
+
+    // Swap in the repository's stubs. In-flight requests finish against the old set;
+    // "stubs.swapped" is published once they have drained.
+    const replaceStubs = (id: string): Effect.Effect<void> =>
+      Effect.gen(function*() {
+        const stubs = yield* repo.getStubs(id).pipe(Effect.catchAll(() => Effect.succeed([] as ReadonlyArray<Stub>)))
+        const stateMap = yield* Ref.get(stateMapRef)
+        const state = HashMap.get(stateMap, id)
+        if (state._tag === "None") {
+          yield* eventBus.publish("stubs.swapped", id, { stubCount: stubs.length, drainedRequests: 0, drainMs: 0 })
+          return
+        }
+
+        const { drain, stubsRef } = state.value
+        yield* Ref.set(stubsRef, stubs)
+        drain.generation += 1
+        const generation = drain.generation
+        const drainedRequests = pendingBefore(drain, generation)
+        const startedAt = Date.now()
+
+        yield* Effect.gen(function*() {
+          while (pendingBefore(drain, generation) > 0 && Date.now() - startedAt < DRAIN_TIMEOUT_MS) {
+            yield* Effect.sleep("10 millis")
+          }
+          yield* eventBus.publish("stubs.swapped", id, {
+            generation,
+            stubCount: stubs.length,
+            drainedRequests,
+            drainMs: Date.now() - startedAt,
+            timedOut: pendingBefore(drain, generation) > 0
+          })
+        }).pipe(Effect.forkDaemon)
+      })
+
+    const resetState = (id: string, scopes: ReadonlyArray<ResetScope>): Effect.Effect<void> =>
+      Effect.gen(function*() {
+        const responseState = Option.map(HashMap.get(yield* Ref.get(stateMapRef), id), (s) => s.responseState)
+        if (scopes.includes("counters")) {
+          if (Option.isSome(responseState)) yield* responseState.value.resetCounters(id)
+          yield* rateLimits.reset(id)
+        }
+        if (scopes.includes("scenarios")) {
+          yield* scenarios.reset(id)
+          yield* clocks.clear(id)
+          if (Option.isSome(responseState)) yield* responseState.value.restartScenario
+        }
+        if (scopes.includes("state")) {
+          yield* stores.clear(id)
+          yield* resources.reset(id)
+          yield* pages.reset(id)
+        }
+      })
+
+    const resetResponses = (id: string): Effect.Effect<void> => resetState(id, RESET_SCOPES)
+
     const isRunning = (id: string): Effect.Effect<boolean> => fiberManager.isRunning(id)
 
-    return { start, stop, updateStubs, updateProxyConfig, isRunning } satisfies ImposterServerShape
+    const clockState = (id: string): Effect.Effect<ClockState> =>
+      Effect.map(
+        Effect.all([clocks.now(id), clocks.isSet(id)]),
+        ([now, set]) => ({ now: DateTime.unsafeMake(now), set })
+      )
+
+    return {
+      start,
+      stop,
+      updateStubs,
+      updateProxyConfig,
+      updateExample,
+      resetResponses,
+      resetState,
+      replaceStubs,
+      isRunning,
+      chaos: Ref.get(chaosRef),
+      setChaos: (chaos) => Ref.set(chaosRef, chaos),
+      release: releases.release,
+      held: releases.held,
+      scenarios: scenarios.states,
+      scenarioHistory: scenarios.history,
+      setScenario: (id, name, state) => Effect.flatMap(clocks.now(id), (at) => scenarios.set(id, name, state, at)),
+      resetScenarios: scenarios.reset,
+      store: stores.get,
+      replaceStore: stores.replace,
+      mergeStore: stores.merge,
+      clearStore: stores.clear,
+      clock: clockState,
+      setClock: (id, time) => Effect.zipRight(clocks.set(id, time), clockState(id)),
+      advanceClock: (id, ms) => Effect.zipRight(clocks.advance(id, ms), clockState(id)),
+      clearClock: (id) => Effect.zipRight(clocks.clear(id), clockState(id))
+    } satisfies ImposterServerShape
   })
 )
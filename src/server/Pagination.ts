@@ -0,0 +1,113 @@
+import { Effect, HashMap, Option, Ref } from "effect"
+import type { RequestContext } from "../matching/RequestMatcher"
+import type { PaginateConfig } from "../schemas/StubSchema"
+import type { ResourceAnswer } from "./Resources"
+
+// Cursors are opaque to clients, but only ever hold an offset
+export const encodeCursor = (offset: number): string => Buffer.from(`offset:${offset}`).toString("base64url")
+
+const decodeCursor = (cursor: string): number | undefined => {
+  const match = /^offset:(\d+)$/.exec(Buffer.from(cursor, "base64url").toString())
+  return match !== null ? Number(match[1]) : undefined
+}
+
+const wholeNumber = (value: string, min: number): number | undefined =>
+  /^\d+$/.test(value) && Number(value) >= min ? Number(value) : undefined
+
+const invalid = (param: string, value: string): ResourceAnswer => ({
+  status: 400,
+  body: { error: "Invalid pagination parameter", param, value }
+})
+
+/**
+ * The page of `dataset` `ctx` asks for. A `cursor` wins over an `offset`, which wins over a
+ * `page` (from 1); the links to the next and previous pages ask the same way the request did,
+ * keeping its other query parameters.
+ */
+export const pageOf = (
+  config: PaginateConfig,
+  dataset: ReadonlyArray<unknown>,
+  ctx: RequestContext
+): ResourceAnswer => {
+  const { cursor, limit: givenLimit, offset: givenOffset, page: givenPage } = ctx.query
+  const limit = givenLimit === undefined ? config.defaultLimit : wholeNumber(givenLimit, 1)
+  if (limit === undefined) return invalid("limit", givenLimit!)
+  const size = Math.min(limit, config.maxLimit)
+
+  const by = cursor !== undefined ? "cursor" : givenOffset !== undefined ? "offset" : "page"
+  const page = givenPage === undefined ? 1 : wholeNumber(givenPage, 1)
+  const offset = by === "cursor"
+    ? decodeCursor(cursor!)
+    : by === "offset"
+    ? wholeNumber(givenOffset!, 0)
+    : page !== undefined
+    ? (page - 1) * size
+    : undefined
+  if (offset === undefined) return invalid(by, ctx.query[by]!)
+
+  const total = dataset.length
+  const link = (to: number) => {
+    const query = new URLSearchParams(ctx.queryString ?? "")
+    for (const param of ["cursor", "offset", "page"]) query.delete(param)
+    if (givenLimit !== undefined) query.set("limit", String(size))
+    if (by === "cursor") query.set("cursor", encodeCursor(to))
+    else if (by === "offset") query.set("offset", String(to))
+    else query.set("page", String(Math.floor(to / size) + 1))
+    return `${ctx.path}?${query}`
+  }
+  const next = offset + size < total ? link(offset + size) : null
+  const prev = offset > 0 ? link(Math.max(0, offset - size)) : null
+  const links = [
+    ...(next !== null ? [`<${next}>; rel="next"`] : []),
+    ...(prev !== null ? [`<${prev}>; rel="prev"`] : [])
+  ]
+  return {
+    status: 200,
+    headers: { "x-total-count": String(total), ...(links.length > 0 ? { link: links.join(", ") } : {}) },
+    body: {
+      [config.itemsField]: dataset.slice(offset, offset + size),
+      total,
+      limit: size,
+      offset,
+      page: Math.floor(offset / size) + 1,
+      pages: Math.ceil(total / size),
+      next,
+      prev,
+      nextCursor: offset + size < total ? encodeCursor(offset + size) : null
+    }
+  }
+}
+
+// Each paginated stub's dataset, rendered on its first request and kept per imposter and stub until a reset
+export const makePages = Effect.gen(function*() {
+  const ref = yield* Ref.make(HashMap.empty<string, ReadonlyArray<unknown>>())
+
+  const serve = (
+    imposterId: string,
+    stubId: string,
+    config: PaginateConfig,
+    ctx: RequestContext,
+    render: (items: unknown) => Promise<unknown>
+  ): Effect.Effect<ResourceAnswer> =>
+    Effect.gen(function*() {
+      const key = `${imposterId}:${stubId}`
+      const kept = HashMap.get(yield* Ref.get(ref), key)
+      if (Option.isSome(kept)) return pageOf(config, kept.value, ctx)
+      const rendered = yield* Effect.promise(() => render(config.items))
+      if (!Array.isArray(rendered)) {
+        return { status: 500, body: { error: "Paginated items did not render to an array" } }
+      }
+      // Requests racing to render the dataset all page through the first one kept
+      const dataset = yield* Ref.modify(ref, (all) => {
+        const first = Option.getOrElse(HashMap.get(all, key), () => rendered)
+        return [first, HashMap.set(all, key, first)]
+      })
+      return pageOf(config, dataset, ctx)
+    })
+
+  // Renders the imposter's datasets afresh on their next request
+  const reset = (imposterId: string): Effect.Effect<void> =>
+    Ref.update(ref, HashMap.filter((_, key) => !key.startsWith(`${imposterId}:`)))
+
+  return { serve, reset }
+})
@@ -0,0 +1,42 @@
+import * as Option from "effect/Option"
+import * as Schema from "effect/Schema"
+import { bodyHash } from "../domain/bodies"
+import { isTextual } from "../matching/Media"
+import type { RequestContext } from "../matching/RequestMatcher"
+import { NULL_BODY_STATUSES } from "../matching/ResponseGenerator"
+import { CachedResponse } from "../schemas/StoreSchema"
+
+// Describe the body as it was received, not as the upstream framed it
+const FRAMING_HEADERS = new Set(["content-length", "content-encoding", "transfer-encoding", "connection"])
+
+// The store key an upstream answer to `ctx` is kept under: method, path, query and the body's hash
+export const cacheKey = (ctx: RequestContext): string => {
+  const query = ctx.queryString !== undefined && ctx.queryString !== "" ? `?${ctx.queryString}` : ""
+  const body = ctx.body !== undefined && ctx.body !== "" ? ` ${bodyHash(ctx.body)}` : ""
+  return `proxy:${ctx.method} ${ctx.path}${query}${body}`
+}
+
+export const toCached = async (response: Response): Promise<CachedResponse> => {
+  const headers: Record<string, string> = {}
+  response.headers.forEach((value, key) => {
+    if (!FRAMING_HEADERS.has(key)) headers[key] = value
+  })
+  const bytes = Buffer.from(await response.arrayBuffer())
+  const textual = bytes.byteLength === 0 || isTextual(response.headers.get("content-type"))
+  return {
+    status: response.status,
+    headers,
+    body: bytes.toString(textual ? "utf8" : "base64"),
+    encoding: textual ? "utf8" : "base64"
+  }
+}
+
+const decodeCached = Schema.decodeUnknownOption(CachedResponse)
+
+// The answer kept under a cache key, marked as served from the cache; undefined unless it is one
+export const fromCached = (value: unknown): Response | undefined =>
+  Option.getOrUndefined(Option.map(decodeCached(value), (cached) =>
+    new Response(
+      NULL_BODY_STATUSES.has(cached.status) ? null : Buffer.from(cached.body, cached.encoding),
+      { status: cached.status, headers: { ...cached.headers, "x-cache": "HIT" } }
+    )))
@@ -0,0 +1,64 @@
+import type { RangeMode } from "../schemas/StubSchema"
+
+// Inclusive byte offsets, as in `Content-Range`
+export interface ByteRange {
+  readonly start: number
+  readonly end: number
+}
+
+/**
+ * Parse a `Range` header against a body of `size` bytes. Only a single byte range is
+ * supported; anything else (other units, several ranges, syntax errors) is ignored as
+ * RFC 9110 allows, so the full body is served.
+ */
+export const parseRange = (header: string, size: number): ByteRange | "unsatisfiable" | undefined => {
+  const match = /^bytes=\s*(\d*)-(\d*)\s*$/.exec(header)
+  if (match === null) return undefined
+  const [, first = "", last = ""] = match
+  if (first === "") {
+    // Suffix range: the last n bytes
+    if (last === "") return undefined
+    const length = Number(last)
+    return length === 0 || size === 0 ? "unsatisfiable" : { start: Math.max(0, size - length), end: size - 1 }
+  }
+  const start = Number(first)
+  const end = last === "" ? Infinity : Number(last)
+  if (end < start) return undefined
+  return start >= size ? "unsatisfiable" : { start, end: Math.min(end, size - 1) }
+}
+
+export interface RangedBody {
+  readonly status: number
+  readonly headers: Headers
+  readonly body: Uint8Array<ArrayBuffer>
+}
+
+/**
+ * Answer a `Range` request for a complete 200 response. `honor` serves 206/416 by the
+ * book; `ignore` advertises range support but sends everything, `misalign` sends the
+ * right headers with bytes from the start of the body, and `unsatisfiable` always refuses.
+ */
+export const serveRange = (
+  mode: RangeMode,
+  request: Headers,
+  body: Uint8Array<ArrayBuffer>,
+  headers: Headers
+): RangedBody => {
+  const out = new Headers(headers)
+  out.set("accept-ranges", "bytes")
+  const header = request.get("range")
+  const ifRange = request.get("if-range")
+  // A stale If-Range means the client's partial copy is outdated: send it all
+  const fresh = ifRange === null || ifRange === headers.get("etag") || ifRange === headers.get("last-modified")
+  const range = header !== null && fresh ? parseRange(header, body.byteLength) : undefined
+  if (range === undefined || mode === "ignore") return { status: 200, headers: out, body }
+  if (range === "unsatisfiable" || mode === "unsatisfiable") {
+    out.set("content-range", `bytes */${body.byteLength}`)
+    out.delete("content-type")
+    return { status: 416, headers: out, body: new Uint8Array(0) }
+  }
+  out.set("content-range", `bytes ${range.start}-${range.end}/${body.byteLength}`)
+  const length = range.end - range.start + 1
+  const start = mode === "misalign" ? 0 : range.start
+  return { status: 206, headers: out, body: body.subarray(start, start + length) }
+}
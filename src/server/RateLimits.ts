@@ -0,0 +1,32 @@
+import { Effect, HashMap, Option, Ref } from "effect"
+import type { ResponseConfig, ResponseRateLimit } from "../schemas/StubSchema"
+
+// When each of an imposter's rate limits let a response through, by limit name
+type Windows = Readonly<Record<string, ReadonlyArray<number>>>
+
+// Each imposter's rate-limit windows, which its responses' `rateLimit`s count against
+export const makeRateLimits = Effect.gen(function*() {
+  const ref = yield* Ref.make(HashMap.empty<string, Windows>())
+
+  // Lets a response through at `now` unless the limit already did `requests` times within its window
+  const take = (imposterId: string, limit: ResponseRateLimit, now: number): Effect.Effect<boolean> =>
+    Ref.modify(ref, (all) => {
+      const windows = Option.getOrElse(HashMap.get(all, imposterId), (): Windows => ({}))
+      const recent = (Object.hasOwn(windows, limit.name) ? windows[limit.name]! : [])
+        .filter((at) => at > now - limit.windowMs)
+      const allowed = recent.length < limit.requests
+      const next = { ...windows, [limit.name]: allowed ? [...recent, now] : recent }
+      return [allowed, HashMap.set(all, imposterId, next)]
+    })
+
+  const reset = (imposterId: string): Effect.Effect<void> => Ref.update(ref, HashMap.remove(imposterId))
+
+  return { take, reset }
+})
+
+// What a response past its rate limit answers with instead
+export const overLimit = (limit: ResponseRateLimit): ResponseConfig => ({
+  status: limit.status,
+  ...(limit.headers !== undefined ? { headers: limit.headers } : {}),
+  ...(limit.body !== undefined ? { body: limit.body } : {})
+})
@@ -0,0 +1,72 @@
+import * as Effect from "effect/Effect"
+import type { ImposterRecord } from "../repositories/ImposterRepository"
+import type { DependencyProbe } from "../schemas/ImposterSchema"
+
+export interface DependencyTarget {
+  readonly url: string
+  readonly sources: ReadonlyArray<string>
+}
+
+// A callback URL that is known before any request: no template in it, and a URL as written
+const isFixedUrl = (url: string): boolean => !/\{\{|\$\{/.test(url) && URL.canParse(url)
+
+/**
+ * Upstream URLs an imposter depends on: its own proxy target, and the proxy targets and
+ * callback (webhook) URLs of its stubs. Callback URLs rendered from the request are left
+ * out. Each URL is listed once, with every place it is configured.
+ */
+export const dependencyTargets = (record: ImposterRecord): ReadonlyArray<DependencyTarget> => {
+  const targets = new Map<string, Array<string>>()
+  const add = (url: string, source: string) => {
+    const sources = targets.get(url) ?? []
+    if (!sources.includes(source)) sources.push(source)
+    targets.set(url, sources)
+  }
+
+  if (record.config.proxy !== undefined) add(record.config.proxy.targetUrl, "imposter")
+  for (const stub of record.stubs) {
+    for (const response of stub.responses) {
+      if (response.proxy !== undefined) add(response.proxy.targetUrl, `stub:${stub.id}`)
+      for (const callback of response.callbacks ?? []) {
+        if (isFixedUrl(callback.url)) add(callback.url, `stub:${stub.id}`)
+      }
+    }
+  }
+  return [...targets].map(([url, sources]) => ({ url, sources }))
+}
+
+// fetch() reports network failures as "fetch failed"; the useful detail is in its cause
+const describeFailure = (error: unknown, timeoutMs: number): string => {
+  if (!(error instanceof Error)) return String(error)
+  if (error.name === "TimeoutError") return `timed out after ${timeoutMs}ms`
+  const cause = error.cause as { code?: unknown } | undefined
+  return typeof cause?.code === "string" ? cause.code : error.message
+}
+
+/**
+ * Check that a target answers HTTP. Any response counts as reachable, whatever its
+ * status; only connection failures and timeouts do not.
+ */
+export const probeTarget = (target: DependencyTarget, timeoutMs: number): Effect.Effect<DependencyProbe> =>
+  Effect.gen(function*() {
+    const startedAt = Date.now()
+    const result = yield* Effect.tryPromise(() =>
+      fetch(target.url, { method: "GET", redirect: "manual", signal: AbortSignal.timeout(timeoutMs) }).then(
+        async (response) => {
+          await response.body?.cancel()
+          return response.status
+        }
+      )
+    ).pipe(Effect.either)
+    const latencyMs = Date.now() - startedAt
+
+    return result._tag === "Right"
+      ? { url: target.url, sources: target.sources, reachable: true, status: result.right, latencyMs }
+      : {
+        url: target.url,
+        sources: target.sources,
+        reachable: false,
+        latencyMs,
+        error: describeFailure(result.left.cause, timeoutMs)
+      }
+  })
@@ -0,0 +1,48 @@
+import { Deferred, Duration, Effect, Option, Ref } from "effect"
+import type { HeldRequests, ReleaseRequest } from "../schemas/ReleaseSchema"
+
+type Waiters = ReadonlyMap<string, ReadonlyArray<Deferred.Deferred<ReleaseRequest>>>
+
+const without = (waiters: Waiters, key: string, deferred: Deferred.Deferred<ReleaseRequest>): Waiters => {
+  const rest = (waiters.get(key) ?? []).filter((d) => d !== deferred)
+  const next = new Map(waiters)
+  if (rest.length > 0) next.set(key, rest)
+  else next.delete(key)
+  return next
+}
+
+/**
+ * Requests held on a key until it is released. A release lets go of the requests waiting at
+ * that moment; one made before a request arrives doesn't carry over to it.
+ */
+export const makeReleases = Effect.gen(function*() {
+  const ref = yield* Ref.make<Waiters>(new Map())
+
+  // None when `timeout` ms pass before a release
+  const wait = (key: string, timeout: number): Effect.Effect<Option.Option<ReleaseRequest>> =>
+    Effect.gen(function*() {
+      const deferred = yield* Deferred.make<ReleaseRequest>()
+      yield* Ref.update(ref, (waiters) => new Map(waiters).set(key, [...(waiters.get(key) ?? []), deferred]))
+      return yield* Deferred.await(deferred).pipe(
+        Effect.timeoutOption(Duration.millis(timeout)),
+        Effect.ensuring(Ref.update(ref, (waiters) => without(waiters, key, deferred)))
+      )
+    })
+
+  const release = (key: string, request: ReleaseRequest): Effect.Effect<number> =>
+    Ref.modify(ref, (waiters): readonly [ReadonlyArray<Deferred.Deferred<ReleaseRequest>>, Waiters] => {
+      const next = new Map(waiters)
+      next.delete(key)
+      return [waiters.get(key) ?? [], next]
+    }).pipe(
+      Effect.tap((held) => Effect.forEach(held, (deferred) => Deferred.succeed(deferred, request))),
+      Effect.map((held) => held.length)
+    )
+
+  const held: Effect.Effect<HeldRequests> = Effect.map(
+    Ref.get(ref),
+    (waiters) => Object.fromEntries([...waiters].map(([key, deferreds]) => [key, deferreds.length]))
+  )
+
+  return { wait, release, held }
+})
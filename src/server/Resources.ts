@@ -0,0 +1,203 @@
+import { Effect, HashMap, Option, Ref } from "effect"
+import type { RequestContext } from "../matching/RequestMatcher"
+import type { ResourceConfig } from "../schemas/StubSchema"
+import { preconditions } from "./Conditional"
+
+type Item = Readonly<Record<string, unknown>>
+
+// When an item was last written: its version, counted across the collection, and the time (epoch ms)
+export interface ItemVersion {
+  readonly version: number
+  readonly updatedAt: number
+}
+
+// A resource's items in the order they were created, and the next numeric id to hand out
+export interface Collection {
+  readonly items: ReadonlyArray<Item>
+  readonly nextId: number
+  // By id; each write takes the next `revision`, so no two versions of an item share one
+  readonly versions: ReadonlyMap<string, ItemVersion>
+  readonly revision: number
+}
+
+export interface ResourceAnswer {
+  readonly status: number
+  readonly headers?: Readonly<Record<string, string>>
+  readonly body?: unknown
+}
+
+const COLLECTION_METHODS = ["GET", "POST"]
+const ITEM_METHODS = ["GET", "PUT", "PATCH", "DELETE"]
+
+const isItem = (body: unknown): body is Item => body !== null && typeof body === "object" && !Array.isArray(body)
+
+const nextNumericId = (items: ReadonlyArray<Item>, idField: string, after = 0): number =>
+  items.reduce((next, item) => {
+    const id = Number(item[idField])
+    return Number.isInteger(id) && id >= next ? id + 1 : next
+  }, after + 1)
+
+// The collection `resource` starts with: its seed, with an id given to each item lacking one
+export const seedCollection = (resource: ResourceConfig, newId: () => string, now = Date.now()): Collection => {
+  let nextId = nextNumericId(resource.seed, resource.idField)
+  const items = resource.seed.map((item) => {
+    if (item[resource.idField] !== undefined) return item
+    const id = resource.ids === "number" ? nextId++ : newId()
+    return { ...item, [resource.idField]: id }
+  })
+  const versions = new Map(items.map((item) => [String(item[resource.idField]), { version: 1, updatedAt: now }]))
+  return { items, nextId, versions, revision: 1 }
+}
+
+// The validators an item is served with, which conditional requests compare against
+const validators = (version: ItemVersion) => ({
+  etag: `"v${version.version}"`,
+  "last-modified": new Date(version.updatedAt).toUTCString()
+})
+
+// The collection's own validators: a write to any item changes them
+const collectionValidators = (collection: Collection) => {
+  const updatedAt = Math.max(0, ...Array.from(collection.versions.values(), (v) => v.updatedAt))
+  return {
+    etag: `"r${collection.revision}"`,
+    ...(updatedAt > 0 ? { "last-modified": new Date(updatedAt).toUTCString() } : {})
+  }
+}
+
+// 412 or 304 in place of the answer when the request's preconditions say so
+const unmetPreconditions = (
+  ctx: RequestContext,
+  headers: Readonly<Record<string, string>>
+): ResourceAnswer | undefined => {
+  const outcome = preconditions(ctx.method, (name) => ctx.headers[name], headers.etag!, headers["last-modified"])
+  return outcome === 200 ? undefined : { status: outcome, headers }
+}
+
+/**
+ * What `path` names in the resource: "" for the collection, an id for one of its items, or
+ * undefined for a path outside it (or below an item).
+ */
+const idIn = (resource: ResourceConfig, path: string): string | undefined => {
+  const base = resource.path.replace(/\/+$/, "")
+  const rest = path.replace(/\/+$/, "")
+  if (rest === base) return ""
+  if (!rest.startsWith(`${base}/`)) return undefined
+  const id = rest.slice(base.length + 1)
+  if (id.includes("/")) return undefined
+  try {
+    return decodeURIComponent(id)
+  } catch {
+    return id
+  }
+}
+
+const notAllowed = (method: string, allow: ReadonlyArray<string>): ResourceAnswer => ({
+  status: 405,
+  headers: { allow: allow.join(", ") },
+  body: { error: "Method not allowed", method, allow }
+})
+
+/**
+ * Answers `ctx` from `collection` as a REST backend would, returning the answer and the
+ * collection as the request left it. POST, PUT and PATCH take a JSON object; a POST naming
+ * an id keeps it, unless an item already has it. Items carry an ETag and Last-Modified,
+ * and a write whose If-Match or If-Unmodified-Since fails is refused before it is made.
+ */
+export const serveResource = (
+  resource: ResourceConfig,
+  collection: Collection,
+  ctx: RequestContext,
+  newId: () => string,
+  now = Date.now()
+): readonly [ResourceAnswer, Collection] => {
+  const { idField } = resource
+  const id = idIn(resource, ctx.path)
+  const unchanged = (answer: ResourceAnswer) => [answer, collection] as const
+  if (id === undefined) return unchanged({ status: 404, body: { error: "Not found", path: ctx.path } })
+  const writes = ctx.method === "POST" || ctx.method === "PUT" || ctx.method === "PATCH"
+  if (writes && !isItem(ctx.body)) return unchanged({ status: 400, body: { error: "Expected a JSON object body" } })
+  const body = ctx.body as Item
+
+  const revision = collection.revision + 1
+  const written = (key: unknown) =>
+    new Map(collection.versions).set(String(key), { version: revision, updatedAt: now })
+
+  if (id === "") {
+    if (ctx.method === "GET") {
+      const headers = collectionValidators(collection)
+      return unchanged(unmetPreconditions(ctx, headers) ?? { status: 200, headers, body: collection.items })
+    }
+    if (ctx.method !== "POST") return unchanged(notAllowed(ctx.method, COLLECTION_METHODS))
+    const given = body[idField]
+    if (given !== undefined && collection.items.some((item) => String(item[idField]) === String(given))) {
+      return unchanged({ status: 409, body: { error: "An item with this id already exists", id: given } })
+    }
+    const created = given !== undefined
+      ? body
+      : { ...body, [idField]: resource.ids === "number" ? collection.nextId : newId() }
+    const location = `${resource.path.replace(/\/+$/, "")}/${encodeURIComponent(String(created[idField]))}`
+    const versions = written(created[idField])
+    return [
+      { status: 201, headers: { location, ...validators(versions.get(String(created[idField]))!) }, body: created },
+      {
+        items: [...collection.items, created],
+        nextId: nextNumericId([created], idField, collection.nextId - 1),
+        versions,
+        revision
+      }
+    ]
+  }
+
+  const index = collection.items.findIndex((item) => String(item[idField]) === id)
+  if (!ITEM_METHODS.includes(ctx.method)) return unchanged(notAllowed(ctx.method, ITEM_METHODS))
+  if (index === -1) return unchanged({ status: 404, body: { error: "Item not found", id } })
+  const current = collection.items[index]!
+  const key = String(current[idField])
+  const headers = validators(collection.versions.get(key) ?? { version: collection.revision, updatedAt: now })
+  const unmet = unmetPreconditions(ctx, headers)
+  if (unmet !== undefined) return unchanged(unmet)
+  if (ctx.method === "GET") return unchanged({ status: 200, headers, body: current })
+  if (ctx.method === "DELETE") {
+    const versions = new Map(collection.versions)
+    versions.delete(key)
+    return [
+      { status: 204 },
+      { ...collection, items: collection.items.filter((_, i) => i !== index), versions, revision }
+    ]
+  }
+  // The id stays the one in the path, whatever the body says
+  const updated = { ...(ctx.method === "PATCH" ? current : {}), ...body, [idField]: current[idField] }
+  const versions = written(key)
+  return [
+    { status: 200, headers: validators(versions.get(key)!), body: updated },
+    { ...collection, items: collection.items.map((item, i) => i === index ? updated : item), versions, revision }
+  ]
+}
+
+export const resourceResponse = (answer: ResourceAnswer): Response =>
+  answer.body === undefined
+    ? new Response(null, { status: answer.status, headers: answer.headers ?? {} })
+    : new Response(JSON.stringify(answer.body), {
+      status: answer.status,
+      headers: { ...answer.headers, "content-type": "application/json" }
+    })
+
+// Each resource's collection, kept per imposter and stub from its first request until a reset
+export const makeResources = Effect.gen(function*() {
+  const ref = yield* Ref.make(HashMap.empty<string, Collection>())
+  const newId = () => crypto.randomUUID()
+
+  const serve = (imposterId: string, stubId: string, resource: ResourceConfig, ctx: RequestContext, now: number) =>
+    Ref.modify(ref, (all) => {
+      const key = `${imposterId}:${stubId}`
+      const current = Option.getOrElse(HashMap.get(all, key), () => seedCollection(resource, newId, now))
+      const [answer, next] = serveResource(resource, current, ctx, newId, now)
+      return [answer, HashMap.set(all, key, next)]
+    })
+
+  // Sends the imposter's resources back to their seeds
+  const reset = (imposterId: string): Effect.Effect<void> =>
+    Ref.update(ref, HashMap.filter((_, key) => !key.startsWith(`${imposterId}:`)))
+
+  return { serve, reset }
+})
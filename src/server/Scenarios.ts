@@ -0,0 +1,67 @@
+import { DateTime, Effect, HashMap, Option, Ref } from "effect"
+import type { ScenarioTransition } from "../schemas/ScenarioSchema"
+import type { Stub } from "../schemas/StubSchema"
+
+// Where every scenario starts, and goes back to on reset
+export const STARTED = "Started"
+
+export type ScenarioStates = Readonly<Record<string, string>>
+
+// Transitions kept per imposter; the oldest go first
+export const MAX_TRANSITIONS = 1000
+
+// The state scenario `name` is in; names every object has, such as `constructor`, are scenarios like any other
+export const stateOf = (states: ScenarioStates, name: string): string =>
+  Object.hasOwn(states, name) ? states[name]! : STARTED
+
+// Whether `stub` may match while its imposter's scenarios are in `states`
+export const inState = (states: ScenarioStates) => (stub: Stub): boolean =>
+  stub.scenario?.requiredState === undefined ||
+  stateOf(states, stub.scenario.name) === stub.scenario.requiredState
+
+// Every scenario the stubs name or that was moved, in its current state
+export const scenarioStates = (stubs: ReadonlyArray<Stub>, states: ScenarioStates): ScenarioStates => {
+  const names = new Set([...stubs.flatMap((stub) => stub.scenario !== undefined ? [stub.scenario.name] : [])])
+  for (const name of Object.keys(states)) names.add(name)
+  return Object.fromEntries([...names].sort().map((name) => [name, stateOf(states, name)]))
+}
+
+// The state each imposter's scenarios have moved to, and the transitions that took them there;
+// scenarios never moved are in STARTED
+export const makeScenarios = Effect.gen(function*() {
+  const ref = yield* Ref.make(HashMap.empty<string, ScenarioStates>())
+  const historyRef = yield* Ref.make(HashMap.empty<string, ReadonlyArray<ScenarioTransition>>())
+
+  const states = (imposterId: string): Effect.Effect<ScenarioStates> =>
+    Effect.map(Ref.get(ref), (all) => Option.getOrElse(HashMap.get(all, imposterId), () => ({})))
+
+  const history = (imposterId: string): Effect.Effect<ReadonlyArray<ScenarioTransition>> =>
+    Effect.map(Ref.get(historyRef), (all) => Option.getOrElse(HashMap.get(all, imposterId), () => []))
+
+  // Moves the scenario at `at` (epoch ms), by `stubId`'s response or by hand when there is none
+  const set = (imposterId: string, name: string, state: string, at: number, stubId?: string): Effect.Effect<void> =>
+    Effect.gen(function*() {
+      const from = yield* Ref.modify(ref, (all) => {
+        const current = Option.getOrElse(HashMap.get(all, imposterId), () => ({}))
+        return [stateOf(current, name), HashMap.set(all, imposterId, { ...current, [name]: state })]
+      })
+      const transition: ScenarioTransition = {
+        scenario: name,
+        from,
+        to: state,
+        ...(stubId !== undefined ? { stubId } : {}),
+        at: DateTime.unsafeMake(at)
+      }
+      yield* Ref.update(historyRef, (all) =>
+        HashMap.set(
+          all,
+          imposterId,
+          [...Option.getOrElse(HashMap.get(all, imposterId), () => []), transition].slice(-MAX_TRANSITIONS)
+        ))
+    })
+
+  const reset = (imposterId: string): Effect.Effect<void> =>
+    Effect.zipRight(Ref.update(ref, HashMap.remove(imposterId)), Ref.update(historyRef, HashMap.remove(imposterId)))
+
+  return { states, history, set, reset }
+})
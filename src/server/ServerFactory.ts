@@ -1,60 +1,228 @@
 import { Context, Layer } from "effect"
 import * as http from "node:http"
+import * as https from "node:https"
+import type { Duplex } from "node:stream"
+import { progressiveResponses } from "./Drip"
+import { ConnectionResetError, rawResponses } from "./Faults"
+import { serverOptions, type TlsSettings } from "./Tls"
 
 export interface ServerInstance {
   readonly port: number
   readonly stop: (closeActive: boolean) => void
 }
 
+// A connection dropped because what it sent wasn't an HTTP request
+export interface RejectedConnection {
+  // "tls": a TLS handshake on a plain HTTP port; "http2": HTTP/2 without negotiation; "malformed": anything else
+  readonly kind: "tls" | "http2" | "malformed"
+  // The parser's error code, e.g. HPE_INVALID_METHOD
+  readonly reason: string
+  // The first bytes received, in hex
+  readonly sample: string
+  readonly remoteAddress?: string
+}
+
+// "lenient" accepts requests the HTTP parser would refuse: invalid header characters,
+// Content-Length alongside Transfer-Encoding, and the like
+export type HttpParserMode = "strict" | "lenient"
+
 export interface ServerFactoryShape {
   readonly create: (options: {
     readonly port: number
     readonly fetch: (request: Request) => Promise<Response>
+    // Serve HTTPS instead of HTTP
+    readonly tls?: TlsSettings
+    readonly httpParser?: HttpParserMode
+    // Told about connections that were dropped without reaching `fetch`
+    readonly onRejectedConnection?: (rejected: RejectedConnection) => void
   }) => ServerInstance
 }
 
 export class ServerFactory extends Context.Tag("ServerFactory")<ServerFactory, ServerFactoryShape>() {}
 
+const SAMPLE_BYTES = 32
+const HTTP2_PREFACE = Buffer.from("PRI * HTTP/2.0")
+
+/**
+ * What a connection the HTTP parser gave up on was sending, from the parser's error code
+ * and the bytes it was looking at.
+ */
+export const classifyRejected = (reason: string, raw: Uint8Array | undefined): RejectedConnection => {
+  const bytes = Buffer.from(raw ?? [])
+  const http2 = bytes.subarray(0, HTTP2_PREFACE.length).equals(HTTP2_PREFACE)
+  return {
+    // 0x16 opens a TLS record carrying a handshake
+    kind: bytes[0] === 0x16 ? "tls" : http2 ? "http2" : "malformed",
+    reason,
+    sample: bytes.subarray(0, SAMPLE_BYTES).toString("hex")
+  }
+}
+
+// What diagnoseRequest found wrong with each request passed to `fetch`, when anything was
+export const requestDiagnostics = new WeakMap<Request, ReadonlyArray<string>>()
+
+// RFC 9110 token characters
+const TOKEN = /^[!#$%&'*+\-.^_`|~0-9A-Za-z]+$/
+// Control characters other than tab
+const CONTROL = /[\u0000-\u0008\u000a-\u001f\u007f]/
+
+/**
+ * What is wrong with a request's headers, from Node's `rawHeaders` (names and values
+ * alternating, as sent): framing a proxy and a server could read differently, and names or
+ * values no standard parser accepts.
+ */
+export const diagnoseRequest = (rawHeaders: ReadonlyArray<string>): Array<string> => {
+  const found: Array<string> = []
+  const lengths: Array<string> = []
+  const encodings: Array<string> = []
+  for (let i = 0; i + 1 < rawHeaders.length; i += 2) {
+    const name = rawHeaders[i]!
+    const value = rawHeaders[i + 1]!
+    if (!TOKEN.test(name)) found.push(`Invalid header name ${JSON.stringify(name)}`)
+    if (CONTROL.test(value)) found.push(`Control characters in the value of ${JSON.stringify(name)}`)
+    const lower = name.trim().toLowerCase()
+    if (lower === "content-length") lengths.push(value.trim())
+    if (lower === "transfer-encoding") encodings.push(value.trim())
+  }
+  if (lengths.length > 0 && encodings.length > 0) found.push("Both Content-Length and Transfer-Encoding are set")
+  if (lengths.length > 1) {
+    found.push(
+      new Set(lengths).size > 1
+        ? `Conflicting Content-Length headers: ${lengths.join(", ")}`
+        : "Content-Length is repeated"
+    )
+  }
+  for (const length of lengths) {
+    if (!/^\d+$/.test(length)) found.push(`Invalid Content-Length ${JSON.stringify(length)}`)
+  }
+  if (encodings.length > 0) {
+    const codings = encodings.join(",").split(",").map((c) => c.trim().toLowerCase())
+    if (codings.at(-1) !== "chunked") found.push(`Transfer-Encoding does not end in chunked: ${encodings.join(", ")}`)
+  }
+  return found
+}
+
+const pipeProgressive = async (body: ReadableStream<Uint8Array>, res: http.ServerResponse) => {
+  const reader = body.getReader()
+  res.on("close", () => reader.cancel().catch(() => undefined))
+  try {
+    while (true) {
+      const { done, value } = await reader.read()
+      if (done || res.destroyed) break
+      res.write(value)
+    }
+    res.end()
+  } catch {
+    res.destroy()
+  }
+}
+
 export const NodeServerFactoryLive = Layer.succeed(ServerFactory, {
   create: (options): ServerInstance => {
-    const server = http.createServer(async (req, res) => {
+    const scheme = options.tls !== undefined ? "https" : "http"
+    const listener: http.RequestListener = async (req, res) => {
       try {
-        const url = `http://localhost:${options.port}${req.url}`
+        const url = `${scheme}://localhost:${options.port}${req.url}`
         const headers = new Headers()
         for (const [key, val] of Object.entries(req.headers)) {
-          if (val) headers.set(key, Array.isArray(val) ? val.join(", ") : val)
+          try {
+            if (val) headers.set(key, Array.isArray(val) ? val.join(", ") : val)
+          } catch {
+            // Only a lenient parser lets through headers Headers refuses; the diagnostics name them
+          }
         }
 
-        let body: string | undefined
+        // Kept as the bytes received, so compressed and binary uploads reach decoding and signing unaltered
+        let body: Buffer | undefined
         if (req.method !== "GET" && req.method !== "HEAD") {
-          body = await new Promise<string>((resolve) => {
-            let data = ""
+          body = await new Promise<Buffer>((resolve) => {
+            const chunks: Array<Buffer> = []
             req.on("data", (chunk: Buffer) => {
-              data += chunk.toString()
+              chunks.push(chunk)
             })
-            req.on("end", () => resolve(data))
+            req.on("end", () => resolve(Buffer.concat(chunks)))
           })
         }
 
         const request = new Request(url, {
           method: req.method ?? "GET",
           headers,
-          ...(body !== undefined && body !== "" ? { body } : {})
+          ...(body !== undefined && body.length > 0 ? { body } : {})
         })
 
+        const diagnostics = diagnoseRequest(req.rawHeaders)
+        if (diagnostics.length > 0) requestDiagnostics.set(request, diagnostics)
+
         const response = await options.fetch(request)
+        const raw = rawResponses.get(response)
+        if (raw !== undefined) {
+          // Malformed on purpose by a fault, so written past Node's HTTP framing
+          req.socket.end(raw)
+          return
+        }
 
         const respHeaders: Record<string, string> = {}
         response.headers.forEach((val, key) => {
           respHeaders[key] = val
         })
+        // Event streams and dripped bodies are written as they arrive, so each piece reaches the client when
+        // it is sent
+        const progressive = progressiveResponses.has(response) ||
+          respHeaders["content-type"]?.startsWith("text/event-stream") === true
+        if (response.body !== null && progressive) {
+          res.writeHead(response.status, respHeaders)
+          await pipeProgressive(response.body, res)
+          return
+        }
+        // Collect the body so a stream that fails part-way (e.g. a truncate fault) still sends what it had
+        const chunks: Array<Uint8Array> = []
+        let failed = false
+        if (response.body !== null) {
+          const reader = response.body.getReader()
+          try {
+            while (true) {
+              const { done, value } = await reader.read()
+              if (done) break
+              chunks.push(value)
+            }
+          } catch (err) {
+            if (err instanceof ConnectionResetError) {
+              // Nothing is written: the client sees the connection reset by peer
+              req.socket.resetAndDestroy()
+              return
+            }
+            failed = true
+          }
+        }
         res.writeHead(response.status, respHeaders)
-        const respBody = await response.text()
-        res.end(respBody)
+        if (failed) {
+          // Send the partial body, then drop the connection as a failing upstream would
+          res.write(Buffer.concat(chunks), () => res.destroy())
+          return
+        }
+        res.end(Buffer.concat(chunks))
       } catch (err) {
         res.writeHead(500)
         res.end(JSON.stringify({ error: "Internal server error", details: String(err) }))
       }
+    }
+    const parser = { insecureHTTPParser: options.httpParser === "lenient" }
+    const server = options.tls !== undefined
+      ? https.createServer({ ...serverOptions(options.tls), ...parser }, listener)
+      : http.createServer(parser, listener)
+
+    // Parse errors only: resets and timeouts are clients going away, not sending the wrong thing
+    server.on("clientError", (err: Error & { code?: string; rawPacket?: Buffer }, socket: Duplex) => {
+      if (err.code?.startsWith("HPE_")) {
+        const rejected = classifyRejected(err.code, err.rawPacket)
+        const remoteAddress = (socket as Partial<{ remoteAddress: string }>).remoteAddress
+        options.onRejectedConnection?.(remoteAddress !== undefined ? { ...rejected, remoteAddress } : rejected)
+        if (rejected.kind === "malformed" && socket.writable) {
+          socket.end("HTTP/1.1 400 Bad Request\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+          return
+        }
+      }
+      socket.destroy()
     })
 
     server.listen(options.port)
@@ -71,6 +239,14 @@ export const NodeServerFactoryLive = Layer.succeed(ServerFactory, {
   }
 })
 
+// Bun picks a certificate by `serverName` from a list; the entry without one is the default
+const bunTls = (settings: TlsSettings) => [
+  { cert: settings.cert, key: settings.key },
+  ...Object.entries(settings.hosts ?? {}).map(([serverName, c]) => ({ serverName, cert: c.cert, key: c.key }))
+]
+
 export const BunServerFactoryLive = Layer.succeed(ServerFactory, {
-  create: (options) => (globalThis as any).Bun.serve(options)
+  // Bun has no hook for connections it can't parse, so none are reported, and its parser is always strict
+  create: ({ httpParser: _parser, onRejectedConnection: _, tls, ...options }) =>
+    (globalThis as any).Bun.serve(tls !== undefined ? { ...options, tls: bunTls(tls) } : options)
 })
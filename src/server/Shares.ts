@@ -0,0 +1,81 @@
+import { createHmac, timingSafeEqual } from "node:crypto"
+import { accessError } from "../api/ApiErrors"
+
+// Where a token given in a link's query is kept for the requests the page goes on to make
+export const TOKEN_COOKIE = "imposters_token"
+
+const SAFE_METHODS = new Set(["GET", "HEAD"])
+
+// Reads that hand out secrets, such as the TLS private keys in an export, are for the admin token only
+const SECRET_PATHS = new Set(["/export"])
+
+const sign = (secret: string, expires: string) =>
+  createHmac("sha256", secret).update(`share:${expires}`).digest("base64url")
+
+const sameText = (a: string, b: string) => {
+  const [x, y] = [Buffer.from(a), Buffer.from(b)]
+  return x.length === y.length && timingSafeEqual(x, y)
+}
+
+/**
+ * A read-only token valid until `expiresAt` (epoch ms): the expiry and an HMAC of it keyed
+ * by the admin token. Nothing is stored, so changing the admin token revokes every share.
+ */
+export const issueShare = (secret: string, expiresAt: number): string => {
+  const expires = expiresAt.toString(36)
+  return `share.${expires}.${sign(secret, expires)}`
+}
+
+// The expiry of a share `secret` signed, or undefined for any other token
+export const shareExpiry = (secret: string, token: string): number | undefined => {
+  const [prefix, expires, signature, ...rest] = token.split(".")
+  if (prefix !== "share" || expires === undefined || signature === undefined || rest.length > 0) return undefined
+  return sameText(signature, sign(secret, expires)) ? parseInt(expires, 36) : undefined
+}
+
+export type Access = "admin" | "read" | "none"
+
+// What `token` lets a request do while the admin API is protected by `adminToken`
+export const accessOf = (adminToken: string, token: string | undefined, now: number): Access => {
+  if (token === undefined) return "none"
+  if (sameText(token, adminToken)) return "admin"
+  const expiry = shareExpiry(adminToken, token)
+  return expiry !== undefined && expiry > now ? "read" : "none"
+}
+
+// A bearer token, else a `token` query parameter as links carry it, else the cookie one of those left
+const requestToken = (request: Request): { readonly token: string; readonly fromQuery: boolean } | undefined => {
+  const bearer = request.headers.get("authorization")?.match(/^Bearer\s+(\S+)$/i)?.[1]
+  if (bearer !== undefined) return { token: bearer, fromQuery: false }
+  const query = new URL(request.url).searchParams.get("token")
+  if (query !== null) return { token: query, fromQuery: true }
+  const cookie = (request.headers.get("cookie") ?? "").split(/;\s*/).find((c) => c.startsWith(`${TOKEN_COOKIE}=`))
+  return cookie !== undefined
+    ? { token: decodeURIComponent(cookie.slice(TOKEN_COOKIE.length + 1)), fromQuery: false }
+    : undefined
+}
+
+/**
+ * The admin handler behind `adminToken`, when set: requests need the token, or a share to
+ * read with. A token from the query is kept in a cookie, so the pages a shared link opens
+ * and the requests they make carry it too.
+ */
+export const guardAdmin = (
+  adminToken: string | undefined,
+  handler: (request: Request) => Promise<Response>
+) =>
+async (request: Request): Promise<Response> => {
+  if (adminToken === undefined) return handler(request)
+  const found = requestToken(request)
+  const access = accessOf(adminToken, found?.token, Date.now())
+  if (access === "none") return accessError("unauthorized", "A valid admin token or share is required")
+  const path = new URL(request.url).pathname.replace(/\/+$/, "")
+  if (access === "read" && (!SAFE_METHODS.has(request.method.toUpperCase()) || SECRET_PATHS.has(path))) {
+    return accessError("read_only", `A share can't ${request.method.toUpperCase()} ${path}`)
+  }
+  const response = await handler(request)
+  if (found?.fromQuery !== true) return response
+  const headers = new Headers(response.headers)
+  headers.append("set-cookie", `${TOKEN_COOKIE}=${encodeURIComponent(found.token)}; Path=/; HttpOnly; SameSite=Strict`)
+  return new Response(response.body, { status: response.status, statusText: response.statusText, headers })
+}
@@ -0,0 +1,87 @@
+import { Effect, HashMap, Option, Ref } from "effect"
+import type { TemplateStore } from "../matching/ExpressionEvaluator"
+import type { RequestContext } from "../matching/RequestMatcher"
+import type { StoreValues } from "../schemas/StoreSchema"
+import type { StubCapture } from "../schemas/StubSchema"
+
+const withoutNulls = (values: StoreValues): StoreValues =>
+  Object.fromEntries(Object.entries(values).filter(([, value]) => value !== null))
+
+// Each imposter's key-value store; an imposter that never wrote one has an empty store
+export const makeStores = Effect.gen(function*() {
+  const ref = yield* Ref.make(HashMap.empty<string, StoreValues>())
+
+  const get = (imposterId: string): Effect.Effect<StoreValues> =>
+    Effect.map(Ref.get(ref), (all) => Option.getOrElse(HashMap.get(all, imposterId), () => ({})))
+
+  const update = (imposterId: string, f: (current: StoreValues) => StoreValues): Effect.Effect<StoreValues> =>
+    Ref.modify(ref, (all) => {
+      const next = withoutNulls(f(Option.getOrElse(HashMap.get(all, imposterId), () => ({}))))
+      return [next, HashMap.set(all, imposterId, next)]
+    })
+
+  // Sets the given keys and keeps the rest; a null value removes one
+  const merge = (imposterId: string, values: StoreValues) =>
+    update(imposterId, (current) => ({ ...current, ...values }))
+
+  const replace = (imposterId: string, values: StoreValues) => update(imposterId, () => values)
+
+  const clear = (imposterId: string): Effect.Effect<void> => Ref.update(ref, HashMap.remove(imposterId))
+
+  return { get, merge, replace, clear }
+})
+
+/**
+ * The store as a response's templates see it while they render: reads see the writes made
+ * before them, and the writes are kept to be saved once the response is built.
+ */
+export const storeView = (values: StoreValues): TemplateStore & { readonly writes: () => StoreValues } => {
+  const current: Record<string, unknown> = { ...values }
+  const writes: Record<string, unknown> = {}
+  return {
+    get: (key) => Object.hasOwn(current, key) ? current[key] : undefined,
+    set: (key, value) => {
+      current[key] = value
+      writes[key] = value
+    },
+    values: () => current,
+    writes: () => writes
+  }
+}
+
+// A field of the parsed body, or of the form body when it didn't parse as JSON
+const bodyField = (ctx: RequestContext, path: ReadonlyArray<string>): unknown => {
+  let value = path.length === 0 || (ctx.body !== null && typeof ctx.body === "object") ? ctx.body : ctx.form
+  for (const key of path) {
+    if (value === null || typeof value !== "object" || !Object.hasOwn(value, key)) return undefined
+    value = (value as Record<string, unknown>)[key]
+  }
+  return value
+}
+
+const captureSource = (ctx: RequestContext, source: string): unknown => {
+  const [from, ...path] = source.split(".")
+  const name = path.join(".")
+  switch (from) {
+    case "params":
+      return ctx.params?.[name]
+    case "headers":
+      return ctx.headers[name.toLowerCase()]
+    case "query":
+      return ctx.query[name]
+    default:
+      return bodyField(ctx, path)
+  }
+}
+
+/**
+ * What a stub's `capture` takes from the request it matched, keyed by store key. Sources the
+ * request lacks are left out, so they don't overwrite what an earlier request captured.
+ */
+export const captureValues = (capture: StubCapture, ctx: RequestContext): StoreValues =>
+  Object.fromEntries(
+    Object.entries(capture).flatMap(([key, source]) => {
+      const value = captureSource(ctx, source)
+      return value === undefined ? [] : [[key, value]]
+    })
+  )
@@ -0,0 +1,62 @@
+import * as tls from "node:tls"
+
+// A PEM certificate chain and the private key it was issued for
+export interface TlsCertificate {
+  readonly cert: string
+  readonly key: string
+}
+
+// The certificate served by default, plus certificates picked by the client's SNI host name
+export interface TlsSettings extends TlsCertificate {
+  readonly hosts?: Readonly<Record<string, TlsCertificate>> | undefined
+}
+
+// Why a certificate/key pair can't be served, or undefined when it can
+export const invalidCertificate = (certificate: TlsCertificate): string | undefined => {
+  try {
+    tls.createSecureContext(certificate)
+    return undefined
+  } catch (e) {
+    return e instanceof Error ? e.message : String(e)
+  }
+}
+
+// "api.example.com" matches itself; "*.example.com" matches exactly one more label, as in certificates
+export const matchServerName = (pattern: string, servername: string): boolean => {
+  const name = servername.toLowerCase()
+  const p = pattern.toLowerCase()
+  if (!p.startsWith("*.")) return p === name
+  const dot = name.indexOf(".")
+  return dot > 0 && name.slice(dot) === p.slice(1)
+}
+
+/**
+ * The host pattern whose certificate answers `servername`: an exact name wins over a
+ * wildcard. Undefined when none applies (or the client sent no SNI), meaning the default.
+ */
+export const selectHost = (
+  hosts: Readonly<Record<string, TlsCertificate>>,
+  servername: string | undefined
+): string | undefined => {
+  if (servername === undefined || servername === "") return undefined
+  const patterns = Object.keys(hosts)
+  return patterns.find((p) => !p.startsWith("*.") && matchServerName(p, servername)) ??
+    patterns.find((p) => matchServerName(p, servername))
+}
+
+/**
+ * Options for `https.createServer`: the default certificate, and an SNI callback that
+ * switches to a host's certificate. Secure contexts are built once, up front.
+ */
+export const serverOptions = (settings: TlsSettings): tls.TlsOptions => {
+  const hosts = settings.hosts ?? {}
+  const contexts = new Map(Object.entries(hosts).map(([pattern, c]) => [pattern, tls.createSecureContext(c)]))
+  return {
+    cert: settings.cert,
+    key: settings.key,
+    SNICallback: (servername, callback) => {
+      const host = selectHost(hosts, servername)
+      callback(null, host !== undefined ? contexts.get(host) : undefined)
+    }
+  }
+}
@@ -1,4 +1,4 @@
-import { Config, Context, Layer } from "effect"
+import { Config, Context, Layer, type Option, type Redacted } from "effect"
 
 export interface AppConfigShape {
   readonly adminPort: number
@@ -6,6 +6,8 @@ export interface AppConfigShape {
   readonly portRangeMax: number
   readonly maxImposters: number
   readonly logLevel: "debug" | "info" | "warn" | "error"
+  // Required of admin requests when set; also the key share links are signed with
+  readonly adminToken: Option.Option<Redacted.Redacted>
 }
 
 export class AppConfig extends Context.Tag("AppConfig")<AppConfig, AppConfigShape>() {}
@@ -16,7 +18,8 @@ const config = Config.all({
   portRangeMax: Config.number("PORT_RANGE_MAX").pipe(Config.withDefault(4000)),
   maxImposters: Config.number("MAX_IMPOSTERS").pipe(Config.withDefault(100)),
   logLevel: Config.literal("debug", "info", "warn", "error")("LOG_LEVEL")
-    .pipe(Config.withDefault("info" as const))
+    .pipe(Config.withDefault("info" as const)),
+  adminToken: Config.option(Config.redacted("ADMIN_TOKEN"))
 })
 
 export const AppConfigLive = Layer.effect(AppConfig, config)
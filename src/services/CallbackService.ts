@@ -0,0 +1,222 @@
+import { Context, Data, Effect, Either, Layer, Ref } from "effect"
+import * as DateTime from "effect/DateTime"
+import type { RequestContext } from "../matching/RequestMatcher"
+import { applyTemplates } from "../matching/TemplateEngine"
+import type { CallbackDelivery, CallbackRequest, FailedCallback } from "../schemas/CallbackSchema"
+import { NonEmptyString } from "../schemas/common"
+import type { CallbackConfig, CallbackRetry } from "../schemas/StubSchema"
+import { outboundFetch } from "./Outbound"
+import type { JournalOutbound } from "./RequestLogger"
+import { Uuid } from "./Uuid"
+
+const MAX_FAILED = 100
+
+// Callbacks without a `retry` policy are attempted once
+const NO_RETRY: CallbackRetry = { maxAttempts: 1, initialDelay: 0, multiplier: 1, maxDelay: 0 }
+
+export class CallbackNotFoundError extends Data.TaggedError("CallbackNotFoundError")<{
+  readonly id: string
+}> {}
+
+// Delay before attempt `attempt` (1-based; the first attempt is not delayed)
+export const backoffDelay = (retry: CallbackRetry, attempt: number): number =>
+  attempt <= 1 ? 0 : Math.min(retry.maxDelay, retry.initialDelay * retry.multiplier ** (attempt - 2))
+
+/**
+ * Render a callback's templates against the request that triggered it.
+ * Object bodies are sent as JSON.
+ */
+export const renderCallback = async (callback: CallbackConfig, ctx: RequestContext): Promise<CallbackRequest> => {
+  const url = String(await applyTemplates(ctx, callback.url))
+  const headers: Record<string, string> = {}
+  for (const [key, val] of Object.entries(callback.headers ?? {})) {
+    headers[key] = String(await applyTemplates(ctx, val))
+  }
+  if (callback.body === undefined) return { url, method: callback.method, headers }
+  const rendered = await applyTemplates(ctx, callback.body)
+  const hasContentType = Object.keys(headers).some((key) => key.toLowerCase() === "content-type")
+  if (typeof rendered !== "string" && !hasContentType) headers["content-type"] = "application/json"
+  return {
+    url,
+    method: callback.method,
+    headers,
+    body: typeof rendered === "string" ? rendered : JSON.stringify(rendered)
+  }
+}
+
+interface DeadLetter {
+  readonly entry: FailedCallback
+  readonly callback: CallbackConfig
+  readonly journal: JournalOutbound | undefined
+}
+
+export interface CallbackServiceShape {
+  // Deliver in the background; callbacks that fail every attempt go to the dead-letter list
+  readonly dispatch: (
+    imposterId: string,
+    stubId: string | undefined,
+    callback: CallbackConfig,
+    ctx: RequestContext,
+    // Records every attempt, redrives included
+    journal?: JournalOutbound
+  ) => Effect.Effect<void>
+  readonly failed: (opts?: { imposterId?: string }) => Effect.Effect<ReadonlyArray<FailedCallback>>
+  // Deliver a dead letter again with its retry policy; it leaves the list once delivered
+  readonly redrive: (id: string) => Effect.Effect<CallbackDelivery, CallbackNotFoundError>
+  readonly clearFailed: (imposterId?: string) => Effect.Effect<number>
+}
+
+export class CallbackService extends Context.Tag("CallbackService")<CallbackService, CallbackServiceShape>() {}
+
+export const CallbackServiceLive = Layer.effect(
+  CallbackService,
+  Effect.gen(function*() {
+    const uuid = yield* Uuid
+    const deadLettersRef = yield* Ref.make<ReadonlyArray<DeadLetter>>([])
+
+    // One attempt: the response status, or why no response arrived
+    const attempt = (request: CallbackRequest, callback: CallbackConfig): Effect.Effect<number, string> =>
+      Effect.tryPromise({
+        try: (signal) =>
+          outboundFetch(request.url, {
+            method: request.method,
+            headers: request.headers,
+            ...(request.body !== undefined && request.method !== "GET" ? { body: request.body } : {}),
+            signal
+          }, callback.outbound).then(async (response) => {
+            await response.body?.cancel()
+            return response.status
+          }),
+        catch: (err) => {
+          const cause = err instanceof Error ? (err.cause as { code?: unknown } | undefined) : undefined
+          return typeof cause?.code === "string" ? cause.code : String(err)
+        }
+      }).pipe(Effect.timeoutFail({
+        duration: `${callback.timeout} millis`,
+        onTimeout: () => `timed out after ${callback.timeout}ms`
+      }))
+
+    const journaledAttempt = (
+      request: CallbackRequest,
+      callback: CallbackConfig,
+      n: number,
+      journal: JournalOutbound | undefined
+    ): Effect.Effect<number, string> =>
+      journal === undefined ? attempt(request, callback) : Effect.gen(function*() {
+        const timestamp = yield* DateTime.now
+        const startedAt = Date.now()
+        const result = yield* Effect.either(attempt(request, callback))
+        yield* journal({
+          kind: "callback",
+          timestamp,
+          request,
+          ...(Either.isRight(result) ? { status: result.right } : { error: result.left }),
+          attempt: n,
+          duration: Date.now() - startedAt
+        })
+        return yield* result
+      })
+
+    const deliver = (
+      request: CallbackRequest,
+      callback: CallbackConfig,
+      journal: JournalOutbound | undefined
+    ): Effect.Effect<CallbackDelivery> =>
+      Effect.gen(function*() {
+        const retry = callback.retry ?? NO_RETRY
+        let lastStatus: number | undefined
+        let lastError = ""
+        for (let n = 1; n <= retry.maxAttempts; n++) {
+          const wait = backoffDelay(retry, n)
+          if (wait > 0) yield* Effect.sleep(`${wait} millis`)
+          const result = yield* Effect.either(journaledAttempt(request, callback, n, journal))
+          if (Either.isRight(result)) {
+            if (result.right >= 200 && result.right < 300) return { delivered: true, attempts: n, status: result.right }
+            lastStatus = result.right
+            lastError = `HTTP ${result.right}`
+          } else {
+            lastStatus = undefined
+            lastError = result.left
+          }
+        }
+        return {
+          delivered: false,
+          attempts: retry.maxAttempts,
+          ...(lastStatus !== undefined ? { status: lastStatus } : {}),
+          error: lastError
+        }
+      })
+
+    const dispatch = (
+      imposterId: string,
+      stubId: string | undefined,
+      callback: CallbackConfig,
+      ctx: RequestContext,
+      journal?: JournalOutbound
+    ): Effect.Effect<void> =>
+      Effect.gen(function*() {
+        if (callback.delay !== undefined && callback.delay > 0) yield* Effect.sleep(`${callback.delay} millis`)
+        const request = yield* Effect.promise(() => renderCallback(callback, ctx))
+        const outcome = yield* deliver(request, callback, journal)
+        if (outcome.delivered) return
+        const entry: FailedCallback = {
+          id: NonEmptyString.make(yield* uuid.generate),
+          imposterId,
+          ...(stubId !== undefined ? { stubId } : {}),
+          request,
+          attempts: outcome.attempts,
+          ...(outcome.status !== undefined ? { lastStatus: outcome.status } : {}),
+          lastError: outcome.error ?? "",
+          failedAt: yield* DateTime.now
+        }
+        yield* Ref.update(deadLettersRef, (letters) => [...letters, { entry, callback, journal }].slice(-MAX_FAILED))
+      }).pipe(
+        Effect.catchAllCause(() => Effect.void),
+        Effect.forkDaemon,
+        Effect.asVoid
+      )
+
+    const failed = (opts?: { imposterId?: string }): Effect.Effect<ReadonlyArray<FailedCallback>> =>
+      Ref.get(deadLettersRef).pipe(
+        Effect.map((letters) =>
+          letters
+            .filter((letter) => opts?.imposterId === undefined || letter.entry.imposterId === opts.imposterId)
+            .map((letter) => letter.entry)
+        )
+      )
+
+    const redrive = (id: string): Effect.Effect<CallbackDelivery, CallbackNotFoundError> =>
+      Effect.gen(function*() {
+        const letter = (yield* Ref.get(deadLettersRef)).find((l) => l.entry.id === id)
+        if (letter === undefined) return yield* Effect.fail(new CallbackNotFoundError({ id }))
+        const outcome = yield* deliver(letter.entry.request, letter.callback, letter.journal)
+        const failedAt = yield* DateTime.now
+        yield* Ref.update(deadLettersRef, (letters) =>
+          outcome.delivered
+            ? letters.filter((l) => l.entry.id !== id)
+            : letters.map((l) => {
+              if (l.entry.id !== id) return l
+              const { lastStatus: _, ...rest } = l.entry
+              return {
+                ...l,
+                entry: {
+                  ...rest,
+                  attempts: l.entry.attempts + outcome.attempts,
+                  ...(outcome.status !== undefined ? { lastStatus: outcome.status } : {}),
+                  lastError: outcome.error ?? "",
+                  failedAt
+                }
+              }
+            }))
+        return outcome
+      })
+
+    const clearFailed = (imposterId?: string): Effect.Effect<number> =>
+      Ref.modify(deadLettersRef, (letters) => {
+        const kept = letters.filter((l) => imposterId !== undefined && l.entry.imposterId !== imposterId)
+        return [letters.length - kept.length, kept]
+      })
+
+    return { dispatch, failed, redrive, clearFailed } satisfies CallbackServiceShape
+  })
+)
@@ -0,0 +1,92 @@
+import type { Queue, Scope } from "effect"
+import { Context, Effect, Layer, PubSub, Ref } from "effect"
+import * as DateTime from "effect/DateTime"
+import { NonEmptyString } from "../schemas/common"
+import type { ImposterEvent, ImposterEventType } from "../schemas/EventSchema"
+import { Uuid } from "./Uuid"
+
+const MAX_EVENTS = 200
+
+export interface FieldChange {
+  readonly before: unknown
+  readonly after: unknown
+}
+
+/**
+ * Field-level diff for change events: every listed key whose value changed maps to
+ * its old and new value.
+ */
+export const diffFields = <T extends object>(
+  before: T,
+  after: T,
+  keys: ReadonlyArray<keyof T & string>
+): Record<string, FieldChange> => {
+  const changes: Record<string, FieldChange> = {}
+  for (const key of keys) {
+    if (JSON.stringify(before[key]) !== JSON.stringify(after[key])) {
+      changes[key] = { before: before[key], after: after[key] }
+    }
+  }
+  return changes
+}
+
+export interface EventBusShape {
+  readonly publish: (
+    type: ImposterEventType,
+    imposterId: string,
+    data?: Record<string, unknown>
+  ) => Effect.Effect<ImposterEvent>
+  readonly recent: (
+    opts?: { imposterId?: string; type?: ImposterEventType; limit?: number }
+  ) => Effect.Effect<ReadonlyArray<ImposterEvent>>
+  readonly subscribe: Effect.Effect<Queue.Dequeue<ImposterEvent>, never, Scope.Scope>
+}
+
+export class EventBus extends Context.Tag("EventBus")<EventBus, EventBusShape>() {}
+
+export const EventBusLive = Layer.scoped(
+  EventBus,
+  Effect.gen(function*() {
+    const uuid = yield* Uuid
+    const historyRef = yield* Ref.make<ReadonlyArray<ImposterEvent>>([])
+    const pubsub = yield* PubSub.sliding<ImposterEvent>(256)
+
+    const publish = (
+      type: ImposterEventType,
+      imposterId: string,
+      data?: Record<string, unknown>
+    ): Effect.Effect<ImposterEvent> =>
+      Effect.gen(function*() {
+        const event: ImposterEvent = {
+          id: NonEmptyString.make(yield* uuid.generate),
+          type,
+          imposterId: NonEmptyString.make(imposterId),
+          timestamp: yield* DateTime.now,
+          ...(data !== undefined ? { data } : {})
+        }
+        yield* Ref.update(historyRef, (events) => [...events, event].slice(-MAX_EVENTS))
+        yield* PubSub.publish(pubsub, event)
+        return event
+      })
+
+    const recent = (
+      opts?: { imposterId?: string; type?: ImposterEventType; limit?: number }
+    ): Effect.Effect<ReadonlyArray<ImposterEvent>> =>
+      Ref.get(historyRef).pipe(
+        Effect.map((events) => {
+          let filtered = events
+          if (opts?.imposterId !== undefined) {
+            filtered = filtered.filter((e) => e.imposterId === opts.imposterId)
+          }
+          if (opts?.type !== undefined) {
+            filtered = filtered.filter((e) => e.type === opts.type)
+          }
+          return filtered.slice(-(opts?.limit ?? 50))
+        })
+      )
+
+    const subscribe: Effect.Effect<Queue.Dequeue<ImposterEvent>, never, Scope.Scope> = PubSub.subscribe(pubsub)
+
+    return { publish, recent, subscribe } satisfies EventBusShape
+  })
+)
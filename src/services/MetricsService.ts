@@ -1,8 +1,10 @@
 import { Context, Effect, HashMap, Layer, Ref } from "effect"
 import * as DateTime from "effect/DateTime"
 import type { RequestLogEntry } from "../schemas/RequestLogSchema"
+import type { RejectedConnection } from "../server/ServerFactory"
 
 const BUFFER_SIZE = 1000
+const REJECTED_SAMPLES = 5
 
 interface ImposterMetrics {
   totalRequests: number
@@ -14,6 +16,44 @@ interface ImposterMetrics {
   firstRequestAt: DateTime.Utc
   lastRequestAt: DateTime.Utc
   errorCount: number
+  stubHits: Record<string, StubUsage>
+  // Matching cost, from requests logged with timings
+  matchTimes: Float64Array
+  matchCount: number
+  matchMsTotal: number
+  maxMatchMs: number
+  candidatesTotal: number
+  maxCandidates: number
+  renderCount: number
+  renderMsTotal: number
+}
+
+export interface StubUsage {
+  readonly hits: number
+  readonly lastHitAt: DateTime.Utc
+}
+
+// Per-stub hit counts, and when they were last reset (absent if never reset)
+export interface StubUsageReport {
+  readonly resetAt?: DateTime.Utc
+  readonly stubs: Record<string, StubUsage>
+}
+
+// What finding stubs and rendering responses cost across an imposter's requests
+export interface MatchingStatistics {
+  readonly averageCandidates: number
+  readonly maxCandidates: number
+  readonly averageMatchMs: number
+  readonly p95MatchMs: number
+  readonly maxMatchMs: number
+  readonly averageRenderMs?: number
+}
+
+// Connections dropped before making an HTTP request, with the latest few as samples
+export interface RejectedConnectionStatistics {
+  readonly total: number
+  readonly byKind: Record<string, number>
+  readonly samples: ReadonlyArray<RejectedConnection & { readonly at: DateTime.Utc }>
 }
 
 export interface Statistics {
@@ -27,6 +67,8 @@ export interface Statistics {
   readonly p50ResponseTime?: number
   readonly p95ResponseTime?: number
   readonly p99ResponseTime?: number
+  readonly matching?: MatchingStatistics
+  readonly rejectedConnections?: RejectedConnectionStatistics
 }
 
 const makeEmptyMetrics = (now: DateTime.Utc): ImposterMetrics => ({
@@ -38,7 +80,16 @@ const makeEmptyMetrics = (now: DateTime.Utc): ImposterMetrics => ({
   responseTimeCount: 0,
   firstRequestAt: now,
   lastRequestAt: now,
-  errorCount: 0
+  errorCount: 0,
+  stubHits: {},
+  matchTimes: new Float64Array(BUFFER_SIZE),
+  matchCount: 0,
+  matchMsTotal: 0,
+  maxMatchMs: 0,
+  candidatesTotal: 0,
+  maxCandidates: 0,
+  renderCount: 0,
+  renderMsTotal: 0
 })
 
 const computePercentile = (sorted: Array<number>, p: number): number => {
@@ -47,6 +98,21 @@ const computePercentile = (sorted: Array<number>, p: number): number => {
   return sorted[Math.max(0, index)]!
 }
 
+const round3 = (n: number): number => Math.round(n * 1000) / 1000
+
+const computeMatching = (metrics: ImposterMetrics): MatchingStatistics => {
+  const sorted = Array.from(metrics.matchTimes.subarray(0, Math.min(metrics.matchCount, BUFFER_SIZE)))
+    .sort((a, b) => a - b)
+  return {
+    averageCandidates: Math.round((metrics.candidatesTotal / metrics.matchCount) * 100) / 100,
+    maxCandidates: metrics.maxCandidates,
+    averageMatchMs: round3(metrics.matchMsTotal / metrics.matchCount),
+    p95MatchMs: computePercentile(sorted, 95),
+    maxMatchMs: metrics.maxMatchMs,
+    ...(metrics.renderCount > 0 ? { averageRenderMs: round3(metrics.renderMsTotal / metrics.renderCount) } : {})
+  }
+}
+
 const computeStats = (metrics: ImposterMetrics): Statistics => {
   const count = metrics.responseTimeCount
   const total = metrics.totalRequests
@@ -84,7 +150,8 @@ const computeStats = (metrics: ImposterMetrics): Statistics => {
         p95ResponseTime: computePercentile(sorted, 95),
         p99ResponseTime: computePercentile(sorted, 99)
       }
-      : {})
+      : {}),
+    ...(metrics.matchCount > 0 ? { matching: computeMatching(metrics) } : {})
   }
 }
 
@@ -92,6 +159,10 @@ export interface MetricsServiceShape {
   readonly recordRequest: (entry: RequestLogEntry) => Effect.Effect<void>
   readonly getStats: (imposterId: string) => Effect.Effect<Statistics>
   readonly resetStats: (imposterId: string) => Effect.Effect<void>
+  // Drops everything kept for a deleted imposter, when its stats were reset included
+  readonly removeStats: (imposterId: string) => Effect.Effect<void>
+  readonly getStubUsage: (imposterId: string) => Effect.Effect<StubUsageReport>
+  readonly recordRejectedConnection: (imposterId: string, rejected: RejectedConnection) => Effect.Effect<void>
 }
 
 export class MetricsService extends Context.Tag("MetricsService")<MetricsService, MetricsServiceShape>() {}
@@ -100,6 +171,8 @@ export const MetricsServiceLive = Layer.effect(
   MetricsService,
   Effect.gen(function*() {
     const storeRef = yield* Ref.make(HashMap.empty<string, ImposterMetrics>())
+    const resetAtRef = yield* Ref.make(HashMap.empty<string, DateTime.Utc>())
+    const rejectedRef = yield* Ref.make(HashMap.empty<string, RejectedConnectionStatistics>())
 
     const recordRequest = (entry: RequestLogEntry): Effect.Effect<void> =>
       Ref.update(storeRef, (store) => {
@@ -127,31 +200,95 @@ export const MetricsServiceLive = Layer.effect(
           metrics.errorCount += 1
         }
 
+        const stubId = entry.response.matchedStubId
+        if (stubId !== undefined) {
+          metrics.stubHits[stubId] = { hits: (metrics.stubHits[stubId]?.hits ?? 0) + 1, lastHitAt: now }
+        }
+
+        const timings = entry.timings
+        if (timings !== undefined) {
+          metrics.matchTimes[metrics.matchCount % BUFFER_SIZE] = timings.matchMs
+          metrics.matchCount += 1
+          metrics.matchMsTotal += timings.matchMs
+          metrics.maxMatchMs = Math.max(metrics.maxMatchMs, timings.matchMs)
+          metrics.candidatesTotal += timings.candidates
+          metrics.maxCandidates = Math.max(metrics.maxCandidates, timings.candidates)
+          if (timings.renderMs !== undefined) {
+            metrics.renderCount += 1
+            metrics.renderMsTotal += timings.renderMs
+          }
+        }
+
         metrics.lastRequestAt = now
 
         return HashMap.set(store, entry.imposterId, metrics)
       })
 
+    const recordRejectedConnection = (imposterId: string, rejected: RejectedConnection): Effect.Effect<void> =>
+      Effect.gen(function*() {
+        const at = yield* DateTime.now
+        yield* Ref.update(rejectedRef, (store) => {
+          const current = HashMap.get(store, imposterId)
+          const { byKind, samples, total } = current._tag === "Some"
+            ? current.value
+            : { byKind: {}, samples: [], total: 0 }
+          return HashMap.set(store, imposterId, {
+            total: total + 1,
+            byKind: { ...byKind, [rejected.kind]: (byKind[rejected.kind] ?? 0) + 1 },
+            samples: [...samples, { ...rejected, at }].slice(-REJECTED_SAMPLES)
+          })
+        })
+      })
+
     const getStats = (imposterId: string): Effect.Effect<Statistics> =>
-      Ref.get(storeRef).pipe(
-        Effect.map((store) => {
-          const existing = HashMap.get(store, imposterId)
-          if (existing._tag === "None") {
-            return {
-              totalRequests: 0,
-              requestsPerMinute: 0,
-              averageResponseTime: 0,
-              errorRate: 0,
-              requestsByMethod: {},
-              requestsByStatusCode: {}
-            }
+      Effect.gen(function*() {
+        const existing = HashMap.get(yield* Ref.get(storeRef), imposterId)
+        const rejected = HashMap.get(yield* Ref.get(rejectedRef), imposterId)
+        const stats: Statistics = existing._tag === "None"
+          ? {
+            totalRequests: 0,
+            requestsPerMinute: 0,
+            averageResponseTime: 0,
+            errorRate: 0,
+            requestsByMethod: {},
+            requestsByStatusCode: {}
           }
-          return computeStats(existing.value)
-        })
-      )
+          : computeStats(existing.value)
+        return rejected._tag === "Some" ? { ...stats, rejectedConnections: rejected.value } : stats
+      })
+
+    const resetStats = (imposterId: string): Effect.Effect<void> =>
+      Effect.gen(function*() {
+        const now = yield* DateTime.now
+        yield* Ref.update(storeRef, HashMap.remove(imposterId))
+        yield* Ref.update(rejectedRef, HashMap.remove(imposterId))
+        yield* Ref.update(resetAtRef, HashMap.set(imposterId, now))
+      })
 
-    const resetStats = (imposterId: string): Effect.Effect<void> => Ref.update(storeRef, HashMap.remove(imposterId))
+    const removeStats = (imposterId: string): Effect.Effect<void> =>
+      Effect.all([
+        Ref.update(storeRef, HashMap.remove(imposterId)),
+        Ref.update(rejectedRef, HashMap.remove(imposterId)),
+        Ref.update(resetAtRef, HashMap.remove(imposterId))
+      ], { discard: true })
+
+    const getStubUsage = (imposterId: string): Effect.Effect<StubUsageReport> =>
+      Effect.gen(function*() {
+        const metrics = HashMap.get(yield* Ref.get(storeRef), imposterId)
+        const resetAt = HashMap.get(yield* Ref.get(resetAtRef), imposterId)
+        return {
+          ...(resetAt._tag === "Some" ? { resetAt: resetAt.value } : {}),
+          stubs: metrics._tag === "Some" ? { ...metrics.value.stubHits } : {}
+        }
+      })
 
-    return { recordRequest, getStats, resetStats } satisfies MetricsServiceShape
+    return {
+      recordRequest,
+      getStats,
+      resetStats,
+      removeStats,
+      getStubUsage,
+      recordRejectedConnection
+    } satisfies MetricsServiceShape
   })
 )
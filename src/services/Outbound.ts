@@ -0,0 +1,41 @@
+import { Agent, type Dispatcher, fetch as undiciFetch, ProxyAgent } from "undici"
+import type { OutboundOptions } from "../schemas/StubSchema"
+
+// Dispatchers hold connection pools, so one is kept per distinct set of options
+const dispatchers = new Map<string, Dispatcher>()
+
+const makeDispatcher = (options: OutboundOptions): Dispatcher => {
+  const tls = {
+    ...(options.ca !== undefined ? { ca: options.ca } : {}),
+    ...(options.insecureSkipVerify === true ? { rejectUnauthorized: false } : {})
+  }
+  const socket = options.localAddress !== undefined ? { localAddress: options.localAddress } : {}
+  return options.proxy !== undefined
+    ? new ProxyAgent({ uri: options.proxy, proxyTls: { ...tls, ...socket }, requestTls: tls })
+    : new Agent({ connect: { ...tls, ...socket } })
+}
+
+export const hasOutboundOptions = (options: OutboundOptions | undefined): options is OutboundOptions =>
+  options !== undefined && Object.values(options).some((value) => value !== undefined)
+
+/**
+ * fetch() for a call the imposter makes itself. Without outbound options this is the
+ * global fetch; otherwise the request goes through an undici dispatcher that binds the
+ * source address, tunnels through the proxy and applies the TLS settings.
+ */
+export const outboundFetch = (
+  url: string,
+  init: RequestInit,
+  options: OutboundOptions | undefined
+): Promise<Response> => {
+  if (!hasOutboundOptions(options)) return fetch(url, init)
+  const key = JSON.stringify(options)
+  let dispatcher = dispatchers.get(key)
+  if (dispatcher === undefined) {
+    dispatcher = makeDispatcher(options)
+    dispatchers.set(key, dispatcher)
+  }
+  // undici's fetch types are structurally the same as the DOM ones but declared separately
+  const response = undiciFetch(url, { ...init, dispatcher } as Parameters<typeof undiciFetch>[1])
+  return response as unknown as Promise<Response>
+}
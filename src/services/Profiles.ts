@@ -0,0 +1,102 @@
+import { Context, Data, Effect, Either, Layer, Ref } from "effect"
+import type { Profile, Profiles as ProfileMap } from "../schemas/ProfileSchema"
+
+export class ProfileNotFoundError extends Data.TaggedError("ProfileNotFoundError")<{
+  readonly name: string
+}> {}
+
+// `extends` leads back to a profile already in the chain
+export class ProfileCycleError extends Data.TaggedError("ProfileCycleError")<{
+  readonly chain: ReadonlyArray<string>
+}> {}
+
+// Removing the profile would leave `extendedBy` extending nothing
+export class ProfileInUseError extends Data.TaggedError("ProfileInUseError")<{
+  readonly name: string
+  readonly extendedBy: ReadonlyArray<string>
+}> {}
+
+// A profile with the ones it extends folded in
+export type ResolvedProfile = Omit<Profile, "extends">
+
+// The profile called `name`, and never a property every object has, such as `constructor`
+const lookup = (profiles: ProfileMap, name: string): Profile | undefined =>
+  Object.hasOwn(profiles, name) ? profiles[name] : undefined
+
+/**
+ * `name` with everything it extends applied under it: each profile's settings override
+ * those of the profiles it extends, and their stubs and presets come before its own.
+ */
+export const resolveProfile = (
+  profiles: ProfileMap,
+  name: string
+): Either.Either<ResolvedProfile, ProfileNotFoundError | ProfileCycleError> => {
+  const chain: Array<string> = []
+  for (let next: string | undefined = name; next !== undefined; next = lookup(profiles, next)?.extends) {
+    if (chain.includes(next)) return Either.left(new ProfileCycleError({ chain: [...chain, next] }))
+    if (lookup(profiles, next) === undefined) return Either.left(new ProfileNotFoundError({ name: next }))
+    chain.push(next)
+  }
+  return Either.right(
+    chain.reverse().reduce<ResolvedProfile>((acc, key) => {
+      const { extends: _, presets, stubs, ...settings } = lookup(profiles, key)!
+      return { ...acc, ...settings, stubs: [...acc.stubs, ...stubs], presets: [...acc.presets, ...presets] }
+    }, { stubs: [], presets: [] })
+  )
+}
+
+export interface ProfilesShape {
+  readonly getAll: Effect.Effect<ProfileMap>
+  // Creates or replaces a profile, refusing one whose `extends` chain doesn't resolve
+  readonly set: (name: string, profile: Profile) => Effect.Effect<Profile, ProfileNotFoundError | ProfileCycleError>
+  readonly remove: (name: string) => Effect.Effect<Profile, ProfileNotFoundError | ProfileInUseError>
+  // Replaces every profile with what `f` makes of the current ones, refusing the lot if one doesn't resolve
+  readonly update: (
+    f: (current: ProfileMap) => ProfileMap
+  ) => Effect.Effect<ProfileMap, ProfileNotFoundError | ProfileCycleError>
+  readonly resolve: (name: string) => Effect.Effect<ResolvedProfile, ProfileNotFoundError | ProfileCycleError>
+}
+
+export class Profiles extends Context.Tag("Profiles")<Profiles, ProfilesShape>() {}
+
+type Modified<A, E> = readonly [Effect.Effect<A, E>, ProfileMap]
+
+export const makeProfiles = (initial: ProfileMap = {}) =>
+  Effect.gen(function*() {
+    const ref = yield* Ref.make(initial)
+
+    const set = (name: string, profile: Profile) =>
+      Ref.modify(ref, (current): Modified<Profile, ProfileNotFoundError | ProfileCycleError> => {
+        const next = { ...current, [name]: profile }
+        return Either.match(resolveProfile(next, name), {
+          onLeft: (error) => [Effect.fail(error), current],
+          onRight: () => [Effect.succeed(profile), next]
+        })
+      }).pipe(Effect.flatten)
+
+    const remove = (name: string) =>
+      Ref.modify(ref, (current): Modified<Profile, ProfileNotFoundError | ProfileInUseError> => {
+        const profile = lookup(current, name)
+        if (profile === undefined) return [Effect.fail(new ProfileNotFoundError({ name })), current]
+        const extendedBy = Object.keys(current).filter((key) => current[key]!.extends === name)
+        if (extendedBy.length > 0) return [Effect.fail(new ProfileInUseError({ name, extendedBy })), current]
+        const { [name]: _, ...rest } = current
+        return [Effect.succeed(profile), rest]
+      }).pipe(Effect.flatten)
+
+    const update = (f: (current: ProfileMap) => ProfileMap) =>
+      Ref.modify(ref, (current): Modified<ProfileMap, ProfileNotFoundError | ProfileCycleError> => {
+        const next = f(current)
+        for (const name of Object.keys(next)) {
+          const resolved = resolveProfile(next, name)
+          if (Either.isLeft(resolved)) return [Effect.fail(resolved.left), current]
+        }
+        return [Effect.succeed(next), next]
+      }).pipe(Effect.flatten)
+
+    const resolve = (name: string) => Effect.flatMap(Ref.get(ref), (profiles) => resolveProfile(profiles, name))
+
+    return { getAll: Ref.get(ref), set, remove, update, resolve } satisfies ProfilesShape
+  })
+
+export const ProfilesLive = Layer.effect(Profiles, makeProfiles())
@@ -1,8 +1,14 @@
 import { Context, Data, Effect, Layer } from "effect"
+import * as DateTime from "effect/DateTime"
 import type { ProxyConfigDomain } from "../domain/imposter"
 import type { RequestContext } from "../matching/RequestMatcher"
+import { normalizeRecordedStub } from "../matching/RecordNormalizer"
+import { scrubBody, scrubHeaders } from "../matching/Scrubber"
+import { applyTemplates } from "../matching/TemplateEngine"
 import { NonEmptyString } from "../schemas/common"
 import type { Stub } from "../schemas/StubSchema"
+import { outboundFetch } from "./Outbound"
+import type { JournalOutbound } from "./RequestLogger"
 import { Uuid } from "./Uuid"
 
 export class ProxyError extends Data.TaggedError("ProxyError")<{
@@ -27,11 +33,14 @@ export interface ProxyServiceShape {
   readonly forward: (
     ctx: RequestContext,
     config: ProxyConfigDomain,
-    originalUrl: URL
+    originalUrl: URL,
+    // Records the forwarded request and what came back
+    journal?: JournalOutbound
   ) => Effect.Effect<Response, ProxyError>
   readonly recordAsStub: (
     request: RequestContext,
-    response: Response
+    response: Response,
+    config?: ProxyConfigDomain
   ) => Effect.Effect<Stub>
 }
 
@@ -45,12 +54,16 @@ export const ProxyServiceLive = Layer.effect(
     const forward = (
       ctx: RequestContext,
       config: ProxyConfigDomain,
-      originalUrl: URL
+      originalUrl: URL,
+      journal?: JournalOutbound
     ): Effect.Effect<Response, ProxyError> =>
       Effect.gen(function*() {
-        // Build target URL preserving path and query
+        // Build target URL preserving path (optionally rewritten) and query
         const targetBase = config.targetUrl.replace(/\/$/, "")
-        const targetUrl = `${targetBase}${originalUrl.pathname}${originalUrl.search}`
+        const pathname = config.pathRewrite
+          ? originalUrl.pathname.replace(new RegExp(config.pathRewrite.pattern), config.pathRewrite.replacement)
+          : originalUrl.pathname
+        const targetUrl = `${targetBase}${pathname}${originalUrl.search}`
 
         // Build headers
         const headers = new Headers()
@@ -72,25 +85,64 @@ export const ProxyServiceLive = Layer.effect(
           }
         }
 
-        // Build body
+        // Build body: the configured template replaces the incoming body
+        const sourceBody = config.body !== undefined
+          ? yield* Effect.promise(() => applyTemplates(ctx, config.body))
+          : ctx.body
         let body: string | undefined
-        if (ctx.body !== undefined && ctx.body !== null) {
-          body = typeof ctx.body === "string" ? ctx.body : JSON.stringify(ctx.body)
+        if (sourceBody !== undefined && sourceBody !== null) {
+          body = typeof sourceBody === "string" ? sourceBody : JSON.stringify(sourceBody)
+        }
+        // Compressed uploads were decoded on the way in and are forwarded as plain bodies
+        if (headers.has("content-encoding")) {
+          headers.delete("content-encoding")
+          headers.delete("content-length")
+        }
+        if (config.body !== undefined) {
+          headers.delete("content-length")
+          if (typeof sourceBody !== "string") headers.set("content-type", "application/json")
+        }
+
+        const sentBody = body !== undefined && ctx.method !== "GET" && ctx.method !== "HEAD" ? body : undefined
+        const timestamp = yield* DateTime.now
+        const startedAt = Date.now()
+        const journaled = (outcome: { readonly status: number } | { readonly error: string }) => {
+          if (journal === undefined) return Effect.void
+          const sentHeaders: Record<string, string> = {}
+          headers.forEach((val, key) => {
+            sentHeaders[key] = val
+          })
+          return journal({
+            kind: "proxy",
+            timestamp,
+            request: {
+              method: ctx.method,
+              url: targetUrl,
+              headers: sentHeaders,
+              ...(sentBody !== undefined ? { body: sentBody } : {})
+            },
+            ...outcome,
+            attempt: 1,
+            duration: Date.now() - startedAt
+          })
         }
 
         const response = yield* Effect.tryPromise({
           try: (signal) =>
-            fetch(targetUrl, {
+            outboundFetch(targetUrl, {
               method: ctx.method,
               headers,
-              ...(body !== undefined && ctx.method !== "GET" && ctx.method !== "HEAD" ? { body } : {}),
+              ...(sentBody !== undefined ? { body: sentBody } : {}),
               redirect: config.followRedirects ? "follow" : "manual",
               signal
-            }),
+            }, config.outbound),
           catch: (err) => new ProxyError({ targetUrl, reason: `Failed to reach target: ${err}`, cause: err })
         }).pipe(Effect.timeoutFail({
           duration: `${config.timeout} millis`,
           onTimeout: () => new ProxyError({ targetUrl, reason: `Request timed out after ${config.timeout}ms` })
+        }), Effect.tapBoth({
+          onFailure: (err) => journaled({ error: err.reason }),
+          onSuccess: (res) => journaled({ status: res.status })
         }))
 
         return response
@@ -98,7 +150,8 @@ export const ProxyServiceLive = Layer.effect(
 
     const recordAsStub = (
       request: RequestContext,
-      response: Response
+      response: Response,
+      config?: ProxyConfigDomain
     ): Effect.Effect<Stub> =>
       Effect.gen(function*() {
         const id = yield* uuid.generateShort
@@ -119,7 +172,9 @@ export const ProxyServiceLive = Layer.effect(
           }
         }
 
-        return {
+        const scrub = config?.scrub ?? []
+
+        const stub: Stub = {
           id: NonEmptyString.make(id),
           predicates: [
             { field: "method" as const, operator: "equals" as const, value: request.method, caseSensitive: true },
@@ -127,11 +182,12 @@ export const ProxyServiceLive = Layer.effect(
           ],
           responses: [{
             status: response.status,
-            headers: respHeaders,
-            body: respBody
+            headers: scrubHeaders(respHeaders, scrub),
+            body: scrubBody(respBody, scrub)
           }],
           responseMode: "sequential" as const
         }
+        return config?.normalize ? normalizeRecordedStub(stub, request.path) : stub
       })
 
     return { forward, recordAsStub } satisfies ProxyServiceShape
@@ -1,20 +1,29 @@
 import type { Queue, Scope } from "effect"
 import { Context, Effect, HashMap, Layer, PubSub, Ref } from "effect"
-import type { RequestLogEntry } from "../schemas/RequestLogSchema"
+import type { OutboundExchange, OutboundLogEntry, RequestLogEntry } from "../schemas/RequestLogSchema"
 
 const MAX_ENTRIES = 100
 
+// Journals one outbound exchange made on behalf of an inbound request
+export type JournalOutbound = (exchange: OutboundExchange) => Effect.Effect<void>
+
 export interface RequestLoggerShape {
   readonly log: (entry: RequestLogEntry) => Effect.Effect<void>
   readonly getEntries: (
     imposterId: string,
-    opts?: { limit?: number; method?: string; path?: string; status?: number }
+    opts?: { limit?: number; offset?: number; method?: string; path?: string; status?: number; matched?: boolean }
   ) => Effect.Effect<ReadonlyArray<RequestLogEntry>>
   readonly getCount: (imposterId: string) => Effect.Effect<number>
   readonly clear: (imposterId: string) => Effect.Effect<void>
   readonly subscribe: Effect.Effect<Queue.Dequeue<RequestLogEntry>, never, Scope.Scope>
   readonly getEntryById: (imposterId: string, entryId: string) => Effect.Effect<RequestLogEntry | null>
   readonly removeImposter: (imposterId: string) => Effect.Effect<void>
+  // Requests the imposter made itself, kept and cleared along with the inbound ones
+  readonly logOutbound: (entry: OutboundLogEntry) => Effect.Effect<void>
+  readonly getOutbound: (
+    imposterId: string,
+    opts?: { limit?: number; triggeredBy?: string }
+  ) => Effect.Effect<ReadonlyArray<OutboundLogEntry>>
 }
 
 export class RequestLogger extends Context.Tag("RequestLogger")<RequestLogger, RequestLoggerShape>() {}
@@ -23,6 +32,7 @@ export const RequestLoggerLive = Layer.scoped(
   RequestLogger,
   Effect.gen(function*() {
     const storeRef = yield* Ref.make(HashMap.empty<string, Array<RequestLogEntry>>())
+    const outboundRef = yield* Ref.make(HashMap.empty<string, Array<OutboundLogEntry>>())
     const pubsub = yield* PubSub.sliding<RequestLogEntry>(256)
 
     const log = (entry: RequestLogEntry): Effect.Effect<void> =>
@@ -38,7 +48,7 @@ export const RequestLoggerLive = Layer.scoped(
 
     const getEntries = (
       imposterId: string,
-      opts?: { limit?: number; method?: string; path?: string; status?: number }
+      opts?: { limit?: number; offset?: number; method?: string; path?: string; status?: number; matched?: boolean }
     ): Effect.Effect<ReadonlyArray<RequestLogEntry>> =>
       Ref.get(storeRef).pipe(
         Effect.map((store) => {
@@ -53,8 +63,13 @@ export const RequestLoggerLive = Layer.scoped(
           if (opts?.status !== undefined) {
             entries = entries.filter((e) => e.response.status === opts.status)
           }
+          if (opts?.matched !== undefined) {
+            const answered = (e: RequestLogEntry) => e.response.matchedStubId !== undefined || e.response.proxied
+            entries = entries.filter((e) => answered(e) === opts.matched)
+          }
           const limit = opts?.limit ?? 50
-          return entries.slice(-limit)
+          const end = entries.length - (opts?.offset ?? 0)
+          return end > 0 ? entries.slice(Math.max(0, end - limit), end) : []
         })
       )
 
@@ -67,7 +82,9 @@ export const RequestLoggerLive = Layer.scoped(
       )
 
     const clear = (imposterId: string): Effect.Effect<void> =>
-      Ref.update(storeRef, (store) => HashMap.set(store, imposterId, []))
+      Ref.update(storeRef, (store) => HashMap.set(store, imposterId, [])).pipe(
+        Effect.zipRight(Ref.update(outboundRef, HashMap.remove(imposterId)))
+      )
 
     const subscribe: Effect.Effect<Queue.Dequeue<RequestLogEntry>, never, Scope.Scope> = PubSub.subscribe(pubsub)
 
@@ -80,8 +97,43 @@ export const RequestLoggerLive = Layer.scoped(
         })
       )
 
-    const removeImposter = (imposterId: string): Effect.Effect<void> => Ref.update(storeRef, HashMap.remove(imposterId))
+    const removeImposter = (imposterId: string): Effect.Effect<void> =>
+      Ref.update(storeRef, HashMap.remove(imposterId)).pipe(
+        Effect.zipRight(Ref.update(outboundRef, HashMap.remove(imposterId)))
+      )
+
+    const logOutbound = (entry: OutboundLogEntry): Effect.Effect<void> =>
+      Ref.update(outboundRef, (store) => {
+        const existing = HashMap.get(store, entry.imposterId)
+        const entries = existing._tag === "Some" ? existing.value : []
+        return HashMap.set(store, entry.imposterId, [...entries, entry].slice(-MAX_ENTRIES))
+      })
+
+    const getOutbound = (
+      imposterId: string,
+      opts?: { limit?: number; triggeredBy?: string }
+    ): Effect.Effect<ReadonlyArray<OutboundLogEntry>> =>
+      Ref.get(outboundRef).pipe(
+        Effect.map((store) => {
+          const existing = HashMap.get(store, imposterId)
+          let entries = existing._tag === "Some" ? existing.value : []
+          if (opts?.triggeredBy !== undefined) {
+            entries = entries.filter((e) => e.triggeredBy === opts.triggeredBy)
+          }
+          return entries.slice(-(opts?.limit ?? 50))
+        })
+      )
 
-    return { log, getEntries, getCount, clear, subscribe, getEntryById, removeImposter } satisfies RequestLoggerShape
+    return {
+      log,
+      getEntries,
+      getCount,
+      clear,
+      subscribe,
+      getEntryById,
+      removeImposter,
+      logOutbound,
+      getOutbound
+    } satisfies RequestLoggerShape
   })
 )
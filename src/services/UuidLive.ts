@@ -1,9 +1,77 @@
+import * as Config from "effect/Config"
 import * as Effect from "effect/Effect"
 import * as Layer from "effect/Layer"
+import { randomBytes } from "node:crypto"
 import { v4 } from "uuid"
 import { Uuid } from "./Uuid"
 
-export const UuidLive = Layer.succeed(Uuid, {
-  generate: Effect.sync(() => v4()),
-  generateShort: Effect.sync(() => v4().substring(0, 8))
+// The format of generated imposter and stub IDs: "short" random strings, full UUIDs, or ULIDs
+export type IdFormat = "short" | "uuid" | "ulid"
+
+export interface IdOptions {
+  readonly format: IdFormat
+  // Length and characters of "short" IDs
+  readonly length: number
+  readonly alphabet: string
+}
+
+export const DEFAULT_ID_OPTIONS: IdOptions = { format: "short", length: 8, alphabet: "0123456789abcdef" }
+
+const CROCKFORD_BASE32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+/**
+ * `length` characters drawn uniformly from `alphabet`. Bytes past the largest multiple of
+ * the alphabet's size are drawn again, so no character is more likely than another.
+ */
+export const randomString = (alphabet: string, length: number): string => {
+  const chars = Array.from(alphabet)
+  const limit = 256 - (256 % chars.length)
+  let result = ""
+  while (result.length < length) {
+    for (const byte of randomBytes(length)) {
+      if (byte < limit && result.length < length) result += chars[byte % chars.length]
+    }
+  }
+  return result
+}
+
+// 48-bit millisecond timestamp then 80 random bits in Crockford base32, so IDs sort by creation time
+export const ulid = (now: number = Date.now()): string => {
+  let time = ""
+  for (let t = now, i = 0; i < 10; i++, t = Math.floor(t / 32)) time = CROCKFORD_BASE32[t % 32] + time
+  return time + randomString(CROCKFORD_BASE32, 16)
+}
+
+export const idGenerator = (options: IdOptions): () => string => {
+  switch (options.format) {
+    case "uuid":
+      return () => v4()
+    case "ulid":
+      return () => ulid()
+    case "short":
+      return () => randomString(options.alphabet, options.length)
+  }
+}
+
+export const makeUuid = (options: IdOptions = DEFAULT_ID_OPTIONS) => {
+  const next = idGenerator(options)
+  return { generate: Effect.sync(() => v4()), generateShort: Effect.sync(next) }
+}
+
+const idOptions = Config.all({
+  format: Config.literal("short", "uuid", "ulid")("ID_FORMAT").pipe(Config.withDefault("short" as const)),
+  length: Config.integer("ID_LENGTH").pipe(
+    Config.validate({ message: "ID_LENGTH must be between 4 and 64", validation: (n) => n >= 4 && n <= 64 }),
+    Config.withDefault(DEFAULT_ID_OPTIONS.length)
+  ),
+  // IDs appear in URLs, so only unreserved URL characters are allowed
+  alphabet: Config.string("ID_ALPHABET").pipe(
+    Config.validate({
+      message: "ID_ALPHABET must list two or more distinct characters from A-Z a-z 0-9 - . _ ~",
+      validation: (a) => /^[\w.~-]{2,}$/.test(a) && new Set(a).size === a.length
+    }),
+    Config.withDefault(DEFAULT_ID_OPTIONS.alphabet)
+  )
 })
+
+export const UuidLive = Layer.effect(Uuid, Effect.map(idOptions, makeUuid))
@@ -0,0 +1,60 @@
+import type { Queue, Scope } from "effect"
+import { Config, Context, Effect, Layer, PubSub, Ref } from "effect"
+import type { TemplateVariables } from "../schemas/VariablesSchema"
+
+export interface VariablesShape {
+  readonly get: Effect.Effect<TemplateVariables>
+  // Replaces every variable
+  readonly replace: (variables: TemplateVariables) => Effect.Effect<TemplateVariables>
+  // Sets the given variables and keeps the rest; a null value removes one
+  readonly merge: (variables: TemplateVariables) => Effect.Effect<TemplateVariables>
+  // Replaces every variable with what `f` makes of the current ones, atomically
+  readonly update: (f: (current: TemplateVariables) => TemplateVariables) => Effect.Effect<TemplateVariables>
+  // The variables after every change from now on
+  readonly subscribe: Effect.Effect<Queue.Dequeue<TemplateVariables>, never, Scope.Scope>
+}
+
+export class Variables extends Context.Tag("Variables")<Variables, VariablesShape>() {}
+
+const withoutNulls = (variables: TemplateVariables): TemplateVariables =>
+  Object.fromEntries(Object.entries(variables).filter(([, value]) => value !== null))
+
+export const makeVariables = (initial: TemplateVariables = {}) =>
+  Effect.gen(function*() {
+    const ref = yield* Ref.make(initial)
+    const pubsub = yield* PubSub.sliding<TemplateVariables>(16)
+
+    const changed = (variables: TemplateVariables): Effect.Effect<TemplateVariables> =>
+      Effect.as(PubSub.publish(pubsub, variables), variables)
+
+    const replace = (variables: TemplateVariables): Effect.Effect<TemplateVariables> =>
+      Effect.flatMap(Ref.setAndGet(ref, withoutNulls(variables)), changed)
+
+    const merge = (variables: TemplateVariables): Effect.Effect<TemplateVariables> =>
+      Effect.flatMap(Ref.updateAndGet(ref, (current) => withoutNulls({ ...current, ...variables })), changed)
+
+    const update = (f: (current: TemplateVariables) => TemplateVariables): Effect.Effect<TemplateVariables> =>
+      Effect.flatMap(Ref.updateAndGet(ref, (current) => withoutNulls(f(current))), changed)
+
+    const subscribe: Effect.Effect<Queue.Dequeue<TemplateVariables>, never, Scope.Scope> = PubSub.subscribe(pubsub)
+
+    return { get: Ref.get(ref), replace, merge, update, subscribe } satisfies VariablesShape
+  })
+
+const isJsonObject = (text: string): boolean => {
+  try {
+    const value: unknown = JSON.parse(text)
+    return value !== null && typeof value === "object" && !Array.isArray(value)
+  } catch {
+    return false
+  }
+}
+
+// Starting values: a JSON object in the VARIABLES environment variable
+const initialVariables = Config.string("VARIABLES").pipe(
+  Config.validate({ message: "VARIABLES must be a JSON object", validation: isJsonObject }),
+  Config.map((text): TemplateVariables => JSON.parse(text)),
+  Config.withDefault({} as TemplateVariables)
+)
+
+export const VariablesLive = Layer.effect(Variables, Effect.flatMap(initialVariables, makeVariables))
@@ -3,6 +3,7 @@ import type { ImposterConfig } from "../domain/imposter"
 import type { ImposterRepositoryShape } from "../repositories/ImposterRepository"
 import { NonEmptyString } from "../schemas/common"
 import type { Stub } from "../schemas/StubSchema"
+import { diffFields, type EventBusShape } from "../services/EventBus"
 import type { RequestLoggerShape } from "../services/RequestLogger"
 import { dashboardPage } from "./pages/dashboard"
 import { requestDetailPage } from "./pages/request-detail"
@@ -16,6 +17,7 @@ export interface UiDeps {
   readonly stubsRef: Ref.Ref<ReadonlyArray<Stub>>
   readonly repo: ImposterRepositoryShape
   readonly requestLogger: RequestLoggerShape
+  readonly eventBus: EventBusShape
   readonly runPromise: <A>(effect: Effect.Effect<A>) => Promise<A>
 }
 
@@ -102,6 +104,7 @@ export const makeUiRouter = (deps: UiDeps) => async (request: Request): Promise<
       return await deps.runPromise(
         Effect.gen(function*() {
           yield* deps.repo.addStub(deps.id, stub).pipe(
+            Effect.andThen(deps.eventBus.publish("stub.added", deps.id, { stubId, stub })),
             Effect.catchAll(() => Effect.void)
           )
           const updated = yield* deps.repo.getStubs(deps.id).pipe(
@@ -122,6 +125,7 @@ export const makeUiRouter = (deps: UiDeps) => async (request: Request): Promise<
     return deps.runPromise(
       Effect.gen(function*() {
         yield* deps.repo.removeStub(deps.id, deleteStubId).pipe(
+          Effect.tap((stub) => deps.eventBus.publish("stub.removed", deps.id, { stubId: stub.id, stub })),
           Effect.catchAll(() => Effect.void)
         )
         const updated = yield* deps.repo.getStubs(deps.id).pipe(
@@ -144,12 +148,21 @@ export const makeUiRouter = (deps: UiDeps) => async (request: Request): Promise<
 
       return await deps.runPromise(
         Effect.gen(function*() {
+          const before = (yield* Ref.get(deps.stubsRef)).find((s) => s.id === putStubId)
           yield* deps.repo.updateStub(deps.id, putStubId, (existing) => ({
             ...existing,
             ...(predicatesRaw ? { predicates: JSON.parse(predicatesRaw) } : {}),
             ...(responsesRaw ? { responses: JSON.parse(responsesRaw) } : {}),
             ...(responseMode ? { responseMode: responseMode as Stub["responseMode"] } : {})
-          })).pipe(Effect.catchAll(() => Effect.void))
+          })).pipe(
+            Effect.tap((stub) => {
+              const changes = before !== undefined
+                ? diffFields(before, stub, ["predicates", "responses", "responseMode"])
+                : {}
+              return deps.eventBus.publish("stub.updated", deps.id, { stubId: stub.id, changes })
+            }),
+            Effect.catchAll(() => Effect.void)
+          )
           const updated = yield* deps.repo.getStubs(deps.id).pipe(
             Effect.catchAll(() => Effect.succeed([] as ReadonlyArray<Stub>))
           )
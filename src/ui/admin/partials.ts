@@ -38,7 +38,7 @@ export const imposterRowPartial = (imp: AdminImposterData): SafeHtml => {
         <button hx-delete="/_ui/imposters/${imp.id}" hx-target="#imposter-list" hx-swap="innerHTML" hx-confirm="Delete this imposter?" class="text-red-500 hover:text-red-700 text-sm">Delete</button>
         ${
     isRunning
-      ? html`<a href="http://localhost:${
+      ? html`<a href="${imp.protocol === "HTTPS" ? "https" : "http"}://localhost:${
         String(imp.port)
       }/_admin" target="_blank" class="text-indigo-600 hover:text-indigo-800 text-sm">Open UI</a>`
       : html``
@@ -1,27 +1,43 @@
 import type { RequestLogEntry } from "../schemas/RequestLogSchema"
-import type { ResponseConfig, Stub } from "../schemas/StubSchema"
+import type { PredicateExpression, ResponseConfig, ResponseDelay, Stub } from "../schemas/StubSchema"
 import { html } from "./html"
 import type { SafeHtml } from "./html"
 
+const expressionSummary = (p: PredicateExpression): string => {
+  if ("and" in p) return `(${p.and.map(expressionSummary).join(" AND ")})`
+  if ("or" in p) return `(${p.or.map(expressionSummary).join(" OR ")})`
+  if ("not" in p) return `NOT ${expressionSummary(p.not)}`
+  return `${p.negate === true ? "NOT " : ""}${p.field} ${p.operator} ${JSON.stringify(p.value)}`
+}
+
 const predicateSummary = (stub: Stub): string => {
   if (stub.predicates.length === 0) return "catch-all (no predicates)"
-  return stub.predicates
-    .map((p) => `${p.field} ${p.operator} ${JSON.stringify(p.value)}`)
-    .join(" AND ")
+  return stub.predicates.map(expressionSummary).join(" AND ")
 }
 
 const formatJson = (value: unknown): string => JSON.stringify(value, null, 2)
 
+const formatDelay = (delay: ResponseDelay): string => {
+  if (typeof delay === "number") return `${String(delay)}ms`
+  if (!("distribution" in delay) || delay.distribution === "uniform") {
+    return `${String(delay.min)}-${String(delay.max)}ms`
+  }
+  return `${delay.distribution} ${String(delay.mean)}±${String(delay.stddev)}ms`
+}
+
 const responseDetail = (r: ResponseConfig, index: number, total: number): SafeHtml => {
   const label = total > 1 ? `Response ${String(index + 1)}/${String(total)}` : "Response"
   const headers = r.headers
     ? Object.entries(r.headers).map(([k, v]) => `${k}: ${v}`).join(", ")
     : null
+  const delay = r.delay !== undefined
+    ? html`<span class="text-xs text-gray-400">delay ${formatDelay(r.delay)}</span>`
+    : html``
   return html`<div class="bg-gray-50 rounded p-3 mb-2 text-sm">
     <div class="flex items-center gap-2 mb-1">
       <span class="font-medium text-gray-700">${label}</span>
       <span class="px-1.5 py-0.5 rounded text-xs font-mono bg-indigo-100 text-indigo-700">${String(r.status)}</span>
-      ${r.delay !== undefined ? html`<span class="text-xs text-gray-400">delay ${String(r.delay)}ms</span>` : html``}
+      ${delay}
     </div>
     ${headers !== null ? html`<div class="text-xs text-gray-500 mb-1">Headers: ${headers}</div>` : html``}
     ${
@@ -29,6 +45,8 @@ const responseDetail = (r: ResponseConfig, index: number, total: number): SafeHt
       ? html`<pre class="mt-1 bg-white border rounded p-2 text-xs font-mono overflow-x-auto whitespace-pre-wrap">${
         formatJson(r.body)
       }</pre>`
+      : r.bodySchema !== undefined
+      ? html`<div class="text-xs text-gray-400 italic">body generated from JSON Schema</div>`
       : html`<div class="text-xs text-gray-400 italic">no body</div>`
   }
   </div>`
@@ -15,7 +15,7 @@ describe("Conversions", () => {
         status: "stopped",
         createdAt: DateTime.unsafeNow()
       })
-      const record: ImposterRecord = { config, stubs: [] }
+      const record: ImposterRecord = { config, stubs: [], stubsAddedAt: new Map() }
 
       const response = await Effect.runPromise(toImposterResponse(record))
 
@@ -43,7 +43,8 @@ describe("Conversions", () => {
         stubs: [
           { id: "s1" as any, predicates: [], responses: [{ status: 200 }] as any, responseMode: "sequential" },
           { id: "s2" as any, predicates: [], responses: [{ status: 404 }] as any, responseMode: "sequential" }
-        ]
+        ],
+        stubsAddedAt: new Map()
       }
 
       const response = await Effect.runPromise(toImposterResponse(record))
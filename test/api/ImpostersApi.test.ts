@@ -2,6 +2,7 @@ import { HttpApiBuilder } from "@effect/platform"
 import * as Layer from "effect/Layer"
 import { ApiLayer } from "imposters/layers/ApiLayer"
 import { MainLayer } from "imposters/layers/MainLayer"
+import * as http from "node:http"
 import { describe, expect, it } from "vitest"
 
 const makeHandler = () => {
@@ -46,6 +47,22 @@ describe("Imposters API", () => {
     }
   })
 
+  it("POST /imposters keeps definition order unless stubOrder asks for specificity", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const plain = await (await handler(new Request("http://localhost/imposters", json({ name: "plain" })))).json()
+      expect(plain.stubOrder).toBeUndefined()
+      const res = await handler(
+        new Request("http://localhost/imposters", json({ name: "ranked", stubOrder: "specificity" }))
+      )
+      expect((await res.json()).stubOrder).toBe("specificity")
+      const bad = await handler(new Request("http://localhost/imposters", json({ name: "bad", stubOrder: "random" })))
+      expect(bad.status).toBe(400)
+    } finally {
+      await dispose()
+    }
+  })
+
   it("POST /imposters with no name auto-generates one", async () => {
     const { dispose, handler } = makeHandler()
     try {
@@ -197,6 +214,31 @@ describe("Imposters API", () => {
     }
   })
 
+  it("PATCH /imposters/:id selects a named example and clears it with null", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const created = await (await handler(new Request("http://localhost/imposters", json({ name: "examples" }))))
+        .json()
+      const patch = (body: object) =>
+        handler(
+          new Request(`http://localhost/imposters/${created.id}`, {
+            method: "PATCH",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify(body)
+          })
+        )
+
+      const selected = await patch({ example: "rate-limited" })
+      expect(selected.status).toBe(200)
+      expect((await selected.json()).example).toBe("rate-limited")
+
+      const cleared = await (await patch({ example: null })).json()
+      expect(cleared.example).toBeUndefined()
+    } finally {
+      await dispose()
+    }
+  })
+
   it("PATCH /imposters/:id returns 404 for non-existent", async () => {
     const { dispose, handler } = makeHandler()
     try {
@@ -259,4 +301,223 @@ describe("Imposters API", () => {
       await dispose()
     }
   })
+
+  it("GET /imposters/overview aggregates health, stubs and unmatched requests", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const createRes = await handler(new Request("http://localhost/imposters", json({ name: "ov", port: 9711 })))
+      const created = await createRes.json()
+      await handler(
+        new Request(
+          `http://localhost/imposters/${created.id}/stubs`,
+          json({
+            predicates: [{ field: "path", operator: "equals", value: "/hit" }],
+            responses: [{ status: 200 }]
+          })
+        )
+      )
+      await handler(new Request("http://localhost/imposters", json({ name: "idle", port: 9712 })))
+      await handler(
+        new Request(`http://localhost/imposters/${created.id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+      await new Promise((r) => setTimeout(r, 100))
+      await fetch("http://localhost:9711/hit")
+      await fetch("http://localhost:9711/missing")
+
+      const res = await handler(new Request("http://localhost/imposters/overview"))
+      expect(res.status).toBe(200)
+      const body = await res.json()
+      expect(body.totals).toEqual({ imposters: 2, running: 1, stubs: 1, requests: 2, unmatched: 1 })
+      const ov = body.imposters.find((i: any) => i.name === "ov")
+      expect(ov.health).toBe("up")
+      expect(ov.unmatchedCount).toBe(1)
+      expect(body.imposters.find((i: any) => i.name === "idle").health).toBe("stopped")
+      expect(body.recentUnmatched[0]).toMatchObject({ imposterName: "ov", method: "GET", path: "/missing" })
+
+      // Bulk reset clears request logs and stats
+      const resetRes = await handler(new Request("http://localhost/imposters/reset", { method: "POST" }))
+      expect((await resetRes.json()).count).toBe(2)
+      const after = await (await handler(new Request("http://localhost/imposters/overview"))).json()
+      expect(after.totals.requests).toBe(0)
+      expect(after.recentUnmatched).toEqual([])
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("POST /imposters/:id/reset clears only the scopes asked for", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const created = await (await handler(new Request("http://localhost/imposters", json({ port: 9714 })))).json()
+      const base = `http://localhost/imposters/${created.id}`
+      await handler(
+        new Request(
+          `${base}/stubs`,
+          json({ scenario: { name: "cart", requiredState: "filled" }, responses: [{ status: 200 }] })
+        )
+      )
+      const put = (path: string, body: object) =>
+        handler(new Request(`${base}${path}`, { ...json(body), method: "PUT" }))
+      await put("/scenarios/cart", { state: "filled" })
+      await put("/state", { lastOrderId: 42 })
+      const reset = (scope: string) => handler(new Request(`${base}/reset?scope=${scope}`, { method: "POST" }))
+      const current = async () => ({
+        scenarios: await (await handler(new Request(`${base}/scenarios`))).json(),
+        state: await (await handler(new Request(`${base}/state`))).json()
+      })
+
+      const res = await reset("state")
+      expect(res.status).toBe(200)
+      expect((await res.json()).scopes).toEqual(["state"])
+      expect(await current()).toEqual({ scenarios: { cart: "filled" }, state: {} })
+
+      await put("/state", { lastOrderId: 42 })
+      await reset("scenarios,counters")
+      expect(await current()).toEqual({ scenarios: { cart: "Started" }, state: { lastOrderId: 42 } })
+      expect(await (await handler(new Request(`${base}/stubs`))).json()).toHaveLength(1)
+
+      expect((await reset("routes")).status).toBe(400)
+      const missing = await handler(new Request("http://localhost/imposters/missing/reset", { method: "POST" }))
+      expect(missing.status).toBe(404)
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("POST /imposters/load creates and starts an environment, replacing a previous load", async () => {
+    const { dispose, handler } = makeHandler()
+    const manifest = {
+      name: "shop",
+      imposters: [{ name: "cart", port: 9713, stubs: [{ responses: [{ status: 200, body: { items: [] } }] }] }]
+    }
+    try {
+      const first = await handler(new Request("http://localhost/imposters/load", json(manifest)))
+      expect(first.status).toBe(201)
+      const body = await first.json()
+      expect(body.environment).toBe("shop")
+      expect(body.removed).toBe(0)
+      expect(body.imposters[0].name).toBe("shop/cart")
+      expect(body.imposters[0].status).toBe("running")
+
+      const resp = await fetch("http://localhost:9713/anything")
+      expect(await resp.json()).toEqual({ items: [] })
+
+      const second = await (await handler(new Request("http://localhost/imposters/load", json(manifest)))).json()
+      expect(second.removed).toBe(1)
+      const list = await (await handler(new Request("http://localhost/imposters"))).json()
+      expect(list.imposters).toHaveLength(1)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("POST /imposters/load removes the imposters it created when a later one fails", async () => {
+    const { dispose, handler } = makeHandler()
+    const manifest = {
+      name: "broken",
+      imposters: [
+        { name: "first", port: 9714, stubs: [{ responses: [{ status: 200 }] }] },
+        { name: "clash", port: 9714, stubs: [] }
+      ]
+    }
+    try {
+      const res = await handler(new Request("http://localhost/imposters/load", json(manifest)))
+      expect(res.status).toBe(409)
+      const list = await (await handler(new Request("http://localhost/imposters"))).json()
+      expect(list.imposters).toEqual([])
+      await expect(fetch("http://localhost:9714/anything")).rejects.toThrow()
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("POST /imposters/import restores an export document in the mode asked for", async () => {
+    const { dispose, handler } = makeHandler()
+    const stub = (path: string) => ({
+      predicates: [{ field: "path", operator: "equals", value: path }],
+      responses: [{ status: 200, body: { path } }]
+    })
+    const document = {
+      variables: { env: "restored" },
+      imposters: [
+        { name: "orders", port: 9747, stubs: [stub("/orders")] },
+        { name: "users", port: 9748, stubs: [stub("/users")] }
+      ]
+    }
+    const importAs = async (mode: string) =>
+      handler(new Request(`http://localhost/imposters/import?mode=${mode}`, json(document)))
+    const stubCount = async (id: string) =>
+      (await (await handler(new Request(`http://localhost/imposters/${id}/stubs`))).json()).length
+    const variables = async () => (await handler(new Request("http://localhost/variables"))).json()
+    try {
+      await handler(new Request("http://localhost/variables", { ...json({ env: "local", team: "a" }), method: "PUT" }))
+      const orders = await (await handler(new Request("http://localhost/imposters", json({ port: 9747 })))).json()
+      await handler(new Request(`http://localhost/imposters/${orders.id}/stubs`, json(stub("/health"))))
+
+      const skipping = await (await importAs("skip-conflicts")).json()
+      expect(skipping.created.map((imp: { name: string }) => imp.name)).toEqual(["users"])
+      expect(skipping.skipped).toEqual([
+        { kind: "variable", name: "env", reason: "Already set" },
+        { kind: "imposter", name: "orders", reason: `Port 9747 is taken by imposter ${orders.name}` }
+      ])
+      expect(await variables()).toEqual({ env: "local", team: "a" })
+      expect(await (await fetch("http://localhost:9748/users")).json()).toEqual({ path: "/users" })
+
+      const merging = await (await importAs("merge")).json()
+      expect(merging.created).toEqual([])
+      expect(merging.updated.map((imp: { id: string }) => imp.id)).toContain(orders.id)
+      // users already serves the document's stub, so it isn't added twice
+      expect(merging.skipped).toEqual([
+        { kind: "stub", name: "users", reason: "A stub with the same predicates is already there" }
+      ])
+      expect(await stubCount(orders.id)).toBe(2)
+      expect(await variables()).toEqual({ env: "restored", team: "a" })
+      await importAs("merge")
+      expect(await stubCount(orders.id)).toBe(2)
+
+      const replacing = await (await importAs("replace")).json()
+      expect(replacing).toMatchObject({ mode: "replace", removed: 2, updated: [], skipped: [] })
+      expect(replacing.created.map((imp: { status: string }) => imp.status)).toEqual(["running", "running"])
+      expect(await stubCount(replacing.created[0].id)).toBe(1)
+      expect(await variables()).toEqual({ env: "restored" })
+      expect(await (await fetch("http://localhost:9747/orders")).json()).toEqual({ path: "/orders" })
+
+      expect((await importAs("overwrite")).status).toBe(400)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("POST /imposters/import puts everything back when an imposter fails to start", async () => {
+    const { dispose, handler } = makeHandler()
+    const blocker = http.createServer()
+    await new Promise<void>((resolve) => blocker.listen(9749, resolve))
+    const document = {
+      variables: { env: "broken" },
+      imposters: [{ name: "fresh", port: 9750, stubs: [] }, { name: "blocked", port: 9749, stubs: [] }]
+    }
+    try {
+      await handler(new Request("http://localhost/variables", { ...json({ env: "local" }), method: "PUT" }))
+      const kept = await (await handler(new Request("http://localhost/imposters", json({ port: 9751 })))).json()
+      await handler(new Request(`http://localhost/imposters/${kept.id}/stubs`, json({ responses: [{ status: 204 }] })))
+      const start = { ...json({ status: "running" }), method: "PATCH" }
+      await handler(new Request(`http://localhost/imposters/${kept.id}`, start))
+
+      const failed = await handler(new Request("http://localhost/imposters/import?mode=replace", json(document)))
+      expect(failed.status).toBe(503)
+      const list = await (await handler(new Request("http://localhost/imposters"))).json()
+      expect(list.imposters.map((imp: { id: string; status: string }) => [imp.id, imp.status]))
+        .toEqual([[kept.id, "running"]])
+      expect((await fetch("http://localhost:9751/anything")).status).toBe(204)
+      expect(await (await handler(new Request("http://localhost/variables"))).json()).toEqual({ env: "local" })
+      await expect(fetch("http://localhost:9750/anything")).rejects.toThrow()
+    } finally {
+      await dispose()
+      await new Promise((resolve) => blocker.close(resolve))
+    }
+  }, 10000)
 })
@@ -65,4 +65,196 @@ describe("Requests API", () => {
       await dispose()
     }
   })
+
+  it("POST /imposters/:id/verify checks what was requested and served", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const createRes = await handler(new Request("http://localhost/imposters", json({ name: "verify", port: 9742 })))
+      const created = await createRes.json()
+      await handler(
+        new Request(
+          `http://localhost/imposters/${created.id}/stubs`,
+          json({ responses: [{ status: 201, body: { variant: "created" } }] })
+        )
+      )
+      await handler(
+        new Request(`http://localhost/imposters/${created.id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+      await new Promise((r) => setTimeout(r, 100))
+      await fetch("http://localhost:9742/orders", { method: "POST" })
+
+      const res = await handler(
+        new Request(
+          `http://localhost/imposters/${created.id}/verify`,
+          json({
+            request: [{ field: "path", operator: "equals", value: "/orders" }],
+            response: {
+              status: 201,
+              predicates: [{ field: "body", operator: "equals", value: { variant: "created" } }]
+            },
+            times: { exactly: 1 }
+          })
+        )
+      )
+      expect(res.status).toBe(200)
+      const result = await res.json()
+      expect(result.passed).toBe(true)
+      expect(result.statuses).toEqual({ "201": 1 })
+
+      const wrong = await (await handler(
+        new Request(`http://localhost/imposters/${created.id}/verify`, json({ response: { status: 200 } }))
+      )).json()
+      expect(wrong.passed).toBe(false)
+      expect(wrong.requestMatches).toBe(1)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("POST /imposters/:id/verify returns 404 for non-existent imposter", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const res = await handler(new Request("http://localhost/imposters/nonexistent/verify", json({})))
+      expect(res.status).toBe(404)
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("POST /imposters/:id/requests/:requestId/promote turns a miss into a stub", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const created = await (await handler(
+        new Request("http://localhost/imposters", json({ name: "promote", port: 9744 }))
+      )).json()
+      await handler(
+        new Request(`http://localhost/imposters/${created.id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+      await new Promise((r) => setTimeout(r, 100))
+      expect((await fetch("http://localhost:9744/reports?year=2026")).status).toBe(404)
+
+      const [miss] = await (await handler(new Request(`http://localhost/imposters/${created.id}/requests`))).json()
+      const promote = (entryId: string) =>
+        handler(
+          new Request(
+            `http://localhost/imposters/${created.id}/requests/${entryId}/promote`,
+            json({ response: { body: { reports: [] } } })
+          )
+        )
+      const res = await promote(miss.id)
+      expect(res.status).toBe(201)
+      const stub = await res.json()
+      expect(stub.predicates).toEqual([
+        { field: "method", operator: "equals", value: "GET", caseSensitive: true },
+        { field: "path", operator: "equals", value: "/reports", caseSensitive: true },
+        { field: "query", operator: "equals", value: { year: "2026" }, caseSensitive: true }
+      ])
+
+      const served = await fetch("http://localhost:9744/reports?year=2026")
+      expect(await served.json()).toEqual({ reports: [] })
+
+      const entries = await (await handler(new Request(`http://localhost/imposters/${created.id}/requests`))).json()
+      const hit = entries.find((e: any) => e.response.matchedStubId === stub.id)
+      expect((await promote(hit.id)).status).toBe(409)
+      expect((await promote("nonexistent")).status).toBe(404)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("journals what matching cost and aggregates it in stats", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const created = await (await handler(
+        new Request("http://localhost/imposters", json({ name: "timings", port: 9743 }))
+      )).json()
+      for (const [path, body] of [["/a", "a"], ["/b", "{{request.path}}"]]) {
+        await handler(
+          new Request(
+            `http://localhost/imposters/${created.id}/stubs`,
+            json({ predicates: [{ field: "path", operator: "equals", value: path }], responses: [{ body }] })
+          )
+        )
+      }
+      await handler(
+        new Request(`http://localhost/imposters/${created.id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+      await new Promise((r) => setTimeout(r, 100))
+      await fetch("http://localhost:9743/b")
+      await fetch("http://localhost:9743/missing")
+
+      const entries = await (await handler(new Request(`http://localhost/imposters/${created.id}/requests`))).json()
+      const byPath = Object.fromEntries(entries.map((e: any) => [e.request.path, e.timings]))
+      expect(byPath["/b"].candidates).toBe(2)
+      expect(byPath["/b"].renderMs).toBeGreaterThanOrEqual(0)
+      expect(byPath["/missing"].candidates).toBe(2)
+      expect(byPath["/missing"].renderMs).toBeUndefined()
+
+      const stats = await (await handler(new Request(`http://localhost/imposters/${created.id}/stats`))).json()
+      expect(stats.matching.averageCandidates).toBe(2)
+      expect(stats.matching.maxCandidates).toBe(2)
+      expect(stats.matching.p95MatchMs).toBeGreaterThanOrEqual(0)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("journals the delay actually injected and the bytes sent", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const created = await (await handler(
+        new Request("http://localhost/imposters", json({ name: "injected", port: 9745 }))
+      )).json()
+      await handler(
+        new Request(
+          `http://localhost/imposters/${created.id}/stubs`,
+          json({
+            predicates: [{ field: "path", operator: "equals", value: "/slow" }],
+            responses: [{ body: "0123456789", delay: { min: 50, max: 80 } }]
+          })
+        )
+      )
+      await handler(
+        new Request(
+          `http://localhost/imposters/${created.id}/stubs`,
+          json({
+            predicates: [{ field: "path", operator: "equals", value: "/cut" }],
+            responses: [{ body: "0123456789", fault: { type: "truncate", bytes: 4 } }]
+          })
+        )
+      )
+      await handler(
+        new Request(`http://localhost/imposters/${created.id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+      await new Promise((r) => setTimeout(r, 100))
+      await fetch("http://localhost:9745/slow")
+      await fetch("http://localhost:9745/cut").then((r) => r.text()).catch(() => undefined)
+
+      const entries = await (await handler(new Request(`http://localhost/imposters/${created.id}/requests`))).json()
+      const byPath = Object.fromEntries(entries.map((e: any) => [e.request.path, e]))
+      expect(byPath["/slow"].timings.delayMs).toBeGreaterThanOrEqual(50)
+      expect(byPath["/slow"].timings.delayMs).toBeLessThanOrEqual(80)
+      expect(byPath["/slow"].response.bytes).toBe(10)
+      expect(byPath["/cut"].timings.delayMs).toBeUndefined()
+      expect(byPath["/cut"].response.bytes).toBe(4)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
 })
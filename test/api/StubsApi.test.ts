@@ -1,4 +1,5 @@
 import { HttpApiBuilder } from "@effect/platform"
+import * as ConfigProvider from "effect/ConfigProvider"
 import * as Layer from "effect/Layer"
 import { ApiLayer } from "imposters/layers/ApiLayer"
 import { MainLayer } from "imposters/layers/MainLayer"
@@ -242,4 +243,265 @@ describe("Stubs API", () => {
       await dispose()
     }
   })
+
+  it("PUT /imposters/:id/stubs replaces the stub set and drains in-flight requests", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const createRes = await handler(new Request("http://localhost/imposters", json({ name: "swap", port: 9721 })))
+      const imposter = await createRes.json()
+      await handler(
+        new Request(
+          `http://localhost/imposters/${imposter.id}/stubs`,
+          json({ responses: [{ status: 200, body: { version: 1 }, delay: 300 }] })
+        )
+      )
+      await handler(
+        new Request(`http://localhost/imposters/${imposter.id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+      await new Promise((r) => setTimeout(r, 100))
+
+      const inFlight = fetch("http://localhost:9721/anything").then((r) => r.json())
+      await new Promise((r) => setTimeout(r, 50))
+
+      const res = await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/stubs`, {
+          method: "PUT",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify([{ responses: [{ status: 200, body: { version: 2 } }] }])
+        })
+      )
+      expect(res.status).toBe(200)
+      const stubs = await res.json()
+      expect(stubs).toHaveLength(1)
+      expect(stubs[0].id).toBeDefined()
+
+      // New requests see the new set immediately; the in-flight one finishes on the old set
+      expect(await (await fetch("http://localhost:9721/anything")).json()).toEqual({ version: 2 })
+      expect(await inFlight).toEqual({ version: 1 })
+
+      await new Promise((r) => setTimeout(r, 50))
+      const events = await (await handler(
+        new Request(`http://localhost/events?imposterId=${imposter.id}&type=stubs.swapped`)
+      )).json()
+      expect(events).toHaveLength(1)
+      expect(events[0].data).toMatchObject({ stubCount: 1, drainedRequests: 1, timedOut: false })
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("PUT /imposters/:id/stubs returns 404 for unknown imposter", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const res = await handler(
+        new Request("http://localhost/imposters/missing/stubs", {
+          method: "PUT",
+          headers: { "Content-Type": "application/json" },
+          body: "[]"
+        })
+      )
+      expect(res.status).toBe(404)
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("GET /imposters/:id/stubs pages with limit and offset", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const imposter = await createImposter(handler, "page-test")
+      for (let i = 0; i < 5; i++) {
+        await handler(
+          new Request(`http://localhost/imposters/${imposter.id}/stubs`, json({ responses: [{ status: 200 + i }] }))
+        )
+      }
+
+      const all = await (await handler(new Request(`http://localhost/imposters/${imposter.id}/stubs`))).json()
+      expect(all).toHaveLength(5)
+
+      const res = await handler(new Request(`http://localhost/imposters/${imposter.id}/stubs?limit=2&offset=3`))
+      const page = await res.json()
+      expect(page.map((s: any) => s.responses[0].status)).toEqual([203, 204])
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("POST /imposters/:id/stubs/prune removes stubs not hit within the window", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const createRes = await handler(new Request("http://localhost/imposters", json({ name: "prune", port: 9741 })))
+      const imposter = await createRes.json()
+      for (const path of ["/used", "/stale"]) {
+        await handler(
+          new Request(
+            `http://localhost/imposters/${imposter.id}/stubs`,
+            json({ predicates: [{ field: "path", operator: "equals", value: path }], responses: [{ status: 200 }] })
+          )
+        )
+      }
+      await handler(
+        new Request(`http://localhost/imposters/${imposter.id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+      await new Promise((r) => setTimeout(r, 300))
+      await fetch("http://localhost:9741/used")
+
+      const dry = await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/stubs/prune?unusedFor=200ms&dryRun=true`, {
+          method: "POST"
+        })
+      )
+      expect(dry.status).toBe(200)
+      const preview = await dry.json()
+      expect(preview.dryRun).toBe(true)
+      expect(preview.pruned).toHaveLength(1)
+      expect(preview.pruned[0].hits).toBe(0)
+      expect(preview.remaining).toBe(1)
+
+      const res = await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/stubs/prune?unusedFor=200ms`, { method: "POST" })
+      )
+      const result = await res.json()
+      expect(result.dryRun).toBe(false)
+      expect(result.remaining).toBe(1)
+
+      const stubs = await (await handler(new Request(`http://localhost/imposters/${imposter.id}/stubs`))).json()
+      expect(stubs.map((s: any) => s.predicates[0].value)).toEqual(["/used"])
+      expect((await fetch("http://localhost:9741/stale")).status).toBe(404)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("POST /imposters/:id/stubs/prune judges a stub never hit from when it was added", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const imposter = await createImposter(handler, "prune-late")
+      await new Promise((r) => setTimeout(r, 300))
+      await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/stubs`, json({ responses: [{ status: 200 }] }))
+      )
+
+      const res = await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/stubs/prune?unusedFor=200ms`, { method: "POST" })
+      )
+      expect(await res.json()).toEqual({ dryRun: false, pruned: [], remaining: 1 })
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("POST /imposters/:id/stubs/prune rejects a malformed window", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const imposter = await createImposter(handler, "prune-bad")
+      const res = await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/stubs/prune?unusedFor=soon`, { method: "POST" })
+      )
+      expect(res.status).toBe(400)
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("POST /imposters/:id/presets adds the stubs of a preset", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const imposter = await createImposter(handler, "preset-test")
+      const res = await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/presets`, json({ preset: "ntlm", schemes: ["ntlm"] }))
+      )
+      expect(res.status).toBe(201)
+      const added = await res.json()
+      expect(added).toHaveLength(2)
+      expect(added.every((s: any) => s.priority === 100 && s.responses[0].status === 401)).toBe(true)
+
+      const stubs = await (await handler(new Request(`http://localhost/imposters/${imposter.id}/stubs`))).json()
+      expect(stubs.map((s: any) => s.id)).toEqual(added.map((s: any) => s.id))
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("POST /imposters/:id/presets rejects unknown presets", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const imposter = await createImposter(handler, "preset-unknown")
+      const res = await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/presets`, json({ preset: "nope" }))
+      )
+      expect(res.status).toBe(400)
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("derives stub IDs from predicates for imposters with stubIds: content", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const create = (name: string) =>
+        handler(new Request("http://localhost/imposters", json({ name, stubIds: "content" }))).then((r) => r.json())
+      const first = await create("stable-ids-1")
+      const second = await create("stable-ids-2")
+      expect(first.stubIds).toBe("content")
+
+      const stubs = [
+        { predicates: [{ field: "path", operator: "equals", value: "/users" }], responses: [{ status: 200 }] },
+        { predicates: [{ field: "path", operator: "equals", value: "/orders" }], responses: [{ status: 200 }] },
+        { predicates: [{ field: "path", operator: "equals", value: "/users" }], responses: [{ status: 500 }] }
+      ]
+      const put = (id: string) =>
+        handler(new Request(`http://localhost/imposters/${id}/stubs`, { ...json(stubs), method: "PUT" }))
+          .then((r) => r.json())
+      const ids = (await put(first.id)).map((s: any) => s.id)
+      expect(ids[0]).toMatch(/^[0-9a-f]{8}$/)
+      expect(ids[2]).toBe(`${ids[0]}-2`)
+      // Same content, same IDs: on another imposter and when loaded again
+      expect((await put(second.id)).map((s: any) => s.id)).toEqual(ids)
+      expect((await put(first.id)).map((s: any) => s.id)).toEqual(ids)
+
+      const added = await handler(new Request(`http://localhost/imposters/${first.id}/stubs`, json(stubs[1]!)))
+      expect((await added.json()).id).toBe(`${ids[1]}-2`)
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("never hands out a taken stub ID, and answers 409 once none is left", async () => {
+    // Two characters, four long: 16 possible IDs
+    const env = ConfigProvider.fromMap(new Map([["ID_ALPHABET", "ab"], ["ID_LENGTH", "4"]]))
+    const { dispose, handler } = HttpApiBuilder.toWebHandler(
+      ApiLayer.pipe(Layer.provide(MainLayer), Layer.provide(Layer.setConfigProvider(env)))
+    )
+    try {
+      const imposter = await createImposter(handler, "narrow-ids")
+      expect(imposter.id).toMatch(/^[ab]{4}$/)
+
+      const ids: Array<string> = []
+      for (let i = 0; i < 24; i++) {
+        const res = await handler(
+          new Request(`http://localhost/imposters/${imposter.id}/stubs`, json({ responses: [{ status: 200 }] }))
+        )
+        if (res.status === 201) {
+          ids.push((await res.json()).id)
+        } else {
+          expect(res.status).toBe(409)
+          expect((await res.json()).message).toContain("ID_LENGTH")
+        }
+      }
+      expect(new Set(ids).size).toBe(ids.length)
+      expect(ids.length).toBeGreaterThanOrEqual(8)
+      expect(ids.length).toBeLessThanOrEqual(16)
+    } finally {
+      await dispose()
+    }
+  })
 })
@@ -59,7 +59,7 @@ describe("System API", () => {
       const body = await res.json()
       expect(body.server.name).toBe("imposters")
       expect(body.server.version).toBe("0.0.0")
-      expect(body.server.protocols).toEqual(["HTTP"])
+      expect(body.server.protocols).toEqual(["HTTP", "HTTPS"])
       expect(body.configuration.maxImposters).toBe(100)
       expect(body.configuration.portRange.min).toBe(3000)
       expect(body.configuration.portRange.max).toBe(4000)
@@ -70,6 +70,200 @@ describe("System API", () => {
     }
   })
 
+  it("PUT and PATCH /variables replace and merge the shared template variables", async () => {
+    const { dispose, handler } = makeHandler()
+    const send = (method: string, body: unknown) =>
+      handler(
+        new Request("http://localhost/variables", {
+          method,
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify(body)
+        })
+      ).then((res) => res.json())
+    try {
+      expect(await send("PUT", { tenant: "acme", baseUrl: "http://localhost:4000" }))
+        .toEqual({ tenant: "acme", baseUrl: "http://localhost:4000" })
+      expect(await send("PATCH", { tenant: "globex", baseUrl: null })).toEqual({ tenant: "globex" })
+      const res = await handler(new Request("http://localhost/variables"))
+      expect(await res.json()).toEqual({ tenant: "globex" })
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("PATCH /flags sets feature flags in the flags variable", async () => {
+    const { dispose, handler } = makeHandler()
+    const send = (method: string, path: string, body: unknown) =>
+      handler(
+        new Request(`http://localhost${path}`, {
+          method,
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify(body)
+        })
+      ).then((res) => res.json())
+    try {
+      await send("PUT", "/variables", { tenant: "acme" })
+      expect(await send("PATCH", "/flags", { "new-checkout": true, banner: "Hi" }))
+        .toEqual({ "new-checkout": true, banner: "Hi" })
+      expect(await send("PATCH", "/flags", { banner: null })).toEqual({ "new-checkout": true })
+      const res = await handler(new Request("http://localhost/variables"))
+      expect(await res.json()).toEqual({ tenant: "acme", flags: { "new-checkout": true } })
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("PUT and DELETE /chaos switch chaos on and off", async () => {
+    const { dispose, handler } = makeHandler()
+    const send = (method: string, body?: unknown) =>
+      handler(
+        new Request("http://localhost/chaos", {
+          method,
+          headers: { "Content-Type": "application/json" },
+          ...(body !== undefined ? { body: JSON.stringify(body) } : {})
+        })
+      ).then((res) => res.json())
+    try {
+      expect(await send("GET")).toEqual({ enabled: false })
+      expect(await send("PUT", { errorRate: 0.05, extraLatencyMs: 200 })).toEqual({
+        enabled: true,
+        chaos: { errorRate: 0.05, errorStatus: 503, extraLatencyMs: 200 }
+      })
+      expect((await send("GET")).enabled).toBe(true)
+      expect(await send("DELETE")).toEqual({ enabled: false })
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("PUT /profiles/:name defines settings and stubs imposters are created from", async () => {
+    const { dispose, handler } = makeHandler()
+    const send = (method: string, path: string, body?: unknown) =>
+      handler(
+        new Request(`http://localhost${path}`, {
+          method,
+          headers: { "Content-Type": "application/json" },
+          ...(body !== undefined ? { body: JSON.stringify(body) } : {})
+        })
+      )
+    try {
+      const health = {
+        predicates: [{ field: "path", operator: "equals", value: "/health" }],
+        responses: [{ status: 200 }]
+      }
+      expect((await send("PUT", "/profiles/base", { stubIds: "content", stubs: [health] })).status).toBe(200)
+      expect((await send("PUT", "/profiles/lenient", { extends: "base", httpParser: "lenient" })).status).toBe(200)
+      expect((await send("PUT", "/profiles/orphan", { extends: "missing" })).status).toBe(404)
+      expect((await send("PUT", "/profiles/base", { extends: "lenient" })).status).toBe(409)
+
+      const res = await send("POST", "/imposters", { profile: "lenient", stubIds: "random" })
+      expect(res.status).toBe(201)
+      const imposter = await res.json()
+      expect(imposter).toMatchObject({ profile: "lenient", httpParser: "lenient", stubIds: "random" })
+      const stubs = await (await send("GET", `/imposters/${imposter.id}/stubs`)).json()
+      expect(stubs).toHaveLength(1)
+
+      expect((await send("POST", "/imposters", { profile: "missing" })).status).toBe(404)
+      expect((await send("DELETE", "/profiles/base")).status).toBe(409)
+      expect((await send("DELETE", "/profiles/lenient")).status).toBe(200)
+      expect(Object.keys(await (await send("GET", "/profiles")).json())).toEqual(["base"])
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("GET /export writes bodies served more than once a single time", async () => {
+    const { dispose, handler } = makeHandler()
+    const post = (path: string, body: unknown) =>
+      handler(
+        new Request(`http://localhost${path}`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify(body)
+        })
+      )
+    try {
+      const recorded = { items: Array.from({ length: 20 }, (_, i) => ({ id: i, sku: `SKU-${i}` })) }
+      const imposter = await (await post("/imposters", { name: "recorded", port: 9746 })).json()
+      for (const path of ["/v1/items", "/v2/items"]) {
+        await post(`/imposters/${imposter.id}/stubs`, {
+          predicates: [{ field: "path", operator: "equals", value: path }],
+          responses: [{ status: 200, body: recorded }]
+        })
+      }
+
+      const exported = await (await handler(new Request("http://localhost/export"))).json()
+      const [hash] = Object.keys(exported.bodies)
+      expect(exported.bodies).toEqual({ [hash!]: recorded })
+      expect(exported.imposters).toMatchObject([{
+        name: "recorded",
+        port: 9746,
+        stubs: [{ responses: [{ bodyRef: hash }] }, { responses: [{ bodyRef: hash }] }]
+      }])
+
+      const go = await handler(new Request("http://localhost/export?format=go&package=checkout_test"))
+      expect(go.headers.get("content-type")).toContain("text/x-go")
+      expect(await go.text()).toContain("package checkout_test\n")
+      const curl = await handler(new Request("http://localhost/export?format=curl"))
+      expect(curl.headers.get("content-type")).toContain("text/x-shellscript")
+      // Shared bodies go back in place, as the script has nowhere to keep them
+      expect(await curl.text()).not.toContain("bodyRef")
+
+      await handler(new Request(`http://localhost/imposters/${imposter.id}`, { method: "DELETE" }))
+      const loaded = await post("/imposters/load", { name: "restored", ...exported })
+      expect(loaded.status).toBe(201)
+      const [restored] = (await loaded.json()).imposters
+      const stubs = await (await handler(new Request(`http://localhost/imposters/${restored.id}/stubs`))).json()
+      expect(stubs.map((s: { responses: Array<{ body: unknown }> }) => s.responses[0]?.body)).toEqual([
+        recorded,
+        recorded
+      ])
+      expect((await post("/imposters/load", { name: "broken", ...exported, bodies: {} })).status).toBe(400)
+      await handler(new Request(`http://localhost/imposters/${restored.id}?force=true`, { method: "DELETE" }))
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("GET /errors lists the codes and hints failures carry", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const catalogue = await (await handler(new Request("http://localhost/errors"))).json()
+      const notFound = catalogue.find((e: { code: string }) => e.code === "imposter_not_found")
+      expect(notFound).toMatchObject({ status: 404, description: "Imposter not found" })
+
+      const res = await handler(new Request("http://localhost/imposters/missing"))
+      expect(res.status).toBe(404)
+      expect(await res.json()).toEqual({
+        _tag: "ApiNotFoundError",
+        code: "imposter_not_found",
+        message: "Imposter not found",
+        resourceType: "imposter",
+        resourceId: "missing",
+        hint: notFound.hint
+      })
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("POST /shares needs an admin token to sign shares with", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const res = await handler(
+        new Request("http://localhost/shares", {
+          method: "POST",
+          headers: { "content-type": "application/json" },
+          body: JSON.stringify({ ttl: 600 })
+        })
+      )
+      expect(res.status).toBe(409)
+      expect((await res.json()).code).toBe("admin_unprotected")
+    } finally {
+      await dispose()
+    }
+  })
+
   it("GET /openapi.json returns OpenAPI spec", async () => {
     const { dispose, handler } = makeHandler()
     try {
@@ -82,4 +276,155 @@ describe("System API", () => {
       await dispose()
     }
   })
+
+  it("GET /schema/config.json and /schema/stub.json publish JSON Schemas for editors", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const config = await (await handler(new Request("http://localhost/schema/config.json"))).json()
+      expect(config.type).toBe("object")
+      expect(Object.keys(config.properties)).toEqual([
+        "$schema",
+        "admin",
+        "variables",
+        "profiles",
+        "imposters",
+        "bodies"
+      ])
+      expect(config.required ?? []).toEqual([])
+
+      const stub = await (await handler(new Request("http://localhost/schema/stub.json"))).json()
+      expect(stub.properties.$schema).toEqual({ type: "string", description: "URL of this JSON Schema" })
+      expect(stub.required).toEqual(["responses"])
+      expect(stub.$defs.PredicateExpression).toBeDefined()
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("GET /ready probes the proxy targets and callback URLs of running imposters", async () => {
+    const { dispose, handler } = makeHandler()
+    const post = (url: string, body: object) =>
+      handler(
+        new Request(url, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify(body)
+        })
+      )
+    const start = (id: string) =>
+      handler(
+        new Request(`http://localhost/imposters/${id}`, {
+          method: "PATCH",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ status: "running" })
+        })
+      )
+    try {
+      const upstream = await (await post("http://localhost/imposters", { name: "upstream", port: 9731 })).json()
+      const gateway = await (await post("http://localhost/imposters", {
+        name: "gateway",
+        port: 9732,
+        proxy: { targetUrl: "http://localhost:9731" }
+      })).json()
+      await post(`http://localhost/imposters/${gateway.id}/stubs`, {
+        predicates: [{ field: "path", operator: "startsWith", value: "/legacy" }],
+        responses: [{ status: 200, proxy: { targetUrl: "http://127.0.0.1:1" } }]
+      })
+      const notifying = await (await post(`http://localhost/imposters/${gateway.id}/stubs`, {
+        predicates: [{ field: "path", operator: "equals", value: "/orders" }],
+        responses: [{
+          status: 201,
+          callbacks: [{ url: "http://127.0.0.1:2/hooks" }, { url: "http://{{request.headers.x-callback-host}}/hooks" }]
+        }]
+      })).json()
+      await start(upstream.id)
+      await start(gateway.id)
+      await new Promise((r) => setTimeout(r, 100))
+
+      const res = await handler(new Request("http://localhost/ready?timeout=500"))
+      expect(res.status).toBe(200)
+      const body = await res.json()
+      expect(body.ready).toBe(false)
+      const deps = body.imposters.find((i: any) => i.name === "gateway").dependencies
+      expect(deps.find((d: any) => d.url === "http://localhost:9731")).toMatchObject({
+        reachable: true,
+        status: 404,
+        sources: ["imposter"]
+      })
+      const broken = deps.find((d: any) => d.url === "http://127.0.0.1:1")
+      expect(broken.reachable).toBe(false)
+      expect(broken.error).toBeDefined()
+      expect(deps.find((d: any) => d.url === "http://127.0.0.1:2/hooks")).toMatchObject({
+        reachable: false,
+        sources: [`stub:${notifying.id}`]
+      })
+      expect(deps.some((d: any) => d.url.includes("{{"))).toBe(false)
+
+      const skipped = await (await handler(new Request("http://localhost/ready?probe=false"))).json()
+      expect(skipped.ready).toBe(true)
+      expect(skipped.imposters.every((i: any) => i.dependencies.length === 0)).toBe(true)
+    } finally {
+      await dispose()
+    }
+  }, 10000)
+
+  it("GET /events records change events with field diffs", async () => {
+    const { dispose, handler } = makeHandler()
+    const send = (method: string, url: string, body: object) =>
+      handler(new Request(url, { method, headers: { "Content-Type": "application/json" }, body: JSON.stringify(body) }))
+    try {
+      const imposter = await (await send("POST", "http://localhost/imposters", { name: "events" })).json()
+      const stub = await (await send("POST", `http://localhost/imposters/${imposter.id}/stubs`, {
+        responses: [{ status: 200 }]
+      })).json()
+      await send("PUT", `http://localhost/imposters/${imposter.id}/stubs/${stub.id}`, {
+        responses: [{ status: 201 }]
+      })
+
+      const events = await (await handler(new Request(`http://localhost/events?imposterId=${imposter.id}`))).json()
+      expect(events.map((e: any) => e.type)).toEqual(["imposter.created", "stub.added", "stub.updated"])
+      expect(events[2].data).toMatchObject({
+        stubId: stub.id,
+        changes: { responses: { before: [{ status: 200 }], after: [{ status: 201 }] } }
+      })
+      expect(Object.keys(events[2].data.changes)).toEqual(["responses"])
+    } finally {
+      await dispose()
+    }
+  })
+
+  it("GET /events/stream pushes change events as server-sent events", async () => {
+    const { dispose, handler } = makeHandler()
+    try {
+      const imposter = await (await handler(
+        new Request("http://localhost/imposters", {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ name: "stream" })
+        })
+      )).json()
+
+      const res = await handler(new Request(`http://localhost/events/stream?imposterId=${imposter.id}`))
+      expect(res.headers.get("content-type")).toContain("text/event-stream")
+      const reader = res.body!.getReader()
+      const decoder = new TextDecoder()
+      let received = decoder.decode((await reader.read()).value)
+      expect(received).toContain(": connected")
+
+      await handler(
+        new Request(`http://localhost/imposters/${imposter.id}/stubs`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ responses: [{ status: 200 }] })
+        })
+      )
+      while (!received.includes("event: stub.added")) {
+        received += decoder.decode((await reader.read()).value)
+      }
+      expect(received).toContain(`"imposterId":"${imposter.id}"`)
+      await reader.cancel()
+    } finally {
+      await dispose()
+    }
+  }, 10000)
 })
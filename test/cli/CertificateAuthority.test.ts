@@ -0,0 +1,88 @@
+import { Effect } from "effect"
+import {
+  caFiles,
+  initCa,
+  loadCa,
+  mintCertificate,
+  shellQuote,
+  trustSteps
+} from "imposters/cli/CertificateAuthority"
+import { X509Certificate } from "node:crypto"
+import * as fs from "node:fs"
+import * as os from "node:os"
+import * as path from "node:path"
+import { afterEach, beforeEach, describe, expect, it } from "vitest"
+
+let dir: string
+
+beforeEach(() => {
+  dir = path.join(fs.mkdtempSync(path.join(os.tmpdir(), "imposters-ca-")), "ca")
+})
+
+afterEach(() => {
+  fs.rmSync(path.dirname(dir), { recursive: true, force: true })
+})
+
+describe("CertificateAuthority", () => {
+  it("creates the CA with a private key only the owner can read", async () => {
+    const certPath = await Effect.runPromise(initCa(dir, { force: false }))
+    expect(certPath).toBe(caFiles(dir).cert)
+    expect(new X509Certificate(fs.readFileSync(certPath)).ca).toBe(true)
+    if (process.platform !== "win32") expect(fs.statSync(caFiles(dir).key).mode & 0o777).toBe(0o600)
+  })
+
+  it("keeps an existing CA unless forced", async () => {
+    await Effect.runPromise(initCa(dir, { force: false }))
+    const before = fs.readFileSync(caFiles(dir).cert, "utf-8")
+    const error = await Effect.runPromise(Effect.flip(initCa(dir, { force: false })))
+    expect(error.message).toContain("already exists")
+    await Effect.runPromise(initCa(dir, { force: true }))
+    expect(fs.readFileSync(caFiles(dir).cert, "utf-8")).not.toBe(before)
+  })
+
+  it("asks for init when there is no CA", async () => {
+    const error = await Effect.runPromise(Effect.flip(loadCa(dir)))
+    expect(error.message).toContain("imposters ca init")
+  })
+
+  it("mints certificates signed by the CA", async () => {
+    await Effect.runPromise(initCa(dir, { force: false }))
+    const ca = new X509Certificate(fs.readFileSync(caFiles(dir).cert))
+    const minted = await Effect.runPromise(mintCertificate(dir, ["localhost", "api.example.com"]))
+    const cert = new X509Certificate(minted.cert)
+    expect(cert.checkIssued(ca)).toBe(true)
+    expect(cert.checkHost("api.example.com")).toBe("api.example.com")
+  })
+})
+
+describe("trustSteps", () => {
+  const installed = (platform: NodeJS.Platform, dirs: ReadonlyArray<string> = []) =>
+    trustSteps("/ca/ca.crt", platform, (p) => dirs.includes(p))
+      .filter((step) => step.install)
+      .flatMap((step) => step.commands)
+
+  it("adds the CA to the OS store", () => {
+    expect(installed("darwin")[0]).toContain("add-trusted-cert")
+    expect(installed("win32")).toEqual([["certutil", "-user", "-addstore", "Root", "/ca/ca.crt"]])
+    expect(installed("linux", ["/usr/local/share/ca-certificates"])).toEqual([
+      ["sudo", "cp", "/ca/ca.crt", "/usr/local/share/ca-certificates/imposters-local-ca.crt"],
+      ["sudo", "update-ca-certificates"]
+    ])
+    expect(installed("linux", ["/etc/pki/ca-trust/source/anchors"])[1]).toEqual(["sudo", "update-ca-trust"])
+    expect(installed("linux")).toEqual([["sudo", "trust", "anchor", "--store", "/ca/ca.crt"]])
+  })
+
+  it("explains clients with their own trust store", () => {
+    const manual = trustSteps("/ca/ca.crt", "linux", () => false).filter((step) => !step.install)
+    expect(manual.map((step) => step.commands[0]![0])).toEqual(["certutil", "export", "curl"])
+    expect(manual[1]!.commands[0]).toEqual(["export", "NODE_EXTRA_CA_CERTS=/ca/ca.crt"])
+  })
+})
+
+describe("shellQuote", () => {
+  it("quotes arguments the shell would split or expand", () => {
+    expect(shellQuote("/ca/ca.crt")).toBe("/ca/ca.crt")
+    expect(shellQuote("Imposters local CA")).toBe("'Imposters local CA'")
+    expect(shellQuote("it's")).toBe("'it'\\''s'")
+  })
+})
@@ -0,0 +1,49 @@
+import { COMMANDS, completionScript } from "imposters/cli/Completion"
+import { describe, expect, it } from "vitest"
+
+describe("completionScript", () => {
+  it("completes subcommands, flags and choices in bash", () => {
+    const script = completionScript("bash")
+    expect(script).toContain(`compgen -W "${COMMANDS.map((c) => c.name).join(" ")}"`)
+    expect(script).toContain("--output|-o) COMPREPLY=($(compgen -W \"table wide json\" -- \"$cur\")); return ;;")
+    expect(script).toContain("complete -F _imposters imposters")
+  })
+
+  it("looks up imposter IDs and context names when completing", () => {
+    const script = completionScript("bash")
+    expect(script).toContain("$(imposters complete imposters 2>/dev/null)")
+    expect(script).toContain("$(imposters complete contexts 2>/dev/null)")
+  })
+
+  it("completes stub IDs of the imposter named first, and preset names, by position", () => {
+    const script = completionScript("bash")
+    expect(script).toContain("local -a args=($(_imposters_args 2))")
+    expect(script).toContain("1) COMPREPLY=($(compgen -W \"$(imposters complete stubs \"${args[0]}\" 2>/dev/null)\"")
+    expect(script).toContain("$(imposters complete presets 2>/dev/null)")
+    expect(script).toContain("--admin-url|--ctx|")
+    const fish = completionScript("fish")
+    expect(fish).toContain(
+      "-n \"__fish_seen_subcommand_from delete-stub; and test (count (__imposters_args 3)) -eq 1\" "
+        + "-x -a \"(imposters complete stubs (__imposters_args 3)[1] 2>/dev/null)\""
+    )
+  })
+
+  it("offers --outbound for requests", () => {
+    expect(COMMANDS.find((c) => c.name === "requests")?.flags.map((f) => f.name)).toContain("outbound")
+  })
+
+  it("reuses the bash function in zsh", () => {
+    const script = completionScript("zsh")
+    expect(script).toContain("bashcompinit")
+    expect(script).toContain("complete -F _imposters imposters")
+  })
+
+  it("scopes fish completions to their subcommand", () => {
+    const script = completionScript("fish")
+    const lookup = "-x -a \"(imposters complete imposters 2>/dev/null)\""
+    expect(script).toContain(`-n "__fish_seen_subcommand_from requests" ${lookup}`)
+    expect(script).toContain(
+      "complete -c imposters -n \"__fish_seen_subcommand_from ctx; and __fish_seen_subcommand_from use\" -x -a"
+    )
+  })
+})
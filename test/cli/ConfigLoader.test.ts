@@ -1,5 +1,5 @@
 import { Effect } from "effect"
-import { ConfigLoadError, loadConfigFile } from "imposters/cli/ConfigLoader"
+import { ConfigLoadError, loadConfigFile, loadManifestFile } from "imposters/cli/ConfigLoader"
 import * as path from "node:path"
 import { describe, expect, it } from "vitest"
 
@@ -16,6 +16,14 @@ describe("ConfigLoader", () => {
     expect(result.imposters[0].name).toBe("Test API")
   })
 
+  it("loads YAML manifests by their extension", async () => {
+    const result = await Effect.runPromise(loadManifestFile(path.join(fixturesDir, "env.yaml")))
+    expect(result.name).toBe("checkout")
+    expect(result.imposters.map((i) => i.port)).toEqual([4001, 4002])
+    expect(result.imposters[0].stubs).toMatchObject([{ responses: [{ body: { paid: true } }] }])
+    expect(result.imposters[1].proxy?.targetUrl).toBe("https://inventory.staging")
+  })
+
   it("returns ConfigLoadError for missing file", async () => {
     const result = await Effect.runPromise(
       loadConfigFile("/nonexistent/path.json").pipe(
@@ -0,0 +1,80 @@
+import { Effect, Option } from "effect"
+import {
+  addContext,
+  ContextError,
+  DEFAULT_ADMIN_URL,
+  loadContexts,
+  removeContext,
+  resolveAdminUrl,
+  useContext
+} from "imposters/cli/Contexts"
+import * as fs from "node:fs"
+import * as os from "node:os"
+import * as path from "node:path"
+import { afterEach, beforeEach, describe, expect, it } from "vitest"
+
+let dir: string
+let file: string
+
+beforeEach(() => {
+  dir = fs.mkdtempSync(path.join(os.tmpdir(), "imposters-ctx-"))
+  file = path.join(dir, "nested", "contexts.json")
+})
+
+afterEach(() => {
+  fs.rmSync(dir, { recursive: true, force: true })
+})
+
+const resolve = (options: { adminUrl?: string; ctx?: string } = {}) =>
+  resolveAdminUrl(file, {
+    adminUrl: Option.fromNullable(options.adminUrl),
+    ctx: Option.fromNullable(options.ctx)
+  })
+
+describe("Contexts", () => {
+  it("falls back to the default URL without a contexts file", async () => {
+    expect(await Effect.runPromise(resolve())).toBe(DEFAULT_ADMIN_URL)
+  })
+
+  it("adds contexts and makes the first one current", async () => {
+    await Effect.runPromise(addContext(file, "staging", "http://mock.staging:2525"))
+    await Effect.runPromise(addContext(file, "qa", "http://mock.qa:2525"))
+    const data = await Effect.runPromise(loadContexts(file))
+    expect(data.current).toBe("staging")
+    expect(Object.keys(data.contexts)).toEqual(["staging", "qa"])
+    expect(await Effect.runPromise(resolve())).toBe("http://mock.staging:2525")
+  })
+
+  it("resolves --admin-url over --ctx over the current context", async () => {
+    await Effect.runPromise(addContext(file, "staging", "http://mock.staging:2525"))
+    await Effect.runPromise(addContext(file, "qa", "http://mock.qa:2525"))
+    expect(await Effect.runPromise(resolve({ ctx: "qa" }))).toBe("http://mock.qa:2525")
+    expect(await Effect.runPromise(resolve({ ctx: "qa", adminUrl: "http://x:1" }))).toBe("http://x:1")
+    await Effect.runPromise(useContext(file, "qa"))
+    expect(await Effect.runPromise(resolve())).toBe("http://mock.qa:2525")
+  })
+
+  it("removes contexts and clears current when it is removed", async () => {
+    await Effect.runPromise(addContext(file, "staging", "http://mock.staging:2525"))
+    await Effect.runPromise(removeContext(file, "staging"))
+    const data = await Effect.runPromise(loadContexts(file))
+    expect(data.current).toBeUndefined()
+    expect(data.contexts).toEqual({})
+  })
+
+  it("fails for unknown contexts and invalid URLs", async () => {
+    const unknown = await Effect.runPromise(Effect.flip(resolve({ ctx: "nope" })))
+    expect(unknown).toBeInstanceOf(ContextError)
+    const invalid = await Effect.runPromise(Effect.flip(addContext(file, "bad", "mock.staging:2525")))
+    expect(invalid).toBeInstanceOf(ContextError)
+  })
+
+  it("treats names every object has, such as constructor, as unknown contexts", async () => {
+    await Effect.runPromise(addContext(file, "staging", "http://mock.staging:2525"))
+    for (const name of ["constructor", "toString"]) {
+      expect(await Effect.runPromise(Effect.flip(resolve({ ctx: name })))).toBeInstanceOf(ContextError)
+      expect(await Effect.runPromise(Effect.flip(useContext(file, name)))).toBeInstanceOf(ContextError)
+      expect(await Effect.runPromise(Effect.flip(removeContext(file, name)))).toBeInstanceOf(ContextError)
+    }
+  })
+})
@@ -0,0 +1,139 @@
+import { HttpApiBuilder } from "@effect/platform"
+import { Effect, Layer, ManagedRuntime } from "effect"
+import * as Schema from "effect/Schema"
+import { environmentDown, environmentUp } from "imposters/cli/Environment"
+import { HandlerHttpClientLive } from "imposters/client/HandlerHttpClient"
+import { ImpostersClient, ImpostersClientLive } from "imposters/client/ImpostersClient"
+import { ApiLayer } from "imposters/layers/ApiLayer"
+import { ImposterRepositoryLive } from "imposters/repositories/ImposterRepository"
+import type { NonEmptyString, PortNumber } from "imposters/schemas/common"
+import { EnvironmentManifest } from "imposters/schemas/ConfigFileSchema"
+import { FiberManagerLive } from "imposters/server/FiberManager"
+import { ImposterServerLive } from "imposters/server/ImposterServer"
+import { AppConfigLive } from "imposters/services/AppConfig"
+import { CallbackServiceLive } from "imposters/services/CallbackService"
+import { EventBusLive } from "imposters/services/EventBus"
+import { MetricsServiceLive } from "imposters/services/MetricsService"
+import { PortAllocatorLive } from "imposters/services/PortAllocator"
+import { ProxyServiceLive } from "imposters/services/ProxyService"
+import { RequestLoggerLive } from "imposters/services/RequestLogger"
+import { UuidLive } from "imposters/services/UuidLive"
+import { VariablesLive } from "imposters/services/Variables"
+import { NodeServerFactoryLive } from "imposters/test/helpers/NodeServerFactory"
+import { afterAll, beforeAll, describe, expect, it } from "vitest"
+
+const PortAllocatorWithDeps = PortAllocatorLive.pipe(Layer.provide(AppConfigLive))
+const ProxyServiceWithDeps = ProxyServiceLive.pipe(Layer.provide(UuidLive))
+const EventBusWithDeps = EventBusLive.pipe(Layer.provide(UuidLive))
+const CallbackServiceWithDeps = CallbackServiceLive.pipe(Layer.provide(UuidLive))
+
+const ImposterServerWithDeps = ImposterServerLive.pipe(
+  Layer.provide(
+    Layer.mergeAll(
+      FiberManagerLive,
+      ImposterRepositoryLive,
+      NodeServerFactoryLive,
+      RequestLoggerLive,
+      MetricsServiceLive,
+      ProxyServiceWithDeps,
+      EventBusWithDeps,
+      CallbackServiceWithDeps,
+      VariablesLive
+    )
+  )
+)
+const MainLayer = Layer.mergeAll(
+  UuidLive,
+  AppConfigLive,
+  PortAllocatorWithDeps,
+  ImposterRepositoryLive,
+  FiberManagerLive,
+  RequestLoggerLive,
+  MetricsServiceLive,
+  EventBusWithDeps,
+  CallbackServiceWithDeps,
+  VariablesLive,
+  ImposterServerWithDeps
+)
+const FullLayer = ApiLayer.pipe(Layer.provide(MainLayer))
+
+let dispose: () => void
+let runtime: ManagedRuntime.ManagedRuntime<ImpostersClient, never>
+
+beforeAll(() => {
+  const result = HttpApiBuilder.toWebHandler(FullLayer)
+  dispose = result.dispose
+  runtime = ManagedRuntime.make(ImpostersClientLive().pipe(Layer.provide(HandlerHttpClientLive(result.handler))))
+})
+
+afterAll(async () => {
+  await runtime.dispose()
+  dispose()
+})
+
+const run = <A, E>(effect: Effect.Effect<A, E, ImpostersClient>) => runtime.runPromise(effect)
+
+const manifest = Schema.decodeUnknownSync(EnvironmentManifest)({
+  name: "checkout",
+  imposters: [
+    {
+      name: "payments",
+      port: 9701,
+      stubs: [{ responses: [{ status: 200, body: { paid: true } }] }]
+    },
+    { port: 9702 }
+  ]
+})
+
+const listNames = () =>
+  run(Effect.gen(function*() {
+    const client = yield* ImpostersClient
+    const page = yield* client.imposters.listImposters({ urlParams: { limit: 100, offset: 0 } })
+    return page.imposters.map((imp) => imp.name)
+  }))
+
+describe("Environment", () => {
+  it("up creates and starts every imposter under the environment prefix", async () => {
+    const created = await run(environmentUp(manifest))
+    try {
+      expect(created.map((imp) => imp.name)).toEqual(["checkout/payments", "checkout/9702"])
+
+      const resp = await fetch("http://localhost:9701/anything")
+      expect(await resp.json()).toEqual({ paid: true })
+    } finally {
+      await run(environmentDown("checkout"))
+    }
+  }, 10000)
+
+  it("up is idempotent and down removes only the environment's imposters", async () => {
+    await run(Effect.gen(function*() {
+      const client = yield* ImpostersClient
+      yield* client.imposters.createImposter({
+        payload: {
+          name: "unrelated" as NonEmptyString,
+          port: 9703 as PortNumber,
+          protocol: "HTTP",
+          adminPath: "/_admin"
+        }
+      })
+    }))
+
+    await run(environmentUp(manifest))
+    await run(environmentUp(manifest))
+    expect((await listNames()).filter((n) => n.startsWith("checkout/"))).toHaveLength(2)
+
+    const removed = await run(environmentDown("checkout"))
+    expect(removed).toHaveLength(2)
+    expect(await listNames()).toContain("unrelated")
+    expect((await listNames()).some((n) => n.startsWith("checkout/"))).toBe(false)
+  }, 10000)
+
+  it("up fails and leaves nothing behind when an imposter can't be created", async () => {
+    const clashing = Schema.decodeUnknownSync(EnvironmentManifest)({
+      name: "broken",
+      imposters: [{ name: "first", port: 9704 }, { name: "second", port: 9704 }]
+    })
+    await expect(run(environmentUp(clashing))).rejects.toBeDefined()
+    expect((await listNames()).some((n) => n.startsWith("broken/"))).toBe(false)
+  }, 10000)
+})
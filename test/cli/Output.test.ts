@@ -0,0 +1,65 @@
+import { Schema } from "effect"
+import { type Column, formatRows, renderTable, stubColumns } from "imposters/cli/Output"
+import { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+interface Row {
+  readonly name: string
+  readonly status: number
+}
+
+const columns: ReadonlyArray<Column<Row>> = [
+  { header: "NAME", value: (r) => r.name },
+  { header: "STATUS", value: (r) => String(r.status), color: (r) => r.status >= 500 ? "red" : undefined },
+  { header: "NOTE", value: () => "extra", wide: true }
+]
+
+const rows: ReadonlyArray<Row> = [{ name: "orders", status: 200 }, { name: "a", status: 503 }]
+
+describe("renderTable", () => {
+  it("aligns columns and hides wide-only ones", () => {
+    expect(renderTable(columns, rows, { wide: false, color: false })).toBe(
+      "NAME    STATUS\norders  200\na       503"
+    )
+    expect(renderTable(columns, rows, { wide: true, color: false }).split("\n")[0]).toBe("NAME    STATUS  NOTE")
+  })
+
+  it("colors cells without breaking the alignment", () => {
+    const lines = renderTable(columns, rows, { wide: false, color: true }).split("\n")
+    expect(lines[0]).toBe("\u001b[1mNAME  \u001b[0m  \u001b[1mSTATUS\u001b[0m")
+    expect(lines[2]).toBe("a       \u001b[31m503\u001b[0m")
+  })
+})
+
+describe("stubColumns", () => {
+  const stubs = [
+    Schema.decodeUnknownSync(Stub)({
+      id: "s1",
+      predicates: [
+        { field: "method", operator: "equals", value: "POST" },
+        { field: "path", operator: "template", value: "/orders/{id}" },
+        { field: "headers", operator: "exists", value: { authorization: true }, negate: true }
+      ],
+      responses: [{ status: 401 }, { status: 200 }]
+    }),
+    Schema.decodeUnknownSync(Stub)({
+      id: "s2",
+      predicates: [],
+      responses: [{ proxy: { targetUrl: "http://upstream" } }]
+    })
+  ]
+
+  it("summarizes method, path, response and other matchers", () => {
+    expect(formatRows("wide", Stub, stubColumns, stubs, false)).toBe([
+      "ID  METHOD  PATH          RESPONSE  RESPONSES  MODE        MATCHERS",
+      "s1  POST    /orders/{id}  401       2          sequential  !headers exists",
+      "s2  *       *             proxy     1          sequential  -"
+    ].join("\n"))
+  })
+
+  it("prints JSON encoded with the API schema", () => {
+    expect(JSON.parse(formatRows("json", Stub, stubColumns, stubs.slice(0, 1)))).toEqual([
+      Schema.encodeSync(Stub)(stubs[0]!)
+    ])
+  })
+})
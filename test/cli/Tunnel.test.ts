@@ -0,0 +1,70 @@
+import { Effect } from "effect"
+import { parsePortMapping, relay, sshReverseArgs, TunnelError } from "imposters/cli/Tunnel"
+import * as http from "node:http"
+import { afterAll, beforeAll, describe, expect, it } from "vitest"
+
+let upstream: http.Server
+let upstreamPort: number
+
+beforeAll(async () => {
+  upstream = http.createServer((req, res) => {
+    res.writeHead(200, { "content-type": "application/json" })
+    res.end(JSON.stringify({ path: req.url }))
+  })
+  await new Promise<void>((resolve) => {
+    upstream.listen(0, () => {
+      upstreamPort = (upstream.address() as { port: number }).port
+      resolve()
+    })
+  })
+})
+
+afterAll(() => {
+  upstream.close()
+})
+
+describe("parsePortMapping", () => {
+  it("parses single ports and local:remote pairs", async () => {
+    expect(await Effect.runPromise(parsePortMapping("4000"))).toEqual({ local: 4000, remote: 4000 })
+    expect(await Effect.runPromise(parsePortMapping("8080:4000"))).toEqual({ local: 8080, remote: 4000 })
+  })
+
+  it("rejects invalid specs", async () => {
+    for (const spec of ["abc", "0", "1:2:3", "4000:70000"]) {
+      const result = await Effect.runPromise(Effect.flip(parsePortMapping(spec)))
+      expect(result).toBeInstanceOf(TunnelError)
+    }
+  })
+})
+
+describe("sshReverseArgs", () => {
+  it("builds one -R forward per mapping", () => {
+    const args = sshReverseArgs("dev@box", [{ local: 2525, remote: 2525 }, { local: 4000, remote: 9000 }])
+    expect(args).toEqual([
+      "-N",
+      "-o",
+      "ExitOnForwardFailure=yes",
+      "-R",
+      "2525:localhost:2525",
+      "-R",
+      "9000:localhost:4000",
+      "dev@box"
+    ])
+  })
+})
+
+describe("relay", () => {
+  it("pipes connections to the target port and closes with its scope", async () => {
+    const body = await Effect.runPromise(
+      Effect.scoped(
+        Effect.gen(function*() {
+          const server = yield* relay({ local: 0, remote: upstreamPort }, "127.0.0.1", "127.0.0.1")
+          const port = (server.address() as { port: number }).port
+          const resp = yield* Effect.promise(() => fetch(`http://127.0.0.1:${port}/hello`))
+          return yield* Effect.promise(() => resp.json())
+        })
+      )
+    )
+    expect(body).toEqual({ path: "/hello" })
+  })
+})
@@ -0,0 +1,28 @@
+import { describeEvent, parseServerSentEvents } from "imposters/cli/Watch"
+import { describe, expect, it } from "vitest"
+
+describe("parseServerSentEvents", () => {
+  it("splits complete messages and keeps the unfinished remainder", () => {
+    const { events, rest } = parseServerSentEvents(
+      ": connected\n\nevent: stub.added\ndata: {\"a\":1}\n\nevent: stub.rem"
+    )
+    expect(events).toEqual([{ event: "stub.added", data: "{\"a\":1}" }])
+    expect(rest).toBe("event: stub.rem")
+  })
+
+  it("joins multi-line data and defaults the event name", () => {
+    const { events } = parseServerSentEvents("data: one\r\ndata: two\r\n\r\n")
+    expect(events).toEqual([{ event: "message", data: "one\ntwo" }])
+  })
+})
+
+describe("describeEvent", () => {
+  it("summarizes the imposter, stub and changed fields", () => {
+    const data = JSON.stringify({ imposterId: "imp1", data: { stubId: "s1", changes: { responses: {} } } })
+    expect(describeEvent({ event: "stub.updated", data })).toBe("stub.updated imp1 stub=s1 (responses)")
+  })
+
+  it("falls back to the event name for non-JSON data", () => {
+    expect(describeEvent({ event: "ping", data: "x" })).toBe("ping")
+  })
+})
@@ -0,0 +1,53 @@
+import { it } from "@effect/vitest"
+import * as Effect from "effect/Effect"
+import { draftToStub, previewResponse, sampleRequest, type StubDraft } from "imposters/cli/Wizard"
+import { describe, expect } from "vitest"
+
+const draft: StubDraft = {
+  method: "GET",
+  path: "/users/{id}",
+  matchers: [
+    { field: "headers", name: "X-Tenant", operator: "equals", value: "acme" },
+    { field: "query", name: "debug", operator: "exists", value: "" }
+  ],
+  status: 200,
+  contentType: "application/json",
+  body: "{\"id\": \"{{request.params.id}}\", \"tenant\": \"{{request.headers.x-tenant}}\"}"
+}
+
+describe("draftToStub", () => {
+  it.effect("builds predicates and a response from the answers", () =>
+    Effect.gen(function*() {
+      const stub = yield* draftToStub(draft)
+      expect(stub.predicates).toEqual([
+        { field: "method", operator: "equals", value: "GET", caseSensitive: true },
+        { field: "path", operator: "template", value: "/users/{id}", caseSensitive: true },
+        { field: "headers", operator: "equals", value: { "X-Tenant": "acme" }, caseSensitive: true },
+        { field: "query", operator: "exists", value: { debug: true }, caseSensitive: true }
+      ])
+      expect(stub.responses[0].body).toEqual({ id: "{{request.params.id}}", tenant: "{{request.headers.x-tenant}}" })
+    }))
+
+  it.effect("leaves the method open for ANY and matches plain paths exactly", () =>
+    Effect.gen(function*() {
+      const stub = yield* draftToStub({ ...draft, method: "ANY", path: "/health", matchers: [], body: "" })
+      expect(stub.predicates).toEqual([{ field: "path", operator: "equals", value: "/health", caseSensitive: true }])
+      expect(stub.responses[0].body).toBeUndefined()
+    }))
+
+  it.effect("rejects answers the admin API would reject", () =>
+    Effect.gen(function*() {
+      const matchers = [{ field: "body" as const, name: "", operator: "matches" as const, value: "(" }]
+      yield* Effect.flip(draftToStub({ ...draft, matchers }))
+    }))
+})
+
+describe("previewResponse", () => {
+  it.effect("renders templates against a sample request", () =>
+    Effect.gen(function*() {
+      const sample = sampleRequest(draft)
+      expect(sample.path).toBe("/users/<id>")
+      const preview = yield* previewResponse(yield* draftToStub(draft), sample)
+      expect(preview).toBe("HTTP 200\ncontent-type: application/json\n\n{\"id\":\"<id>\",\"tenant\":\"acme\"}")
+    }))
+})
@@ -8,16 +8,21 @@ import type { NonEmptyString, PortNumber, PositiveInteger } from "imposters/sche
 import { FiberManagerLive } from "imposters/server/FiberManager"
 import { ImposterServerLive } from "imposters/server/ImposterServer"
 import { AppConfigLive } from "imposters/services/AppConfig"
+import { CallbackServiceLive } from "imposters/services/CallbackService"
+import { EventBusLive } from "imposters/services/EventBus"
 import { MetricsServiceLive } from "imposters/services/MetricsService"
 import { PortAllocatorLive } from "imposters/services/PortAllocator"
 import { ProxyServiceLive } from "imposters/services/ProxyService"
 import { RequestLoggerLive } from "imposters/services/RequestLogger"
 import { UuidLive } from "imposters/services/UuidLive"
+import { VariablesLive } from "imposters/services/Variables"
 import { NodeServerFactoryLive } from "imposters/test/helpers/NodeServerFactory"
 import { afterAll, beforeAll, describe, expect, it } from "vitest"
 
 const PortAllocatorWithDeps = PortAllocatorLive.pipe(Layer.provide(AppConfigLive))
 const ProxyServiceWithDeps = ProxyServiceLive.pipe(Layer.provide(UuidLive))
+const EventBusWithDeps = EventBusLive.pipe(Layer.provide(UuidLive))
+const CallbackServiceWithDeps = CallbackServiceLive.pipe(Layer.provide(UuidLive))
 
 const ImposterServerWithDeps = ImposterServerLive.pipe(
   Layer.provide(
@@ -27,7 +32,10 @@ const ImposterServerWithDeps = ImposterServerLive.pipe(
       NodeServerFactoryLive,
       RequestLoggerLive,
       MetricsServiceLive,
-      ProxyServiceWithDeps
+      ProxyServiceWithDeps,
+      EventBusWithDeps,
+      CallbackServiceWithDeps,
+      VariablesLive
     )
   )
 )
@@ -39,6 +47,9 @@ const MainLayer = Layer.mergeAll(
   FiberManagerLive,
   RequestLoggerLive,
   MetricsServiceLive,
+  EventBusWithDeps,
+  CallbackServiceWithDeps,
+  VariablesLive,
   ImposterServerWithDeps
 )
 const FullLayer = ApiLayer.pipe(Layer.provide(MainLayer))
@@ -182,7 +193,7 @@ describe("ImpostersClient", () => {
         const stubs = await run(
           Effect.gen(function*() {
             const client = yield* ImpostersClient
-            return yield* client.imposters.listStubs({ path: { imposterId: imp.id } })
+            return yield* client.imposters.listStubs({ path: { imposterId: imp.id }, urlParams: {} })
           })
         )
         expect(stubs.length).toBe(1)
@@ -0,0 +1,53 @@
+import * as Schema from "effect/Schema"
+import { bodyHash, hoistBodies, inlineBodies, makeBodyPool, missingBodies } from "imposters/domain/bodies"
+import { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const page = (n: number) => ({ items: Array.from({ length: 20 }, (_, i) => ({ id: i, name: `item-${i}` })), n })
+
+const stub = (id: string, ...bodies: ReadonlyArray<unknown>) =>
+  Schema.decodeUnknownSync(Stub)({ id, predicates: [], responses: bodies.map((body) => ({ status: 200, body })) })
+
+describe("bodyHash", () => {
+  it("names equal bodies alike whatever their key order", () => {
+    expect(bodyHash({ a: 1, b: [2] })).toMatch(/^sha256:[0-9a-f]{64}$/)
+    expect(bodyHash({ b: [2], a: 1 })).toBe(bodyHash({ a: 1, b: [2] }))
+    expect(bodyHash({ a: 2 })).not.toBe(bodyHash({ a: 1 }))
+  })
+})
+
+describe("makeBodyPool", () => {
+  it("keeps one copy of each large body", () => {
+    const pool = makeBodyPool()
+    const first = pool.internStub(stub("a", page(1), { small: true }))
+    const second = pool.internStub(stub("b", JSON.parse(JSON.stringify(page(1))), { small: true }))
+    expect(second.responses[0].body).toBe(first.responses[0].body)
+    expect(second.responses[1]?.body).not.toBe(first.responses[1]?.body)
+    expect(pool.size()).toBe(1)
+  })
+
+  it("shares a body only with bodies in the same key order", () => {
+    const pool = makeBodyPool()
+    const { n, items } = page(1)
+    const first = pool.internStub(stub("a", { items, n }))
+    const reordered = pool.internStub(stub("b", { n, items }))
+    expect(reordered.responses[0].body).not.toBe(first.responses[0].body)
+    expect(Object.keys(reordered.responses[0].body as object)).toEqual(["n", "items"])
+  })
+})
+
+describe("hoistBodies", () => {
+  it("moves large bodies served more than once into bodies and puts them back", () => {
+    const stubs = [stub("a", page(1), page(2)), stub("b", page(1), "short")]
+    const { bodies, stubs: hoisted } = hoistBodies(stubs)
+    const hash = bodyHash(page(1))
+    expect(Object.keys(bodies)).toEqual([hash])
+    expect(hoisted[0]?.responses[0]).toEqual({ status: 200, bodyRef: hash })
+    expect(hoisted[0]?.responses[1]).toEqual({ status: 200, body: page(2) })
+    expect(hoisted[1]?.responses[1]).toEqual({ status: 200, body: "short" })
+
+    expect(missingBodies(hoisted, bodies)).toEqual([])
+    expect(missingBodies(hoisted, {})).toEqual([hash])
+    expect(inlineBodies(hoisted, bodies)).toEqual(stubs)
+  })
+})
@@ -5,6 +5,7 @@ import * as Option from "effect/Option"
 import {
   createResponseWithParams,
   createRoute,
+  matchPath,
   newRoute,
   Response,
   RouteError,
@@ -110,6 +111,38 @@ describe("route domain", () => {
     })
   })
 
+  describe("matchPath", () => {
+    it("captures named segments", () => {
+      expect(matchPath("/users/{id}/orders/{orderId}", "/users/42/orders/a%20b")).toEqual(
+        Option.some({ id: "42", orderId: "a b" })
+      )
+    })
+
+    it("matches any single segment with * without capturing", () => {
+      expect(matchPath("/users/*/avatar", "/users/42/avatar")).toEqual(Option.some({}))
+      expect(matchPath("/users/*/avatar", "/users/42/profile")).toEqual(Option.none())
+    })
+
+    it("requires the same number of segments", () => {
+      expect(matchPath("/users/*", "/users/1/2")).toEqual(Option.none())
+      expect(matchPath("/users/*", "/users/")).toEqual(Option.none())
+    })
+
+    it("captures the remaining segments with a trailing catch-all", () => {
+      expect(matchPath("/files/{rest...}", "/files/docs/2024/report.pdf")).toEqual(
+        Option.some({ rest: "docs/2024/report.pdf" })
+      )
+      expect(matchPath("/files/{rest...}", "/files")).toEqual(Option.some({ rest: "" }))
+      expect(matchPath("/files/{bucket}/{key...}", "/files/b1/a/b")).toEqual(Option.some({ bucket: "b1", key: "a/b" }))
+      expect(matchPath("/files/{rest...}", "/other/x")).toEqual(Option.none())
+    })
+
+    it("compares literal segments case-insensitively when asked", () => {
+      expect(matchPath("/Users/{id}", "/users/7")).toEqual(Option.none())
+      expect(matchPath("/Users/{id}", "/users/7", false)).toEqual(Option.some({ id: "7" }))
+    })
+  })
+
   describe("createResponseWithParams", () => {
     it("substitutes params in response body", () => {
       const response = Response({
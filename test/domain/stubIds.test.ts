@@ -0,0 +1,50 @@
+import * as Schema from "effect/Schema"
+import { canonicalJson, contentStubId, uniqueStubId } from "imposters/domain/stubIds"
+import { CreateStubRequest } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const predicatesOf = (predicates: unknown) =>
+  Schema.decodeUnknownSync(CreateStubRequest)({ predicates, responses: [{ status: 200 }] }).predicates
+
+describe("canonicalJson", () => {
+  it("sorts keys at every level and drops undefined values", () => {
+    expect(canonicalJson({ b: [{ d: 1, c: 2 }], a: undefined, e: null })).toBe("{\"b\":[{\"c\":2,\"d\":1}],\"e\":null}")
+  })
+})
+
+describe("contentStubId", () => {
+  const getUsers = [
+    { field: "method", operator: "equals", value: "GET" },
+    { field: "path", operator: "equals", value: "/users" }
+  ]
+
+  it("is an 8-character hash of the predicates", () => {
+    expect(contentStubId(predicatesOf(getUsers))).toMatch(/^[0-9a-f]{8}$/)
+    expect(contentStubId(predicatesOf(getUsers))).toBe(contentStubId(predicatesOf(getUsers)))
+  })
+
+  it("ignores key order and spelled-out defaults", () => {
+    const reordered = [
+      { value: "GET", operator: "equals", field: "method", caseSensitive: true },
+      { operator: "equals", field: "path", value: "/users" }
+    ]
+    expect(contentStubId(predicatesOf(reordered))).toBe(contentStubId(predicatesOf(getUsers)))
+  })
+
+  it("changes with the method, path or any other matcher", () => {
+    const id = contentStubId(predicatesOf(getUsers))
+    const post = [{ ...getUsers[0], value: "POST" }, getUsers[1]]
+    const withHeader = [...getUsers, { field: "headers", operator: "exists", value: { authorization: "" } }]
+    expect(contentStubId(predicatesOf(post))).not.toBe(id)
+    expect(contentStubId(predicatesOf(withHeader))).not.toBe(id)
+    expect(contentStubId(predicatesOf([getUsers[1], getUsers[0]]))).not.toBe(id)
+  })
+})
+
+describe("uniqueStubId", () => {
+  it("numbers IDs already taken", () => {
+    expect(uniqueStubId("abc", new Set())).toBe("abc")
+    expect(uniqueStubId("abc", new Set(["abc"]))).toBe("abc-2")
+    expect(uniqueStubId("abc", new Set(["abc", "abc-2"]))).toBe("abc-3")
+  })
+})
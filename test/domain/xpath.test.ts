@@ -0,0 +1,74 @@
+import { compileXPath, parseXml, selectValues, XmlSyntaxError, XPathSyntaxError } from "imposters/domain/xpath"
+import { describe, expect, it } from "vitest"
+
+const catalog = parseXml(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE catalog [<!ENTITY note "unused">]>
+<!-- inventory export -->
+<catalog xmlns:inv="urn:inventory">
+  <inv:book id="b1" lang="en"><title>Dune</title><price>9.99</price></inv:book>
+  <inv:book id="b2" lang="fr"><title>L&apos;&#201;tranger</title><price>7.50</price></inv:book>
+  <magazine id="m1"><title><![CDATA[<Wired>]]></title></magazine>
+  <empty/>
+</catalog>`)
+
+describe("parseXml", () => {
+  it("builds the element tree with attributes and decoded text", () => {
+    expect(catalog.name).toBe("catalog")
+    const book = catalog.children.find((c) => typeof c !== "string" && c.name === "inv:book")
+    expect(book).toMatchObject({ attributes: { id: "b1", lang: "en" } })
+    expect(selectValues(catalog, "//book[@id='b2']/title")).toEqual(["L'Étranger"])
+  })
+
+  it("keeps CDATA sections as text", () => {
+    expect(selectValues(catalog, "/catalog/magazine/title")).toEqual(["<Wired>"])
+  })
+
+  it("rejects malformed documents", () => {
+    expect(() => parseXml("<a><b></a>")).toThrow(XmlSyntaxError)
+    expect(() => parseXml("<a>")).toThrow(XmlSyntaxError)
+    expect(() => parseXml("just text")).toThrow(XmlSyntaxError)
+    expect(() => parseXml("<a/><b/>")).toThrow(XmlSyntaxError)
+  })
+})
+
+describe("selectValues", () => {
+  it("follows absolute and relative child paths", () => {
+    expect(selectValues(catalog, "/catalog/book/title")).toEqual(["Dune", "L'Étranger"])
+    expect(selectValues(catalog, "catalog/book/price")).toEqual(["9.99", "7.50"])
+    expect(selectValues(catalog, "/book")).toEqual([])
+  })
+
+  it("searches descendants with //", () => {
+    expect(selectValues(catalog, "//title")).toEqual(["Dune", "L'Étranger", "<Wired>"])
+    expect(selectValues(catalog, "/catalog//price")).toEqual(["9.99", "7.50"])
+  })
+
+  it("selects attributes and text nodes", () => {
+    expect(selectValues(catalog, "//book/@lang")).toEqual(["en", "fr"])
+    expect(selectValues(catalog, "//@id")).toEqual(["b1", "b2", "m1"])
+    expect(selectValues(catalog, "//book[1]/title/text()")).toEqual(["Dune"])
+  })
+
+  it("filters with positional and comparison predicates", () => {
+    expect(selectValues(catalog, "//book[2]/@id")).toEqual(["b2"])
+    expect(selectValues(catalog, "//book[last()]/@id")).toEqual(["b2"])
+    expect(selectValues(catalog, "//book[title='Dune']/price")).toEqual(["9.99"])
+    expect(selectValues(catalog, "//book[@lang!='en']/@id")).toEqual(["b2"])
+    expect(selectValues(catalog, "//*[@id][title]/@id")).toEqual(["b1", "b2", "m1"])
+    expect(selectValues(catalog, "//title[.='Dune']")).toEqual(["Dune"])
+  })
+
+  it("matches empty elements", () => {
+    expect(selectValues(catalog, "/catalog/empty")).toEqual([""])
+  })
+})
+
+describe("compileXPath", () => {
+  it("rejects expressions outside the supported subset", () => {
+    expect(() => compileXPath("//book[")).toThrow(XPathSyntaxError)
+    expect(() => compileXPath("/")).toThrow(XPathSyntaxError)
+    expect(() => compileXPath("count(//book)")).toThrow(XPathSyntaxError)
+    expect(() => compileXPath("//@id/title")).toThrow(XPathSyntaxError)
+    expect(() => compileXPath("//book[0]")).toThrow(XPathSyntaxError)
+  })
+})
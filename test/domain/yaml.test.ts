@@ -0,0 +1,99 @@
+import { parseYaml, YamlSyntaxError } from "imposters/domain/yaml"
+import { describe, expect, it } from "vitest"
+
+describe("parseYaml", () => {
+  it("reads block mappings and sequences, with typed scalars", () => {
+    const doc = parseYaml(`---
+# an environment
+name: checkout
+retries: 3
+ratio: 0.5
+enabled: true
+owner: ~
+imposters:
+  - name: payments
+    port: 4001
+  - name: inventory
+    tags:
+    - stock
+    - "4002"
+`)
+    expect(doc).toEqual({
+      name: "checkout",
+      retries: 3,
+      ratio: 0.5,
+      enabled: true,
+      owner: null,
+      imposters: [{ name: "payments", port: 4001 }, { name: "inventory", tags: ["stock", "4002"] }]
+    })
+  })
+
+  it("reads flow collections, over several lines too", () => {
+    expect(parseYaml(`body: { paid: true, items: [1, "two", 'three'] }
+headers: {
+  "Content-Type": application/json,  # a comment
+  X-Empty: ""
+}`)).toEqual({
+      body: { paid: true, items: [1, "two", "three"] },
+      headers: { "Content-Type": "application/json", "X-Empty": "" }
+    })
+  })
+
+  it("unquotes and unescapes strings", () => {
+    expect(parseYaml(`a: "line\\nbreak # not a comment"
+b: 'it''s'
+c: http://host:4001/path#fragment
+"quoted key": yes`)).toEqual({
+      a: "line\nbreak # not a comment",
+      b: "it's",
+      c: "http://host:4001/path#fragment",
+      "quoted key": "yes"
+    })
+  })
+
+  it("reads literal and folded block scalars", () => {
+    expect(parseYaml(`literal: |
+  first
+    indented
+
+  last
+folded: >-
+  one
+  two
+
+  three
+kept: |+
+  end
+
+next: 1`)).toEqual({
+      literal: "first\n  indented\n\nlast\n",
+      folded: "one two\nthree",
+      kept: "end\n\n",
+      next: 1
+    })
+  })
+
+  it("keeps __proto__ as data", () => {
+    const doc = parseYaml("__proto__:\n  polluted: true") as Record<string, unknown>
+    expect(Object.hasOwn(doc, "__proto__")).toBe(true)
+    expect(({} as Record<string, unknown>).polluted).toBeUndefined()
+  })
+
+  it("reads an empty document as null", () => {
+    expect(parseYaml("# nothing here\n")).toBeNull()
+  })
+
+  it("rejects what it can't read faithfully", () => {
+    expect(() => parseYaml("a: 1\na: 2")).toThrow(YamlSyntaxError)
+    expect(() => parseYaml("a: &ref 1\nb: *ref")).toThrow(YamlSyntaxError)
+    expect(() => parseYaml("a:\n\tb: 1")).toThrow(YamlSyntaxError)
+    expect(() => parseYaml("a: plain\n  continued")).toThrow(YamlSyntaxError)
+    expect(() => parseYaml("a: 1\n---\nb: 2")).toThrow(YamlSyntaxError)
+    expect(() => parseYaml("a: [1, 2")).toThrow(YamlSyntaxError)
+    expect(() => parseYaml("a: \"open")).toThrow(YamlSyntaxError)
+  })
+
+  it("names the line it stopped at", () => {
+    expect(() => parseYaml("a: 1\nb: 2\nb: 3")).toThrow("Duplicate key \"b\" on line 3")
+  })
+})
@@ -5,17 +5,22 @@ import { ImposterRepositoryLive } from "imposters/repositories/ImposterRepositor
 import { FiberManagerLive } from "imposters/server/FiberManager"
 import { ImposterServerLive } from "imposters/server/ImposterServer"
 import { AppConfigLive } from "imposters/services/AppConfig"
+import { CallbackServiceLive } from "imposters/services/CallbackService"
+import { EventBusLive } from "imposters/services/EventBus"
 import { MetricsServiceLive } from "imposters/services/MetricsService"
 import { PortAllocatorLive } from "imposters/services/PortAllocator"
 import { ProxyServiceLive } from "imposters/services/ProxyService"
 import { RequestLoggerLive } from "imposters/services/RequestLogger"
 import { UuidLive } from "imposters/services/UuidLive"
+import { VariablesLive } from "imposters/services/Variables"
 import { NodeServerFactoryLive } from "imposters/test/helpers/NodeServerFactory"
 import * as http from "node:http"
 import { afterAll, beforeAll, describe, expect, it } from "vitest"
 
 const PortAllocatorWithDeps = PortAllocatorLive.pipe(Layer.provide(AppConfigLive))
 const ProxyServiceWithDeps = ProxyServiceLive.pipe(Layer.provide(UuidLive))
+const EventBusWithDeps = EventBusLive.pipe(Layer.provide(UuidLive))
+const CallbackServiceWithDeps = CallbackServiceLive.pipe(Layer.provide(UuidLive))
 
 const ImposterServerWithDeps = ImposterServerLive.pipe(
   Layer.provide(
@@ -25,7 +30,10 @@ const ImposterServerWithDeps = ImposterServerLive.pipe(
       NodeServerFactoryLive,
       RequestLoggerLive,
       MetricsServiceLive,
-      ProxyServiceWithDeps
+      ProxyServiceWithDeps,
+      EventBusWithDeps,
+      CallbackServiceWithDeps,
+      VariablesLive
     )
   )
 )
@@ -37,6 +45,9 @@ const MainLayer = Layer.mergeAll(
   FiberManagerLive,
   RequestLoggerLive,
   MetricsServiceLive,
+  EventBusWithDeps,
+  CallbackServiceWithDeps,
+  VariablesLive,
   ImposterServerWithDeps
 )
 const FullLayer = ApiLayer.pipe(Layer.provide(MainLayer))
@@ -214,6 +225,48 @@ describe("E2E: Proxy Mode", () => {
     }
   }, 10000)
 
+  it("stub-level proxy forwards matched requests with a rewritten path", async () => {
+    const createResp = await admin("/imposters", {
+      method: "POST",
+      headers: { "content-type": "application/json" },
+      body: JSON.stringify({ port: 9507 })
+    })
+    const imp = await createResp.json()
+
+    await admin(`/imposters/${imp.id}/stubs`, {
+      method: "POST",
+      headers: { "content-type": "application/json" },
+      body: JSON.stringify({
+        predicates: [{ field: "path", operator: "startsWith", value: "/v2/" }],
+        responses: [{
+          proxy: {
+            targetUrl: `http://localhost:${upstreamPort}`,
+            pathRewrite: { pattern: "^/v2", replacement: "/api" }
+          }
+        }]
+      })
+    })
+
+    await startImposter(imp.id)
+    await new Promise((r) => setTimeout(r, 150))
+
+    try {
+      const proxiedResp = await fetch("http://localhost:9507/v2/users?page=2")
+      expect(proxiedResp.status).toBe(200)
+      const proxiedBody = await proxiedResp.json()
+      expect(proxiedBody.upstream).toBe(true)
+      expect(proxiedBody.path).toBe("/api/users")
+      expect(proxiedBody.query).toEqual({ page: "2" })
+
+      // Unmatched routes are not proxied without an imposter-level proxy
+      const missResp = await fetch("http://localhost:9507/other")
+      expect(missResp.status).toBe(404)
+    } finally {
+      await stopImposter(imp.id)
+      await new Promise((r) => setTimeout(r, 100))
+    }
+  }, 10000)
+
   it("returns 502 when upstream is unreachable", async () => {
     const imp = await createImposterWithProxy(9504, {
       targetUrl: "http://localhost:1",
@@ -234,6 +287,46 @@ describe("E2E: Proxy Mode", () => {
     }
   }, 10000)
 
+  it("cache keeps upstream answers to serve once the upstream is gone", async () => {
+    let hits = 0
+    const flaky = http.createServer((_req, res) => {
+      hits++
+      res.writeHead(200, { "content-type": "application/json" })
+      res.end(JSON.stringify({ hits }))
+    })
+    await new Promise<void>((resolve) => flaky.listen(0, resolve))
+    const imp = await createImposterWithProxy(9508, {
+      targetUrl: `http://localhost:${(flaky.address() as { port: number }).port}`,
+      cache: true
+    })
+
+    await startImposter(imp.id)
+    await new Promise((r) => setTimeout(r, 150))
+
+    try {
+      const first = await fetch("http://localhost:9508/api/cached?page=1")
+      expect(await first.json()).toEqual({ hits: 1 })
+      expect(first.headers.get("x-cache")).toBeNull()
+
+      const closed = new Promise<void>((resolve) => flaky.close(() => resolve()))
+      flaky.closeAllConnections()
+      await closed
+
+      const again = await fetch("http://localhost:9508/api/cached?page=1")
+      expect(again.status).toBe(200)
+      expect(again.headers.get("x-cache")).toBe("HIT")
+      expect(await again.json()).toEqual({ hits: 1 })
+      // Another query is another request, which the upstream is no longer there to answer
+      expect((await fetch("http://localhost:9508/api/cached?page=2")).status).toBe(502)
+
+      const state = await (await admin(`/imposters/${imp.id}/state`)).json()
+      expect(Object.keys(state)).toEqual(["proxy:GET /api/cached?page=1"])
+    } finally {
+      await stopImposter(imp.id)
+      await new Promise((r) => setTimeout(r, 100))
+    }
+  }, 10000)
+
   it("proxy config shows in imposter response", async () => {
     const imp = await createImposterWithProxy(9505, {
       targetUrl: `http://localhost:${upstreamPort}`,
@@ -5,16 +5,21 @@ import { ImposterRepositoryLive } from "imposters/repositories/ImposterRepositor
 import { FiberManagerLive } from "imposters/server/FiberManager"
 import { ImposterServerLive } from "imposters/server/ImposterServer"
 import { AppConfigLive } from "imposters/services/AppConfig"
+import { CallbackServiceLive } from "imposters/services/CallbackService"
+import { EventBusLive } from "imposters/services/EventBus"
 import { MetricsServiceLive } from "imposters/services/MetricsService"
 import { PortAllocatorLive } from "imposters/services/PortAllocator"
 import { ProxyServiceLive } from "imposters/services/ProxyService"
 import { RequestLoggerLive } from "imposters/services/RequestLogger"
 import { UuidLive } from "imposters/services/UuidLive"
+import { VariablesLive } from "imposters/services/Variables"
 import { NodeServerFactoryLive } from "imposters/test/helpers/NodeServerFactory"
 import { afterAll, beforeAll, describe, expect, it } from "vitest"
 
 const PortAllocatorWithDeps = PortAllocatorLive.pipe(Layer.provide(AppConfigLive))
 const ProxyServiceWithDeps = ProxyServiceLive.pipe(Layer.provide(UuidLive))
+const EventBusWithDeps = EventBusLive.pipe(Layer.provide(UuidLive))
+const CallbackServiceWithDeps = CallbackServiceLive.pipe(Layer.provide(UuidLive))
 
 const ImposterServerWithDeps = ImposterServerLive.pipe(
   Layer.provide(
@@ -24,7 +29,10 @@ const ImposterServerWithDeps = ImposterServerLive.pipe(
       NodeServerFactoryLive,
       RequestLoggerLive,
       MetricsServiceLive,
-      ProxyServiceWithDeps
+      ProxyServiceWithDeps,
+      EventBusWithDeps,
+      CallbackServiceWithDeps,
+      VariablesLive
     )
   )
 )
@@ -36,6 +44,9 @@ const MainLayer = Layer.mergeAll(
   FiberManagerLive,
   RequestLoggerLive,
   MetricsServiceLive,
+  EventBusWithDeps,
+  CallbackServiceWithDeps,
+  VariablesLive,
   ImposterServerWithDeps
 )
 const FullLayer = ApiLayer.pipe(Layer.provide(MainLayer))
@@ -199,4 +210,47 @@ describe("E2E: Request Logging", () => {
       await new Promise((r) => setTimeout(r, 100))
     }
   }, 10000)
+
+  it("serves a 500 and journals violations when a rendered body breaks its schema", async () => {
+    const imp = await createImposter(9514)
+    await addStub(imp.id, {
+      predicates: [],
+      responses: [{
+        status: 200,
+        body: { id: "{{request.query.id}}", total: 5 },
+        validate: {
+          schema: {
+            type: "object",
+            required: ["id", "total"],
+            properties: { id: { type: "string", pattern: "^ord-" }, total: { type: "integer" } }
+          }
+        }
+      }]
+    })
+
+    await startImposter(imp.id)
+    await new Promise((r) => setTimeout(r, 150))
+
+    try {
+      const ok = await fetch("http://localhost:9514/orders?id=ord-1")
+      expect(ok.status).toBe(200)
+      expect(await ok.json()).toEqual({ id: "ord-1", total: 5 })
+
+      const bad = await fetch("http://localhost:9514/orders?id=42")
+      expect(bad.status).toBe(500)
+      expect(await bad.json()).toEqual({
+        error: "Response does not match its schema",
+        violations: ["$.id: does not match ^ord-"]
+      })
+      await new Promise((r) => setTimeout(r, 100))
+
+      const entries = await (await admin(`/imposters/${imp.id}/requests`)).json()
+      expect(entries.map((e: any) => e.response.status)).toEqual([200, 500])
+      expect(entries[0].response.violations).toBeUndefined()
+      expect(entries[1].response.violations).toEqual(["$.id: does not match ^ord-"])
+    } finally {
+      await stopImposter(imp.id)
+      await new Promise((r) => setTimeout(r, 100))
+    }
+  }, 10000)
 })
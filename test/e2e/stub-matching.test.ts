@@ -5,16 +5,22 @@ import { ImposterRepositoryLive } from "imposters/repositories/ImposterRepositor
 import { FiberManagerLive } from "imposters/server/FiberManager"
 import { ImposterServerLive } from "imposters/server/ImposterServer"
 import { AppConfigLive } from "imposters/services/AppConfig"
+import { CallbackServiceLive } from "imposters/services/CallbackService"
+import { EventBusLive } from "imposters/services/EventBus"
 import { MetricsServiceLive } from "imposters/services/MetricsService"
 import { PortAllocatorLive } from "imposters/services/PortAllocator"
 import { ProxyServiceLive } from "imposters/services/ProxyService"
 import { RequestLoggerLive } from "imposters/services/RequestLogger"
 import { UuidLive } from "imposters/services/UuidLive"
+import { VariablesLive } from "imposters/services/Variables"
 import { NodeServerFactoryLive } from "imposters/test/helpers/NodeServerFactory"
+import { gzipSync } from "node:zlib"
 import { afterAll, beforeAll, describe, expect, it } from "vitest"
 
 const PortAllocatorWithDeps = PortAllocatorLive.pipe(Layer.provide(AppConfigLive))
 const ProxyServiceWithDeps = ProxyServiceLive.pipe(Layer.provide(UuidLive))
+const EventBusWithDeps = EventBusLive.pipe(Layer.provide(UuidLive))
+const CallbackServiceWithDeps = CallbackServiceLive.pipe(Layer.provide(UuidLive))
 
 const ImposterServerWithDeps = ImposterServerLive.pipe(
   Layer.provide(
@@ -24,7 +30,10 @@ const ImposterServerWithDeps = ImposterServerLive.pipe(
       NodeServerFactoryLive,
       RequestLoggerLive,
       MetricsServiceLive,
-      ProxyServiceWithDeps
+      ProxyServiceWithDeps,
+      EventBusWithDeps,
+      CallbackServiceWithDeps,
+      VariablesLive
     )
   )
 )
@@ -36,6 +45,9 @@ const MainLayer = Layer.mergeAll(
   FiberManagerLive,
   RequestLoggerLive,
   MetricsServiceLive,
+  EventBusWithDeps,
+  CallbackServiceWithDeps,
+  VariablesLive,
   ImposterServerWithDeps
 )
 const FullLayer = ApiLayer.pipe(Layer.provide(MainLayer))
@@ -265,4 +277,85 @@ describe("E2E: Stub Matching", () => {
       await new Promise((r) => setTimeout(r, 100))
     }
   }, 10000)
+
+  it("truncate fault cuts the body after correct headers", async () => {
+    const imp = await createImposter(9208)
+    await addStub(imp.id, {
+      predicates: [],
+      responses: [{ status: 200, body: { items: ["a", "b", "c", "d"] }, fault: { type: "truncate", bytes: 10 } }]
+    })
+
+    await startImposter(imp.id)
+    await new Promise((r) => setTimeout(r, 150))
+
+    try {
+      const resp = await fetch("http://localhost:9208/items")
+      expect(resp.status).toBe(200)
+      expect(resp.headers.get("content-type")).toBe("application/json")
+      expect(resp.headers.get("content-length")).toBe(String(JSON.stringify({ items: ["a", "b", "c", "d"] }).length))
+      await expect(resp.text()).rejects.toThrow()
+    } finally {
+      await stopImposter(imp.id)
+      await new Promise((r) => setTimeout(r, 100))
+    }
+  }, 10000)
+
+  it("serves placeholder images intact, with byte ranges", async () => {
+    const imp = await createImposter(9209)
+    await addStub(imp.id, {
+      predicates: [],
+      responses: [{ media: { type: "image", width: 40, height: 30, color: "#336699" } }]
+    })
+
+    await startImposter(imp.id)
+    await new Promise((r) => setTimeout(r, 150))
+
+    try {
+      const full = await fetch("http://localhost:9209/avatar.png")
+      expect(full.headers.get("content-type")).toBe("image/png")
+      expect(full.headers.get("accept-ranges")).toBe("bytes")
+      const bytes = new Uint8Array(await full.arrayBuffer())
+      expect(Array.from(bytes.subarray(0, 4))).toEqual([0x89, 0x50, 0x4e, 0x47])
+
+      const part = await fetch("http://localhost:9209/avatar.png", { headers: { range: "bytes=8-15" } })
+      expect(part.status).toBe(206)
+      expect(part.headers.get("content-range")).toBe(`bytes 8-15/${bytes.byteLength}`)
+      expect(Array.from(new Uint8Array(await part.arrayBuffer()))).toEqual(Array.from(bytes.subarray(8, 16)))
+
+      const beyond = await fetch("http://localhost:9209/avatar.png", { headers: { range: "bytes=99999-" } })
+      expect(beyond.status).toBe(416)
+      expect(beyond.headers.get("content-range")).toBe(`bytes */${bytes.byteLength}`)
+    } finally {
+      await stopImposter(imp.id)
+      await new Promise((r) => setTimeout(r, 100))
+    }
+  }, 10000)
+
+  it("matches a gzipped body and journals the bytes received", async () => {
+    const imp = await createImposter(9210)
+    await addStub(imp.id, {
+      predicates: [{ field: "body", operator: "equals", value: { name: "Alice" } }],
+      responses: [{ status: 201, body: { created: true } }]
+    })
+
+    await startImposter(imp.id)
+    await new Promise((r) => setTimeout(r, 150))
+
+    try {
+      const compressed = gzipSync(JSON.stringify({ name: "Alice" }))
+      const resp = await fetch("http://localhost:9210/users", {
+        method: "POST",
+        headers: { "content-type": "application/json", "content-encoding": "gzip" },
+        body: compressed
+      })
+      expect(resp.status).toBe(201)
+      expect(await resp.json()).toEqual({ created: true })
+
+      const entries = await (await admin(`/imposters/${imp.id}/requests`)).json()
+      expect(entries[0].request.bodySize).toBe(compressed.byteLength)
+    } finally {
+      await stopImposter(imp.id)
+      await new Promise((r) => setTimeout(r, 100))
+    }
+  }, 10000)
 })
@@ -0,0 +1,66 @@
+import * as Schema from "effect/Schema"
+import { bodyHash } from "imposters/domain/bodies"
+import { curlScript } from "imposters/exporters/Curl"
+import { ExportDocument } from "imposters/schemas/ConfigFileSchema"
+import { describe, expect, it } from "vitest"
+
+const widget = { name: "Widget" }
+const exportDocument = Schema.decodeUnknownSync(ExportDocument)({
+  variables: { tenant: "acme" },
+  profiles: {},
+  imposters: [{
+    name: "orders",
+    port: 4000,
+    stubs: [
+      {
+        predicates: [
+          { field: "method", operator: "equals", value: "GET" },
+          { field: "path", operator: "template", value: "/orders/{id}" }
+        ],
+        responses: [{ body: { id: "{{request.params.id}}" } }]
+      },
+      { predicates: [], responses: [{ bodyRef: bodyHash(widget) }, { bodyRef: bodyHash(widget) }] }
+    ]
+  }],
+  bodies: { [bodyHash(widget)]: widget }
+})
+
+describe("curlScript", () => {
+  it("creates each imposter, adds its stubs and starts it, keeping its id in $id", () => {
+    const script = curlScript(exportDocument)
+    expect(script.startsWith("#!/bin/sh\n")).toBe(true)
+    expect(script).toContain(`# Imposter orders on port 4000\nid=$(create <<'JSON'\n{\n  "name": "orders",`)
+    expect(script).toContain(`# Stub GET /orders/{id}\nadmin POST "/imposters/$id/stubs" >/dev/null <<'JSON'\n`)
+    expect(script).toContain(`admin PATCH "/imposters/$id" >/dev/null <<'JSON'\n{\n  "status": "running"\n}\nJSON\n`)
+  })
+
+  it("puts shared bodies back in place and merges the variables first", () => {
+    const script = curlScript(exportDocument)
+    expect(script).not.toContain("bodyRef")
+    expect(script.match(/"Widget"/g)).toHaveLength(2)
+    expect(script.indexOf(`admin PATCH "/variables"`)).toBeLessThan(script.indexOf("id=$(create"))
+  })
+
+  it("keeps titles on their comment line, whatever the imposter is called", () => {
+    const script = curlScript(
+      Schema.decodeUnknownSync(ExportDocument)({
+        variables: {},
+        profiles: {},
+        imposters: [{ name: "orders\ntouch /tmp/pwned\r\n#", port: 4000, stubs: [] }],
+        bodies: {}
+      })
+    )
+    expect(script).toContain("# Imposter orders touch /tmp/pwned # on port 4000\nid=$(create")
+    expect(script.split("\n").filter((line) => line.startsWith("touch"))).toEqual([])
+  })
+
+  it("sends ADMIN_TOKEN as a bearer token when set", () => {
+    const script = curlScript(exportDocument)
+    expect(script).toContain(`ADMIN_TOKEN="\${ADMIN_TOKEN:-}"\n`)
+    expect(script).toContain(`[ -z "$ADMIN_TOKEN" ] || set -- -H "Authorization: Bearer $ADMIN_TOKEN"\n`)
+  })
+
+  it("reads the new imposter's id wherever the key is in the response", () => {
+    expect(curlScript(exportDocument)).toContain(`grep -o '"id" *: *"[^"]*"' | head -n 1`)
+  })
+})
@@ -0,0 +1,44 @@
+import { goSource } from "imposters/exporters/Go"
+import { describe, expect, it } from "vitest"
+
+const document = {
+  variables: {},
+  profiles: {},
+  imposters: [{
+    name: "orders",
+    port: 4000,
+    stubs: [{ predicates: [], responses: [{ status: 200, body: "`quoted`" }] }],
+    presets: []
+  }],
+  bodies: {}
+}
+
+describe("goSource", () => {
+  it("declares a setup helper that loads the imposters and deletes them on cleanup", () => {
+    const source = goSource(document, "orders_test")
+    expect(source).toContain("package orders_test\n")
+    expect(source).toContain("func SetupImposters(t *testing.T, adminURL string) {")
+    expect(source).toContain(`adminRequest(t, http.MethodPost, adminURL+"/imposters/load", manifest)`)
+    expect(source).toContain("t.Cleanup(func() {")
+    expect(source).not.toContain("/variables")
+  })
+
+  it("sends $ADMIN_TOKEN as a bearer token when set", () => {
+    const source = goSource(document, "imposters")
+    expect(source).toContain(`\t"os"\n`)
+    expect(source).toContain(`if token := os.Getenv("ADMIN_TOKEN"); token != "" {`)
+  })
+
+  it("embeds the manifest in a raw string with backquotes spliced in", () => {
+    const source = goSource(document, "imposters")
+    const literal = source.slice(source.indexOf("const manifest = ") + "const manifest = ".length).trimEnd()
+    const json = literal.slice(1, -1).replaceAll("` + \"`\" + `", "`")
+    expect(JSON.parse(json)).toEqual({ name: "exported", imposters: document.imposters, bodies: {} })
+  })
+
+  it("merges the template variables first when there are any", () => {
+    const source = goSource({ ...document, variables: { tenant: "acme" } }, "imposters")
+    expect(source).toContain(`adminRequest(t, http.MethodPatch, adminURL+"/variables", variables)`)
+    expect(source).toContain(`const variables = \`{\n  "tenant": "acme"\n}\``)
+  })
+})
@@ -0,0 +1,63 @@
+import * as Schema from "effect/Schema"
+import { bodyHash } from "imposters/domain/bodies"
+import { httpFile } from "imposters/exporters/Http"
+import { ExportDocument } from "imposters/schemas/ConfigFileSchema"
+import { describe, expect, it } from "vitest"
+
+const widget = { name: "Widget" }
+const exportDocument = Schema.decodeUnknownSync(ExportDocument)({
+  variables: { tenant: "acme" },
+  profiles: {},
+  imposters: [{
+    name: "orders",
+    port: 4000,
+    stubs: [
+      {
+        predicates: [
+          { field: "method", operator: "equals", value: "GET" },
+          { field: "path", operator: "template", value: "/orders/{id}" }
+        ],
+        responses: [{ body: { id: "{{request.params.id}}" } }]
+      },
+      { predicates: [], responses: [{ bodyRef: bodyHash(widget) }, { bodyRef: bodyHash(widget) }] }
+    ]
+  }],
+  bodies: { [bodyHash(widget)]: widget }
+})
+
+describe("httpFile", () => {
+  it("names each imposter's creation and sends its stubs to the id it answered with", () => {
+    const file = httpFile(exportDocument)
+    expect(file).toContain("@admin = http://localhost:2525\n")
+    expect(file).toContain("### Imposter orders on port 4000\n# @name imposter1\nPOST {{admin}}/imposters\n")
+    expect(file).toContain("### Stub GET /orders/{id}\nPOST {{admin}}/imposters/{{imposter1.response.body.$.id}}/stubs")
+    expect(file).toContain("### Start it\nPATCH {{admin}}/imposters/{{imposter1.response.body.$.id}}\n")
+  })
+
+  it("sends the admin token from the editor's environment", () => {
+    const file = httpFile(exportDocument)
+    expect(file).toContain("@token = {{$processEnv ADMIN_TOKEN}}\n")
+    expect(file).toContain("/imposters\nContent-Type: application/json\nAuthorization: Bearer {{token}}\n")
+  })
+
+  it("escapes template braces so the client leaves them alone", () => {
+    const file = httpFile(exportDocument)
+    expect(file).toContain(`"id": "\\u007b\\u007brequest.params.id}}"`)
+    const body = file.slice(file.indexOf("### Stub GET /orders/{id}"))
+    const json = body.slice(body.indexOf("\n\n") + 2, body.indexOf("\n\n###"))
+    expect(JSON.parse(json).responses[0].body.id).toBe("{{request.params.id}}")
+  })
+
+  it("keeps titles on their separator line", () => {
+    const file = httpFile(
+      Schema.decodeUnknownSync(ExportDocument)({
+        variables: {},
+        profiles: {},
+        imposters: [{ name: "orders\nDELETE http://localhost:2525/imposters", port: 4000, stubs: [] }],
+        bodies: {}
+      })
+    )
+    expect(file).toContain("### Imposter orders DELETE http://localhost:2525/imposters on port 4000\n# @name")
+    expect(file.split("\n").filter((line) => line.startsWith("DELETE"))).toEqual([])
+  })
+})
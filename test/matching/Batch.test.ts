@@ -0,0 +1,96 @@
+import * as Schema from "effect/Schema"
+import { answerBatch, withResults } from "imposters/matching/Batch"
+import { buildResponse } from "imposters/matching/ResponseGenerator"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { BatchResponse, ResponseConfig } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const makeCtx = (body: unknown): RequestContext => ({
+  method: "POST",
+  path: "/bulk",
+  headers: { "content-type": "application/json" },
+  query: {},
+  body
+})
+
+const batchOf = (input: unknown) => Schema.decodeUnknownSync(BatchResponse)(input)
+
+const operations = [
+  { id: "a", action: "create", name: "first" },
+  { id: "b", action: "delete", name: "second" },
+  { id: "c", action: "create", name: "third" }
+]
+
+describe("answerBatch", () => {
+  it("answers each operation from the first rule covering it, else the default", async () => {
+    const answer = await answerBatch(
+      batchOf({
+        operations: "ops",
+        rules: [
+          { ids: ["c"], result: { id: "{{request.body.id}}", error: "conflict" }, failed: true },
+          {
+            predicates: [{ field: "body", operator: "matchesJson", value: { action: "delete" } }],
+            result: { id: "{{request.body.id}}", deleted: true }
+          }
+        ],
+        result: { index: "{{index}}", created: "{{request.body.name}}" }
+      }),
+      makeCtx({ ops: operations })
+    )
+    expect(answer.results).toEqual([
+      { index: 0, created: "first" },
+      { id: "b", deleted: true },
+      { id: "c", error: "conflict" }
+    ])
+    expect(answer.status).toBeUndefined()
+  })
+
+  it("covers operations by position, and sets the failure status when any failed", async () => {
+    const answer = await answerBatch(
+      batchOf({ rules: [{ indexes: [1], result: { status: 500 }, failed: true }], failureStatus: 207 }),
+      makeCtx(operations)
+    )
+    expect(answer.results).toEqual([{ index: 0, status: 200 }, { status: 500 }, { index: 2, status: 200 }])
+    expect(answer.status).toBe(207)
+  })
+
+  it("needs every condition a rule sets to hold", async () => {
+    const answer = await answerBatch(
+      batchOf({ rules: [{ indexes: [0, 1], ids: ["b"], result: "hit" }], result: "miss" }),
+      makeCtx(operations)
+    )
+    expect(answer.results).toEqual(["miss", "hit", "miss"])
+  })
+
+  it("treats a body without an operations array as a batch of none", async () => {
+    expect((await answerBatch(batchOf({ operations: "ops" }), makeCtx({ other: [] }))).results).toEqual([])
+  })
+})
+
+describe("withResults", () => {
+  it("puts the results at a dot path in the body, or makes them the body", () => {
+    expect(withResults({ took: 3 }, [1, 2], "data.items")).toEqual({ took: 3, data: { items: [1, 2] } })
+    expect(withResults(undefined, [1, 2], undefined)).toEqual([1, 2])
+  })
+})
+
+describe("buildResponse with a batch", () => {
+  it("renders the body around the results and uses the failure status", async () => {
+    const config = Schema.decodeUnknownSync(ResponseConfig)({
+      body: { errors: true, path: "{{request.path}}" },
+      batch: {
+        into: "items",
+        rules: [{ ids: ["b"], result: { id: "b", status: 404 }, failed: true }],
+        result: { id: "{{request.body.id}}", status: 201 },
+        failureStatus: 207
+      }
+    })
+    const response = await buildResponse(config, makeCtx(operations))
+    expect(response.status).toBe(207)
+    expect(await response.json()).toEqual({
+      errors: true,
+      path: "/bulk",
+      items: [{ id: "a", status: 201 }, { id: "b", status: 404 }, { id: "c", status: 201 }]
+    })
+  })
+})
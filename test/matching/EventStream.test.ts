@@ -0,0 +1,51 @@
+import { eventStream, formatEvent, liveEventStream } from "imposters/matching/EventStream"
+import { describe, expect, it } from "vitest"
+
+describe("formatEvent", () => {
+  it("writes the event name and id, then the data", () => {
+    expect(formatEvent({ event: "update", id: 7, data: { n: 1 } })).toBe("event: update\nid: 7\ndata: {\"n\":1}\n\n")
+  })
+
+  it("splits multi-line data across data lines", () => {
+    expect(formatEvent({ data: "one\ntwo\r\nthree" })).toBe("data: one\ndata: two\ndata: three\n\n")
+  })
+})
+
+describe("eventStream", () => {
+  it("sends events in order, each after its delay", async () => {
+    const started = Date.now()
+    const text = await new Response(eventStream([{ text: "a", delay: 0 }, { text: "b", delay: 50 }])).text()
+    expect(text).toBe("ab")
+    expect(Date.now() - started).toBeGreaterThanOrEqual(45)
+  })
+
+  it("stops when cancelled", async () => {
+    const reader = eventStream([{ text: "a", delay: 0 }, { text: "b", delay: 60000 }]).getReader()
+    expect(new TextDecoder().decode((await reader.read()).value)).toBe("a")
+    await reader.cancel()
+  })
+})
+
+describe("liveEventStream", () => {
+  it("sends the first events, then the events each change renders, until cancelled", async () => {
+    let listener: (value: string) => void = () => {}
+    let stopped = false
+    const stream = liveEventStream(
+      eventStream([{ text: "first;", delay: 0 }]),
+      (l: (value: string) => void) => {
+        listener = l
+        return () => {
+          stopped = true
+        }
+      },
+      async (value) => [{ text: `${value};`, delay: 0 }]
+    )
+    const reader = stream.getReader()
+    const decoder = new TextDecoder()
+    expect(decoder.decode((await reader.read()).value)).toBe("first;")
+    listener("second")
+    expect(decoder.decode((await reader.read()).value)).toBe("second;")
+    await reader.cancel()
+    expect(stopped).toBe(true)
+  })
+})
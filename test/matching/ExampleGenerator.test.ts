@@ -0,0 +1,127 @@
+import { generateExample } from "imposters/matching/ExampleGenerator"
+import { describe, expect, it } from "vitest"
+
+const options = { arraySize: { min: 1, max: 3 } }
+
+const spec = {
+  $ref: "#/components/schemas/Order",
+  components: {
+    schemas: {
+      Order: {
+        type: "object",
+        required: ["id", "lines"],
+        properties: {
+          id: { type: "string", format: "uuid" },
+          email: { type: "string", format: "email" },
+          status: { enum: ["new", "paid", "shipped"] },
+          placedAt: { type: "string", format: "date-time" },
+          lines: { type: "array", minItems: 1, items: { $ref: "#/components/schemas/Line" } },
+          parent: { $ref: "#/components/schemas/Order" }
+        }
+      },
+      Line: {
+        type: "object",
+        properties: {
+          sku: { type: "string", format: "sku" },
+          qty: { type: "integer", minimum: 1, maximum: 9 }
+        }
+      }
+    }
+  }
+}
+
+describe("generateExample", () => {
+  it("follows $refs into OpenAPI components", () => {
+    const order = generateExample(spec, { ...options, formats: { sku: "SKU-####" } }) as Record<string, any>
+    expect(order.id).toMatch(/^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$/)
+    expect(order.email).toMatch(/^[a-z]+\.[a-z]+@example\.com$/)
+    expect(["new", "paid", "shipped"]).toContain(order.status)
+    expect(Number.isNaN(Date.parse(order.placedAt))).toBe(false)
+    expect(order.lines.length).toBeGreaterThanOrEqual(1)
+    for (const line of order.lines) {
+      expect(line.sku).toMatch(/^SKU-\d{4}$/)
+      expect(Number.isInteger(line.qty)).toBe(true)
+      expect(line.qty).toBeGreaterThanOrEqual(1)
+      expect(line.qty).toBeLessThanOrEqual(9)
+    }
+  })
+
+  it("stops expanding recursive schemas", () => {
+    let order = generateExample(spec, options) as Record<string, any>
+    let levels = 1
+    while (order.parent !== undefined) {
+      order = order.parent
+      levels++
+    }
+    expect(levels).toBeLessThan(6)
+    expect(order.lines.length).toBeGreaterThanOrEqual(1)
+  })
+
+  it("ends schemas that require themselves", () => {
+    const tree = {
+      $ref: "#/$defs/Node",
+      $defs: {
+        Node: {
+          type: "object",
+          required: ["name", "children", "parent"],
+          properties: {
+            name: { type: "string" },
+            children: { type: "array", minItems: 1, items: { $ref: "#/$defs/Node" } },
+            parent: { $ref: "#/$defs/Node" }
+          }
+        }
+      }
+    }
+    let node = generateExample(tree, options) as Record<string, any>
+    let levels = 0
+    while (node !== null && typeof node === "object") {
+      expect(typeof node.name).toBe("string")
+      node = node.children[0]
+      levels++
+    }
+    expect(node === null || node === undefined).toBe(true)
+    expect(levels).toBeLessThanOrEqual(8)
+  })
+
+  it("is deterministic for a seed", () => {
+    const a = generateExample(spec, { ...options, seed: 42 })
+    expect(generateExample(spec, { ...options, seed: 42 })).toEqual(a)
+  })
+
+  it("sizes arrays within arraySize and the schema's own bounds", () => {
+    const schema = { type: "array", items: { type: "boolean" } }
+    expect(generateExample(schema, { arraySize: { min: 4, max: 4 } })).toHaveLength(4)
+    expect(generateExample({ ...schema, maxItems: 2 }, { arraySize: { min: 4, max: 6 } })).toHaveLength(2)
+    expect(generateExample({ ...schema, minItems: 5 }, { arraySize: { min: 0, max: 1 } })).toHaveLength(5)
+  })
+
+  it("prefers examples, const and defaults over generated values", () => {
+    expect(generateExample({ type: "string", example: "fixed" }, options)).toBe("fixed")
+    expect(generateExample({ const: 3 }, options)).toBe(3)
+    expect(generateExample({ type: "integer", default: 10 }, options)).toBe(10)
+  })
+
+  it("respects numeric and string bounds", () => {
+    for (let i = 0; i < 20; i++) {
+      const n = generateExample({ type: "number", exclusiveMinimum: 0, maximum: 1 }, options) as number
+      expect(n).toBeGreaterThan(0)
+      expect(n).toBeLessThanOrEqual(1)
+      expect((generateExample({ type: "integer", minimum: 0, maximum: 20, multipleOf: 5 }, options) as number) % 5)
+        .toBe(0)
+      const s = generateExample({ type: "string", minLength: 30, maxLength: 32 }, options) as string
+      expect(s.length).toBeGreaterThanOrEqual(30)
+      expect(s.length).toBeLessThanOrEqual(32)
+    }
+  })
+
+  it("merges allOf parts and picks one of oneOf", () => {
+    const merged = generateExample({
+      allOf: [
+        { type: "object", required: ["id"], properties: { id: { const: 1 } } },
+        { properties: { active: { type: "boolean" } } }
+      ]
+    }, options)
+    expect(merged).toEqual({ id: 1, active: expect.any(Boolean) })
+    expect(generateExample({ oneOf: [{ type: "null" }] }, options)).toBeNull()
+  })
+})
@@ -0,0 +1,87 @@
+import { validateJsonSchema } from "imposters/matching/JsonSchema"
+import { describe, expect, it } from "vitest"
+
+const order = {
+  $ref: "#/components/schemas/Order",
+  components: {
+    schemas: {
+      Order: {
+        type: "object",
+        required: ["id", "lines"],
+        additionalProperties: false,
+        properties: {
+          id: { type: "string", format: "uuid" },
+          status: { enum: ["new", "paid"] },
+          note: { type: "string", nullable: true },
+          lines: { type: "array", minItems: 1, items: { $ref: "#/components/schemas/Line" } }
+        }
+      },
+      Line: {
+        type: "object",
+        required: ["qty"],
+        properties: { qty: { type: "integer", minimum: 1 }, "unit-price": { type: "number", multipleOf: 0.01 } }
+      }
+    }
+  }
+}
+
+const valid = { id: "0b9e0f44-5c1c-4d0c-9f4e-7b1f4c8a2d11", status: "paid", note: null, lines: [{ qty: 2 }] }
+
+describe("validateJsonSchema", () => {
+  it("accepts a conformant value", () => {
+    expect(validateJsonSchema(order, valid)).toEqual([])
+    expect(validateJsonSchema({}, "anything")).toEqual([])
+  })
+
+  it("reports every violation with its path", () => {
+    const errors = validateJsonSchema(order, {
+      id: "not-a-uuid",
+      status: "lost",
+      extra: true,
+      lines: [{ qty: 0, "unit-price": 1.005 }, {}]
+    })
+    expect(errors).toEqual([
+      "$.id: not a valid uuid",
+      "$.status: expected one of \"new\", \"paid\"",
+      "$: unexpected property \"extra\"",
+      "$.lines[0].qty: less than 1",
+      "$.lines[0][\"unit-price\"]: not a multiple of 0.01",
+      "$.lines[1]: missing required property \"qty\""
+    ])
+  })
+
+  it("stops at a type mismatch", () => {
+    expect(validateJsonSchema(order, [])).toEqual(["$: expected object, got array"])
+    expect(validateJsonSchema({ type: ["integer", "null"] }, 1.5)).toEqual(["$: expected integer or null, got number"])
+  })
+
+  it("checks string and array bounds", () => {
+    expect(validateJsonSchema({ type: "string", minLength: 3, pattern: "^a" }, "b")).toEqual([
+      "$: shorter than 3 characters",
+      "$: does not match ^a"
+    ])
+    expect(validateJsonSchema({ type: "array", maxItems: 2, uniqueItems: true }, [1, 1, 1])).toEqual([
+      "$: more than 2 items",
+      "$: items are not unique"
+    ])
+  })
+
+  it("combines schemas with allOf, anyOf, oneOf and not", () => {
+    const number = { type: "number" }
+    const positive = { type: "number", exclusiveMinimum: 0 }
+    expect(validateJsonSchema({ allOf: [number, positive] }, -1)).toEqual(["$: not greater than 0"])
+    expect(validateJsonSchema({ anyOf: [{ type: "string" }, positive] }, -1)).toEqual([
+      "$: does not match any schema in anyOf"
+    ])
+    expect(validateJsonSchema({ oneOf: [number, positive] }, 5)).toEqual([
+      "$: matches 2 schemas in oneOf, expected exactly 1"
+    ])
+    expect(validateJsonSchema({ not: { const: 0 } }, 0)).toEqual(["$: matches a schema in not"])
+  })
+
+  it("reports refs that do not resolve", () => {
+    expect(validateJsonSchema({ $ref: "#/components/schemas/Missing" }, 1)).toEqual([
+      "$: unresolved $ref #/components/schemas/Missing"
+    ])
+  })
+})
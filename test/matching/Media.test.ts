@@ -0,0 +1,64 @@
+import { isTextual, renderPdf, renderPng, renderSvg } from "imposters/matching/Media"
+import { inflateSync } from "node:zlib"
+import { describe, expect, it } from "vitest"
+
+const ascii = (bytes: Uint8Array) => new TextDecoder().decode(bytes)
+
+describe("renderPng", () => {
+  const png = renderPng(30, 20, "#336699")
+  const view = new DataView(png.buffer)
+
+  it("writes a valid header with the requested dimensions", () => {
+    expect(Array.from(png.subarray(0, 8))).toEqual([0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a])
+    expect(ascii(png.subarray(12, 16))).toBe("IHDR")
+    expect(view.getUint32(16)).toBe(30)
+    expect(view.getUint32(20)).toBe(20)
+    expect(ascii(png.subarray(png.byteLength - 8, png.byteLength - 4))).toBe("IEND")
+  })
+
+  it("fills the image with the background colour", () => {
+    const idatLength = view.getUint32(33)
+    const raw = inflateSync(png.subarray(41, 41 + idatLength))
+    expect(raw.byteLength).toBe(20 * (30 * 3 + 1))
+    // Top edge, halfway between the two diagonals
+    expect(Array.from(raw.subarray(1 + 15 * 3, 1 + 15 * 3 + 3))).toEqual([0x33, 0x66, 0x99])
+  })
+})
+
+describe("renderSvg", () => {
+  it("escapes the text and picks a readable ink", () => {
+    expect(renderSvg(100, 50, "#ffffff", "<b>")).toContain("fill=\"#333333\">&lt;b&gt;</text>")
+    expect(renderSvg(100, 50, "#000000", "x")).toContain("fill=\"#ffffff\">x</text>")
+  })
+})
+
+describe("renderPdf", () => {
+  const pdf = ascii(renderPdf("Invoice (draft)\nTotal: 10 €", 2, "letter"))
+
+  it("writes one page per requested page with escaped text", () => {
+    expect(pdf.startsWith("%PDF-1.4\n")).toBe(true)
+    expect(pdf.endsWith("%%EOF\n")).toBe(true)
+    expect(pdf).toContain("/Count 2")
+    expect(pdf).toContain("/MediaBox [0 0 612 792]")
+    expect(pdf).toContain("(Invoice \\(draft\\)) '")
+    expect(pdf).toContain("(Total: 10 ?) '")
+    expect(pdf).toContain("(Page 2 of 2) Tj")
+  })
+
+  it("points the cross-reference table at each object", () => {
+    const xref = Number(pdf.match(/startxref\n(\d+)/)?.[1])
+    expect(pdf.slice(xref, xref + 4)).toBe("xref")
+    const offsets = pdf.slice(xref).split("\n").slice(3, 10).map((line) => Number(line.slice(0, 10)))
+    offsets.forEach((offset, i) => expect(pdf.slice(offset).startsWith(`${i + 1} 0 obj`)).toBe(true))
+  })
+})
+
+describe("isTextual", () => {
+  it("treats text, JSON and XML as text and media as binary", () => {
+    expect(isTextual("application/json; charset=utf-8")).toBe(true)
+    expect(isTextual("application/problem+xml")).toBe(true)
+    expect(isTextual(null)).toBe(true)
+    expect(isTextual("image/png")).toBe(false)
+    expect(isTextual("application/pdf")).toBe(false)
+  })
+})
@@ -0,0 +1,79 @@
+import { isDuplicateRecording, normalizeRecordedStub, pathPattern } from "imposters/matching/RecordNormalizer"
+import { evaluatePredicates } from "imposters/matching/RequestMatcher"
+import { NonEmptyString } from "imposters/schemas/common"
+import type { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const makeStub = (path: string, body: unknown, headers: Record<string, string> = {}): Stub => ({
+  id: NonEmptyString.make("rec1"),
+  predicates: [
+    { field: "method", operator: "equals", value: "GET", caseSensitive: true },
+    { field: "path", operator: "equals", value: path, caseSensitive: true }
+  ],
+  responses: [{ status: 200, headers, body }],
+  responseMode: "sequential"
+})
+
+const ctx = (path: string) => ({ method: "GET", path, headers: {}, query: {}, body: undefined })
+
+describe("pathPattern", () => {
+  it("replaces numeric, UUID and hex segments", () => {
+    expect(pathPattern("/users/42")).toBe("^/users/[^/]+$")
+    expect(pathPattern("/orders/0b6f3c1e-2f4a-4c1a-9d1e-4a7e2b9c5d10/items")).toBe("^/orders/[^/]+/items$")
+    expect(pathPattern("/blobs/5f2b9c1d8e7a6b4c3d2e")).toBe("^/blobs/[^/]+$")
+  })
+
+  it("returns undefined for static paths", () => {
+    expect(pathPattern("/api/health")).toBeUndefined()
+  })
+
+  it("escapes regex characters in static segments", () => {
+    expect(pathPattern("/v1.0/items/7")).toBe("^/v1\\.0/items/[^/]+$")
+  })
+})
+
+describe("normalizeRecordedStub", () => {
+  it("generalizes the path predicate so sibling IDs match", () => {
+    const stub = normalizeRecordedStub(makeStub("/users/42", { id: 42 }), "/users/42")
+    expect(evaluatePredicates(ctx("/users/99"), stub.predicates)).toBe(true)
+    expect(evaluatePredicates(ctx("/users/99/posts"), stub.predicates)).toBe(false)
+  })
+
+  it("templates echoed IDs and timestamps in the body", () => {
+    const body = { id: 42, userId: "42", name: "Ann", createdAt: "2024-05-01T10:00:00.000Z" }
+    const stub = normalizeRecordedStub(makeStub("/users/42", body), "/users/42")
+    expect(stub.responses[0]!.body).toEqual({
+      id: "${$number($split(request.path, \"/\")[2])}",
+      userId: "${$split(request.path, \"/\")[2]}",
+      name: "Ann",
+      createdAt: "${$now()}"
+    })
+  })
+
+  it("leaves fields that only happen to equal a path ID alone", () => {
+    const body = { page: 1, total: 1, id: 1, items: [{ rank: 1, ownerIds: [1, 2] }] }
+    const stub = normalizeRecordedStub(makeStub("/users/1", body), "/users/1")
+    expect(stub.responses[0]!.body).toEqual({
+      page: 1,
+      total: 1,
+      id: "${$number($split(request.path, \"/\")[2])}",
+      items: [{ rank: 1, ownerIds: ["${$number($split(request.path, \"/\")[2])}", 2] }]
+    })
+  })
+
+  it("drops volatile response headers", () => {
+    const stub = normalizeRecordedStub(
+      makeStub("/users/1", {}, { date: "Mon", "content-length": "2", "x-api": "v1" }),
+      "/users/1"
+    )
+    expect(stub.responses[0]!.headers).toEqual({ "x-api": "v1" })
+  })
+})
+
+describe("isDuplicateRecording", () => {
+  it("detects stubs with identical predicates", () => {
+    const existing = [makeStub("/a", 1)]
+    expect(isDuplicateRecording(existing, makeStub("/a", 2))).toBe(true)
+    expect(isDuplicateRecording(existing, makeStub("/b", 1))).toBe(false)
+  })
+})
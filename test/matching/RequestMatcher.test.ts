@@ -1,9 +1,21 @@
 import * as Schema from "effect/Schema"
+import { deflateSync, gzipSync } from "node:zlib"
 import {
+  allowedMethods,
+  decodeBody,
   evaluatePredicate,
+  evaluatePredicateExpression,
   evaluatePredicates,
   extractRequestContext,
-  findMatchingStub
+  findMatchingStub,
+  hostOf,
+  isBodyTooLarge,
+  matchMediaType,
+  matchStub,
+  MAX_DECODED_BODY_BYTES,
+  rankStubs,
+  receivedBody,
+  withPathParams
 } from "imposters/matching/RequestMatcher"
 import type { RequestContext } from "imposters/matching/RequestMatcher"
 import { Stub } from "imposters/schemas/StubSchema"
@@ -53,6 +65,18 @@ describe("extractRequestContext", () => {
     expect(ctx.body).toEqual({ name: "Alice", age: 30 })
   })
 
+  it("decompresses gzipped bodies and records the received size", async () => {
+    const compressed = gzipSync(JSON.stringify({ name: "Alice" }))
+    const req = new Request("http://localhost:3000/users", {
+      method: "POST",
+      headers: { "content-type": "application/json", "content-encoding": "gzip" },
+      body: compressed
+    })
+    const ctx = await extractRequestContext(req)
+    expect(ctx.body).toEqual({ name: "Alice" })
+    expect(ctx.bodySize).toBe(compressed.byteLength)
+  })
+
   it("falls back to text body for non-JSON", async () => {
     const req = new Request("http://localhost:3000/data", {
       method: "POST",
@@ -71,6 +95,36 @@ describe("extractRequestContext", () => {
     expect(ctx.headers["authorization"]).toBe("Bearer abc")
     expect(ctx.headers["x-custom"]).toBe("value")
   })
+
+  it("keeps the query string as sent", async () => {
+    const ctx = await extractRequestContext(new Request("http://localhost:3000/items?filter[tag]=a%2Cb&flag"))
+    expect(ctx.queryString).toBe("filter[tag]=a%2Cb&flag")
+    expect((await extractRequestContext(new Request("http://localhost:3000/items"))).queryString).toBe("")
+  })
+
+  it("keeps every value of a repeated query parameter", async () => {
+    const ctx = await extractRequestContext(new Request("http://localhost:3000/items?tag=a&tag=b&page=2"))
+    expect(ctx.query).toEqual({ tag: "b", page: "2" })
+    expect(ctx.queryValues).toEqual({ tag: ["a", "b"] })
+  })
+
+  it("parses form-encoded fields and keeps the raw body", async () => {
+    const body = "grant_type=client_credentials&scope=read&scope=write&note=a%20b"
+    const req = new Request("http://localhost:3000/oauth/token", {
+      method: "POST",
+      headers: { "content-type": "application/x-www-form-urlencoded; charset=utf-8" },
+      body
+    })
+    const ctx = await extractRequestContext(req)
+    expect(ctx.body).toBe(body)
+    expect(ctx.form).toEqual({ grant_type: "client_credentials", scope: "write", note: "a b" })
+    expect(ctx.formValues).toEqual({ scope: ["read", "write"] })
+  })
+
+  it("does not parse form fields from other content types", async () => {
+    const req = new Request("http://localhost:3000/data", { method: "POST", body: "a=1" })
+    expect((await extractRequestContext(req)).form).toBeUndefined()
+  })
 })
 
 describe("evaluatePredicate - method", () => {
@@ -125,6 +179,13 @@ describe("evaluatePredicate - path", () => {
       true
     )
   })
+  it("template matches wildcard and named segments", () => {
+    const ctx = makeCtx({ path: "/users/456/avatar" })
+    const template = (value: string) => makePredicate({ field: "path", operator: "template", value })
+    expect(evaluatePredicate(ctx, template("/users/*/avatar"))).toBe(true)
+    expect(evaluatePredicate(ctx, template("/users/{id}/avatar"))).toBe(true)
+    expect(evaluatePredicate(ctx, template("/users/*"))).toBe(false)
+  })
 })
 
 describe("evaluatePredicate - headers", () => {
@@ -184,6 +245,36 @@ describe("evaluatePredicate - headers", () => {
       })
     )).toBe(true)
   })
+  it("compares header names case-insensitively even when values are case-sensitive", () => {
+    const ctx = makeCtx({ headers: { "x-api-version": "2" } })
+    expect(evaluatePredicate(
+      ctx,
+      makePredicate({ field: "headers", operator: "equals", value: { "X-Api-Version": "2" } })
+    )).toBe(true)
+    expect(evaluatePredicate(
+      ctx,
+      makePredicate({ field: "headers", operator: "equals", value: { "X-Api-Version": "1" } })
+    )).toBe(false)
+  })
+
+  it("matches applies a regex to header values", () => {
+    const ctx = makeCtx({ headers: { "user-agent": "MyApp/3.2 (iOS)" } })
+    expect(evaluatePredicate(
+      ctx,
+      makePredicate({ field: "headers", operator: "matches", value: { "User-Agent": "^MyApp/3\\." } })
+    )).toBe(true)
+  })
+
+  it("exists with negate requires the header to be absent", () => {
+    const predicate = makePredicate({
+      field: "headers",
+      operator: "exists",
+      value: { "X-Debug": true },
+      negate: true
+    })
+    expect(evaluatePredicate(makeCtx({ headers: {} }), predicate)).toBe(true)
+    expect(evaluatePredicate(makeCtx({ headers: { "x-debug": "1" } }), predicate)).toBe(false)
+  })
 })
 
 describe("evaluatePredicate - query", () => {
@@ -210,6 +301,21 @@ describe("evaluatePredicate - query", () => {
       })
     )).toBe(true)
   })
+  it("matches a repeated parameter when any value matches", () => {
+    const ctx = makeCtx({ query: { status: "archived" }, queryValues: { status: ["active", "archived"] } })
+    const predicate = (value: string) => makePredicate({ field: "query", operator: "equals", value: { status: value } })
+    expect(evaluatePredicate(ctx, predicate("active"))).toBe(true)
+    expect(evaluatePredicate(ctx, predicate("archived"))).toBe(true)
+    expect(evaluatePredicate(ctx, predicate("deleted"))).toBe(false)
+  })
+
+  it("fails when the parameter is missing", () => {
+    const ctx = makeCtx({ query: { page: "1" } })
+    expect(evaluatePredicate(
+      ctx,
+      makePredicate({ field: "query", operator: "contains", value: { status: "act" } })
+    )).toBe(false)
+  })
 })
 
 describe("evaluatePredicate - body", () => {
@@ -275,6 +381,239 @@ describe("evaluatePredicate - body", () => {
   })
 })
 
+describe("evaluatePredicate - JSON body", () => {
+  const order = { customer: { id: 7, tier: "gold" }, items: [{ sku: "A1", qty: 2 }, { sku: "B2", qty: 1 }] }
+  const json = (operator: "equalToJson" | "matchesJson", value: unknown, caseSensitive = true) =>
+    makePredicate({ field: "body", operator, value, caseSensitive })
+
+  it("equalToJson ignores key order but requires the same fields", () => {
+    const ctx = makeCtx({ body: order })
+    const reordered = { items: order.items, customer: { tier: "gold", id: 7 } }
+    expect(evaluatePredicate(ctx, json("equalToJson", reordered))).toBe(true)
+    expect(evaluatePredicate(ctx, json("equalToJson", { customer: order.customer }))).toBe(false)
+  })
+
+  it("equalToJson keeps array order", () => {
+    const ctx = makeCtx({ body: order })
+    const swapped = { ...order, items: [order.items[1], order.items[0]] }
+    expect(evaluatePredicate(ctx, json("equalToJson", swapped))).toBe(false)
+  })
+
+  it("matchesJson allows extra fields and matches array items in any order", () => {
+    const ctx = makeCtx({ body: order })
+    expect(evaluatePredicate(ctx, json("matchesJson", { items: [{ sku: "B2" }] }))).toBe(true)
+    expect(evaluatePredicate(ctx, json("matchesJson", { customer: { tier: "silver" } }))).toBe(false)
+  })
+
+  it("parses JSON documents given as text on either side", () => {
+    const ctx = makeCtx({ body: JSON.stringify(order) })
+    expect(evaluatePredicate(ctx, json("matchesJson", "{\"customer\": {\"id\": 7}}"))).toBe(true)
+    expect(evaluatePredicate(makeCtx({ body: "not json" }), json("matchesJson", {}))).toBe(false)
+  })
+
+  it("honours caseSensitive for string values", () => {
+    const ctx = makeCtx({ body: { status: "ACTIVE" } })
+    expect(evaluatePredicate(ctx, json("equalToJson", { status: "active" }))).toBe(false)
+    expect(evaluatePredicate(ctx, json("equalToJson", { status: "active" }, false))).toBe(true)
+  })
+})
+
+describe("evaluatePredicate - form", () => {
+  const form = (operator: Predicate["operator"], value: unknown) => makePredicate({ field: "form", operator, value })
+
+  it("matches form fields like query parameters", () => {
+    const ctx = makeCtx({ form: { grant_type: "client_credentials", client_id: "svc-1" } })
+    expect(evaluatePredicate(ctx, form("equals", { grant_type: "client_credentials" }))).toBe(true)
+    expect(evaluatePredicate(ctx, form("equals", { grant_type: "password" }))).toBe(false)
+    expect(evaluatePredicate(ctx, form("startsWith", { client_id: "svc-" }))).toBe(true)
+    expect(evaluatePredicate(ctx, form("exists", { client_secret: true }))).toBe(false)
+  })
+
+  it("matches a repeated field when any value matches", () => {
+    const ctx = makeCtx({ form: { scope: "write" }, formValues: { scope: ["read", "write"] } })
+    expect(evaluatePredicate(ctx, form("equals", { scope: "read" }))).toBe(true)
+  })
+
+  it("does not match requests without a form body", () => {
+    expect(evaluatePredicate(makeCtx({ body: "grant_type=password" }), form("exists", { grant_type: true })))
+      .toBe(false)
+  })
+
+  it("routes token grants to different stubs", () => {
+    const clientCredentials = makeStub("cc", [form("equals", { grant_type: "client_credentials" })])
+    const password = makeStub("pw", [form("equals", { grant_type: "password" })])
+    const ctx = makeCtx({ method: "POST", path: "/oauth/token", form: { grant_type: "password" } })
+    expect(findMatchingStub(ctx, [clientCredentials, password])?.id).toBe("pw")
+  })
+})
+
+describe("evaluatePredicate - host", () => {
+  const host = (operator: Predicate["operator"], value: unknown) => makePredicate({ field: "host", operator, value })
+
+  it("matches the Host header without its port, ignoring case", () => {
+    const ctx = makeCtx({ headers: { host: "API.Example.com:8080" } })
+    expect(evaluatePredicate(ctx, host("equals", "api.example.com"))).toBe(true)
+    expect(evaluatePredicate(ctx, host("equals", "auth.example.com"))).toBe(false)
+    expect(evaluatePredicate(ctx, host("matches", "^[a-z]+\\.example\\.com$"))).toBe(true)
+    expect(hostOf({ host: "[::1]:4000" })).toBe("[::1]")
+  })
+
+  it("does not match requests without a Host header", () => {
+    expect(evaluatePredicate(makeCtx(), host("exists", true))).toBe(false)
+    expect(evaluatePredicate(makeCtx(), host("equals", "api.example.com"))).toBe(false)
+  })
+
+  it("serves several virtual hosts from one imposter", () => {
+    const api = makeStub("api", [host("equals", "api.example.com")])
+    const auth = makeStub("auth", [host("equals", "auth.example.com")])
+    const ctx = makeCtx({ headers: { host: "auth.example.com" } })
+    expect(findMatchingStub(ctx, [api, auth])?.id).toBe("auth")
+  })
+})
+
+describe("evaluatePredicate - XPath", () => {
+  const envelope = `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <m:GetUser xmlns:m="urn:users"><m:id>42</m:id><m:name>Alice</m:name></m:GetUser>
+  </soap:Body>
+</soap:Envelope>`
+  const xpath = (xpath: string, operator: Predicate["operator"], value: unknown = undefined) =>
+    makePredicate({ field: "body", operator, value, xpath })
+
+  it("matches the text of the selected nodes, ignoring namespace prefixes", () => {
+    const ctx = makeCtx({ headers: { "content-type": "text/xml; charset=utf-8" }, body: envelope })
+    expect(evaluatePredicate(ctx, xpath("/Envelope/Body/GetUser/id", "equals", "42"))).toBe(true)
+    expect(evaluatePredicate(ctx, xpath("//soap:Body/m:GetUser/m:name", "startsWith", "Al"))).toBe(true)
+    expect(evaluatePredicate(ctx, xpath("//GetUser/id", "equals", "7"))).toBe(false)
+  })
+
+  it("exists selects the operation element", () => {
+    const ctx = makeCtx({ headers: { "content-type": "application/soap+xml" }, body: envelope })
+    expect(evaluatePredicate(ctx, xpath("//Body/GetUser", "exists"))).toBe(true)
+    expect(evaluatePredicate(ctx, xpath("//Body/DeleteUser", "exists"))).toBe(false)
+  })
+
+  it("detects XML bodies sent without an XML content type", () => {
+    const ctx = makeCtx({ headers: { "content-type": "text/plain" }, body: envelope })
+    expect(evaluatePredicate(ctx, xpath("//GetUser/id", "equals", "42"))).toBe(true)
+  })
+
+  it("does not match bodies that are not XML", () => {
+    expect(evaluatePredicate(makeCtx({ body: { id: "42" } }), xpath("//id", "exists"))).toBe(false)
+    expect(evaluatePredicate(makeCtx({ body: "<unclosed>" }), xpath("//unclosed", "exists"))).toBe(false)
+  })
+
+  it("routes SOAP operations to different stubs", () => {
+    const getUser = makeStub("get", [xpath("//Body/GetUser", "exists")])
+    const deleteUser = makeStub("delete", [xpath("//Body/DeleteUser", "exists")])
+    const ctx = makeCtx({ method: "POST", path: "/soap", body: envelope })
+    expect(findMatchingStub(ctx, [deleteUser, getUser])?.id).toBe("get")
+  })
+})
+
+describe("evaluatePredicate - negate", () => {
+  it("negated exists matches when header is absent", () => {
+    const predicate = makePredicate({
+      field: "headers",
+      operator: "exists",
+      value: { authorization: true },
+      negate: true
+    })
+    expect(evaluatePredicate(makeCtx(), predicate)).toBe(true)
+    expect(evaluatePredicate(makeCtx({ headers: { authorization: "Bearer abc" } }), predicate)).toBe(false)
+  })
+
+  it("negated equals matches when query param differs or is missing", () => {
+    const predicate = makePredicate({
+      field: "query",
+      operator: "equals",
+      value: { status: "active" },
+      negate: true
+    })
+    expect(evaluatePredicate(makeCtx({ query: { status: "archived" } }), predicate)).toBe(true)
+    expect(evaluatePredicate(makeCtx(), predicate)).toBe(true)
+    expect(evaluatePredicate(makeCtx({ query: { status: "active" } }), predicate)).toBe(false)
+  })
+
+  it("body exists checks nested field presence", () => {
+    const ctx = makeCtx({ body: { user: { name: "Alice" } } })
+    expect(evaluatePredicate(
+      ctx,
+      makePredicate({ field: "body", operator: "exists", value: { user: { name: true } } })
+    )).toBe(true)
+    expect(evaluatePredicate(
+      ctx,
+      makePredicate({ field: "body", operator: "exists", value: { user: { email: true } } })
+    )).toBe(false)
+  })
+
+  it("negated body exists matches when field is missing", () => {
+    const predicate = makePredicate({ field: "body", operator: "exists", value: { token: true }, negate: true })
+    expect(evaluatePredicate(makeCtx({ body: { name: "Alice" } }), predicate)).toBe(true)
+    expect(evaluatePredicate(makeCtx({ body: { token: "abc" } }), predicate)).toBe(false)
+  })
+
+  it("negated contains matches bodies without the text, including no body at all", () => {
+    const predicate = makePredicate({ field: "body", operator: "contains", value: "sandbox", negate: true })
+    expect(evaluatePredicate(makeCtx({ body: "{\"env\":\"live\"}" }), predicate)).toBe(true)
+    expect(evaluatePredicate(makeCtx({ body: { env: "sandbox" } }), predicate)).toBe(false)
+    expect(evaluatePredicate(makeCtx(), predicate)).toBe(true)
+  })
+
+  it("lets a fallback stub cover everything except another stub's requests, in any order", () => {
+    const v2 = makePredicate({ field: "headers", operator: "equals", value: { "x-api-version": "2" } })
+    const fallback = makeStub("fallback", [{ ...v2, negate: true }])
+    const versioned = makeStub("v2", [v2])
+    const ctx = makeCtx({ headers: { "x-api-version": "2" } })
+    expect(findMatchingStub(ctx, [fallback, versioned])?.id).toBe("v2")
+    expect(findMatchingStub(makeCtx(), [fallback, versioned])?.id).toBe("fallback")
+  })
+})
+
+describe("evaluatePredicateExpression", () => {
+  const isGet = makePredicate({ field: "method", operator: "equals", value: "GET" })
+  const hasToken = makePredicate({ field: "headers", operator: "exists", value: { "x-token": true } })
+  const isDebug = makePredicate({ field: "query", operator: "equals", value: { debug: "1" } })
+
+  it("or matches when any branch matches", () => {
+    const expr = { or: [hasToken, isDebug] }
+    expect(evaluatePredicateExpression(makeCtx({ headers: { "x-token": "t" } }), expr)).toBe(true)
+    expect(evaluatePredicateExpression(makeCtx({ query: { debug: "1" } }), expr)).toBe(true)
+    expect(evaluatePredicateExpression(makeCtx(), expr)).toBe(false)
+  })
+
+  it("and requires every branch", () => {
+    const expr = { and: [isGet, hasToken] }
+    expect(evaluatePredicateExpression(makeCtx({ headers: { "x-token": "t" } }), expr)).toBe(true)
+    expect(evaluatePredicateExpression(makeCtx({ method: "POST", headers: { "x-token": "t" } }), expr)).toBe(false)
+  })
+
+  it("not inverts a nested expression", () => {
+    const expr = { not: { or: [hasToken, isDebug] } }
+    expect(evaluatePredicateExpression(makeCtx(), expr)).toBe(true)
+    expect(evaluatePredicateExpression(makeCtx({ query: { debug: "1" } }), expr)).toBe(false)
+  })
+
+  it("composes with plain predicates in a stub", () => {
+    const composed = Schema.decodeUnknownSync(Stub)({
+      id: "s1",
+      responses: [{ status: 200 }],
+      predicates: [
+        { field: "method", operator: "equals", value: "GET" },
+        {
+          or: [
+            { field: "headers", operator: "exists", value: { "x-token": true } },
+            { field: "query", operator: "equals", value: { debug: "1" } }
+          ]
+        }
+      ]
+    })
+    expect(findMatchingStub(makeCtx({ query: { debug: "1" } }), [composed])?.id).toBe("s1")
+    expect(findMatchingStub(makeCtx(), [composed])).toBeUndefined()
+  })
+})
+
 describe("evaluatePredicates", () => {
   it("empty predicates match everything (catch-all)", () => {
     expect(evaluatePredicates(makeCtx(), [])).toBe(true)
@@ -299,6 +638,36 @@ describe("evaluatePredicates", () => {
   })
 })
 
+describe("matchStub", () => {
+  const byMethod = (id: string, method: string) =>
+    makeStub(id, [makePredicate({ field: "method", operator: "equals", value: method })])
+
+  it("counts the stubs tried up to the match", () => {
+    const stubs = [byMethod("post", "POST"), byMethod("get", "GET"), byMethod("put", "PUT")]
+    const result = matchStub(makeCtx({ method: "GET" }), stubs)
+    expect(result.stub?.id).toBe("get")
+    expect(result.candidates).toBe(2)
+    expect(result.matchMs).toBeGreaterThanOrEqual(0)
+  })
+
+  it("counts every stub when none matches", () => {
+    const stubs = [byMethod("post", "POST"), byMethod("put", "PUT")]
+    const result = matchStub(makeCtx({ method: "DELETE" }), stubs)
+    expect(result.stub).toBeUndefined()
+    expect(result.candidates).toBe(2)
+  })
+
+  it("skips ineligible stubs without reranking the list", () => {
+    const stubs = [byMethod("first", "GET"), byMethod("second", "GET")]
+    const ranked = rankStubs(stubs)
+    const result = matchStub(makeCtx({ method: "GET" }), stubs, "definition", (stub) => stub.id !== "first")
+    expect(result.stub?.id).toBe("second")
+    expect(result.candidates).toBe(1)
+    expect(rankStubs(stubs)).toBe(ranked)
+    expect(allowedMethods(makeCtx({ method: "POST" }), stubs, () => false)).toEqual([])
+  })
+})
+
 describe("findMatchingStub", () => {
   it("returns first matching stub", () => {
     const ctx = makeCtx({ method: "GET", path: "/users" })
@@ -325,4 +694,247 @@ describe("findMatchingStub", () => {
     const match = findMatchingStub(ctx, stubs)
     expect(match?.id).toBe("catch-all")
   })
+
+  const path = (operator: "equals" | "template", value: string) => makePredicate({ field: "path", operator, value })
+
+  it("tries stubs in definition order unless the imposter ranks them by specificity", () => {
+    const byId = makeStub("by-id", [path("template", "/users/{id}")])
+    const me = makeStub("me", [path("equals", "/users/me")])
+    const ctx = makeCtx({ path: "/users/me" })
+    expect(findMatchingStub(ctx, [byId, me])?.id).toBe("by-id")
+    expect(findMatchingStub(ctx, [byId, me], "definition")?.id).toBe("by-id")
+    expect(rankStubs([byId, me]).map((s) => s.id)).toEqual(["by-id", "me"])
+  })
+
+  it("prefers literal segments over parameters, whatever the order", () => {
+    const byId = makeStub("by-id", [path("template", "/users/{id}")])
+    const me = makeStub("me", [path("equals", "/users/me")])
+    const ctx = makeCtx({ path: "/users/me" })
+    expect(findMatchingStub(ctx, [byId, me], "specificity")?.id).toBe("me")
+    expect(findMatchingStub(ctx, [me, byId], "specificity")?.id).toBe("me")
+    expect(findMatchingStub(makeCtx({ path: "/users/42" }), [byId, me], "specificity")?.id).toBe("by-id")
+  })
+
+  it("ranks parameters above wildcards and rest segments, and any path above none", () => {
+    const stubs = [
+      makeStub("any", []),
+      makeStub("rest", [path("template", "/files/{rest...}")]),
+      makeStub("wildcard", [path("template", "/files/*/raw")]),
+      makeStub("param", [path("template", "/files/{name}/raw")])
+    ]
+    expect(rankStubs(stubs, "specificity").map((s) => s.id)).toEqual(["param", "wildcard", "rest", "any"])
+    expect(findMatchingStub(makeCtx({ path: "/files/a/raw" }), stubs, "specificity")?.id).toBe("param")
+    const root = makeStub("root", [path("equals", "/files")])
+    expect(findMatchingStub(makeCtx({ path: "/files" }), [...stubs, root], "specificity")?.id).toBe("root")
+  })
+
+  it("lets an explicit priority override specificity and definition order", () => {
+    const me = makeStub("me", [path("equals", "/users/me")])
+    const maintenance = { ...makeStub("maintenance", [], 503), priority: 10 }
+    expect(findMatchingStub(makeCtx({ path: "/users/me" }), [me, maintenance])?.id).toBe("maintenance")
+    expect(findMatchingStub(makeCtx({ path: "/users/me" }), [me, maintenance], "specificity")?.id).toBe("maintenance")
+    const fallback = { ...makeStub("fallback", [path("template", "/users/{id}")]), priority: -1 }
+    const other = makeStub("other", [])
+    expect(rankStubs([fallback, other]).map((s) => s.id)).toEqual(["other", "fallback"])
+    expect(rankStubs([fallback, other], "specificity").map((s) => s.id)).toEqual(["other", "fallback"])
+  })
+
+  it("keeps definition order between equally specific stubs", () => {
+    const first = makeStub("first", [path("template", "/orders/{id}")])
+    const second = makeStub("second", [path("template", "/orders/{orderId}")])
+    expect(findMatchingStub(makeCtx({ path: "/orders/1" }), [first, second], "specificity")?.id).toBe("first")
+    expect(findMatchingStub(makeCtx({ path: "/orders/1" }), [second, first], "specificity")?.id).toBe("second")
+  })
+})
+
+describe("withPathParams", () => {
+  it("exposes parameters captured by the stub's path template", () => {
+    const ctx = makeCtx({ path: "/users/42/orders/7" })
+    const stub = makeStub("s1", [
+      makePredicate({ field: "path", operator: "template", value: "/users/{userId}/orders/{orderId}" })
+    ])
+    expect(withPathParams(ctx, stub).params).toEqual({ userId: "42", orderId: "7" })
+  })
+
+  it("exposes named groups of a path regex", () => {
+    const ctx = makeCtx({ path: "/legacy/catalog/item_42.do" })
+    const stub = makeStub("s1", [
+      makePredicate({
+        field: "path",
+        operator: "matches",
+        value: "^/legacy/(?<section>[a-z]+)/item_(?<itemId>\\d+)\\.do$"
+      })
+    ])
+    expect(withPathParams(ctx, stub).params).toEqual({ section: "catalog", itemId: "42" })
+  })
+
+  it("leaves the context unchanged when nothing is captured", () => {
+    const ctx = makeCtx({ path: "/users/42" })
+    const stub = makeStub("s1", [makePredicate({ field: "path", operator: "template", value: "/users/*" })])
+    expect(withPathParams(ctx, stub)).toBe(ctx)
+  })
+})
+
+describe("allowedMethods", () => {
+  const route = (id: string, method: string, path: string) =>
+    makeStub(id, [
+      makePredicate({ field: "method", operator: "equals", value: method }),
+      makePredicate({ field: "path", operator: "template", value: path })
+    ])
+  const stubs = [route("get", "GET", "/users/{id}"), route("put", "put", "/users/{id}"), route("list", "GET", "/users")]
+
+  it("lists the methods other stubs accept for the same path", () => {
+    expect(allowedMethods(makeCtx({ method: "DELETE", path: "/users/1" }), stubs)).toEqual(["GET", "PUT"])
+    expect(allowedMethods(makeCtx({ method: "POST", path: "/users" }), stubs)).toEqual(["GET"])
+  })
+
+  it("is empty for unknown paths and stubs without a method", () => {
+    expect(allowedMethods(makeCtx({ method: "DELETE", path: "/orders" }), stubs)).toEqual([])
+    expect(allowedMethods(makeCtx({ method: "DELETE" }), [makeStub("any", [])])).toEqual([])
+  })
+
+  it("still requires the other predicates to hold", () => {
+    const admin = makeStub("admin", [
+      makePredicate({ field: "method", operator: "equals", value: "POST" }),
+      makePredicate({ field: "headers", operator: "exists", value: { authorization: true } })
+    ])
+    expect(allowedMethods(makeCtx({ method: "GET" }), [admin])).toEqual([])
+    expect(allowedMethods(makeCtx({ method: "GET", headers: { authorization: "x" } }), [admin])).toEqual(["POST"])
+  })
+})
+
+describe("bodySize and encoding", () => {
+  const size = (operator: "equals" | "between" | "exists", value: unknown) =>
+    makePredicate({ field: "bodySize", operator, value })
+
+  it("matches byte counts and ranges", () => {
+    const ctx = makeCtx({ body: "x".repeat(100), bodySize: 100 })
+    expect(evaluatePredicate(ctx, size("between", { min: 1, max: 100 }))).toBe(true)
+    expect(evaluatePredicate(ctx, size("between", { max: 99 }))).toBe(false)
+    expect(evaluatePredicate(ctx, size("between", { min: 101 }))).toBe(false)
+    expect(evaluatePredicate(ctx, size("equals", 100))).toBe(true)
+    expect(evaluatePredicate(ctx, size("exists", true))).toBe(true)
+    expect(evaluatePredicate(makeCtx(), size("exists", true))).toBe(false)
+  })
+
+  it("matches the content encoding, identity when absent", () => {
+    const encoding = makePredicate({ field: "encoding", operator: "equals", value: "gzip" })
+    expect(evaluatePredicate(makeCtx({ headers: { "content-encoding": "GZIP" } }), encoding)).toBe(true)
+    expect(evaluatePredicate(makeCtx(), encoding)).toBe(false)
+    expect(evaluatePredicate(makeCtx(), { ...encoding, value: "identity" })).toBe(true)
+  })
+
+  it("decodes stacked encodings and keeps bodies it cannot decode", () => {
+    const text = new TextEncoder().encode("hello")
+    expect(new TextDecoder().decode(decodeBody(gzipSync(deflateSync(text)), "deflate, gzip"))).toBe("hello")
+    expect(decodeBody(text, "gzip")).toBe(text)
+    expect(decodeBody(text, "zstd")).toBe(text)
+  })
+
+  it("refuses to decode a body past the size limit", async () => {
+    const bomb = gzipSync(new Uint8Array(1024 * 1024))
+    expect(decodeBody(bomb, "gzip", 1024)).toBeUndefined()
+    expect(decodeBody(bomb, "gzip")?.byteLength).toBe(1024 * 1024)
+
+    const request = new Request("http://localhost/upload", {
+      method: "POST",
+      headers: { "content-encoding": "gzip", "content-type": "application/json" },
+      body: gzipSync(new Uint8Array(MAX_DECODED_BODY_BYTES + 1))
+    })
+    const ctx = await extractRequestContext(request)
+    expect(isBodyTooLarge(ctx)).toBe(true)
+    expect(ctx.body).toBeUndefined()
+  })
+})
+
+describe("contentType", () => {
+  const contentType = (operator: "equals" | "contains", value: string) =>
+    makePredicate({ field: "contentType", operator, value })
+  const withType = (type: string) => makeCtx({ headers: { "content-type": type } })
+
+  it("compares the media type without parameters, ignoring case", () => {
+    expect(evaluatePredicate(withType("Application/JSON; charset=utf-8"), contentType("equals", "application/json")))
+      .toBe(true)
+    expect(evaluatePredicate(withType("text/xml"), contentType("contains", "xml"))).toBe(true)
+    expect(evaluatePredicate(makeCtx(), contentType("equals", "*/*"))).toBe(false)
+  })
+
+  it("accepts wildcards in equals", () => {
+    expect(matchMediaType("application/problem+json", "application/*+json")).toBe(true)
+    expect(matchMediaType("application/json", "application/*+json")).toBe(false)
+    expect(matchMediaType("text/csv", "text/*")).toBe(true)
+    expect(matchMediaType("application/xml", "*/*")).toBe(true)
+    expect(matchMediaType("application/jsonp", "application/json")).toBe(false)
+  })
+
+  it("routes one path to different stubs by content type", () => {
+    const json = makeStub("json", [contentType("equals", "application/*+json")])
+    const xml = makeStub("xml", [contentType("equals", "application/xml")])
+    expect(findMatchingStub(withType("application/vnd.api+json"), [xml, json])?.id).toBe("json")
+    expect(findMatchingStub(withType("application/xml"), [xml, json])?.id).toBe("xml")
+  })
+})
+
+describe("authorization", () => {
+  const verifies = makePredicate({
+    field: "authorization",
+    operator: "verifies",
+    value: { scheme: "sigv4", keys: { AKIDEXAMPLE: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY" } }
+  })
+  // AWS Signature Version 4 test suite: post-x-www-form-urlencoded
+  const signed = (body: string) =>
+    new Request("http://example.amazonaws.com/", {
+      method: "POST",
+      headers: {
+        "content-type": "application/x-www-form-urlencoded",
+        host: "example.amazonaws.com",
+        "x-amz-date": "20150830T123600Z",
+        authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+          "SignedHeaders=content-type;host;x-amz-date, " +
+          "Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"
+      },
+      body
+    })
+
+  it("verifies signatures over the body as received", async () => {
+    expect(evaluatePredicate(await extractRequestContext(signed("Param1=value1")), verifies)).toBe(true)
+    expect(evaluatePredicate(await extractRequestContext(signed("Param1=value2")), verifies)).toBe(false)
+  })
+
+  it("keeps the received bytes of compressed bodies", async () => {
+    const compressed = gzipSync("hello")
+    const ctx = await extractRequestContext(
+      new Request("http://localhost/", { method: "POST", headers: { "content-encoding": "gzip" }, body: compressed })
+    )
+    expect(ctx.body).toBe("hello")
+    expect(Array.from(receivedBody(ctx))).toEqual(Array.from(compressed))
+    expect(new TextDecoder().decode(receivedBody(makeCtx({ body: { a: 1 } })))).toBe("{\"a\":1}")
+  })
+
+  it("exists when any credentials are sent", () => {
+    const exists = makePredicate({ field: "authorization", operator: "exists" })
+    expect(evaluatePredicate(makeCtx({ headers: { authorization: "Bearer x" } }), exists)).toBe(true)
+    expect(evaluatePredicate(makeCtx({ query: { "X-Amz-Signature": "abc" } }), exists)).toBe(true)
+    expect(evaluatePredicate(makeCtx(), exists)).toBe(false)
+    expect(evaluatePredicate(makeCtx(), verifies)).toBe(false)
+  })
+})
+
+describe("queryString", () => {
+  const queryString = (operator: "equals" | "matches" | "exists", value: unknown) =>
+    makePredicate({ field: "queryString", operator, value })
+
+  it("matches the raw query string", () => {
+    const ctx = makeCtx({ query: { "ids[]": "2" }, queryString: "ids[]=1&ids[]=2" })
+    expect(evaluatePredicate(ctx, queryString("equals", "ids[]=1&ids[]=2"))).toBe(true)
+    expect(evaluatePredicate(ctx, queryString("matches", "^ids\\[\\]=1&ids\\[\\]=2$"))).toBe(true)
+    expect(evaluatePredicate(ctx, queryString("matches", "^ids\\[\\]=2"))).toBe(false)
+    expect(evaluatePredicate(ctx, { ...queryString("equals", "IDS[]=1&IDS[]=2"), caseSensitive: false })).toBe(true)
+  })
+
+  it("exists only for a non-empty query string", () => {
+    expect(evaluatePredicate(makeCtx({ queryString: "a" }), queryString("exists", true))).toBe(true)
+    expect(evaluatePredicate(makeCtx({ queryString: "" }), queryString("exists", true))).toBe(false)
+    expect(evaluatePredicate(makeCtx(), queryString("exists", true))).toBe(false)
+  })
 })
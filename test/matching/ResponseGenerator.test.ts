@@ -1,8 +1,27 @@
 import { it } from "@effect/vitest"
 import * as Effect from "effect/Effect"
 import type { RequestContext } from "imposters/matching/RequestMatcher"
-import { buildResponse, makeResponseState } from "imposters/matching/ResponseGenerator"
-import type { ResponseConfig } from "imposters/schemas/StubSchema"
+import {
+  buildResponse,
+  coversCall,
+  delayMs,
+  exampleIndex,
+  makeResponseState,
+  makeTemplateHelpers,
+  resolveFixture,
+  scheduledWait,
+  toCsv,
+  toStatus,
+  toXml,
+  validateResponse,
+  withinWindow
+} from "imposters/matching/ResponseGenerator"
+import { NonEmptyString } from "imposters/schemas/common"
+import type { ResponseConfig, Stub } from "imposters/schemas/StubSchema"
+import { storeView } from "imposters/server/Stores"
+import { mkdtempSync, writeFileSync } from "node:fs"
+import { tmpdir } from "node:os"
+import * as path from "node:path"
 import { describe, expect } from "vitest"
 
 const makeCtx = (overrides: Partial<RequestContext> = {}): RequestContext => ({
@@ -74,6 +93,151 @@ describe("makeResponseState", () => {
       const afterReset = yield* state.getNextIndex("imp1", "stub1", 3, "sequential")
       expect(afterReset).toBe(0)
     }))
+
+  it.effect("responses with calls answer those calls and the others cycle", () =>
+    Effect.gen(function*() {
+      const state = yield* makeResponseState()
+      const responses = [
+        makeResponse({ status: 404, calls: "1" }),
+        makeResponse({ status: 200 }),
+        makeResponse({ status: 201 }),
+        makeResponse({ status: 503, calls: "4-5" })
+      ]
+      const picked: Array<number> = []
+      for (let i = 0; i < 7; i++) picked.push(yield* state.nextIndex("imp1", "stub1", responses, "sequential"))
+      expect(picked).toEqual([0, 1, 2, 3, 3, 1, 2])
+
+      yield* state.reset("imp1")
+      expect(yield* state.nextIndex("imp1", "stub1", responses, "sequential")).toBe(0)
+
+      const allCounted = [makeResponse({ status: 404, calls: "1" }), makeResponse({ status: 200, calls: "2" })]
+      const counted: Array<number> = []
+      for (let i = 0; i < 3; i++) counted.push(yield* state.nextIndex("imp1", "stub2", allCounted, "sequential"))
+      expect(counted).toEqual([0, 1, 1])
+    }))
+
+  it("coversCall reads single calls, open ranges and closed ranges", () => {
+    expect(coversCall("2", 2)).toBe(true)
+    expect(coversCall("2", 3)).toBe(false)
+    expect(coversCall("2+", 9)).toBe(true)
+    expect(coversCall("2-4", 4)).toBe(true)
+    expect(coversCall("2-4", 5)).toBe(false)
+  })
+
+  it("withinWindow reads daily windows, overnight ones by the day they start", () => {
+    // Friday 2026-01-02 at 00:30 UTC
+    const fridayNight = Date.parse("2026-01-02T00:30:00Z")
+    expect(withinWindow({ from: "00:00", to: "01:00" }, fridayNight)).toBe(true)
+    expect(withinWindow({ from: "00:00", to: "00:30" }, fridayNight)).toBe(false)
+    expect(withinWindow({ from: "23:00", to: "01:00", days: ["thu"] }, fridayNight)).toBe(true)
+    expect(withinWindow({ from: "23:00", to: "01:00", days: ["fri"] }, fridayNight)).toBe(false)
+    // 00:30 UTC is 19:30 the evening before in New York
+    expect(withinWindow({ from: "19:00", to: "20:00", timezone: "America/New_York" }, fridayNight)).toBe(true)
+  })
+
+  it.effect("a response whose window holds the imposter's time answers ahead of the others", () =>
+    Effect.gen(function*() {
+      const state = yield* makeResponseState()
+      const responses = [
+        makeResponse({ status: 200 }),
+        makeResponse({ status: 503, during: { from: "00:00", to: "01:00" } }),
+        makeResponse({ status: 201 })
+      ]
+      const maintenance = Date.parse("2026-01-02T00:15:00Z")
+      const daytime = Date.parse("2026-01-02T12:00:00Z")
+      const picked: Array<number> = []
+      for (const now of [daytime, maintenance, daytime, daytime]) {
+        picked.push(yield* state.nextIndex("imp1", "stub1", responses, "sequential", now))
+      }
+      expect(picked).toEqual([0, 1, 2, 0])
+
+      const allTimed = [makeResponse({ status: 503, during: { from: "00:00", to: "01:00" } })]
+      expect(yield* state.nextIndex("imp1", "stub2", allTimed, "sequential", daytime)).toBe(0)
+    }))
+
+  it.live("reset restarts the scenario clock", () =>
+    Effect.gen(function*() {
+      const state = yield* makeResponseState()
+      const started = yield* state.scenarioStart
+      yield* Effect.sleep("5 millis")
+      yield* state.reset("imp1")
+      expect(yield* state.scenarioStart).toBeGreaterThan(started)
+    }))
+})
+
+describe("exampleIndex", () => {
+  const responses: ReadonlyArray<ResponseConfig> = [
+    { status: 200 },
+    { example: NonEmptyString.make("rate-limited"), status: 429 },
+    { example: NonEmptyString.make("rate-limited"), status: 503 }
+  ]
+
+  it("finds the first response with the name", () => {
+    expect(exampleIndex(responses, "rate-limited")).toBe(1)
+  })
+
+  it("finds nothing for an unknown or missing name", () => {
+    expect(exampleIndex(responses, "not-found")).toBeUndefined()
+    expect(exampleIndex(responses, undefined)).toBeUndefined()
+  })
+})
+
+describe("delayMs", () => {
+  it("waits a fixed delay as given", () => {
+    expect(delayMs(250)).toBe(250)
+  })
+
+  it("picks a whole number from min to max, both included", () => {
+    expect(delayMs({ min: 100, max: 300 }, () => 0)).toBe(100)
+    expect(delayMs({ min: 100, max: 300 }, () => 0.5)).toBe(200)
+    expect(delayMs({ min: 100, max: 300 }, () => 0.9999)).toBe(300)
+    expect(delayMs({ min: 50, max: 50 })).toBe(50)
+  })
+
+  // Random numbers in turn; 1 - e^-0.5 then 0 makes the normal draw exactly 1
+  const sequence = (...values: Array<number>) => () => values.shift() ?? 0
+  const ONE_SIGMA = 1 - Math.exp(-0.5)
+
+  it("draws normal latency around the mean, kept at or above zero", () => {
+    expect(delayMs({ distribution: "normal", mean: 100, stddev: 20 }, sequence(ONE_SIGMA, 0))).toBe(120)
+    expect(delayMs({ distribution: "normal", mean: 100, stddev: 20 }, sequence(ONE_SIGMA, 0.5))).toBe(80)
+    expect(delayMs({ distribution: "normal", mean: 10, stddev: 100 }, sequence(ONE_SIGMA, 0.5))).toBe(0)
+  })
+
+  it("draws log-normal latency with the given mean", () => {
+    const draws = Array.from({ length: 20000 }, () => delayMs({ distribution: "lognormal", mean: 100, stddev: 50 }))
+    const mean = draws.reduce((sum, d) => sum + d, 0) / draws.length
+    expect(mean).toBeGreaterThan(95)
+    expect(mean).toBeLessThan(105)
+    expect(Math.min(...draws)).toBeGreaterThan(0)
+  })
+
+  it("sends draws past the tail percentile after the tail delay", () => {
+    const delay = { distribution: "uniform", min: 10, max: 20, tail: { percentile: 99, delay: 3000 } } as const
+    expect(delayMs(delay, sequence(0.995))).toBe(3000)
+    expect(delayMs(delay, sequence(0.5, 0))).toBe(10)
+  })
+})
+
+describe("scheduledWait", () => {
+  it("waits until `after` ms from the scenario start", () => {
+    expect(scheduledWait({ after: 500 }, 200, 0)).toBe(300)
+    expect(scheduledWait({ after: 500 }, 800, 0)).toBe(0)
+  })
+
+  it("spreads `after` by up to `jitter` either way", () => {
+    expect(scheduledWait({ after: 500, jitter: 100 }, 0, 0, () => 0)).toBe(400)
+    expect(scheduledWait({ after: 500, jitter: 100 }, 0, 0, () => 1)).toBe(600)
+  })
+
+  it("counts `after` from the scenario start whatever the imposter's clock says", () => {
+    expect(scheduledWait({ after: 500 }, 200, Date.parse("2020-01-01T00:00:00Z"))).toBe(300)
+  })
+
+  it("waits until an absolute time", () => {
+    const at = "2026-01-01T00:00:01.000Z"
+    expect(scheduledWait({ at }, 0, Date.parse("2026-01-01T00:00:00.250Z"))).toBe(750)
+  })
 })
 
 describe("buildResponse", () => {
@@ -120,3 +284,293 @@ describe("buildResponse", () => {
     expect(resp.headers.get("x-method")).toBe("POST")
   })
 })
+
+describe("buildResponse - bodyType", () => {
+  it("serves HTML and text with their content types", async () => {
+    const html = await buildResponse(makeResponse({ body: "<h1>{{request.path}}</h1>", bodyType: "html" }), makeCtx())
+    expect(html.headers.get("content-type")).toBe("text/html; charset=utf-8")
+    expect(await html.text()).toBe("<h1>/test</h1>")
+    const text = await buildResponse(makeResponse({ body: { ok: true }, bodyType: "text" }), makeCtx())
+    expect(text.headers.get("content-type")).toBe("text/plain; charset=utf-8")
+    expect(await text.text()).toBe("{\"ok\":true}")
+  })
+
+  it("encodes strings as JSON when asked to", async () => {
+    const resp = await buildResponse(makeResponse({ body: "hello", bodyType: "json" }), makeCtx())
+    expect(resp.headers.get("content-type")).toBe("application/json")
+    expect(await resp.text()).toBe("\"hello\"")
+  })
+
+  it("renders arrays as CSV", async () => {
+    const body = [{ id: 1, name: "Alice" }, { id: 2, name: "Bob, Jr." }]
+    const resp = await buildResponse(makeResponse({ body, bodyType: "csv" }), makeCtx())
+    expect(resp.headers.get("content-type")).toBe("text/csv; charset=utf-8")
+    expect(await resp.text()).toBe("id,name\r\n1,Alice\r\n2,\"Bob, Jr.\"\r\n")
+  })
+
+  it("serializes structured bodies to XML, escaping substituted values", async () => {
+    const order = { "@id": "{{request.query.id}}", item: [{ sku: "A" }, { sku: "B" }], note: "{{request.query.q}}" }
+    const body = { order }
+    const ctx = makeCtx({ query: { id: "42", q: "fish & chips" } })
+    const resp = await buildResponse(makeResponse({ body, bodyType: "xml" }), ctx)
+    expect(resp.headers.get("content-type")).toBe("application/xml")
+    expect(await resp.text()).toBe(
+      "<?xml version=\"1.0\" encoding=\"UTF-8\"?>"
+        + "<order id=\"42\"><item><sku>A</sku></item><item><sku>B</sku></item><note>fish &amp; chips</note></order>"
+    )
+  })
+
+  it("passes XML strings through", async () => {
+    const config = makeResponse({ body: "<ok path=\"{{request.path}}\"/>", bodyType: "xml" })
+    const resp = await buildResponse(config, makeCtx())
+    expect(await resp.text()).toBe("<ok path=\"/test\"/>")
+  })
+
+  it("serves raw bodies as given, with no content type of their own", async () => {
+    const resp = await buildResponse(makeResponse({ body: "a|b|c", bodyType: "raw" }), makeCtx())
+    expect(resp.headers.get("content-type")).toBeNull()
+    expect(await resp.text()).toBe("a|b|c")
+  })
+
+  it("leaves a content-type header alone", async () => {
+    const headers = { "content-type": "application/vnd.ms-excel" }
+    const config = makeResponse({ headers, body: [[1]], bodyType: "csv" })
+    expect((await buildResponse(config, makeCtx())).headers.get("content-type")).toBe("application/vnd.ms-excel")
+  })
+})
+
+describe("toXml", () => {
+  it("wraps bodies without a single root element in <response>", () => {
+    expect(toXml({ a: 1, b: null })).toBe("<?xml version=\"1.0\" encoding=\"UTF-8\"?><response><a>1</a><b/></response>")
+    expect(toXml([1, 2])).toBe(
+      "<?xml version=\"1.0\" encoding=\"UTF-8\"?><response><item>1</item><item>2</item></response>"
+    )
+  })
+
+  it("writes #text as the element's text next to its attributes", () => {
+    expect(toXml({ name: { "@lang": "en", "#text": "a<b" } })).toBe(
+      "<?xml version=\"1.0\" encoding=\"UTF-8\"?><name lang=\"en\">a&lt;b</name>"
+    )
+  })
+})
+
+describe("buildResponse - bodyFile", () => {
+  const fixtures = mkdtempSync(path.join(tmpdir(), "imposters-fixtures-"))
+  writeFileSync(path.join(fixtures, "orders.json"), "{\"orders\":[1,2,3]}")
+  writeFileSync(path.join(fixtures, "report.bin"), new Uint8Array([0, 255, 1]))
+
+  it("serves the file verbatim with a Content-Type from its extension", async () => {
+    const resp = await buildResponse(makeResponse({ bodyFile: "orders.json" }), makeCtx(), {}, fixtures)
+    expect(resp.headers.get("content-type")).toBe("application/json")
+    expect(await resp.text()).toBe("{\"orders\":[1,2,3]}")
+    const binary = await buildResponse(makeResponse({ bodyFile: "report.bin" }), makeCtx(), {}, fixtures)
+    expect(binary.headers.get("content-type")).toBe("application/octet-stream")
+    expect([...new Uint8Array(await binary.arrayBuffer())]).toEqual([0, 255, 1])
+  })
+
+  it("takes the Content-Type from bodyType or headers when set", async () => {
+    const asText = makeResponse({ bodyFile: "orders.json", bodyType: "text" })
+    const text = await buildResponse(asText, makeCtx(), {}, fixtures)
+    expect(text.headers.get("content-type")).toBe("text/plain; charset=utf-8")
+    const config = makeResponse({ bodyFile: "report.bin", headers: { "content-type": "application/x-report" } })
+    const custom = await buildResponse(config, makeCtx(), {}, fixtures)
+    expect(custom.headers.get("content-type")).toBe("application/x-report")
+  })
+
+  it("answers 500 naming a missing file", async () => {
+    const resp = await buildResponse(makeResponse({ bodyFile: "missing.json" }), makeCtx(), {}, fixtures)
+    expect(resp.status).toBe(500)
+    expect(await resp.json()).toEqual({ error: "Body file not found", bodyFile: "missing.json" })
+  })
+
+  it("never resolves outside the fixtures directory", () => {
+    expect(resolveFixture("/srv/fixtures", "a/b.json")).toBe(path.resolve("/srv/fixtures/a/b.json"))
+    expect(resolveFixture("/srv/fixtures", "../etc/passwd")).toBeUndefined()
+    expect(resolveFixture("/srv/fixtures", "/etc/passwd")).toBeUndefined()
+  })
+})
+
+describe("toCsv", () => {
+  it("writes arrays as rows and quotes cells that need it", () => {
+    expect(toCsv([["a", "b"], [1, "say \"hi\""], ["line\nbreak", null]]))
+      .toBe("a,b\r\n1,\"say \"\"hi\"\"\"\r\n\"line\nbreak\",\r\n")
+  })
+
+  it("uses every key of object rows as the header", () => {
+    expect(toCsv([{ a: 1 }, { b: 2 }])).toBe("a,b\r\n1,\r\n,2\r\n")
+    expect(toCsv([])).toBe("")
+  })
+})
+
+describe("buildResponse - templated status", () => {
+  it("takes the status from the request", async () => {
+    const fromQuery = makeResponse({ status: "{{request.query.status}}", body: { ok: false } })
+    expect((await buildResponse(fromQuery, makeCtx({ query: { status: "418" } }))).status).toBe(418)
+    const fromHeader = makeResponse({ status: "${$number(request.headers.\"x-status\") + 1}" })
+    expect((await buildResponse(fromHeader, makeCtx({ headers: { "x-status": "502" } }))).status).toBe(503)
+  })
+
+  it("answers 500 when the status doesn't render to a status code", async () => {
+    const config = makeResponse({ status: "{{request.query.status}}" })
+    const resp = await buildResponse(config, makeCtx({ query: { status: "abc" } }))
+    expect(resp.status).toBe(500)
+    expect(await resp.json()).toEqual({ error: "Status is not a valid status code", status: "abc" })
+    expect(toStatus("99")).toBeUndefined()
+    expect(toStatus(" 201 ")).toBe(201)
+  })
+
+  it("drops the body for statuses that can't carry one", async () => {
+    const config = makeResponse({ status: "{{request.query.status}}", body: { ok: true } })
+    const resp = await buildResponse(config, makeCtx({ query: { status: "204" } }))
+    expect(resp.status).toBe(204)
+    expect(await resp.text()).toBe("")
+  })
+})
+
+describe("buildResponse - sse", () => {
+  it("streams templated events as text/event-stream", async () => {
+    const resp = await buildResponse(
+      makeResponse({
+        type: "sse",
+        events: [{ event: "greeting", data: { to: "{{request.query.name}}" } }, { id: "2", data: "bye", delay: 10 }]
+      }),
+      makeCtx({ query: { name: "Ada" } })
+    )
+    expect(resp.headers.get("content-type")).toBe("text/event-stream")
+    expect(resp.headers.get("cache-control")).toBe("no-cache")
+    expect(await resp.text()).toBe("event: greeting\ndata: {\"to\":\"Ada\"}\n\nid: 2\ndata: bye\n\n")
+  })
+})
+
+describe("buildResponse - bodySchema", () => {
+  const bodySchema = {
+    schema: { type: "object", properties: { id: { type: "string", example: "{{request.query.id}}" } } },
+    arraySize: { min: 1, max: 3 }
+  }
+
+  it("generates a JSON body from the schema and applies templates", async () => {
+    const resp = await buildResponse(makeResponse({ bodySchema }), makeCtx({ query: { id: "abc" } }))
+    expect(resp.headers.get("content-type")).toBe("application/json")
+    expect(JSON.parse(await resp.text())).toEqual({ id: "abc" })
+  })
+
+  it("uses body when both are set", async () => {
+    const resp = await buildResponse(makeResponse({ body: { fixed: true }, bodySchema }), makeCtx())
+    expect(JSON.parse(await resp.text())).toEqual({ fixed: true })
+  })
+})
+
+describe("buildResponse - media", () => {
+  it("serves a PNG with its content type", async () => {
+    const res = await buildResponse(
+      makeResponse({ media: { type: "image", format: "png", width: 4, height: 3, color: "#336699" } }),
+      makeCtx()
+    )
+    expect(res.headers.get("content-type")).toBe("image/png")
+    const bytes = new Uint8Array(await res.arrayBuffer())
+    expect(Array.from(bytes.subarray(1, 4), (b) => String.fromCharCode(b)).join("")).toBe("PNG")
+  })
+
+  it("templates the text of a PDF", async () => {
+    const res = await buildResponse(
+      makeResponse({ media: { type: "pdf", text: "Invoice {{request.path}}", pages: 1, size: "a4" } }),
+      makeCtx({ path: "/invoices/42" })
+    )
+    expect(res.headers.get("content-type")).toBe("application/pdf")
+    expect(await res.text()).toContain("(Invoice /invoices/42) '")
+  })
+})
+
+describe("validateResponse", () => {
+  const schema = { type: "object", required: ["id"], properties: { id: { type: "integer" } } }
+
+  it("passes a conformant response through unchanged", async () => {
+    const original = new Response(JSON.stringify({ id: 1 }), {
+      status: 201,
+      headers: { "content-type": "application/json", "x-trace": "t1" }
+    })
+    const { response, violations } = await validateResponse(original, schema)
+    expect(violations).toEqual([])
+    expect(response.status).toBe(201)
+    expect(response.headers.get("x-trace")).toBe("t1")
+    expect(await response.json()).toEqual({ id: 1 })
+  })
+
+  it("replaces a non-conformant response with a 500 listing the violations", async () => {
+    const original = new Response(JSON.stringify({ id: "one" }), { headers: { "content-type": "application/json" } })
+    const { response, violations } = await validateResponse(original, schema)
+    expect(violations).toEqual(["$.id: expected integer, got string"])
+    expect(response.status).toBe(500)
+    expect(await response.json()).toEqual({ error: "Response does not match its schema", violations })
+  })
+
+  it("reports JSON bodies that do not parse", async () => {
+    const original = new Response("{\"id\":", { headers: { "content-type": "application/json" } })
+    expect((await validateResponse(original, schema)).violations).toEqual(["$: body is not valid JSON"])
+  })
+
+  it("validates other bodies as a string", async () => {
+    const original = new Response("pong", { headers: { "content-type": "text/plain" } })
+    expect((await validateResponse(original, { type: "string", maxLength: 3 })).violations)
+      .toEqual(["$: longer than 3 characters"])
+  })
+})
+
+describe("buildResponse - transforms", () => {
+  it("applies transforms after templating", async () => {
+    const config = makeResponse({
+      body: { user: { name: "{{request.query.name}}", token: "abc" } },
+      transforms: [{ op: "mask", path: "user.token", with: "xxx" }, { op: "set", path: "mocked", value: true }]
+    })
+    const resp = await buildResponse(config, makeCtx({ query: { name: "Alice" } }))
+    const parsed = JSON.parse(await resp.text())
+    expect(parsed).toEqual({ user: { name: "Alice", token: "xxx" }, mocked: true })
+  })
+})
+
+describe("makeTemplateHelpers - $route", () => {
+  const makeStub = (id: string, path: string, ...responses: [ResponseConfig, ...Array<ResponseConfig>]): Stub => ({
+    id: NonEmptyString.make(id),
+    predicates: [{ field: "path", operator: "template", value: path, caseSensitive: true }],
+    responses,
+    responseMode: "sequential"
+  })
+
+  const userBody = { id: "{{request.params.id}}", name: "User {{request.params.id}}" }
+  const profileBody = { user: "${$route('/users/' & request.params.id)}", motd: "${$route('/motd')}" }
+  const stubs: ReadonlyArray<Stub> = [
+    makeStub("user", "/users/{id}", makeResponse({ body: userBody })),
+    makeStub("motd", "/motd", makeResponse({ body: "hello" }), makeResponse({ body: "second" })),
+    makeStub("loop", "/loop", makeResponse({ body: { next: "${$route('/loop')}" } }))
+  ]
+
+  it("embeds the rendered body of another route", async () => {
+    const ctx = makeCtx({ path: "/profiles/7", params: { id: "7" } })
+    const resp = await buildResponse(makeResponse({ body: profileBody }), ctx, makeTemplateHelpers(stubs, {}))
+    expect(JSON.parse(await resp.text())).toEqual({ user: { id: "7", name: "User 7" }, motd: "hello" })
+  })
+
+  it("always renders the first response without advancing the stub", async () => {
+    const helpers = makeTemplateHelpers(stubs, {})
+    expect(await helpers.route!("/motd", "GET")).toBe("hello")
+    expect(await helpers.route!("/motd", "GET")).toBe("hello")
+  })
+
+  it("returns undefined when no stub answers the route", async () => {
+    expect(await makeTemplateHelpers(stubs, {}).route!("/missing", "GET")).toBeUndefined()
+  })
+
+  it("stops routes that include themselves", async () => {
+    const body = await makeTemplateHelpers(stubs, {}).route!("/loop", "GET")
+    expect(JSON.stringify(body)).toContain("${$route('/loop')}")
+  })
+
+  it("lets routed responses read the store but not write it", async () => {
+    const store = storeView({ user: "ann" })
+    const body = "{{store.set \"visited\" true}}{{store.get \"user\"}}"
+    const routed = [makeStub("visit", "/visit", makeResponse({ body }))]
+    expect(await makeTemplateHelpers(routed, {}, { store }).route!("/visit", "GET")).toBe("ann")
+    expect(store.values()).toEqual({ user: "ann" })
+    expect(store.writes()).toEqual({})
+  })
+})
@@ -0,0 +1,77 @@
+import { applyTransforms, transformResponse } from "imposters/matching/ResponseTransforms"
+import { describe, expect, it } from "vitest"
+
+describe("applyTransforms", () => {
+  it("removes fields, including wildcard array items", () => {
+    const body = { id: 1, secret: "s", items: [{ a: 1, internal: true }, { a: 2, internal: false }] }
+    const result = applyTransforms(body, [
+      { op: "remove", path: "secret" },
+      { op: "remove", path: "items.*.internal" }
+    ])
+    expect(result).toEqual({ id: 1, items: [{ a: 1 }, { a: 2 }] })
+  })
+
+  it("removes array elements by index", () => {
+    const result = applyTransforms({ list: [1, 2, 3] }, [{ op: "remove", path: "list.1" }])
+    expect(result).toEqual({ list: [1, 3] })
+  })
+
+  it("masks existing values only", () => {
+    const result = applyTransforms({ user: { email: "a@b.com" } }, [
+      { op: "mask", path: "user.email", with: "****" },
+      { op: "mask", path: "user.phone", with: "****" }
+    ])
+    expect(result).toEqual({ user: { email: "****" } })
+  })
+
+  it("renames keys", () => {
+    const result = applyTransforms({ user_name: "alice", other: 1 }, [{ op: "rename", path: "user_name", to: "name" }])
+    expect(result).toEqual({ name: "alice", other: 1 })
+  })
+
+  it("sets values and creates missing parents", () => {
+    const result = applyTransforms({ a: 1 }, [{ op: "set", path: "meta.source", value: "mock" }])
+    expect(result).toEqual({ a: 1, meta: { source: "mock" } })
+  })
+
+  it("never follows or sets prototype keys", () => {
+    const result = applyTransforms({ a: 1 }, [
+      { op: "set", path: "__proto__.polluted", value: true },
+      { op: "set", path: "constructor.prototype.polluted", value: true },
+      { op: "set", path: "x.__proto__", value: { polluted: true } },
+      { op: "rename", path: "a", to: "__proto__" }
+    ])
+    expect(result).toEqual({ a: 1, x: {} })
+    expect(Object.getPrototypeOf(result)).toBe(Object.prototype)
+    expect(({} as Record<string, unknown>).polluted).toBeUndefined()
+  })
+
+  it("does not mutate the input", () => {
+    const body = { secret: "s" }
+    applyTransforms(body, [{ op: "remove", path: "secret" }])
+    expect(body).toEqual({ secret: "s" })
+  })
+
+  it("returns non-object bodies unchanged", () => {
+    expect(applyTransforms("plain", [{ op: "remove", path: "x" }])).toBe("plain")
+  })
+})
+
+describe("transformResponse", () => {
+  it("rewrites JSON responses and keeps status and headers", async () => {
+    const response = new Response(JSON.stringify({ token: "t", ok: true }), {
+      status: 201,
+      headers: { "content-type": "application/json", "x-upstream": "1" }
+    })
+    const result = await transformResponse(response, [{ op: "remove", path: "token" }])
+    expect(result.status).toBe(201)
+    expect(result.headers.get("x-upstream")).toBe("1")
+    expect(await result.json()).toEqual({ ok: true })
+  })
+
+  it("passes non-JSON responses through", async () => {
+    const response = new Response("hello", { headers: { "content-type": "text/plain" } })
+    const result = await transformResponse(response, [{ op: "remove", path: "token" }])
+    expect(await result.text()).toBe("hello")
+  })
+})
@@ -0,0 +1,55 @@
+import { scrubBody, scrubHeaders } from "imposters/matching/Scrubber"
+import type { ScrubRule } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const rule = (overrides: Partial<ScrubRule>): ScrubRule => ({ replacement: "[REDACTED]", ...overrides })
+
+describe("scrubBody", () => {
+  it("replaces the value at a path", () => {
+    const result = scrubBody({ user: { ssn: "123-45-6789", name: "Ann" } }, [rule({ path: "user.ssn" })])
+    expect(result).toEqual({ user: { ssn: "[REDACTED]", name: "Ann" } })
+  })
+
+  it("accepts JSONPath-style $. prefixes and wildcards", () => {
+    const result = scrubBody({ items: [{ token: "a" }, { token: "b" }] }, [rule({ path: "$.items.*.token" })])
+    expect(result).toEqual({ items: [{ token: "[REDACTED]" }, { token: "[REDACTED]" }] })
+  })
+
+  it("redacts regex matches in every string", () => {
+    const body = { contact: "mail ann@example.com now", nested: ["bob@example.org"] }
+    const result = scrubBody(body, [rule({ preset: "email", replacement: "<email>" })])
+    expect(result).toEqual({ contact: "mail <email> now", nested: ["<email>"] })
+  })
+
+  it("limits a pattern to a path when both are set", () => {
+    const body = { card: "4111 1111 1111 1111", note: "4111 1111 1111 1111" }
+    const result = scrubBody(body, [rule({ path: "card", preset: "creditCard" })])
+    expect(result).toEqual({ card: "[REDACTED]", note: "4111 1111 1111 1111" })
+  })
+
+  it("scrubs plain string bodies with custom patterns", () => {
+    expect(scrubBody("id=abc123", [rule({ pattern: "abc\\d+" })])).toBe("id=[REDACTED]")
+  })
+
+  it("does not modify the input", () => {
+    const body = { secret: "s" }
+    scrubBody(body, [rule({ path: "secret" })])
+    expect(body).toEqual({ secret: "s" })
+  })
+})
+
+describe("scrubHeaders", () => {
+  it("replaces named headers case-insensitively", () => {
+    const result = scrubHeaders({ "Set-Cookie": "session=abc", "x-id": "1" }, [rule({ header: "set-cookie" })])
+    expect(result).toEqual({ "Set-Cookie": "[REDACTED]", "x-id": "1" })
+  })
+
+  it("applies body-less patterns to all header values", () => {
+    const result = scrubHeaders({ "x-auth": "Bearer abc.def" }, [rule({ preset: "bearerToken" })])
+    expect(result).toEqual({ "x-auth": "Bearer [REDACTED]" })
+  })
+
+  it("ignores path rules", () => {
+    expect(scrubHeaders({ token: "t" }, [rule({ path: "token" })])).toEqual({ token: "t" })
+  })
+})
@@ -1,5 +1,6 @@
 import type { RequestContext } from "imposters/matching/RequestMatcher"
-import { applyTemplates, flattenRequestContext } from "imposters/matching/TemplateEngine"
+import { applyTemplates, flattenRequestContext, formatTime } from "imposters/matching/TemplateEngine"
+import { storeView } from "imposters/server/Stores"
 import { describe, expect, it } from "vitest"
 
 const makeCtx = (overrides: Partial<RequestContext> = {}): RequestContext => ({
@@ -30,6 +31,16 @@ describe("flattenRequestContext", () => {
     expect(result["request.query.name"]).toBe("Alice")
   })
 
+  it("flattens form fields", () => {
+    const result = flattenRequestContext(makeCtx({ form: { grant_type: "client_credentials" } }))
+    expect(result["request.form.grant_type"]).toBe("client_credentials")
+  })
+
+  it("flattens path params", () => {
+    const result = flattenRequestContext(makeCtx({ params: { id: "123" } }))
+    expect(result["request.params.id"]).toBe("123")
+  })
+
   it("flattens simple body", () => {
     const ctx = makeCtx({ body: { name: "Alice", age: 30 } })
     const result = flattenRequestContext(ctx)
@@ -79,6 +90,89 @@ describe("applyTemplates", () => {
     expect(await applyTemplates(ctx, data)).toEqual({ message: "Hello, Alice!", path: "/users/123" })
   })
 
+  it("substitutes headers by name in any case", async () => {
+    const ctx = makeCtx({ headers: { "x-request-id": "req-42", authorization: "Bearer abc" } })
+    const data = { correlationId: "{{header.X-Request-Id}}", auth: "{{request.headers.Authorization}}" }
+    expect(await applyTemplates(ctx, data)).toEqual({ correlationId: "req-42", auth: "Bearer abc" })
+  })
+
+  it("leaves headers the request doesn't have as written", async () => {
+    expect(await applyTemplates(makeCtx(), "id={{header.X-Request-Id}}")).toBe("id={{header.X-Request-Id}}")
+  })
+
+  it("substitutes {{body.<path>}} with fields of the request body", async () => {
+    const ctx = makeCtx({ method: "POST", body: { user: { name: "Alice", tags: ["a", "b"] }, count: 2 } })
+    expect(await applyTemplates(ctx, "Created {{body.user.name}} ({{body.count}}, {{body.user.tags}})"))
+      .toBe("Created Alice (2, [\"a\",\"b\"])")
+    expect(await applyTemplates(ctx, "{{body.user.tags.1}}")).toBe("b")
+  })
+
+  it("keeps the field's type when {{body.<path>}} is the whole string", async () => {
+    const ctx = makeCtx({ method: "POST", body: { name: "Alice", age: 30, tags: ["a"], admin: false } })
+    const data = {
+      id: 1001,
+      name: "{{body.name}}",
+      age: "{{body.age}}",
+      tags: "{{body.tags}}",
+      admin: "{{body.admin}}"
+    }
+    expect(await applyTemplates(ctx, data)).toEqual({ id: 1001, name: "Alice", age: 30, tags: ["a"], admin: false })
+    expect(await applyTemplates(ctx, { entity: "{{body}}" })).toEqual({ entity: ctx.body })
+  })
+
+  it("reads {{body.<name>}} from form bodies", async () => {
+    const ctx = makeCtx({ method: "POST", body: "name=Alice", form: { name: "Alice" } })
+    expect(await applyTemplates(ctx, "{{body.name}}")).toBe("Alice")
+  })
+
+  it("leaves body fields the request doesn't have as written", async () => {
+    const ctx = makeCtx({ body: { name: "Alice" } })
+    expect(await applyTemplates(ctx, "{{body.email}}")).toBe("{{body.email}}")
+    expect(await applyTemplates(ctx, "{{body.name.first}} {{body.constructor}}"))
+      .toBe("{{body.name.first}} {{body.constructor}}")
+  })
+
+  it("renders {{uuid}} afresh for every response", async () => {
+    const first = await applyTemplates(makeCtx(), { id: "{{uuid}}" }) as { id: string }
+    const second = await applyTemplates(makeCtx(), { id: "{{uuid}}" }) as { id: string }
+    expect(first.id).toMatch(/^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$/)
+    expect(second.id).not.toBe(first.id)
+  })
+
+  it("renders {{now}} in the layout asked for", async () => {
+    const data = {
+      at: "{{now}}",
+      http: "{{now \"RFC1123\"}}",
+      epoch: "{{now \"unix\"}}",
+      day: "{{now \"YYYY-MM-DD\"}}"
+    }
+    const result = await applyTemplates(makeCtx(), data) as Record<string, unknown>
+    expect(result.at).toMatch(/^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$/)
+    expect(result.http).toMatch(/ GMT$/)
+    expect(typeof result.epoch).toBe("number")
+    expect(Math.abs((result.epoch as number) - Date.now() / 1000)).toBeLessThan(5)
+    expect(result.day).toMatch(/^\d{4}-\d{2}-\d{2}$/)
+  })
+
+  it("renders {{now}} from the imposter's clock when it is set", async () => {
+    const now = () => Date.parse("2026-03-01T00:30:00Z")
+    expect(await applyTemplates(makeCtx(), "{{now}}", { now })).toBe("2026-03-01T00:30:00Z")
+  })
+
+  it("renders {{randomInt min max}} within both bounds", async () => {
+    for (let i = 0; i < 50; i++) {
+      const n = await applyTemplates(makeCtx(), "{{randomInt 1 3}}")
+      expect([1, 2, 3]).toContain(n)
+    }
+    expect(await applyTemplates(makeCtx(), "id-{{randomInt 7 7}}")).toBe("id-7")
+    expect(await applyTemplates(makeCtx(), "{{randomInt 9 1}}")).toBe("{{randomInt 9 1}}")
+    expect(await applyTemplates(makeCtx(), "{{randomInt one 2}}")).toBe("{{randomInt one 2}}")
+    expect(await applyTemplates(makeCtx(), "{{randomInt 0 281474976710655}}")).toBe("{{randomInt 0 281474976710655}}")
+    expect(await applyTemplates(makeCtx(), "{{randomInt 9007199254740990 9007199254740991}}"))
+      .toBe("{{randomInt 9007199254740990 9007199254740991}}")
+    expect(Number(await applyTemplates(makeCtx(), "{{randomInt 0 281474976710654}}"))).toBeLessThan(2 ** 48)
+  })
+
   it("substitutes in arrays", async () => {
     const ctx = makeCtx({ method: "GET" })
     const data = ["{{request.method}}", "static"]
@@ -130,9 +224,103 @@ describe("applyTemplates", () => {
     expect(await applyTemplates(ctx, "${$$$bad}")).toBe("${$$$bad}")
   })
 
+  it("substitutes shared variables, keeping the type of a whole-string token", async () => {
+    const helpers = { variables: { baseUrl: "https://api.test", beta: true } }
+    const data = { next: "{{vars.baseUrl}}/users?page=2", beta: "{{vars.beta}}", missing: "{{vars.nope}}" }
+    expect(await applyTemplates(makeCtx(), data, helpers))
+      .toEqual({ next: "https://api.test/users?page=2", beta: true, missing: "{{vars.nope}}" })
+    expect(await applyTemplates(makeCtx(), "${$vars.baseUrl & request.path}", helpers))
+      .toBe("https://api.test/users/123")
+  })
+
+  it("reads and writes the imposter's store, seeing earlier writes", async () => {
+    const store = storeView({ visits: 2 })
+    const ctx = makeCtx({ body: { id: 42, name: "Ada" } })
+    const data = [
+      "{{store.set \"lastOrderId\" {{body.id}}}}{{store.set \"name\" \"{{body.name}}\"}}",
+      "{{store.get \"lastOrderId\"}}",
+      "order {{store.get \"lastOrderId\"}} for {{store.get \"name\"}} after {{store.get \"visits\"}} visits",
+      "${$store.name}",
+      "{{store.set \"visits\"}}"
+    ]
+    expect(await applyTemplates(ctx, data, { store })).toEqual(["", 42, "order 42 for Ada after 2 visits", "Ada", ""])
+    expect(store.writes()).toEqual({ lastOrderId: 42, name: "Ada", visits: null })
+  })
+
+  it("echoes tokens and expressions sent in the request as text", async () => {
+    const store = storeView({ secret: "s3cret" })
+    const name = "{{store.set admin true}}{{store.get secret}}${$store.secret}"
+    const ctx = makeCtx({ body: { name }, headers: { "x-name": "{{uuid}}" } })
+    const data = {
+      name: "{{body.name}}",
+      header: "{{header.x-name}}",
+      saved: "{{store.set \"name\" \"{{body.name}}\"}}"
+    }
+    expect(await applyTemplates(ctx, data, { store })).toEqual({ name, header: "{{uuid}}", saved: "" })
+    expect(store.writes()).toEqual({ name })
+    expect(await applyTemplates(ctx, "{{store.get \"name\"}}", { store })).toBe(name)
+  })
+
+  it("repeats an item with its index, rendering each separately", async () => {
+    const data = { users: { $repeat: 3, $item: { id: "{{index}}", name: "user-{{index}}", key: "{{uuid}}" } } }
+    const { users } = await applyTemplates(makeCtx(), data) as { users: Array<Record<string, unknown>> }
+    expect(users.map(({ id, name }) => ({ id, name }))).toEqual([
+      { id: 0, name: "user-0" },
+      { id: 1, name: "user-1" },
+      { id: 2, name: "user-2" }
+    ])
+    expect(new Set(users.map((u) => u.key)).size).toBe(3)
+  })
+
+  it("takes counts and ranges from the request", async () => {
+    const ctx = makeCtx({ query: { limit: "2", page: "3" } })
+    expect(await applyTemplates(ctx, { $repeat: "{{request.query.limit}}", $item: "${{{index}} * 10}" }))
+      .toEqual([0, 10])
+    expect(await applyTemplates(ctx, {
+      $range: ["${($number(request.query.page) - 1) * 2 + 1}", "${$number(request.query.page) * 2}"],
+      $item: { id: "{{index}}" }
+    })).toEqual([{ id: 5 }, { id: 6 }])
+  })
+
+  it("gives nested repeats their own index", async () => {
+    const data = { $range: [1, 2], $item: { row: "{{index}}", cells: { $repeat: 2, $item: "{{index}}" } } }
+    expect(await applyTemplates(makeCtx(), data)).toEqual([{ row: 1, cells: [0, 1] }, { row: 2, cells: [0, 1] }])
+  })
+
+  it("leaves repeats without a whole-number count as written", async () => {
+    const data = { $repeat: "{{request.query.limit}}", $item: "x" }
+    expect(await applyTemplates(makeCtx(), data)).toEqual(data)
+    const huge = { $repeat: 1_000_000, $item: "x" }
+    expect(await applyTemplates(makeCtx(), huge)).toEqual(huge)
+  })
+
+  it("fails the render when nested repeats make too many items in all", async () => {
+    const limit = "{{request.query.limit}}"
+    const nested = { $repeat: limit, $item: { $repeat: limit, $item: { $repeat: limit, $item: "x" } } }
+    await expect(applyTemplates(makeCtx({ query: { limit: "10000" } }), nested)).rejects.toThrow("100000 items")
+    const rows = await applyTemplates(makeCtx({ query: { limit: "20" } }), nested)
+    expect((rows as Array<Array<Array<string>>>).flat(2)).toHaveLength(8000)
+  })
+
   it("handles mixed {{key}} and ${expr} in same string", async () => {
     const ctx = makeCtx({ method: "GET", query: { name: "Alice" } })
     expect(await applyTemplates(ctx, "{{request.method}} to ${$uppercase(request.query.name)}"))
       .toBe("GET to ALICE")
   })
 })
+
+describe("formatTime", () => {
+  const date = new Date(Date.UTC(2026, 0, 2, 3, 4, 5, 6))
+
+  it("formats named layouts", () => {
+    expect(formatTime(date)).toBe("2026-01-02T03:04:05Z")
+    expect(formatTime(date, "RFC3339Millis")).toBe("2026-01-02T03:04:05.006Z")
+    expect(formatTime(date, "RFC1123")).toBe("Fri, 02 Jan 2026 03:04:05 GMT")
+    expect(formatTime(date, "unix")).toBe(1767323045)
+    expect(formatTime(date, "unixMillis")).toBe(1767323045006)
+  })
+
+  it("fills in patterns", () => {
+    expect(formatTime(date, "YYYY/MM/DD HH:mm:ss.SSS")).toBe("2026/01/02 03:04:05.006")
+  })
+})
@@ -0,0 +1,148 @@
+import * as DateTime from "effect/DateTime"
+import { verifyEntries, verifyScenario } from "imposters/matching/Verification"
+import { NonEmptyString } from "imposters/schemas/common"
+import type { RequestLogEntry } from "imposters/schemas/RequestLogSchema"
+import { describe, expect, it } from "vitest"
+
+const makeEntry = (
+  path: string,
+  response: { status: number; body?: string; stubId?: string; proxied?: boolean }
+): RequestLogEntry => ({
+  id: NonEmptyString.make(crypto.randomUUID()),
+  imposterId: NonEmptyString.make("imp-1"),
+  timestamp: DateTime.unsafeNow(),
+  request: { method: "GET", path, headers: {}, query: {}, body: undefined },
+  response: {
+    status: response.status,
+    headers: { "Content-Type": "application/json" },
+    ...(response.body !== undefined ? { body: response.body } : {}),
+    ...(response.stubId !== undefined ? { matchedStubId: NonEmptyString.make(response.stubId) } : {}),
+    proxied: response.proxied ?? false
+  },
+  duration: 5
+})
+
+const entries = [
+  makeEntry("/checkout", { status: 200, body: "{\"result\":\"approved\"}", stubId: "ok" }),
+  makeEntry("/checkout", { status: 402, body: "{\"result\":\"declined\"}", stubId: "declined" }),
+  makeEntry("/checkout", { status: 200, body: "{\"result\":\"approved\"}", stubId: "ok" }),
+  makeEntry("/health", { status: 200 })
+]
+
+const checkout = [{ field: "path" as const, operator: "equals" as const, value: "/checkout", caseSensitive: true }]
+
+describe("verifyEntries", () => {
+  it("passes when at least one request matches by default", () => {
+    const result = verifyEntries(entries, { request: checkout })
+    expect(result.passed).toBe(true)
+    expect(result.count).toBe(3)
+    expect(result.entryIds).toHaveLength(3)
+  })
+
+  it("reports the status and stub distribution of matching requests", () => {
+    const result = verifyEntries(entries, { request: checkout })
+    expect(result.statuses).toEqual({ "200": 2, "402": 1 })
+    expect(result.stubs).toEqual({ ok: 2, declined: 1 })
+  })
+
+  it("filters on the served status and stub", () => {
+    expect(verifyEntries(entries, { request: checkout, response: { status: 402, predicates: [] } }).count).toBe(1)
+    expect(verifyEntries(entries, { request: checkout, response: { stubId: "ok", predicates: [] } }).count).toBe(2)
+  })
+
+  it("evaluates response predicates against the served body and headers", () => {
+    const result = verifyEntries(entries, {
+      request: checkout,
+      response: {
+        predicates: [
+          { field: "body", operator: "equals", value: { result: "declined" }, caseSensitive: true },
+          { field: "headers", operator: "contains", value: { "content-type": "json" }, caseSensitive: true }
+        ]
+      }
+    })
+    expect(result.count).toBe(1)
+  })
+
+  it("fails with a message when the count is out of bounds", () => {
+    const result = verifyEntries(entries, {
+      request: checkout,
+      response: { status: 200, predicates: [] },
+      times: { exactly: 1 }
+    })
+    expect(result.passed).toBe(false)
+    expect(result.message).toBe(
+      "Expected exactly 1 exchanges with the expected response, found 2 (3 matching requests)"
+    )
+  })
+
+  it("supports atLeast and atMost bounds", () => {
+    expect(verifyEntries(entries, { request: checkout, times: { atLeast: 2, atMost: 3 } }).passed).toBe(true)
+    expect(verifyEntries(entries, { request: checkout, times: { atMost: 2 } }).passed).toBe(false)
+    expect(verifyEntries(entries, { request: [], times: { exactly: 0 } }).passed).toBe(false)
+  })
+
+  it("matches queryString and bodySize from the journal", () => {
+    const base = makeEntry("/search", { status: 200 })
+    const entry: RequestLogEntry = { ...base, request: { ...base.request, queryString: "tag=a&tag=b", bodySize: 12 } }
+    const spec = (field: "queryString" | "bodySize", value: unknown) => ({
+      request: [{ field, operator: "equals" as const, value, caseSensitive: true }]
+    })
+    expect(verifyEntries([entry], spec("queryString", "tag=a&tag=b")).count).toBe(1)
+    expect(verifyEntries([entry], spec("bodySize", 12)).count).toBe(1)
+    expect(verifyEntries([entry], spec("bodySize", 13)).count).toBe(0)
+  })
+
+  it("matches any value of a repeated query parameter, as live matching does", () => {
+    const base = makeEntry("/search", { status: 200 })
+    const entry: RequestLogEntry = {
+      ...base,
+      request: { ...base.request, query: { tag: "b" }, queryString: "tag=a&tag=b" }
+    }
+    const tagged = (tag: string) => ({
+      request: [{ field: "query" as const, operator: "equals" as const, value: { tag }, caseSensitive: true }]
+    })
+    expect(verifyEntries([entry], tagged("a")).count).toBe(1)
+    expect(verifyEntries([entry], tagged("b")).count).toBe(1)
+    expect(verifyEntries([entry], tagged("c")).count).toBe(0)
+  })
+})
+
+describe("verifyScenario", () => {
+  const transition = (scenario: string, from: string, to: string) => ({
+    scenario,
+    from,
+    to,
+    at: DateTime.unsafeMake(0)
+  })
+  const history = [
+    transition("checkout", "Started", "paid"),
+    transition("auth", "Started", "in"),
+    transition("checkout", "paid", "shipped")
+  ]
+
+  it("passes when the transitions happened in order, with others in between", () => {
+    const result = verifyScenario(history, "shipped", {
+      name: "checkout",
+      state: "shipped",
+      sequence: [{ to: "paid" }, { from: "paid", to: "shipped" }]
+    })
+    expect(result.passed).toBe(true)
+    expect(result.transitions.map((t) => t.to)).toEqual(["paid", "shipped"])
+  })
+
+  it("fails when a step is missing or out of order", () => {
+    const result = verifyScenario(history, "shipped", {
+      name: "checkout",
+      sequence: [{ to: "shipped" }, { to: "paid" }]
+    })
+    expect(result.passed).toBe(false)
+    expect(result.message).toBe(
+      "Expected scenario checkout to move -> paid, found Started -> paid, paid -> shipped"
+    )
+  })
+
+  it("fails when the scenario is in another state", () => {
+    const result = verifyScenario(history, "shipped", { name: "checkout", state: "paid", sequence: [] })
+    expect(result).toMatchObject({ passed: false, message: "Expected scenario checkout in paid, found shipped" })
+  })
+})
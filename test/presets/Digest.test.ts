@@ -0,0 +1,56 @@
+import * as Schema from "effect/Schema"
+import { findMatchingStub } from "imposters/matching/RequestMatcher"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { expandPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const decodePreset = Schema.decodeUnknownSync(PresetConfig)
+
+const protectedRoute = Schema.decodeUnknownSync(Stub)({ id: "route", responses: [{ status: 200 }] })
+
+const withPreset = (config: unknown): ReadonlyArray<Stub> => [
+  ...expandPreset(decodePreset(config)).map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `auth-${i}`, ...stub })),
+  protectedRoute
+]
+
+const makeCtx = (headers: Record<string, string> = {}, path = "/dir/index.html"): RequestContext => ({
+  method: "GET",
+  path,
+  headers,
+  query: {},
+  body: undefined
+})
+
+// RFC 7616 section 3.9.1
+const authorization = (response: string) =>
+  `Digest username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", algorithm=MD5, ` +
+  `nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", nc=00000001, ` +
+  `cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", qop=auth, response="${response}"`
+
+describe("digest preset", () => {
+  const config = { preset: "digest", realm: "http-auth@example.org", users: { Mufasa: "Circle of Life" } }
+
+  it("requires at least one user", () => {
+    expect(() => decodePreset({ preset: "digest", users: {} })).toThrow("At least one user is required")
+  })
+
+  it("challenges until the client answers correctly", () => {
+    const stubs = withPreset(config)
+    const challenge = findMatchingStub(makeCtx(), stubs)
+    expect(challenge?.responses[0]?.status).toBe(401)
+    expect(challenge?.responses[0]?.headers?.["www-authenticate"]).toMatch(
+      /^Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=MD5, nonce=/
+    )
+    expect(findMatchingStub(makeCtx({ authorization: authorization("0".repeat(32)) }), stubs)?.id).not.toBe("route")
+    expect(findMatchingStub(makeCtx({ authorization: authorization("8ca523f5e9506fed4657c9700eebdbec") }), stubs)?.id)
+      .toBe("route")
+  })
+
+  it("only guards the configured path", () => {
+    const stubs = withPreset({ ...config, path: "/dir/{rest...}" })
+    expect(findMatchingStub(makeCtx({}, "/public"), stubs)?.id).toBe("route")
+    expect(findMatchingStub(makeCtx({}, "/dir/index.html"), stubs)?.responses[0]?.status).toBe(401)
+  })
+})
@@ -0,0 +1,87 @@
+import { it } from "@effect/vitest"
+import * as ConfigProvider from "effect/ConfigProvider"
+import * as Effect from "effect/Effect"
+import * as Layer from "effect/Layer"
+import * as Schema from "effect/Schema"
+import { buildResponse } from "imposters/matching/ResponseGenerator"
+import { findMatchingStub } from "imposters/matching/RequestMatcher"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { updateFlags } from "imposters/presets/Flags"
+import { expandPreset, seedPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { Variables, VariablesLive } from "imposters/services/Variables"
+import { describe, expect } from "vitest"
+
+const decodePreset = Schema.decodeUnknownSync(PresetConfig)
+
+const stubs = expandPreset(decodePreset({ preset: "flags" }))
+  .map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `flags-${i}`, ...stub }))
+
+const makeCtx = (path: string, method = "GET"): RequestContext => ({
+  method,
+  path,
+  headers: {},
+  query: {},
+  body: undefined
+})
+
+const flags = { "new-checkout": true, "dark-mode": false, "banner-text": "Hello", limits: { max: 5 } }
+
+const respond = async (path: string, method = "GET") => {
+  const ctx = makeCtx(path, method)
+  const stub = findMatchingStub(ctx, stubs)
+  expect(stub).toBeDefined()
+  return buildResponse(stub!.responses[0]!, ctx, { variables: { flags } })
+}
+
+describe("flags preset", () => {
+  it("serves LaunchDarkly flag definitions that always serve the flag's value", async () => {
+    const body = await (await respond("/sdk/latest-all")).json()
+    expect(body.segments).toEqual({})
+    expect(body.flags["new-checkout"]).toMatchObject({ key: "new-checkout", on: true, variations: [true] })
+    expect(body.flags["banner-text"].fallthrough).toEqual({ variation: 0 })
+  })
+
+  it("serves client-side evaluations, also as a put event", async () => {
+    const evaluated = await (await respond("/sdk/evalx/env-1/contexts/eyJrZXkiOiJ1In0", "REPORT")).json()
+    expect(evaluated["dark-mode"]).toMatchObject({ value: false, variation: 0 })
+    expect(evaluated.limits.value).toEqual({ max: 5 })
+
+    const stream = await respond("/eval/env-1/eyJrZXkiOiJ1In0")
+    expect(stream.headers.get("content-type")).toBe("text/event-stream")
+    const [event, data] = (await stream.text()).split("\n")
+    expect(event).toBe("event: put")
+    expect(JSON.parse(data!.slice("data: ".length))["new-checkout"].value).toBe(true)
+  })
+
+  it("serves Unleash features and enabled frontend toggles", async () => {
+    const { features } = await (await respond("/api/client/features")).json()
+    const byName = Object.fromEntries(features.map((f: { name: string }) => [f.name, f]))
+    expect(byName["new-checkout"]).toMatchObject({ enabled: true, variants: [] })
+    expect(byName["dark-mode"].enabled).toBe(false)
+    expect(byName["banner-text"].variants[0].payload).toEqual({ type: "string", value: "Hello" })
+
+    const { toggles } = await (await respond("/api/frontend")).json()
+    expect(toggles.map((t: { name: string }) => t.name)).toEqual(["new-checkout", "banner-text", "limits"])
+    expect(toggles[2].variant.payload).toEqual({ type: "json", value: "{\"max\":5}" })
+  })
+
+  it("serves empty payloads before any flag is set", async () => {
+    const ctx = makeCtx("/sdk/latest-flags")
+    const response = await buildResponse(findMatchingStub(ctx, stubs)!.responses[0]!, ctx, { variables: {} })
+    expect(await response.json()).toEqual({})
+  })
+})
+
+describe("flag state", () => {
+  const env = Layer.setConfigProvider(ConfigProvider.fromMap(new Map([["VARIABLES", "{\"tenant\":\"acme\"}"]])))
+
+  it.effect("applying the preset merges its flags, and null removes one", () =>
+    Effect.gen(function*() {
+      yield* seedPreset(decodePreset({ preset: "flags", flags: { a: true, b: "x" } }))
+      expect(yield* updateFlags((current) => ({ ...current, b: null, c: 1 }))).toEqual({ a: true, c: 1 })
+      const variables = yield* Variables
+      expect(yield* variables.get).toEqual({ tenant: "acme", flags: { a: true, c: 1 } })
+    }).pipe(Effect.provide(VariablesLive), Effect.provide(env)))
+})
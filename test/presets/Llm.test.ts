@@ -0,0 +1,101 @@
+import * as Schema from "effect/Schema"
+import { buildResponse } from "imposters/matching/ResponseGenerator"
+import { findMatchingStub, withPathParams } from "imposters/matching/RequestMatcher"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { expandPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { overLimit } from "imposters/server/RateLimits"
+import { describe, expect, it } from "vitest"
+
+const stubsFor = (config: unknown): ReadonlyArray<Stub> =>
+  expandPreset(Schema.decodeUnknownSync(PresetConfig)(config))
+    .map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `llm-${i}`, ...stub }))
+
+const stubs = stubsFor({
+  preset: "llm",
+  chunkDelay: 0,
+  completions: [{ match: "weather", reply: "It is sunny in Lisbon." }]
+})
+
+const chat = (content: string, extra: Record<string, unknown> = {}, headers: Record<string, string> = {}) =>
+  send(stubs, {
+    method: "POST",
+    path: "/v1/chat/completions",
+    headers,
+    query: {},
+    body: { model: "gpt-4.1", messages: [{ role: "user", content }], ...extra }
+  })
+
+const send = async (stubs: ReadonlyArray<Stub>, ctx: RequestContext) => {
+  const stub = findMatchingStub(ctx, stubs)
+  expect(stub).toBeDefined()
+  return buildResponse(stub!.responses[0]!, withPathParams(ctx, stub!))
+}
+
+describe("llm preset", () => {
+  it("answers with the first completion that matches, else the default reply", async () => {
+    const response = await chat("What's the WEATHER like?")
+    expect(response.headers.get("x-ratelimit-limit-requests")).toBe("500")
+    const completion = await response.json()
+    expect(completion).toMatchObject({
+      object: "chat.completion",
+      model: "gpt-4.1",
+      choices: [{ index: 0, message: { role: "assistant", content: "It is sunny in Lisbon." }, finish_reason: "stop" }]
+    })
+    expect(completion.id).toMatch(/^chatcmpl-/)
+    expect(completion.usage.total_tokens).toBe(completion.usage.prompt_tokens + completion.usage.completion_tokens)
+
+    const fallback = await (await chat("Hello")).json()
+    expect(fallback.choices[0].message.content).toBe("This is a mock completion from Imposters.")
+  })
+
+  it("streams the reply as chunks ending with [DONE]", async () => {
+    const response = await chat("weather?", { stream: true })
+    expect(response.headers.get("content-type")).toBe("text/event-stream")
+    const events = (await response.text()).trim().split("\n\n").map((event) => event.replace(/^data: /, ""))
+    expect(events.at(-1)).toBe("[DONE]")
+    const chunks = events.slice(0, -1).map((event) => JSON.parse(event))
+    expect(new Set(chunks.map((chunk) => chunk.id)).size).toBe(1)
+    expect(chunks[0].choices[0].delta).toEqual({ role: "assistant", content: "" })
+    expect(chunks.map((chunk) => chunk.choices[0].delta.content ?? "").join("")).toBe("It is sunny in Lisbon.")
+    expect(chunks.at(-1).choices[0].finish_reason).toBe("stop")
+  })
+
+  it("fails the way the X-Imposters-Error header asks", async () => {
+    const limited = await chat("Hello", {}, { "x-imposters-error": "rate_limit" })
+    expect(limited.status).toBe(429)
+    expect(limited.headers.get("retry-after")).toBe("1")
+    expect((await limited.json()).error).toMatchObject({ code: "rate_limit_exceeded" })
+
+    const tooLong = await chat("Hello", { stream: true }, { "x-imposters-error": "context_length" })
+    expect(tooLong.status).toBe(400)
+    expect((await tooLong.json()).error).toMatchObject({ param: "messages", code: "context_length_exceeded" })
+  })
+
+  it("limits completions to requestsPerMinute, failing the rest as rate_limit", async () => {
+    const limited = stubsFor({ preset: "llm", requestsPerMinute: 3 })
+    const ctx = { method: "POST", path: "/v1/chat/completions", headers: {}, query: {}, body: { messages: [] } }
+    const limit = findMatchingStub(ctx, limited)!.responses[0]!.rateLimit!
+    expect(limit).toMatchObject({ name: "llm", requests: 3, windowMs: 60000, status: 429 })
+    const streamed = findMatchingStub({ ...ctx, body: { messages: [], stream: true } }, limited)!.responses[0]!
+    expect(streamed.rateLimit).toEqual(limit)
+
+    const response = await buildResponse(overLimit(limit), ctx)
+    expect(response.status).toBe(429)
+    expect(response.headers.get("x-ratelimit-remaining-requests")).toBe("0")
+    expect((await response.json()).error).toMatchObject({ code: "rate_limit_exceeded" })
+  })
+
+  it("fails every completion with error set, and lists its model", async () => {
+    const failing = stubsFor({ preset: "llm", model: "local-llama", error: "overloaded" })
+    const ctx = { method: "POST", path: "/v1/chat/completions", headers: {}, query: {}, body: { messages: [] } }
+    expect((await send(failing, ctx)).status).toBe(503)
+    const models = await send(failing, { method: "GET", path: "/v1/models", headers: {}, query: {}, body: undefined })
+    expect(await models.json()).toMatchObject({ data: [{ id: "local-llama", object: "model" }] })
+  })
+
+  it("rejects completions whose pattern is not a regular expression", () => {
+    expect(() => stubsFor({ preset: "llm", completions: [{ match: "(", reply: "x" }] })).toThrow()
+  })
+})
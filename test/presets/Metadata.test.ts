@@ -0,0 +1,110 @@
+import * as Schema from "effect/Schema"
+import { buildResponse } from "imposters/matching/ResponseGenerator"
+import { findMatchingStub, withPathParams } from "imposters/matching/RequestMatcher"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { expandPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { afterEach, beforeEach, describe, expect, it, vi } from "vitest"
+
+const stubsFor = (config: unknown): ReadonlyArray<Stub> =>
+  expandPreset(Schema.decodeUnknownSync(PresetConfig)(config))
+    .map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `md-${i}`, ...stub }))
+
+const makeCtx = (method: string, path: string, headers: Record<string, string> = {}): RequestContext => ({
+  method,
+  path,
+  headers,
+  query: {},
+  body: undefined
+})
+
+const send = async (stubs: ReadonlyArray<Stub>, ctx: RequestContext) => {
+  const stub = findMatchingStub(ctx, stubs)
+  if (stub === undefined) return undefined
+  return buildResponse(stub.responses[0]!, withPathParams(ctx, stub))
+}
+
+describe("metadata preset", () => {
+  beforeEach(() => {
+    vi.useFakeTimers({ toFake: ["Date"] })
+    vi.setSystemTime(new Date("2025-06-01T10:15:00Z"))
+  })
+  afterEach(() => {
+    vi.useRealTimers()
+  })
+
+  describe("aws", () => {
+    const stubs = stubsFor({ provider: "aws", preset: "metadata", identity: "app-role", tokenRequired: true })
+    const token = { "x-aws-ec2-metadata-token": "AQAE" }
+
+    it("hands out IMDSv2 tokens and requires them when asked to", async () => {
+      const ttl = { "x-aws-ec2-metadata-token-ttl-seconds": "21600" }
+      const issued = await send(stubs, makeCtx("PUT", "/latest/api/token", ttl))
+      expect(issued?.status).toBe(200)
+      expect(issued?.headers.get("x-aws-ec2-metadata-token-ttl-seconds")).toBe("21600")
+      expect((await send(stubs, makeCtx("PUT", "/latest/api/token")))?.status).toBe(400)
+      expect((await send(stubs, makeCtx("GET", "/latest/meta-data/instance-id")))?.status).toBe(401)
+    })
+
+    it("serves credentials for the role that expire at the end of the hour", async () => {
+      const role = await send(stubs, makeCtx("GET", "/latest/meta-data/iam/security-credentials/", token))
+      expect(await role?.text()).toBe("app-role")
+
+      const credentials = await (await send(
+        stubs,
+        makeCtx("GET", "/latest/meta-data/iam/security-credentials/app-role", token)
+      ))?.json()
+      expect(credentials).toMatchObject({
+        Code: "Success",
+        Type: "AWS-HMAC",
+        LastUpdated: "2025-06-01T10:00:00Z",
+        Expiration: "2025-06-01T11:00:00Z"
+      })
+      expect(credentials.AccessKeyId).toMatch(/^ASIAIMPOSTERS[0-9A-Z]+$/)
+
+      vi.setSystemTime(new Date("2025-06-01T11:00:01Z"))
+      const rotated = await (await send(
+        stubs,
+        makeCtx("GET", "/latest/meta-data/iam/security-credentials/app-role", token)
+      ))?.json()
+      expect(rotated.AccessKeyId).not.toBe(credentials.AccessKeyId)
+      expect(rotated.Expiration).toBe("2025-06-01T12:00:00Z")
+    })
+
+    it("serves the instance identity document", async () => {
+      const document = await (await send(stubs, makeCtx("GET", "/latest/dynamic/instance-identity/document", token)))
+        ?.json()
+      expect(document).toMatchObject({ region: "us-east-1", availabilityZone: "us-east-1a", accountId: "123456789012" })
+    })
+  })
+
+  describe("gcp", () => {
+    const stubs = stubsFor({ preset: "metadata", provider: "gcp", account: "my-project", credentialTtl: 600 })
+    const flavor = { "metadata-flavor": "Google" }
+
+    it("refuses requests without Metadata-Flavor: Google", async () => {
+      const response = await send(stubs, makeCtx("GET", "/computeMetadata/v1/project/project-id"))
+      expect(response?.status).toBe(403)
+      expect(response?.headers.get("metadata-flavor")).toBe("Google")
+    })
+
+    it("serves the project and a token for the default service account", async () => {
+      const project = await send(stubs, makeCtx("GET", "/computeMetadata/v1/project/project-id", flavor))
+      expect(await project?.text()).toBe("my-project")
+
+      const token = await (await send(
+        stubs,
+        makeCtx("GET", "/computeMetadata/v1/instance/service-accounts/default/token", flavor)
+      ))?.json()
+      expect(token).toMatchObject({ token_type: "Bearer", expires_in: 300 })
+      expect(token.access_token).toMatch(/^ya29\.imposters-/)
+
+      const email = await send(
+        stubs,
+        makeCtx("GET", "/computeMetadata/v1/instance/service-accounts/default/email", flavor)
+      )
+      expect(await email?.text()).toBe("default@my-project.iam.gserviceaccount.com")
+    })
+  })
+})
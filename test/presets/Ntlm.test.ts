@@ -0,0 +1,87 @@
+import * as Schema from "effect/Schema"
+import { findMatchingStub } from "imposters/matching/RequestMatcher"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { NTLM_NEGOTIATE_PREFIX, ntlmChallenge } from "imposters/presets/Ntlm"
+import { expandPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const decodePreset = Schema.decodeUnknownSync(PresetConfig)
+
+const makeCtx = (headers: Record<string, string> = {}, path = "/intranet"): RequestContext => ({
+  method: "GET",
+  path,
+  headers,
+  query: {},
+  body: undefined
+})
+
+const protectedRoute = Schema.decodeUnknownSync(Stub)({ id: "route", responses: [{ status: 200 }] })
+
+const withPreset = (config: unknown): ReadonlyArray<Stub> => [
+  ...expandPreset(decodePreset(config)).map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `ntlm-${i}`, ...stub })),
+  protectedRoute
+]
+
+// A minimal AUTHENTICATE message header: "NTLMSSP\0" + type 3
+const AUTHENTICATE = Buffer.from("NTLMSSP\0\x03\0\0\0", "latin1").toString("base64")
+
+describe("ntlmChallenge", () => {
+  it("builds a type 2 message naming the domain", () => {
+    const message = Buffer.from(ntlmChallenge("contoso"), "base64")
+    expect(message.subarray(0, 8).toString("latin1")).toBe("NTLMSSP\0")
+    expect(message.readUInt32LE(8)).toBe(2)
+    const length = message.readUInt16LE(12)
+    const offset = message.readUInt32LE(16)
+    expect(message.subarray(offset, offset + length).toString("utf16le")).toBe("CONTOSO")
+    // NTLMSSP_NEGOTIATE_UNICODE and NTLMSSP_NEGOTIATE_TARGET_INFO
+    expect(message.readUInt32LE(20) & 0x00800001).toBe(0x00800001)
+    const infoOffset = message.readUInt32LE(44)
+    expect(infoOffset + message.readUInt16LE(40)).toBe(message.byteLength)
+  })
+})
+
+describe("ntlm preset", () => {
+  it("applies defaults when decoded", () => {
+    expect(decodePreset({ preset: "ntlm" })).toEqual({
+      preset: "ntlm",
+      schemes: ["negotiate", "ntlm"],
+      domain: "IMPOSTERS",
+      priority: 100
+    })
+  })
+
+  it("rejects domains that are not NetBIOS names", () => {
+    expect(() => decodePreset({ preset: "ntlm", domain: "not a netbios domain" })).toThrow()
+  })
+
+  it("walks a client through the handshake", () => {
+    const stubs = withPreset({ preset: "ntlm" })
+
+    const anonymous = findMatchingStub(makeCtx(), stubs)
+    expect(anonymous?.responses[0]?.status).toBe(401)
+    expect(anonymous?.responses[0]?.headers).toEqual({ "www-authenticate": "Negotiate, NTLM" })
+
+    const negotiate = findMatchingStub(makeCtx({ authorization: `NTLM ${NTLM_NEGOTIATE_PREFIX}B4IIog==` }), stubs)
+    expect(negotiate?.responses[0]?.status).toBe(401)
+    expect(negotiate?.responses[0]?.headers?.["www-authenticate"]).toBe(`NTLM ${ntlmChallenge("IMPOSTERS")}`)
+
+    expect(findMatchingStub(makeCtx({ authorization: `NTLM ${AUTHENTICATE}` }), stubs)?.id).toBe("route")
+    expect(findMatchingStub(makeCtx({ authorization: "Negotiate YIIGhgYGKwYBBQUCoIIGejCC" }), stubs)?.id)
+      .toBe("route")
+  })
+
+  it("offers only the configured schemes", () => {
+    const stubs = withPreset({ preset: "ntlm", schemes: ["ntlm"] })
+    expect(findMatchingStub(makeCtx(), stubs)?.responses[0]?.headers).toEqual({ "www-authenticate": "NTLM" })
+    expect(findMatchingStub(makeCtx({ authorization: "Negotiate YIIGhgYGKwYBBQUCoIIGejCC" }), stubs)?.id)
+      .not.toBe("route")
+  })
+
+  it("only guards the configured path", () => {
+    const stubs = withPreset({ preset: "ntlm", path: "/intranet/{rest...}" })
+    expect(findMatchingStub(makeCtx({}, "/public"), stubs)?.id).toBe("route")
+    expect(findMatchingStub(makeCtx({}, "/intranet/reports"), stubs)?.responses[0]?.status).toBe(401)
+  })
+})
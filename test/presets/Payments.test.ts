@@ -0,0 +1,92 @@
+import * as Schema from "effect/Schema"
+import { buildResponse } from "imposters/matching/ResponseGenerator"
+import { findMatchingStub, withPathParams } from "imposters/matching/RequestMatcher"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { expandPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const decodePreset = Schema.decodeUnknownSync(PresetConfig)
+
+const stubsFor = (config: unknown): ReadonlyArray<Stub> =>
+  expandPreset(decodePreset(config)).map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `pay-${i}`, ...stub }))
+
+const stubs = stubsFor({ preset: "payments", webhookUrl: "http://localhost:4000/webhooks" })
+
+const makeCtx = (method: string, path: string, body: unknown, form?: Record<string, string>): RequestContext => ({
+  method,
+  path,
+  headers: {},
+  query: {},
+  body,
+  ...(form !== undefined ? { form } : {})
+})
+
+const send = async (ctx: RequestContext) => {
+  const stub = findMatchingStub(ctx, stubs)
+  expect(stub).toBeDefined()
+  const response = await buildResponse(stub!.responses[0]!, withPathParams(ctx, stub!))
+  return { stub: stub!, response }
+}
+
+const pay = (number: string, extra: Record<string, unknown> = {}) =>
+  send(
+    makeCtx("POST", "/payments", { amount: 1999, currency: "eur", reference: "order-1", card: { number }, ...extra })
+  )
+
+describe("payments preset", () => {
+  it("charges a good card and sends payment.succeeded", async () => {
+    const { response, stub } = await pay("4242424242424242")
+    expect(response.status).toBe(201)
+    expect(await response.json()).toEqual({
+      id: "pay_order-1",
+      object: "payment",
+      status: "succeeded",
+      amount: 1999,
+      currency: "eur",
+      reference: "order-1",
+      last4: "4242"
+    })
+    expect(stub.responses[0]!.callbacks?.[0]?.url).toBe("http://localhost:4000/webhooks")
+    expect(stub.responses[0]!.callbacks?.[0]?.body).toContain("payment.succeeded")
+  })
+
+  it("declines the magic numbers with card errors", async () => {
+    const { response } = await pay("4000000000009995")
+    expect(response.status).toBe(402)
+    const { error } = await response.json()
+    expect(error).toMatchObject({ type: "card_error", code: "card_declined", decline_code: "insufficient_funds" })
+    expect(error.payment).toMatchObject({ id: "pay_order-1", status: "failed" })
+    expect((await pay("4000000000000069")).response.status).toBe(402)
+  })
+
+  it("walks a 3-D Secure card through its challenge", async () => {
+    const { response } = await pay("4000000000003220")
+    const payment = await response.json()
+    expect(payment.status).toBe("requires_action")
+    expect(payment.next_action).toEqual({ type: "three_d_secure", url: "/3ds/pay_order-1" })
+
+    const page = await send(makeCtx("GET", "/3ds/pay_order-1", undefined))
+    expect(await page.response.text()).toContain("action=\"/3ds/pay_order-1\"")
+
+    const passed = await send(makeCtx("POST", "/3ds/pay_order-1", undefined, { result: "authenticated" }))
+    expect(await passed.response.json()).toMatchObject({ id: "pay_order-1", status: "succeeded" })
+    const failed = await send(makeCtx("POST", "/3ds/pay_order-1", undefined, { result: "failed" }))
+    expect(await failed.response.json()).toMatchObject({ status: "failed", error: { code: "authentication_failed" } })
+  })
+
+  it("authorizes without capturing, then captures asynchronously", async () => {
+    const { response } = await pay("4242424242424242", { capture: false })
+    expect(await response.json()).toMatchObject({ status: "requires_capture" })
+
+    const capture = await send(makeCtx("POST", "/payments/pay_order-1/capture", undefined))
+    expect(capture.response.status).toBe(202)
+    expect(await capture.response.json()).toMatchObject({ id: "pay_order-1", status: "processing" })
+    expect(capture.stub.responses[0]!.callbacks?.[0]).toMatchObject({ delay: 2000 })
+  })
+
+  it("sends no webhooks without a webhookUrl", () => {
+    expect(stubsFor({ preset: "payments" }).every((stub) => stub.responses[0]!.callbacks === undefined)).toBe(true)
+  })
+})
@@ -0,0 +1,39 @@
+import * as Schema from "effect/Schema"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { findMatchingStub } from "imposters/matching/RequestMatcher"
+import { expandPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const stubsFor = (config: unknown) =>
+  expandPreset(Schema.decodeUnknownSync(PresetConfig)(config))
+    .map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `resource-${i}`, ...stub }))
+
+const makeCtx = (path: string, method = "GET"): RequestContext => ({
+  method,
+  path,
+  headers: {},
+  query: {},
+  body: undefined
+})
+
+describe("resource preset", () => {
+  it("matches the collection and its items, and nothing else", () => {
+    const stubs = stubsFor({ preset: "resource", path: "/api/v1.0/todos/" })
+    const matched = (path: string) => findMatchingStub(makeCtx(path, "DELETE"), stubs) !== undefined
+    const paths = ["/api/v1.0/todos", "/api/v1.0/todos/", "/api/v1.0/todos/3", "/api/v1.0/todos/3/", "/api/v1x0/todos"]
+    expect(paths.filter(matched)).toEqual(paths.slice(0, 4))
+    expect(matched("/api/v1.0/todos/3/tags")).toBe(false)
+    expect(matched("/api/v1.0/todosx")).toBe(false)
+  })
+
+  it("answers from a resource with the preset's settings", () => {
+    const [stub] = stubsFor({ preset: "resource", path: "/users", idField: "userId", ids: "uuid" })
+    expect(stub?.responses[0].resource).toEqual({ path: "/users", idField: "userId", ids: "uuid", seed: [] })
+  })
+
+  it("rejects a path that isn't absolute", () => {
+    expect(() => stubsFor({ preset: "resource", path: "todos" })).toThrow()
+  })
+})
@@ -0,0 +1,59 @@
+import * as Schema from "effect/Schema"
+import { findMatchingStub } from "imposters/matching/RequestMatcher"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { expandPreset } from "imposters/presets/Presets"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { describe, expect, it } from "vitest"
+
+const decodePreset = Schema.decodeUnknownSync(PresetConfig)
+
+const protectedRoute = Schema.decodeUnknownSync(Stub)({ id: "route", responses: [{ status: 200 }] })
+
+const withPreset = (config: unknown): ReadonlyArray<Stub> => [
+  ...expandPreset(decodePreset(config)).map((stub, i) => Schema.decodeUnknownSync(Stub)({ id: `sigv4-${i}`, ...stub })),
+  protectedRoute
+]
+
+// AWS Signature Version 4 test suite: get-vanilla
+const signed = (signature: string): RequestContext => ({
+  method: "GET",
+  path: "/",
+  headers: {
+    host: "example.amazonaws.com",
+    "x-amz-date": "20150830T123600Z",
+    authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+      `SignedHeaders=host;x-amz-date, Signature=${signature}`
+  },
+  query: {},
+  body: undefined
+})
+
+const VALID = "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+
+describe("sigv4 preset", () => {
+  const config = { preset: "sigv4", keys: { AKIDEXAMPLE: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY" } }
+
+  it("passes correctly signed requests through", () => {
+    expect(findMatchingStub(signed(VALID), withPreset(config))?.id).toBe("route")
+    expect(findMatchingStub(signed(VALID), withPreset({ ...config, region: "us-east-1" }))?.id).toBe("route")
+  })
+
+  it("answers unsigned and badly signed requests like AWS", () => {
+    const stubs = withPreset(config)
+    const unsigned = findMatchingStub({ ...signed(VALID), headers: {} }, stubs)?.responses[0]
+    expect(unsigned?.status).toBe(403)
+    expect(unsigned?.headers?.["x-amzn-errortype"]).toBe("MissingAuthenticationTokenException")
+    const mismatch = findMatchingStub(signed("0".repeat(64)), stubs)?.responses[0]
+    expect(mismatch?.status).toBe(403)
+    expect(mismatch?.body).toMatchObject({ __type: "InvalidSignatureException" })
+    expect(findMatchingStub(signed(VALID), withPreset({ ...config, region: "eu-west-1" }))?.id).not.toBe("route")
+  })
+
+  it("uses S3's XML errors for the s3 service", () => {
+    const stubs = withPreset({ ...config, service: "s3" })
+    const error = findMatchingStub(signed(VALID), stubs)?.responses[0]
+    expect(error?.headers?.["content-type"]).toBe("application/xml")
+    expect(error?.body).toContain("<Code>SignatureDoesNotMatch</Code>")
+  })
+})
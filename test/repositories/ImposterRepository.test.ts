@@ -1,7 +1,10 @@
 import { it } from "@effect/vitest"
 import * as DateTime from "effect/DateTime"
+import * as Deferred from "effect/Deferred"
 import * as Effect from "effect/Effect"
+import * as Fiber from "effect/Fiber"
 import * as Schema from "effect/Schema"
+import * as TestClock from "effect/TestClock"
 import { ImposterConfig } from "imposters/domain/imposter"
 import { ImposterRepository, ImposterRepositoryLive } from "imposters/repositories/ImposterRepository"
 import { Stub } from "imposters/schemas/StubSchema"
@@ -43,6 +46,15 @@ describe("ImposterRepository", () => {
       expect(error._tag).toBe("ImposterNotFoundError")
     }).pipe(Effect.provide(ImposterRepositoryLive)))
 
+  it.effect("create rejects a taken ID", () =>
+    Effect.gen(function*() {
+      const repo = yield* ImposterRepository
+      yield* repo.create(makeConfig("imp-1", "first"))
+      const error = yield* Effect.flip(repo.create(makeConfig("imp-1", "second")))
+      expect(error._tag).toBe("ImposterExistsError")
+      expect((yield* repo.get("imp-1")).config.name).toBe("first")
+    }).pipe(Effect.provide(ImposterRepositoryLive)))
+
   it.effect("update imposter config", () =>
     Effect.gen(function*() {
       const repo = yield* ImposterRepository
@@ -91,6 +103,19 @@ describe("ImposterRepository", () => {
         expect(stubs[0]!.id).toBe("stub-1")
       }).pipe(Effect.provide(ImposterRepositoryLive)))
 
+    it.effect("stamps stubs with the Clock's time when they are added", () =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        yield* repo.create(makeConfig("imp-1", "test"))
+        yield* repo.addStub("imp-1", makeStub("stub-1"))
+        yield* TestClock.adjust("1 minute")
+        yield* repo.update("imp-1", (r) => ({ ...r, stubs: [...r.stubs, makeStub("stub-2")] }))
+
+        const { stubsAddedAt } = yield* repo.get("imp-1")
+        expect(stubsAddedAt.get("stub-1")!.epochMillis).toBe(0)
+        expect(stubsAddedAt.get("stub-2")!.epochMillis).toBe(60000)
+      }).pipe(Effect.provide(ImposterRepositoryLive)))
+
     it.effect("update stub", () =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
@@ -117,6 +142,17 @@ describe("ImposterRepository", () => {
         expect(stubs).toHaveLength(0)
       }).pipe(Effect.provide(ImposterRepositoryLive)))
 
+    it.effect("addStub rejects a taken stub ID", () =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        yield* repo.create(makeConfig("imp-1", "test"))
+        yield* repo.addStub("imp-1", makeStub("s1"))
+
+        const error = yield* Effect.flip(repo.addStub("imp-1", makeStub("s1")))
+        expect(error._tag).toBe("StubExistsError")
+        expect(yield* repo.getStubs("imp-1")).toHaveLength(1)
+      }).pipe(Effect.provide(ImposterRepositoryLive)))
+
     it.effect("update missing stub fails", () =>
       Effect.gen(function*() {
         const repo = yield* ImposterRepository
@@ -135,4 +171,63 @@ describe("ImposterRepository", () => {
         expect(error._tag).toBe("ImposterNotFoundError")
       }).pipe(Effect.provide(ImposterRepositoryLive)))
   })
+  describe("transactions", () => {
+    it.effect("commit every change together", () =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const result = yield* repo.transaction((tx) =>
+          Effect.gen(function*() {
+            yield* tx.create(makeConfig("imp-1", "test"))
+            yield* tx.addStub("imp-1", makeStub("s1"))
+            yield* tx.addStub("imp-1", makeStub("s2"))
+            return yield* tx.getStubs("imp-1")
+          })
+        )
+        expect(result.map((s) => s.id)).toEqual(["s1", "s2"])
+        expect((yield* repo.getStubs("imp-1")).map((s) => s.id)).toEqual(["s1", "s2"])
+      }).pipe(Effect.provide(ImposterRepositoryLive)))
+
+    it.effect("leave the store untouched when they fail", () =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        yield* repo.create(makeConfig("imp-1", "test"))
+        const error = yield* Effect.flip(repo.transaction((tx) =>
+          Effect.gen(function*() {
+            yield* tx.addStub("imp-1", makeStub("s1"))
+            yield* tx.create(makeConfig("imp-2", "other"))
+            yield* tx.addStub("imp-1", makeStub("s1"))
+          })
+        ))
+        expect(error._tag).toBe("StubExistsError")
+        expect(yield* repo.getStubs("imp-1")).toEqual([])
+        expect(yield* repo.getAll).toHaveLength(1)
+      }).pipe(Effect.provide(ImposterRepositoryLive)))
+
+    it.effect("hide changes from readers and hold back writers until they commit", () =>
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        yield* repo.create(makeConfig("imp-1", "test"))
+        const halfway = yield* Deferred.make<void>()
+        const finish = yield* Deferred.make<void>()
+        const tx = yield* repo.transaction((tx) =>
+          Effect.gen(function*() {
+            yield* tx.addStub("imp-1", makeStub("s1"))
+            yield* Deferred.succeed(halfway, undefined)
+            yield* Deferred.await(finish)
+            yield* tx.addStub("imp-1", makeStub("s2"))
+          })
+        ).pipe(Effect.fork)
+        yield* Deferred.await(halfway)
+
+        expect(yield* repo.getStubs("imp-1")).toEqual([])
+        const write = yield* Effect.fork(repo.addStub("imp-1", makeStub("s3")))
+        yield* Effect.yieldNow()
+        expect(yield* repo.getStubs("imp-1")).toEqual([])
+
+        yield* Deferred.succeed(finish, undefined)
+        yield* Fiber.join(tx)
+        yield* Fiber.join(write)
+        expect((yield* repo.getStubs("imp-1")).map((s) => s.id)).toEqual(["s1", "s2", "s3"])
+      }).pipe(Effect.provide(ImposterRepositoryLive)))
+  })
 })
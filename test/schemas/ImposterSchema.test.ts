@@ -7,8 +7,12 @@ import {
   DeleteImposterQuery,
   UpdateImposterRequest
 } from "imposters/schemas/ImposterSchema"
+import * as fs from "node:fs"
+import * as path from "node:path"
 import { describe, expect } from "vitest"
 
+const fixture = (name: string) => fs.readFileSync(path.join(__dirname, "../fixtures/tls", name), "utf8")
+
 describe("ImposterSchema", () => {
   describe("CreateImposterRequest", () => {
     it.effect("decodes with defaults", () =>
@@ -29,6 +33,28 @@ describe("ImposterSchema", () => {
         expect(request.port).toBe(3000)
         expect(request.adminPath).toBe("/custom")
       }))
+
+    it.effect("accepts HTTPS with a certificate per host", () =>
+      Effect.gen(function*() {
+        const localhost = { cert: fixture("localhost.crt"), key: fixture("localhost.key") }
+        const api = { cert: fixture("api.example.com.crt"), key: fixture("api.example.com.key") }
+        const request = yield* Schema.decodeUnknown(CreateImposterRequest)({
+          protocol: "HTTPS",
+          tls: { ...localhost, hosts: { "api.example.com": api } }
+        })
+        expect(request.protocol).toBe("HTTPS")
+        expect(request.tls?.hosts?.["api.example.com"]).toEqual(api)
+      }))
+
+    it.effect("rejects HTTPS without a certificate, and keys that don't match", () =>
+      Effect.gen(function*() {
+        const decode = Schema.decodeUnknown(CreateImposterRequest)
+        expect((yield* Effect.either(decode({ protocol: "HTTPS" })))._tag).toBe("Left")
+        const mismatched = { cert: fixture("localhost.crt"), key: fixture("api.example.com.key") }
+        expect((yield* Effect.either(decode({ protocol: "HTTPS", tls: mismatched })))._tag).toBe("Left")
+        const badHost = { cert: fixture("localhost.crt"), key: fixture("localhost.key"), hosts: { a: mismatched } }
+        expect((yield* Effect.either(decode({ protocol: "HTTPS", tls: badHost })))._tag).toBe("Left")
+      }))
   })
 
   describe("UpdateImposterRequest", () => {
@@ -1,7 +1,15 @@
 import { it } from "@effect/vitest"
 import * as Effect from "effect/Effect"
 import * as Schema from "effect/Schema"
-import { CreateStubRequest, Predicate, ResponseConfig, Stub } from "imposters/schemas/StubSchema"
+import {
+  CreateStubRequest,
+  DocumentStubRequest,
+  Predicate,
+  PredicateExpression,
+  ResponseConfig,
+  Stub,
+  UpdateStubRequest
+} from "imposters/schemas/StubSchema"
 import { describe, expect } from "vitest"
 
 describe("StubSchema", () => {
@@ -23,6 +31,116 @@ describe("StubSchema", () => {
         const result = yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ status: 999 }))
         expect(result._tag).toBe("ParseError")
       }))
+    it.effect("requires exactly one of bytes or percent for a truncate fault", () =>
+      Effect.gen(function*() {
+        const config = yield* Schema.decodeUnknown(ResponseConfig)({ fault: { type: "truncate", percent: 50 } })
+        expect(config.fault).toEqual({ type: "truncate", percent: 50 })
+        const both = yield* Effect.flip(
+          Schema.decodeUnknown(ResponseConfig)({ fault: { type: "truncate", bytes: 10, percent: 50 } })
+        )
+        expect(both.message).toContain("Set exactly one of bytes or percent")
+        const neither = yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ fault: { type: "truncate" } }))
+        expect(neither._tag).toBe("ParseError")
+      }))
+
+    it.effect("defaults the array size of a body schema", () =>
+      Effect.gen(function*() {
+        const config = yield* Schema.decodeUnknown(ResponseConfig)({ bodySchema: { schema: { type: "array" } } })
+        expect(config.bodySchema?.arraySize).toEqual({ min: 1, max: 3 })
+        const inverted = yield* Effect.flip(
+          Schema.decodeUnknown(ResponseConfig)({ bodySchema: { schema: {}, arraySize: { min: 5, max: 2 } } })
+        )
+        expect(inverted.message).toContain("arraySize.min must not exceed arraySize.max")
+      }))
+
+    it.effect("decodes a response validation schema", () =>
+      Effect.gen(function*() {
+        const config = yield* Schema.decodeUnknown(ResponseConfig)({ validate: { schema: { type: "object" } } })
+        expect(config.validate?.schema).toEqual({ type: "object" })
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ validate: { schema: "object" } }))
+      }))
+
+    it.effect("decodes media with defaults", () =>
+      Effect.gen(function*() {
+        const image = yield* Schema.decodeUnknown(ResponseConfig)({ media: { type: "image" } })
+        expect(image.media).toEqual({ type: "image", format: "png", width: 200, height: 200, color: "#cccccc" })
+        const pdf = yield* Schema.decodeUnknown(ResponseConfig)({ media: { type: "pdf", text: "Invoice" } })
+        expect(pdf.media).toEqual({ type: "pdf", text: "Invoice", pages: 1, size: "a4" })
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ media: { type: "image", color: "red" } }))
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ media: { type: "image", width: 0 } }))
+      }))
+
+    it.effect("accepts a templated status but not other strings", () =>
+      Effect.gen(function*() {
+        const config = yield* Schema.decodeUnknown(ResponseConfig)({ status: "{{request.query.status}}" })
+        expect(config.status).toBe("{{request.query.status}}")
+        yield* Schema.decodeUnknown(ResponseConfig)({ status: "${request.query.fail ? 503 : 200}" })
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ status: "404" }))
+      }))
+
+    it.effect("takes events only with type sse", () =>
+      Effect.gen(function*() {
+        const config = yield* Schema.decodeUnknown(ResponseConfig)({
+          type: "sse",
+          events: [{ data: { n: 1 } }, { event: "tick", id: "2", data: "two", delay: 500 }]
+        })
+        expect(config.events).toHaveLength(2)
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ type: "sse" }))
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ events: [{ data: 1 }] }))
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ type: "sse", events: [{ event: "a\nb", data: 1 }] }))
+      }))
+
+    it.effect("takes a fixed delay or a range", () =>
+      Effect.gen(function*() {
+        expect((yield* Schema.decodeUnknown(ResponseConfig)({ delay: 250 })).delay).toBe(250)
+        const ranged = yield* Schema.decodeUnknown(ResponseConfig)({ delay: { min: 100, max: 300 } })
+        expect(ranged.delay).toEqual({ min: 100, max: 300 })
+        const inverted = yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ delay: { min: 300, max: 100 } }))
+        expect(inverted.message).toContain("delay min must not be greater than max")
+        yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ delay: { min: 0, max: 120000 } }))
+      }))
+
+    it.effect("takes a latency distribution with an optional tail", () =>
+      Effect.gen(function*() {
+        const delay = { distribution: "uniform", min: 10, max: 20, tail: { percentile: 99.9, delay: 5000 } }
+        expect((yield* Schema.decodeUnknown(ResponseConfig)({ delay })).delay).toEqual(delay)
+        const normal = { distribution: "normal", mean: 100, stddev: 30 }
+        expect((yield* Schema.decodeUnknown(ResponseConfig)({ delay: normal })).delay).toEqual(normal)
+        const zero = yield* Effect.flip(
+          Schema.decodeUnknown(ResponseConfig)({ delay: { distribution: "lognormal", mean: 0, stddev: 10 } })
+        )
+        expect(zero.message).toContain("lognormal delay mean must be above 0")
+        yield* Effect.flip(
+          Schema.decodeUnknown(ResponseConfig)({ delay: { ...normal, tail: { percentile: 100, delay: 10 } } })
+        )
+      }))
+
+    it.effect("accepts only body files inside the fixtures directory", () =>
+      Effect.gen(function*() {
+        const config = yield* Schema.decodeUnknown(ResponseConfig)({ bodyFile: "orders/page-1.json" })
+        expect(config.bodyFile).toBe("orders/page-1.json")
+        for (const bodyFile of ["/etc/passwd", "../secrets.json", "orders/../../x", "C:\\data.json", ""]) {
+          yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)({ bodyFile }))
+        }
+      }))
+
+    it.effect("rejects a proxy pathRewrite pattern that does not compile", () =>
+      Effect.gen(function*() {
+        const proxy = (pattern: string) => ({
+          proxy: { targetUrl: "http://upstream", pathRewrite: { pattern, replacement: "/api/$1" } }
+        })
+        yield* Schema.decodeUnknown(ResponseConfig)(proxy("^/v2/(.*)$"))
+        const result = yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)(proxy("^/v2/(.*$")))
+        expect(result.message).toContain("Invalid regex")
+      }))
+
+    it.effect("rejects a scrub rule pattern that does not compile", () =>
+      Effect.gen(function*() {
+        const proxy = (pattern: string) => ({ proxy: { targetUrl: "http://upstream", scrub: [{ pattern }] } })
+        yield* Schema.decodeUnknown(ResponseConfig)(proxy("\\d{4}"))
+        const result = yield* Effect.flip(Schema.decodeUnknown(ResponseConfig)(proxy("(?<card>\\d+")))
+        expect(result.message).toContain("Invalid regex")
+      }))
   })
 
   describe("Predicate", () => {
@@ -38,6 +156,19 @@ describe("StubSchema", () => {
         expect(predicate.caseSensitive).toBe(true)
       }))
 
+    it.effect("decodes negate and leaves it unset by default", () =>
+      Effect.gen(function*() {
+        const predicate = yield* Schema.decodeUnknown(Predicate)({
+          field: "headers",
+          operator: "exists",
+          value: { authorization: true },
+          negate: true
+        })
+        expect(predicate.negate).toBe(true)
+        const plain = yield* Schema.decodeUnknown(Predicate)({ field: "path", operator: "equals", value: "/" })
+        expect(plain.negate).toBeUndefined()
+      }))
+
     it.effect("rejects invalid operator", () =>
       Effect.gen(function*() {
         const result = yield* Effect.flip(
@@ -49,6 +180,162 @@ describe("StubSchema", () => {
         )
         expect(result._tag).toBe("ParseError")
       }))
+
+    it.effect("rejects a matches predicate with an invalid regex", () =>
+      Effect.gen(function*() {
+        const result = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "path", operator: "matches", value: "^/orders/(?<id>\\d+$" })
+        )
+        expect(result.message).toContain("Invalid regex")
+        const headers = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "headers", operator: "matches", value: { accept: "[" } })
+        )
+        expect(headers._tag).toBe("ParseError")
+      }))
+
+    it.effect("requires an object of strings for headers and query predicates", () =>
+      Effect.gen(function*() {
+        const result = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "query", operator: "equals", value: "status=active" })
+        )
+        expect(result.message).toContain("query value must be an object of string values")
+        const nonString = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "headers", operator: "equals", value: { "x-api-version": 2 } })
+        )
+        expect(nonString._tag).toBe("ParseError")
+        const exists = yield* Schema.decodeUnknown(Predicate)({
+          field: "query",
+          operator: "exists",
+          value: { debug: true }
+        })
+        expect(exists.operator).toBe("exists")
+      }))
+
+    it.effect("requires a host name string for host predicates", () =>
+      Effect.gen(function*() {
+        const host = yield* Schema.decodeUnknown(Predicate)({
+          field: "host",
+          operator: "equals",
+          value: "api.example.com"
+        })
+        expect(host.field).toBe("host")
+        const nonString = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "host", operator: "equals", value: { host: "api.example.com" } })
+        )
+        expect(nonString.message).toContain("host value must be a string")
+        const template = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "host", operator: "template", value: "{tenant}.example.com" })
+        )
+        expect(template.message).toContain("template cannot be used with host")
+      }))
+
+    it.effect("validates bodySize and encoding predicates", () =>
+      Effect.gen(function*() {
+        const decode = Schema.decodeUnknown(Predicate)
+        yield* decode({ field: "bodySize", operator: "between", value: { min: 1, max: 1024 } })
+        yield* decode({ field: "bodySize", operator: "equals", value: 0 })
+        yield* decode({ field: "encoding", operator: "equals", value: "gzip" })
+        const badRange = yield* Effect.flip(decode({ field: "bodySize", operator: "between", value: { max: -1 } }))
+        expect(badRange.message).toContain("bodySize range must be")
+        const badOperator = yield* Effect.flip(decode({ field: "bodySize", operator: "contains", value: "1" }))
+        expect(badOperator.message).toContain("contains cannot be used with bodySize")
+        const elsewhere = yield* Effect.flip(decode({ field: "body", operator: "between", value: { max: 1 } }))
+        expect(elsewhere.message).toContain("between only applies to the bodySize field")
+        const encoding = yield* Effect.flip(decode({ field: "encoding", operator: "equals", value: ["gzip"] }))
+        expect(encoding.message).toContain("encoding value must be a string, e.g. \"gzip\"")
+        const contentType = yield* Effect.flip(decode({ field: "contentType", operator: "template", value: "a/{b}" }))
+        expect(contentType.message).toContain("template cannot be used with contentType")
+        const queryString = yield* Effect.flip(decode({ field: "queryString", operator: "equals", value: { a: "1" } }))
+        expect(queryString.message).toContain("queryString value must be a string")
+      }))
+
+    it.effect("validates authorization predicates", () =>
+      Effect.gen(function*() {
+        const decode = Schema.decodeUnknown(Predicate)
+        yield* decode({ field: "authorization", operator: "verifies", value: { scheme: "digest", users: { a: "b" } } })
+        yield* decode({ field: "authorization", operator: "exists", value: true })
+        const badScheme = yield* Effect.flip(
+          decode({ field: "authorization", operator: "verifies", value: { scheme: "basic", users: {} } })
+        )
+        expect(badScheme.message).toContain("verifies value must be")
+        const badOperator = yield* Effect.flip(decode({ field: "authorization", operator: "equals", value: "x" }))
+        expect(badOperator.message).toContain("equals cannot be used with authorization")
+        const elsewhere = yield* Effect.flip(
+          decode({ field: "headers", operator: "verifies", value: { scheme: "sigv4", keys: {} } })
+        )
+        expect(elsewhere.message).toContain("verifies only applies to the authorization field")
+      }))
+
+    it.effect("restricts JSON operators to valid documents on the body", () =>
+      Effect.gen(function*() {
+        const onPath = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "path", operator: "equalToJson", value: {} })
+        )
+        expect(onPath.message).toContain("equalToJson only applies to the body field")
+        const badText = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "body", operator: "matchesJson", value: "{ id: 1" })
+        )
+        expect(badText.message).toContain("Invalid JSON document")
+      }))
+
+    it.effect("validates xpath selectors", () =>
+      Effect.gen(function*() {
+        const predicate = yield* Schema.decodeUnknown(Predicate)({
+          field: "body",
+          operator: "equals",
+          value: "42",
+          xpath: "//soap:Body/GetUser/id"
+        })
+        expect(predicate.xpath).toBe("//soap:Body/GetUser/id")
+        const onPath = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "path", operator: "exists", value: true, xpath: "//id" })
+        )
+        expect(onPath.message).toContain("xpath only applies to the body field")
+        const withJson = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "body", operator: "matchesJson", value: {}, xpath: "//id" })
+        )
+        expect(withJson.message).toContain("matchesJson cannot be combined with xpath")
+        const badPath = yield* Effect.flip(
+          Schema.decodeUnknown(Predicate)({ field: "body", operator: "exists", value: true, xpath: "//id[@" })
+        )
+        expect(badPath.message).toContain("Invalid xpath")
+      }))
+
+    it.effect("accepts named capture groups in matches predicates", () =>
+      Effect.gen(function*() {
+        const predicate = yield* Schema.decodeUnknown(Predicate)({
+          field: "path",
+          operator: "matches",
+          value: "^/legacy/(?<section>[a-z]+)/item_(?<itemId>\\d+)\\.do$"
+        })
+        expect(predicate.operator).toBe("matches")
+      }))
+  })
+
+  describe("PredicateExpression", () => {
+    it.effect("decodes nested and/or/not with field defaults", () =>
+      Effect.gen(function*() {
+        const expr = yield* Schema.decodeUnknown(PredicateExpression)({
+          or: [
+            { field: "path", operator: "equals", value: "/a" },
+            { not: { and: [{ field: "method", operator: "equals", value: "GET" }] } }
+          ]
+        })
+        expect(expr).toEqual({
+          or: [
+            { field: "path", operator: "equals", value: "/a", caseSensitive: true },
+            { not: { and: [{ field: "method", operator: "equals", value: "GET", caseSensitive: true }] } }
+          ]
+        })
+      }))
+
+    it.effect("rejects invalid nested predicates", () =>
+      Effect.gen(function*() {
+        const result = yield* Effect.flip(
+          Schema.decodeUnknown(PredicateExpression)({ and: [{ field: "nope", operator: "equals" }] })
+        )
+        expect(result._tag).toBe("ParseError")
+      }))
   })
 
   describe("Stub", () => {
@@ -65,6 +352,13 @@ describe("StubSchema", () => {
         expect(stub.responseMode).toBe("sequential")
       }))
 
+    it.effect("accepts an integer priority", () =>
+      Effect.gen(function*() {
+        const stub = yield* Schema.decodeUnknown(Stub)({ id: "s", predicates: [], responses: [{}], priority: -5 })
+        expect(stub.priority).toBe(-5)
+        yield* Effect.flip(Schema.decodeUnknown(Stub)({ id: "s", predicates: [], responses: [{}], priority: 1.5 }))
+      }))
+
     it.effect("rejects empty responses array", () =>
       Effect.gen(function*() {
         const result = yield* Effect.flip(
@@ -87,5 +381,14 @@ describe("StubSchema", () => {
         expect(request.predicates).toEqual([])
         expect(request.responseMode).toBe("sequential")
       }))
+
+    it.effect("refuses bodyRef, which only a document's bodies resolve", () =>
+      Effect.gen(function*() {
+        const responses = [{ bodyRef: `sha256:${"0".repeat(64)}` }]
+        expect((yield* Effect.flip(Schema.decodeUnknown(CreateStubRequest)({ responses })))._tag).toBe("ParseError")
+        expect((yield* Effect.flip(Schema.decodeUnknown(UpdateStubRequest)({ responses })))._tag).toBe("ParseError")
+        const request = yield* Schema.decodeUnknown(DocumentStubRequest)({ responses })
+        expect(request.responses[0].bodyRef).toBe(responses[0]!.bodyRef)
+      }))
   })
 })
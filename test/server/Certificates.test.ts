@@ -0,0 +1,75 @@
+import { createCertificateAuthority, issueCertificate } from "imposters/server/Certificates"
+import { X509Certificate } from "node:crypto"
+import * as https from "node:https"
+import type { AddressInfo } from "node:net"
+import { describe, expect, it } from "vitest"
+
+const ca = createCertificateAuthority("Test CA")
+
+describe("createCertificateAuthority", () => {
+  it("creates a self-signed CA", () => {
+    const cert = new X509Certificate(ca.cert)
+    expect(cert.ca).toBe(true)
+    expect(cert.subject).toBe("O=Imposters\nCN=Test CA")
+    expect(cert.issuer).toBe(cert.subject)
+    expect(cert.verify(cert.publicKey)).toBe(true)
+  })
+
+  it("honours validityDays from now", () => {
+    const now = new Date("2030-01-01T00:00:00Z")
+    const cert = new X509Certificate(createCertificateAuthority("Short", { now, validityDays: 1 }).cert)
+    expect(new Date(cert.validFrom).toISOString()).toBe("2029-12-31T23:00:00.000Z")
+    expect(new Date(cert.validTo).toISOString()).toBe("2030-01-02T00:00:00.000Z")
+  })
+
+  it("encodes dates from 2050 on as GeneralizedTime", () => {
+    const cert = new X509Certificate(createCertificateAuthority("Long", { validityDays: 36500 }).cert)
+    expect(new Date(cert.validTo).getUTCFullYear()).toBeGreaterThan(2100)
+  })
+
+  it("encodes a serial drawn with a leading zero byte minimally", () => {
+    const serial = Buffer.alloc(16, 0x11)
+    serial[0] = 0x00
+    const cert = new X509Certificate(createCertificateAuthority("Zero", { serial }).cert)
+    expect(cert.serialNumber).toBe("01" + "11".repeat(15))
+  })
+})
+
+describe("issueCertificate", () => {
+  const leaf = issueCertificate(ca, ["api.example.com", "*.internal.test", "127.0.0.1", "::1"])
+  const cert = new X509Certificate(leaf.cert)
+
+  it("is signed by the CA for the given names and addresses", () => {
+    expect(cert.ca).toBe(false)
+    expect(cert.checkIssued(new X509Certificate(ca.cert))).toBe(true)
+    expect(cert.verify(new X509Certificate(ca.cert).publicKey)).toBe(true)
+    expect(cert.subjectAltName).toBe(
+      "DNS:api.example.com, DNS:*.internal.test, IP Address:127.0.0.1, IP Address:0:0:0:0:0:0:0:1"
+    )
+    expect(cert.checkHost("db.internal.test")).toBe("*.internal.test")
+    expect(cert.checkIP("::1")).toBe("::1")
+    expect(cert.checkHost("other.example.com")).toBeUndefined()
+  })
+
+  it("is valid for 397 days", () => {
+    const days = (Date.parse(cert.validTo) - Date.parse(cert.validFrom)) / 86_400_000
+    expect(Math.round(days * 24)).toBe(397 * 24 + 1)
+  })
+
+  it("is accepted by a client that trusts the CA", async () => {
+    const server = https.createServer(leaf, (_req, res) => res.end("ok"))
+    await new Promise<void>((resolve) => server.listen(0, resolve))
+    const { port } = server.address() as AddressInfo
+    try {
+      const status = await new Promise<number | undefined>((resolve, reject) =>
+        https.get({ host: "127.0.0.1", port, servername: "db.internal.test", ca: ca.cert }, (res) => {
+          res.resume()
+          resolve(res.statusCode)
+        }).on("error", reject)
+      )
+      expect(status).toBe(200)
+    } finally {
+      server.close()
+    }
+  })
+})
@@ -0,0 +1,30 @@
+import { CHAOS_HEADER, chaosFor, chaosResponse, injectsError } from "imposters/server/Chaos"
+import { describe, expect, it } from "vitest"
+
+const chaos = { errorRate: 0.25, errorStatus: 503, extraLatencyMs: 0 }
+
+describe("chaosFor", () => {
+  it("applies to every imposter unless limited to some", () => {
+    expect(chaosFor(chaos, "orders")).toBe(chaos)
+    expect(chaosFor({ ...chaos, imposters: ["payments"] }, "orders")).toBeUndefined()
+    expect(chaosFor({ ...chaos, imposters: ["payments"] }, "payments")).toBeDefined()
+    expect(chaosFor(undefined, "orders")).toBeUndefined()
+  })
+})
+
+describe("injectsError", () => {
+  it("fails the share of requests errorRate asks for", () => {
+    expect(injectsError(chaos, 0.1)).toBe(true)
+    expect(injectsError(chaos, 0.25)).toBe(false)
+    expect(injectsError({ ...chaos, errorRate: 0 }, 0)).toBe(false)
+  })
+})
+
+describe("chaosResponse", () => {
+  it("answers with errorStatus, marked as injected", async () => {
+    const response = chaosResponse({ ...chaos, errorStatus: 500 })
+    expect(response.status).toBe(500)
+    expect(response.headers.get(CHAOS_HEADER)).toBe("error")
+    expect(await response.json()).toEqual({ error: "Injected failure", status: 500 })
+  })
+})
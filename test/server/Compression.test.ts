@@ -0,0 +1,49 @@
+import { acceptsGzip, compressResponse } from "imposters/server/Compression"
+import { gunzipSync } from "node:zlib"
+import { describe, expect, it } from "vitest"
+
+const largeJson = JSON.stringify({ items: Array.from({ length: 200 }, (_, i) => ({ id: i, name: `item-${i}` })) })
+
+const request = (acceptEncoding?: string) =>
+  new Request(
+    "http://localhost/imposters",
+    acceptEncoding !== undefined ? { headers: { "accept-encoding": acceptEncoding } } : {}
+  )
+
+const jsonResponse = (body: string) => new Response(body, { headers: { "content-type": "application/json" } })
+
+describe("acceptsGzip", () => {
+  it("parses Accept-Encoding with quality values", () => {
+    expect(acceptsGzip("gzip, deflate, br")).toBe(true)
+    expect(acceptsGzip("br;q=1.0, gzip;q=0.5")).toBe(true)
+    expect(acceptsGzip("gzip;q=0")).toBe(false)
+    expect(acceptsGzip("*")).toBe(true)
+    expect(acceptsGzip("identity")).toBe(false)
+    expect(acceptsGzip(null)).toBe(false)
+  })
+})
+
+describe("compressResponse", () => {
+  it("gzips large JSON bodies when negotiated", async () => {
+    const res = await compressResponse(request("gzip"), jsonResponse(largeJson))
+    expect(res.headers.get("content-encoding")).toBe("gzip")
+    expect(res.headers.get("vary")).toBe("accept-encoding")
+    const body = Buffer.from(await res.arrayBuffer())
+    expect(gunzipSync(body).toString()).toBe(largeJson)
+  })
+
+  it("leaves the body alone without Accept-Encoding", async () => {
+    const res = await compressResponse(request(), jsonResponse(largeJson))
+    expect(res.headers.get("content-encoding")).toBeNull()
+    expect(await res.text()).toBe(largeJson)
+  })
+
+  it("skips small bodies and event streams", async () => {
+    const small = await compressResponse(request("gzip"), jsonResponse("{\"ok\":true}"))
+    expect(small.headers.get("content-encoding")).toBeNull()
+    expect(await small.text()).toBe("{\"ok\":true}")
+
+    const stream = new Response("data: x\n\n", { headers: { "content-type": "text/event-stream" } })
+    expect(await compressResponse(request("gzip"), stream)).toBe(stream)
+  })
+})
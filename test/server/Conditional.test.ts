@@ -0,0 +1,75 @@
+import { entityTag, serveConditional, tagsMatch } from "imposters/server/Conditional"
+import { describe, expect, it } from "vitest"
+
+const body = new TextEncoder().encode("{\"id\":1}")
+const etag = entityTag(body)
+
+const serve = (method: string, request: Record<string, string>, headers: Record<string, string> = {}, status = 200) =>
+  serveConditional(
+    method,
+    new Headers(request),
+    status,
+    body,
+    new Headers({ "content-type": "application/json", ...headers })
+  )
+
+describe("entityTag", () => {
+  it("is a quoted digest of the body", () => {
+    expect(etag).toMatch(/^"[\w-]{22}"$/)
+    expect(entityTag(new TextEncoder().encode("{\"id\":2}"))).not.toBe(etag)
+  })
+})
+
+describe("tagsMatch", () => {
+  it("compares weakly for If-None-Match and strongly for If-Match", () => {
+    expect(tagsMatch("W/\"a\"", "\"a\"", true)).toBe(true)
+    expect(tagsMatch("W/\"a\"", "\"a\"", false)).toBe(false)
+    expect(tagsMatch("\"a\"", "W/\"a\"", false)).toBe(false)
+    expect(tagsMatch("\"x\", \"a\"", "\"a\"", false)).toBe(true)
+    expect(tagsMatch("*", "\"a\"", false)).toBe(true)
+  })
+})
+
+describe("serveConditional", () => {
+  it("adds an ETag to a plain request", () => {
+    const result = serve("GET", {})
+    expect(result.status).toBe(200)
+    expect(result.headers.get("etag")).toBe(etag)
+    expect(result.body).toBe(body)
+  })
+
+  it("answers a matching If-None-Match with 304 to GET and 412 to writes", () => {
+    const get = serve("GET", { "if-none-match": `W/${etag}` })
+    expect(get.status).toBe(304)
+    expect(get.body.byteLength).toBe(0)
+    expect(get.headers.get("etag")).toBe(etag)
+    expect(serve("PUT", { "if-none-match": "*" }).status).toBe(412)
+    expect(serve("GET", { "if-none-match": "\"stale\"" }).status).toBe(200)
+  })
+
+  it("fails If-Match with another tag", () => {
+    expect(serve("PUT", { "if-match": "\"stale\"" }).status).toBe(412)
+    expect(serve("PUT", { "if-match": etag }).status).toBe(200)
+  })
+
+  it("keeps an ETag the response already has", () => {
+    const result = serve("GET", { "if-none-match": "\"v7\"" }, { etag: "\"v7\"" })
+    expect(result.status).toBe(304)
+  })
+
+  it("compares dates against Last-Modified", () => {
+    const headers = { "last-modified": "Wed, 01 Jan 2025 00:00:00 GMT" }
+    expect(serve("GET", { "if-modified-since": "Wed, 01 Jan 2025 00:00:00 GMT" }, headers).status).toBe(304)
+    expect(serve("GET", { "if-modified-since": "Tue, 31 Dec 2024 00:00:00 GMT" }, headers).status).toBe(200)
+    expect(serve("PUT", { "if-unmodified-since": "Tue, 31 Dec 2024 00:00:00 GMT" }, headers).status).toBe(412)
+    // If-None-Match takes precedence over If-Modified-Since
+    expect(serve("GET", { "if-none-match": "\"other\"", "if-modified-since": "Thu, 01 Jan 2026 00:00:00 GMT" }, headers)
+      .status).toBe(200)
+  })
+
+  it("leaves non-2xx responses alone", () => {
+    const result = serve("GET", { "if-none-match": "*" }, {}, 404)
+    expect(result.status).toBe(404)
+    expect(result.headers.has("etag")).toBe(false)
+  })
+})
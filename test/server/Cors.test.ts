@@ -0,0 +1,72 @@
+import * as Schema from "effect/Schema"
+import { CorsRule } from "imposters/schemas/CorsSchema"
+import { corsRuleFor, isPreflight, preflightResponse, withCors } from "imposters/server/Cors"
+import { describe, expect, it } from "vitest"
+
+const rule = (input: unknown) => Schema.decodeUnknownSync(CorsRule)(input)
+
+const preflight = {
+  origin: "http://localhost:5173",
+  "access-control-request-method": "PUT",
+  "access-control-request-headers": "authorization, content-type"
+}
+
+describe("corsRuleFor", () => {
+  it("picks the first rule whose exact path or prefix covers the path", () => {
+    const rules = [rule({ path: "/health" }), rule({ path: "/api/*", credentials: true }), rule({})]
+    expect(corsRuleFor(rules, "/health")).toBe(rules[0])
+    expect(corsRuleFor(rules, "/api/orders")).toBe(rules[1])
+    expect(corsRuleFor(rules, "/healthz")).toBe(rules[2])
+    expect(corsRuleFor(rules.slice(0, 2), "/other")).toBeUndefined()
+  })
+})
+
+describe("preflightResponse", () => {
+  it("recognises preflights by their Origin and Access-Control-Request-Method", () => {
+    expect(isPreflight("OPTIONS", preflight)).toBe(true)
+    expect(isPreflight("OPTIONS", { origin: "http://localhost:5173" })).toBe(false)
+    expect(isPreflight("PUT", preflight)).toBe(false)
+  })
+
+  it("offers the stubs' methods and echoes the requested headers by default", () => {
+    const response = preflightResponse(rule({}), preflight, ["GET", "PUT"])
+    expect(response.status).toBe(204)
+    expect(response.headers.get("access-control-allow-origin")).toBe("*")
+    expect(response.headers.get("access-control-allow-methods")).toBe("GET, PUT")
+    expect(response.headers.get("access-control-allow-headers")).toBe("authorization, content-type")
+    expect(response.headers.get("access-control-allow-credentials")).toBeNull()
+  })
+
+  it("answers with the rule's settings, echoing the origin when credentials are allowed", () => {
+    const response = preflightResponse(
+      rule({ methods: ["GET"], headers: ["x-api-key"], credentials: true, maxAge: 600 }),
+      preflight,
+      ["GET", "PUT"]
+    )
+    expect(response.headers.get("access-control-allow-origin")).toBe("http://localhost:5173")
+    expect(response.headers.get("access-control-allow-methods")).toBe("GET")
+    expect(response.headers.get("access-control-allow-headers")).toBe("x-api-key")
+    expect(response.headers.get("access-control-allow-credentials")).toBe("true")
+    expect(response.headers.get("access-control-max-age")).toBe("600")
+  })
+
+  it("leaves out the Access-Control headers for an origin the rule doesn't allow", () => {
+    const response = preflightResponse(rule({ origins: ["https://app.example.com"] }), preflight, ["PUT"])
+    expect(response.status).toBe(204)
+    expect(response.headers.get("access-control-allow-origin")).toBeNull()
+    expect(response.headers.get("access-control-allow-methods")).toBeNull()
+    expect(response.headers.get("vary")).toBe("Origin")
+  })
+})
+
+describe("withCors", () => {
+  it("adds the rule's headers to a served response, keeping its own", async () => {
+    const served = new Response("ok", { status: 201, headers: { "x-request-id": "42", vary: "Accept" } })
+    const response = withCors(rule({ exposeHeaders: ["x-request-id"] }), "http://localhost:5173", served)
+    expect(response.status).toBe(201)
+    expect(await response.text()).toBe("ok")
+    expect(response.headers.get("access-control-allow-origin")).toBe("*")
+    expect(response.headers.get("access-control-expose-headers")).toBe("x-request-id")
+    expect(response.headers.get("vary")).toBe("Accept, Origin")
+  })
+})
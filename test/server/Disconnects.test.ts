@@ -0,0 +1,40 @@
+import { progressiveResponses } from "imposters/server/Drip"
+import { watchBody } from "imposters/server/Disconnects"
+import { describe, expect, it } from "vitest"
+
+const streamOf = (...chunks: Array<string>) => {
+  const encoder = new TextEncoder()
+  let next = 0
+  return new ReadableStream<Uint8Array>({
+    pull: (controller) => {
+      const chunk = chunks[next++]
+      if (chunk === undefined) controller.close()
+      else controller.enqueue(encoder.encode(chunk))
+    }
+  })
+}
+
+describe("watchBody", () => {
+  it("reports everything sent once the body ends", async () => {
+    const done: Array<[string, boolean]> = []
+    const served = new Response(streamOf("a", "bc"), { status: 201, headers: { "x-id": "1" } })
+    const watched = watchBody(served, (sent, disconnected) => done.push([new TextDecoder().decode(sent), disconnected]))
+    expect(watched.status).toBe(201)
+    expect(watched.headers.get("x-id")).toBe("1")
+    expect(await watched.text()).toBe("abc")
+    expect(done).toEqual([["abc", false]])
+  })
+
+  it("reports what went out before the client went away", async () => {
+    const done: Array<[string, boolean]> = []
+    const served = new Response(streamOf("first", "second", "third"))
+    progressiveResponses.add(served)
+    const watched = watchBody(served, (sent, disconnected) => done.push([new TextDecoder().decode(sent), disconnected]))
+    expect(progressiveResponses.has(watched)).toBe(true)
+
+    const reader = watched.body!.getReader()
+    await reader.read()
+    await reader.cancel()
+    expect(done).toEqual([["first", true]])
+  })
+})
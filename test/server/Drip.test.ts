@@ -0,0 +1,27 @@
+import { dripResponse, progressiveResponses } from "imposters/server/Drip"
+import { describe, expect, it } from "vitest"
+
+describe("dripResponse", () => {
+  const body = new TextEncoder().encode("0123456789")
+
+  it("sends the body a few bytes at a time over the duration", async () => {
+    const response = dripResponse(body, { duration: 200, chunkBytes: 4 }, { status: 200 })
+    expect(response.headers.get("content-length")).toBe("10")
+    expect(progressiveResponses.has(response)).toBe(true)
+
+    const started = Date.now()
+    const reader = response.body!.pipeThrough(new TextDecoderStream()).getReader()
+    const chunks: Array<string> = []
+    for (let chunk = await reader.read(); !chunk.done; chunk = await reader.read()) chunks.push(chunk.value)
+    expect(chunks).toEqual(["0123", "4567", "89"])
+    expect(Date.now() - started).toBeGreaterThanOrEqual(190)
+  })
+
+  it("stops sending when the client goes away", async () => {
+    const response = dripResponse(body, { duration: 10000, chunkBytes: 1 }, { status: 200 })
+    const reader = response.body!.getReader()
+    await reader.read()
+    await reader.cancel()
+    expect((await reader.read()).done).toBe(true)
+  })
+})
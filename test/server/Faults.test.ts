@@ -0,0 +1,74 @@
+import { applyFault, garbageBytes, rawResponses, truncationOffset } from "imposters/server/Faults"
+import { describe, expect, it } from "vitest"
+
+describe("truncationOffset", () => {
+  it("cuts at a byte offset, capped at the body length", () => {
+    expect(truncationOffset({ type: "truncate", bytes: 5 }, 20)).toBe(5)
+    expect(truncationOffset({ type: "truncate", bytes: 50 }, 20)).toBe(20)
+  })
+
+  it("cuts at a percentage of the body", () => {
+    expect(truncationOffset({ type: "truncate", percent: 50 }, 21)).toBe(10)
+    expect(truncationOffset({ type: "truncate", percent: 0 }, 21)).toBe(0)
+  })
+})
+
+describe("applyFault", () => {
+  const body = JSON.stringify({ message: "hello world" })
+  const init = { status: 200, headers: new Headers({ "content-type": "application/json" }) }
+
+  it("keeps the status and full content-length but fails the body after the offset", async () => {
+    const { response, sentBody } = applyFault({ type: "truncate", bytes: 8 }, body, init)
+    expect(response.status).toBe(200)
+    expect(response.headers.get("content-length")).toBe(String(body.length))
+    expect(sentBody).toBe(body.slice(0, 8))
+
+    const reader = response.body!.getReader()
+    const first = await reader.read()
+    expect(new TextDecoder().decode(first.value)).toBe(body.slice(0, 8))
+    await expect(reader.read()).rejects.toThrow("truncated after 8 of")
+  })
+
+  it("fails immediately when nothing is to be sent", async () => {
+    const { response, sentBody } = applyFault({ type: "truncate", percent: 0 }, body, init)
+    expect(sentBody).toBe("")
+    await expect(response.text()).rejects.toThrow()
+  })
+
+  it("fails a connection_reset body before anything is read", async () => {
+    const { response, sentBytes } = applyFault({ type: "connection_reset" }, body, init)
+    expect(sentBytes.byteLength).toBe(0)
+    await expect(response.text()).rejects.toThrow("Connection reset")
+  })
+
+  // What a fault writes to the socket, as text
+  const rawOf = (response: Response) => new TextDecoder().decode(rawResponses.get(response))
+
+  it("cuts truncated_json short under a Content-Length that matches", () => {
+    const { response, sentBody } = applyFault({ type: "truncated_json", percent: 50 }, body, init)
+    const cut = body.slice(0, Math.floor(body.length / 2))
+    expect(sentBody).toBe(cut)
+    expect(rawOf(response)).toBe(
+      `HTTP/1.1 200 OK\r\ncontent-type: application/json\r\ncontent-length: ${cut.length}\r\n` +
+        `connection: close\r\n\r\n${cut}`
+    )
+  })
+
+  it("sends the whole body under a wrong Content-Length", () => {
+    const { response } = applyFault({ type: "wrong_content_length", offset: -5 }, body, init)
+    expect(rawOf(response)).toContain(`content-length: ${body.length - 5}\r\n`)
+    expect(rawOf(response).endsWith(`\r\n\r\n${body}`)).toBe(true)
+  })
+
+  it("frames invalid_chunked with a chunk size that isn't hexadecimal", () => {
+    const { response } = applyFault({ type: "invalid_chunked" }, body, init)
+    expect(rawOf(response)).toContain(`transfer-encoding: chunked\r\nconnection: close\r\n\r\nzz\r\n${body}\r\n0`)
+  })
+
+  it("sends the same garbage every time in place of the response", () => {
+    const { response, sentBytes } = applyFault({ type: "garbage", bytes: 16 }, body, init)
+    expect(rawResponses.get(response)).toEqual(garbageBytes(16))
+    expect(sentBytes).toEqual(garbageBytes(16))
+    expect(new TextDecoder().decode(garbageBytes(16))).not.toMatch(/^HTTP/)
+  })
+})
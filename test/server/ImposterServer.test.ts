@@ -1,18 +1,29 @@
+import * as ConfigProvider from "effect/ConfigProvider"
 import * as DateTime from "effect/DateTime"
 import * as Effect from "effect/Effect"
 import * as Layer from "effect/Layer"
 import * as ManagedRuntime from "effect/ManagedRuntime"
 import * as Schema from "effect/Schema"
 import { ImposterConfig } from "imposters/domain/imposter"
+import { expandPreset } from "imposters/presets/Presets"
 import { ImposterRepository, ImposterRepositoryLive } from "imposters/repositories/ImposterRepository"
+import { PresetConfig } from "imposters/schemas/PresetSchema"
 import { Stub } from "imposters/schemas/StubSchema"
 import { FiberManagerLive } from "imposters/server/FiberManager"
 import { ImposterServer, ImposterServerLive } from "imposters/server/ImposterServer"
+import { issueShare } from "imposters/server/Shares"
+import { CallbackServiceLive } from "imposters/services/CallbackService"
+import { EventBus, EventBusLive } from "imposters/services/EventBus"
 import { MetricsServiceLive } from "imposters/services/MetricsService"
 import { ProxyServiceLive } from "imposters/services/ProxyService"
 import { RequestLoggerLive } from "imposters/services/RequestLogger"
 import { UuidLive } from "imposters/services/UuidLive"
+import { VariablesLive } from "imposters/services/Variables"
 import { NodeServerFactoryLive } from "imposters/test/helpers/NodeServerFactory"
+import * as fs from "node:fs"
+import * as https from "node:https"
+import * as path from "node:path"
+import type { TLSSocket } from "node:tls"
 import { afterAll, describe, expect, it } from "vitest"
 
 const makeConfig = (id: string, port: number): ImposterConfig =>
@@ -36,6 +47,8 @@ const makeStub = (id: string, method: string, path: string, status = 200, body?:
   })
 
 const ProxyServiceWithDeps = ProxyServiceLive.pipe(Layer.provide(UuidLive))
+const EventBusWithDeps = EventBusLive.pipe(Layer.provide(UuidLive))
+const CallbackServiceWithDeps = CallbackServiceLive.pipe(Layer.provide(UuidLive))
 
 const TestLayer = ImposterServerLive.pipe(
   Layer.provide(
@@ -45,7 +58,10 @@ const TestLayer = ImposterServerLive.pipe(
       NodeServerFactoryLive,
       RequestLoggerLive,
       MetricsServiceLive,
-      ProxyServiceWithDeps
+      ProxyServiceWithDeps,
+      EventBusWithDeps,
+      CallbackServiceWithDeps,
+      VariablesLive
     )
   )
 )
@@ -53,18 +69,40 @@ const TestLayer = ImposterServerLive.pipe(
 const FullLayer = Layer.mergeAll(
   ImposterRepositoryLive,
   FiberManagerLive,
+  EventBusWithDeps,
   TestLayer
 )
 
 const runtime = ManagedRuntime.make(FullLayer)
 afterAll(() => runtime.dispose())
 
-type Deps = ImposterRepository | ImposterServer
+type Deps = ImposterRepository | ImposterServer | EventBus
 const run = <A>(effect: Effect.Effect<A, unknown, Deps>) => runtime.runPromise(effect)
 
 const fetchJson = (url: string, init?: RequestInit) =>
   fetch(url, init).then(async (r) => ({ status: r.status, body: await r.json() }))
 
+const certificate = (name: string) => {
+  const file = (ext: string) => fs.readFileSync(path.join(__dirname, "../fixtures/tls", `${name}.${ext}`), "utf8")
+  return { cert: file("crt"), key: file("key") }
+}
+
+// GET over HTTPS naming `servername` in SNI and Host; resolves the certificate's CN and the body
+const getTls = (port: number, servername: string) =>
+  new Promise<{ cn: unknown; body: string }>((resolve, reject) => {
+    const req = https.get(
+      { host: "localhost", port, path: "/", servername, headers: { host: servername }, rejectUnauthorized: false },
+      (res) => {
+        const cn = (res.socket as TLSSocket).getPeerCertificate().subject.CN
+        let body = ""
+        res.setEncoding("utf8")
+        res.on("data", (chunk) => (body += chunk))
+        res.on("end", () => resolve({ cn, body }))
+      }
+    )
+    req.on("error", reject)
+  })
+
 describe("ImposterServer", () => {
   it("start makes imposter reachable", async () => {
     await run(
@@ -172,6 +210,41 @@ describe("ImposterServer", () => {
     )
   }, 10000)
 
+  it("returns 405 with Allow when only the method is wrong", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+
+        yield* repo.create(makeConfig("imp-405-1", 9108))
+        yield* repo.addStub("imp-405-1", makeStub("get", "GET", "/users", 200))
+        yield* repo.addStub("imp-405-1", makeStub("post", "POST", "/users", 201))
+
+        yield* server.start("imp-405-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    const resp = await fetch("http://localhost:9108/users", { method: "DELETE" })
+    expect(resp.status).toBe(405)
+    expect(resp.headers.get("allow")).toBe("GET, POST")
+    expect(await resp.json()).toEqual({
+      error: "Method not allowed",
+      method: "DELETE",
+      path: "/users",
+      allow: ["GET", "POST"]
+    })
+    expect((await fetch("http://localhost:9108/orders", { method: "DELETE" })).status).toBe(404)
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-405-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
   it("updateStubs hot-reloads without restart", async () => {
     await run(
       Effect.gen(function*() {
@@ -275,4 +348,432 @@ describe("ImposterServer", () => {
       })
     )
   }, 10000)
+
+  it("streams Server-Sent Events as they fall due", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+
+        yield* repo.create(makeConfig("imp-sse-1", 9110))
+        yield* repo.addStub(
+          "imp-sse-1",
+          Schema.decodeUnknownSync(Stub)({
+            id: "sse-stub",
+            predicates: [],
+            responses: [{
+              type: "sse",
+              events: [{ data: "first" }, { event: "done", data: { path: "{{request.path}}" }, delay: 300 }]
+            }]
+          })
+        )
+
+        yield* server.start("imp-sse-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    const started = Date.now()
+    const response = await fetch("http://localhost:9110/feed")
+    expect(response.headers.get("content-type")).toBe("text/event-stream")
+    const reader = response.body!.pipeThrough(new TextDecoderStream()).getReader()
+    const first = await reader.read()
+    expect(first.value).toBe("data: first\n\n")
+    expect(Date.now() - started).toBeLessThan(250)
+    let rest = ""
+    for (let chunk = await reader.read(); !chunk.done; chunk = await reader.read()) rest += chunk.value
+    expect(rest).toBe("event: done\ndata: {\"path\":\"/feed\"}\n\n")
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-sse-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("journals and announces a client leaving a stream early", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+
+        yield* repo.create(makeConfig("imp-gone-1", 9117))
+        yield* repo.addStub(
+          "imp-gone-1",
+          Schema.decodeUnknownSync(Stub)({
+            id: "feed",
+            predicates: [],
+            responses: [
+              {
+                type: "sse",
+                events: [{ data: "first" }, { data: "late", delay: 5000 }],
+                onDisconnect: { reset: true }
+              },
+              { status: 200, body: "second" }
+            ]
+          })
+        )
+        yield* server.start("imp-gone-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    const abort = new AbortController()
+    const response = await fetch("http://localhost:9117/feed", { signal: abort.signal })
+    const reader = response.body!.pipeThrough(new TextDecoderStream()).getReader()
+    expect((await reader.read()).value).toBe("data: first\n\n")
+    abort.abort()
+    await new Promise((resolve) => setTimeout(resolve, 200))
+
+    const events = await run(
+      Effect.flatMap(EventBus, (bus) => bus.recent({ imposterId: "imp-gone-1", type: "client.disconnected" }))
+    )
+    expect(events).toHaveLength(1)
+    expect(events[0]!.data).toMatchObject({ path: "/feed", stubId: "feed", bytes: 13 })
+    // The reset starts the cycle over, so the stream is served again rather than the second response
+    const again = await fetch("http://localhost:9117/feed", { signal: AbortSignal.timeout(300) })
+    expect(again.headers.get("content-type")).toBe("text/event-stream")
+    await again.body!.cancel()
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-gone-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("layers chaos over matched requests until it is switched off", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+
+        yield* repo.create(makeConfig("imp-chaos-1", 9111))
+        yield* repo.addStub("imp-chaos-1", makeStub("ok", "GET", "/ok", 200, { ok: true }))
+        yield* server.start("imp-chaos-1")
+        yield* server.setChaos({ errorRate: 1, errorStatus: 502, extraLatencyMs: 100 })
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    const started = Date.now()
+    const failed = await fetch("http://localhost:9111/ok")
+    expect(failed.status).toBe(502)
+    expect(failed.headers.get("x-imposters-chaos")).toBe("error")
+    expect(Date.now() - started).toBeGreaterThanOrEqual(90)
+    expect((await fetch("http://localhost:9111/missing")).status).toBe(404)
+
+    await run(Effect.flatMap(ImposterServer, (server) => server.setChaos(undefined)))
+    expect((await fetchJson("http://localhost:9111/ok")).body).toEqual({ ok: true })
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-chaos-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("holds long polls until their key is released or they time out", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+
+        yield* repo.create(makeConfig("imp-hold-1", 9116))
+        yield* repo.addStub(
+          "imp-hold-1",
+          Schema.decodeUnknownSync(Stub)({
+            id: "poll",
+            predicates: [],
+            responses: [{ status: 200, body: { events: [] }, hold: { key: "{{request.path}}", timeout: 300 } }]
+          })
+        )
+        yield* server.start("imp-hold-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    const started = Date.now()
+    const timedOut = await fetch("http://localhost:9116/feed/a")
+    expect(timedOut.status).toBe(204)
+    expect(Date.now() - started).toBeGreaterThanOrEqual(290)
+
+    const polled = fetchJson("http://localhost:9116/feed/b")
+    await new Promise((resolve) => setTimeout(resolve, 100))
+    const released = await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        const held = yield* server.held
+        const count = yield* server.release("/feed/b", { body: { events: ["{{request.path}}"] } })
+        return { held, count }
+      })
+    )
+    expect(released).toEqual({ held: { "/feed/b": 1 }, count: 1 })
+    expect(await polled).toEqual({ status: 200, body: { events: ["/feed/b"] } })
+    expect(await run(Effect.flatMap(ImposterServer, (server) => server.held))).toEqual({})
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-hold-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("moves scenarios from state to state as their stubs respond", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+
+        yield* repo.create(makeConfig("imp-scenario-1", 9118))
+        const cartStub = (id: string, method: string, scenario: unknown, body: unknown) =>
+          Schema.decodeUnknownSync(Stub)({
+            id,
+            predicates: [{ field: "method", operator: "equals", value: method }],
+            responses: [{ status: 200, body }],
+            scenario
+          })
+        const cart = (requiredState: string) => ({ name: "cart", requiredState })
+        yield* repo.addStub("imp-scenario-1", cartStub("empty", "GET", cart("Started"), { items: 0 }))
+        yield* repo.addStub("imp-scenario-1", cartStub("add", "POST", { name: "cart", newState: "filled" }, "added"))
+        yield* repo.addStub("imp-scenario-1", cartStub("filled", "GET", cart("filled"), { items: 1 }))
+        yield* server.start("imp-scenario-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    expect(await fetchJson("http://localhost:9118/cart")).toEqual({ status: 200, body: { items: 0 } })
+    await fetch("http://localhost:9118/cart", { method: "POST" })
+    expect(await fetchJson("http://localhost:9118/cart")).toEqual({ status: 200, body: { items: 1 } })
+    expect(await run(Effect.flatMap(ImposterServer, (server) => server.scenarios("imp-scenario-1")))).toEqual({
+      cart: "filled"
+    })
+    expect(await run(Effect.flatMap(ImposterServer, (server) => server.scenarioHistory("imp-scenario-1"))))
+      .toMatchObject([{ scenario: "cart", from: "Started", to: "filled", stubId: "add" }])
+
+    await run(Effect.flatMap(ImposterServer, (server) => server.resetScenarios("imp-scenario-1")))
+    expect(await fetchJson("http://localhost:9118/cart")).toEqual({ status: 200, body: { items: 0 } })
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-scenario-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("reads respondAt on the imposter clock and refuses one held past the limit", async () => {
+    const at = (iso: string) =>
+      Schema.decodeUnknownSync(Stub)({
+        id: iso,
+        predicates: [{ field: "path", operator: "equals", value: `/${iso.slice(0, 4)}` }],
+        responses: [{ status: 200, body: "sent", respondAt: { at: iso } }]
+      })
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+        yield* repo.create(makeConfig("imp-schedule-1", 9121))
+        yield* repo.addStub("imp-schedule-1", at("2030-01-01T00:00:00Z"))
+        yield* repo.addStub("imp-schedule-1", at("2031-01-01T00:00:00Z"))
+        yield* server.start("imp-schedule-1")
+        yield* server.setClock("imp-schedule-1", Date.parse("2030-01-01T00:00:01Z"))
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    expect(await fetch("http://localhost:9121/2030").then((r) => r.status)).toBe(200)
+    expect(await fetchJson("http://localhost:9121/2031")).toEqual({
+      status: 500,
+      body: { error: "respondAt is too far ahead", limitMs: 3600000 }
+    })
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-schedule-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("keeps a resource's items across requests until the imposter is reset", async () => {
+    const [todos] = expandPreset(Schema.decodeUnknownSync(PresetConfig)({ preset: "resource", path: "/todos" }))
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+        yield* repo.create(makeConfig("imp-resource-1", 9119))
+        yield* repo.addStub("imp-resource-1", Schema.decodeUnknownSync(Stub)({ id: "todos", ...todos }))
+        yield* server.start("imp-resource-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    const post = (title: string) =>
+      fetchJson("http://localhost:9119/todos", {
+        method: "POST",
+        headers: { "content-type": "application/json" },
+        body: JSON.stringify({ title })
+      })
+    expect(await post("Write tests")).toEqual({ status: 201, body: { id: 1, title: "Write tests" } })
+    expect(await post("Ship")).toEqual({ status: 201, body: { id: 2, title: "Ship" } })
+    const deleted = await fetch("http://localhost:9119/todos/1", { method: "DELETE" })
+    expect(deleted.status).toBe(204)
+    expect(await fetchJson("http://localhost:9119/todos")).toEqual({ status: 200, body: [{ id: 2, title: "Ship" }] })
+
+    await run(Effect.flatMap(ImposterServer, (server) => server.resetResponses("imp-resource-1")))
+    expect(await fetchJson("http://localhost:9119/todos")).toEqual({ status: 200, body: [] })
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-resource-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("captures request values into the store for later responses", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+        yield* repo.create(makeConfig("imp-capture-1", 9120))
+        yield* repo.addStub(
+          "imp-capture-1",
+          Schema.decodeUnknownSync(Stub)({
+            id: "create",
+            predicates: [
+              { field: "method", operator: "equals", value: "POST" },
+              { field: "path", operator: "template", value: "/orders/{orderId}" }
+            ],
+            responses: [{ status: 201, body: { saved: "{{store.get \"orderId\"}}" } }],
+            capture: { orderId: "params.orderId", customer: "body.customer.name", missing: "headers.x-absent" }
+          })
+        )
+        yield* repo.addStub(
+          "imp-capture-1",
+          Schema.decodeUnknownSync(Stub)({
+            id: "latest",
+            predicates: [{ field: "path", operator: "equals", value: "/orders/latest" }],
+            responses: [{ status: 200, body: { id: "{{store.get \"orderId\"}}", customer: "${$store.customer}" } }]
+          })
+        )
+        yield* server.start("imp-capture-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    const created = await fetchJson("http://localhost:9120/orders/42", {
+      method: "POST",
+      headers: { "content-type": "application/json" },
+      body: JSON.stringify({ customer: { name: "Ada" } })
+    })
+    expect(created).toEqual({ status: 201, body: { saved: "42" } })
+    expect(await fetchJson("http://localhost:9120/orders/latest")).toEqual({
+      status: 200,
+      body: { id: "42", customer: "Ada" }
+    })
+    expect(await run(Effect.flatMap(ImposterServer, (server) => server.store("imp-capture-1")))).toEqual({
+      orderId: "42",
+      customer: "Ada"
+    })
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-capture-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("serves HTTPS with the certificate the client's SNI names", async () => {
+    await run(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+
+        const tls = {
+          ...certificate("localhost"),
+          hosts: {
+            "api.example.com": certificate("api.example.com"),
+            "*.internal.test": certificate("wildcard.internal.test")
+          }
+        }
+        yield* repo.create({ ...makeConfig("imp-tls-1", 9109), tls })
+        yield* repo.addStub(
+          "imp-tls-1",
+          Schema.decodeUnknownSync(Stub)({
+            id: "api",
+            predicates: [{ field: "host", operator: "equals", value: "api.example.com" }],
+            responses: [{ status: 200, body: "api" }]
+          })
+        )
+        yield* repo.addStub("imp-tls-1", makeCatchAllStub("other", 200, "other"))
+
+        yield* server.start("imp-tls-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    expect(await getTls(9109, "api.example.com")).toEqual({ cn: "api.example.com", body: "api" })
+    expect(await getTls(9109, "db.internal.test")).toEqual({ cn: "*.internal.test", body: "other" })
+    expect(await getTls(9109, "localhost")).toEqual({ cn: "localhost", body: "other" })
+
+    await run(
+      Effect.gen(function*() {
+        const server = yield* ImposterServer
+        yield* server.stop("imp-tls-1")
+        yield* Effect.sleep("50 millis")
+      })
+    )
+  }, 10000)
+
+  it("guards the /_admin pages with ADMIN_TOKEN, leaving stubs open", async () => {
+    const guarded = ManagedRuntime.make(
+      Layer.mergeAll(ImposterRepositoryLive, TestLayer).pipe(
+        Layer.provide(Layer.setConfigProvider(ConfigProvider.fromMap(new Map([["ADMIN_TOKEN", "s3cret"]]))))
+      )
+    )
+    await guarded.runPromise(
+      Effect.gen(function*() {
+        const repo = yield* ImposterRepository
+        const server = yield* ImposterServer
+        yield* repo.create(makeConfig("imp-guard-1", 9122))
+        yield* repo.addStub("imp-guard-1", makeCatchAllStub("s1", 200, { ok: true }))
+        yield* server.start("imp-guard-1")
+        yield* Effect.sleep("200 millis")
+      })
+    )
+
+    try {
+      const share = issueShare("s3cret", Date.now() + 60_000)
+      const addStub = (token: string) =>
+        fetch("http://localhost:9122/_admin/stubs", {
+          method: "POST",
+          headers: { authorization: `Bearer ${token}`, "content-type": "application/x-www-form-urlencoded" },
+          body: "status=201"
+        }).then((r) => r.status)
+      expect(await fetch("http://localhost:9122/_admin").then((r) => r.status)).toBe(401)
+      expect(await fetch("http://localhost:9122/_admin?token=s3cret").then((r) => r.status)).toBe(200)
+      expect(await fetch(`http://localhost:9122/_admin?token=${share}`).then((r) => r.status)).toBe(200)
+      expect(await addStub(share)).toBe(403)
+      expect(await addStub("guess")).toBe(401)
+      expect(await fetchJson("http://localhost:9122/anything")).toEqual({ status: 200, body: { ok: true } })
+    } finally {
+      await guarded.runPromise(Effect.flatMap(ImposterServer, (server) => server.stop("imp-guard-1")))
+      await guarded.dispose()
+    }
+  }, 10000)
 })
@@ -0,0 +1,70 @@
+import * as Schema from "effect/Schema"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { PaginateConfig } from "imposters/schemas/StubSchema"
+import { encodeCursor, pageOf } from "imposters/server/Pagination"
+import { describe, expect, it } from "vitest"
+
+const config = Schema.decodeUnknownSync(PaginateConfig)({ items: [], defaultLimit: 10, maxLimit: 25 })
+const users = Array.from({ length: 42 }, (_, i) => ({ id: i + 1 }))
+
+const request = (queryString: string): RequestContext => ({
+  method: "GET",
+  path: "/users",
+  headers: {},
+  query: Object.fromEntries(new URLSearchParams(queryString)),
+  queryString,
+  body: undefined
+})
+
+const ids = (body: unknown) => (body as { items: ReadonlyArray<{ id: number }> }).items.map((u) => u.id)
+
+describe("pageOf", () => {
+  it("serves pages by number, linking the next and previous ones", () => {
+    const answer = pageOf(config, users, request("page=2&sort=name"))
+    expect(ids(answer.body)).toEqual([11, 12, 13, 14, 15, 16, 17, 18, 19, 20])
+    expect(answer.body).toMatchObject({
+      total: 42,
+      limit: 10,
+      page: 2,
+      pages: 5,
+      next: "/users?sort=name&page=3",
+      prev: "/users?sort=name&page=1"
+    })
+    expect(answer.headers).toEqual({
+      "x-total-count": "42",
+      link: "</users?sort=name&page=3>; rel=\"next\", </users?sort=name&page=1>; rel=\"prev\""
+    })
+
+    const last = pageOf(config, users, request("page=5"))
+    expect(ids(last.body)).toEqual([41, 42])
+    expect(last.body).toMatchObject({ next: null, nextCursor: null })
+    expect(ids(pageOf(config, users, request("page=9")).body)).toEqual([])
+  })
+
+  it("pages by offset or cursor, and caps the limit", () => {
+    const byOffset = pageOf(config, users, request("offset=5&limit=3"))
+    expect(ids(byOffset.body)).toEqual([6, 7, 8])
+    expect(byOffset.body).toMatchObject({ next: "/users?limit=3&offset=8", prev: "/users?limit=3&offset=2" })
+
+    const first = pageOf(config, users, request("limit=100"))
+    expect(ids(first.body)).toHaveLength(25)
+    expect(first.body).toMatchObject({ limit: 25, prev: null, nextCursor: encodeCursor(25) })
+    const second = pageOf(config, users, request(`cursor=${encodeCursor(25)}&limit=100`))
+    expect(ids(second.body)).toEqual(users.slice(25, 42).map((u) => u.id))
+    expect(second.body).toMatchObject({ next: null, prev: `/users?limit=25&cursor=${encodeCursor(0)}` })
+  })
+
+  it("refuses parameters it can't page by", () => {
+    expect(pageOf(config, users, request("limit=0"))).toEqual({
+      status: 400,
+      body: { error: "Invalid pagination parameter", param: "limit", value: "0" }
+    })
+    expect(pageOf(config, users, request("page=first")).status).toBe(400)
+    expect(pageOf(config, users, request("cursor=bogus")).status).toBe(400)
+  })
+
+  it("puts the items in the field asked for", () => {
+    const data = Schema.decodeUnknownSync(PaginateConfig)({ items: [], itemsField: "data" })
+    expect(pageOf(data, users, request("")).body).toMatchObject({ data: users.slice(0, 20) })
+  })
+})
@@ -0,0 +1,56 @@
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { cacheKey, fromCached, toCached } from "imposters/server/ProxyCache"
+import { describe, expect, it } from "vitest"
+
+const request = (method: string, path: string, queryString = "", body?: unknown): RequestContext => ({
+  method,
+  path,
+  headers: {},
+  query: Object.fromEntries(new URLSearchParams(queryString)),
+  queryString,
+  body
+})
+
+describe("proxy cache", () => {
+  it("keys answers by method, path, query and body", () => {
+    expect(cacheKey(request("GET", "/users", "page=2"))).toBe("proxy:GET /users?page=2")
+    const created = cacheKey(request("POST", "/users", "", { name: "Ada" }))
+    expect(created).toMatch(/^proxy:POST \/users sha256:[0-9a-f]{64}$/)
+    expect(cacheKey(request("POST", "/users", "", { name: "Ada" }))).toBe(created)
+    expect(cacheKey(request("POST", "/users", "", { name: "Bob" }))).not.toBe(created)
+  })
+
+  it("keeps text and binary bodies as sent", async () => {
+    const text = await toCached(
+      new Response("{\"ok\":true}", { status: 201, headers: { "content-type": "application/json", "x-id": "7" } })
+    )
+    expect(text).toEqual({
+      status: 201,
+      headers: { "content-type": "application/json", "x-id": "7" },
+      body: "{\"ok\":true}",
+      encoding: "utf8"
+    })
+    const served = fromCached(text)!
+    expect(served.status).toBe(201)
+    expect(served.headers.get("x-cache")).toBe("HIT")
+    expect(await served.json()).toEqual({ ok: true })
+
+    const bytes = new Uint8Array([0, 255, 137, 80])
+    const image = await toCached(new Response(bytes, { headers: { "content-type": "image/png" } }))
+    expect(image.encoding).toBe("base64")
+    expect(new Uint8Array(await fromCached(image)!.arrayBuffer())).toEqual(bytes)
+  })
+
+  it("serves statuses that can't carry a body without one", async () => {
+    for (const status of [204, 205, 304]) {
+      const served = fromCached({ status, headers: {}, body: "", encoding: "utf8" })!
+      expect(served.status).toBe(status)
+      expect(served.body).toBeNull()
+    }
+  })
+
+  it("ignores store values that aren't cached answers", () => {
+    expect(fromCached(undefined)).toBeUndefined()
+    expect(fromCached({ status: 200 })).toBeUndefined()
+  })
+})
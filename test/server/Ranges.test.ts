@@ -0,0 +1,64 @@
+import { parseRange, serveRange } from "imposters/server/Ranges"
+import { describe, expect, it } from "vitest"
+
+describe("parseRange", () => {
+  it("parses closed, open and suffix ranges", () => {
+    expect(parseRange("bytes=0-9", 100)).toEqual({ start: 0, end: 9 })
+    expect(parseRange("bytes=90-", 100)).toEqual({ start: 90, end: 99 })
+    expect(parseRange("bytes=-10", 100)).toEqual({ start: 90, end: 99 })
+    expect(parseRange("bytes=50-500", 100)).toEqual({ start: 50, end: 99 })
+    expect(parseRange("bytes=-500", 100)).toEqual({ start: 0, end: 99 })
+  })
+
+  it("reports ranges past the end as unsatisfiable", () => {
+    expect(parseRange("bytes=100-", 100)).toBe("unsatisfiable")
+    expect(parseRange("bytes=-0", 100)).toBe("unsatisfiable")
+  })
+
+  it("ignores other units, several ranges and malformed headers", () => {
+    expect(parseRange("items=0-9", 100)).toBeUndefined()
+    expect(parseRange("bytes=0-9,20-29", 100)).toBeUndefined()
+    expect(parseRange("bytes=9-0", 100)).toBeUndefined()
+    expect(parseRange("bytes=-", 100)).toBeUndefined()
+  })
+})
+
+describe("serveRange", () => {
+  const body = new TextEncoder().encode("0123456789")
+  const headers = new Headers({ "content-type": "application/octet-stream", etag: "\"v1\"" })
+  const request = (init: Record<string, string>) => new Headers(init)
+  const text = (bytes: Uint8Array) => new TextDecoder().decode(bytes)
+
+  it("serves partial content by the book", () => {
+    const ranged = serveRange("honor", request({ range: "bytes=2-4" }), body, headers)
+    expect(ranged.status).toBe(206)
+    expect(ranged.headers.get("content-range")).toBe("bytes 2-4/10")
+    expect(text(ranged.body)).toBe("234")
+  })
+
+  it("sends the whole body without a Range or with a stale If-Range", () => {
+    const plain = serveRange("honor", request({}), body, headers)
+    expect(plain.status).toBe(200)
+    expect(plain.headers.get("accept-ranges")).toBe("bytes")
+    const stale = serveRange("honor", request({ range: "bytes=2-4", "if-range": "\"v0\"" }), body, headers)
+    expect(stale.status).toBe(200)
+    expect(serveRange("honor", request({ range: "bytes=2-4", "if-range": "\"v1\"" }), body, headers).status).toBe(206)
+  })
+
+  it("refuses unsatisfiable ranges with 416", () => {
+    const ranged = serveRange("honor", request({ range: "bytes=20-" }), body, headers)
+    expect(ranged.status).toBe(416)
+    expect(ranged.headers.get("content-range")).toBe("bytes */10")
+    expect(ranged.body.byteLength).toBe(0)
+  })
+
+  it("mishandles ranges on purpose", () => {
+    const range = request({ range: "bytes=6-8" })
+    expect(serveRange("ignore", range, body, headers).status).toBe(200)
+    const misaligned = serveRange("misalign", range, body, headers)
+    expect(misaligned.status).toBe(206)
+    expect(misaligned.headers.get("content-range")).toBe("bytes 6-8/10")
+    expect(text(misaligned.body)).toBe("012")
+    expect(serveRange("unsatisfiable", range, body, headers).status).toBe(416)
+  })
+})
@@ -0,0 +1,46 @@
+import { Effect } from "effect"
+import * as Schema from "effect/Schema"
+import { ResponseRateLimit } from "imposters/schemas/StubSchema"
+import { makeRateLimits, overLimit } from "imposters/server/RateLimits"
+import { describe, expect, it } from "vitest"
+
+const limit = (config: unknown) => Schema.decodeUnknownSync(ResponseRateLimit)(config)
+
+describe("makeRateLimits", () => {
+  it("lets `requests` responses through a window, then none until it moves on", async () => {
+    const twice = limit({ requests: 2, windowMs: 1000 })
+    const taken = await Effect.runPromise(Effect.gen(function*() {
+      const limits = yield* makeRateLimits
+      return [
+        yield* limits.take("imp-1", twice, 0),
+        yield* limits.take("imp-1", twice, 10),
+        yield* limits.take("imp-1", twice, 20),
+        yield* limits.take("imp-1", twice, 1001),
+        yield* limits.take("imp-2", twice, 20)
+      ]
+    }))
+    expect(taken).toEqual([true, true, false, true, true])
+  })
+
+  it("shares a window between limits of the same name, and starts over once reset", async () => {
+    const taken = await Effect.runPromise(Effect.gen(function*() {
+      const limits = yield* makeRateLimits
+      yield* limits.take("imp-1", limit({ name: "llm", requests: 1 }), 0)
+      const shared = yield* limits.take("imp-1", limit({ name: "llm", requests: 1 }), 1)
+      const other = yield* limits.take("imp-1", limit({ name: "search", requests: 1 }), 1)
+      yield* limits.reset("imp-1")
+      return [shared, other, yield* limits.take("imp-1", limit({ name: "llm", requests: 1 }), 2)]
+    }))
+    expect(taken).toEqual([false, true, true])
+  })
+})
+
+describe("overLimit", () => {
+  it("answers with the limit's status, headers and body", () => {
+    expect(overLimit(limit({ requests: 1, headers: { "retry-after": "1" }, body: { error: "slow down" } }))).toEqual({
+      status: 429,
+      headers: { "retry-after": "1" },
+      body: { error: "slow down" }
+    })
+  })
+})
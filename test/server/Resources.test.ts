@@ -0,0 +1,150 @@
+import * as Schema from "effect/Schema"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import { ResourceConfig } from "imposters/schemas/StubSchema"
+import { type Collection, seedCollection, serveResource } from "imposters/server/Resources"
+import { describe, expect, it } from "vitest"
+
+const todos = Schema.decodeUnknownSync(ResourceConfig)({
+  path: "/todos",
+  seed: [{ id: 7, title: "Write tests" }, { title: "Ship" }]
+})
+
+const request = (
+  method: string,
+  path: string,
+  body?: unknown,
+  headers: Record<string, string> = {}
+): RequestContext => ({
+  method,
+  path,
+  headers,
+  query: {},
+  body
+})
+
+const NOW = Date.UTC(2026, 0, 1, 12)
+const MODIFIED = new Date(NOW).toUTCString()
+const validators = (version: number) => ({ etag: `"v${version}"`, "last-modified": MODIFIED })
+
+// Serves each request in turn, returning the answers and the collection they leave
+const serveAll = (collection: Collection, ...requests: ReadonlyArray<RequestContext>) =>
+  requests.reduce(
+    ({ answers, current }, ctx) => {
+      const [answer, next] = serveResource(todos, current, ctx, () => "generated", NOW)
+      return { answers: [...answers, answer], current: next }
+    },
+    { answers: [] as Array<unknown>, current: collection }
+  )
+
+describe("seedCollection", () => {
+  it("gives seed items without an id the next number", () => {
+    expect(seedCollection(todos, () => "generated", NOW)).toEqual({
+      items: [{ id: 7, title: "Write tests" }, { id: 8, title: "Ship" }],
+      nextId: 9,
+      versions: new Map([["7", { version: 1, updatedAt: NOW }], ["8", { version: 1, updatedAt: NOW }]]),
+      revision: 1
+    })
+  })
+})
+
+describe("serveResource", () => {
+  const seeded = seedCollection(todos, () => "generated", NOW)
+
+  it("creates, reads, updates and deletes items", () => {
+    const { answers, current } = serveAll(
+      seeded,
+      request("POST", "/todos", { title: "Review" }),
+      request("GET", "/todos/9"),
+      request("PATCH", "/todos/9", { done: true }),
+      request("PUT", "/todos/7/", { id: 100, title: "Rewrite tests" }),
+      request("DELETE", "/todos/8"),
+      request("GET", "/todos")
+    )
+    expect(answers).toEqual([
+      { status: 201, headers: { location: "/todos/9", ...validators(2) }, body: { id: 9, title: "Review" } },
+      { status: 200, headers: validators(2), body: { id: 9, title: "Review" } },
+      { status: 200, headers: validators(3), body: { id: 9, title: "Review", done: true } },
+      { status: 200, headers: validators(4), body: { id: 7, title: "Rewrite tests" } },
+      { status: 204 },
+      {
+        status: 200,
+        headers: { etag: "\"r5\"", "last-modified": MODIFIED },
+        body: [{ id: 7, title: "Rewrite tests" }, { id: 9, title: "Review", done: true }]
+      }
+    ])
+    expect(current.nextId).toBe(10)
+  })
+
+  it("keeps an id the client picks, and refuses one already taken", () => {
+    const { answers, current } = serveAll(
+      seeded,
+      request("POST", "/todos", { id: 20, title: "Plan" }),
+      request("POST", "/todos", { id: "7", title: "Again" })
+    )
+    expect(answers).toEqual([
+      { status: 201, headers: { location: "/todos/20", ...validators(2) }, body: { id: 20, title: "Plan" } },
+      { status: 409, body: { error: "An item with this id already exists", id: "7" } }
+    ])
+    expect(current.nextId).toBe(21)
+  })
+
+  it("answers unknown items, paths and methods without changing anything", () => {
+    const { answers, current } = serveAll(
+      seeded,
+      request("GET", "/todos/404"),
+      request("GET", "/todos/7/comments"),
+      request("DELETE", "/todos"),
+      request("POST", "/todos/7", { title: "x" }),
+      request("POST", "/todos", ["not", "an", "object"])
+    )
+    expect(answers).toEqual([
+      { status: 404, body: { error: "Item not found", id: "404" } },
+      { status: 404, body: { error: "Not found", path: "/todos/7/comments" } },
+      {
+        status: 405,
+        headers: { allow: "GET, POST" },
+        body: { error: "Method not allowed", method: "DELETE", allow: ["GET", "POST"] }
+      },
+      {
+        status: 405,
+        headers: { allow: "GET, PUT, PATCH, DELETE" },
+        body: { error: "Method not allowed", method: "POST", allow: ["GET", "PUT", "PATCH", "DELETE"] }
+      },
+      { status: 400, body: { error: "Expected a JSON object body" } }
+    ])
+    expect(current).toBe(seeded)
+  })
+
+  it("generates uuids when asked to", () => {
+    const resource = Schema.decodeUnknownSync(ResourceConfig)({ path: "/users", idField: "userId", ids: "uuid" })
+    const newId = () => "u-1"
+    const [answer] = serveResource(resource, seedCollection(resource, newId), request("POST", "/users", {}), newId, NOW)
+    expect(answer).toEqual({
+      status: 201,
+      headers: { location: "/users/u-1", ...validators(2) },
+      body: { userId: "u-1" }
+    })
+  })
+
+  it("checks preconditions against each item's version before writing", () => {
+    const earlier = new Date(NOW - 60_000).toUTCString()
+    const { answers, current } = serveAll(
+      seeded,
+      request("GET", "/todos/7", undefined, { "if-none-match": "\"v1\"" }),
+      request("PUT", "/todos/7", { title: "Stale" }, { "if-match": "\"v0\"" }),
+      request("PATCH", "/todos/7", { title: "Stale" }, { "if-unmodified-since": earlier }),
+      request("PUT", "/todos/7", { title: "Fresh" }, { "if-match": "\"v1\"" }),
+      request("DELETE", "/todos/8", undefined, { "if-match": "*" }),
+      request("GET", "/todos", undefined, { "if-none-match": "\"r1\"" })
+    )
+    expect(answers).toEqual([
+      { status: 304, headers: validators(1) },
+      { status: 412, headers: validators(1) },
+      { status: 412, headers: validators(1) },
+      { status: 200, headers: validators(2), body: { id: 7, title: "Fresh" } },
+      { status: 204 },
+      { status: 200, headers: { etag: "\"r3\"", "last-modified": MODIFIED }, body: [{ id: 7, title: "Fresh" }] }
+    ])
+    expect(current.items).toEqual([{ id: 7, title: "Fresh" }])
+  })
+})
@@ -0,0 +1,69 @@
+import { DateTime, Effect } from "effect"
+import * as Schema from "effect/Schema"
+import { Stub } from "imposters/schemas/StubSchema"
+import { inState, makeScenarios, scenarioStates } from "imposters/server/Scenarios"
+import { describe, expect, it } from "vitest"
+
+const stub = (id: string, scenario?: unknown) =>
+  Schema.decodeUnknownSync(Stub)({
+    id,
+    predicates: [],
+    responses: [{ status: 200 }],
+    ...(scenario !== undefined ? { scenario } : {})
+  })
+
+describe("inState", () => {
+  it("lets stubs match only while their scenario is in the required state", () => {
+    const open = stub("open", { name: "checkout", requiredState: "Started", newState: "paid" })
+    const paid = stub("paid", { name: "checkout", requiredState: "paid" })
+    const any = stub("any", { name: "checkout" })
+    expect([open, paid, any, stub("plain")].filter(inState({})).map((s) => s.id)).toEqual(["open", "any", "plain"])
+    expect([open, paid, any].filter(inState({ checkout: "paid" })).map((s) => s.id)).toEqual(["paid", "any"])
+  })
+})
+
+describe("scenarioStates", () => {
+  it("lists every scenario the stubs name, in Started until moved", () => {
+    const stubs = [stub("a", { name: "checkout" }), stub("b", { name: "auth", newState: "in" }), stub("c")]
+    expect(scenarioStates(stubs, { auth: "in" })).toEqual({ auth: "in", checkout: "Started" })
+  })
+
+  it("treats names every object has as ordinary scenarios", () => {
+    const stubs = [stub("a", { name: "constructor", requiredState: "Started" }), stub("b", { name: "toString" })]
+    expect(stubs.filter(inState({})).map((s) => s.id)).toEqual(["a", "b"])
+    expect(scenarioStates(stubs, { toString: "moved" })).toEqual({ constructor: "Started", toString: "moved" })
+  })
+})
+
+describe("makeScenarios", () => {
+  it("keeps each imposter's states apart and forgets them on reset", async () => {
+    const states = await Effect.runPromise(
+      Effect.gen(function*() {
+        const scenarios = yield* makeScenarios
+        yield* scenarios.set("imp-1", "checkout", "paid", 0)
+        yield* scenarios.set("imp-2", "checkout", "shipped", 0)
+        yield* scenarios.reset("imp-2")
+        return [yield* scenarios.states("imp-1"), yield* scenarios.states("imp-2")]
+      })
+    )
+    expect(states).toEqual([{ checkout: "paid" }, {}])
+  })
+
+  it("records each transition with the stub that made it, until reset", async () => {
+    const [history, cleared] = await Effect.runPromise(
+      Effect.gen(function*() {
+        const scenarios = yield* makeScenarios
+        yield* scenarios.set("imp-1", "checkout", "paid", 1000, "pay")
+        yield* scenarios.set("imp-1", "checkout", "shipped", 2000)
+        const history = yield* scenarios.history("imp-1")
+        yield* scenarios.reset("imp-1")
+        return [history, yield* scenarios.history("imp-1")]
+      })
+    )
+    expect(history).toEqual([
+      { scenario: "checkout", from: "Started", to: "paid", stubId: "pay", at: DateTime.unsafeMake(1000) },
+      { scenario: "checkout", from: "paid", to: "shipped", at: DateTime.unsafeMake(2000) }
+    ])
+    expect(cleared).toEqual([])
+  })
+})
@@ -0,0 +1,156 @@
+import { Effect } from "effect"
+import { applyFault, resetResponse } from "imposters/server/Faults"
+import {
+  classifyRejected,
+  diagnoseRequest,
+  NodeServerFactoryLive,
+  requestDiagnostics,
+  ServerFactory
+} from "imposters/server/ServerFactory"
+import * as net from "node:net"
+import { describe, expect, it } from "vitest"
+
+describe("classifyRejected", () => {
+  it("recognises a TLS handshake sent to a plain HTTP port", () => {
+    const clientHello = new Uint8Array([0x16, 0x03, 0x01, 0x02, 0x00, 0x01])
+    expect(classifyRejected("HPE_INVALID_METHOD", clientHello)).toEqual({
+      kind: "tls",
+      reason: "HPE_INVALID_METHOD",
+      sample: "160301020001"
+    })
+  })
+
+  it("recognises HTTP/2 sent without negotiating it", () => {
+    const preface = new TextEncoder().encode("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+    expect(classifyRejected("HPE_INVALID_METHOD", preface).kind).toBe("http2")
+  })
+
+  it("samples the first 32 bytes of anything else", () => {
+    const rejected = classifyRejected("HPE_INVALID_METHOD", new Uint8Array(100).fill(0xff))
+    expect(rejected.kind).toBe("malformed")
+    expect(rejected.sample).toBe("ff".repeat(32))
+    expect(classifyRejected("HPE_INVALID_METHOD", undefined).sample).toBe("")
+  })
+})
+
+describe("diagnoseRequest", () => {
+  it("finds nothing wrong with a well-formed request", () => {
+    expect(diagnoseRequest(["Host", "localhost", "Content-Length", "5"])).toEqual([])
+    expect(diagnoseRequest(["Transfer-Encoding", "gzip, chunked"])).toEqual([])
+  })
+
+  it("flags framing two parsers could read differently", () => {
+    expect(diagnoseRequest(["Content-Length", "5", "Transfer-Encoding", "chunked"])).toEqual([
+      "Both Content-Length and Transfer-Encoding are set"
+    ])
+    expect(diagnoseRequest(["Content-Length", "5", "content-length", "7"])).toEqual([
+      "Conflicting Content-Length headers: 5, 7"
+    ])
+    expect(diagnoseRequest(["Content-Length", "5", "Content-Length", "5"])).toEqual(["Content-Length is repeated"])
+    expect(diagnoseRequest(["Content-Length", "-1"])).toEqual(["Invalid Content-Length \"-1\""])
+    expect(diagnoseRequest(["Transfer-Encoding", "chunked, gzip"])).toEqual([
+      "Transfer-Encoding does not end in chunked: chunked, gzip"
+    ])
+  })
+
+  it("flags header names and values no standard parser accepts", () => {
+    expect(diagnoseRequest(["X Forwarded", "a", "X-Note", "a\u0001b", "X-Tab", "a\tb"])).toEqual([
+      "Invalid header name \"X Forwarded\"",
+      "Control characters in the value of \"X-Note\""
+    ])
+  })
+})
+
+// Sends raw bytes and resolves with the status line of the reply, or "" when the connection is dropped
+const sendRaw = (port: number, raw: string) =>
+  new Promise<string>((resolve) => {
+    const socket = net.connect(port, "127.0.0.1", () => socket.write(raw))
+    let received = ""
+    socket.on("data", (chunk) => {
+      received += chunk.toString()
+    })
+    socket.on("close", () => resolve(received.split("\r\n")[0] ?? ""))
+    socket.on("error", () => resolve(""))
+  })
+
+describe("NodeServerFactoryLive", () => {
+  const smuggled = "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n" +
+    "Connection: close\r\n\r\n0\r\n\r\n"
+
+  const factory = Effect.runSync(Effect.provide(ServerFactory, NodeServerFactoryLive))
+  const serve = (httpParser: "strict" | "lenient", port: number, seen: Array<ReadonlyArray<string>>) =>
+    factory.create({
+      port,
+      httpParser,
+      fetch: async (request) => {
+        seen.push(requestDiagnostics.get(request) ?? [])
+        return new Response("ok")
+      }
+    })
+
+  it("refuses conflicting framing by default", async () => {
+    const seen: Array<ReadonlyArray<string>> = []
+    const server = serve("strict", 9112, seen)
+    try {
+      expect(await sendRaw(9112, smuggled)).toBe("HTTP/1.1 400 Bad Request")
+      expect(seen).toEqual([])
+    } finally {
+      server.stop(true)
+    }
+  })
+
+  it("passes it on with its diagnostics when lenient", async () => {
+    const seen: Array<ReadonlyArray<string>> = []
+    const server = serve("lenient", 9113, seen)
+    try {
+      expect(await sendRaw(9113, smuggled)).toBe("HTTP/1.1 200 OK")
+      expect(seen).toEqual([["Both Content-Length and Transfer-Encoding are set"]])
+    } finally {
+      server.stop(true)
+    }
+  })
+
+  it("resets the connection for a connection_reset fault", async () => {
+    const server = factory.create({
+      port: 9114,
+      fetch: async () => resetResponse({ status: 200, headers: new Headers() })
+    })
+    try {
+      const outcome = await new Promise<string>((resolve) => {
+        const socket = net.connect(9114, "127.0.0.1", () => socket.write("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+        let received = ""
+        socket.on("data", (chunk) => {
+          received += chunk.toString()
+        })
+        socket.on("error", (err: NodeJS.ErrnoException) => resolve(err.code ?? "error"))
+        socket.on("close", () => resolve(received))
+      })
+      expect(outcome).toBe("ECONNRESET")
+    } finally {
+      server.stop(true)
+    }
+  })
+
+  it("writes a malformed response fault to the socket as is", async () => {
+    const init = { status: 200, headers: new Headers() }
+    const server = factory.create({
+      port: 9115,
+      fetch: async () => applyFault({ type: "invalid_chunked" }, "oops", init).response
+    })
+    try {
+      const received = await new Promise<string>((resolve) => {
+        const socket = net.connect(9115, "127.0.0.1", () => socket.write("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+        let text = ""
+        socket.on("data", (chunk) => {
+          text += chunk.toString()
+        })
+        socket.on("close", () => resolve(text))
+      })
+      expect(received).toBe(
+        "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\nconnection: close\r\n\r\nzz\r\noops\r\n0\r\n\r\n"
+      )
+    } finally {
+      server.stop(true)
+    }
+  })
+})
@@ -0,0 +1,75 @@
+import { accessOf, guardAdmin, issueShare, shareExpiry } from "imposters/server/Shares"
+import { describe, expect, it } from "vitest"
+
+const ADMIN_TOKEN = "s3cret"
+const HOUR = 3_600_000
+
+const handler = guardAdmin(ADMIN_TOKEN, async (request) => new Response(`${request.method} ok`))
+
+describe("shares", () => {
+  it("carry their expiry, signed with the admin token", () => {
+    const share = issueShare(ADMIN_TOKEN, 1_700_000_000_000)
+    expect(shareExpiry(ADMIN_TOKEN, share)).toBe(1_700_000_000_000)
+    expect(shareExpiry("another token", share)).toBeUndefined()
+    const [prefix, , signature] = share.split(".")
+    expect(shareExpiry(ADMIN_TOKEN, `${prefix}.${(1_800_000_000_000).toString(36)}.${signature}`)).toBeUndefined()
+  })
+
+  it("let requests read until they expire", () => {
+    const now = Date.now()
+    expect(accessOf(ADMIN_TOKEN, ADMIN_TOKEN, now)).toBe("admin")
+    expect(accessOf(ADMIN_TOKEN, issueShare(ADMIN_TOKEN, now + HOUR), now)).toBe("read")
+    expect(accessOf(ADMIN_TOKEN, issueShare(ADMIN_TOKEN, now - 1), now)).toBe("none")
+    expect(accessOf(ADMIN_TOKEN, "guess", now)).toBe("none")
+    expect(accessOf(ADMIN_TOKEN, undefined, now)).toBe("none")
+  })
+})
+
+describe("guardAdmin", () => {
+  const share = issueShare(ADMIN_TOKEN, Date.now() + HOUR)
+
+  it("refuses requests without a token, and changes made with a share", async () => {
+    const anonymous = await handler(new Request("http://localhost/imposters"))
+    expect(anonymous.status).toBe(401)
+    expect(anonymous.headers.get("www-authenticate")).toBe("Bearer")
+    expect((await anonymous.json()).code).toBe("unauthorized")
+
+    const bearer = { authorization: `Bearer ${share}` }
+    expect(await (await handler(new Request("http://localhost/imposters", { headers: bearer }))).text()).toBe("GET ok")
+    const change = await handler(new Request("http://localhost/imposters", { method: "POST", headers: bearer }))
+    expect(change.status).toBe(403)
+    expect((await change.json()).code).toBe("read_only")
+  })
+
+  it("keeps the export, and the private keys in it, from shares", async () => {
+    const headers = { authorization: `Bearer ${share}` }
+    for (const path of ["/export", "/export/", "/export?format=curl"]) {
+      const res = await handler(new Request(`http://localhost${path}`, { headers }))
+      expect(res.status).toBe(403)
+    }
+    const admin = { authorization: `Bearer ${ADMIN_TOKEN}` }
+    expect((await handler(new Request("http://localhost/export", { headers: admin }))).status).toBe(200)
+  })
+
+  it("lets the admin token do anything", async () => {
+    const request = new Request("http://localhost/imposters", {
+      method: "DELETE",
+      headers: { authorization: `Bearer ${ADMIN_TOKEN}` }
+    })
+    expect(await (await handler(request)).text()).toBe("DELETE ok")
+  })
+
+  it("keeps a token from a link in a cookie for the requests that follow", async () => {
+    const opened = await handler(new Request(`http://localhost/_ui?token=${share}`))
+    const cookie = opened.headers.get("set-cookie")!
+    expect(cookie).toContain("HttpOnly")
+    const headers = { cookie: cookie.split(";")[0]! }
+    const next = await handler(new Request("http://localhost/_ui/imposters", { headers }))
+    expect(next.status).toBe(200)
+  })
+
+  it("lets everything through when no admin token is set", async () => {
+    const open = guardAdmin(undefined, async (request) => new Response(`${request.method} ok`))
+    expect(await (await open(new Request("http://localhost/imposters", { method: "POST" }))).text()).toBe("POST ok")
+  })
+})
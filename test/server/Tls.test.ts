@@ -0,0 +1,53 @@
+import { invalidCertificate, matchServerName, selectHost } from "imposters/server/Tls"
+import * as fs from "node:fs"
+import * as path from "node:path"
+import { describe, expect, it } from "vitest"
+
+const fixture = (name: string) => fs.readFileSync(path.join(__dirname, "../fixtures/tls", name), "utf8")
+
+const certificate = (name: string) => ({ cert: fixture(`${name}.crt`), key: fixture(`${name}.key`) })
+
+describe("matchServerName", () => {
+  it("matches exact names case-insensitively", () => {
+    expect(matchServerName("api.example.com", "API.example.com")).toBe(true)
+    expect(matchServerName("api.example.com", "www.example.com")).toBe(false)
+  })
+
+  it("lets a wildcard stand for exactly one label", () => {
+    expect(matchServerName("*.example.com", "api.example.com")).toBe(true)
+    expect(matchServerName("*.example.com", "example.com")).toBe(false)
+    expect(matchServerName("*.example.com", "a.b.example.com")).toBe(false)
+  })
+})
+
+describe("selectHost", () => {
+  const hosts = {
+    "*.internal.test": certificate("wildcard.internal.test"),
+    "db.internal.test": certificate("localhost"),
+    "api.example.com": certificate("api.example.com")
+  }
+
+  it("prefers an exact name over a wildcard", () => {
+    expect(selectHost(hosts, "db.internal.test")).toBe("db.internal.test")
+    expect(selectHost(hosts, "cache.internal.test")).toBe("*.internal.test")
+    expect(selectHost(hosts, "api.example.com")).toBe("api.example.com")
+  })
+
+  it("falls back to the default certificate", () => {
+    expect(selectHost(hosts, "other.example.com")).toBeUndefined()
+    expect(selectHost(hosts, undefined)).toBeUndefined()
+    expect(selectHost(hosts, "")).toBeUndefined()
+  })
+})
+
+describe("invalidCertificate", () => {
+  it("accepts a matching certificate and key", () => {
+    expect(invalidCertificate(certificate("localhost"))).toBeUndefined()
+  })
+
+  it("explains keys that don't match and PEM that doesn't parse", () => {
+    const mismatched = { cert: fixture("localhost.crt"), key: fixture("api.example.com.key") }
+    expect(invalidCertificate(mismatched)).toEqual(expect.any(String))
+    expect(invalidCertificate({ cert: "not a certificate", key: "not a key" })).toEqual(expect.any(String))
+  })
+})
@@ -0,0 +1,213 @@
+import { Effect, Layer, ManagedRuntime } from "effect"
+import type { RequestContext } from "imposters/matching/RequestMatcher"
+import type { OutboundExchange } from "imposters/schemas/RequestLogSchema"
+import type { CallbackConfig, CallbackRetry } from "imposters/schemas/StubSchema"
+import { backoffDelay, CallbackService, CallbackServiceLive, renderCallback } from "imposters/services/CallbackService"
+import { UuidLive } from "imposters/services/UuidLive"
+import * as http from "node:http"
+import { afterAll, beforeAll, beforeEach, describe, expect, it } from "vitest"
+
+// Receiver that fails `/flaky` until `failuresLeft` runs out and always fails `/down`
+let receiver: http.Server
+let receiverPort: number
+let failuresLeft = 0
+const received: Array<{ method: string; path: string; headers: http.IncomingHttpHeaders; body: string }> = []
+
+beforeAll(async () => {
+  receiver = http.createServer((req, res) => {
+    let body = ""
+    req.on("data", (chunk: Buffer) => {
+      body += chunk.toString()
+    })
+    req.on("end", () => {
+      const path = new URL(req.url!, "http://localhost").pathname
+      received.push({ method: req.method!, path, headers: req.headers, body })
+      const fail = path === "/down" || (path === "/flaky" && failuresLeft-- > 0)
+      res.writeHead(fail ? 503 : 204)
+      res.end()
+    })
+  })
+  await new Promise<void>((resolve) => {
+    receiver.listen(0, () => {
+      receiverPort = (receiver.address() as { port: number }).port
+      resolve()
+    })
+  })
+})
+
+afterAll(() => {
+  receiver.close()
+})
+
+beforeEach(() => {
+  received.length = 0
+  failuresLeft = 0
+})
+
+const runtime = ManagedRuntime.make(CallbackServiceLive.pipe(Layer.provide(UuidLive)))
+afterAll(async () => {
+  await runtime.dispose()
+})
+
+const ctx: RequestContext = {
+  method: "POST",
+  path: "/orders/42",
+  headers: { "x-tenant": "acme" },
+  query: {},
+  body: { orderId: "42" }
+}
+
+const fastRetry: CallbackRetry = { maxAttempts: 3, initialDelay: 10, multiplier: 2, maxDelay: 1000 }
+
+const makeCallback = (overrides: Partial<CallbackConfig> = {}): CallbackConfig => ({
+  url: `http://localhost:${receiverPort}/flaky`,
+  method: "POST",
+  timeout: 2000,
+  ...overrides
+})
+
+const waitFor = async (check: () => Promise<boolean>) => {
+  for (let i = 0; i < 100; i++) {
+    if (await check()) return
+    await new Promise((resolve) => setTimeout(resolve, 20))
+  }
+  throw new Error("condition not met")
+}
+
+const failedCount = () =>
+  runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.failed())).then((entries) => entries.length)
+
+describe("backoffDelay", () => {
+  it("grows geometrically from the initial delay and is capped at maxDelay", () => {
+    const retry: CallbackRetry = { maxAttempts: 6, initialDelay: 100, multiplier: 3, maxDelay: 1000 }
+    expect([1, 2, 3, 4, 5].map((n) => backoffDelay(retry, n))).toEqual([0, 100, 300, 900, 1000])
+  })
+})
+
+describe("renderCallback", () => {
+  it("renders templates in the url, headers and body", async () => {
+    const request = await renderCallback(
+      makeCallback({
+        url: "http://localhost/hooks/{{request.body.orderId}}",
+        headers: { "x-tenant": "{{request.headers.x-tenant}}" },
+        body: { order: "{{request.body.orderId}}", path: "{{request.path}}" }
+      }),
+      ctx
+    )
+    expect(request.url).toBe("http://localhost/hooks/42")
+    expect(request.headers).toEqual({ "x-tenant": "acme", "content-type": "application/json" })
+    expect(JSON.parse(request.body!)).toEqual({ order: "42", path: "/orders/42" })
+  })
+
+  it("sends string bodies as-is", async () => {
+    const request = await renderCallback(makeCallback({ body: "order {{request.body.orderId}}" }), ctx)
+    expect(request.body).toBe("order 42")
+    expect(request.headers).toEqual({})
+  })
+})
+
+describe("CallbackService", () => {
+  it("delivers a callback in the background", async () => {
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", "stub-1", makeCallback(), ctx)))
+    await waitFor(async () => received.length === 1)
+    expect(received[0]!.method).toBe("POST")
+    expect(await failedCount()).toBe(0)
+  })
+
+  it("retries with backoff until the receiver recovers", async () => {
+    failuresLeft = 2
+    await runtime.runPromise(
+      Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", "stub-1", makeCallback({ retry: fastRetry }), ctx))
+    )
+    await waitFor(async () => received.length === 3)
+    expect(await failedCount()).toBe(0)
+  })
+
+  it("journals every attempt", async () => {
+    failuresLeft = 1
+    const journaled: Array<OutboundExchange> = []
+    const journal = (exchange: OutboundExchange) => Effect.sync(() => void journaled.push(exchange))
+    const callback = makeCallback({ retry: fastRetry, body: { order: "{{request.body.orderId}}" } })
+    await runtime.runPromise(
+      Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", "stub-1", callback, ctx, journal))
+    )
+    await waitFor(async () => journaled.length === 2)
+
+    expect(journaled.map((e) => [e.kind, e.attempt, e.status])).toEqual([["callback", 1, 503], ["callback", 2, 204]])
+    expect(journaled[0]!.request.url).toBe(`http://localhost:${receiverPort}/flaky`)
+    expect(journaled[0]!.request.body).toBe("{\"order\":\"42\"}")
+  })
+
+  it("moves callbacks that fail every attempt to the dead-letter list", async () => {
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.clearFailed()))
+    const callback = makeCallback({ url: `http://localhost:${receiverPort}/down`, retry: fastRetry })
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", "stub-1", callback, ctx)))
+    await waitFor(async () => (await failedCount()) === 1)
+
+    const [entry] = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.failed({ imposterId: "imp-1" })))
+    expect(received).toHaveLength(3)
+    expect(entry!.attempts).toBe(3)
+    expect(entry!.lastStatus).toBe(503)
+    expect(entry!.lastError).toBe("HTTP 503")
+    expect(entry!.stubId).toBe("stub-1")
+    expect(entry!.request.url).toBe(`http://localhost:${receiverPort}/down`)
+  })
+
+  it("records connection errors for unreachable receivers", async () => {
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.clearFailed()))
+    const callback = makeCallback({ url: "http://127.0.0.1:1/" })
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", undefined, callback, ctx)))
+    await waitFor(async () => (await failedCount()) === 1)
+
+    const [entry] = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.failed()))
+    expect(entry!.attempts).toBe(1)
+    expect(entry!.lastStatus).toBeUndefined()
+    expect(entry!.lastError).toBe("ECONNREFUSED")
+  })
+
+  it("re-drives a dead letter and removes it once delivered", async () => {
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.clearFailed()))
+    failuresLeft = 1
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", "stub-1", makeCallback(), ctx)))
+    await waitFor(async () => (await failedCount()) === 1)
+
+    const [entry] = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.failed()))
+    const delivery = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.redrive(entry!.id)))
+    expect(delivery).toEqual({ delivered: true, attempts: 1, status: 204 })
+    expect(await failedCount()).toBe(0)
+  })
+
+  it("keeps a dead letter that fails again and counts the extra attempts", async () => {
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.clearFailed()))
+    const callback = makeCallback({ url: `http://localhost:${receiverPort}/down` })
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", "stub-1", callback, ctx)))
+    await waitFor(async () => (await failedCount()) === 1)
+
+    const [entry] = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.failed()))
+    const delivery = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.redrive(entry!.id)))
+    expect(delivery.delivered).toBe(false)
+
+    const [updated] = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.failed()))
+    expect(updated!.id).toBe(entry!.id)
+    expect(updated!.attempts).toBe(2)
+  })
+
+  it("fails to re-drive an unknown callback", async () => {
+    const result = await runtime.runPromise(
+      Effect.flatMap(CallbackService, (s) => s.redrive("missing")).pipe(Effect.flip)
+    )
+    expect(result._tag).toBe("CallbackNotFoundError")
+  })
+
+  it("clears dead letters by imposter", async () => {
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.clearFailed()))
+    const callback = makeCallback({ url: `http://localhost:${receiverPort}/down` })
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.dispatch("imp-1", undefined, callback, ctx)))
+    await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.dispatch("imp-2", undefined, callback, ctx)))
+    await waitFor(async () => (await failedCount()) === 2)
+
+    expect(await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.clearFailed("imp-1")))).toBe(1)
+    const remaining = await runtime.runPromise(Effect.flatMap(CallbackService, (s) => s.failed()))
+    expect(remaining.map((e) => e.imposterId)).toEqual(["imp-2"])
+  })
+})
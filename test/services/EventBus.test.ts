@@ -0,0 +1,40 @@
+import { Effect, Layer, ManagedRuntime, Queue } from "effect"
+import { EventBus, EventBusLive } from "imposters/services/EventBus"
+import { UuidLive } from "imposters/services/UuidLive"
+import { afterAll, describe, expect, it } from "vitest"
+
+const runtime = ManagedRuntime.make(EventBusLive.pipe(Layer.provide(UuidLive)))
+afterAll(async () => {
+  await runtime.dispose()
+})
+
+describe("EventBus", () => {
+  it("recent filters by imposter and honours the limit", async () => {
+    const events = await runtime.runPromise(
+      Effect.gen(function*() {
+        const bus = yield* EventBus
+        yield* bus.publish("stubs.swapped", "imp-a", { stubCount: 1 })
+        yield* bus.publish("stubs.swapped", "imp-b", { stubCount: 2 })
+        yield* bus.publish("stubs.swapped", "imp-a", { stubCount: 3 })
+        return yield* bus.recent({ imposterId: "imp-a", limit: 1 })
+      })
+    )
+    expect(events).toHaveLength(1)
+    expect(events[0]!.data).toEqual({ stubCount: 3 })
+  })
+
+  it("subscribers receive events published after subscribing", async () => {
+    const event = await runtime.runPromise(
+      Effect.scoped(
+        Effect.gen(function*() {
+          const bus = yield* EventBus
+          const queue = yield* bus.subscribe
+          yield* bus.publish("stubs.swapped", "imp-sub")
+          return yield* Queue.take(queue)
+        })
+      )
+    )
+    expect(event.imposterId).toBe("imp-sub")
+    expect(event.type).toBe("stubs.swapped")
+  })
+})
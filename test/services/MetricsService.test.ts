@@ -15,6 +15,8 @@ const makeEntry = (overrides: {
   method?: string
   status?: number
   duration?: number
+  matchedStubId?: string
+  timings?: RequestLogEntry["timings"]
 } = {}): RequestLogEntry => ({
   id: NonEmptyString.make(crypto.randomUUID()),
   imposterId: NonEmptyString.make(overrides.imposterId ?? "imp-1"),
@@ -29,9 +31,11 @@ const makeEntry = (overrides: {
   response: {
     status: overrides.status ?? 200,
     headers: {},
-    proxied: false
+    proxied: false,
+    ...(overrides.matchedStubId !== undefined ? { matchedStubId: NonEmptyString.make(overrides.matchedStubId) } : {})
   },
-  duration: overrides.duration ?? 10
+  duration: overrides.duration ?? 10,
+  ...(overrides.timings !== undefined ? { timings: overrides.timings } : {})
 })
 
 describe("MetricsService", () => {
@@ -155,6 +159,32 @@ describe("MetricsService", () => {
     )
   })
 
+  it("tracks hits per matched stub", async () => {
+    await runtime.runPromise(
+      Effect.gen(function*() {
+        const metrics = yield* MetricsService
+        const impId = "imp-stub-usage"
+        yield* metrics.recordRequest(makeEntry({ imposterId: impId, matchedStubId: "s1" }))
+        yield* metrics.recordRequest(makeEntry({ imposterId: impId, matchedStubId: "s1" }))
+        yield* metrics.recordRequest(makeEntry({ imposterId: impId, matchedStubId: "s2" }))
+        yield* metrics.recordRequest(makeEntry({ imposterId: impId }))
+        const usage = yield* metrics.getStubUsage(impId)
+        expect(usage.stubs["s1"]?.hits).toBe(2)
+        expect(usage.stubs["s2"]?.hits).toBe(1)
+        expect(Object.keys(usage.stubs)).toHaveLength(2)
+        expect(usage.resetAt).toBeUndefined()
+
+        yield* metrics.resetStats(impId)
+        const afterReset = yield* metrics.getStubUsage(impId)
+        expect(afterReset.stubs).toEqual({})
+        expect(afterReset.resetAt).toBeDefined()
+
+        yield* metrics.removeStats(impId)
+        expect(yield* metrics.getStubUsage(impId)).toEqual({ stubs: {} })
+      })
+    )
+  })
+
   it("isolates metrics across imposters", async () => {
     await runtime.runPromise(
       Effect.gen(function*() {
@@ -181,4 +211,53 @@ describe("MetricsService", () => {
       })
     )
   })
+
+  it("aggregates matching cost from journal timings", async () => {
+    await runtime.runPromise(
+      Effect.gen(function*() {
+        const metrics = yield* MetricsService
+        const impId = "imp-matching"
+        yield* metrics.recordRequest(makeEntry({ imposterId: impId }))
+        expect((yield* metrics.getStats(impId)).matching).toBeUndefined()
+
+        yield* metrics.recordRequest(makeEntry({ imposterId: impId, timings: { candidates: 3, matchMs: 0.2 } }))
+        yield* metrics.recordRequest(
+          makeEntry({ imposterId: impId, timings: { candidates: 9, matchMs: 1.4, renderMs: 2.5 } })
+        )
+        const { matching } = yield* metrics.getStats(impId)
+        expect(matching).toEqual({
+          averageCandidates: 6,
+          maxCandidates: 9,
+          averageMatchMs: 0.8,
+          p95MatchMs: 1.4,
+          maxMatchMs: 1.4,
+          averageRenderMs: 2.5
+        })
+      })
+    )
+  })
+
+  it("counts rejected connections by kind and keeps the latest samples", async () => {
+    await runtime.runPromise(
+      Effect.gen(function*() {
+        const metrics = yield* MetricsService
+        const impId = "imp-rejected"
+        const reason = "HPE_INVALID_METHOD"
+        for (let i = 0; i < 6; i++) {
+          yield* metrics.recordRejectedConnection(impId, { kind: "tls", reason, sample: `16030${i}` })
+        }
+        yield* metrics.recordRejectedConnection(impId, { kind: "malformed", reason, sample: "00" })
+
+        const { rejectedConnections, totalRequests } = yield* metrics.getStats(impId)
+        expect(totalRequests).toBe(0)
+        expect(rejectedConnections?.total).toBe(7)
+        expect(rejectedConnections?.byKind).toEqual({ tls: 6, malformed: 1 })
+        expect(rejectedConnections?.samples.map((s) => s.sample))
+          .toEqual(["160302", "160303", "160304", "160305", "00"])
+
+        yield* metrics.resetStats(impId)
+        expect((yield* metrics.getStats(impId)).rejectedConnections).toBeUndefined()
+      })
+    )
+  })
 })
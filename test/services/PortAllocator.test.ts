@@ -1,6 +1,7 @@
 import { it } from "@effect/vitest"
 import * as Effect from "effect/Effect"
 import * as Layer from "effect/Layer"
+import * as Option from "effect/Option"
 import { AppConfig } from "imposters/services/AppConfig"
 import { PortAllocator, PortAllocatorLive } from "imposters/services/PortAllocator"
 import { describe, expect } from "vitest"
@@ -11,7 +12,8 @@ const TestConfig = Layer.succeed(AppConfig, {
   portRangeMin: 5000,
   portRangeMax: 5002,
   maxImposters: 100,
-  logLevel: "info" as const
+  logLevel: "info" as const,
+  adminToken: Option.none()
 })
 
 const TestPortAllocator = PortAllocatorLive.pipe(Layer.provide(TestConfig))
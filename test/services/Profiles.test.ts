@@ -0,0 +1,69 @@
+import { it } from "@effect/vitest"
+import * as Effect from "effect/Effect"
+import * as Either from "effect/Either"
+import * as Schema from "effect/Schema"
+import { Profiles } from "imposters/schemas/ProfileSchema"
+import { makeProfiles, resolveProfile } from "imposters/services/Profiles"
+import { describe, expect } from "vitest"
+
+const stub = (path: string) => ({ predicates: [{ field: "path", operator: "equals", value: path }] })
+
+const profiles = Schema.decodeUnknownSync(Profiles)({
+  base: { stubIds: "content", httpParser: "strict", stubs: [stub("/health")] },
+  lenient: { extends: "base", httpParser: "lenient", stubs: [stub("/status")] },
+  loop: { extends: "loop" }
+})
+
+describe("Profiles", () => {
+  it("applies each profile over the ones it extends, their stubs first", () => {
+    const resolved = Either.getOrThrow(resolveProfile(profiles, "lenient"))
+    expect(resolved).toMatchObject({ stubIds: "content", httpParser: "lenient" })
+    expect(resolved.stubs.map((s) => s.predicates[0]?.value)).toEqual(["/health", "/status"])
+    expect("extends" in resolved).toBe(false)
+  })
+
+  it("fails on an unknown profile and on profiles extending each other", () => {
+    expect(Either.getOrThrow(Either.flip(resolveProfile(profiles, "missing"))))
+      .toMatchObject({ _tag: "ProfileNotFoundError", name: "missing" })
+    expect(Either.getOrThrow(Either.flip(resolveProfile(profiles, "loop"))))
+      .toMatchObject({ _tag: "ProfileCycleError", chain: ["loop", "loop"] })
+  })
+
+  it.effect("finds no profile under a name every object has", () =>
+    Effect.gen(function*() {
+      for (const name of ["constructor", "toString", "__proto__"]) {
+        expect(Either.getOrThrow(Either.flip(resolveProfile(profiles, name))))
+          .toMatchObject({ _tag: "ProfileNotFoundError", name })
+      }
+      const store = yield* makeProfiles()
+      expect(yield* Effect.flip(store.remove("constructor"))).toMatchObject({ _tag: "ProfileNotFoundError" })
+      const extending = Schema.decodeUnknownSync(Profiles)({ child: { extends: "toString" } })
+      expect((yield* Effect.flip(store.update(() => extending)))._tag).toBe("ProfileNotFoundError")
+      expect(yield* store.getAll).toEqual({})
+    }))
+
+  it.effect("refuses to set a profile that doesn't resolve or remove one others extend", () =>
+    Effect.gen(function*() {
+      const store = yield* makeProfiles()
+      const [base, lenient] = [profiles.base!, profiles.lenient!]
+      expect((yield* Effect.flip(store.set("lenient", lenient)))._tag).toBe("ProfileNotFoundError")
+      yield* store.set("base", base)
+      yield* store.set("lenient", lenient)
+      expect(yield* Effect.flip(store.remove("base"))).toMatchObject({ extendedBy: ["lenient"] })
+      yield* store.remove("lenient")
+      yield* store.remove("base")
+      expect(yield* store.getAll).toEqual({})
+    }))
+
+  it.effect("updates every profile at once, or none when one doesn't resolve", () =>
+    Effect.gen(function*() {
+      const store = yield* makeProfiles()
+      const [base, lenient] = [profiles.base!, profiles.lenient!]
+      // Order doesn't matter when they arrive together
+      yield* store.update(() => ({ lenient, base }))
+      expect(Object.keys(yield* store.getAll)).toEqual(["lenient", "base"])
+      const failed = yield* Effect.flip(store.update(({ base: _, ...rest }) => rest))
+      expect(failed).toMatchObject({ _tag: "ProfileNotFoundError", name: "base" })
+      expect(Object.keys(yield* store.getAll)).toEqual(["lenient", "base"])
+    }))
+})
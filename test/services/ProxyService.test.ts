@@ -1,9 +1,11 @@
 import { Effect, Layer, ManagedRuntime } from "effect"
 import type { ProxyConfigDomain } from "imposters/domain/imposter"
 import type { RequestContext } from "imposters/matching/RequestMatcher"
+import type { OutboundExchange } from "imposters/schemas/RequestLogSchema"
 import { ProxyService, ProxyServiceLive } from "imposters/services/ProxyService"
 import { UuidLive } from "imposters/services/UuidLive"
 import * as http from "node:http"
+import * as net from "node:net"
 import { afterAll, beforeAll, describe, expect, it } from "vitest"
 
 // Test HTTP server
@@ -25,7 +27,8 @@ beforeAll(async () => {
         path: url.pathname,
         query: Object.fromEntries(url.searchParams),
         receivedHeaders: req.headers,
-        receivedBody: body || undefined
+        receivedBody: body || undefined,
+        remoteAddress: req.socket.remoteAddress
       }
 
       if (url.pathname === "/slow") {
@@ -118,6 +121,30 @@ describe("ProxyService", () => {
       )
     })
 
+    it("journals the forwarded request and the upstream status or error", async () => {
+      await runtime.runPromise(
+        Effect.gen(function*() {
+          const proxy = yield* ProxyService
+          const journaled: Array<OutboundExchange> = []
+          const journal = (exchange: OutboundExchange) => Effect.sync(() => void journaled.push(exchange))
+          const ctx = makeCtx({ method: "POST", body: { name: "test" } })
+          yield* proxy.forward(ctx, makeConfig(), new URL("http://localhost:3000/api/create"), journal)
+          const unreachable = makeConfig({ targetUrl: "http://localhost:1" })
+          yield* proxy.forward(ctx, unreachable, new URL("http://x/a"), journal).pipe(Effect.ignore)
+          expect(journaled).toHaveLength(2)
+          expect(journaled[0]).toMatchObject({
+            kind: "proxy",
+            attempt: 1,
+            status: 200,
+            request: { method: "POST", url: `http://localhost:${testPort}/api/create`, body: "{\"name\":\"test\"}" }
+          })
+          expect(journaled[0]!.request.headers).not.toHaveProperty("host")
+          expect(journaled[1]!.status).toBeUndefined()
+          expect(journaled[1]!.error).toContain("Failed to reach target")
+        })
+      )
+    })
+
     it("strips hop-by-hop headers", async () => {
       await runtime.runPromise(
         Effect.gen(function*() {
@@ -205,6 +232,84 @@ describe("ProxyService", () => {
         })
       )
     })
+
+    it("rewrites the path before forwarding", async () => {
+      await runtime.runPromise(
+        Effect.gen(function*() {
+          const proxy = yield* ProxyService
+          const ctx = makeCtx({ path: "/v2/users/42" })
+          const config = makeConfig({ pathRewrite: { pattern: "^/v2/(.*)$", replacement: "/api/$1" } })
+          const url = new URL("http://localhost:3000/v2/users/42?key=value")
+          const response = yield* proxy.forward(ctx, config, url)
+          const body = yield* Effect.promise(() => response.json())
+          expect(body.path).toBe("/api/users/42")
+          expect(body.query).toEqual({ key: "value" })
+        })
+      )
+    })
+
+    it("replaces the body with the templated config body", async () => {
+      await runtime.runPromise(
+        Effect.gen(function*() {
+          const proxy = yield* ProxyService
+          const ctx = makeCtx({ method: "POST", body: { name: "alice" } })
+          const config = makeConfig({ body: { user: "${request.body.name}", source: "imposters" } })
+          const url = new URL("http://localhost:3000/api/create")
+          const response = yield* proxy.forward(ctx, config, url)
+          const body = yield* Effect.promise(() => response.json())
+          expect(JSON.parse(body.receivedBody)).toEqual({ user: "alice", source: "imposters" })
+        })
+      )
+    })
+    it("binds the configured local address", async () => {
+      await runtime.runPromise(
+        Effect.gen(function*() {
+          const proxy = yield* ProxyService
+          const config = makeConfig({
+            targetUrl: `http://127.0.0.1:${testPort}`,
+            outbound: { localAddress: "127.0.0.1" }
+          })
+          const response = yield* proxy.forward(makeCtx(), config, new URL("http://localhost:3000/api/test"))
+          const body = yield* Effect.promise(() => response.json())
+          expect(body.remoteAddress).toMatch(/127\.0\.0\.1$/)
+        })
+      )
+    })
+
+    it("tunnels through the configured HTTP proxy", async () => {
+      const tunnels: Array<string> = []
+      const forwardProxy = http.createServer()
+      forwardProxy.on("connect", (req: http.IncomingMessage, client: net.Socket, head: Buffer) => {
+        tunnels.push(req.url ?? "")
+        const [host, port] = (req.url ?? "").split(":")
+        const upstream = net.connect(Number(port), host, () => {
+          client.write("HTTP/1.1 200 Connection Established\r\n\r\n")
+          upstream.write(head)
+          upstream.pipe(client).pipe(upstream)
+        })
+        upstream.on("error", () => client.destroy())
+      })
+      await new Promise<void>((resolve) => forwardProxy.listen(0, "127.0.0.1", resolve))
+      const proxyPort = (forwardProxy.address() as { port: number }).port
+      try {
+        await runtime.runPromise(
+          Effect.gen(function*() {
+            const proxy = yield* ProxyService
+            const config = makeConfig({
+              targetUrl: `http://127.0.0.1:${testPort}`,
+              outbound: { proxy: `http://127.0.0.1:${proxyPort}` }
+            })
+            const response = yield* proxy.forward(makeCtx(), config, new URL("http://localhost:3000/api/test"))
+            expect(response.status).toBe(200)
+            const body = yield* Effect.promise(() => response.json())
+            expect(body.path).toBe("/api/test")
+          })
+        )
+        expect(tunnels).toEqual([`127.0.0.1:${testPort}`])
+      } finally {
+        forwardProxy.close()
+      }
+    })
   })
 
   describe("recordAsStub", () => {
@@ -238,5 +343,29 @@ describe("ProxyService", () => {
         })
       )
     })
+
+    it("scrubs configured fields before storing", async () => {
+      await runtime.runPromise(
+        Effect.gen(function*() {
+          const proxy = yield* ProxyService
+          const ctx = makeCtx({ method: "GET", path: "/api/me" })
+          const response = new Response(
+            JSON.stringify({ email: "ann@example.com", token: "secret-token", name: "Ann" }),
+            { status: 200, headers: { "content-type": "application/json", "set-cookie": "sid=1" } }
+          )
+          const config = makeConfig({
+            mode: "record",
+            scrub: [
+              { path: "token", replacement: "[REDACTED]" },
+              { preset: "email", replacement: "user@example.com" },
+              { header: "set-cookie", replacement: "[REDACTED]" }
+            ]
+          })
+          const stub = yield* proxy.recordAsStub(ctx, response, config)
+          expect(stub.responses[0]!.body).toEqual({ email: "user@example.com", token: "[REDACTED]", name: "Ann" })
+          expect(stub.responses[0]!.headers!["set-cookie"]).toBe("[REDACTED]")
+        })
+      )
+    })
   })
 })
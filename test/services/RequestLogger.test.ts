@@ -1,7 +1,7 @@
 import { Effect, ManagedRuntime, Queue } from "effect"
 import * as DateTime from "effect/DateTime"
 import { NonEmptyString } from "imposters/schemas/common"
-import type { RequestLogEntry } from "imposters/schemas/RequestLogSchema"
+import type { OutboundLogEntry, RequestLogEntry } from "imposters/schemas/RequestLogSchema"
 import { RequestLogger, RequestLoggerLive } from "imposters/services/RequestLogger"
 import { afterAll, describe, expect, it } from "vitest"
 
@@ -40,6 +40,18 @@ const makeEntry = (overrides: {
   duration: overrides.duration ?? 5
 })
 
+const makeOutbound = (id: string, imposterId: string, triggeredBy: string): OutboundLogEntry => ({
+  id: NonEmptyString.make(id),
+  imposterId: NonEmptyString.make(imposterId),
+  triggeredBy: NonEmptyString.make(triggeredBy),
+  kind: "callback",
+  timestamp: DateTime.unsafeNow(),
+  request: { method: "POST", url: "http://localhost/hook", headers: {} },
+  status: 204,
+  attempt: 1,
+  duration: 3
+})
+
 describe("RequestLogger", () => {
   it("log + getEntries returns logged entry", async () => {
     await runtime.runPromise(
@@ -74,6 +86,39 @@ describe("RequestLogger", () => {
     )
   })
 
+  it("getEntries pages backwards with offset", async () => {
+    await runtime.runPromise(
+      Effect.gen(function*() {
+        const logger = yield* RequestLogger
+        const impId = "i-offset"
+        for (let i = 0; i < 5; i++) {
+          yield* logger.log(makeEntry({ id: `o-${i}`, imposterId: impId }))
+        }
+        const page = yield* logger.getEntries(impId, { limit: 2, offset: 2 })
+        expect(page.map((e) => e.id)).toEqual(["o-1", "o-2"])
+        const beyond = yield* logger.getEntries(impId, { limit: 2, offset: 5 })
+        expect(beyond).toEqual([])
+      })
+    )
+  })
+
+  it("getEntries filters by whether a stub matched before applying the limit", async () => {
+    await runtime.runPromise(
+      Effect.gen(function*() {
+        const logger = yield* RequestLogger
+        const impId = "i-matched"
+        yield* logger.log(makeEntry({ id: "m-miss", imposterId: impId }))
+        for (let i = 0; i < 3; i++) {
+          yield* logger.log(makeEntry({ id: `m-${i}`, imposterId: impId, matchedStubId: "stub-1" }))
+        }
+        const unmatched = yield* logger.getEntries(impId, { limit: 2, matched: false })
+        expect(unmatched.map((e) => e.id)).toEqual(["m-miss"])
+        const matched = yield* logger.getEntries(impId, { matched: true })
+        expect(matched.map((e) => e.id)).toEqual(["m-0", "m-1", "m-2"])
+      })
+    )
+  })
+
   it("getEntries filters by method", async () => {
     await runtime.runPromise(
       Effect.gen(function*() {
@@ -217,4 +262,20 @@ describe("RequestLogger", () => {
       })
     )
   })
+
+  it("keeps outbound entries by triggering request and clears them with the journal", async () => {
+    await runtime.runPromise(
+      Effect.gen(function*() {
+        const logger = yield* RequestLogger
+        yield* logger.logOutbound(makeOutbound("o1", "i-out", "r1"))
+        yield* logger.logOutbound(makeOutbound("o2", "i-out", "r2"))
+        yield* logger.logOutbound(makeOutbound("o3", "i-out", "r1"))
+        expect((yield* logger.getOutbound("i-out")).map((e) => e.id)).toEqual(["o1", "o2", "o3"])
+        expect((yield* logger.getOutbound("i-out", { triggeredBy: "r1" })).map((e) => e.id)).toEqual(["o1", "o3"])
+        expect((yield* logger.getOutbound("i-out", { limit: 1 })).map((e) => e.id)).toEqual(["o3"])
+        yield* logger.clear("i-out")
+        expect(yield* logger.getOutbound("i-out")).toEqual([])
+      })
+    )
+  })
 })
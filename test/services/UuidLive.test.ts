@@ -1,9 +1,14 @@
 import { it } from "@effect/vitest"
+import * as ConfigProvider from "effect/ConfigProvider"
 import * as Effect from "effect/Effect"
+import * as Layer from "effect/Layer"
 import { Uuid } from "imposters/services/Uuid"
-import { UuidLive } from "imposters/services/UuidLive"
+import { randomString, ulid, UuidLive } from "imposters/services/UuidLive"
 import { describe, expect } from "vitest"
 
+const withEnv = (env: Record<string, string>) =>
+  Layer.setConfigProvider(ConfigProvider.fromMap(new Map(Object.entries(env))))
+
 describe("UuidLive", () => {
   it.effect("generate returns valid UUID v4 format", () =>
     Effect.gen(function*() {
@@ -27,4 +32,49 @@ describe("UuidLive", () => {
       const id2 = yield* uuid.generate
       expect(id1).not.toBe(id2)
     }).pipe(Effect.provide(UuidLive)))
+
+  it.effect("ID_FORMAT, ID_LENGTH and ID_ALPHABET shape generateShort", () =>
+    Effect.gen(function*() {
+      const short = yield* Effect.flatMap(Uuid, (uuid) => uuid.generateShort).pipe(
+        Effect.provide(UuidLive),
+        Effect.provide(withEnv({ ID_LENGTH: "12", ID_ALPHABET: "xyz" }))
+      )
+      expect(short).toMatch(/^[xyz]{12}$/)
+      const full = yield* Effect.flatMap(Uuid, (uuid) => uuid.generateShort).pipe(
+        Effect.provide(UuidLive),
+        Effect.provide(withEnv({ ID_FORMAT: "uuid" }))
+      )
+      expect(full).toMatch(/^[0-9a-f]{8}-[0-9a-f]{4}-4/)
+      const sortable = yield* Effect.flatMap(Uuid, (uuid) => uuid.generateShort).pipe(
+        Effect.provide(UuidLive),
+        Effect.provide(withEnv({ ID_FORMAT: "ulid" }))
+      )
+      expect(sortable).toMatch(/^[0-9A-HJKMNP-TV-Z]{26}$/)
+    }))
+
+  it.effect("rejects alphabets with characters that don't belong in URLs", () =>
+    Effect.gen(function*() {
+      const error = yield* Effect.flip(
+        Effect.provide(Uuid, UuidLive).pipe(Effect.provide(withEnv({ ID_ALPHABET: "ab/" })))
+      )
+      expect(error._tag).toBe("ConfigError")
+    }))
+})
+
+describe("randomString", () => {
+  it.effect("draws only from the alphabet", () =>
+    Effect.sync(() => {
+      const s = randomString("abc", 300)
+      expect(s).toHaveLength(300)
+      expect(new Set(s)).toEqual(new Set(["a", "b", "c"]))
+    }))
+})
+
+describe("ulid", () => {
+  it.effect("encodes the timestamp first, so later IDs sort after earlier ones", () =>
+    Effect.sync(() => {
+      expect(ulid(0).slice(0, 10)).toBe("0000000000")
+      expect(ulid(1469918176385).slice(0, 10)).toBe("01ARYZ6S41")
+      expect(ulid(2000) > ulid(1000)).toBe(true)
+    }))
 })
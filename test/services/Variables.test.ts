@@ -0,0 +1,48 @@
+import { it } from "@effect/vitest"
+import * as ConfigProvider from "effect/ConfigProvider"
+import * as Effect from "effect/Effect"
+import * as Layer from "effect/Layer"
+import * as Queue from "effect/Queue"
+import { Variables, VariablesLive } from "imposters/services/Variables"
+import { describe, expect } from "vitest"
+
+const withEnv = (env: Record<string, string>) =>
+  Layer.setConfigProvider(ConfigProvider.fromMap(new Map(Object.entries(env))))
+
+describe("Variables", () => {
+  it.effect("starts from the VARIABLES environment variable", () =>
+    Effect.gen(function*() {
+      const variables = yield* Variables
+      expect(yield* variables.get).toEqual({ tenant: "acme", beta: true })
+    }).pipe(
+      Effect.provide(VariablesLive),
+      Effect.provide(withEnv({ VARIABLES: "{\"tenant\":\"acme\",\"beta\":true}" }))
+    ))
+
+  it.effect("rejects a VARIABLES value that is not a JSON object", () =>
+    Effect.gen(function*() {
+      const error = yield* Effect.flip(Effect.provide(Variables, VariablesLive))
+      expect(String(error)).toContain("VARIABLES must be a JSON object")
+    }).pipe(Effect.provide(withEnv({ VARIABLES: "[1, 2]" }))))
+
+  it.effect("merge keeps other variables and removes nulls; replace drops them", () =>
+    Effect.gen(function*() {
+      const variables = yield* Variables
+      yield* variables.replace({ tenant: "acme", baseUrl: "http://localhost:4000" })
+      expect(yield* variables.merge({ tenant: "globex", baseUrl: null, region: "eu" }))
+        .toEqual({ tenant: "globex", region: "eu" })
+      expect(yield* variables.replace({ flag: false })).toEqual({ flag: false })
+    }).pipe(Effect.provide(VariablesLive)))
+
+  it.effect("announces the variables after every change to subscribers", () =>
+    Effect.scoped(
+      Effect.gen(function*() {
+        const variables = yield* Variables
+        const changes = yield* variables.subscribe
+        yield* variables.merge({ tenant: "acme" })
+        yield* variables.update((current) => ({ ...current, region: "eu" }))
+        expect(Array.from(yield* Queue.takeAll(changes)))
+          .toEqual([{ tenant: "acme" }, { tenant: "acme", region: "eu" }])
+      })
+    ).pipe(Effect.provide(VariablesLive)))
+})